@@ -0,0 +1,309 @@
+package bitbucket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// APIURL is the Bitbucket Cloud REST API the client talks to
+var APIURL = "https://api.bitbucket.org/2.0"
+
+// Variable scopes the key can be stored in
+const (
+	// ScopeRepository stores the key as a repository variable
+	ScopeRepository = "repository"
+	// ScopeWorkspace stores the key as a workspace variable shared by every repository in the workspace
+	ScopeWorkspace = "workspace"
+)
+
+// Environment variables the credentials are read from
+const (
+	// TokenEnv holds a repository, workspace or OAuth access token
+	TokenEnv = "BITBUCKET_TOKEN"
+	// UsernameEnv and AppPasswordEnv hold a username and app password used when no token is set
+	UsernameEnv    = "BITBUCKET_USERNAME"
+	AppPasswordEnv = "BITBUCKET_APP_PASSWORD"
+)
+
+// ErrNotFound is returned when the API reports that a resource does not exist
+var ErrNotFound = errors.New("not found")
+
+// Client calls the Bitbucket Cloud API with the credentials from the environment
+type Client struct {
+	http     *http.Client
+	token    string
+	username string
+	password string
+}
+
+// CredentialSource describes which credentials NewClient uses, for messages
+func CredentialSource() (string, error) {
+	if os.Getenv(TokenEnv) != "" {
+		return "access token (from " + TokenEnv + ")", nil
+	}
+	if os.Getenv(UsernameEnv) != "" && os.Getenv(AppPasswordEnv) != "" {
+		return "app password of " + os.Getenv(UsernameEnv) + " (from " + AppPasswordEnv + ")", nil
+	}
+	return "", fmt.Errorf("no Bitbucket credentials found: set %s, or %s and %s", TokenEnv, UsernameEnv, AppPasswordEnv)
+}
+
+// NewClient returns a client authenticated with BITBUCKET_TOKEN, or with BITBUCKET_USERNAME and
+// BITBUCKET_APP_PASSWORD
+func NewClient() (*Client, error) {
+	c := &Client{http: http.DefaultClient}
+	if c.token = os.Getenv(TokenEnv); c.token != "" {
+		return c, nil
+	}
+	c.username, c.password = os.Getenv(UsernameEnv), os.Getenv(AppPasswordEnv)
+	if c.username == "" || c.password == "" {
+		return nil, fmt.Errorf("no Bitbucket credentials found: set %s, or %s and %s", TokenEnv, UsernameEnv, AppPasswordEnv)
+	}
+	return c, nil
+}
+
+// IsRemoteURL reports whether a git remote URL points at Bitbucket Cloud
+func IsRemoteURL(remoteURL string) bool {
+	_, _, err := ParseRemoteURL(remoteURL)
+	return err == nil
+}
+
+// ParseRemoteURL returns the workspace and repository slug of a bitbucket.org remote URL
+// Format: git@bitbucket.org:workspace/repo.git, ssh://git@bitbucket.org/workspace/repo.git or
+// https://[user@]bitbucket.org/workspace/repo.git
+func ParseRemoteURL(remoteURL string) (string, string, error) {
+	var path string
+	if rest, ok := strings.CutPrefix(remoteURL, "git@bitbucket.org:"); ok {
+		path = rest
+	} else if parsed, err := url.Parse(remoteURL); err == nil && parsed.Hostname() == "bitbucket.org" &&
+		(parsed.Scheme == "https" || parsed.Scheme == "ssh") {
+		path = strings.TrimPrefix(parsed.Path, "/")
+	} else {
+		return "", "", fmt.Errorf("unsupported remote URL format: %s", remoteURL)
+	}
+
+	parts := strings.Split(strings.TrimSuffix(path, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid remote URL format: %s", remoteURL)
+	}
+	return parts[0], strings.TrimSuffix(parts[1], ".git"), nil
+}
+
+// User is a Bitbucket account
+type User struct {
+	Nickname    string `json:"nickname"`
+	DisplayName string `json:"display_name"`
+	AccountID   string `json:"account_id"`
+	UUID        string `json:"uuid"`
+}
+
+// Member is a workspace member and their permission (owner, collaborator or member)
+type Member struct {
+	User       User   `json:"user"`
+	Permission string `json:"permission"`
+}
+
+// Variable is a Pipelines variable; the value of secured variables is never returned
+type Variable struct {
+	UUID    string `json:"uuid,omitempty"`
+	Key     string `json:"key"`
+	Value   string `json:"value,omitempty"`
+	Secured bool   `json:"secured"`
+}
+
+// SSHKey is a public SSH key registered on an account
+type SSHKey struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+}
+
+// page is one page of a paginated API response
+type page[T any] struct {
+	Values []T    `json:"values"`
+	Next   string `json:"next"`
+}
+
+// CurrentUser returns the authenticated account
+func (c *Client) CurrentUser(ctx context.Context) (*User, error) {
+	var user User
+	if err := c.do(ctx, http.MethodGet, APIURL+"/user", nil, &user); err != nil {
+		return nil, fmt.Errorf("failed to get current user: %w", err)
+	}
+	return &user, nil
+}
+
+// WorkspaceMembers returns every member of a workspace with their permission
+func (c *Client) WorkspaceMembers(ctx context.Context, workspace string) ([]Member, error) {
+	members, err := list[Member](ctx, c, fmt.Sprintf("%s/workspaces/%s/permissions?pagelen=100", APIURL, url.PathEscape(workspace)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspace members: %w", err)
+	}
+	return members, nil
+}
+
+// UserSSHKeys returns the public SSH keys of an account, given as its UUID or account id
+func (c *Client) UserSSHKeys(ctx context.Context, user string) ([]SSHKey, error) {
+	keys, err := list[SSHKey](ctx, c, fmt.Sprintf("%s/users/%s/ssh-keys?pagelen=100", APIURL, url.PathEscape(user)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SSH keys for %s: %w", user, err)
+	}
+	return keys, nil
+}
+
+// variablesURL returns the collection URL of the variables in scope
+func variablesURL(scope, workspace, repo string) (string, error) {
+	switch scope {
+	case ScopeRepository:
+		return fmt.Sprintf("%s/repositories/%s/%s/pipelines_config/variables", APIURL, url.PathEscape(workspace), url.PathEscape(repo)), nil
+	case ScopeWorkspace:
+		return fmt.Sprintf("%s/workspaces/%s/pipelines-config/variables", APIURL, url.PathEscape(workspace)), nil
+	}
+	return "", fmt.Errorf("unknown variable scope %q (expected %s or %s)", scope, ScopeRepository, ScopeWorkspace)
+}
+
+// FindVariable returns the variable named key in scope, or ErrNotFound
+func (c *Client) FindVariable(ctx context.Context, scope, workspace, repo, key string) (*Variable, error) {
+	collection, err := variablesURL(scope, workspace, repo)
+	if err != nil {
+		return nil, err
+	}
+	variables, err := list[Variable](ctx, c, collection+"?pagelen=100")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s variables: %w", scope, err)
+	}
+	for _, variable := range variables {
+		if variable.Key == key {
+			return &variable, nil
+		}
+	}
+	return nil, fmt.Errorf("%s variable %s: %w", scope, key, ErrNotFound)
+}
+
+// SetVariable creates or replaces the secured variable named key in scope
+func (c *Client) SetVariable(ctx context.Context, scope, workspace, repo, key, value string) error {
+	collection, err := variablesURL(scope, workspace, repo)
+	if err != nil {
+		return err
+	}
+	variable := Variable{Key: key, Value: value, Secured: true}
+
+	existing, err := c.FindVariable(ctx, scope, workspace, repo, key)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		err = c.do(ctx, http.MethodPost, collection, variable, nil)
+	case err == nil:
+		err = c.do(ctx, http.MethodPut, collection+"/"+url.PathEscape(existing.UUID), variable, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set %s variable %s: %w", scope, key, err)
+	}
+	return nil
+}
+
+// DeleteVariable deletes the variable named key in scope
+func (c *Client) DeleteVariable(ctx context.Context, scope, workspace, repo, key string) error {
+	collection, err := variablesURL(scope, workspace, repo)
+	if err != nil {
+		return err
+	}
+	existing, err := c.FindVariable(ctx, scope, workspace, repo, key)
+	if err != nil {
+		return err
+	}
+	if err := c.do(ctx, http.MethodDelete, collection+"/"+url.PathEscape(existing.UUID), nil, nil); err != nil {
+		return fmt.Errorf("failed to delete %s variable %s: %w", scope, key, err)
+	}
+	return nil
+}
+
+// list follows the pagination of a collection and returns every value
+// The credentials are sent with every page, so a next link to another host is not followed
+func list[T any](ctx context.Context, c *Client, next string) ([]T, error) {
+	first, err := url.Parse(next)
+	if err != nil {
+		return nil, err
+	}
+	var values []T
+	for next != "" {
+		var p page[T]
+		if err := c.do(ctx, http.MethodGet, next, nil, &p); err != nil {
+			return nil, err
+		}
+		values = append(values, p.Values...)
+		next = p.Next
+		if next == "" {
+			break
+		}
+		if nextURL, err := url.Parse(next); err != nil || nextURL.Scheme != first.Scheme || nextURL.Host != first.Host {
+			return nil, fmt.Errorf("refusing to follow the next page link %q to another host than %s", next, first.Host)
+		}
+	}
+	return values, nil
+}
+
+// do sends a request with a JSON body and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, endpoint string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	} else {
+		request.SetBasicAuth(c.username, c.password)
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return apiError(response)
+	}
+	if out == nil || response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// apiError turns an error response into an error with the API's message when it has one
+func apiError(response *http.Response) error {
+	var payload struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(response.Body, 64<<10))
+	if json.Unmarshal(data, &payload) == nil && payload.Error.Message != "" {
+		return fmt.Errorf("%s: %s", response.Status, payload.Error.Message)
+	}
+	return errors.New(response.Status)
+}
@@ -0,0 +1,181 @@
+package bitbucket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRemoteURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		workspace string
+		repo      string
+		expectErr bool
+	}{
+		{name: "scp-like SSH", url: "git@bitbucket.org:acme/api.git", workspace: "acme", repo: "api"},
+		{name: "SSH URL", url: "ssh://git@bitbucket.org/acme/api.git", workspace: "acme", repo: "api"},
+		{name: "HTTPS", url: "https://bitbucket.org/acme/api.git", workspace: "acme", repo: "api"},
+		{name: "HTTPS with user", url: "https://jane@bitbucket.org/acme/api.git", workspace: "acme", repo: "api"},
+		{name: "HTTPS without .git", url: "https://bitbucket.org/acme/api", workspace: "acme", repo: "api"},
+		{name: "GitHub", url: "git@github.com:acme/api.git", expectErr: true},
+		{name: "other host", url: "https://bitbucket.example.com/acme/api.git", expectErr: true},
+		{name: "missing repository", url: "git@bitbucket.org:acme", expectErr: true},
+		{name: "nested path", url: "https://bitbucket.org/acme/api/extra", expectErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workspace, repo, err := ParseRemoteURL(tt.url)
+			assert.Equal(t, !tt.expectErr, IsRemoteURL(tt.url))
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.workspace, workspace)
+			assert.Equal(t, tt.repo, repo)
+		})
+	}
+}
+
+// fakeAPI serves the variables and members endpoints from memory
+func fakeAPI(t *testing.T) (*httptest.Server, map[string]Variable) {
+	variables := map[string]Variable{}
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+
+	mux.HandleFunc("GET /repositories/acme/api/pipelines_config/variables", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer test-token", r.Header.Get("Authorization"))
+		var values []Variable
+		for _, v := range variables {
+			values = append(values, Variable{UUID: v.UUID, Key: v.Key, Secured: v.Secured})
+		}
+		json.NewEncoder(w).Encode(page[Variable]{Values: values})
+	})
+	mux.HandleFunc("POST /repositories/acme/api/pipelines_config/variables", func(w http.ResponseWriter, r *http.Request) {
+		var v Variable
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&v))
+		v.UUID = "{" + v.Key + "}"
+		variables[v.UUID] = v
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(v)
+	})
+	mux.HandleFunc("PUT /repositories/acme/api/pipelines_config/variables/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		var v Variable
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&v))
+		v.UUID = r.PathValue("uuid")
+		variables[v.UUID] = v
+		json.NewEncoder(w).Encode(v)
+	})
+	mux.HandleFunc("DELETE /repositories/acme/api/pipelines_config/variables/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		delete(variables, r.PathValue("uuid"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /workspaces/acme/permissions", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			json.NewEncoder(w).Encode(page[Member]{Values: []Member{{User: User{Nickname: "bob", UUID: "{b}"}, Permission: "member"}}})
+			return
+		}
+		json.NewEncoder(w).Encode(page[Member]{
+			Values: []Member{{User: User{Nickname: "alice", UUID: "{a}"}, Permission: "owner"}},
+			Next:   server.URL + "/workspaces/acme/permissions?page=2",
+		})
+	})
+
+	original := APIURL
+	APIURL = server.URL
+	t.Cleanup(func() {
+		APIURL = original
+		server.Close()
+	})
+	return server, variables
+}
+
+func TestVariables(t *testing.T) {
+	_, variables := fakeAPI(t)
+	t.Setenv(TokenEnv, "test-token")
+	client, err := NewClient()
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = client.FindVariable(ctx, ScopeRepository, "acme", "api", "EZENV_ENCRYPTION_KEY")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, client.SetVariable(ctx, ScopeRepository, "acme", "api", "EZENV_ENCRYPTION_KEY", "first"))
+	require.NoError(t, client.SetVariable(ctx, ScopeRepository, "acme", "api", "EZENV_ENCRYPTION_KEY", "second"))
+	require.Len(t, variables, 1, "setting an existing variable replaces it")
+	for _, v := range variables {
+		assert.Equal(t, "second", v.Value)
+		assert.True(t, v.Secured)
+	}
+
+	found, err := client.FindVariable(ctx, ScopeRepository, "acme", "api", "EZENV_ENCRYPTION_KEY")
+	require.NoError(t, err)
+	assert.Empty(t, found.Value, "secured values are never returned")
+
+	require.NoError(t, client.DeleteVariable(ctx, ScopeRepository, "acme", "api", "EZENV_ENCRYPTION_KEY"))
+	assert.Empty(t, variables)
+
+	_, err = client.FindVariable(ctx, "project", "acme", "api", "EZENV_ENCRYPTION_KEY")
+	assert.ErrorContains(t, err, "unknown variable scope")
+}
+
+func TestWorkspaceMembers(t *testing.T) {
+	fakeAPI(t)
+	t.Setenv(TokenEnv, "test-token")
+	client, err := NewClient()
+	require.NoError(t, err)
+
+	members, err := client.WorkspaceMembers(context.Background(), "acme")
+	require.NoError(t, err)
+	require.Len(t, members, 2, "every page is read")
+	assert.Equal(t, "alice", members[0].User.Nickname)
+	assert.Equal(t, "owner", members[0].Permission)
+	assert.Equal(t, "{b}", members[1].User.UUID)
+}
+
+func TestListRefusesOtherHosts(t *testing.T) {
+	var leaked bool
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = r.Header.Get("Authorization") != ""
+		json.NewEncoder(w).Encode(page[Member]{})
+	}))
+	defer other.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(page[Member]{
+			Values: []Member{{User: User{Nickname: "alice"}, Permission: "owner"}},
+			Next:   other.URL + "/workspaces/acme/permissions?page=2",
+		})
+	}))
+	defer server.Close()
+	original := APIURL
+	APIURL = server.URL
+	t.Cleanup(func() { APIURL = original })
+
+	t.Setenv(TokenEnv, "test-token")
+	client, err := NewClient()
+	require.NoError(t, err)
+	_, err = client.WorkspaceMembers(context.Background(), "acme")
+	assert.ErrorContains(t, err, "another host")
+	assert.False(t, leaked, "the token is not sent to the other host")
+}
+
+func TestNewClientCredentials(t *testing.T) {
+	t.Setenv(TokenEnv, "")
+	t.Setenv(UsernameEnv, "")
+	t.Setenv(AppPasswordEnv, "")
+	_, err := NewClient()
+	assert.ErrorContains(t, err, "no Bitbucket credentials found")
+
+	t.Setenv(UsernameEnv, "jane")
+	t.Setenv(AppPasswordEnv, "app-password")
+	client, err := NewClient()
+	require.NoError(t, err)
+	assert.Equal(t, "jane", client.username)
+}
@@ -0,0 +1,142 @@
+package canary
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/canonical"
+)
+
+const (
+	// RegistryFile is the committed file listing the hashes of planted canary values
+	RegistryFile = ".ezenv-canaries"
+
+	// valueLength is the length of generated canary values
+	valueLength = 40
+	alphabet    = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// Canary is a registered decoy secret
+// Only the hash of the value is stored so the registry itself never leaks it
+type Canary struct {
+	Name      string    `json:"name"`
+	File      string    `json:"file"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Registry is the list of canaries planted in a repository
+type Registry struct {
+	Version  int      `json:"version"`
+	Canaries []Canary `json:"canaries"`
+}
+
+// Generate returns a random value that looks like an API token
+func Generate() (string, error) {
+	value := make([]byte, valueLength)
+	max := big.NewInt(int64(len(alphabet)))
+	for i := range value {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate canary value: %w", err)
+		}
+		value[i] = alphabet[n.Int64()]
+	}
+	return string(value), nil
+}
+
+// Load reads the registry from path, returning an empty registry if it does not exist
+func Load(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Registry{Version: 1}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read canary registry: %w", err)
+	}
+
+	var registry Registry
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return nil, fmt.Errorf("failed to parse canary registry: %w", err)
+	}
+	return &registry, nil
+}
+
+// Save writes the registry to path as canonical JSON
+func (r *Registry) Save(path string) error {
+	if err := canonical.WriteFile(path, r, 0644); err != nil {
+		return fmt.Errorf("failed to save canary registry: %w", err)
+	}
+	return nil
+}
+
+// Add registers a planted canary value
+func (r *Registry) Add(name, file, value string) Canary {
+	c := Canary{
+		Name:      name,
+		File:      file,
+		Hash:      hash(value),
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	r.Canaries = append(r.Canaries, c)
+	return c
+}
+
+// Scan returns every registered canary whose value appears in data
+func (r *Registry) Scan(data []byte) []Canary {
+	if len(r.Canaries) == 0 {
+		return nil
+	}
+
+	byHash := make(map[string]Canary)
+	for _, c := range r.Canaries {
+		byHash[c.Hash] = c
+	}
+
+	var found []Canary
+	seen := make(map[string]bool)
+	for _, token := range tokens(data) {
+		h := hash(token)
+		if c, ok := byHash[h]; ok && !seen[h] {
+			seen[h] = true
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// tokens splits data into candidate tokens of exactly the canary value length
+func tokens(data []byte) []string {
+	var result []string
+	start := -1
+	for i := 0; i <= len(data); i++ {
+		if i < len(data) && isTokenByte(data[i]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && i-start == valueLength {
+			result = append(result, string(data[start:i]))
+		}
+		start = -1
+	}
+	return result
+}
+
+// isTokenByte reports whether b can be part of a canary value
+func isTokenByte(b byte) bool {
+	return b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= '0' && b <= '9'
+}
+
+// hash returns the hex SHA-256 of a canary value
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
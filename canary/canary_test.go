@@ -0,0 +1,77 @@
+package canary
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	first, err := Generate()
+	require.NoError(t, err)
+	second, err := Generate()
+	require.NoError(t, err)
+
+	assert.Len(t, first, valueLength)
+	assert.NotEqual(t, first, second, "canary values should be unique")
+	assert.Len(t, tokens([]byte(first)), 1, "generated values should be detectable as a single token")
+}
+
+func TestScan(t *testing.T) {
+	value, err := Generate()
+	require.NoError(t, err)
+
+	registry := &Registry{Version: 1}
+	registry.Add("LEGACY_API_TOKEN", ".env", value)
+
+	tests := []struct {
+		name  string
+		data  string
+		found bool
+	}{
+		{"finds value in dotenv line", "LEGACY_API_TOKEN=" + value + "\n", true},
+		{"finds value in log output", "request failed: token=" + value + ", retrying", true},
+		{"finds value in JSON", `{"token":"` + value + `"}`, true},
+		{"ignores unrelated content", "nothing to see here", false},
+		{"ignores value embedded in a longer token", "x" + value, false},
+		{"ignores partial value", value[:20], false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found := registry.Scan([]byte(tt.data))
+			if tt.found {
+				require.Len(t, found, 1)
+				assert.Equal(t, "LEGACY_API_TOKEN", found[0].Name)
+			} else {
+				assert.Empty(t, found)
+			}
+		})
+	}
+}
+
+func TestRegistryRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), RegistryFile)
+
+	registry, err := Load(path)
+	require.NoError(t, err)
+	assert.Empty(t, registry.Canaries)
+
+	value, err := Generate()
+	require.NoError(t, err)
+	registry.Add("TOKEN", ".env", value)
+	require.NoError(t, registry.Save(path))
+
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, loaded.Canaries, 1)
+	assert.Equal(t, "TOKEN", loaded.Canaries[0].Name)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), value, "the registry must not contain the value")
+	assert.Len(t, loaded.Scan([]byte(value)), 1)
+}
@@ -0,0 +1,68 @@
+package canonical
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Marshal encodes v as canonical JSON: object keys sorted, two-space indentation,
+// no HTML escaping, numbers kept as written and a trailing newline
+// Encoding the same value twice always produces identical bytes, so re-saving
+// committed metadata without changes never produces a git diff
+func Marshal(v any) ([]byte, error) {
+	return marshal(v, "  ")
+}
+
+// MarshalLine encodes v as a single line of canonical JSON with a trailing newline,
+// for append-only JSON Lines files
+func MarshalLine(v any) ([]byte, error) {
+	return marshal(v, "")
+}
+
+// marshal encodes v with sorted object keys and the given indentation
+func marshal(v any, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+
+	// Decoding into generic values sorts object keys when encoding again
+	decoder := json.NewDecoder(&buf)
+	decoder.UseNumber()
+	var generic any
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	encoder = json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if indent != "" {
+		encoder.SetIndent("", indent)
+	}
+	if err := encoder.Encode(generic); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// WriteFile writes v as canonical JSON to path
+// The file is left untouched when its content would not change
+func WriteFile(path string, v any, perm os.FileMode) error {
+	data, err := Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
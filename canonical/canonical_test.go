@@ -0,0 +1,92 @@
+package canonical
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshal(t *testing.T) {
+	type entry struct {
+		Zeta  string `json:"zeta"`
+		Alpha int    `json:"alpha"`
+	}
+
+	tests := []struct {
+		name     string
+		value    any
+		expected string
+	}{
+		{
+			name:     "struct fields are sorted",
+			value:    entry{Zeta: "z", Alpha: 1},
+			expected: "{\n  \"alpha\": 1,\n  \"zeta\": \"z\"\n}\n",
+		},
+		{
+			name:     "map keys are sorted",
+			value:    map[string]int{"b": 2, "a": 1, "c": 3},
+			expected: "{\n  \"a\": 1,\n  \"b\": 2,\n  \"c\": 3\n}\n",
+		},
+		{
+			name:     "nested objects are sorted",
+			value:    map[string]any{"outer": entry{Zeta: "z", Alpha: 2}, "list": []entry{{Zeta: "y"}}},
+			expected: "{\n  \"list\": [\n    {\n      \"alpha\": 0,\n      \"zeta\": \"y\"\n    }\n  ],\n  \"outer\": {\n    \"alpha\": 2,\n    \"zeta\": \"z\"\n  }\n}\n",
+		},
+		{
+			name:     "html is not escaped",
+			value:    map[string]string{"login": "<alice&bob>"},
+			expected: "{\n  \"login\": \"<alice&bob>\"\n}\n",
+		},
+		{
+			name:     "large numbers keep their precision",
+			value:    map[string]uint64{"n": 18446744073709551615},
+			expected: "{\n  \"n\": 18446744073709551615\n}\n",
+		},
+		{
+			name:     "times use UTC RFC 3339",
+			value:    map[string]time.Time{"at": time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+			expected: "{\n  \"at\": \"2024-01-02T03:04:05Z\"\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Marshal(tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(data))
+
+			again, err := Marshal(tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, data, again, "encoding must be reproducible")
+		})
+	}
+}
+
+func TestWriteFileSkipsUnchangedContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	value := map[string]int{"a": 1}
+
+	require.NoError(t, WriteFile(path, value, 0644))
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(path, old, old))
+
+	require.NoError(t, WriteFile(path, value, 0644))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(old), "unchanged content should not be rewritten")
+
+	require.NoError(t, WriteFile(path, map[string]int{"a": 2}, 0644))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "{\n  \"a\": 2\n}\n", string(data))
+}
+
+func TestMarshalLine(t *testing.T) {
+	data, err := MarshalLine(map[string]any{"b": []int{1, 2}, "a": "<x>"})
+	require.NoError(t, err)
+	assert.Equal(t, "{\"a\":\"<x>\",\"b\":[1,2]}\n", string(data))
+}
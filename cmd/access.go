@@ -0,0 +1,340 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/hosting"
+)
+
+const (
+	// Rotation policies applied when a collaborator is removed (git config ezenv.rotateOnRemoval)
+	rotateAlways = "always"
+	rotateAsk    = "ask"
+	rotateNever  = "never"
+
+	// defaultRestoreGracePeriod is how long a removed collaborator can be restored (git config ezenv.restoreGracePeriod)
+	defaultRestoreGracePeriod = 30 * 24 * time.Hour
+)
+
+// Grant adds a collaborator's SSH keys from GitHub to the keyring, wraps the key to them
+// and commits the updated keyring
+func Grant(args []string) error {
+	flags := flag.NewFlagSet("grant", flag.ContinueOnError)
+	noCommit := flags.Bool("no-commit", false, "stage the updated keyring without committing it")
+	recipient := flags.String("recipient", "", "wrap the key to this SSH public key or age recipient instead of the keys on GitHub")
+	refresh := flags.Bool("refresh", false, "download the keys again instead of revalidating the cached ones")
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	github.RefreshCache = *refresh
+	if len(positional) < 1 {
+		return fmt.Errorf("no collaborator specified")
+	}
+	login := positional[0]
+
+	if err := requireKeyringMode(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if *recipient == "" {
+		// The collaborator's keys are looked up on GitHub
+		if err := checkGitHubAccess(ctx, "repo"); err != nil {
+			return err
+		}
+	}
+	keyManager := crypto.NewKeyManager()
+	key, err := keyManager.GetEncryptionKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	defer key.Destroy()
+
+	keyring, err := loadTrustedKeyring()
+	if err != nil {
+		return err
+	}
+	if *recipient != "" {
+		if !keyring.CanWrapTo(*recipient) {
+			return fmt.Errorf("the %s keyring cannot wrap the key to %q", keyring.WrapBackend(), *recipient)
+		}
+		if _, err := keyring.AddEntry(login, *recipient); err != nil {
+			return err
+		}
+		keyring.MarkGranted(login, time.Now())
+	} else if _, err := addCollaboratorKeys(ctx, keyring, login); err != nil {
+		// Keys registered with keygen are already in the keyring and only need the key wrapped to them
+		if keyring.PendingEntries(login) == 0 {
+			return err
+		}
+		fmt.Printf("Note: %v; wrapping the key to the keys registered with keygen only\n", err)
+	}
+	pending := keyring.PendingEntries(login)
+	if pending == 0 {
+		return fmt.Errorf("%s has no new keys on GitHub that the %s keyring can wrap to", login, keyring.WrapBackend())
+	}
+	// A fresh grant supersedes an earlier removal
+	keyring.RemoveTombstone(login)
+
+	if _, err := keyring.WrapPendingDEKs(key.Bytes(), login); err != nil {
+		return err
+	}
+	if err := saveKeyring(keyring); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Granted %s access with %d key(s)\n", login, pending)
+
+	if *noCommit {
+		return nil
+	}
+	commitCmd := exec.Command("git", "commit", "-q", "-m", "Grant ez-env access to "+login, "--", crypto.KeyringFile)
+	if output, err := commitCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to commit %s: %w: %s", crypto.KeyringFile, err, output)
+	}
+	fmt.Println("✓ Keyring committed; push it so the collaborator can decrypt")
+	return nil
+}
+
+// Revoke removes a collaborator from the keyring, keeping a tombstone so access can be restored
+// Removing the entry alone revokes nothing the collaborator already has, so by default the key is
+// rotated in the same operation: every tracked file is re-encrypted and the new key is wrapped for
+// the remaining collaborators
+func Revoke(args []string) error {
+	flags := flag.NewFlagSet("revoke", flag.ContinueOnError)
+	rotate := flags.String("rotate", "", "rotate the key after removal: always, ask or never (default from ezenv.rotateOnRemoval, or always)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return fmt.Errorf("no collaborator specified")
+	}
+	login := flags.Arg(0)
+
+	if err := requireKeyringMode(); err != nil {
+		return err
+	}
+
+	policy, err := rotationPolicy(*rotate)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	keyring, err := loadTrustedKeyring()
+	if err != nil {
+		return err
+	}
+
+	removed := keyring.SoftDelete(login, currentActor(ctx), time.Now())
+	if removed == 0 {
+		return fmt.Errorf("%s is not in the keyring", login)
+	}
+	// The removal is saved before rotating so the new key is only wrapped for the remaining entries
+	if err := saveKeyring(keyring); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Removed %d key(s) of %s from the keyring\n", removed, login)
+
+	doRotate := policy == rotateAlways
+	if policy == rotateAsk {
+		if doRotate, err = confirm(fmt.Sprintf("Rotate the key so %s cannot decrypt future changes?", login)); err != nil {
+			return err
+		}
+	}
+
+	if !doRotate {
+		fmt.Printf("Warning: %s can still decrypt everything encrypted with the current key; run 'git ez-env rotate-key' to revoke that\n", login)
+		fmt.Printf("Note: run 'git ez-env restore-access %s' to undo the removal\n", login)
+		return nil
+	}
+
+	if err := rotateKey(ctx, false, 100, false); err != nil {
+		return fmt.Errorf("%s was removed but the key rotation failed; run 'git ez-env rotate-key --resume' to finish revoking access: %w", login, err)
+	}
+
+	// Reload the keyring because the rotation re-wrapped and saved it
+	if keyring, err = loadTrustedKeyring(); err != nil {
+		return err
+	}
+	if tombstone, ok := keyring.FindTombstone(login); ok {
+		tombstone.Rotated = true
+	}
+	if err := rotateSyncBotKey(ctx, keyring); err != nil {
+		return err
+	}
+	if err := saveKeyring(keyring); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Revoked %s: the new key is wrapped for %d remaining keyring entries\n", login, len(keyring.Entries))
+	return nil
+}
+
+// RestoreAccess re-adds a removed collaborator from their tombstone without re-fetching keys
+func RestoreAccess(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("no collaborator specified")
+	}
+	login := args[0]
+
+	if err := requireKeyringMode(); err != nil {
+		return err
+	}
+
+	value := settingValue("restoreGracePeriod")
+	gracePeriod, err := time.ParseDuration(value)
+	if err != nil || gracePeriod <= 0 {
+		return fmt.Errorf("invalid ezenv.restoreGracePeriod value: %q", value)
+	}
+
+	ctx := context.Background()
+	keyManager := crypto.NewKeyManager()
+	key, err := keyManager.GetEncryptionKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	defer key.Destroy()
+
+	keyring, err := loadTrustedKeyring()
+	if err != nil {
+		return err
+	}
+	restored, err := keyring.Restore(login, time.Now(), gracePeriod)
+	if err != nil {
+		return err
+	}
+	if _, err := keyring.WrapPendingDEKs(key.Bytes(), login); err != nil {
+		return err
+	}
+	if err := saveKeyring(keyring); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Restored %d key(s) of %s\n", restored, login)
+	fmt.Println("Note: commit and push the keyring so the change takes effect")
+	return nil
+}
+
+// requireKeyringMode fails unless the repository manages collaborators in a keyring
+func requireKeyringMode() error {
+	if crypto.CurrentMode() != crypto.ModeKeyring {
+		return fmt.Errorf("collaborators are managed by GitHub in %s mode; run 'git ez-env convert --to keyring' first", crypto.ModeSharedKey)
+	}
+	return nil
+}
+
+// rotationPolicy resolves the rotation policy from the flag value or git config, defaulting to always
+func rotationPolicy(flagValue string) (string, error) {
+	policy := flagValue
+	if policy == "" {
+		policy = settingValue("rotateOnRemoval")
+	}
+
+	switch policy {
+	case rotateAlways, rotateAsk, rotateNever:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid rotation policy %q (expected always, ask or never)", policy)
+}
+
+// managingRole is the least repository role allowed to set up, grant and rotate the key
+const managingRole = "maintain"
+
+// checkGitHubAccess fails early when the GitHub token lacks scopes or the user cannot manage the
+// repository, instead of partway through an operation
+// Bitbucket credentials have no scopes to inspect, so nothing is checked there
+// When the remote is a fork and its upstream is another remote, the user can switch to it
+func checkGitHubAccess(ctx context.Context, scopes ...string) error {
+	if hosting.Current() != hosting.HostGitHub {
+		return nil
+	}
+	err := github.CheckAccess(ctx, scopes, managingRole)
+	var fork *github.ForkError
+	if !errors.As(err, &fork) {
+		return err
+	}
+	// With the upstream already a remote, offer to manage the key there instead
+	remote, ok := github.RemoteFor(fork.Parent)
+	if !ok {
+		return err
+	}
+	fmt.Printf("%s is a fork of %s, whose secrets and workflow runs are separate from the fork's.\n", fork.Repository, fork.Parent)
+	use, confirmErr := confirm(fmt.Sprintf("Manage the key in %s through the remote %s instead?", fork.Parent, remote))
+	if confirmErr != nil || !use {
+		return err
+	}
+	if err := writeSetting(true, "ezenv.remote", remote); err != nil {
+		return err
+	}
+	github.UseRemote(remote)
+	fmt.Printf("✓ remote = %s (in this clone's git config)\n", remote)
+	return github.CheckAccess(ctx, scopes, managingRole)
+}
+
+// currentActor identifies who performs a keyring change, preferring the GitHub login
+func currentActor(ctx context.Context) string {
+	if login, err := hosting.GetCurrentUser(ctx); err == nil && login != "" {
+		return login
+	}
+	name, _ := gitOutput("config", "--get", "user.name")
+	return name
+}
+
+// loadTrustedKeyring loads the keyring for a change that re-signs it, after checking it against the
+// signers this clone trusts, so an edited keyring is not signed into a trusted one
+func loadTrustedKeyring() (*crypto.Keyring, error) {
+	keyring, err := crypto.LoadKeyring(crypto.KeyringFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := crypto.CheckKeyringTrust(keyring); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+// saveKeyring signs the keyring when possible, writes it and stages it
+func saveKeyring(keyring *crypto.Keyring) error {
+	if err := signKeyring(keyring); err != nil {
+		return err
+	}
+	if err := keyring.Save(crypto.KeyringFile); err != nil {
+		return err
+	}
+	addCmd := exec.Command("git", "add", crypto.KeyringFile)
+	if err := addCmd.Run(); err != nil {
+		return fmt.Errorf("failed to add %s to git: %w", crypto.KeyringFile, err)
+	}
+	return nil
+}
+
+// signKeyring signs the keyring with the local SSH key if it is a granted entry, and remembers the
+// result as trusted since this clone made it
+// A keyring that was signed before must stay validly signed, or every other clone would reject it
+func signKeyring(keyring *crypto.Keyring) error {
+	previous := keyring.Signature
+	privateKey, err := keyring.LoadPrivateKey()
+	if err == nil {
+		err = keyring.Sign(privateKey)
+	}
+	if err == nil {
+		return crypto.TrustKeyring(keyring)
+	}
+
+	keyring.Signature = previous
+	if previous == nil {
+		return nil
+	}
+	// Pending entries are not covered by the signature, so registering a key keeps it valid
+	if _, verifyErr := keyring.VerifySignature(); verifyErr == nil {
+		return nil
+	}
+	return fmt.Errorf("failed to sign %s: %w; it is signed, so only a collaborator whose SSH key has access can change it", crypto.KeyringFile, err)
+}
@@ -1,77 +1,377 @@
 package cmd
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/workpool"
 )
 
-// AddFile adds a file to the list of files that should be encrypted
+// attributes are the .gitattributes attributes assigned to every managed pattern
+const attributes = "filter=ezenv diff=ezenv merge=ezenv"
+
+// AddFile adds files, directories or glob patterns to the list of files that should be encrypted
+// All arguments are validated first and .ezenv.yml and .gitattributes are written once
 func AddFile(args []string) error {
+	flags := flag.NewFlagSet("add", flag.ContinueOnError)
+	fromStdin := flags.Bool("stdin", false, "read the file content from stdin and stage it encrypted without writing plaintext to disk")
+	materialize := flags.Bool("materialize", false, "with --stdin, also write the plaintext to the working tree with 0600 permissions")
+	external := flags.Bool("external", false, "manage a file outside the repository; only its ciphertext is stored in the sidecar store")
+	name := flags.String("name", "", "with --external, the name of the sidecar entry (default derived from the path)")
+	interactive := flags.Bool("i", false, "choose from files in the repository that look like secrets")
+	environment := flags.String("environment", "", "encrypt with the key of this GitHub Environment instead of the repository key")
+	jobs := flags.Int("jobs", workpool.DefaultJobs(), "number of tracked files encrypted in parallel")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *jobs < 1 {
+		return fmt.Errorf("--jobs must be at least 1")
+	}
+	args = flags.Args()
+	if *environment != "" {
+		if err := crypto.ValidateEnvironment(*environment); err != nil {
+			return err
+		}
+		if *external {
+			return fmt.Errorf("--environment cannot be combined with --external")
+		}
+	}
+
+	if *interactive {
+		if len(args) > 0 || *external || *fromStdin {
+			return fmt.Errorf("-i cannot be combined with paths, --external or --stdin")
+		}
+		candidates, err := secretFileCandidates()
+		if err != nil {
+			return err
+		}
+		if len(candidates) == 0 {
+			fmt.Println("No unmanaged files that look like secrets were found")
+			return nil
+		}
+		if args, err = pickFiles("Select the files to encrypt", candidates); err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			fmt.Println("No files selected")
+			return nil
+		}
+	}
+
 	if len(args) < 1 {
 		return fmt.Errorf("no file specified")
 	}
 
-	filePath := args[0]
+	if *external || *fromStdin {
+		if len(args) > 1 {
+			return fmt.Errorf("--external and --stdin take a single path")
+		}
+		if *external {
+			return addExternal(args[0], *name)
+		}
+		return addFromStdin(args[0], *materialize, *environment)
+	}
+	if *materialize {
+		return fmt.Errorf("--materialize can only be used with --stdin")
+	}
 
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return fmt.Errorf("file does not exist: %s", filePath)
+	config, err := loadRepoConfig()
+	if err != nil {
+		return err
+	}
+	existing, err := config.files()
+	if err != nil {
+		return err
 	}
 
-	// Add the file pattern to .gitattributes
-	if err := addToGitAttributes(filePath); err != nil {
-		return fmt.Errorf("failed to add file to .gitattributes: %w", err)
+	var patterns []string
+	matches := make(map[string][]string)
+	for _, arg := range args {
+		pattern, files, err := resolveAddArgument(arg)
+		if err != nil {
+			return err
+		}
+		if _, seen := matches[pattern]; !seen {
+			patterns = append(patterns, pattern)
+		}
+		matches[pattern] = files
 	}
 
-	fmt.Printf("✓ File added for encryption: %s\n", filePath)
-	fmt.Printf("Note: The file will be encrypted on next git add/commit\n")
+	if *environment != "" {
+		for _, pattern := range patterns {
+			if containsManagedPattern(existing, pattern) {
+				return fmt.Errorf("%s is already managed; remove it first to move it to the %s environment", pattern, *environment)
+			}
+		}
+	}
+
+	// Add the patterns to .ezenv.yml and .gitattributes
+	if err := addManagedPatterns(*environment, patterns...); err != nil {
+		return fmt.Errorf("failed to add files to %s: %w", RepoConfigFile, err)
+	}
+
+	// The index is read once instead of once per matched file
+	entries, err := indexEntries()
+	if err != nil {
+		return fmt.Errorf("failed to list tracked files: %w", err)
+	}
+	indexModes := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		indexModes[entry.Path] = entry.Mode
+	}
+
+	files := make(map[string]bool)
+	for _, pattern := range patterns {
+		status := "added"
+		if containsManagedPattern(existing, pattern) {
+			status = "already managed"
+		}
+		fmt.Printf("✓ %s (%s)\n", pattern, status)
+		if len(matches[pattern]) == 1 && matches[pattern][0] == pattern {
+			files[pattern] = true
+			continue
+		}
+		for _, path := range matches[pattern] {
+			if kind, description := fileKindOf(path, indexModes[path]); kind != kindRegular {
+				fmt.Printf("  - %s (%s, stored unencrypted)\n", path, description)
+				continue
+			}
+			fmt.Printf("  - %s\n", path)
+			files[path] = true
+		}
+	}
+	if *environment != "" {
+		fmt.Printf("Note: they are encrypted with the %s environment key; create it with 'git ez-env init --environment %s' if it does not exist\n", *environment, *environment)
+	}
 
+	// Tracked files stay in plaintext in the index until they change, so they are encrypted now
+	encrypted, err := encryptTrackedFiles(entries, files, *jobs)
+	if err != nil {
+		return fmt.Errorf("%w; run 'git add --renormalize .' once the key is available", err)
+	}
+	if encrypted > 0 {
+		fmt.Printf("✓ Encrypted and staged %d tracked file(s)\n", encrypted)
+	}
+	if untracked := len(files) - encrypted; untracked > 0 {
+		fmt.Printf("Note: %d pattern(s) cover %d other file(s), which will be encrypted on next git add/commit\n", len(patterns), untracked)
+	}
 	return nil
 }
 
-// addToGitAttributes adds a file pattern to .gitattributes
-func addToGitAttributes(filePath string) error {
-	// Read existing .gitattributes
-	content, err := os.ReadFile(".gitattributes")
-	if err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to read .gitattributes: %w", err)
+// encryptTrackedFiles encrypts the staged content of the index entries among paths that is
+// still plaintext and stages the result, returning how many files it encrypted
+// The blobs are read through one git process, encrypted on up to jobs workers and written and
+// staged through one git process each, so adding a directory of many files stays fast; unstaged
+// changes in the working tree are left alone
+func encryptTrackedFiles(entries []indexEntry, paths map[string]bool, jobs int) (int, error) {
+	var tracked []indexEntry
+	var trackedPaths []string
+	for _, entry := range entries {
+		// Symlinks are stored as link targets and never encrypted
+		if paths[entry.Path] && entry.Mode != "120000" {
+			tracked = append(tracked, entry)
+			trackedPaths = append(trackedPaths, entry.Path)
+		}
+	}
+	if len(tracked) == 0 {
+		return 0, nil
+	}
+	environments, err := crypto.FileEnvironments(trackedPaths)
+	if err != nil {
+		return 0, err
+	}
+	opts, err := encryptOptions("")
+	if err != nil {
+		return 0, err
+	}
+	bind, err := bindPathsEnabled()
+	if err != nil {
+		return 0, err
 	}
 
-	// Check if the pattern already exists
-	pattern := filePath + " filter=ezenv\n"
-	if os.IsNotExist(err) {
-		// Create new .gitattributes
-		content = []byte("# ezenv encrypted files\n" + pattern)
-	} else {
-		// Check if pattern already exists
-		if !containsPattern(string(content), filePath) {
-			// Append to existing content
-			content = append(content, []byte(pattern)...)
+	// Keys are only retrieved once a plaintext blob needs them
+	ctx := context.Background()
+	keys := filterKeys{}
+	defer keys.destroy()
+	var keysMu sync.Mutex
+	updated, err := rekeyEntries(tracked, jobs, func(entry indexEntry, content []byte) ([]byte, error) {
+		if crypto.IsEncryptedFile(content) {
+			return content, nil
+		}
+		keysMu.Lock()
+		key, err := keys.forEnvironment(ctx, environments[entry.Path])
+		keysMu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		entryOpts := opts
+		if bind {
+			entryOpts.Path = entry.Path
+		}
+		encrypted, err := crypto.EncryptFileWithOptions(content, key.Bytes(), entryOpts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt %s: %w", entry.Path, err)
+		}
+		return encrypted, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var changed []indexEntry
+	for i, entry := range updated {
+		if entry.Object != tracked[i].Object {
+			changed = append(changed, entry)
+		}
+	}
+	if err := stageEntries(changed); err != nil {
+		return 0, err
+	}
+	return len(changed), nil
+}
+
+// resolveAddArgument turns an add argument into a .gitattributes pattern and the files it matches
+// Literal paths must be existing regular files; directories and globs must match at least one file
+func resolveAddArgument(arg string) (string, []string, error) {
+	if pattern, ok := directoryOrGlobPattern(arg); ok {
+		files, err := patternMatches(pattern)
+		if err != nil {
+			return "", nil, err
+		}
+		return pattern, files, nil
+	}
+
+	// Check if file exists
+	if _, err := os.Stat(arg); os.IsNotExist(err) {
+		return "", nil, fmt.Errorf("file does not exist: %s", arg)
+	}
+	switch kind, description := managedFileKind(arg); kind {
+	case kindSymlink:
+		return "", nil, fmt.Errorf("%s is a symbolic link; add the file it points to instead", arg)
+	case kindSpecial:
+		return "", nil, fmt.Errorf("%s is a %s; only regular files can be encrypted", arg, description)
+	}
+	return arg, []string{arg}, nil
+}
+
+// directoryOrGlobPattern converts a directory or glob argument into a .gitattributes pattern
+// A directory becomes dir/** so every file below it is matched; literal paths are not patterns
+func directoryOrGlobPattern(arg string) (string, bool) {
+	pattern := strings.TrimPrefix(filepath.ToSlash(arg), "./")
+	if strings.ContainsAny(pattern, "*?[") {
+		return pattern, true
+	}
+	if info, err := os.Stat(arg); (err == nil && info.IsDir()) || strings.HasSuffix(pattern, "/") {
+		return strings.TrimSuffix(pattern, "/") + "/**", true
+	}
+	return "", false
+}
+
+// patternMatches lists the tracked and untracked files matching a glob pattern, failing if there are none
+func patternMatches(pattern string) ([]string, error) {
+	// Like in .gitignore, a pattern without a slash matches at any depth
+	pathspec := strings.TrimPrefix(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		pathspec = "**/" + pattern
+	}
+	output, err := gitOutputRaw(nil, "ls-files", "-z", "--cached", "--others", "--exclude-standard", "--", ":(glob)"+pathspec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files matching %s: %w", pattern, err)
+	}
+	var matches []string
+	for _, path := range strings.Split(string(output), "\x00") {
+		if path != "" && path != ".gitattributes" {
+			matches = append(matches, path)
 		}
 	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no files match %s", pattern)
+	}
+	return matches, nil
+}
+
+// addFromStdin encrypts content read from stdin and stages the ciphertext directly in the index
+// Unless materialize is set, the plaintext never touches the disk and the path is marked
+// skip-worktree so the missing working tree file does not show up as deleted
+func addFromStdin(filePath string, materialize bool, environment string) error {
+	plaintext, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read stdin: %w", err)
+	}
+
+	if err := addManagedPatterns(environment, filePath); err != nil {
+		return fmt.Errorf("failed to add file to %s: %w", RepoConfigFile, err)
+	}
 
-	// Write .gitattributes
-	if err := os.WriteFile(".gitattributes", content, 0644); err != nil {
-		return fmt.Errorf("failed to write .gitattributes: %w", err)
+	ctx := context.Background()
+	keyManager, err := fileKeyManager(filePath)
+	if err != nil {
+		return err
+	}
+	key, err := keyManager.GetEncryptionKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	defer key.Destroy()
+
+	opts, err := encryptOptions(repoRelativePath(filePath))
+	if err != nil {
+		return err
 	}
 
-	// Add .gitattributes to git
-	addCmd := exec.Command("git", "add", ".gitattributes")
-	if err := addCmd.Run(); err != nil {
-		return fmt.Errorf("failed to add .gitattributes to git: %w", err)
+	encrypted, err := crypto.EncryptFileWithOptions(plaintext, key.Bytes(), opts)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt content: %w", err)
+	}
+
+	object, err := writeBlob(encrypted)
+	if err != nil {
+		return fmt.Errorf("failed to write encrypted blob: %w", err)
+	}
+
+	if _, err := gitOutput("update-index", "--add", "--cacheinfo", fmt.Sprintf("100644,%s,%s", object, filePath)); err != nil {
+		return fmt.Errorf("failed to stage %s: %w", filePath, err)
+	}
+
+	if materialize {
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", filePath, err)
+		}
+		if err := os.WriteFile(filePath, plaintext, 0600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", filePath, err)
+		}
+		if err := os.Chmod(filePath, 0600); err != nil {
+			return fmt.Errorf("failed to set permissions on %s: %w", filePath, err)
+		}
+	} else {
+		if _, err := gitOutput("update-index", "--skip-worktree", "--", filePath); err != nil {
+			return fmt.Errorf("failed to mark %s as skip-worktree: %w", filePath, err)
+		}
+	}
+
+	fmt.Printf("✓ Encrypted content staged for: %s\n", filePath)
+	if !materialize {
+		fmt.Printf("Note: no plaintext was written to disk; run 'git update-index --no-skip-worktree %s && git checkout -- %s' to materialize it later\n", filePath, filePath)
 	}
 
 	return nil
 }
 
-// containsPattern checks if a file pattern already exists in .gitattributes
-func containsPattern(content, filePath string) bool {
-	pattern := filePath + " filter=ezenv"
-	lines := strings.Split(content, "\n")
-	for _, line := range lines {
-		if strings.TrimSpace(line) == pattern {
+// isPatternLine reports whether a .gitattributes line assigns the ezenv filter to filePath
+func isPatternLine(line, filePath string) bool {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != filePath {
+		return false
+	}
+	for _, attr := range fields[1:] {
+		if attr == "filter=ezenv" {
 			return true
 		}
 	}
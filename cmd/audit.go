@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/canonical"
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/hosting"
+)
+
+// Ways a person can obtain the repository key
+const (
+	// accessKeyring means the key is wrapped to one of their SSH keys
+	accessKeyring = "keyring"
+	// accessWorkflow means they can dispatch the key management workflow, which needs write permission
+	accessWorkflow = "workflow"
+	// accessArtifact means they can download key artifacts of other users' workflow runs while they are retained
+	accessArtifact = "artifact"
+	// accessStale means they were removed but the key was not rotated, so they may still hold it
+	accessStale = "stale"
+)
+
+// auditReport lists everyone who can currently obtain the repository key
+type auditReport struct {
+	Repository      string        `json:"repository,omitempty"`
+	Mode            string        `json:"mode"`
+	SecretExists    bool          `json:"secret_exists"`
+	SecretUpdatedAt *time.Time    `json:"secret_updated_at,omitempty"`
+	People          []auditPerson `json:"people"`
+	GeneratedAt     time.Time     `json:"generated_at"`
+}
+
+// auditPerson is a single person and the ways they can obtain the key
+type auditPerson struct {
+	Login      string     `json:"login"`
+	Permission string     `json:"permission,omitempty"`
+	Access     []string   `json:"access"`
+	Keys       int        `json:"keys,omitempty"`
+	GrantedAt  *time.Time `json:"granted_at,omitempty"`
+	RemovedAt  *time.Time `json:"removed_at,omitempty"`
+}
+
+// Audit cross-references GitHub collaborators, the keyring and the secret access model
+// and prints who can obtain the repository key
+func Audit(args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	all := flags.Bool("all", false, "also list collaborators who cannot obtain the key")
+	output := flags.String("o", "", "write the JSON report to this file (encrypted unless --plaintext)")
+	writer := newSecureWriter(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := buildAuditReport(context.Background(), *all)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON || *output != "" {
+		data, err := canonical.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("failed to encode report: %w", err)
+		}
+		if *output != "" {
+			if err := writer.WriteFile(*output, data); err != nil {
+				return err
+			}
+			fmt.Printf("✓ Audit report written to %s\n", *output)
+			return nil
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	fmt.Printf("Mode:   %s\n", report.Mode)
+	switch {
+	case !report.SecretExists:
+		fmt.Printf("Secret: %s does not exist\n", github.SecretName)
+	case report.SecretUpdatedAt != nil:
+		fmt.Printf("Secret: %s set %s\n", github.SecretName, report.SecretUpdatedAt.Format(time.RFC3339))
+	default:
+		fmt.Printf("Secret: %s exists\n", github.SecretName)
+	}
+
+	fmt.Println()
+	if len(report.People) == 0 {
+		fmt.Println("Nobody can obtain the key")
+		return nil
+	}
+	for _, person := range report.People {
+		details := []string{"permission " + valueOr(person.Permission, "none")}
+		if person.Keys > 0 {
+			details = append(details, fmt.Sprintf("%d key(s)", person.Keys))
+		}
+		if person.GrantedAt != nil {
+			details = append(details, "granted "+person.GrantedAt.Format(time.RFC3339))
+		}
+		if person.RemovedAt != nil {
+			details = append(details, "removed "+person.RemovedAt.Format(time.RFC3339))
+		}
+
+		mark := "✓"
+		if len(person.Access) == 0 {
+			mark = "✗"
+		}
+		fmt.Printf("  %s %-20s %-18s %s\n", mark, person.Login, valueOr(strings.Join(person.Access, ","), "no access"), strings.Join(details, ", "))
+	}
+
+	fmt.Println("\nNote: keys imported with import-key, exported key files and delegations are not tracked")
+	return nil
+}
+
+// buildAuditReport collects the access of every collaborator, keyring entry and removed collaborator
+func buildAuditReport(ctx context.Context, all bool) (*auditReport, error) {
+	collaborators, err := hosting.ListCollaboratorPermissions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := hosting.SecretExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &auditReport{
+		Mode:         crypto.CurrentMode(),
+		SecretExists: exists,
+		People:       []auditPerson{},
+		GeneratedAt:  time.Now().UTC(),
+	}
+	if owner, repo, err := hosting.GetRepositoryInfo(); err == nil {
+		report.Repository = owner + "/" + repo
+	}
+	if exists {
+		if updatedAt, err := hosting.GetSecretUpdatedAt(ctx); err == nil {
+			report.SecretUpdatedAt = &updatedAt
+		}
+	}
+
+	people := make(map[string]*auditPerson)
+	person := func(login string) *auditPerson {
+		if people[login] == nil {
+			people[login] = &auditPerson{Login: login, Access: []string{}}
+		}
+		return people[login]
+	}
+
+	for _, collaborator := range collaborators {
+		p := person(collaborator.Login)
+		p.Permission = collaborator.Permission
+		// The workflow hands out the secret to anyone who can dispatch it while the secret exists
+		if exists {
+			switch collaborator.Permission {
+			case "admin", "maintain", "write":
+				p.Access = append(p.Access, accessWorkflow)
+			default:
+				p.Access = append(p.Access, accessArtifact)
+			}
+		}
+	}
+
+	if report.Mode == crypto.ModeKeyring {
+		keyring, err := crypto.LoadKeyring(crypto.KeyringFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range keyring.Entries {
+			p := person(entry.Login)
+			if p.Keys == 0 {
+				p.Access = append(p.Access, accessKeyring)
+			}
+			p.Keys++
+			if entry.GrantedAt != nil && (p.GrantedAt == nil || entry.GrantedAt.After(*p.GrantedAt)) {
+				p.GrantedAt = entry.GrantedAt
+			}
+		}
+		for _, tombstone := range keyring.Tombstones {
+			p := person(tombstone.Login)
+			removedAt := tombstone.RemovedAt
+			p.RemovedAt = &removedAt
+			if !tombstone.Rotated {
+				p.Access = append(p.Access, accessStale)
+			}
+		}
+	}
+
+	for _, p := range people {
+		if len(p.Access) > 0 || all {
+			report.People = append(report.People, *p)
+		}
+	}
+	sort.Slice(report.People, func(i, j int) bool {
+		return report.People[i].Login < report.People[j].Login
+	})
+	return report, nil
+}
+
+// valueOr returns value, or fallback if value is empty
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
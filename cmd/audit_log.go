@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/canonical"
+	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/hosting"
+)
+
+// AuditLog prints the key retrievals the key management workflow recorded on the ezenv-audit
+// branch, and warns about commits to the log that the workflow did not make
+func AuditLog(args []string) error {
+	flags := flag.NewFlagSet("audit-log", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the records as JSON")
+	user := flags.String("user", "", "only show retrievals dispatched by or for this login")
+	since := flags.Duration("since", 0, "only show retrievals within this duration, such as 720h")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if hosting.Current() != hosting.HostGitHub {
+		return fmt.Errorf("the audit log is recorded by the GitHub workflow; %s has no such log", hosting.Name())
+	}
+
+	records, untrusted, err := github.GetAuditLog(context.Background())
+	if errors.Is(err, github.ErrNoAuditLog) {
+		fmt.Printf("No key retrievals recorded on the %s branch yet\n", github.AuditBranch)
+		fmt.Println("Note: workflows written before the audit log do not record retrievals; update with 'git ez-env init --update-workflow'")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	protected, err := github.AuditBranchProtected(context.Background())
+	if err != nil && !errors.Is(err, github.ErrNoAuditLog) {
+		return err
+	}
+
+	shown := []github.AuditRecord{}
+	for _, record := range records {
+		if *user != "" && record.Actor != *user && record.User != *user {
+			continue
+		}
+		if *since > 0 && record.Time.Before(time.Now().Add(-*since)) {
+			continue
+		}
+		shown = append(shown, record)
+	}
+
+	if *asJSON {
+		data, err := canonical.Marshal(shown)
+		if err != nil {
+			return fmt.Errorf("failed to encode records: %w", err)
+		}
+		if _, err := os.Stdout.Write(data); err != nil {
+			return err
+		}
+	} else {
+		for _, record := range shown {
+			who := record.Actor
+			if record.User != "" && record.User != record.Actor {
+				who += " for " + record.User
+			}
+			fmt.Printf("%s  %-10s %-28s %-12s run %d  commit %.7s  key %s\n",
+				record.Time.Format(time.RFC3339), record.Action, who, valueOr(record.Environment, "(repository)"),
+				record.RunID, record.Commit, record.KeyID)
+		}
+		fmt.Printf("%d of %d recorded key retrieval(s)\n", len(shown), len(records))
+	}
+
+	// Records are only trustworthy when every change to the log came from the workflow, and any
+	// workflow can commit as the bot unless the branch is protected
+	if !protected {
+		fmt.Fprintf(os.Stderr, "Warning: the %s branch is not protected, so any workflow of the repository can add, change or remove records\n", github.AuditBranch)
+	}
+	for _, commit := range untrusted {
+		fmt.Fprintf(os.Stderr, "Warning: commit %.7s by %s changed the audit log, but %s\n", commit.SHA, valueOr(commit.Author, "unknown"), commit.Reason)
+	}
+	if len(untrusted) > 0 {
+		return fmt.Errorf("the audit log was changed outside the workflow; protect the %s branch so only GitHub Actions can push to it", github.AuditBranch)
+	}
+	if !protected {
+		return fmt.Errorf("the audit log cannot be trusted; protect the %s branch so only the key management workflow can push to it", github.AuditBranch)
+	}
+	return nil
+}
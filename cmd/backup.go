@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+	"rsc.io/qr"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+// Backup prints a paper backup of the repository key for offline escrow
+func Backup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	paper := flags.Bool("paper", false, "print the key as words and a QR code to write down or print")
+	pngPath := flags.String("png", "", "also write the QR code as a PNG image to this file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !*paper {
+		return fmt.Errorf("use --paper for a printable backup, or 'git ez-env export-key' for a passphrase-protected file")
+	}
+
+	ctx := context.Background()
+	keyManager := crypto.NewKeyManager()
+	key, err := keyManager.GetEncryptionKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	defer key.Destroy()
+
+	words, err := crypto.PaperWords(key.Bytes())
+	if err != nil {
+		return err
+	}
+	text, err := crypto.PaperCode(key.Bytes())
+	if err != nil {
+		return err
+	}
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return fmt.Errorf("failed to encode QR code: %w", err)
+	}
+
+	fmt.Printf("ez-env paper backup of key %s\n\n", crypto.KeyID(key.Bytes()))
+	for i, word := range words {
+		if (i+1)%4 == 0 || i == len(words)-1 {
+			fmt.Printf("%2d. %s\n", i+1, word)
+		} else {
+			fmt.Printf("%2d. %-10s", i+1, word)
+		}
+	}
+	fmt.Println()
+	fmt.Print(renderQR(code))
+	fmt.Printf("\n%s\n\n", text)
+
+	if *pngPath != "" {
+		if err := os.WriteFile(*pngPath, code.PNG(), 0600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", *pngPath, err)
+		}
+		fmt.Printf("✓ QR code written to %s\n", *pngPath)
+	}
+	fmt.Println("Note: anyone holding this backup can decrypt every file; store it offline and never commit it")
+	fmt.Println("Note: restore it with 'git ez-env restore --paper'")
+	return nil
+}
+
+// renderQR draws a QR code with half-block characters, two modules per character, dark modules
+// printed as ink so the code scans from paper, with the quiet zone scanners need around it
+func renderQR(code *qr.Code) string {
+	const quiet = 4
+	dark := func(x, y int) bool {
+		x, y = x-quiet, y-quiet
+		return x >= 0 && y >= 0 && x < code.Size && y < code.Size && code.Black(x, y)
+	}
+
+	var b strings.Builder
+	size := code.Size + 2*quiet
+	for y := 0; y < size; y += 2 {
+		for x := range size {
+			top, bottom := dark(x, y), dark(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Restore restores the repository key on this machine from a paper backup
+// The words or the scanned QR code text are read from stdin
+func Restore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	paper := flags.Bool("paper", false, "restore from the words or QR code text of a paper backup")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !*paper {
+		return fmt.Errorf("use --paper to restore from a paper backup, or 'git ez-env import-key' for an exported key file")
+	}
+	if err := checkGitRepo(); err != nil {
+		return err
+	}
+
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintln(os.Stderr, "Type the backup words or the QR code text, then press Ctrl-D:")
+	}
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read the backup: %w", err)
+	}
+	key, err := crypto.ParsePaperBackup(string(input))
+	if err != nil {
+		return err
+	}
+
+	// Refuse a key that cannot decrypt what is already committed
+	checked, err := verifyRecoveryKey(key)
+	if err != nil {
+		return err
+	}
+	if err := crypto.SaveLocalKey(key); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Encryption key %s restored\n", crypto.KeyID(key))
+	if checked > 0 {
+		fmt.Printf("✓ Key verified against %d encrypted file(s)\n", checked)
+	}
+	fmt.Println("Note: the key is stored in .git/ezenv/key and used instead of the GitHub workflow")
+	if crypto.CurrentMode() == crypto.ModeSharedKey {
+		fmt.Println("Note: if the repository secret was deleted, run 'git ez-env recover' to upload the key again")
+	}
+	return nil
+}
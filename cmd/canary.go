@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/canary"
+	"github.com/oliviaBahr/ez-env/dotenv"
+	"github.com/oliviaBahr/ez-env/workflows"
+)
+
+// Canary manages decoy secrets that reveal when encryption was bypassed
+func Canary(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("no canary command specified (add, check or install-workflow)")
+	}
+
+	switch args[0] {
+	case "add":
+		return canaryAdd(args[1:])
+	case "check":
+		return canaryCheck(args[1:])
+	case "install-workflow":
+		return installCanaryWorkflow()
+	default:
+		return fmt.Errorf("unknown canary command: %s", args[0])
+	}
+}
+
+// canaryAdd plants a decoy value in a managed dotenv file and registers its hash
+func canaryAdd(args []string) error {
+	flags := flag.NewFlagSet("canary add", flag.ContinueOnError)
+	name := flags.String("name", "LEGACY_API_TOKEN", "variable name of the decoy secret")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return fmt.Errorf("no file specified")
+	}
+	filePath := flags.Arg(0)
+
+	managed, err := pathsWithFilter([]string{filePath})
+	if err != nil {
+		return err
+	}
+	if !managed[filePath] {
+		return fmt.Errorf("%s is not managed by ez-env; canaries must live in encrypted files", filePath)
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+	env := dotenv.Parse(content)
+	if _, exists := env.Get(*name); exists {
+		return fmt.Errorf("%s already defines %s", filePath, *name)
+	}
+
+	value, err := canary.Generate()
+	if err != nil {
+		return err
+	}
+	env.Set(*name, value)
+
+	registry, err := canary.Load(canary.RegistryFile)
+	if err != nil {
+		return err
+	}
+	registry.Add(*name, filePath, value)
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", filePath, err)
+	}
+	if err := os.WriteFile(filePath, env.Bytes(), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filePath, err)
+	}
+	if err := registry.Save(canary.RegistryFile); err != nil {
+		return err
+	}
+
+	addCmd := exec.Command("git", "add", "--", filePath, canary.RegistryFile)
+	if err := addCmd.Run(); err != nil {
+		return fmt.Errorf("failed to stage canary: %w", err)
+	}
+
+	fmt.Printf("✓ Canary %s planted in %s\n", *name, filePath)
+	fmt.Println("Note: run 'git ez-env canary install-workflow' to check pushes and pull requests automatically")
+	return nil
+}
+
+// canaryCheck scans committed content (or stdin) for canary values
+func canaryCheck(args []string) error {
+	flags := flag.NewFlagSet("canary check", flag.ContinueOnError)
+	fromStdin := flags.Bool("stdin", false, "scan stdin (e.g. logs or pull request text) instead of the repository")
+	history := flags.Bool("history", false, "scan every object in the repository, not only HEAD")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	registry, err := canary.Load(canary.RegistryFile)
+	if err != nil {
+		return err
+	}
+	if len(registry.Canaries) == 0 {
+		fmt.Println("No canaries registered")
+		return nil
+	}
+
+	var found []string
+	report := func(source string, canaries []canary.Canary) {
+		for _, c := range canaries {
+			found = append(found, fmt.Sprintf("%s (planted in %s) found in plaintext in %s", c.Name, c.File, source))
+		}
+	}
+
+	if *fromStdin {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %w", err)
+		}
+		report("stdin", registry.Scan(data))
+	} else {
+		objects, names, err := canaryScanObjects(*history)
+		if err != nil {
+			return err
+		}
+		err = forEachBlob(objects, func(object string, content []byte) error {
+			report(names[object], registry.Scan(content))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(found) > 0 {
+		for _, message := range found {
+			fmt.Printf("✗ %s\n", message)
+		}
+		return fmt.Errorf("canary tripped: encryption was bypassed for %d value(s)", len(found))
+	}
+
+	fmt.Printf("✓ No canary values found (%d canaries checked)\n", len(registry.Canaries))
+	return nil
+}
+
+// canaryScanObjects lists the blobs to scan and a display name for each
+func canaryScanObjects(history bool) ([]string, map[string]string, error) {
+	names := make(map[string]string)
+	var objects []string
+
+	if history {
+		output, err := gitOutput("cat-file", "--batch-all-objects", "--batch-check=%(objectname) %(objecttype)")
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, line := range strings.Split(output, "\n") {
+			object, objectType, ok := strings.Cut(line, " ")
+			if ok && objectType == "blob" {
+				objects = append(objects, object)
+				names[object] = "blob " + object
+			}
+		}
+		return objects, names, nil
+	}
+
+	output, err := gitOutputRaw(nil, "ls-tree", "-r", "-z", "HEAD")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, record := range strings.Split(string(output), "\x00") {
+		// Format: <mode> <type> <object>\t<path>
+		meta, path, ok := strings.Cut(record, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 3 || fields[1] != "blob" {
+			continue
+		}
+		objects = append(objects, fields[2])
+		names[fields[2]] = path
+	}
+	return objects, names, nil
+}
+
+// installCanaryWorkflow adds the canary check workflow to the repository
+func installCanaryWorkflow() error {
+	ref, err := pinnedRef()
+	if err != nil {
+		return err
+	}
+	repoPath, err := repoRoot()
+	if err != nil {
+		return err
+	}
+
+	if err := workflows.WriteCanaryWorkflowFile(repoPath, ref); err != nil {
+		return err
+	}
+
+	addCmd := exec.Command("git", "add", ".github/workflows/"+workflows.CanaryWorkflow)
+	if err := addCmd.Run(); err != nil {
+		return fmt.Errorf("failed to add workflow to git: %w", err)
+	}
+
+	fmt.Println("✓ Canary workflow created")
+	return nil
+}
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+// reencryptCheckpoint records the progress of a re-encryption so it can resume after an interruption
+// It lives next to the local key inside the git directory and is removed once the operation completes
+type reencryptCheckpoint struct {
+	Version   int    `json:"version"`
+	Operation string `json:"operation"`
+	OldKeyID  string `json:"old_key_id"`
+	NewKeyID  string `json:"new_key_id"`
+	// NewKey is the new key encrypted with the old key
+	NewKey    string                      `json:"new_key"`
+	Published bool                        `json:"published"`
+	Done      map[string]reencryptedEntry `json:"done"`
+	// Rewrap only rewraps per-file keys instead of re-encrypting the content
+	Rewrap bool `json:"rewrap,omitempty"`
+
+	path string
+}
+
+// reencryptedEntry maps the blob a path had before re-encryption to its re-encrypted blob
+type reencryptedEntry struct {
+	Source string `json:"source"`
+	Object string `json:"object"`
+}
+
+// checkpointPath returns where the checkpoint of an operation is stored
+func checkpointPath(operation string) (string, error) {
+	keyPath, err := crypto.LocalKeyPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(keyPath), operation+".checkpoint"), nil
+}
+
+// newCheckpoint starts a checkpoint for re-encrypting from oldKey to newKey
+// It fails if an unfinished checkpoint for the operation already exists
+func newCheckpoint(operation string, oldKey, newKey []byte) (*reencryptCheckpoint, error) {
+	path, err := checkpointPath(operation)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("an interrupted %s exists; run it again with --resume or delete %s", operation, path)
+	}
+
+	wrapped, err := crypto.EncryptFile(newKey, oldKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to protect new key: %w", err)
+	}
+
+	return &reencryptCheckpoint{
+		Version:   1,
+		Operation: operation,
+		OldKeyID:  crypto.KeyID(oldKey),
+		NewKeyID:  crypto.KeyID(newKey),
+		NewKey:    base64.StdEncoding.EncodeToString(wrapped),
+		Done:      make(map[string]reencryptedEntry),
+		path:      path,
+	}, nil
+}
+
+// loadCheckpoint reads the checkpoint of an interrupted operation
+func loadCheckpoint(operation string) (*reencryptCheckpoint, error) {
+	path, err := checkpointPath(operation)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no interrupted %s to resume", operation)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var checkpoint reencryptCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", path, err)
+	}
+	if checkpoint.Version != 1 || checkpoint.Operation != operation {
+		return nil, fmt.Errorf("unsupported checkpoint %s", path)
+	}
+	if checkpoint.Done == nil {
+		checkpoint.Done = make(map[string]reencryptedEntry)
+	}
+	checkpoint.path = path
+	return &checkpoint, nil
+}
+
+// keys recovers the old and new key from the current repository key
+// Once the new key is published the old key is no longer available and nil is returned for it
+func (c *reencryptCheckpoint) keys(currentKey []byte) ([]byte, []byte, error) {
+	switch crypto.KeyID(currentKey) {
+	case c.NewKeyID:
+		return nil, currentKey, nil
+	case c.OldKeyID:
+		wrapped, err := base64.StdEncoding.DecodeString(c.NewKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode checkpoint key: %w", err)
+		}
+		newKey, err := crypto.DecryptFile(wrapped, currentKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to recover checkpoint key: %w", err)
+		}
+		if crypto.KeyID(newKey) != c.NewKeyID {
+			return nil, nil, fmt.Errorf("checkpoint key does not match key ID %s", c.NewKeyID)
+		}
+		return currentKey, newKey, nil
+	default:
+		return nil, nil, fmt.Errorf("checkpoint was created for key %s, but the current key is %s", c.OldKeyID, crypto.KeyID(currentKey))
+	}
+}
+
+// verify drops processed paths whose source blob changed or whose re-encrypted blob is missing or invalid
+// It returns the number of paths that were kept
+func (c *reencryptCheckpoint) verify(entries []indexEntry, newKey []byte) int {
+	sources := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		sources[entry.Path] = entry.Object
+	}
+
+	for path, done := range c.Done {
+		if sources[path] != done.Source && sources[path] != done.Object {
+			delete(c.Done, path)
+			continue
+		}
+		content, err := catFileBlob(done.Object)
+		if err != nil {
+			delete(c.Done, path)
+			continue
+		}
+		if _, err := crypto.DecryptFile(content, newKey); err != nil {
+			delete(c.Done, path)
+		}
+	}
+	return len(c.Done)
+}
+
+// save atomically writes the checkpoint
+func (c *reencryptCheckpoint) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// remove deletes the checkpoint after the operation completed
+func (c *reencryptCheckpoint) remove() error {
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove checkpoint: %w", err)
+	}
+	return nil
+}
+
+// reencryptCheckpointed re-encrypts entries in batches, saving the checkpoint after every batch
+// Entries already recorded in the checkpoint are not processed again
+func reencryptCheckpointed(checkpoint *reencryptCheckpoint, entries []indexEntry, oldKey, newKey []byte, batchSize int) ([]indexEntry, error) {
+	var pending []indexEntry
+	for _, entry := range entries {
+		if done, ok := checkpoint.Done[entry.Path]; ok && (done.Source == entry.Object || done.Object == entry.Object) {
+			continue
+		}
+		pending = append(pending, entry)
+	}
+
+	if len(pending) > 0 && oldKey == nil {
+		return nil, fmt.Errorf("%d file(s) changed after the new key was published and can no longer be re-encrypted", len(pending))
+	}
+
+	reencrypt := reencryptEntries
+	if checkpoint.Rewrap {
+		reencrypt = rewrapEntries
+	}
+	for start := 0; start < len(pending); start += batchSize {
+		end := min(start+batchSize, len(pending))
+		updated, err := reencrypt(pending[start:end], oldKey, newKey)
+		if err != nil {
+			return nil, err
+		}
+		for i, entry := range updated {
+			checkpoint.Done[entry.Path] = reencryptedEntry{Source: pending[start+i].Object, Object: entry.Object}
+		}
+		if err := checkpoint.save(); err != nil {
+			return nil, err
+		}
+		fmt.Printf("  %d/%d files re-encrypted\n", len(checkpoint.Done), len(entries))
+	}
+
+	result := make([]indexEntry, 0, len(entries))
+	for _, entry := range entries {
+		entry.Object = checkpoint.Done[entry.Path].Object
+		result = append(result, entry)
+	}
+	return result, nil
+}
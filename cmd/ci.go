@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/ssh"
+)
+
+// CI providers supported by 'ci setup'
+const (
+	providerGitHubActions = "github-actions"
+	providerGitLab        = "gitlab"
+	providerCircleCI      = "circleci"
+	providerBitbucket     = "bitbucket-pipelines"
+)
+
+// ciInstallCommand builds the ez-env binary in a CI job and puts it on the PATH as a git subcommand
+const ciInstallCommand = `GOBIN="$HOME/.local/bin" go install github.com/oliviaBahr/ez-env@latest && mv "$HOME/.local/bin/ez-env" "$HOME/.local/bin/git-ez-env"`
+
+// CI prepares and describes decryption in CI pipelines
+//
+//	git ez-env ci setup --provider github-actions|gitlab|circleci|bitbucket-pipelines [--write]
+//	git ez-env ci unlock [--environment <name>]
+//	git ez-env ci key [--environment <name>]
+func CI(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: git ez-env ci setup|unlock|key")
+	}
+	switch args[0] {
+	case "setup":
+		return ciSetup(args[1:])
+	case "unlock":
+		return ciUnlock(args[1:])
+	case "key":
+		return ciKey(args[1:])
+	}
+	return fmt.Errorf("unknown ci command: %s", args[0])
+}
+
+// ciSetup prints, or with --write creates, the pipeline configuration that installs ez-env and decrypts files
+// Existing pipeline files are never rewritten; the generated config is included from them instead
+func ciSetup(args []string) error {
+	flags := flag.NewFlagSet("ci setup", flag.ContinueOnError)
+	provider := flags.String("provider", providerGitHubActions, "CI provider: github-actions, gitlab, circleci or bitbucket-pipelines")
+	write := flags.Bool("write", false, "write the configuration to the repository instead of printing it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	secret := github.SecretName
+	var path, config, usage string
+	switch *provider {
+	case providerGitHubActions:
+		path = filepath.Join(".github", "actions", "ez-env", "action.yml")
+		config = fmt.Sprintf(`name: ez-env unlock
+description: Install ez-env and decrypt the files it manages
+# ez-env reads the key from the %[1]s variable in GitHub Actions instead of running the key workflow
+inputs:
+  key:
+    description: Base64 encoded ez-env key (the %[1]s secret)
+    required: true
+runs:
+  using: composite
+  steps:
+    - uses: actions/setup-go@v5
+      with:
+        go-version: '1.23'
+    - shell: bash
+      run: |
+        %[2]s
+        echo "$HOME/.local/bin" >> "$GITHUB_PATH"
+    - shell: bash
+      env:
+        %[1]s: ${{ inputs.key }}
+      run: git-ez-env ci unlock
+`, secret, ciInstallCommand)
+		usage = fmt.Sprintf(`Add these steps to a job, after actions/checkout:
+
+    - uses: ./.github/actions/ez-env
+      with:
+        key: ${{ secrets.%[1]s }}
+
+Inside GitHub Actions ez-env never runs the key workflow, since a job cannot read secrets through it.
+Steps that run ez-env without the action read the key from the job's environment instead:
+
+    env:
+      %[1]s: ${{ secrets.%[1]s }}`, secret)
+	case providerGitLab:
+		path = filepath.Join(".gitlab", "ez-env.yml")
+		config = fmt.Sprintf(`# Extend jobs that need decrypted files with: extends: .ez-env
+.ez-env:
+  before_script:
+    - %s
+    - export PATH="$HOME/.local/bin:$PATH"
+    - git-ez-env ci unlock
+`, ciInstallCommand)
+		usage = fmt.Sprintf(`Include the template in .gitlab-ci.yml and extend it from jobs that need the secrets:
+
+    include:
+      - local: .gitlab/ez-env.yml
+
+    build:
+      extends: .ez-env
+
+Store the output of 'git ez-env ci key' as a masked, protected CI/CD variable named %s.`, secret)
+	case providerCircleCI:
+		config = fmt.Sprintf(`commands:
+  ez-env-unlock:
+    steps:
+      - run:
+          name: Install ez-env and decrypt files
+          command: |
+            %s
+            export PATH="$HOME/.local/bin:$PATH"
+            git-ez-env ci unlock
+`, ciInstallCommand)
+		usage = fmt.Sprintf(`Merge the command above into .circleci/config.yml and run '- ez-env-unlock' after '- checkout'.
+Store the output of 'git ez-env ci key' as a project environment variable named %s.`, secret)
+		if *write {
+			return fmt.Errorf("CircleCI reads a single config file; merge the printed command into .circleci/config.yml instead of using --write")
+		}
+	case providerBitbucket:
+		config = fmt.Sprintf(`# Start the script of every step that needs decrypted files with these lines
+script:
+  - %s
+  - export PATH="$HOME/.local/bin:$PATH"
+  - git-ez-env ci unlock
+`, ciInstallCommand)
+		usage = fmt.Sprintf(`Merge the script lines above into the steps of bitbucket-pipelines.yml; the steps need Go, for example image: golang:1.23.
+'git ez-env init' on a Bitbucket repository stores the key as the secured variable %s; otherwise store the
+output of 'git ez-env ci key' as a secured repository variable with that name.`, secret)
+		if *write {
+			return fmt.Errorf("Bitbucket Pipelines reads a single config file; merge the printed script lines into bitbucket-pipelines.yml instead of using --write")
+		}
+	default:
+		return fmt.Errorf("unknown provider %q (expected %s, %s, %s or %s)", *provider, providerGitHubActions, providerGitLab, providerCircleCI, providerBitbucket)
+	}
+
+	if !*write {
+		fmt.Print(config)
+		fmt.Fprintf(os.Stderr, "\n%s\n", usage)
+		return nil
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if _, err := gitOutput("add", "--", path); err != nil {
+		return fmt.Errorf("failed to add %s to git: %w", path, err)
+	}
+	fmt.Printf("✓ Wrote %s\n", path)
+	fmt.Println(usage)
+	return nil
+}
+
+// ciUnlock stores the key given to the CI job, configures the filters and re-checks out the managed files
+// The key comes from the secret's environment variable (base64) or, in keyring mode, from the
+// private key named by EZENV_SSH_KEY
+// With --environment the key is the secret of a GitHub Environment, which a job running in that
+// environment sees under the same name, and only the files of that environment are checked out
+func ciUnlock(args []string) error {
+	flags := flag.NewFlagSet("ci unlock", flag.ContinueOnError)
+	environment := flags.String("environment", "", "the key is the secret of this GitHub Environment")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := checkGitRepo(); err != nil {
+		return err
+	}
+	if *environment != "" {
+		if err := crypto.ValidateEnvironment(*environment); err != nil {
+			return err
+		}
+	}
+
+	var key *crypto.SecureBytes
+	if encoded := strings.TrimSpace(os.Getenv(github.SecretName)); encoded != "" {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("%s is not a base64 encoded key: %w", github.SecretName, err)
+		}
+		key = crypto.SecureBytesFrom(decoded)
+	} else if *environment == "" && crypto.CurrentMode() == crypto.ModeKeyring && os.Getenv(ssh.PrivateKeyEnv) != "" {
+		unwrapped, err := crypto.NewKeyManager().GetKeyringKey()
+		if err != nil {
+			return err
+		}
+		key = unwrapped
+	} else {
+		return fmt.Errorf("no key available: set %s to the base64 key, or %s to a keyring private key", github.SecretName, ssh.PrivateKeyEnv)
+	}
+	defer key.Destroy()
+
+	save := crypto.SaveLocalKey
+	if *environment != "" {
+		save = func(key []byte) error { return crypto.SaveEnvironmentKey(*environment, key) }
+	}
+	if err := save(key.Bytes()); err != nil {
+		return err
+	}
+	if err := configureGitFilters(); err != nil {
+		return fmt.Errorf("failed to configure git filters: %w", err)
+	}
+
+	entries, err := encryptedIndexEntries()
+	if err != nil {
+		return fmt.Errorf("failed to list encrypted files: %w", err)
+	}
+	all := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		all = append(all, entry.Path)
+	}
+	environments, err := crypto.FileEnvironments(all)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(entries))
+	for _, path := range all {
+		// Files of other environments cannot be decrypted with this key
+		if environments[path] == *environment {
+			paths = append(paths, path)
+		}
+	}
+	if err := refreshWorkingFiles(paths); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Decrypted %d file(s) with key %s\n", len(paths), crypto.KeyID(key.Bytes()))
+	return nil
+}
+
+// ciKey prints the base64 key to store as a CI variable
+func ciKey(args []string) error {
+	flags := flag.NewFlagSet("ci key", flag.ContinueOnError)
+	environment := flags.String("environment", "", "print the key of this GitHub Environment")
+	quiet := flags.Bool("quiet", false, "do not print the note about storing the key")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	key, err := crypto.NewEnvironmentKeyManager(*environment).GetEncryptionKey(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	defer key.Destroy()
+	if !*quiet {
+		fmt.Fprintf(os.Stderr, "Note: store this as a masked secret named %s; anyone who sees it can decrypt every file\n", github.SecretName)
+	}
+	fmt.Println(base64.StdEncoding.EncodeToString(key.Bytes()))
+	return nil
+}
+
+// refreshWorkingFiles checks paths out from the index again so they pass through the smudge filter
+// The files are removed first because git skips checking out files whose stat data is unchanged
+func refreshWorkingFiles(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+	input := strings.Join(paths, "\x00") + "\x00"
+	if _, err := gitOutputRaw([]byte(input), "checkout", "--pathspec-from-file=-", "--pathspec-file-nul"); err != nil {
+		return fmt.Errorf("failed to check out decrypted files: %w", err)
+	}
+	return nil
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/oliviaBahr/ez-env/crypto"
 )
@@ -12,40 +14,193 @@ import (
 // Clean encrypts the file content using the shared encryption key
 // This is called by Git when files are staged (git add)
 // Only called for files that match patterns in .gitattributes
-func Clean() error {
+// Git passes the path (%f) as the first argument so symlinks and special files can be detected
+// Files larger than ezenv.streamThreshold are encrypted as they are read (see cleanStream)
+func Clean(args []string) error {
+	path := ""
+	if len(args) > 0 {
+		path = args[0]
+	}
+	keys := filterKeys{}
+	defer keys.destroy()
+	start := time.Now()
+	input := &countingReader{r: os.Stdin}
+	err := cleanFile(keys, path, input)
+	debugFilter("clean", path, input.n, keys, start, err)
+	return err
+}
+
+// cleanFile encrypts the content of the file at path read from r to stdout
+func cleanFile(keys filterKeys, path string, r io.Reader) error {
+	threshold, err := streamThreshold()
+	if err != nil {
+		return err
+	}
 	// Read the file content from stdin
-	input, err := io.ReadAll(os.Stdin)
+	input, more, err := readHead(r, threshold)
 	if err != nil {
 		return fmt.Errorf("failed to read input: %w", err)
 	}
 
-	// Check if the content is already encrypted
-	if crypto.IsEncryptedFile(input) {
-		// If already encrypted, just pass it through
-		if _, err := os.Stdout.Write(input); err != nil {
-			return fmt.Errorf("failed to write output: %w", err)
+	if more {
+		output, err := cleanStream(context.Background(), keys, path, input, r)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(os.Stdout, output)
+		if closeErr := output.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to read encrypted content: %w", closeErr)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write encrypted content: %w", err)
 		}
 		return nil
 	}
+	staged := &indexBlobs{}
+	defer staged.close()
+	output, err := cleanContent(context.Background(), keys, staged, path, input)
+	if err != nil {
+		return err
+	}
+
+	// Write the encrypted content to stdout (Git will store this in the index)
+	if _, err := os.Stdout.Write(output); err != nil {
+		return fmt.Errorf("failed to write encrypted content: %w", err)
+	}
+	return nil
+}
+
+// cleanContent encrypts the content of the file at path, or returns it unchanged when it must not
+// be encrypted; path may be empty when git did not pass it
+// When the ciphertext staged for path already holds this content it is returned as it is, so
+// comparing an unchanged file with the index after a checkout does not show it as modified, and a
+// locked placeholder is turned back into the ciphertext it stands for
+func cleanContent(ctx context.Context, keys filterKeys, staged *indexBlobs, path string, input []byte) ([]byte, error) {
+	if object, ok := parseLockedPlaceholder(input); ok {
+		return lockedCiphertext(path, object)
+	}
+	if passThrough, err := cleanPassesThrough(path, input); passThrough || err != nil {
+		return input, err
+	}
 
 	// Get encryption key
-	ctx := context.Background()
-	keyManager := crypto.NewKeyManager()
-	key, err := keyManager.GetOrCreateEncryptionKey(ctx)
+	key, err := keys.get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := encryptOptions(path)
 	if err != nil {
-		return fmt.Errorf("failed to get encryption key: %w", err)
+		return nil, err
+	}
+
+	if existing, ok := staged.read(path); ok && crypto.MatchesPlaintext(existing, input, key.Bytes(), opts) {
+		return existing, nil
 	}
 
 	// Encrypt the file content
-	encryptedContent, err := crypto.EncryptFile(input, key)
+	encryptedContent, err := crypto.EncryptFileWithOptions(input, key.Bytes(), opts)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt content: %w", err)
+		return nil, fmt.Errorf("failed to encrypt content: %w", err)
 	}
+	return encryptedContent, nil
+}
 
-	// Write the encrypted content to stdout (Git will store this in the index)
-	if _, err := os.Stdout.Write(encryptedContent); err != nil {
-		return fmt.Errorf("failed to write encrypted content: %w", err)
+// cleanPassesThrough reports whether clean leaves the content of path, starting with head, as it
+// is: symlinks and content that is already encrypted. Special files are an error
+func cleanPassesThrough(path string, head []byte) (bool, error) {
+	if path != "" {
+		switch kind, description := managedFileKind(path); kind {
+		case kindSymlink:
+			// The content is the link target, which is not a secret
+			return true, nil
+		case kindSpecial:
+			return false, fmt.Errorf("refusing to encrypt %s: it is a %s, not a regular file", path, description)
+		}
 	}
 
-	return nil
+	// If already encrypted, just pass it through
+	return crypto.IsEncryptedFile(head), nil
+}
+
+// bindPathsEnabled reports whether ezenv.bindPaths binds encrypted files to their path
+func bindPathsEnabled() (bool, error) {
+	value := settingValue("bindPaths")
+	bind, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid ezenv.bindPaths value: %q", value)
+	}
+	return bind, nil
+}
+
+// minStreamThreshold is the smallest stream threshold, so small files such as locked placeholders
+// are always read whole
+const minStreamThreshold = 4096
+
+// streamThreshold returns ezenv.streamThreshold, the size above which the filters stream files
+// instead of holding them in memory, or zero when they never do
+func streamThreshold() (int64, error) {
+	value := settingValue("streamThreshold")
+	threshold, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || threshold < 0 {
+		return 0, fmt.Errorf("invalid ezenv.streamThreshold value: %q", value)
+	}
+	if threshold > 0 && threshold < minStreamThreshold {
+		threshold = minStreamThreshold
+	}
+	return threshold, nil
+}
+
+// encryptOptions returns the repository's encryption options from the ez-env settings
+// ezenv.padding sets the padding bucket size in bytes used to hide file sizes
+// ezenv.deterministic derives the nonce from the content so re-staging an unchanged file is a no-op
+// ezenv.bindPaths binds the file to path, the repository-relative path it is encrypted for
+// ezenv.streamThreshold makes larger files chunked, so the filters can always stream them
+// In passphrase mode the KDF parameters are recorded in every file's header
+func encryptOptions(path string) (crypto.EncryptOptions, error) {
+	var opts crypto.EncryptOptions
+
+	value := settingValue("padding")
+
+	bucket, err := strconv.Atoi(value)
+	if err != nil || bucket < 0 {
+		return opts, fmt.Errorf("invalid ezenv.padding value: %q", value)
+	}
+	opts.PaddingBucket = bucket
+
+	value = settingValue("deterministic")
+	if opts.Deterministic, err = strconv.ParseBool(value); err != nil {
+		return opts, fmt.Errorf("invalid ezenv.deterministic value: %q", value)
+	}
+
+	opts.Format = settingValue("format")
+	bind, err := bindPathsEnabled()
+	if err != nil {
+		return opts, err
+	}
+	// Age files have no header to bind the path in; the format's description says so
+	if bind && opts.Format != crypto.FormatAge {
+		opts.Path = path
+	}
+
+	if opts.ChunkAbove, err = streamThreshold(); err != nil {
+		return opts, err
+	}
+
+	if opts.Format == crypto.FormatAge && (opts.PaddingBucket > 0 || opts.Deterministic) {
+		return opts, fmt.Errorf("ezenv.format=%s cannot be combined with ezenv.padding or ezenv.deterministic", crypto.FormatAge)
+	}
+
+	if crypto.CurrentMode() == crypto.ModePassphrase {
+		config, err := crypto.LoadPassphraseConfig(crypto.PassphraseFile)
+		if err != nil {
+			return opts, err
+		}
+		params, err := config.Params()
+		if err != nil {
+			return opts, err
+		}
+		opts.KDF = &params
+	}
+	return opts, nil
 }
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/dotenv"
+)
+
+// defaultClipboardTimeout is how long a copied value stays on the clipboard
+const defaultClipboardTimeout = 45 * time.Second
+
+// clipboardTool is a command pair that writes to and reads from the system clipboard
+type clipboardTool struct {
+	copy  []string
+	paste []string
+}
+
+// Copy decrypts a dotenv file in memory and puts the value of one variable on the clipboard
+// A background process clears the clipboard after the timeout unless something else was copied since
+func Copy(args []string) error {
+	flags := flag.NewFlagSet("copy", flag.ContinueOnError)
+	timeout := flags.Duration("timeout", defaultClipboardTimeout, "clear the clipboard after this long (0 keeps the value)")
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return fmt.Errorf("usage: git ez-env copy <file> <variable> [--timeout 45s]")
+	}
+	file, name := positional[0], positional[1]
+
+	tool, err := findClipboardTool()
+	if err != nil {
+		return err
+	}
+
+	keys := filterKeys{}
+	defer keys.destroy()
+	content, err := stagedPlaintextFor(context.Background(), keys, file)
+	if err != nil {
+		return err
+	}
+	value, ok := dotenv.Parse(content).Get(name)
+	if !ok {
+		return fmt.Errorf("%s is not set in %s", name, file)
+	}
+
+	if err := runClipboard(tool.copy, []byte(value)); err != nil {
+		return err
+	}
+
+	if *timeout <= 0 {
+		fmt.Printf("✓ Copied %s from %s to the clipboard\n", name, file)
+		return nil
+	}
+
+	// The clearing process only learns a hash of the value, never the value itself
+	sum := sha256.Sum256([]byte(value))
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate ez-env: %w", err)
+	}
+	clearer := exec.Command(exe, "clear-clipboard", timeout.String(), hex.EncodeToString(sum[:]))
+	if err := clearer.Start(); err != nil {
+		return fmt.Errorf("failed to schedule clearing the clipboard: %w", err)
+	}
+	clearer.Process.Release()
+
+	fmt.Printf("✓ Copied %s from %s to the clipboard; it will be cleared in %s\n", name, file, timeout.String())
+	return nil
+}
+
+// ClearClipboard waits and then empties the clipboard if it still holds the value with the given hash
+// It is started in the background by copy and is not meant to be run directly
+func ClearClipboard(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: git ez-env clear-clipboard <delay> <sha256>")
+	}
+	// The delay is passed as a duration so a sub-second timeout is not truncated to zero
+	delay, err := time.ParseDuration(args[0])
+	if err != nil || delay <= 0 {
+		return fmt.Errorf("invalid delay: %s", args[0])
+	}
+	tool, err := findClipboardTool()
+	if err != nil {
+		return err
+	}
+
+	time.Sleep(delay)
+
+	if current, err := exec.Command(tool.paste[0], tool.paste[1:]...).Output(); err == nil {
+		sum := sha256.Sum256(bytes.TrimSuffix(current, []byte("\n")))
+		exact := sha256.Sum256(current)
+		if hex.EncodeToString(sum[:]) != args[1] && hex.EncodeToString(exact[:]) != args[1] {
+			// Something else was copied in the meantime
+			return nil
+		}
+	}
+	return runClipboard(tool.copy, nil)
+}
+
+// findClipboardTool returns the first available clipboard command for this platform
+func findClipboardTool() (clipboardTool, error) {
+	var candidates []clipboardTool
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = []clipboardTool{{copy: []string{"pbcopy"}, paste: []string{"pbpaste"}}}
+	case "windows":
+		candidates = []clipboardTool{{copy: []string{"clip.exe"}, paste: []string{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard"}}}
+	default:
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			candidates = append(candidates, clipboardTool{copy: []string{"wl-copy"}, paste: []string{"wl-paste", "--no-newline"}})
+		}
+		candidates = append(candidates,
+			clipboardTool{copy: []string{"xclip", "-selection", "clipboard"}, paste: []string{"xclip", "-selection", "clipboard", "-o"}},
+			clipboardTool{copy: []string{"xsel", "--clipboard", "--input"}, paste: []string{"xsel", "--clipboard", "--output"}},
+		)
+	}
+
+	for _, candidate := range candidates {
+		if _, err := exec.LookPath(candidate.copy[0]); err == nil {
+			return candidate, nil
+		}
+	}
+	return clipboardTool{}, fmt.Errorf("no clipboard tool found (install pbcopy, wl-copy, xclip or xsel)")
+}
+
+// runClipboard writes data to the clipboard with a copy command
+func runClipboard(command []string, data []byte) error {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to write to the clipboard: %w: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/github"
+)
+
+// postCreateScript is the devcontainer helper that installs ez-env and decrypts the managed files
+// when a codespace is created
+var postCreateScript = filepath.Join(".devcontainer", "ez-env-post-create.sh")
+
+// devcontainerFile is the dev container configuration codespaces are created from
+var devcontainerFile = filepath.Join(".devcontainer", "devcontainer.json")
+
+// postCreateContent returns the helper script for the configured secret name
+func postCreateContent() string {
+	return fmt.Sprintf(`#!/bin/sh
+# Installs ez-env and decrypts the files it manages when a codespace is created
+# %[1]s is the Codespaces secret set by 'git ez-env init --codespaces'
+set -e
+
+if [ -z "$%[1]s" ]; then
+  echo "ez-env: the %[1]s Codespaces secret is not available; files are decrypted through the workflow on the next checkout" >&2
+  exit 0
+fi
+if ! command -v git-ez-env >/dev/null 2>&1; then
+  %[2]s
+  export PATH="$HOME/.local/bin:$PATH"
+fi
+git-ez-env ci unlock
+`, github.SecretName, ciInstallCommand)
+}
+
+// setupCodespaces stores the key as a Codespaces secret and writes the devcontainer helper
+// An existing devcontainer.json is never rewritten since it may hold comments; the command to
+// add to it is printed instead
+func setupCodespaces(ctx context.Context, key []byte) error {
+	if err := github.StoreCodespacesKey(ctx, key); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Encryption key stored as the %s Codespaces secret\n", github.SecretName)
+
+	if err := os.MkdirAll(filepath.Dir(postCreateScript), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(postCreateScript), err)
+	}
+	if err := os.WriteFile(postCreateScript, []byte(postCreateContent()), 0755); err != nil {
+		return fmt.Errorf("failed to write %s: %w", postCreateScript, err)
+	}
+	paths := []string{postCreateScript}
+
+	command := "sh " + filepath.ToSlash(postCreateScript)
+	existing, err := os.ReadFile(devcontainerFile)
+	switch {
+	case os.IsNotExist(err):
+		config := fmt.Sprintf(`{
+  "name": "Codespace",
+  "image": "mcr.microsoft.com/devcontainers/universal:2",
+  "postCreateCommand": %q
+}
+`, command)
+		if err := os.WriteFile(devcontainerFile, []byte(config), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", devcontainerFile, err)
+		}
+		paths = append(paths, devcontainerFile)
+	case err != nil:
+		return fmt.Errorf("failed to read %s: %w", devcontainerFile, err)
+	case !strings.Contains(string(existing), filepath.ToSlash(postCreateScript)):
+		fmt.Printf("Note: add \"postCreateCommand\": %q to %s\n", command, devcontainerFile)
+	}
+
+	if _, err := gitOutput(append([]string{"add", "--"}, paths...)...); err != nil {
+		return fmt.Errorf("failed to add the devcontainer files to git: %w", err)
+	}
+	fmt.Printf("✓ Devcontainer helper written to %s\n", postCreateScript)
+	return nil
+}
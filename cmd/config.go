@@ -0,0 +1,399 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/bitbucket"
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/hosting"
+)
+
+// setting is an ez-env setting that can be read with 'config get' and written with 'config set'
+type setting struct {
+	key         string
+	description string
+	defaultVal  string
+	validate    func(value string) error
+}
+
+// settings are the known ez-env settings; each is stored as ezenv.<key>
+var settings = []setting{
+	{key: "secretName", description: "GitHub secret that stores the shared key", defaultVal: github.DefaultSecretName, validate: validateSecretName},
+	{key: "secretScope", description: "whether the GitHub secret is a repository or an organization secret", defaultVal: github.ScopeRepository, validate: validateSecretScope},
+	{key: "workflowName", description: "file name of the key management workflow", defaultVal: github.DefaultWorkflowName, validate: validateWorkflowName},
+	{key: "remote", description: "git remote that points at the GitHub or Bitbucket repository (unset picks the GitHub remote you administer)", defaultVal: github.DefaultRemoteName, validate: validateNotEmpty},
+	{key: "variableScope", description: "where the key is stored on Bitbucket: repository or workspace variables", defaultVal: bitbucket.ScopeRepository, validate: validateVariableScope},
+	{key: "keychain", description: "cache a key fetched from GitHub in the macOS Keychain, for at most cacheTTL when that is set", defaultVal: "true", validate: validateBool},
+	{key: "cacheTTL", description: "how long a fetched key is cached in the git directory, encrypted to your SSH key (0 disables the cache)", defaultVal: "0", validate: validateDuration},
+	{key: "sessionTimeout", description: "how long the filters of a git command share a retrieved key after its last use (0 disables sharing)", defaultVal: "1m", validate: validateDuration},
+	{key: "failMode", description: "what smudge does when the key cannot be retrieved: fail blocks the checkout, soft checks files out as locked placeholders", defaultVal: failModeFail, validate: validateFailMode},
+	{key: "format", description: "file format: envelope, or age to allow decrypting with the age CLI (no padding, deterministic mode or path binding)", defaultVal: crypto.FormatEnvelope, validate: validateFormat},
+	{key: "padding", description: "padding bucket size in bytes used to hide file sizes (0 disables padding)", defaultVal: "0", validate: validateSize},
+	{key: "streamThreshold", description: "size in bytes above which files are encrypted in chunks and streamed through the filters (0 disables streaming, at least 4096)", defaultVal: "67108864", validate: validateSize},
+	{key: "bindPaths", description: "bind each encrypted file to its path so swapped or moved ciphertext is detected (a file moved with git mv is re-added with git add --renormalize)", defaultVal: "true", validate: validateBool},
+	{key: "deterministic", description: "derive nonces from the content so unchanged files encrypt identically", defaultVal: "false", validate: validateBool},
+	{key: "rotateOnRemoval", description: "key rotation when a collaborator is revoked: always, ask or never", defaultVal: rotateAlways, validate: validateRotationPolicy},
+	{key: "restoreGracePeriod", description: "how long a revoked collaborator can be restored", defaultVal: defaultRestoreGracePeriod.String(), validate: validateDuration},
+	{key: "syncBot", description: "keyring login of the sync workflow's key, which is replaced when collaborators are removed (set by 'sync-keys --install-workflow')", defaultVal: "", validate: validateSyncBot},
+	{key: "inheritParent", description: "in a submodule, use the key and settings of the parent repository", defaultVal: "false", validate: validateBool},
+}
+
+// ownSettings are never inherited from a parent repository: they describe the submodule itself
+var ownSettings = map[string]bool{"inheritParent": true, "remote": true}
+
+const (
+	// Smudge failure modes (ezenv.failMode)
+	failModeFail = "fail"
+	failModeSoft = "soft"
+)
+
+// Config reads and writes ez-env settings
+// Settings are stored in the committed .ezenv.yml; --local writes to .git/config instead,
+// which takes precedence for this clone only
+func Config(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: git ez-env config get|set|unset|list|sync [--local] [<key> [<value>]]")
+	}
+	subcommand := args[0]
+
+	flags := flag.NewFlagSet("config "+subcommand, flag.ContinueOnError)
+	local := flags.Bool("local", false, "use this clone's git config instead of "+RepoConfigFile)
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	args = flags.Args()
+
+	switch subcommand {
+	case "list":
+		for _, s := range settings {
+			value, source := settingSource(s.key)
+			fmt.Printf("%-19s %-26s %-16s %s\n", s.key, value, "("+source+")", s.description)
+		}
+		return nil
+	case "get":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: git ez-env config get <key>")
+		}
+		s, err := lookupSetting(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println(settingValue(s.key))
+		return nil
+	case "set":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: git ez-env config set [--local] <key> <value>")
+		}
+		s, err := lookupSetting(args[0])
+		if err != nil {
+			return err
+		}
+		if err := s.validate(args[1]); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", s.key, err)
+		}
+		if err := writeSetting(*local, "ezenv."+s.key, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("✓ %s = %s\n", s.key, args[1])
+		if s.key == "secretName" || s.key == "workflowName" {
+			fmt.Println("Note: run 'git ez-env init' to regenerate the key management workflow with the new name")
+		}
+		return nil
+	case "unset":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: git ez-env config unset [--local] <key>")
+		}
+		s, err := lookupSetting(args[0])
+		if err != nil {
+			return err
+		}
+		if err := writeSetting(*local, "ezenv."+s.key, ""); err != nil {
+			return err
+		}
+		fmt.Printf("✓ %s reset to %s\n", s.key, settingValue(s.key))
+		return nil
+	case "sync":
+		return syncRepoConfig()
+	}
+	return fmt.Errorf("unknown config command: %s", subcommand)
+}
+
+// ApplySettings points the GitHub integration at the configured secret, workflow and remote,
+// turns the keychain cache on or off, sets how long keys are cached and shared between filters,
+// lets a submodule inherit its parent's key and takes the key mode from .ezenv.yml
+// It is called once at startup; outside a repository the defaults are kept
+func ApplySettings() {
+	// Read first, since the other settings may come from the parent repository
+	crypto.InheritParent = settingValue("inheritParent") == "true"
+	github.SecretName = settingValue("secretName")
+	github.WorkflowName = settingValue("workflowName")
+	remote, source := settingSource("remote")
+	github.RemoteName = remote
+	// Without a configured remote the GitHub remote is picked among all of them
+	github.AutoRemote = source == "default"
+	github.SecretScope = settingValue("secretScope")
+	hosting.VariableScope = settingValue("variableScope")
+	crypto.UseKeychain = settingValue("keychain") == "true"
+	// A hand-edited invalid value leaves the default in place
+	if timeout, err := time.ParseDuration(settingValue("sessionTimeout")); err == nil {
+		crypto.SessionTimeout = timeout
+	}
+	if ttl, err := time.ParseDuration(settingValue("cacheTTL")); err == nil {
+		crypto.CacheTTL = ttl
+	}
+	// A submodule inheriting its parent's key has the parent's key files, so its mode is detected
+	if config, err := loadRepoConfig(); err == nil && !crypto.InheritParent {
+		if mode, ok := config.value(configKeyMode); ok && validateMode(mode) == nil {
+			crypto.ConfiguredMode = mode
+		}
+	}
+}
+
+// settingValue returns the effective value of a setting
+func settingValue(key string) string {
+	value, _ := settingSource(key)
+	return value
+}
+
+// settingSource returns the effective value of a setting and where it came from
+// This clone's git config (including global config) wins over the committed file, which wins over the
+// parent repository's settings when a submodule inherits them, which win over the default
+func settingSource(key string) (string, string) {
+	if value, err := gitOutput("config", "--get", "ezenv."+key); err == nil && value != "" {
+		return value, "git config"
+	}
+	if root, err := repoRoot(); err == nil {
+		if value, ok := committedSetting(root, key); ok {
+			return value, RepoConfigFile
+		}
+	}
+	if parent, ok := crypto.ParentRepo(); ok && !ownSettings[key] {
+		if value, err := gitOutput("-C", parent, "config", "--get", "ezenv."+key); err == nil && value != "" {
+			return value, "parent repository"
+		}
+		if value, ok := committedSetting(parent, key); ok {
+			return value, "parent repository"
+		}
+	}
+	for _, s := range settings {
+		if s.key == key {
+			return s.defaultVal, "default"
+		}
+	}
+	return "", "unknown"
+}
+
+// committedSetting returns a setting committed to the working tree at root, in .ezenv.yml or the
+// legacy settings file
+func committedSetting(root, key string) (string, bool) {
+	if config, err := loadRepoConfigAt(filepath.Join(root, RepoConfigFile)); err == nil {
+		if value, ok := config.value(key); ok && value != "" {
+			return value, true
+		}
+	}
+	path := filepath.Join(root, filepath.FromSlash(legacyRepoConfigFile))
+	if value, err := gitOutput("config", "--file", path, "--get", "ezenv."+key); err == nil && value != "" {
+		return value, true
+	}
+	return "", false
+}
+
+// writeSetting stores or, for an empty value, removes a setting in git config or .ezenv.yml
+// name is the git config name, ezenv.<key>
+func writeSetting(local bool, name, value string) error {
+	if local {
+		if value == "" {
+			// Fails when the setting is not set, which is fine
+			gitOutput("config", "--local", "--unset", name)
+		} else if _, err := gitOutput("config", "--local", name, value); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		return nil
+	}
+
+	config, err := loadRepoConfig()
+	if err != nil {
+		return err
+	}
+	config.set(strings.TrimPrefix(name, "ezenv."), value)
+	if err := config.save(); err != nil {
+		return err
+	}
+	return retireLegacySetting(name)
+}
+
+// retireLegacySetting removes a setting from the legacy settings file, which would otherwise
+// still be read once the setting is removed from .ezenv.yml, and removes the file once it is empty
+func retireLegacySetting(name string) error {
+	root, err := repoRoot()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(root, filepath.FromSlash(legacyRepoConfigFile))
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	// Fails when the setting is not set, which is fine
+	gitOutput("config", "--file", path, "--unset", name)
+	if remaining, _ := gitOutput("config", "--file", path, "--list"); remaining != "" {
+		_, err := gitOutput("-C", root, "add", "--", legacyRepoConfigFile)
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", legacyRepoConfigFile, err)
+	}
+	// Fails when the file was never committed, which is fine
+	gitOutput("-C", root, "rm", "--quiet", "--cached", "--ignore-unmatch", "--", legacyRepoConfigFile)
+	return nil
+}
+
+// syncRepoConfig checks a hand-edited .ezenv.yml and writes .gitattributes from its files
+func syncRepoConfig() error {
+	config, err := loadRepoConfig()
+	if err != nil {
+		return err
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	patterns, err := config.files()
+	if err != nil {
+		return err
+	}
+	if config.node(configKeyFiles) == nil {
+		// Lists the patterns found in .gitattributes, so .ezenv.yml holds them from now on
+		config.setFiles(patterns)
+		if err := config.save(); err != nil {
+			return err
+		}
+	}
+	if err := syncGitAttributes(config); err != nil {
+		return err
+	}
+	fmt.Printf("✓ .gitattributes matches %s (%d pattern(s))\n", RepoConfigFile, len(patterns))
+	fmt.Println("Note: run 'git add --renormalize .' to encrypt files that were added by hand")
+	return nil
+}
+
+// lookupSetting finds a known setting by key, ignoring case like git config does
+func lookupSetting(key string) (setting, error) {
+	key = strings.TrimPrefix(key, "ezenv.")
+	for _, s := range settings {
+		if strings.EqualFold(s.key, key) {
+			return s, nil
+		}
+	}
+	return setting{}, fmt.Errorf("unknown setting %q (run 'git ez-env config list')", key)
+}
+
+// validateNotEmpty rejects empty values
+func validateNotEmpty(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	return nil
+}
+
+// validateSyncBot accepts keyring logins that are safe to write into the sync workflow
+func validateSyncBot(value string) error {
+	if !botLogin.MatchString(value) {
+		return fmt.Errorf("must be a login of letters, digits, '-' and '_'")
+	}
+	return nil
+}
+
+// validateSecretName accepts names GitHub allows for secrets
+func validateSecretName(value string) error {
+	if value == "" || strings.HasPrefix(strings.ToUpper(value), "GITHUB_") || (value[0] >= '0' && value[0] <= '9') {
+		return fmt.Errorf("secret names must not be empty, start with a digit or start with GITHUB_")
+	}
+	for _, c := range value {
+		if !(c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')) {
+			return fmt.Errorf("secret names may only contain letters, digits and underscores")
+		}
+	}
+	return nil
+}
+
+// validateWorkflowName accepts workflow file names
+func validateWorkflowName(value string) error {
+	if strings.ContainsAny(value, "/\\") || !(strings.HasSuffix(value, ".yml") || strings.HasSuffix(value, ".yaml")) {
+		return fmt.Errorf("expected a workflow file name ending in .yml or .yaml")
+	}
+	return nil
+}
+
+// validateDuration accepts Go durations such as 30m or 720h
+func validateDuration(value string) error {
+	if duration, err := time.ParseDuration(value); err != nil || duration < 0 {
+		return fmt.Errorf("expected a duration such as 30m or 8h")
+	}
+	return nil
+}
+
+// validateFailMode accepts the smudge failure modes
+func validateFailMode(value string) error {
+	switch value {
+	case failModeFail, failModeSoft:
+		return nil
+	}
+	return fmt.Errorf("expected %s or %s", failModeFail, failModeSoft)
+}
+
+// validateFormat accepts the file formats
+func validateFormat(value string) error {
+	for _, format := range crypto.Formats {
+		if value == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("expected %s", strings.Join(crypto.Formats, " or "))
+}
+
+// validateSecretScope accepts the GitHub secret scopes
+func validateSecretScope(value string) error {
+	if value != github.ScopeRepository && value != github.ScopeOrganization {
+		return fmt.Errorf("expected %s or %s", github.ScopeRepository, github.ScopeOrganization)
+	}
+	return nil
+}
+
+// validateVariableScope accepts the Bitbucket variable scopes
+func validateVariableScope(value string) error {
+	if value != bitbucket.ScopeRepository && value != bitbucket.ScopeWorkspace {
+		return fmt.Errorf("expected %s or %s", bitbucket.ScopeRepository, bitbucket.ScopeWorkspace)
+	}
+	return nil
+}
+
+// validateSize accepts sizes in bytes
+func validateSize(value string) error {
+	if bucket, err := strconv.Atoi(value); err != nil || bucket < 0 {
+		return fmt.Errorf("expected a size in bytes")
+	}
+	return nil
+}
+
+// validateBool accepts true or false
+func validateBool(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("expected true or false")
+	}
+	return nil
+}
+
+// validateRotationPolicy accepts the rotation policies of revoke
+func validateRotationPolicy(value string) error {
+	switch value {
+	case rotateAlways, rotateAsk, rotateNever:
+		return nil
+	}
+	return fmt.Errorf("expected %s, %s or %s", rotateAlways, rotateAsk, rotateNever)
+}
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/hosting"
+)
+
+// Convert migrates the repository between the shared-key and keyring key management modes
+// The repository key is kept as-is and only re-wrapped, so encrypted files stay valid
+func Convert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	to := flags.String("to", "", "target mode: keyring or shared-key")
+	deleteSecret := flags.Bool("delete-secret", false, "delete the GitHub secret or Bitbucket variable after converting to keyring mode")
+	backend := flags.String("backend", crypto.BackendSSH, "how the keyring wraps the key: ssh, age or gpg")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	current := crypto.CurrentMode()
+	if current == crypto.ModePassphrase {
+		return fmt.Errorf("converting from %s mode is not supported; the key is derived from the passphrase", crypto.ModePassphrase)
+	}
+	switch *to {
+	case crypto.ModeKeyring, crypto.ModeSharedKey:
+	case "":
+		return fmt.Errorf("no target mode specified (use --to keyring or --to shared-key)")
+	default:
+		return fmt.Errorf("unknown mode: %s (use keyring or shared-key)", *to)
+	}
+	if *to == current {
+		return fmt.Errorf("repository is already in %s mode", current)
+	}
+
+	if err := crypto.ValidateBackend(*backend); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if *to == crypto.ModeKeyring {
+		return convertToKeyring(ctx, *backend, *deleteSecret)
+	}
+	return convertToSharedKey(ctx)
+}
+
+// convertToKeyring wraps the shared repository key to every collaborator's SSH keys
+func convertToKeyring(ctx context.Context, backend string, deleteSecret bool) error {
+	keyManager := crypto.NewKeyManager()
+	key, err := keyManager.GetEncryptionKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	defer key.Destroy()
+
+	keyring, err := collaboratorKeyring(ctx, backend)
+	if err != nil {
+		return err
+	}
+
+	if err := keyring.GenerateEncryptedDEKs(key.Bytes(), keyring.Logins()...); err != nil {
+		return err
+	}
+	if err := saveKeyring(keyring); err != nil {
+		return err
+	}
+	if err := recordKeyMode(crypto.ModeKeyring, backend); err != nil {
+		return err
+	}
+
+	// Make sure the current user can still decrypt before retiring the secret
+	if _, err := keyManager.GetKeyringKey(); err != nil {
+		fmt.Printf("Warning: your local key cannot unlock the keyring: %v\n", err)
+	} else if deleteSecret {
+		if err := hosting.DeleteEncryptionKey(ctx); err != nil {
+			return err
+		}
+		fmt.Printf("✓ %s secret deleted\n", hosting.Name())
+	}
+
+	fmt.Printf("✓ Converted to keyring mode (%d keys for %d collaborators)\n", len(keyring.Entries), len(keyring.Logins()))
+	if !deleteSecret {
+		fmt.Printf("Note: the %s secret still exists; delete it with 'gh secret delete %s' once collaborators have pulled the keyring\n", github.SecretName, github.SecretName)
+	}
+	return nil
+}
+
+// convertToSharedKey stores the keyring's data encryption key as the GitHub secret
+func convertToSharedKey(ctx context.Context) error {
+	keyManager := crypto.NewKeyManager()
+	key, err := keyManager.GetKeyringKey()
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	defer key.Destroy()
+
+	if err := hosting.StoreEncryptionKey(ctx, key.Bytes()); err != nil {
+		return err
+	}
+	fmt.Println("✓ Encryption key stored in " + hosting.SecretStore())
+
+	// The workflow distributes the key in shared-key mode
+	if err := writeWorkflowFile(); err != nil {
+		return fmt.Errorf("failed to write workflow file: %w", err)
+	}
+	if err := addWorkflowToGit(); err != nil {
+		return err
+	}
+
+	rmCmd := exec.Command("git", "rm", "--quiet", "--cached", crypto.KeyringFile)
+	if err := rmCmd.Run(); err != nil {
+		return fmt.Errorf("failed to remove %s from git: %w", crypto.KeyringFile, err)
+	}
+	if err := os.Remove(crypto.KeyringFile); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", crypto.KeyringFile, err)
+	}
+	if err := recordKeyMode(crypto.ModeSharedKey, ""); err != nil {
+		return err
+	}
+
+	fmt.Println("✓ Converted to shared-key mode")
+	return nil
+}
+
+// collaboratorKeyring builds a keyring holding the supported SSH keys of every collaborator who can push
+func collaboratorKeyring(ctx context.Context, backend string) (*crypto.Keyring, error) {
+	collaborators, _, err := keyCollaborators(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring := crypto.NewKeyring()
+	if backend != crypto.BackendSSH {
+		keyring.Backend = backend
+	}
+	for _, login := range collaborators {
+		added, err := addCollaboratorKeys(ctx, keyring, login)
+		if err != nil {
+			return nil, err
+		}
+		if added == 0 {
+			fmt.Printf("Warning: %s has no supported keys and will not be able to decrypt\n", login)
+		}
+	}
+	if len(keyring.Entries) == 0 {
+		return nil, fmt.Errorf("no collaborator has a supported key; nobody would be able to decrypt")
+	}
+	return keyring, nil
+}
+
+// addCollaboratorKeys adds every supported key of login to the keyring and returns how many were added
+// GPG keyrings use the user's GPG keys on GitHub and the other backends their SSH keys
+func addCollaboratorKeys(ctx context.Context, keyring *crypto.Keyring, login string) (int, error) {
+	fetchKeys := hosting.GetUserSSHKeys
+	if keyring.WrapBackend() == crypto.BackendGPG {
+		fetchKeys = hosting.GetUserGPGKeys
+	}
+	keys, err := fetchKeys(ctx, login)
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, publicKey := range keys {
+		// Only keys that can wrap the DEK are useful in the keyring
+		if !keyring.CanWrapTo(publicKey) {
+			continue
+		}
+		ok, err := keyring.AddEntry(login, publicKey)
+		if err != nil {
+			return added, err
+		}
+		if ok {
+			added++
+		}
+	}
+	if added > 0 {
+		keyring.MarkGranted(login, time.Now())
+	}
+	return added, nil
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+// With EZENV_DEBUG=1 the filters append a record for every file they process and every key they
+// retrieve to .git/ezenv/debug.log, since stdout belongs to git and the tool running git often
+// hides stderr. Records hold paths, sizes, key sources, durations and errors, never content or keys.
+
+// debugEnv enables the debug log when set to 1
+const debugEnv = "EZENV_DEBUG"
+
+var (
+	debugOnce   sync.Once
+	debugLogger *slog.Logger
+)
+
+// debugLog returns the logger of the debug log, or nil when EZENV_DEBUG is not 1
+func debugLog() *slog.Logger {
+	debugOnce.Do(func() {
+		if os.Getenv(debugEnv) != "1" {
+			return
+		}
+		file, err := openDebugLog()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s is set but the debug log could not be opened: %v\n", debugEnv, err)
+			return
+		}
+		// Records of concurrent filters interleave, so each names its process
+		debugLogger = slog.New(slog.NewTextHandler(file, &slog.HandlerOptions{Level: slog.LevelDebug})).
+			With("pid", os.Getpid())
+	})
+	return debugLogger
+}
+
+// openDebugLog opens the debug log for appending; each record is written at once, so the records
+// of filters running at the same time do not mix
+func openDebugLog() (*os.File, error) {
+	keyPath, err := crypto.LocalKeyPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(keyPath), err)
+	}
+	path := filepath.Join(filepath.Dir(keyPath), "debug.log")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// debugFilter logs a filter operation on the file at path that started at start and read size
+// bytes of content
+func debugFilter(op, path string, size int64, keys filterKeys, start time.Time, err error) {
+	logger := debugLog()
+	if logger == nil {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("file", path),
+		slog.Int64("size", size),
+		slog.String("key_source", keys.source(path)),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	logger.LogAttrs(context.Background(), slog.LevelDebug, op, attrs...)
+}
+
+// debugKey logs the retrieval of the key of environment, empty for the repository key, that
+// started at start
+func debugKey(environment, source string, start time.Time, err error) {
+	logger := debugLog()
+	if logger == nil {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("environment", environment),
+		slog.String("key_source", source),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	logger.LogAttrs(context.Background(), slog.LevelDebug, "key", attrs...)
+}
+
+// countingReader counts the bytes read through it, for the size of streamed files
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read reads from the underlying reader
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/canary"
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/hosting"
+	"github.com/oliviaBahr/ez-env/sidecar"
+	"github.com/oliviaBahr/ez-env/workflows"
+)
+
+// Deinit removes ez-env from the repository, the reverse of init
+// Managed files are decrypted and staged in plaintext, external files are installed from the
+// sidecar store, the ezenv attributes, git config, workflows and metadata are removed, and the
+// GitHub secret is deleted on request
+func Deinit(args []string) error {
+	flags := flag.NewFlagSet("deinit", flag.ContinueOnError)
+	deleteSecret := flags.Bool("delete-secret", false, "also delete the "+github.SecretName+" repository secret")
+	yes := flags.Bool("yes", false, "do not ask for confirmation")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if err := checkGitRepo(); err != nil {
+		return err
+	}
+	mode := crypto.CurrentMode()
+
+	if !*yes {
+		fmt.Println("This decrypts every managed file and stages it in plaintext; committing the result stores the secrets unencrypted.")
+		ok, err := confirm("Remove ez-env from this repository?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("aborted")
+		}
+	}
+
+	entries, err := encryptedIndexEntries()
+	if err != nil {
+		return fmt.Errorf("failed to list encrypted files: %w", err)
+	}
+
+	// Decrypt everything before touching any configuration so a missing key leaves the repository as it was
+	decrypted, err := decryptEntries(entries)
+	if err != nil {
+		return err
+	}
+	// The sidecar store is removed with .ezenv, so its files must be installed in plaintext first
+	if err := installSidecarEntries(); err != nil {
+		return err
+	}
+
+	if err := removeEzenvAttributes(); err != nil {
+		return err
+	}
+	for _, entry := range decrypted {
+		if err := updateIndexEntry(entry); err != nil {
+			return fmt.Errorf("failed to stage %s: %w", entry.Path, err)
+		}
+		fmt.Printf("✓ Decrypted %s\n", entry.Path)
+	}
+
+	for _, path := range []string{
+		workflows.KeyManagementWorkflowPath(),
+		filepath.Join(".github", "workflows", workflows.HealthWorkflow),
+		filepath.Join(".github", "workflows", workflows.CanaryWorkflow),
+		filepath.Join(".github", "workflows", workflows.SyncKeysWorkflow),
+		crypto.KeyringFile,
+		crypto.PassphraseFile,
+		canary.RegistryFile,
+		postCreateScript,
+		RepoConfigFile,
+		".ezenv",
+	} {
+		if err := removeTracked(path); err != nil {
+			return err
+		}
+	}
+
+	for _, section := range []string{"filter.ezenv", "diff.ezenv", "merge.ezenv", "ezenv"} {
+		// Fails when the section does not exist, which is fine
+		gitOutput("config", "--remove-section", section)
+	}
+	fmt.Println("✓ Git filters and ez-env settings removed")
+
+	keyPath, err := crypto.LocalKeyPath()
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Dir(keyPath)); err != nil {
+		return fmt.Errorf("failed to remove local key: %w", err)
+	}
+
+	if *deleteSecret {
+		if err := hosting.DeleteEncryptionKey(context.Background()); err != nil {
+			return err
+		}
+		if github.SecretScope == github.ScopeOrganization && hosting.Current() == hosting.HostGitHub {
+			fmt.Printf("✓ Removed this repository from the %s organization secret\n", github.SecretName)
+		} else {
+			fmt.Printf("✓ Deleted the %s secret\n", github.SecretName)
+		}
+		if hosting.Current() == hosting.HostGitHub {
+			exists, err := github.CodespacesSecretExists(context.Background())
+			if err != nil {
+				return err
+			}
+			if exists {
+				if err := github.DeleteCodespacesKey(context.Background()); err != nil {
+					return err
+				}
+				fmt.Printf("✓ Deleted the %s Codespaces secret\n", github.SecretName)
+			}
+		}
+	}
+
+	fmt.Println("✓ ez-env removed")
+	if data, err := os.ReadFile(devcontainerFile); err == nil && strings.Contains(string(data), filepath.ToSlash(postCreateScript)) {
+		fmt.Printf("Note: remove the ez-env postCreateCommand from %s\n", devcontainerFile)
+	}
+	fmt.Println("Note: review and commit the staged changes; the decrypted files are now stored in plaintext")
+	if !*deleteSecret && mode == crypto.ModeSharedKey {
+		fmt.Printf("Note: the %s secret still exists; delete it with 'gh secret delete %s' once nobody needs it\n", github.SecretName, github.SecretName)
+	}
+	return nil
+}
+
+// decryptEntries decrypts the index blobs of entries and writes the plaintext as new blobs
+// Working tree files that still hold ciphertext are replaced with the plaintext
+func decryptEntries(entries []indexEntry) ([]indexEntry, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	ctx := context.Background()
+	keys := filterKeys{}
+	defer keys.destroy()
+
+	var decrypted []indexEntry
+	for _, entry := range entries {
+		content, err := catFileBlob(entry.Object)
+		if err != nil {
+			return nil, err
+		}
+		if !crypto.IsEncryptedFile(content) {
+			continue
+		}
+		key, err := keys.get(ctx, entry.Path)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := crypto.DecryptFile(content, key.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", entry.Path, err)
+		}
+		if entry.Object, err = writeBlob(plaintext); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", entry.Path, err)
+		}
+		decrypted = append(decrypted, entry)
+
+		if working, err := os.ReadFile(entry.Path); err == nil && crypto.IsEncryptedFile(working) {
+			if err := os.WriteFile(entry.Path, plaintext, 0600); err != nil {
+				return nil, fmt.Errorf("failed to write %s: %w", entry.Path, err)
+			}
+		}
+	}
+	return decrypted, nil
+}
+
+// installSidecarEntries decrypts every sidecar entry to its install path, failing if any entry
+// could not be installed so the only copy of a file is not deleted with the store
+func installSidecarEntries() error {
+	m, err := sidecar.Load(sidecar.MapFile)
+	if err != nil {
+		return err
+	}
+	if len(m.Entries) == 0 {
+		return nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get home directory: %w", err)
+	}
+	root, err := repoRoot()
+	if err != nil {
+		return err
+	}
+	key, err := crypto.NewKeyManager().GetEncryptionKey(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	defer key.Destroy()
+
+	var skipped int
+	for _, entry := range m.Entries {
+		result, err := unlockEntry(entry, key.Bytes(), home, root, false)
+		if err != nil {
+			return err
+		}
+		fmt.Println(result.message)
+		if result.skipped {
+			skipped++
+		}
+	}
+	if skipped > 0 {
+		return fmt.Errorf("%d external file(s) were not installed; resolve them with 'git ez-env unlock --external --force' or 'git ez-env add --external' before removing ez-env", skipped)
+	}
+	return nil
+}
+
+// removeEzenvAttributes removes every .gitattributes line that uses an ezenv driver
+func removeEzenvAttributes() error {
+	content, err := os.ReadFile(".gitattributes")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read .gitattributes: %w", err)
+	}
+
+	var kept []string
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if strings.TrimSpace(line) == "# ezenv encrypted files" || strings.HasPrefix(strings.TrimSpace(line), "# Files will be added here") {
+			continue
+		}
+		if len(fields) > 1 && !strings.HasPrefix(fields[0], "#") && usesEzenvDriver(fields[1:]) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+
+	newContent := strings.TrimSpace(strings.Join(kept, "\n"))
+	if newContent == "" {
+		return removeTracked(".gitattributes")
+	}
+
+	if err := os.WriteFile(".gitattributes", []byte(newContent+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write .gitattributes: %w", err)
+	}
+	if _, err := gitOutput("add", ".gitattributes"); err != nil {
+		return fmt.Errorf("failed to add .gitattributes to git: %w", err)
+	}
+	fmt.Println("✓ ezenv patterns removed from .gitattributes")
+	return nil
+}
+
+// usesEzenvDriver reports whether any attribute selects an ezenv filter, diff or merge driver
+func usesEzenvDriver(attributes []string) bool {
+	for _, attribute := range attributes {
+		switch attribute {
+		case "filter=ezenv", "diff=ezenv", "merge=ezenv":
+			return true
+		}
+	}
+	return false
+}
+
+// removeTracked deletes a file or directory from the index and the working tree if it exists
+func removeTracked(path string) error {
+	tracked, _ := gitOutput("ls-files", "--", path)
+	if _, err := os.Lstat(path); os.IsNotExist(err) && tracked == "" {
+		return nil
+	}
+	if _, err := gitOutput("rm", "-r", "-q", "--cached", "--ignore-unmatch", "--", path); err != nil {
+		return fmt.Errorf("failed to remove %s from git: %w", path, err)
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	fmt.Printf("✓ Removed %s\n", path)
+	return nil
+}
@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/hosting"
+	"github.com/oliviaBahr/ez-env/ssh"
+)
+
+// maxDelegationTTL caps how long a delegated capability can stay valid
+const maxDelegationTTL = 7 * 24 * time.Hour
+
+// Delegate issues a time-limited capability to decrypt selected files, or opens one
+// The time limit is advisory once the capability is issued (see crypto.Capability)
+//
+//	git ez-env delegate --ttl 1h --paths 'staging/*' <login|public-key-file>
+//	git ez-env delegate open <capability> [path]
+func Delegate(args []string) error {
+	if len(args) > 0 && args[0] == "open" {
+		return openDelegation(args[1:])
+	}
+
+	flags := flag.NewFlagSet("delegate", flag.ContinueOnError)
+	ttl := flags.Duration("ttl", time.Hour, "how long ez-env opens the capability; advisory, since the holder can decrypt the files without ez-env")
+	patterns := flags.String("paths", "", "comma-separated path patterns the holder may decrypt")
+	output := flags.String("o", "", "capability file to write (default <recipient>.ezcap)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() < 1 {
+		return fmt.Errorf("no recipient specified (GitHub login or SSH public key file)")
+	}
+	if *patterns == "" {
+		return fmt.Errorf("no paths specified (use --paths)")
+	}
+	if *ttl <= 0 || *ttl > maxDelegationTTL {
+		return fmt.Errorf("--ttl must be between 0 and %s", maxDelegationTTL)
+	}
+
+	ctx := context.Background()
+	subject, authorizedKeys, err := delegationRecipient(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var patternList []string
+	for _, pattern := range strings.Split(*patterns, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid path pattern %q: %w", pattern, err)
+		}
+		patternList = append(patternList, pattern)
+	}
+
+	files, err := delegatedFiles(ctx, patternList)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no managed files match %s", *patterns)
+	}
+
+	now := time.Now()
+	capability, err := crypto.NewCapability(subject, patternList, files, authorizedKeys, now, now.Add(*ttl))
+	if err != nil {
+		return fmt.Errorf("failed to create capability: %w", err)
+	}
+	data, err := capability.Marshal()
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		*output = subject + ".ezcap"
+	}
+	if err := os.WriteFile(*output, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", *output, err)
+	}
+
+	fmt.Printf("✓ Capability for %s written to %s\n", subject, *output)
+	fmt.Printf("  %d file(s), expires %s\n", len(files), capability.ExpiresAt.Local().Format(time.RFC1123))
+	fmt.Println("Note: the capability holds a snapshot of the matched files, and its expiry is only enforced by ez-env; rotate the secrets it holds if it leaks or must not outlive its expiry")
+	return nil
+}
+
+// delegationRecipient resolves a GitHub login or public key file into SSH keys that can hold a capability
+func delegationRecipient(ctx context.Context, recipient string) (string, []string, error) {
+	var subject string
+	var candidates []string
+
+	if data, err := os.ReadFile(recipient); err == nil {
+		subject = strings.TrimSuffix(filepath.Base(recipient), filepath.Ext(recipient))
+		candidates = strings.Split(strings.TrimSpace(string(data)), "\n")
+	} else {
+		keys, err := hosting.GetUserSSHKeys(ctx, recipient)
+		if err != nil {
+			return "", nil, err
+		}
+		subject = recipient
+		candidates = keys
+	}
+
+	var authorizedKeys []string
+	for _, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+		if candidate != "" && crypto.CanWrapTo(candidate) {
+			authorizedKeys = append(authorizedKeys, candidate)
+		}
+	}
+	if len(authorizedKeys) == 0 {
+		return "", nil, fmt.Errorf("%s has no supported (RSA or ed25519) SSH public keys", recipient)
+	}
+	return subject, authorizedKeys, nil
+}
+
+// delegatedFiles decrypts the staged managed files matching any of the patterns
+func delegatedFiles(ctx context.Context, patterns []string) (map[string][]byte, error) {
+	entries, err := encryptedIndexEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]string)
+	var objects []string
+	for _, entry := range entries {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, entry.Path); ok {
+				paths[entry.Object] = entry.Path
+				objects = append(objects, entry.Object)
+				break
+			}
+		}
+	}
+	if len(objects) == 0 {
+		return nil, nil
+	}
+
+	// Files with an ezenv-environment attribute are decrypted with their environment's key
+	keys := filterKeys{}
+	defer keys.destroy()
+
+	files := make(map[string][]byte)
+	err = forEachBlob(objects, func(object string, content []byte) error {
+		plaintext := content
+		if crypto.IsEncryptedFile(content) {
+			key, err := keys.get(ctx, paths[object])
+			if err != nil {
+				return err
+			}
+			if plaintext, err = crypto.DecryptFile(content, key.Bytes()); err != nil {
+				return fmt.Errorf("failed to decrypt %s: %w", paths[object], err)
+			}
+		}
+		files[paths[object]] = plaintext
+		return nil
+	})
+	return files, err
+}
+
+// openDelegation decrypts a capability with the local SSH key
+// Without a path it lists the delegated files, with a path it prints that file
+func openDelegation(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("no capability file specified")
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to read capability: %w", err)
+	}
+	capability, err := crypto.ParseCapability(data)
+	if err != nil {
+		return err
+	}
+
+	privateKey, err := ssh.LoadLocalPrivateKey()
+	if err != nil {
+		return err
+	}
+	files, err := capability.Open(privateKey, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 2 {
+		paths := make([]string, 0, len(files))
+		for path := range files {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		fmt.Printf("Capability for %s, expires %s:\n", capability.Subject, capability.ExpiresAt.Local().Format(time.RFC1123))
+		for _, path := range paths {
+			fmt.Printf("  %s\n", path)
+		}
+		return nil
+	}
+
+	plaintext, ok := files[args[1]]
+	if !ok {
+		return fmt.Errorf("%s is not part of this capability", args[1])
+	}
+	_, err = os.Stdout.Write(plaintext)
+	return err
+}
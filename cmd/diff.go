@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+// Diff decrypts the file at the given path to stdout
+// This is called by Git as the diff.ezenv.textconv driver for git diff, git log -p and git show
+func Diff(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("no file specified")
+	}
+
+	// Git passes a temporary file holding the blob content
+	input, err := os.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+
+	// Content that is not encrypted is shown as-is
+	if !crypto.IsEncryptedFile(input) {
+		if _, err := os.Stdout.Write(input); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+		return nil
+	}
+
+	// The temporary file does not name the path, so the key is found from the envelope header
+	ctx := context.Background()
+	keys := filterKeys{}
+	defer keys.destroy()
+	key, err := keys.forContent(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := crypto.DecryptFile(input, key.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to decrypt content: %w", err)
+	}
+
+	if _, err := os.Stdout.Write(plaintext); err != nil {
+		return fmt.Errorf("failed to write plaintext content: %w", err)
+	}
+
+	return nil
+}
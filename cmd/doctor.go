@@ -0,0 +1,292 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/bitbucket"
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/hosting"
+	"github.com/oliviaBahr/ez-env/version"
+	"github.com/oliviaBahr/ez-env/workflows"
+)
+
+// Doctor diagnoses common setup problems and prints how to fix them
+func Doctor(args []string) error {
+	if err := checkGitRepo(); err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+
+	ctx := context.Background()
+	problems := 0
+	check := func(name string, err error, fix string) {
+		if err == nil {
+			fmt.Printf("✓ %s\n", name)
+			return
+		}
+		problems++
+		fmt.Printf("✗ %s: %v\n", name, err)
+		if fix != "" {
+			fmt.Printf("  → %s\n", fix)
+		}
+	}
+
+	exe, _ := os.Executable()
+	check("git clean filter configured", checkFilter("clean"),
+		fmt.Sprintf("git config filter.ezenv.clean '%s clean %%f'", exe))
+	check("git smudge filter configured", checkFilter("smudge"),
+		fmt.Sprintf("git config filter.ezenv.smudge '%s smudge %%f'", exe))
+	check("git filter process configured", checkFilter("process"),
+		fmt.Sprintf("git config filter.ezenv.process '%s filter-process'", exe))
+	check("git filter marked as required", checkFilterRequired(), "git config filter.ezenv.required true")
+	check("git diff driver configured", checkFilter("diff"),
+		fmt.Sprintf("git config diff.ezenv.textconv '%s diff'", exe))
+	check("git merge driver configured", checkFilter("merge"),
+		fmt.Sprintf("git config merge.ezenv.driver '%s merge %%O %%A %%B %%L %%P'", exe))
+	check(".gitattributes consistent", checkGitAttributes(), "git ez-env add <file>")
+	check(RepoConfigFile+" valid and matching .gitattributes", checkGitAttributesInSync(),
+		"fix "+RepoConfigFile+", then run 'git ez-env config sync'")
+	check("managed paths are regular files", checkManagedFileKinds(),
+		"narrow the .gitattributes pattern so it only matches regular files")
+	check("encrypted files use formats this ez-env supports", checkFormats(),
+		"upgrade ez-env; 'git ez-env version --json' lists the supported formats")
+
+	mode := crypto.CurrentMode()
+	fmt.Printf("Key mode: %s\n", mode)
+	check("key mode in "+RepoConfigFile+" matches the committed key files", checkConfiguredMode(),
+		"run 'git ez-env convert' to change the mode, or correct mode in "+RepoConfigFile)
+	fmt.Printf("ez-env version: %s\n", version.Get(crypto.SupportedFormats).Version)
+
+	if mode == crypto.ModeKeyring {
+		_, err := crypto.NewKeyManager().GetKeyringKey()
+		check("keyring can be unlocked with your SSH key", err, "ask a collaborator to add your SSH key to the keyring")
+	} else if mode == crypto.ModePassphrase {
+		check("passphrase configuration readable", checkPassphraseConfig(),
+			"restore "+crypto.PassphraseFile+" from history; it only holds the KDF parameters")
+	} else if hosting.Current() == hosting.HostBitbucket {
+		bbErr := hosting.CheckAuthentication(ctx)
+		check("Bitbucket API authenticated", bbErr, "set "+bitbucket.TokenEnv+", or "+bitbucket.UsernameEnv+" and "+bitbucket.AppPasswordEnv)
+		if bbErr == nil {
+			check("Bitbucket "+hosting.VariableScope+" variable "+github.SecretName+" exists", checkSecret(ctx),
+				"run 'git ez-env recover' from a machine that holds the key")
+		}
+	} else {
+		ghErr := hosting.CheckAuthentication(ctx)
+		check("GitHub API authenticated", ghErr, "set GITHUB_TOKEN, or install gh from https://cli.github.com and run 'gh auth login'")
+
+		check("key management workflow present and committed", checkWorkflowCommitted(),
+			"git ez-env init, then commit and push "+workflows.KeyManagementWorkflowPath())
+
+		if ghErr == nil {
+			check("GitHub secret "+github.SecretName+" exists", checkSecret(ctx),
+				"run 'git ez-env init' or restore the key with 'git ez-env import-key'")
+			check("GitHub Actions enabled", checkActions(ctx),
+				"enable Actions under Settings → Actions → General")
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%d problem(s) found", problems)
+	}
+	fmt.Println("\n✓ No problems found")
+	return nil
+}
+
+// checkFilter verifies that a filter command is configured and points at an existing binary
+// The diff and merge commands are configured as drivers rather than filters, and the process
+// filter runs the filter-process command
+func checkFilter(name string) error {
+	setting := "filter.ezenv." + name
+	command := name
+	switch name {
+	case "process":
+		command = "filter-process"
+	case "diff":
+		setting = "diff.ezenv.textconv"
+	case "merge":
+		setting = "merge.ezenv.driver"
+	}
+
+	value, err := gitOutput("config", "--get", setting)
+	if err != nil || value == "" {
+		return fmt.Errorf("%s is not set", setting)
+	}
+
+	fields := strings.Fields(value)
+	if _, err := os.Stat(fields[0]); err != nil {
+		return fmt.Errorf("%s points at a missing binary: %s", setting, fields[0])
+	}
+	if len(fields) < 2 || fields[1] != command {
+		return fmt.Errorf("%s does not run the %s command: %s", setting, command, value)
+	}
+	// Without the path the filters cannot bind files to it or detect swapped ciphertext
+	if (name == "clean" || name == "smudge") && (len(fields) < 3 || fields[2] != "%f") {
+		return fmt.Errorf("%s does not pass the file path (%%f): %s", setting, value)
+	}
+	return nil
+}
+
+// checkFilterRequired verifies that git refuses to stage files when the filter fails
+func checkFilterRequired() error {
+	value, _ := gitOutput("config", "--get", "filter.ezenv.required")
+	if value != "true" {
+		return fmt.Errorf("filter.ezenv.required is not true, so a failing filter would stage plaintext")
+	}
+	return nil
+}
+
+// checkGitAttributes verifies that .gitattributes is tracked and its literal paths exist
+func checkGitAttributes() error {
+	content, err := os.ReadFile(".gitattributes")
+	if err != nil {
+		return fmt.Errorf(".gitattributes does not exist")
+	}
+
+	if _, err := gitOutput("ls-files", "--error-unmatch", ".gitattributes"); err != nil {
+		return fmt.Errorf(".gitattributes is not tracked by git")
+	}
+
+	var patterns, missing []string
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || !isPatternLine(line, fields[0]) {
+			continue
+		}
+		patterns = append(patterns, fields[0])
+		if !strings.ContainsAny(fields[0], "*?[") {
+			if _, err := os.Stat(fields[0]); err != nil {
+				missing = append(missing, fields[0])
+			}
+		}
+	}
+
+	if len(patterns) == 0 {
+		return fmt.Errorf("no files are managed by ez-env")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("managed files do not exist: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// checkConfiguredMode verifies that the mode recorded in .ezenv.yml is the one its key files imply
+func checkConfiguredMode() error {
+	if crypto.ConfiguredMode == "" {
+		return nil
+	}
+	if detected := crypto.DetectedMode(); detected != crypto.ConfiguredMode {
+		return fmt.Errorf("%s records %s mode, but the committed key files are those of %s mode", RepoConfigFile, crypto.ConfiguredMode, detected)
+	}
+	return nil
+}
+
+// checkManagedFileKinds verifies that no managed path is a symlink or special file
+func checkManagedFileKinds() error {
+	entries, err := encryptedIndexEntries()
+	if err != nil {
+		return err
+	}
+
+	var odd []string
+	for _, entry := range entries {
+		if kind, description := managedFileKind(entry.Path); kind != kindRegular {
+			odd = append(odd, fmt.Sprintf("%s (%s)", entry.Path, description))
+		}
+	}
+	if len(odd) > 0 {
+		return fmt.Errorf("patterns match non-regular files that are not encrypted: %s", strings.Join(odd, ", "))
+	}
+	return nil
+}
+
+// checkPassphraseConfig verifies the passphrase configuration and that a locally stored key was derived with it
+func checkPassphraseConfig() error {
+	config, err := crypto.LoadPassphraseConfig(crypto.PassphraseFile)
+	if err != nil {
+		return err
+	}
+	if _, err := config.Params(); err != nil {
+		return err
+	}
+	if key, err := crypto.LoadLocalKey(); err == nil && crypto.KeyID(key) != config.KeyID {
+		return fmt.Errorf("the local key %s was not derived from the configured passphrase (expected key %s)", crypto.KeyID(key), config.KeyID)
+	}
+	return nil
+}
+
+// checkFormats verifies that every staged encrypted file uses a format this binary can decrypt
+// A newer format means a collaborator upgraded ez-env and this machine has to follow
+func checkFormats() error {
+	entries, err := encryptedIndexEntries()
+	if err != nil {
+		return err
+	}
+	paths := make(map[string][]string)
+	var objects []string
+	for _, entry := range entries {
+		if entry.Mode == "120000" {
+			continue
+		}
+		if _, ok := paths[entry.Object]; !ok {
+			objects = append(objects, entry.Object)
+		}
+		paths[entry.Object] = append(paths[entry.Object], entry.Path)
+	}
+
+	var unsupported []string
+	err = forEachBlob(objects, func(object string, content []byte) error {
+		if format, ok := crypto.FormatVersion(content); ok && !crypto.IsSupportedFormat(format) {
+			for _, path := range paths[object] {
+				unsupported = append(unsupported, fmt.Sprintf("%s (v%d)", path, format))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("files use unsupported formats: %s", strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// checkWorkflowCommitted verifies that the key management workflow exists and is committed
+func checkWorkflowCommitted() error {
+	workflowPath := workflows.KeyManagementWorkflowPath()
+	if _, err := os.Stat(workflowPath); err != nil {
+		return fmt.Errorf("%s does not exist", workflowPath)
+	}
+	if _, err := gitOutput("cat-file", "-e", "HEAD:"+filepath.ToSlash(workflowPath)); err != nil {
+		return fmt.Errorf("%s is not committed", workflowPath)
+	}
+	return nil
+}
+
+// checkSecret verifies that the encryption key secret exists
+func checkSecret(ctx context.Context) error {
+	exists, err := hosting.SecretExists(ctx)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("secret %s is missing; run 'git ez-env recover' from a machine that holds the key", github.SecretName)
+	}
+	return nil
+}
+
+// checkActions verifies that GitHub Actions can run the key management workflow
+func checkActions(ctx context.Context) error {
+	enabled, err := github.ActionsEnabled(ctx)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return fmt.Errorf("GitHub Actions is disabled for this repository")
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+// Edit decrypts a managed file to a private temporary copy, opens it in the user's editor and
+// re-encrypts the result, so a secret can be changed without it being decrypted in the working tree
+// The temporary copy lives on a memory-backed filesystem when one is available and is overwritten
+// before it is removed
+func Edit(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: git ez-env edit <file>")
+	}
+	file := args[0]
+
+	if err := checkGitRepo(); err != nil {
+		return err
+	}
+	filtered, err := pathsWithFilter([]string{file})
+	if err != nil {
+		return err
+	}
+	if !filtered[file] {
+		return fmt.Errorf("%s is not managed by ez-env; add it with 'git ez-env add %s' first", file, file)
+	}
+
+	ctx := context.Background()
+	keyManager, err := fileKeyManager(file)
+	if err != nil {
+		return err
+	}
+	key, err := keyManager.GetEncryptionKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	defer key.Destroy()
+
+	// The working tree copy is used when it is decrypted since it may hold unstaged changes
+	working, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	lockedWorkingTree := err == nil && crypto.IsEncryptedFile(working)
+	var plaintext []byte
+	switch {
+	case err == nil && !lockedWorkingTree:
+		plaintext = working
+	case lockedWorkingTree:
+		if plaintext, err = crypto.DecryptFile(working, key.Bytes()); err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", file, err)
+		}
+	default:
+		// A file that does not exist yet starts out empty; one that fails to decrypt is not
+		// replaced by whatever is written in the editor
+		plaintext, err = stagedPlaintext(file, key.Bytes())
+		if errors.Is(err, errNotStaged) {
+			plaintext = nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	dir, err := editTempDir()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	tmpPath := filepath.Join(dir, filepath.Base(file))
+	defer shred(tmpPath)
+	if err := os.WriteFile(tmpPath, plaintext, 0600); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	if err := runEditor(tmpPath); err != nil {
+		return err
+	}
+
+	edited, err := os.ReadFile(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to read the edited file: %w", err)
+	}
+	if bytes.Equal(edited, plaintext) {
+		fmt.Printf("No changes to %s\n", file)
+		return nil
+	}
+
+	// A locked working tree keeps holding ciphertext; otherwise the clean filter encrypts on git add
+	content := edited
+	if lockedWorkingTree {
+		opts, err := encryptOptions(repoRelativePath(file))
+		if err != nil {
+			return err
+		}
+		if content, err = crypto.EncryptFileWithOptions(edited, key.Bytes(), opts); err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", file, err)
+		}
+	}
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(file); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(file), err)
+	}
+	if err := os.WriteFile(file, content, mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	if _, err := gitOutput("add", "--", file); err != nil {
+		return fmt.Errorf("failed to stage %s: %w", file, err)
+	}
+
+	fmt.Printf("✓ Re-encrypted and staged %s\n", file)
+	return nil
+}
+
+// editTempDir creates a private directory for a plaintext copy, preferring memory-backed filesystems
+// so the plaintext never reaches the disk; it falls back to the git directory
+func editTempDir() (string, error) {
+	var candidates []string
+	if runtime.GOOS == "linux" {
+		if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+			candidates = append(candidates, dir)
+		}
+		candidates = append(candidates, "/dev/shm")
+	}
+	for _, parent := range candidates {
+		if info, err := os.Stat(parent); err != nil || !info.IsDir() {
+			continue
+		}
+		if dir, err := os.MkdirTemp(parent, "ezenv-edit-"); err == nil {
+			return dir, nil
+		}
+	}
+	return privateTempDir("edit-")
+}
+
+// runEditor opens path in the editor git is configured to use ($GIT_EDITOR, core.editor, $VISUAL, $EDITOR)
+func runEditor(path string) error {
+	editor, err := gitOutput("var", "GIT_EDITOR")
+	if err != nil || editor == "" {
+		return fmt.Errorf("no editor configured; set $EDITOR or git config core.editor")
+	}
+	// The editor is run directly rather than through a shell, so only quoting is interpreted
+	argv, err := splitCommandLine(editor)
+	if err != nil {
+		return fmt.Errorf("invalid editor %q: %w", editor, err)
+	}
+	cmd := exec.Command(argv[0], append(argv[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %s failed: %w", editor, err)
+	}
+	return nil
+}
+
+// splitCommandLine splits a command line into words at unquoted whitespace, honoring single and
+// double quotes and backslash escapes the way a shell does, but without expanding anything
+func splitCommandLine(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			// Inside double quotes a backslash only escapes the characters a shell treats specially
+			if quote == '"' && !strings.ContainsRune(`"\$`+"`", r) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return words, nil
+}
+
+// shred overwrites a file with zeros before removing it
+// Copy-on-write and journaling filesystems may keep old blocks, which is why a tmpfs is preferred
+func shred(path string) {
+	if info, err := os.Stat(path); err == nil {
+		if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+			f.Write(make([]byte, info.Size()))
+			f.Sync()
+			f.Close()
+		}
+	}
+	os.Remove(path)
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/oliviaBahr/ez-env/dotenv"
+)
+
+// Env prints the decrypted variables of tracked dotenv files, e.g. for eval or a deployment tool
+//
+//	eval "$(git ez-env env --export)"
+//	git ez-env env -f config/.env.production --format json
+func Env(args []string) error {
+	flags := flag.NewFlagSet("env", flag.ContinueOnError)
+	var files stringList
+	flags.Var(&files, "f", "dotenv file to load (repeatable, later files win; default all managed dotenv files)")
+	format := flags.String("format", "", "output format: dotenv, json, yaml or shell (default dotenv)")
+	export := flags.Bool("export", false, "print export KEY=... lines for eval (implies --format shell)")
+	strict := flags.Bool("strict", false, "fail instead of skipping variables that violate the allow/deny lists")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q (use -f to choose files)", flags.Arg(0))
+	}
+
+	switch {
+	case *export && *format != "" && *format != dotenv.FormatShell:
+		return fmt.Errorf("--export can only be used with the shell format")
+	case *export:
+		*format = dotenv.FormatShell
+	case *format == "":
+		*format = dotenv.FormatDotenv
+	}
+
+	variables, err := loadDotenvVariables(files, *strict)
+	if err != nil {
+		return err
+	}
+
+	output, err := dotenv.Format(variables, *format, *export)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(output)
+	return err
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+// fileKeyManager returns the key manager for the key path is encrypted with: the key of the
+// environment its ezenv-environment attribute names, or the repository key
+func fileKeyManager(path string) (*crypto.KeyManager, error) {
+	if path == "" {
+		return crypto.NewKeyManager(), nil
+	}
+	environment, err := crypto.FileEnvironment(path)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.NewEnvironmentKeyManager(environment), nil
+}
+
+// filterKey is a key a filter retrieved, or why it could not
+type filterKey struct {
+	key *crypto.SecureBytes
+	err error
+	// source is where the key came from, for the debug log
+	source string
+}
+
+// filterKeys holds the keys a filter retrieved by environment, so a filter process handling many
+// files retrieves each key once, and does not retry a retrieval that already failed for every file
+type filterKeys map[string]filterKey
+
+// get returns the key path is encrypted with, retrieving it on first use; filterKeys owns it
+func (k filterKeys) get(ctx context.Context, path string) (*crypto.SecureBytes, error) {
+	environment := ""
+	if path != "" {
+		var err error
+		if environment, err = crypto.FileEnvironment(path); err != nil {
+			return nil, err
+		}
+	}
+	return k.forEnvironment(ctx, environment)
+}
+
+// forEnvironment returns the key of environment, or the repository key for an empty one,
+// retrieving it on first use; filterKeys owns it
+func (k filterKeys) forEnvironment(ctx context.Context, environment string) (*crypto.SecureBytes, error) {
+	if cached, ok := k[environment]; ok {
+		return cached.key, cached.err
+	}
+	start := time.Now()
+	km := crypto.NewEnvironmentKeyManager(environment)
+	key, err := km.GetEncryptionKey(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	debugKey(environment, km.Source(), start, err)
+	k[environment] = filterKey{key: key, err: err, source: km.Source()}
+	return key, err
+}
+
+// source returns where the key of path came from, or "none" when the filter did not retrieve it,
+// as for files that pass through
+func (k filterKeys) source(path string) string {
+	environment := ""
+	if path != "" {
+		var err error
+		if environment, err = crypto.FileEnvironment(path); err != nil {
+			return "none"
+		}
+	}
+	if cached, ok := k[environment]; ok && cached.err == nil {
+		return cached.source
+	}
+	return "none"
+}
+
+// destroy wipes the retrieved keys
+func (k filterKeys) destroy() {
+	for environment, cached := range k {
+		if cached.key != nil {
+			cached.key.Destroy()
+		}
+		delete(k, environment)
+	}
+}
+
+// forKeyID returns the key with the given key id, for content whose path is unknown such as the
+// temporary file of a textconv or a sealed report: the repository key, or the key of an
+// environment listed in .ezenv.yml; filterKeys owns it
+// Environment keys are only read from this clone, so looking one up never waits for an approval.
+// Without a match the repository key is returned and decrypting with it reports the mismatch
+func (k filterKeys) forKeyID(ctx context.Context, keyID string) (*crypto.SecureBytes, error) {
+	key, err := k.forEnvironment(ctx, "")
+	if err == nil && crypto.KeyID(key.Bytes()) == keyID {
+		return key, nil
+	}
+
+	config, configErr := loadRepoConfig()
+	if configErr != nil {
+		return nil, configErr
+	}
+	environments, configErr := config.environments()
+	if configErr != nil {
+		return nil, configErr
+	}
+	for _, environment := range environments {
+		if cached, ok := k[environment]; ok {
+			if cached.err == nil && crypto.KeyID(cached.key.Bytes()) == keyID {
+				return cached.key, nil
+			}
+			continue
+		}
+		local, loadErr := crypto.LoadEnvironmentKey(environment)
+		if loadErr != nil {
+			continue
+		}
+		if crypto.KeyID(local) != keyID {
+			crypto.Wipe(local)
+			continue
+		}
+		envKey := crypto.SecureBytesFrom(local)
+		k[environment] = filterKey{key: envKey, source: "local key"}
+		return envKey, nil
+	}
+	return key, err
+}
+
+// forContent returns the key that decrypts content whose path is unknown: the key of the path
+// the content is bound to, or else the key its header names
+func (k filterKeys) forContent(ctx context.Context, content []byte) (*crypto.SecureBytes, error) {
+	if path, ok := crypto.EnvelopePath(content); ok {
+		return k.get(ctx, path)
+	}
+	if keyID, ok := crypto.EnvelopeKeyID(content); ok {
+		return k.forKeyID(ctx, keyID)
+	}
+	return k.forEnvironment(ctx, "")
+}
+
+// entriesByEnvironment groups entries by the environment whose key encrypts them, "" for the
+// repository key
+func entriesByEnvironment(entries []indexEntry) (map[string][]indexEntry, error) {
+	groups := make(map[string][]indexEntry)
+	if len(entries) == 0 {
+		return groups, nil
+	}
+	paths := make([]string, len(entries))
+	for i, entry := range entries {
+		paths[i] = entry.Path
+	}
+	environments, err := crypto.FileEnvironments(paths)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		environment := environments[entry.Path]
+		groups[environment] = append(groups[environment], entry)
+	}
+	return groups, nil
+}
+
+// groupNames returns the environments of groups in order, the repository key's "" first
+func groupNames(groups map[string][]indexEntry) []string {
+	names := make([]string, 0, len(groups))
+	for environment := range groups {
+		names = append(names, environment)
+	}
+	sort.Strings(names)
+	return names
+}
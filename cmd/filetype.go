@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// fileKind classifies a managed path so the filters only ever encrypt regular files
+type fileKind int
+
+const (
+	kindRegular fileKind = iota
+	kindSymlink
+	kindSpecial
+)
+
+// managedFileKind determines the kind of path from the index and the working tree
+// The index mode is checked first because with core.symlinks=false a symlink is checked out
+// as a regular file holding the link target
+func managedFileKind(path string) (fileKind, string) {
+	indexMode := ""
+	if output, err := gitOutputRaw(nil, "ls-files", "-s", "-z", "--", path); err == nil {
+		indexMode, _, _ = strings.Cut(string(output), " ")
+	}
+	return fileKindOf(path, indexMode)
+}
+
+// fileKindOf determines the kind of path from its index mode, empty when it is not tracked, and
+// the working tree; commands checking many paths read the index once and call it directly
+func fileKindOf(path, indexMode string) (fileKind, string) {
+	switch indexMode {
+	case "120000":
+		return kindSymlink, "symbolic link"
+	case "160000":
+		return kindSpecial, "submodule"
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		// The filter may run for content that is not in the working tree (e.g. git hash-object --path)
+		return kindRegular, "regular file"
+	}
+
+	mode := info.Mode()
+	switch {
+	case mode.IsRegular():
+		return kindRegular, "regular file"
+	case mode&os.ModeSymlink != 0:
+		return kindSymlink, "symbolic link"
+	case mode.IsDir():
+		return kindSpecial, "directory"
+	case mode&os.ModeNamedPipe != 0:
+		return kindSpecial, "named pipe"
+	case mode&os.ModeSocket != 0:
+		return kindSpecial, "socket"
+	case mode&os.ModeDevice != 0:
+		return kindSpecial, "device file"
+	default:
+		return kindSpecial, fmt.Sprintf("special file (%s)", mode.Type())
+	}
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/pktline"
+)
+
+// filterCapabilities are the filter process capabilities ez-env supports
+var filterCapabilities = []string{"capability=clean", "capability=smudge"}
+
+// FilterProcess is git's long-running filter: one process cleans and smudges every file of a
+// checkout or add over the pkt-line protocol on stdin and stdout, retrieving each key only once
+// instead of once per file as the clean and smudge commands do
+// Files larger than ezenv.streamThreshold are streamed (see streamFilter)
+func FilterProcess(args []string) error {
+	r := pktline.NewReader(os.Stdin)
+	w := pktline.NewWriter(os.Stdout)
+
+	// Handshake: git announces the protocol versions it speaks and the filter picks one
+	hello, err := r.ReadList()
+	if err != nil {
+		return fmt.Errorf("failed to read filter handshake: %w", err)
+	}
+	if len(hello) == 0 || hello[0] != "git-filter-client" || !slices.Contains(hello, "version=2") {
+		return fmt.Errorf("unsupported filter handshake: %q", hello)
+	}
+	if err := w.WriteList("git-filter-server", "version=2"); err != nil {
+		return fmt.Errorf("failed to write filter handshake: %w", err)
+	}
+	offered, err := r.ReadList()
+	if err != nil {
+		return fmt.Errorf("failed to read filter capabilities: %w", err)
+	}
+	var capabilities []string
+	for _, capability := range filterCapabilities {
+		if slices.Contains(offered, capability) {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	if err := w.WriteList(capabilities...); err != nil {
+		return fmt.Errorf("failed to write filter capabilities: %w", err)
+	}
+
+	threshold, err := streamThreshold()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	keys := filterKeys{}
+	defer keys.destroy()
+	staged := &indexBlobs{}
+	defer staged.close()
+	for {
+		headers, err := r.ReadList()
+		if err == io.EOF {
+			// git closes the pipe when it has no more files
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read filter request: %w", err)
+		}
+		command, path := "", ""
+		for _, header := range headers {
+			name, value, _ := strings.Cut(header, "=")
+			switch name {
+			case "command":
+				command = value
+			case "pathname":
+				path = value
+			}
+		}
+		start := time.Now()
+		content := &countingReader{r: r.ContentReader()}
+		input, more, err := readHead(content, threshold)
+		if err != nil {
+			return fmt.Errorf("failed to read content of %s: %w", path, err)
+		}
+		if more {
+			filterErr, err := streamFilter(ctx, w, keys, command, path, input, content)
+			debugFilter(command, path, content.n, keys, start, filterErr)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		var output []byte
+		switch command {
+		case "clean":
+			output, err = cleanContent(ctx, keys, staged, path, input)
+		case "smudge":
+			output, err = smudgeContent(ctx, keys, path, input)
+		default:
+			err = fmt.Errorf("unsupported filter command %q", command)
+		}
+		debugFilter(command, path, content.n, keys, start, err)
+		if err := writeFilterResponse(w, path, output, err); err != nil {
+			return err
+		}
+	}
+}
+
+// writeFilterResponse sends the filtered content of path, or tells git filtering it failed
+// The error is printed here since git only learns that the file failed
+func writeFilterResponse(w *pktline.Writer, path string, output []byte, filterErr error) error {
+	if filterErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", path, filterErr)
+		if err := w.WriteList("status=error"); err != nil {
+			return fmt.Errorf("failed to write filter response: %w", err)
+		}
+		return nil
+	}
+	if err := w.WriteList("status=success"); err != nil {
+		return fmt.Errorf("failed to write filter response: %w", err)
+	}
+	if err := w.WriteContent(output); err != nil {
+		return fmt.Errorf("failed to write filter response: %w", err)
+	}
+	// An empty list keeps the status sent before the content
+	if err := w.WriteList(); err != nil {
+		return fmt.Errorf("failed to write filter response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+// Forget removes the keys cached for the current repository: in the git directory, in the macOS
+// Keychain and in the session agent sharing them between filters, so the next filter run fetches
+// the key through the GitHub workflow again
+func Forget(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: git ez-env forget")
+	}
+	if err := checkGitRepo(); err != nil {
+		return err
+	}
+
+	forgot := false
+	stopped, err := crypto.ForgetSession()
+	if err != nil {
+		return err
+	}
+	if stopped {
+		forgot = true
+		fmt.Println("✓ Stopped the session agent holding the key")
+	}
+
+	cached, err := crypto.ForgetCachedKeys()
+	if err != nil {
+		return err
+	}
+	if cached > 0 {
+		forgot = true
+		fmt.Printf("✓ Removed %d cached key(s) from the git directory\n", cached)
+	}
+
+	removed, err := crypto.DeleteKeychainKey()
+	if err != nil && !errors.Is(err, crypto.ErrKeychainUnavailable) {
+		return err
+	}
+	if removed {
+		forgot = true
+		fmt.Println("✓ Removed the cached key from the keychain")
+	}
+
+	if !forgot {
+		fmt.Println("No key is cached on this machine for this repository; nothing to forget")
+	}
+	return nil
+}
+
+// SessionAgent shares retrieved keys between the filters of a repository until they go unused
+// It is started in the background by the filters and is not meant to be run directly
+func SessionAgent(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: git ez-env session-agent <socket> <timeout>")
+	}
+	timeout, err := time.ParseDuration(args[1])
+	if err != nil || timeout <= 0 {
+		return fmt.Errorf("invalid timeout: %s", args[1])
+	}
+	return crypto.RunSessionAgent(args[0], timeout, func() {
+		// The filter that started the agent waits for this line and then closes the pipe
+		fmt.Println("ready")
+		os.Stdout.Close()
+	})
+}
@@ -0,0 +1,357 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+// gitOutput runs git with the given arguments and returns its trimmed stdout
+func gitOutput(args ...string) (string, error) {
+	output, err := gitOutputRaw(nil, args...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// catFileBlob reads the raw (unfiltered) contents of a blob such as ":2:path" or "HEAD:path"
+func catFileBlob(object string) ([]byte, error) {
+	output, err := gitOutputRaw(nil, "cat-file", "blob", object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", object, err)
+	}
+	return output, nil
+}
+
+// conflictStages returns the index stages (1=base, 2=ours, 3=theirs) present for a conflicted path
+func conflictStages(path string) (map[int]bool, error) {
+	output, err := gitOutput("ls-files", "-u", "--", path)
+	if err != nil {
+		return nil, err
+	}
+
+	stages := make(map[int]bool)
+	for _, line := range strings.Split(output, "\n") {
+		// Format: <mode> <object> <stage>\t<path>
+		fields := strings.Fields(strings.SplitN(line, "\t", 2)[0])
+		if len(fields) != 3 {
+			continue
+		}
+		if stage, err := strconv.Atoi(fields[2]); err == nil {
+			stages[stage] = true
+		}
+	}
+	return stages, nil
+}
+
+// indexEntry is a stage-0 entry of the git index
+type indexEntry struct {
+	Mode   string
+	Object string
+	Path   string
+}
+
+// indexEntries returns the stage-0 index entries matching the optional pathspecs
+func indexEntries(pathspecs ...string) ([]indexEntry, error) {
+	output, err := gitOutputRaw(nil, append([]string{"ls-files", "-s", "-z", "--"}, pathspecs...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []indexEntry
+	for _, record := range strings.Split(string(output), "\x00") {
+		// Format: <mode> <object> <stage>\t<path>
+		meta, path, ok := strings.Cut(record, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 3 || fields[2] != "0" {
+			continue
+		}
+		entries = append(entries, indexEntry{Mode: fields[0], Object: fields[1], Path: path})
+	}
+	return entries, nil
+}
+
+// encryptedIndexEntries returns the index entries whose path has the ezenv filter attribute
+func encryptedIndexEntries() ([]indexEntry, error) {
+	entries, err := indexEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	filtered, err := pathsWithFilter(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []indexEntry
+	for _, entry := range entries {
+		if filtered[entry.Path] {
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
+// pathsWithFilter returns the subset of paths that have filter=ezenv in .gitattributes
+func pathsWithFilter(paths []string) (map[string]bool, error) {
+	input := strings.Join(paths, "\x00") + "\x00"
+	output, err := gitOutputRaw([]byte(input), "check-attr", "-z", "--stdin", "filter")
+	if err != nil {
+		return nil, err
+	}
+
+	// Format: <path> NUL <attribute> NUL <value> NUL
+	filtered := make(map[string]bool)
+	fields := strings.Split(string(output), "\x00")
+	for i := 0; i+2 < len(fields); i += 3 {
+		if fields[i+2] == "ezenv" {
+			filtered[fields[i]] = true
+		}
+	}
+	return filtered, nil
+}
+
+// repoRoot returns the top of the current working tree, where workflows and committed ez-env files live
+func repoRoot() (string, error) {
+	root, err := gitOutput("rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", fmt.Errorf("not a git repository: %w", err)
+	}
+	return root, nil
+}
+
+// repoRelativePath returns path, given relative to the current directory, relative to the top of the
+// working tree with forward slashes, the way git passes %f to filters
+func repoRelativePath(path string) string {
+	prefix, _ := gitOutput("rev-parse", "--show-prefix")
+	return filepath.ToSlash(filepath.Clean(filepath.Join(prefix, path)))
+}
+
+// gitOutputRaw runs git with optional stdin and returns its untrimmed stdout
+func gitOutputRaw(stdin []byte, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return output, nil
+}
+
+// writeBlob stores data in the object database without running any filters
+func writeBlob(data []byte) (string, error) {
+	output, err := gitOutputRaw(data, "hash-object", "-w", "--no-filters", "--stdin")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// updateIndexEntry points the index entry for path at object
+func updateIndexEntry(entry indexEntry) error {
+	_, err := gitOutput("update-index", "--cacheinfo", fmt.Sprintf("%s,%s,%s", entry.Mode, entry.Object, entry.Path))
+	return err
+}
+
+// forEachBlob streams the contents of the given blob objects through a single git cat-file process
+func forEachBlob(objects []string, fn func(object string, content []byte) error) error {
+	if len(objects) == 0 {
+		return nil
+	}
+
+	cmd := exec.Command("git", "cat-file", "--batch")
+	cmd.Stdin = strings.NewReader(strings.Join(objects, "\n") + "\n")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to read blobs: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to read blobs: %w", err)
+	}
+
+	reader := bufio.NewReader(stdout)
+	var fnErr error
+	for range objects {
+		// Format: <object> <type> <size>\n<content>\n
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			fnErr = fmt.Errorf("failed to read blob header: %w", err)
+			break
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			continue // "<object> missing"
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			fnErr = fmt.Errorf("invalid blob header: %q", header)
+			break
+		}
+		content := make([]byte, size+1)
+		if _, err := io.ReadFull(reader, content); err != nil {
+			fnErr = fmt.Errorf("failed to read blob %s: %w", fields[0], err)
+			break
+		}
+		if fields[1] != "blob" {
+			continue
+		}
+		if err := fn(fields[0], content[:size]); err != nil {
+			fnErr = err
+			break
+		}
+	}
+
+	if fnErr != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fnErr
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("failed to read blobs: %w", err)
+	}
+	return nil
+}
+
+// indexBlobs reads the staged blobs of paths through one git cat-file process, started on first use
+// so a filter process looks up every file it cleans without starting git for each
+type indexBlobs struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	err    error
+}
+
+// read returns the raw content staged for path, or false when none is staged or it cannot be read
+func (b *indexBlobs) read(path string) ([]byte, bool) {
+	if b.err != nil || path == "" || strings.Contains(path, "\n") {
+		return nil, false
+	}
+	if b.cmd == nil {
+		if b.err = b.start(); b.err != nil {
+			return nil, false
+		}
+	}
+	if _, b.err = fmt.Fprintf(b.stdin, ":%s\n", path); b.err != nil {
+		return nil, false
+	}
+	// Format: <object> <type> <size>\n<content>\n, or "<object> missing\n"
+	header, err := b.stdout.ReadString('\n')
+	if err != nil {
+		b.err = err
+		return nil, false
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return nil, false
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		b.err = fmt.Errorf("invalid blob header: %q", header)
+		return nil, false
+	}
+	content := make([]byte, size+1)
+	if _, b.err = io.ReadFull(b.stdout, content); b.err != nil {
+		return nil, false
+	}
+	return content[:size], fields[1] == "blob"
+}
+
+// start runs the cat-file process
+func (b *indexBlobs) start() error {
+	b.cmd = exec.Command("git", "cat-file", "--batch")
+	stdin, err := b.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := b.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := b.cmd.Start(); err != nil {
+		return fmt.Errorf("failed to read the index: %w", err)
+	}
+	b.stdin, b.stdout = stdin, bufio.NewReader(stdout)
+	return nil
+}
+
+// close stops the cat-file process, if one was started
+func (b *indexBlobs) close() {
+	if b.stdin != nil {
+		b.stdin.Close()
+		b.cmd.Wait()
+	}
+}
+
+// stagedBlob streams the raw content staged for path through git cat-file, whose -p streams large
+// blobs instead of loading them; it is empty when nothing is staged, and closing it reports whether
+// it could be read
+func stagedBlob(path string) io.ReadCloser {
+	if path == "" {
+		return io.NopCloser(strings.NewReader(""))
+	}
+	cmd := exec.Command("git", "cat-file", "-p", ":"+path)
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		return io.NopCloser(strings.NewReader(""))
+	}
+	return &commandReader{ReadCloser: stdout, cmd: cmd}
+}
+
+// commandReader reads the output of a command and waits for it when closed
+type commandReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+// Close stops reading and waits for the command, which exits early if its output was not read
+func (c *commandReader) Close() error {
+	c.ReadCloser.Close()
+	return c.cmd.Wait()
+}
+
+// privateTempDir creates a temporary directory for plaintext inside the git directory
+// The caller removes it when done
+func privateTempDir(prefix string) (string, error) {
+	keyPath, err := crypto.LocalKeyPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return "", fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(keyPath), prefix)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	return dir, nil
+}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/canonical"
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/hosting"
+	"github.com/oliviaBahr/ez-env/workflows"
+)
+
+// healthReport summarizes the secret hygiene of a repository
+type healthReport struct {
+	Repository     string     `json:"repository,omitempty"`
+	Mode           string     `json:"mode"`
+	TrackedFiles   int        `json:"tracked_files"`
+	EncryptedFiles int        `json:"encrypted_files"`
+	Coverage       float64    `json:"coverage"`
+	PlaintextFiles []string   `json:"plaintext_files"`
+	LastRotation   *time.Time `json:"last_rotation,omitempty"`
+	GeneratedAt    time.Time  `json:"generated_at"`
+}
+
+// badge is a shields.io endpoint badge (https://shields.io/badges/endpoint-badge)
+type badge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+}
+
+// Health reports encryption coverage and the last key rotation, optionally as JSON and a badge
+func Health(args []string) error {
+	flags := flag.NewFlagSet("health", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	output := flags.String("o", "", "write the report to this file instead of stdout")
+	badgePath := flags.String("badge", "", "write a shields.io endpoint badge to this file")
+	installWorkflow := flags.Bool("install-workflow", false, "add a scheduled workflow that publishes the report as an artifact")
+	writer := newSecureWriter(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *installWorkflow {
+		return installHealthWorkflow()
+	}
+
+	report, err := buildHealthReport(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if *badgePath != "" {
+		if err := writeJSON(*badgePath, healthBadge(report)); err != nil {
+			return err
+		}
+	}
+
+	if *asJSON {
+		data, err := canonical.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("failed to encode report: %w", err)
+		}
+		// Report files are encrypted unless --plaintext is given; the badge is public by design
+		if *output != "" {
+			return writer.WriteFile(*output, data)
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	fmt.Printf("Mode:          %s\n", report.Mode)
+	fmt.Printf("Coverage:      %.1f%% (%d/%d files encrypted)\n", report.Coverage, report.EncryptedFiles, report.TrackedFiles)
+	if report.LastRotation != nil {
+		fmt.Printf("Last rotation: %s\n", report.LastRotation.Format(time.RFC3339))
+	} else {
+		fmt.Println("Last rotation: unknown")
+	}
+	for _, path := range report.PlaintextFiles {
+		fmt.Printf("  ✗ %s is committed in plaintext\n", path)
+	}
+	return nil
+}
+
+// buildHealthReport inspects the index blobs of every ezenv-managed file
+func buildHealthReport(ctx context.Context) (*healthReport, error) {
+	entries, err := encryptedIndexEntries()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list encrypted files: %w", err)
+	}
+
+	report := &healthReport{
+		Mode:           crypto.CurrentMode(),
+		TrackedFiles:   len(entries),
+		PlaintextFiles: []string{},
+		Coverage:       100,
+		GeneratedAt:    time.Now().UTC(),
+	}
+
+	if owner, repo, err := hosting.GetRepositoryInfo(); err == nil {
+		report.Repository = owner + "/" + repo
+	}
+
+	for _, entry := range entries {
+		content, err := catFileBlob(entry.Object)
+		if err != nil {
+			return nil, err
+		}
+		if crypto.IsEncryptedFile(content) {
+			report.EncryptedFiles++
+		} else {
+			report.PlaintextFiles = append(report.PlaintextFiles, entry.Path)
+		}
+	}
+	if report.TrackedFiles > 0 {
+		report.Coverage = float64(report.EncryptedFiles) * 100 / float64(report.TrackedFiles)
+	}
+
+	if rotated, err := lastRotation(ctx, report.Mode); err == nil {
+		report.LastRotation = &rotated
+	}
+
+	return report, nil
+}
+
+// lastRotation returns when the repository key was last replaced
+// In shared-key mode this is the secret's update time, in keyring mode the last keyring commit
+func lastRotation(ctx context.Context, mode string) (time.Time, error) {
+	if mode == crypto.ModeSharedKey {
+		return hosting.GetSecretUpdatedAt(ctx)
+	}
+
+	output, err := gitOutput("log", "-1", "--format=%cI", "--", crypto.KeyringFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, output)
+}
+
+// healthBadge renders the coverage as a shields.io endpoint badge
+func healthBadge(report *healthReport) badge {
+	color := "brightgreen"
+	switch {
+	case report.Coverage < 100:
+		color = "red"
+	case report.LastRotation == nil || time.Since(*report.LastRotation) > 180*24*time.Hour:
+		color = "yellow"
+	}
+
+	return badge{
+		SchemaVersion: 1,
+		Label:         "secrets",
+		Message:       fmt.Sprintf("%.0f%% encrypted", report.Coverage),
+		Color:         color,
+	}
+}
+
+// writeJSON writes v as canonical JSON to path
+func writeJSON(path string, v interface{}) error {
+	return canonical.WriteFile(path, v, 0644)
+}
+
+// healthTokenSecret is the repository secret the health workflow reads the shared key's update time with
+const healthTokenSecret = "EZENV_HEALTH_TOKEN"
+
+// installHealthWorkflow adds the scheduled health workflow to the repository
+func installHealthWorkflow() error {
+	ref, err := pinnedRef()
+	if err != nil {
+		return err
+	}
+	repoPath, err := repoRoot()
+	if err != nil {
+		return err
+	}
+
+	if err := workflows.WriteHealthWorkflowFile(repoPath, ref); err != nil {
+		return err
+	}
+
+	addCmd := exec.Command("git", "add", ".github/workflows/"+workflows.HealthWorkflow)
+	if err := addCmd.Run(); err != nil {
+		return fmt.Errorf("failed to add workflow to git: %w", err)
+	}
+
+	fmt.Println("✓ Health workflow created")
+	fmt.Println("Note: commit and push to publish a weekly ezenv-health artifact")
+	if crypto.CurrentMode() == crypto.ModeSharedKey {
+		fmt.Printf("Note: the workflow token cannot read secrets; store a token with Secrets read access as the %s secret so the report includes the last key rotation\n", healthTokenSecret)
+	}
+	return nil
+}
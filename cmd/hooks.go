@@ -0,0 +1,397 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/dotenv"
+)
+
+// hookMarker identifies hook scripts written by ez-env so they can be updated safely
+const hookMarker = "# Installed by git ez-env install-hooks"
+
+// InstallHooks installs pre-commit and pre-push hooks that refuse to commit or push managed files
+// in plaintext
+// The clean filter is skipped silently when it is not configured, e.g. in a fresh clone, so the
+// hooks check the blobs themselves
+// With --refresh, post-merge and post-checkout hooks re-run the smudge filter on managed files
+// that changed but are still encrypted, so a pull never leaves ciphertext in the working tree
+func InstallHooks(args []string) error {
+	flags := flag.NewFlagSet("install-hooks", flag.ContinueOnError)
+	force := flags.Bool("force", false, "replace existing hooks that were not installed by ez-env")
+	refresh := flags.Bool("refresh", false, "also install post-merge and post-checkout hooks that refresh decrypted files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if err := checkGitRepo(); err != nil {
+		return err
+	}
+
+	if err := writeHook("pre-commit", "pre-commit-check", "Blocks commits that would store files managed by ez-env in plaintext", *force); err != nil {
+		return err
+	}
+	if err := writeHook("pre-push", "pre-push-check", "Blocks pushes of commits that store files managed by ez-env in plaintext", *force); err != nil {
+		return err
+	}
+	if !*refresh {
+		return nil
+	}
+	if err := writeHook("post-merge", "post-merge-refresh", "Refreshes decrypted files changed by a merge or pull", *force); err != nil {
+		return err
+	}
+	return writeHook("post-checkout", "post-checkout-refresh", "Refreshes decrypted files changed by switching branches", *force)
+}
+
+// CheckStaged fails when a staged blob of an ezenv-managed path is plaintext, which catches clones
+// where the filter was never configured, or is ciphertext bound to another path, as happens when a
+// bound file is renamed with git mv
+func CheckStaged(args []string) error {
+	flags := flag.NewFlagSet("check-staged", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := checkGitRepo(); err != nil {
+		return err
+	}
+
+	count, err := checkStagedBlobs()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ %d staged managed file(s) are encrypted\n", count)
+	return nil
+}
+
+// PreCommitCheck is CheckStaged without output when the staged files pass
+// It is run by the pre-commit hook and is not meant to be run directly
+func PreCommitCheck(args []string) error {
+	_, err := checkStagedBlobs()
+	return err
+}
+
+// checkStagedBlobs reports the staged entries of managed paths that would be stored unencrypted or
+// fail to check out, and returns how many entries it checked
+func checkStagedBlobs() (int, error) {
+	checked, plaintext, moved, err := stagedProblemEntries()
+	if err != nil {
+		return 0, err
+	}
+	if len(plaintext) == 0 && len(moved) == 0 {
+		return checked, nil
+	}
+
+	for _, entry := range plaintext {
+		fmt.Fprintf(os.Stderr, "✗ %s is staged in plaintext\n", entry.Path)
+	}
+	if len(plaintext) > 0 {
+		fmt.Fprintln(os.Stderr, "  → the ez-env clean filter did not run; run 'git ez-env doctor', then 'git add --renormalize .' and commit again")
+		return 0, fmt.Errorf("commit blocked: %d managed file(s) would be committed unencrypted", len(plaintext))
+	}
+
+	for _, entry := range moved {
+		fmt.Fprintf(os.Stderr, "✗ %s is staged with ciphertext encrypted for another path\n", entry.Path)
+		fmt.Fprintf(os.Stderr, "  → run 'git add --renormalize %s' to encrypt it for its new path\n", entry.Path)
+	}
+	return 0, fmt.Errorf("commit blocked: %d managed file(s) would fail to check out at their path", len(moved))
+}
+
+// PrePushCheck fails when a pushed commit that the remote does not have yet stores a managed file
+// in plaintext, which the pre-commit hook cannot catch for commits made before it was installed
+// Paths are managed according to the current attributes, like scan-history
+// It is run by the pre-push hook with the hook's arguments and the updated refs on stdin, and is
+// not meant to be run directly
+func PrePushCheck(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: git ez-env pre-push-check <remote> [<url>]")
+	}
+	remote := args[0]
+
+	// Format: <local ref> SP <local object> SP <remote ref> SP <remote object> LF
+	var leaks []historyRevision
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 || isNullObject(fields[1]) {
+			continue // deleted refs push nothing
+		}
+		revs := []string{fields[1]}
+		if isNullObject(fields[3]) || !commitExists(fields[3]) {
+			// A new ref, or a remote tip this clone has not fetched, like git's sample pre-push hook
+			revs = append(revs, "--not", "--remotes="+remote)
+		} else {
+			revs = append(revs, "^"+fields[3])
+		}
+		found, err := pushedPlaintext(revs)
+		if err != nil {
+			return err
+		}
+		leaks = append(leaks, found...)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read pushed refs: %w", err)
+	}
+	if len(leaks) == 0 {
+		return nil
+	}
+
+	reported := make(map[string]bool)
+	for _, leak := range leaks {
+		if key := leak.commit + "\x00" + leak.path; !reported[key] {
+			reported[key] = true
+			fmt.Fprintf(os.Stderr, "✗ %s is committed in plaintext in %s\n", leak.path, leak.commit[:12])
+		}
+	}
+	fmt.Fprintln(os.Stderr, "  → rewrite those commits with the file encrypted, e.g. 'git add --renormalize <path>' and 'git commit --amend' for the last one")
+	return fmt.Errorf("push blocked: %d revision(s) of managed files would be pushed unencrypted", len(reported))
+}
+
+// pushedPlaintext returns the revisions of managed files in the commits selected by revs whose blob
+// is not ciphertext
+func pushedPlaintext(revs []string) ([]historyRevision, error) {
+	revisions, err := historyRevisions(revs...)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, revision := range revisions {
+		paths = append(paths, revision.path)
+	}
+	paths = uniqueStrings(paths)
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	managed, err := pathsWithFilter(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []string
+	for _, revision := range revisions {
+		if managed[revision.path] {
+			objects = append(objects, revision.blob)
+		}
+	}
+	plaintext := make(map[string]bool)
+	err = forEachBlob(uniqueStrings(objects), func(object string, content []byte) error {
+		// Empty files hold nothing to leak
+		if len(content) > 0 && !crypto.IsEncryptedFile(content) {
+			plaintext[object] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var leaks []historyRevision
+	for _, revision := range revisions {
+		if managed[revision.path] && plaintext[revision.blob] {
+			leaks = append(leaks, revision)
+		}
+	}
+	return leaks, nil
+}
+
+// commitExists reports whether object names a commit in the local object database
+func commitExists(object string) bool {
+	_, err := gitOutput("cat-file", "-e", object+"^{commit}")
+	return err == nil
+}
+
+// isNullObject reports whether object is the all-zero object id git uses for a missing ref
+func isNullObject(object string) bool {
+	return strings.Trim(object, "0") == ""
+}
+
+// stagedProblemEntries returns how many staged entries have the ezenv filter attribute, those whose
+// blob is not ciphertext, and those whose blob is bound to a different path
+// The attributes are read from the index so a staged .gitattributes change is taken into account
+func stagedProblemEntries() (int, []indexEntry, []indexEntry, error) {
+	entries, err := indexEntries()
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("failed to list staged files: %w", err)
+	}
+	var paths []string
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	if len(paths) == 0 {
+		return 0, nil, nil, nil
+	}
+
+	output, err := gitOutputRaw([]byte(strings.Join(paths, "\x00")+"\x00"), "check-attr", "--cached", "-z", "--stdin", "filter")
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("failed to read attributes: %w", err)
+	}
+	// Format: <path> NUL <attribute> NUL <value> NUL
+	managed := make(map[string]bool)
+	fields := strings.Split(string(output), "\x00")
+	for i := 0; i+2 < len(fields); i += 3 {
+		if fields[i+2] == "ezenv" {
+			managed[fields[i]] = true
+		}
+	}
+
+	byObject := make(map[string][]indexEntry)
+	var objects []string
+	checked := 0
+	for _, entry := range entries {
+		// Symlinks are stored as link targets and never encrypted
+		if !managed[entry.Path] || entry.Mode == "120000" || entry.Mode == "160000" {
+			continue
+		}
+		checked++
+		if _, ok := byObject[entry.Object]; !ok {
+			objects = append(objects, entry.Object)
+		}
+		byObject[entry.Object] = append(byObject[entry.Object], entry)
+	}
+
+	var plaintext, moved []indexEntry
+	err = forEachBlob(objects, func(object string, content []byte) error {
+		// Empty files hold nothing to leak
+		if len(content) > 0 && !crypto.IsEncryptedFile(content) {
+			plaintext = append(plaintext, byObject[object]...)
+			return nil
+		}
+		if bound, ok := crypto.EnvelopePath(content); ok {
+			for _, entry := range byObject[object] {
+				if entry.Path != bound {
+					moved = append(moved, entry)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return checked, plaintext, moved, nil
+}
+
+// RefreshHook re-checks out managed files after a merge or branch checkout so they pass through the smudge filter
+// It is run by the post-merge and post-checkout hooks with the hook's arguments and is not meant to be run directly
+// Failures only print a warning because the merge or checkout has already happened
+func RefreshHook(hook string, args []string) error {
+	var from, to string
+	switch hook {
+	case "post-checkout":
+		// Arguments: <previous HEAD> <new HEAD> <1 for a branch checkout, 0 for a file checkout>
+		// File checkouts are skipped, which includes the checkout done by the refresh itself
+		if len(args) < 3 || args[2] != "1" {
+			return nil
+		}
+		from, to = args[0], args[1]
+	case "post-merge":
+		from, to = "ORIG_HEAD", "HEAD"
+	default:
+		return fmt.Errorf("unknown hook: %s", hook)
+	}
+
+	paths, err := staleManagedFiles(from, to)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ez-env could not check decrypted files: %v\n", err)
+		return nil
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	if smudge, _ := gitOutput("config", "--get", "filter.ezenv.smudge"); smudge == "" {
+		fmt.Fprintln(os.Stderr, "Warning: the ez-env filters are not configured; run 'git ez-env init' or 'git ez-env ci unlock' to decrypt files")
+		return nil
+	}
+	// Without a key the smudge filter would leave the files encrypted again
+	if _, err := crypto.NewKeyManager().GetEncryptionKey(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ez-env cannot decrypt %d encrypted file(s): %v\n", len(paths), err)
+		return nil
+	}
+	if err := refreshWorkingFiles(paths); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return nil
+	}
+	fmt.Printf("✓ ez-env refreshed %d decrypted file(s)\n", len(paths))
+	return nil
+}
+
+// staleManagedFiles returns the managed files that changed between two revisions and are still ciphertext
+// in the working tree, i.e. the smudge filter did not run or failed when git wrote them
+// Files that were decrypted correctly are left alone so the refresh does not touch their stat data
+func staleManagedFiles(from, to string) ([]string, error) {
+	entries, err := encryptedIndexEntries()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	var changed map[string]bool
+	// The previous HEAD is missing, or the null object, after a clone or an orphan checkout; every file is checked then
+	if _, err := gitOutput("rev-parse", "--verify", "--quiet", from+"^{commit}"); err == nil {
+		output, err := gitOutputRaw(nil, "diff", "--name-only", "-z", from, to, "--")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list changed files: %w", err)
+		}
+		changed = make(map[string]bool)
+		for _, path := range strings.Split(string(output), "\x00") {
+			if path != "" {
+				changed[path] = true
+			}
+		}
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if entry.Mode == "120000" || entry.Mode == "160000" || (changed != nil && !changed[entry.Path]) {
+			continue
+		}
+		content, err := os.ReadFile(entry.Path)
+		if err != nil {
+			continue
+		}
+		if crypto.IsEncryptedFile(content) {
+			paths = append(paths, entry.Path)
+		}
+	}
+	return paths, nil
+}
+
+// writeHook writes an executable hook script that runs an ez-env command with the hook's arguments,
+// refusing to replace foreign hooks unless force is set
+func writeHook(name, command, description string, force bool) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate ez-env: %w", err)
+	}
+	hooksDir, err := gitOutput("rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return fmt.Errorf("failed to locate the hooks directory: %w", err)
+	}
+	path := filepath.Join(hooksDir, name)
+
+	if existing, err := os.ReadFile(path); err == nil && !strings.Contains(string(existing), hookMarker) && !force {
+		return fmt.Errorf("%s already exists; use --force to replace it or add 'git ez-env %s \"$@\"' to it", path, command)
+	}
+
+	script := fmt.Sprintf("#!/bin/sh\n%s\n# %s\nexec %s %s \"$@\"\n",
+		hookMarker, description, dotenv.ShellQuote(filepath.ToSlash(exe)), command)
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", hooksDir, err)
+	}
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0755); err != nil {
+		return fmt.Errorf("failed to make %s executable: %w", path, err)
+	}
+	fmt.Printf("✓ Installed %s hook\n", name)
+	return nil
+}
@@ -2,50 +2,155 @@ package cmd
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/hosting"
 	"github.com/oliviaBahr/ez-env/workflows"
 )
 
 // Init initializes ezenv in the current repository
+// The default shared-key mode keeps the key in a GitHub secret; --mode keyring wraps a new key to
+// the collaborators' SSH keys instead, with the backend chosen by --backend, and --mode passphrase
+// derives the key from a passphrase so neither GitHub nor collaborators are involved
 func Init(args []string) error {
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	mode := flags.String("mode", crypto.ModeSharedKey, "key management mode: shared-key, keyring or passphrase")
+	backend := flags.String("backend", crypto.BackendSSH, "how a keyring wraps the key: ssh, age or gpg")
+	noKeychain := flags.Bool("no-keychain", false, "never cache the key in the macOS Keychain on this clone")
+	orgSecret := flags.Bool("org-secret", false, "store the shared key as a GitHub organization secret shared with selected repositories")
+	environment := flags.String("environment", "", "create or fetch the key of this GitHub Environment for files added with --environment")
+	codespaces := flags.Bool("codespaces", false, "also store the key as a Codespaces secret and add a devcontainer helper that decrypts files")
+	updateWorkflow := flags.Bool("update-workflow", false, "only rewrite the key management workflow with the version of this ez-env")
+	requireApproval := flags.Bool("require-approval", false, "key requests from collaborators below maintain wait for a reviewer's approval")
+	approvers := flags.String("approvers", "", "comma-separated logins that approve key requests (default the admins and maintainers)")
+	inheritParent := flags.Bool("inherit-parent", false, "in a submodule, use the key and settings of the parent repository")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *inheritParent {
+		return initInheritParent()
+	}
+	if *requireApproval && (*mode != crypto.ModeSharedKey || hosting.Current() != hosting.HostGitHub) {
+		return fmt.Errorf("--require-approval only applies to shared-key mode on GitHub")
+	}
+	if *updateWorkflow {
+		if err := checkGitRepo(); err != nil {
+			return fmt.Errorf("not a git repository: %w", err)
+		}
+		if err := writeWorkflowFile(); err != nil {
+			return fmt.Errorf("failed to write workflow file: %w", err)
+		}
+		if err := addWorkflowToGit(); err != nil {
+			return err
+		}
+		if *requireApproval {
+			if err := protectKeyRequests(context.Background(), *approvers); err != nil {
+				return err
+			}
+		}
+		fmt.Println("Note: commit and push the workflow so key requests use it")
+		return nil
+	}
+	switch *mode {
+	case crypto.ModeSharedKey, crypto.ModeKeyring, crypto.ModePassphrase:
+	default:
+		return fmt.Errorf("unknown mode: %s (use shared-key, keyring or passphrase)", *mode)
+	}
+	if err := crypto.ValidateBackend(*backend); err != nil {
+		return err
+	}
+
 	// Check if we're in a git repository
 	if err := checkGitRepo(); err != nil {
 		return fmt.Errorf("not a git repository: %w", err)
 	}
+	if *noKeychain {
+		if err := writeSetting(true, "ezenv.keychain", "false"); err != nil {
+			return err
+		}
+		crypto.UseKeychain = false
+	}
+
+	if *orgSecret {
+		if *mode != crypto.ModeSharedKey {
+			return fmt.Errorf("--org-secret only applies to shared-key mode")
+		}
+		if hosting.Current() != hosting.HostGitHub {
+			return fmt.Errorf("--org-secret needs a GitHub remote; on Bitbucket set variableScope to workspace instead")
+		}
+		// Committed so every clone looks the key up in the organization
+		if err := writeSetting(false, "ezenv.secretScope", github.ScopeOrganization); err != nil {
+			return err
+		}
+		github.SecretScope = github.ScopeOrganization
+	}
+
+	if *codespaces {
+		if *mode != crypto.ModeSharedKey || *environment != "" {
+			return fmt.Errorf("--codespaces only applies to shared-key mode without --environment")
+		}
+		if hosting.Current() != hosting.HostGitHub {
+			return fmt.Errorf("--codespaces needs a GitHub remote")
+		}
+	}
 
 	ctx := context.Background()
+	switch *mode {
+	case crypto.ModeSharedKey:
+		// The secret is stored and the workflow committed
+		if err := checkGitHubAccess(ctx, "repo", "workflow"); err != nil {
+			return err
+		}
+	case crypto.ModeKeyring:
+		// The collaborators and their keys are looked up
+		if err := checkGitHubAccess(ctx, "repo"); err != nil {
+			return err
+		}
+	}
+
+	if *environment != "" {
+		if *mode != crypto.ModeSharedKey || *orgSecret {
+			return fmt.Errorf("--environment only applies to shared-key mode with repository secrets")
+		}
+		return initEnvironment(ctx, *environment)
+	}
+
+	switch *mode {
+	case crypto.ModeKeyring:
+		return initKeyring(ctx, *backend)
+	case crypto.ModePassphrase:
+		return initPassphrase()
+	}
+	if hosting.Current() == hosting.HostBitbucket {
+		return initBitbucket(ctx)
+	}
 
 	// Create key manager and get/create encryption key
 	fmt.Println("Setting up ez-env with GitHub Actions workflow-based key management...")
+	if err := checkSecretNotLost(ctx); err != nil {
+		return err
+	}
 	keyManager := crypto.NewKeyManager()
 	key, err := keyManager.GetOrCreateEncryptionKey(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get or create encryption key: %w", err)
 	}
+	defer key.Destroy()
 
 	// Write the workflow file to the repository
 	if err := writeWorkflowFile(); err != nil {
 		return fmt.Errorf("failed to write workflow file: %w", err)
 	}
 
-	// Set up git attributes (will be populated as files are added)
-	if err := setupGitAttributes(); err != nil {
-		return fmt.Errorf("failed to set up git attributes: %w", err)
-	}
-
-	// Configure git filters
-	if err := configureGitFilters(); err != nil {
-		return fmt.Errorf("failed to configure git filters: %w", err)
-	}
-
-	// Add .gitattributes to git
-	if err := addGitAttributesToGit(); err != nil {
-		return fmt.Errorf("failed to add .gitattributes to git: %w", err)
+	if err := setupFilters(crypto.ModeSharedKey, ""); err != nil {
+		return err
 	}
 
 	// Add workflow file to git
@@ -53,23 +158,269 @@ func Init(args []string) error {
 		return fmt.Errorf("failed to add workflow to git: %w", err)
 	}
 
+	if *codespaces {
+		if err := setupCodespaces(ctx, key.Bytes()); err != nil {
+			return err
+		}
+	}
+	if *requireApproval {
+		if err := protectKeyRequests(ctx, *approvers); err != nil {
+			return err
+		}
+	}
+
 	fmt.Println("✓ ezenv initialized successfully!")
-	fmt.Printf("✓ Encryption key: %d bytes\n", len(key))
+	fmt.Printf("✓ Encryption key fingerprint: %s\n", crypto.KeyID(key.Bytes()))
 	fmt.Println("✓ Git filters configured")
 	fmt.Println("✓ .gitattributes created")
 	fmt.Println("✓ GitHub workflow created")
 	fmt.Println("\nKey Management:")
-	fmt.Println("  - Encryption key stored in GitHub repository secrets")
+	fmt.Println("  - Encryption key stored in " + hosting.SecretStore())
 	fmt.Println("  - Key distribution via GitHub Actions workflow")
 	fmt.Println("  - Access controlled by repository permissions")
 	fmt.Println("\nNext steps:")
 	fmt.Println("  - Use 'git ez-env add <file>' to specify files for encryption")
 	fmt.Println("  - Use 'git add <file>' to stage files (they'll be encrypted automatically)")
 	fmt.Println("  - Push changes to enable workflow-based key management for collaborators")
+	if *codespaces {
+		fmt.Println("  - New codespaces decrypt the files on creation; rebuild existing ones to pick up the helper")
+	}
+
+	return nil
+}
+
+// protectKeyRequests adds required reviewers to the environment the workflow runs key requests of
+// collaborators below maintain in
+func protectKeyRequests(ctx context.Context, approvers string) error {
+	var logins []string
+	for _, login := range strings.Split(approvers, ",") {
+		if login = strings.TrimSpace(login); login != "" {
+			logins = append(logins, login)
+		}
+	}
+	reviewers, err := github.RequireApproval(ctx, logins)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Key requests from collaborators below maintain wait for approval from %s\n", strings.Join(reviewers, ", "))
+	return nil
+}
 
+// initEnvironment sets up the key of a GitHub Environment, creating the environment and its
+// secret if needed, and writes the workflow that can fetch it
+func initEnvironment(ctx context.Context, environment string) error {
+	if err := crypto.ValidateEnvironment(environment); err != nil {
+		return err
+	}
+	if hosting.Current() != hosting.HostGitHub {
+		return fmt.Errorf("--environment needs a GitHub remote")
+	}
+
+	fmt.Printf("Setting up the key of the %s environment...\n", environment)
+	key, err := crypto.NewEnvironmentKeyManager(environment).GetOrCreateEncryptionKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get or create the %s environment key: %w", environment, err)
+	}
+	defer key.Destroy()
+
+	// The workflow needs the environment input to run its job in the environment
+	if err := writeWorkflowFile(); err != nil {
+		return fmt.Errorf("failed to write workflow file: %w", err)
+	}
+	if err := setupFilters(crypto.ModeSharedKey, ""); err != nil {
+		return err
+	}
+	if err := recordEnvironment(environment); err != nil {
+		return err
+	}
+	if err := addWorkflowToGit(); err != nil {
+		return fmt.Errorf("failed to add workflow to git: %w", err)
+	}
+
+	fmt.Printf("✓ %s environment key fingerprint: %s\n", environment, crypto.KeyID(key.Bytes()))
+	fmt.Println("\nNext steps:")
+	fmt.Printf("  - Use 'git ez-env add --environment %s <file>' for files encrypted with this key\n", environment)
+	fmt.Printf("  - Add required reviewers under Settings → Environments → %s to gate who can fetch the key\n", environment)
+	fmt.Printf("  - Deployment jobs with 'environment: %s' run 'git ez-env ci unlock --environment %s'\n", environment, environment)
 	return nil
 }
 
+// initBitbucket initializes a shared-key repository hosted on Bitbucket
+// The key is stored as a secured Pipelines variable for CI, but Bitbucket never hands a secured
+// value back, so the key is also kept on this machine and collaborators get it with import-key
+func initBitbucket(ctx context.Context) error {
+	fmt.Printf("Setting up ez-env with the key in %s...\n", hosting.SecretStore())
+	if err := checkSecretNotLost(ctx); err != nil {
+		return err
+	}
+	key, err := crypto.NewKeyManager().GetOrCreateEncryptionKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get or create encryption key: %w", err)
+	}
+	defer key.Destroy()
+	if err := crypto.SaveLocalKey(key.Bytes()); err != nil {
+		return err
+	}
+
+	if err := setupFilters(crypto.ModeSharedKey, ""); err != nil {
+		return err
+	}
+
+	fmt.Println("✓ ezenv initialized successfully!")
+	fmt.Printf("✓ Encryption key fingerprint: %s\n", crypto.KeyID(key.Bytes()))
+	fmt.Println("✓ Git filters configured")
+	fmt.Println("✓ .gitattributes created")
+	fmt.Printf("✓ Encryption key stored as the secured variable %s\n", github.SecretName)
+	fmt.Println("\nKey Management:")
+	fmt.Println("  - Pipelines read the key from the secured variable; see 'git ez-env ci setup --provider bitbucket-pipelines'")
+	fmt.Println("  - Bitbucket cannot return the key, so give it to collaborators with 'git ez-env export-key'")
+	fmt.Println("  - Or use 'git ez-env init --mode keyring' to wrap the key to the workspace members' SSH keys")
+	return nil
+}
+
+// initKeyring initializes the repository in keyring mode with a new key wrapped to every collaborator
+func initKeyring(ctx context.Context, backend string) error {
+	if crypto.CurrentMode() == crypto.ModeKeyring {
+		return fmt.Errorf("%s already exists; the repository is already in keyring mode", crypto.KeyringFile)
+	}
+	if _, err := crypto.LoadLocalKey(); err == nil {
+		return fmt.Errorf("the repository already has a key; run 'git ez-env convert --to keyring --backend %s' to keep it", backend)
+	}
+	fmt.Printf("Setting up ez-env with a keyring (%s backend)...\n", backend)
+
+	keyring, err := collaboratorKeyring(ctx, backend)
+	if err != nil {
+		return err
+	}
+	key, err := crypto.GenerateEncryptionKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+	if err := keyring.GenerateEncryptedDEKs(key, keyring.Logins()...); err != nil {
+		return err
+	}
+	if err := saveKeyring(keyring); err != nil {
+		return err
+	}
+
+	if err := setupFilters(crypto.ModeKeyring, backend); err != nil {
+		return err
+	}
+
+	fmt.Println("✓ ezenv initialized successfully!")
+	fmt.Printf("✓ Encryption key fingerprint: %s\n", crypto.KeyID(key))
+	fmt.Printf("✓ Key wrapped to %d keys of %d collaborators in %s\n", len(keyring.Entries), len(keyring.Logins()), crypto.KeyringFile)
+	fmt.Println("✓ Git filters configured")
+	fmt.Println("✓ .gitattributes created")
+	if _, err := crypto.NewKeyManager().GetKeyringKey(); err != nil {
+		fmt.Printf("Warning: your local key cannot unlock the keyring: %v\n", err)
+	}
+	fmt.Println("\nNext steps:")
+	fmt.Println("  - Use 'git ez-env add <file>' to specify files for encryption")
+	fmt.Println("  - Commit and push the keyring; use 'git ez-env grant <login>' and 'git ez-env sync-keys' to manage access")
+	return nil
+}
+
+// initPassphrase initializes the repository with a key derived from a passphrase using Argon2id
+// Only the KDF parameters are committed; the derived key is kept in the git directory
+func initPassphrase() error {
+	if mode := crypto.CurrentMode(); mode != crypto.ModeSharedKey {
+		return fmt.Errorf("the repository is already in %s mode", mode)
+	}
+	if _, err := crypto.LoadLocalKey(); err == nil {
+		return fmt.Errorf("the repository already has a key; a passphrase-derived key would replace it")
+	}
+	fmt.Println("Setting up ez-env with a passphrase-derived key...")
+
+	passphrase, err := readPassphrase("Passphrase: ", true)
+	if err != nil {
+		return err
+	}
+	params, err := crypto.NewKDFParams()
+	if err != nil {
+		return err
+	}
+	key := params.DeriveKey(passphrase)
+
+	if err := crypto.NewPassphraseConfig(params, key).Save(crypto.PassphraseFile); err != nil {
+		return err
+	}
+	if _, err := gitOutput("add", "--", crypto.PassphraseFile); err != nil {
+		return fmt.Errorf("failed to add %s to git: %w", crypto.PassphraseFile, err)
+	}
+	if err := crypto.SaveLocalKey(key); err != nil {
+		return err
+	}
+
+	if err := setupFilters(crypto.ModePassphrase, ""); err != nil {
+		return err
+	}
+
+	fmt.Println("✓ ezenv initialized successfully!")
+	fmt.Printf("✓ Encryption key fingerprint: %s\n", crypto.KeyID(key))
+	fmt.Printf("✓ Key derived with Argon2id; parameters stored in %s\n", crypto.PassphraseFile)
+	fmt.Println("✓ Git filters configured")
+	fmt.Println("✓ .gitattributes created")
+	fmt.Println("\nNext steps:")
+	fmt.Println("  - Use 'git ez-env add <file>' to specify files for encryption")
+	fmt.Printf("  - In a fresh clone the passphrase is asked for once, or read from %s\n", crypto.PassphraseEnv)
+	fmt.Println("  - Nobody can recover the files without the passphrase; keep it somewhere safe")
+	return nil
+}
+
+// initInheritParent sets up a submodule to encrypt with the key of its parent repository, so it
+// needs no key, secret or workflow of its own
+func initInheritParent() error {
+	if err := checkGitRepo(); err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	crypto.InheritParent = true
+	parent, ok := crypto.ParentRepo()
+	if !ok {
+		return fmt.Errorf("--inherit-parent only applies to a submodule checked out in its parent repository")
+	}
+	// Committed so every checkout of the submodule inherits the key
+	if err := writeSetting(false, "ezenv.inheritParent", "true"); err != nil {
+		return err
+	}
+	if _, err := os.Stat(".gitattributes"); os.IsNotExist(err) {
+		if err := setupGitAttributes(); err != nil {
+			return fmt.Errorf("failed to set up git attributes: %w", err)
+		}
+		if err := addGitAttributesToGit(); err != nil {
+			return err
+		}
+	}
+	if err := configureGitFilters(); err != nil {
+		return fmt.Errorf("failed to configure git filters: %w", err)
+	}
+
+	fmt.Printf("✓ Files are encrypted with the key of the parent repository %s\n", parent)
+	fmt.Println("✓ Git filters configured")
+	fmt.Printf("Note: commit %s so other checkouts of the submodule inherit the key too\n", RepoConfigFile)
+	return nil
+}
+
+// setupFilters writes .gitattributes, configures the git filters, stages .gitattributes and records
+// the key mode in .ezenv.yml
+func setupFilters(mode, backend string) error {
+	// Set up git attributes (will be populated as files are added)
+	if err := setupGitAttributes(); err != nil {
+		return fmt.Errorf("failed to set up git attributes: %w", err)
+	}
+
+	// Configure git filters
+	if err := configureGitFilters(); err != nil {
+		return fmt.Errorf("failed to configure git filters: %w", err)
+	}
+
+	// Add .gitattributes to git
+	if err := addGitAttributesToGit(); err != nil {
+		return fmt.Errorf("failed to add .gitattributes to git: %w", err)
+	}
+	return recordKeyMode(mode, backend)
+}
+
 func checkGitRepo() error {
 	cmd := exec.Command("git", "rev-parse", "--git-dir")
 	if err := cmd.Run(); err != nil {
@@ -81,10 +432,10 @@ func checkGitRepo() error {
 func writeWorkflowFile() error {
 	fmt.Println("Setting up GitHub workflow...")
 
-	// Get the current working directory (repository root)
-	repoPath, err := os.Getwd()
+	// Workflows live at the top of the working tree, wherever init runs
+	repoPath, err := repoRoot()
 	if err != nil {
-		return fmt.Errorf("failed to get current directory: %w", err)
+		return err
 	}
 
 	// Write the workflow file
@@ -96,10 +447,29 @@ func writeWorkflowFile() error {
 	return nil
 }
 
+// setupGitAttributes writes the ez-env section of .gitattributes, keeping the files listed in
+// .ezenv.yml and every attribute that is not ez-env's
 func setupGitAttributes() error {
-	content := `# ezenv encrypted files
-# Files will be added here when you run 'git ez-env add <file>'
-`
+	config, err := loadRepoConfig()
+	if err != nil {
+		return err
+	}
+	patterns, err := config.files()
+	if err != nil {
+		return err
+	}
+	if len(patterns) > 0 {
+		return syncGitAttributes(config)
+	}
+	existing, err := os.ReadFile(".gitattributes")
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read .gitattributes: %w", err)
+	}
+	content := generateGitAttributes(string(existing), nil)
+	if content != "" {
+		content += "\n"
+	}
+	content += gitAttributesHeader + "\n# Files will be added here when you run 'git ez-env add <file>'\n"
 	return os.WriteFile(".gitattributes", []byte(content), 0644)
 }
 
@@ -115,17 +485,40 @@ func configureGitFilters() error {
 	}
 
 	// Configure clean filter to run on add/commit
-	cleanCmd := exec.Command("git", "config", "filter.ezenv.clean", exe+" clean")
+	cleanCmd := exec.Command("git", "config", "filter.ezenv.clean", exe+" clean %f")
 	if err := cleanCmd.Run(); err != nil {
 		return fmt.Errorf("failed to configure clean filter: %w", err)
 	}
 
 	// Configure smudge filter to run on checkout
-	smudgeCmd := exec.Command("git", "config", "filter.ezenv.smudge", exe+" smudge")
+	smudgeCmd := exec.Command("git", "config", "filter.ezenv.smudge", exe+" smudge %f")
 	if err := smudgeCmd.Run(); err != nil {
 		return fmt.Errorf("failed to configure smudge filter: %w", err)
 	}
 
+	// Configure the long-running filter, which git uses instead of clean and smudge when it
+	// supports it, so one process handles every file
+	processCmd := exec.Command("git", "config", "filter.ezenv.process", exe+" filter-process")
+	if err := processCmd.Run(); err != nil {
+		return fmt.Errorf("failed to configure filter process: %w", err)
+	}
+
+	// Configure the diff driver so git diff/log/show display plaintext
+	diffCmd := exec.Command("git", "config", "diff.ezenv.textconv", exe+" diff")
+	if err := diffCmd.Run(); err != nil {
+		return fmt.Errorf("failed to configure diff driver: %w", err)
+	}
+
+	// Configure the merge driver so merges of encrypted files happen on plaintext
+	mergeNameCmd := exec.Command("git", "config", "merge.ezenv.name", "ez-env encrypted file merge")
+	if err := mergeNameCmd.Run(); err != nil {
+		return fmt.Errorf("failed to configure merge driver: %w", err)
+	}
+	mergeCmd := exec.Command("git", "config", "merge.ezenv.driver", exe+" merge %O %A %B %L %P")
+	if err := mergeCmd.Run(); err != nil {
+		return fmt.Errorf("failed to configure merge driver: %w", err)
+	}
+
 	// Enable the filter to run automatically
 	requiredCmd := exec.Command("git", "config", "filter.ezenv.required", "true")
 	if err := requiredCmd.Run(); err != nil {
@@ -147,7 +540,7 @@ func addGitAttributesToGit() error {
 
 func addWorkflowToGit() error {
 	// Add the workflow file
-	addWorkflowCmd := exec.Command("git", "add", ".github/workflows/ez-env-key-management.yml")
+	addWorkflowCmd := exec.Command("git", "add", filepath.ToSlash(workflows.KeyManagementWorkflowPath()))
 	if err := addWorkflowCmd.Run(); err != nil {
 		return fmt.Errorf("failed to add workflow to git: %w", err)
 	}
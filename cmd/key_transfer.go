@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+// ExportKey writes the repository key to a passphrase-protected file for manual transfer
+func ExportKey(args []string) error {
+	flags := flag.NewFlagSet("export-key", flag.ContinueOnError)
+	output := flags.String("o", "", "write the exported key to this file instead of stdout")
+	ageIdentity := flags.Bool("age", false, "export the age identity that decrypts files in the age format with the age CLI")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	keyManager := crypto.NewKeyManager()
+	key, err := keyManager.GetEncryptionKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	defer key.Destroy()
+
+	if *ageIdentity {
+		return exportAgeIdentity(key.Bytes(), *output)
+	}
+
+	passphrase, err := readPassphrase("Passphrase to protect the exported key: ", true)
+	if err != nil {
+		return err
+	}
+
+	exported, err := crypto.ExportKey(key.Bytes(), passphrase)
+	if err != nil {
+		return fmt.Errorf("failed to export key: %w", err)
+	}
+
+	if *output == "" {
+		_, err := os.Stdout.Write(exported)
+		return err
+	}
+
+	if err := os.WriteFile(*output, exported, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", *output, err)
+	}
+	fmt.Printf("✓ Encryption key exported to %s\n", *output)
+	fmt.Println("Note: transfer the file and passphrase separately, then run 'git ez-env import-key <file>'")
+	return nil
+}
+
+// exportAgeIdentity writes the age identity of key as an age identity file
+func exportAgeIdentity(key []byte, output string) error {
+	identity, err := crypto.AgeIdentity(key)
+	if err != nil {
+		return err
+	}
+	content := fmt.Sprintf("# ez-env repository key %s\n# decrypt files in the age format with: age -d -i <this file> <file>\n%s\n", crypto.KeyID(key), identity)
+
+	if output == "" {
+		fmt.Print(content)
+		return nil
+	}
+	if err := os.WriteFile(output, []byte(content), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", output, err)
+	}
+	fmt.Printf("✓ age identity written to %s\n", output)
+	fmt.Println("Note: the identity is not passphrase-protected; anyone holding it can decrypt every file in the age format")
+	return nil
+}
+
+// ImportKey decrypts an exported key file and stores the key locally for this repository
+func ImportKey(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("no key file specified")
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", args[0], err)
+	}
+
+	passphrase, err := readPassphrase("Passphrase for the exported key: ", false)
+	if err != nil {
+		return err
+	}
+
+	key, err := crypto.ImportKey(data, passphrase)
+	if err != nil {
+		return err
+	}
+
+	if err := crypto.SaveLocalKey(key); err != nil {
+		return err
+	}
+
+	fmt.Println("✓ Encryption key imported")
+	fmt.Println("Note: the key is stored in .git/ezenv/key and used instead of the GitHub workflow")
+	return nil
+}
@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/hosting"
+	"github.com/oliviaBahr/ez-env/ssh"
+)
+
+// Keygen generates a keypair dedicated to ez-env under ~/.config/ezenv/keys and registers the
+// public key with the repository keyring, leaving the personal keys in ~/.ssh untouched
+// When the current user can already decrypt, the key is wrapped to the new keypair right away;
+// otherwise the entry stays pending until a collaborator with access runs 'git ez-env grant <login>'
+// --type hardware registers a key that already lives on a hardware token instead of generating one
+func Keygen(args []string) error {
+	flags := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	keyType := flags.String("type", "rsa", "key type: rsa, ed25519 or hardware")
+	pluginName := flags.String("plugin", crypto.DefaultHardwarePlugin, "age plugin that talks to the hardware token (with --type hardware)")
+	recipient := flags.String("recipient", "", "recipient of the token key to use when several are found (with --type hardware)")
+	bits := flags.Int("bits", 4096, "RSA key size")
+	login := flags.String("login", "", "GitHub login to register the key for (default the authenticated GitHub user)")
+	noRegister := flags.Bool("no-register", false, "only generate the keypair, do not add it to the keyring")
+	force := flags.Bool("force", false, "replace an existing ez-env keypair")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var keyName string
+	switch *keyType {
+	case "rsa":
+		keyName = ssh.DedicatedKeyName
+	case "ed25519":
+		keyName = ssh.DedicatedEd25519KeyName
+	case "hardware":
+		return registerHardwareKey(*pluginName, *recipient, *login, *noRegister)
+	default:
+		return fmt.Errorf("unknown key type %q (expected rsa, ed25519 or hardware)", *keyType)
+	}
+
+	dir, err := ssh.KeyDir()
+	if err != nil {
+		return err
+	}
+	privatePath := filepath.Join(dir, keyName)
+	if _, err := os.Stat(privatePath); err == nil && !*force {
+		return fmt.Errorf("%s already exists; use --force to replace it", privatePath)
+	}
+
+	register := !*noRegister && crypto.CurrentMode() == crypto.ModeKeyring
+	var keyring *crypto.Keyring
+	var dek *crypto.SecureBytes
+	if register {
+		// Unwrap with the current key before the new keypair takes its place
+		if keyring, dek, err = prepareRegistration(login); err != nil {
+			return err
+		}
+		defer dek.Destroy()
+		if keyring.WrapBackend() == crypto.BackendGPG {
+			return fmt.Errorf("the keyring wraps the key to GPG keys; add your GPG key on GitHub and ask a collaborator with access to run 'git ez-env grant %s'", *login)
+		}
+	}
+
+	var privatePEM []byte
+	var publicKey string
+	if *keyType == "ed25519" {
+		privatePEM, publicKey, err = ssh.GenerateEd25519Key()
+	} else {
+		privatePEM, publicKey, err = ssh.GenerateRSAKey(*bits)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	if err := os.WriteFile(privatePath, privatePEM, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", privatePath, err)
+	}
+	if err := os.Chmod(privatePath, 0600); err != nil {
+		return fmt.Errorf("failed to set permissions on %s: %w", privatePath, err)
+	}
+	if err := os.WriteFile(privatePath+".pub", []byte(publicKey+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write %s.pub: %w", privatePath, err)
+	}
+	fingerprint, err := ssh.Fingerprint(publicKey)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Generated %s (%s)\n", privatePath, fingerprint)
+	if os.Getenv(ssh.PrivateKeyEnv) != "" {
+		fmt.Printf("Note: %s is set and takes precedence over the generated key\n", ssh.PrivateKeyEnv)
+	}
+
+	if !register {
+		if !*noRegister {
+			fmt.Println("Note: the repository is not in keyring mode; run 'git ez-env convert --to keyring' to use the key")
+		}
+		return nil
+	}
+
+	return registerKey(keyring, *login, publicKey, dek)
+}
+
+// prepareRegistration resolves the login to register a key for, loads the keyring and unwraps the
+// data encryption key if the current user already has access
+func prepareRegistration(login *string) (*crypto.Keyring, *crypto.SecureBytes, error) {
+	ctx := context.Background()
+	if *login == "" {
+		var err error
+		if *login, err = hosting.GetCurrentUser(ctx); err != nil {
+			return nil, nil, fmt.Errorf("%w (use --login to name the keyring entry)", err)
+		}
+	}
+	keyring, err := loadTrustedKeyring()
+	if err != nil {
+		return nil, nil, err
+	}
+	// Without access the key is registered as pending; other failures abort instead of doing the same
+	dek, err := crypto.NewKeyManager().GetEncryptionKey(ctx)
+	if err != nil && !errors.Is(err, crypto.ErrNoKeyringEntry) {
+		return nil, nil, fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	return keyring, dek, nil
+}
+
+// registerKey adds publicKey to the keyring for login, wrapping the key to it when dek is known
+func registerKey(keyring *crypto.Keyring, login, publicKey string, dek *crypto.SecureBytes) error {
+	if _, err := keyring.AddEntry(login, publicKey); err != nil {
+		return err
+	}
+	if dek != nil {
+		if _, err := keyring.WrapPendingDEKs(dek.Bytes(), login); err != nil {
+			return err
+		}
+		keyring.MarkGranted(login, time.Now())
+	}
+	if err := saveKeyring(keyring); err != nil {
+		return err
+	}
+
+	if dek != nil {
+		fmt.Printf("✓ Registered the key for %s in %s; commit and push it\n", login, crypto.KeyringFile)
+		return nil
+	}
+	fmt.Printf("✓ Registered the key for %s in %s as pending\n", login, crypto.KeyringFile)
+	fmt.Printf("Note: commit and push the keyring, then ask a collaborator with access to run 'git ez-env grant %s'\n", login)
+	return nil
+}
+
+// registerHardwareKey finds a key on a hardware token with an age plugin, records its identity stub
+// in the local age identity file and registers its recipient with the age keyring
+func registerHardwareKey(pluginName, recipient, login string, noRegister bool) error {
+	keys, err := crypto.DiscoverHardwareKeys(pluginName)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no keys found on a connected token; create one with '%s --generate'", crypto.PluginBinary(pluginName))
+	}
+
+	var key *crypto.HardwareKey
+	for i := range keys {
+		if recipient == "" && len(keys) == 1 || keys[i].Recipient == recipient {
+			key = &keys[i]
+		}
+	}
+	if key == nil {
+		for _, k := range keys {
+			fmt.Printf("  %s (%s)\n", k.Recipient, k.Description)
+		}
+		if recipient == "" {
+			return fmt.Errorf("%d keys found; choose one with --recipient", len(keys))
+		}
+		return fmt.Errorf("no connected token has the key %s", recipient)
+	}
+
+	register := !noRegister && crypto.CurrentMode() == crypto.ModeKeyring
+	var keyring *crypto.Keyring
+	var dek *crypto.SecureBytes
+	if register {
+		if keyring, dek, err = prepareRegistration(&login); err != nil {
+			return err
+		}
+		defer dek.Destroy()
+		if keyring.WrapBackend() != crypto.BackendAge {
+			return fmt.Errorf("hardware keys need a keyring with the %s backend, but this one uses %s", crypto.BackendAge, keyring.WrapBackend())
+		}
+	}
+
+	path, err := appendAgeIdentity(key.Identity)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Using %s (%s); its identity stub is in %s\n", key.Recipient, key.Description, path)
+
+	if !register {
+		if !noRegister {
+			fmt.Printf("Note: the repository is not in keyring mode; run 'git ez-env convert --to keyring --backend %s' to use the key\n", crypto.BackendAge)
+		}
+		return nil
+	}
+	return registerKey(keyring, login, key.Recipient, dek)
+}
+
+// appendAgeIdentity adds an identity line to the local age identity file unless it is already there
+func appendAgeIdentity(identity string) (string, error) {
+	path := os.Getenv(crypto.AgeIdentityEnv)
+	if path == "" {
+		dir, err := ssh.KeyDir()
+		if err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+		path = filepath.Join(dir, crypto.AgeIdentityName)
+	}
+
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	for _, line := range strings.Split(string(existing), "\n") {
+		if strings.TrimSpace(line) == identity {
+			return path, nil
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	if len(existing) > 0 && !strings.HasSuffix(string(existing), "\n") {
+		identity = "\n" + identity
+	}
+	if _, err := fmt.Fprintln(f, identity); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return path, nil
+}
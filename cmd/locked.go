@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+// A file smudged without its key in ezenv.failMode=soft is checked out as a locked placeholder:
+// a short text naming the blob of its ciphertext, so builds that read it fail with a message that
+// says what to do. Clean turns the placeholder back into that ciphertext, so locked files show as
+// unmodified and are committed untouched, and unlock checks them out again once the key is there.
+
+// lockedHeader starts every locked placeholder
+const lockedHeader = "EZENV LOCKED FILE — run git ez-env unlock"
+
+// lockedObjectPattern matches the line of a placeholder that names the ciphertext blob
+var lockedObjectPattern = regexp.MustCompile(`(?m)^object ([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// lockedPlaceholder returns the placeholder of the file whose ciphertext is the blob object
+func lockedPlaceholder(object string) []byte {
+	return []byte(lockedHeader + "\n" +
+		"This file is encrypted with ez-env and was checked out without its key.\n" +
+		"object " + object + "\n")
+}
+
+// parseLockedPlaceholder returns the ciphertext blob a locked placeholder names
+func parseLockedPlaceholder(content []byte) (string, bool) {
+	if !bytes.HasPrefix(content, []byte(lockedHeader+"\n")) || len(content) > 512 {
+		return "", false
+	}
+	match := lockedObjectPattern.FindSubmatch(content)
+	if match == nil {
+		return "", false
+	}
+	return string(match[1]), true
+}
+
+// lockedCiphertext returns the ciphertext a locked placeholder stands for
+// Only the blob staged or committed for the same path is restored, so a crafted placeholder cannot
+// copy the ciphertext of another file, or any other object, into path
+func lockedCiphertext(path, object string) ([]byte, error) {
+	if !lockedObjectOf(path, object) {
+		return nil, fmt.Errorf("%s is a locked placeholder for %s, which is not the staged or committed content of %s; run 'git ez-env unlock' or restore the file with 'git checkout -- %s'", path, object, path, path)
+	}
+	content, err := catFileBlob(object)
+	if err != nil || !crypto.IsEncryptedFile(content) {
+		return nil, fmt.Errorf("%s is a locked placeholder for %s, which is not encrypted content of this repository; run 'git ez-env unlock' or restore the file with 'git checkout -- %s'", path, object, path)
+	}
+	return content, nil
+}
+
+// lockedObjectOf reports whether object is the blob of path in the index or in HEAD
+func lockedObjectOf(path, object string) bool {
+	if path == "" {
+		return false
+	}
+	for _, rev := range []string{":" + path, "HEAD:" + path} {
+		if id, err := gitOutput("rev-parse", "--verify", "--quiet", rev); err == nil && id == object {
+			return true
+		}
+	}
+	return false
+}
+
+// smudgeWithoutKey applies ezenv.failMode to the encrypted content of a file whose key could not
+// be retrieved: fail returns err, blocking the checkout, while soft checks out a locked
+// placeholder with a warning, for CI jobs and contributors who do not need the secrets
+func smudgeWithoutKey(path string, encrypted io.Reader, err error) ([]byte, error) {
+	if settingValue("failMode") != failModeSoft {
+		return nil, err
+	}
+	object, hashErr := hashObject(encrypted)
+	if hashErr != nil {
+		return nil, fmt.Errorf("%w; locking the file failed: %v", err, hashErr)
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s is checked out locked: %v\n", path, err)
+	return lockedPlaceholder(object), nil
+}
+
+// hashObject returns the blob object id of the content read from r without storing it
+func hashObject(r io.Reader) (string, error) {
+	cmd := exec.Command("git", "hash-object", "--stdin")
+	cmd.Stdin = r
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to hash content: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// unlockLockedFiles checks out the locked placeholders in the working tree again, decrypting them
+// now that the key can be retrieved, and returns how many there were
+func unlockLockedFiles(ctx context.Context) (int, error) {
+	root, err := repoRoot()
+	if err != nil {
+		return 0, err
+	}
+	entries, err := encryptedIndexEntries()
+	if err != nil {
+		return 0, err
+	}
+	var locked []string
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(entry.Path)))
+		if err != nil {
+			continue
+		}
+		if _, ok := parseLockedPlaceholder(content); ok {
+			locked = append(locked, entry.Path)
+		}
+	}
+	if len(locked) == 0 {
+		return 0, nil
+	}
+
+	// Retrieving the keys first fails with the reason instead of locking the files again
+	keys := filterKeys{}
+	defer keys.destroy()
+	for _, path := range locked {
+		if _, err := keys.get(ctx, path); err != nil {
+			return 0, fmt.Errorf("failed to unlock %s: %w", path, err)
+		}
+	}
+	// git leaves files that match the index alone, and placeholders do, so they are removed first
+	for _, path := range locked {
+		if err := os.Remove(filepath.Join(root, filepath.FromSlash(path))); err != nil {
+			return 0, fmt.Errorf("failed to remove the placeholder of %s: %w", path, err)
+		}
+	}
+	args := append([]string{"-C", root, "-c", "ezenv.failMode=" + failModeFail, "checkout", "--"}, locked...)
+	if _, err := gitOutput(args...); err != nil {
+		return 0, fmt.Errorf("failed to check out the locked files: %w; run 'git checkout -- .' once the key is available", err)
+	}
+	return len(locked), nil
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+// Merge performs a three-way merge of encrypted files
+// This is called by Git as the merge.ezenv.driver with %O %A %B %L %P: the ancestor, current
+// and other versions are decrypted, merged as text and the result is re-encrypted into %A
+// On conflict the plaintext with conflict markers is left in %A for the user to resolve
+func Merge(args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("usage: merge <ancestor> <current> <other> [marker-size] [path]")
+	}
+	ancestorFile, currentFile, otherFile := args[0], args[1], args[2]
+
+	markerSize := 7
+	if len(args) > 3 {
+		size, err := strconv.Atoi(args[3])
+		if err != nil || size <= 0 {
+			return fmt.Errorf("invalid conflict marker size: %s", args[3])
+		}
+		markerSize = size
+	}
+	path := currentFile
+	if len(args) > 4 {
+		path = args[4]
+	}
+
+	ctx := context.Background()
+	keyManager, err := fileKeyManager(path)
+	if err != nil {
+		return err
+	}
+	key, err := keyManager.GetEncryptionKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	defer key.Destroy()
+
+	// Decrypted versions live in a private directory inside the git directory and are removed afterwards
+	tmpDir, err := privateTempDir("merge-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var plainFiles []string
+	for _, file := range []string{currentFile, ancestorFile, otherFile} {
+		plaintext, err := readDecrypted(file, key.Bytes())
+		if err != nil {
+			return err
+		}
+		plainFile := filepath.Join(tmpDir, strconv.Itoa(len(plainFiles)))
+		if err := os.WriteFile(plainFile, plaintext, 0600); err != nil {
+			return fmt.Errorf("failed to write merge input: %w", err)
+		}
+		plainFiles = append(plainFiles, plainFile)
+	}
+
+	// git merge-file exits with the number of conflicts, or a negative status on error
+	mergeCmd := exec.Command("git", "merge-file", "-p", "--marker-size="+strconv.Itoa(markerSize),
+		"-L", path+" (ours)", "-L", path+" (base)", "-L", path+" (theirs)",
+		plainFiles[0], plainFiles[1], plainFiles[2])
+	var stderr bytes.Buffer
+	mergeCmd.Stderr = &stderr
+	merged, err := mergeCmd.Output()
+	conflicts := 0
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() > 127 {
+			return fmt.Errorf("failed to merge %s: %w: %s", path, err, stderr.String())
+		}
+		conflicts = exitErr.ExitCode()
+	}
+
+	if conflicts > 0 {
+		if err := os.WriteFile(currentFile, merged, 0600); err != nil {
+			return fmt.Errorf("failed to write merge result: %w", err)
+		}
+		return fmt.Errorf("%d conflict(s) in %s; resolve the plaintext conflict markers and git add the file", conflicts, path)
+	}
+
+	// Without %P the merge result is left unbound, since currentFile is a temporary file
+	boundPath := ""
+	if len(args) > 4 {
+		boundPath = args[4]
+	}
+	opts, err := encryptOptions(boundPath)
+	if err != nil {
+		return err
+	}
+	encrypted, err := crypto.EncryptFileWithOptions(merged, key.Bytes(), opts)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt merge result: %w", err)
+	}
+	if err := os.WriteFile(currentFile, encrypted, 0600); err != nil {
+		return fmt.Errorf("failed to write merge result: %w", err)
+	}
+	return nil
+}
+
+// readDecrypted reads a file and decrypts it if it is encrypted
+func readDecrypted(file string, key []byte) ([]byte, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	if !crypto.IsEncryptedFile(content) {
+		return content, nil
+	}
+
+	plaintext, err := crypto.DecryptFile(content, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", file, err)
+	}
+	return plaintext, nil
+}
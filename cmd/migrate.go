@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// migrationSource describes where another encryption tool keeps its file list and ciphertext
+type migrationSource struct {
+	// lists are the candidate locations of the file list, the first existing one is used
+	lists []string
+	// extension is appended to a path to get its encrypted copy
+	extension string
+}
+
+// migrationSources are the tools ez-env can migrate from
+var migrationSources = map[string]migrationSource{
+	"git-secret": {
+		lists:     []string{filepath.Join(".gitsecret", "paths", "mapping.cfg")},
+		extension: ".secret",
+	},
+	"blackbox": {
+		lists:     []string{filepath.Join(".blackbox", "blackbox-files.txt"), filepath.Join("keyrings", "live", "blackbox-files.txt")},
+		extension: ".gpg",
+	},
+}
+
+// Migrate moves files encrypted with git-secret or blackbox under ez-env encryption
+// Each file is decrypted with the user's GPG key, its encrypted copy is removed and the
+// plaintext is re-added so the ezenv filter encrypts it
+func Migrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "do not ask for confirmation")
+	keepOld := flags.Bool("keep-old", false, "keep the old encrypted copies")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return fmt.Errorf("no tool specified (git-secret or blackbox)")
+	}
+
+	tool := flags.Arg(0)
+	source, ok := migrationSources[tool]
+	if !ok {
+		return fmt.Errorf("unsupported tool: %s (expected git-secret or blackbox)", tool)
+	}
+
+	if err := checkGitRepo(); err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	if _, err := exec.LookPath("gpg"); err != nil {
+		return fmt.Errorf("gpg is required to decrypt %s files", tool)
+	}
+	// Nothing is decrypted or deleted unless git add will encrypt the plaintext
+	if err := requireCleanFilter(); err != nil {
+		return err
+	}
+
+	paths, err := source.files()
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no files are managed by %s", tool)
+	}
+
+	fmt.Printf("Files to migrate from %s:\n", tool)
+	for _, path := range paths {
+		fmt.Printf("  %s%s → %s\n", path, source.extension, path)
+	}
+	if !*yes {
+		ok, err := confirm("Decrypt these files and re-add them under ez-env encryption?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("migration aborted")
+		}
+	}
+
+	// Decrypt everything first so a missing GPG key does not leave a half-migrated repository
+	plaintexts := make(map[string][]byte, len(paths))
+	for _, path := range paths {
+		plaintext, err := gpgDecrypt(path + source.extension)
+		if err != nil {
+			return err
+		}
+		plaintexts[path] = plaintext
+		fmt.Printf("✓ Decrypted %s\n", path+source.extension)
+	}
+
+	for _, path := range paths {
+		if err := migrateFile(path, path+source.extension, plaintexts[path], *keepOld); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Migrated %s\n", path)
+	}
+
+	fmt.Printf("✓ Migrated %d file(s) from %s\n", len(paths), tool)
+	fmt.Printf("Note: review and commit the staged changes; %s's own configuration can be removed once everyone has switched\n", tool)
+	return nil
+}
+
+// files reads the file list of the migration source
+func (s migrationSource) files() ([]string, error) {
+	for _, list := range s.lists {
+		content, err := os.ReadFile(list)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", list, err)
+		}
+
+		var paths []string
+		for _, line := range strings.Split(string(content), "\n") {
+			// git-secret records "path:hash", blackbox one path per line
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if path, _, ok := strings.Cut(line, ":"); ok {
+				line = path
+			}
+			paths = append(paths, filepath.ToSlash(filepath.Clean(line)))
+		}
+		return paths, nil
+	}
+	return nil, fmt.Errorf("file list not found (looked for %s)", strings.Join(s.lists, ", "))
+}
+
+// gpgDecrypt decrypts a file with the user's GPG key
+func gpgDecrypt(path string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("gpg", "--quiet", "--decrypt", path)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = &stderr
+	plaintext, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s with gpg: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return plaintext, nil
+}
+
+// migrateFile writes the plaintext, removes the old encrypted copy and stages the file under ez-env
+func migrateFile(path, encryptedPath string, plaintext []byte, keepOld bool) error {
+	if err := os.WriteFile(path, plaintext, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	// Both tools ignore the plaintext, which would stop git add from staging it
+	if err := removeFromGitignore(path); err != nil {
+		return err
+	}
+	if err := addManagedPatterns("", path); err != nil {
+		return fmt.Errorf("failed to add %s to %s: %w", path, RepoConfigFile, err)
+	}
+
+	if !keepOld {
+		if _, err := gitOutput("rm", "-q", "--cached", "--ignore-unmatch", "--", encryptedPath); err != nil {
+			return fmt.Errorf("failed to remove %s from git: %w", encryptedPath, err)
+		}
+		if err := os.Remove(encryptedPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete %s: %w", encryptedPath, err)
+		}
+	}
+
+	addCmd := exec.Command("git", "add", "--", path)
+	if err := addCmd.Run(); err != nil {
+		return fmt.Errorf("failed to add %s to git: %w", path, err)
+	}
+	return nil
+}
+
+// requireCleanFilter fails unless the clean filter is configured and required in this clone, as it
+// is not in a fresh clone where init has not run, so git add would stage decrypted files as they are
+func requireCleanFilter() error {
+	err := checkFilter("clean")
+	if err == nil {
+		err = checkFilterRequired()
+	}
+	if err != nil {
+		return fmt.Errorf("%w; run 'git ez-env init' in this clone first so decrypted files are staged encrypted", err)
+	}
+	return nil
+}
+
+// removeFromGitignore removes the entries that ignore path from .gitignore
+func removeFromGitignore(path string) error {
+	content, err := os.ReadFile(".gitignore")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read .gitignore: %w", err)
+	}
+
+	var kept []string
+	removed := false
+	for _, line := range strings.Split(string(content), "\n") {
+		entry := strings.TrimSpace(line)
+		if entry == path || entry == "/"+path {
+			removed = true
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if !removed {
+		return nil
+	}
+
+	if err := os.WriteFile(".gitignore", []byte(strings.Join(kept, "\n")), 0644); err != nil {
+		return fmt.Errorf("failed to write .gitignore: %w", err)
+	}
+	addCmd := exec.Command("git", "add", ".gitignore")
+	if err := addCmd.Run(); err != nil {
+		return fmt.Errorf("failed to add .gitignore to git: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+// secureWriter writes reports, logs and backups produced by ez-env
+// Output is encrypted with the repository key by default, or with an environment's key or a
+// passphrase on request, and is only written in plaintext when explicitly asked for with --plaintext
+type secureWriter struct {
+	plaintext   *bool
+	passphrase  *bool
+	environment *string
+}
+
+// newSecureWriter registers the flags that choose how output files are protected
+func newSecureWriter(flags *flag.FlagSet) *secureWriter {
+	return &secureWriter{
+		plaintext:   flags.Bool("plaintext", false, "write output files unencrypted"),
+		passphrase:  flags.Bool("passphrase", false, "encrypt output files with a passphrase instead of the repository key"),
+		environment: flags.String("environment", "", "encrypt output files with the key of a GitHub Environment instead of the repository key"),
+	}
+}
+
+// WriteFile protects data as selected by the flags and writes it to path
+func (w *secureWriter) WriteFile(path string, data []byte) error {
+	if *w.plaintext && *w.passphrase {
+		return fmt.Errorf("--plaintext and --passphrase cannot be combined")
+	}
+	if *w.environment != "" && (*w.plaintext || *w.passphrase) {
+		return fmt.Errorf("--environment cannot be combined with --plaintext or --passphrase")
+	}
+
+	if !*w.plaintext {
+		sealed, err := w.seal(data)
+		if err != nil {
+			return err
+		}
+		data = sealed
+	}
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// seal encrypts data with a passphrase, or the key of the chosen environment or the repository
+func (w *secureWriter) seal(data []byte) ([]byte, error) {
+	if *w.passphrase {
+		passphrase, err := readPassphrase("Passphrase to protect the output: ", true)
+		if err != nil {
+			return nil, err
+		}
+		return crypto.SealOutputWithPassphrase(data, passphrase)
+	}
+
+	if *w.environment != "" {
+		if err := crypto.ValidateEnvironment(*w.environment); err != nil {
+			return nil, err
+		}
+	}
+	keyManager := crypto.NewEnvironmentKeyManager(*w.environment)
+	key, err := keyManager.GetEncryptionKey(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encryption key (use --plaintext to write unencrypted output): %w", err)
+	}
+	defer key.Destroy()
+	return crypto.SealOutput(data, key.Bytes())
+}
+
+// ReadOutput decrypts a report, log or backup written by ez-env and prints it
+func ReadOutput(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("no file specified")
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", args[0], err)
+	}
+	sealed, err := crypto.ParseSealedOutput(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+
+	var plaintext []byte
+	if sealed.Protection == crypto.SealedWithPassphrase {
+		passphrase, err := readPassphrase("Passphrase for the output: ", false)
+		if err != nil {
+			return err
+		}
+		plaintext, err = sealed.OpenWithPassphrase(passphrase)
+		if err != nil {
+			return err
+		}
+	} else {
+		// The output may be sealed with an environment's key, which its key id tells apart
+		keys := filterKeys{}
+		defer keys.destroy()
+		key, err := keys.forKeyID(context.Background(), sealed.KeyID)
+		if err != nil {
+			return err
+		}
+		plaintext, err = sealed.Open(key.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = os.Stdout.Write(plaintext)
+	return err
+}
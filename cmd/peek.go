@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+// maxPeekLines limits how much plaintext a single peek request may return
+const maxPeekLines = 200
+
+// peekRequest is a single request of the editor protocol
+// In --stdio mode one JSON request is read per line from stdin
+type peekRequest struct {
+	ID    int    `json:"id"`
+	Path  string `json:"path"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// peekResponse is written as one JSON line to stdout for every request
+type peekResponse struct {
+	ID    int      `json:"id"`
+	Path  string   `json:"path,omitempty"`
+	Start int      `json:"start,omitempty"`
+	Lines []string `json:"lines,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// Peek returns decrypted lines of a managed file for editor integrations
+// Plaintext is only ever held in memory, and only ezenv-managed files inside the repository can be read
+//
+//	git ez-env peek --line-range 3:5 config/.env
+//	git ez-env peek --stdio   (requests: {"id":1,"path":"config/.env","start":3,"end":5})
+func Peek(args []string) error {
+	flags := flag.NewFlagSet("peek", flag.ContinueOnError)
+	lineRange := flags.String("line-range", "", "1-based inclusive line range START:END")
+	stdio := flags.Bool("stdio", false, "serve newline-delimited JSON requests from stdin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+
+	// Keys are retrieved per environment when a request first needs one
+	ctx := context.Background()
+	keys := filterKeys{}
+	defer keys.destroy()
+
+	if *stdio {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			var request peekRequest
+			if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+				encoder.Encode(peekResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+				continue
+			}
+			if err := encoder.Encode(servePeek(ctx, request, keys)); err != nil {
+				return fmt.Errorf("failed to write response: %w", err)
+			}
+		}
+		return scanner.Err()
+	}
+
+	if flags.NArg() < 1 {
+		return fmt.Errorf("no file specified")
+	}
+	request := peekRequest{Path: flags.Arg(0)}
+	if *lineRange != "" {
+		var err error
+		if request.Start, request.End, err = parseLineRange(*lineRange); err != nil {
+			return err
+		}
+	}
+
+	response := servePeek(ctx, request, keys)
+	if err := encoder.Encode(response); err != nil {
+		return fmt.Errorf("failed to write response: %w", err)
+	}
+	if response.Error != "" {
+		return fmt.Errorf("%s", response.Error)
+	}
+	return nil
+}
+
+// servePeek authorizes and answers a single peek request
+func servePeek(ctx context.Context, request peekRequest, keys filterKeys) peekResponse {
+	response := peekResponse{ID: request.ID}
+
+	repoPath, err := authorizePeekPath(request.Path)
+	if err != nil {
+		response.Error = err.Error()
+		return response
+	}
+	response.Path = repoPath
+
+	content, err := catFileBlob(":" + repoPath)
+	if err != nil {
+		response.Error = fmt.Sprintf("%s is not staged or committed", repoPath)
+		return response
+	}
+	if crypto.IsEncryptedFile(content) {
+		key, err := keys.get(ctx, repoPath)
+		if err != nil {
+			response.Error = err.Error()
+			return response
+		}
+		if content, err = crypto.DecryptFile(content, key.Bytes()); err != nil {
+			response.Error = fmt.Sprintf("failed to decrypt %s: %v", repoPath, err)
+			return response
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	start, end := request.Start, request.End
+	if start <= 0 {
+		start = 1
+	}
+	if end <= 0 || end > len(lines) {
+		end = len(lines)
+	}
+	if end-start+1 > maxPeekLines {
+		end = start + maxPeekLines - 1
+	}
+	if start > end {
+		response.Error = fmt.Sprintf("line range %d:%d is outside of %s", request.Start, request.End, repoPath)
+		return response
+	}
+
+	response.Start = start
+	response.Lines = lines[start-1 : end]
+	return response
+}
+
+// authorizePeekPath ensures a requested path is inside the repository and managed by ez-env
+// It returns the repository-relative path
+func authorizePeekPath(requested string) (string, error) {
+	if requested == "" {
+		return "", fmt.Errorf("no path specified")
+	}
+
+	root, err := gitOutput("rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+
+	abs, err := filepath.Abs(requested)
+	if err != nil {
+		return "", fmt.Errorf("invalid path: %s", requested)
+	}
+	// The top-level directory reported by git has symlinks resolved
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+		abs = filepath.Join(dir, filepath.Base(abs))
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("access denied: %s is outside the repository", requested)
+	}
+	rel = path.Clean(filepath.ToSlash(rel))
+
+	managed, err := pathsWithFilter([]string{abs})
+	if err != nil {
+		return "", err
+	}
+	if !managed[abs] {
+		return "", fmt.Errorf("access denied: %s is not managed by ez-env", rel)
+	}
+	return rel, nil
+}
+
+// parseLineRange parses a START:END line range
+func parseLineRange(value string) (int, int, error) {
+	startText, endText, ok := strings.Cut(value, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid line range %q (expected START:END)", value)
+	}
+
+	start, err := strconv.Atoi(startText)
+	if err != nil || start < 1 {
+		return 0, 0, fmt.Errorf("invalid line range start: %q", startText)
+	}
+	end, err := strconv.Atoi(endText)
+	if err != nil || end < start {
+		return 0, 0, fmt.Errorf("invalid line range end: %q", endText)
+	}
+	return start, end, nil
+}
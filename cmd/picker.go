@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// secretFilePatterns are base name patterns of files that commonly hold secrets
+var secretFilePatterns = []string{
+	".env", ".env.*", "*.env",
+	"*.pem", "*.key", "*.p12", "*.pfx", "*.keystore", "*.jks",
+	"id_rsa", "id_dsa", "id_ecdsa", "id_ed25519",
+	"*credentials*", "*secret*", ".npmrc", ".pypirc", ".netrc", "*.tfvars",
+}
+
+// exampleFileSuffixes mark committed templates that do not hold real secrets
+var exampleFileSuffixes = []string{".example", ".sample", ".template", ".dist", ".pub"}
+
+// secretFileCandidates lists tracked and untracked files that look like secrets and are not managed yet
+func secretFileCandidates() ([]string, error) {
+	output, err := gitOutputRaw(nil, "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	var candidates []string
+	for _, file := range strings.Split(string(output), "\x00") {
+		if file != "" && looksLikeSecretFile(file) {
+			candidates = append(candidates, file)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	managed, err := pathsWithFilter(candidates)
+	if err != nil {
+		return nil, err
+	}
+	unmanaged := candidates[:0]
+	for _, file := range candidates {
+		if managed[file] {
+			continue
+		}
+		if kind, _ := managedFileKind(file); kind != kindRegular {
+			continue
+		}
+		unmanaged = append(unmanaged, file)
+	}
+	return unmanaged, nil
+}
+
+// looksLikeSecretFile reports whether the base name of file matches a secret file pattern
+func looksLikeSecretFile(file string) bool {
+	base := strings.ToLower(path.Base(file))
+	for _, suffix := range exampleFileSuffixes {
+		if strings.HasSuffix(base, suffix) {
+			return false
+		}
+	}
+	for _, pattern := range secretFilePatterns {
+		if matched, _ := path.Match(pattern, base); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// pickFiles shows a terminal multi-select list of files and returns the chosen ones
+// Up/down (or k/j) move, space toggles, a toggles all, enter confirms and q or escape cancels
+func pickFiles(prompt string, files []string) ([]string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("no terminal available for interactive selection")
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enter interactive mode: %w", err)
+	}
+	defer term.Restore(fd, state)
+
+	selected := make([]bool, len(files))
+	cursor := 0
+	lines := 0
+	render := func() {
+		// Move back to the top of the previous frame before redrawing it
+		if lines > 0 {
+			fmt.Fprintf(os.Stderr, "\x1b[%dA", lines)
+		}
+		fmt.Fprintf(os.Stderr, "\r\x1b[J%s (space to toggle, a for all, enter to confirm, q to cancel)\r\n", prompt)
+		for i, file := range files {
+			pointer, box := " ", "[ ]"
+			if i == cursor {
+				pointer = ">"
+			}
+			if selected[i] {
+				box = "[x]"
+			}
+			fmt.Fprintf(os.Stderr, "%s %s %s\r\n", pointer, box, file)
+		}
+		lines = len(files) + 1
+	}
+
+	buf := make([]byte, 3)
+	for {
+		render()
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read selection: %w", err)
+		}
+		switch key := string(buf[:n]); key {
+		case "\x1b[A", "k":
+			cursor = (cursor + len(files) - 1) % len(files)
+		case "\x1b[B", "j":
+			cursor = (cursor + 1) % len(files)
+		case " ":
+			selected[cursor] = !selected[cursor]
+		case "a":
+			all := true
+			for _, s := range selected {
+				all = all && s
+			}
+			for i := range selected {
+				selected[i] = !all
+			}
+		case "\r", "\n":
+			var chosen []string
+			for i, file := range files {
+				if selected[i] {
+					chosen = append(chosen, file)
+				}
+			}
+			return chosen, nil
+		case "q", "\x1b", "\x03":
+			return nil, fmt.Errorf("aborted")
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/hosting"
+)
+
+// Recover re-uploads the encryption key to the repository secrets after the secret was deleted
+// The key comes from this machine's local key or from a file written by export-key
+func Recover(args []string) error {
+	flags := flag.NewFlagSet("recover", flag.ContinueOnError)
+	from := flags.String("from", "", "recover from a key file written by export-key")
+	force := flags.Bool("force", false, "overwrite the secret even if it still exists")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	switch crypto.CurrentMode() {
+	case crypto.ModeKeyring:
+		return fmt.Errorf("the key is stored in %s in %s mode; there is no repository secret to recover", crypto.KeyringFile, crypto.ModeKeyring)
+	case crypto.ModePassphrase:
+		return fmt.Errorf("the key is derived from the passphrase in %s mode; there is no repository secret to recover", crypto.ModePassphrase)
+	}
+
+	ctx := context.Background()
+	exists, err := hosting.SecretExists(ctx)
+	if err != nil {
+		return err
+	}
+	if exists && !*force {
+		return fmt.Errorf("secret %s still exists; use --force to replace it", github.SecretName)
+	}
+
+	key, err := recoveryKey(*from)
+	if err != nil {
+		return err
+	}
+
+	// Refuse to upload a key that cannot decrypt what is already committed
+	checked, err := verifyRecoveryKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := hosting.StoreEncryptionKey(ctx, key); err != nil {
+		return err
+	}
+	if *from != "" {
+		if err := crypto.SaveLocalKey(key); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("✓ Encryption key %s uploaded to the %s secret\n", crypto.KeyID(key), github.SecretName)
+	if checked > 0 {
+		fmt.Printf("✓ Key verified against %d encrypted file(s)\n", checked)
+	}
+	fmt.Println("Note: collaborators can now check out encrypted files again")
+	return nil
+}
+
+// recoveryKey loads the key to recover from a key file or the local key of this repository
+func recoveryKey(from string) ([]byte, error) {
+	if from != "" {
+		data, err := os.ReadFile(from)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", from, err)
+		}
+		passphrase, err := readPassphrase("Passphrase for the exported key: ", false)
+		if err != nil {
+			return nil, err
+		}
+		return crypto.ImportKey(data, passphrase)
+	}
+
+	key, err := crypto.LoadLocalKey()
+	if err != nil {
+		return nil, fmt.Errorf("this machine holds no copy of the key. " +
+			"Ask a collaborator who does to run 'git ez-env recover', or recover from a backup with --from <file>; " +
+			"if nobody has a copy, the encrypted files cannot be decrypted")
+	}
+	return key, nil
+}
+
+// verifyRecoveryKey checks that key decrypts the encrypted files in the index
+// It returns the number of files that were checked
+func verifyRecoveryKey(key []byte) (int, error) {
+	entries, err := encryptedIndexEntries()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list encrypted files: %w", err)
+	}
+
+	checked := 0
+	for _, entry := range entries {
+		content, err := catFileBlob(entry.Object)
+		if err != nil {
+			return 0, err
+		}
+		if !crypto.IsEncryptedFile(content) {
+			continue
+		}
+		if _, err := crypto.DecryptFile(content, key); err != nil {
+			return 0, fmt.Errorf("key %s cannot decrypt %s; it is not the repository key", crypto.KeyID(key), entry.Path)
+		}
+		checked++
+	}
+	return checked, nil
+}
+
+// checkSecretNotLost fails when the repository secret is missing although encrypted files are committed,
+// because creating a new key would leave those files undecryptable
+func checkSecretNotLost(ctx context.Context) error {
+	if crypto.CurrentMode() != crypto.ModeSharedKey {
+		return nil
+	}
+	if _, err := crypto.LoadLocalKey(); err == nil {
+		return nil
+	}
+	if exists, err := hosting.SecretExists(ctx); err != nil || exists {
+		return nil
+	}
+
+	entries, err := encryptedIndexEntries()
+	if err != nil || len(entries) == 0 {
+		return nil
+	}
+	return fmt.Errorf("secret %s is missing but %d encrypted file(s) are committed; "+
+		"run 'git ez-env recover' from a machine that still holds the key instead of creating a new one", github.SecretName, len(entries))
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/oliviaBahr/ez-env/sidecar"
+	"github.com/oliviaBahr/ez-env/workpool"
+)
+
+// ReEncrypt re-encrypts every managed file with the current key and encryption settings
+// This is the equivalent of re-running the clean filter on every file, e.g. after changing
+// ezenv.padding or upgrading the format; the staged content is used and the result is staged
+func ReEncrypt(args []string) error {
+	flags := flag.NewFlagSet("re-encrypt", flag.ContinueOnError)
+	jobs := flags.Int("jobs", workpool.DefaultJobs(), "number of files re-encrypted in parallel")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *jobs < 1 {
+		return fmt.Errorf("--jobs must be at least 1")
+	}
+
+	entries, err := encryptedIndexEntries()
+	if err != nil {
+		return fmt.Errorf("failed to list encrypted files: %w", err)
+	}
+	sidecarEntries, err := indexEntries(sidecar.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to list sidecar files: %w", err)
+	}
+	entries = append(entries, sidecarEntries...)
+
+	// Symlinks are stored as link targets and never encrypted
+	regular := entries[:0]
+	for _, entry := range entries {
+		if entry.Mode != "120000" {
+			regular = append(regular, entry)
+		}
+	}
+	entries = regular
+	if len(entries) == 0 {
+		fmt.Println("No encrypted files to re-encrypt")
+		return nil
+	}
+
+	// Files are re-encrypted with the key of their environment
+	ctx := context.Background()
+	keys := filterKeys{}
+	defer keys.destroy()
+	groups, err := entriesByEnvironment(entries)
+	if err != nil {
+		return err
+	}
+	var updated []indexEntry
+	for _, environment := range groupNames(groups) {
+		key, err := keys.forEnvironment(ctx, environment)
+		if err != nil {
+			return err
+		}
+		transform, err := reencryptTransform(key.Bytes(), key.Bytes())
+		if err != nil {
+			return err
+		}
+		rekeyed, err := rekeyEntries(groups[environment], *jobs, transform)
+		if err != nil {
+			return err
+		}
+		updated = append(updated, rekeyed...)
+	}
+	if err := stageRekeyed(updated); err != nil {
+		return err
+	}
+	for _, entry := range updated {
+		fmt.Printf("✓ Re-encrypted %s\n", entry.Path)
+	}
+
+	fmt.Printf("✓ Re-encrypted %d file(s)\n", len(updated))
+	fmt.Println("Note: commit the staged changes; unstaged edits in the working tree were not touched")
+	return nil
+}
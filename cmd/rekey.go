@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/sidecar"
+	"github.com/oliviaBahr/ez-env/workpool"
+)
+
+// rekeyTransform turns the content of one index blob into its replacement
+type rekeyTransform func(entry indexEntry, content []byte) ([]byte, error)
+
+// rekeyEntries is the bulk re-key engine behind rotate-key and re-encrypt
+// It reads the blobs of entries through one cat-file process, transforms them on up to jobs
+// workers and writes the results through one hash-object process, without running any filters.
+// Every failing entry is reported. The returned entries keep the order of entries and point at
+// the new blobs; the index is not touched
+func rekeyEntries(entries []indexEntry, jobs int, transform rekeyTransform) ([]indexEntry, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	// forEachBlob skips objects that are missing or not blobs, so blobs are matched to entries by
+	// object id; entries sharing an object are handed out in order
+	objects := make([]string, len(entries))
+	waiting := make(map[string][]int)
+	for i, entry := range entries {
+		objects[i] = entry.Object
+		waiting[entry.Object] = append(waiting[entry.Object], i)
+	}
+	results := make([][]byte, len(entries))
+	pool := workpool.New(min(jobs, len(entries)))
+	readErr := forEachBlob(objects, func(object string, content []byte) error {
+		indexes := waiting[object]
+		if len(indexes) == 0 {
+			return fmt.Errorf("git returned the unexpected blob %s", object)
+		}
+		index := indexes[0]
+		waiting[object] = indexes[1:]
+		pool.Go(func() error {
+			result, err := transform(entries[index], content)
+			results[index] = result
+			return err
+		})
+		return nil
+	})
+	if err := pool.Wait(); err != nil {
+		return nil, err
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	for _, entry := range entries {
+		if len(waiting[entry.Object]) > 0 {
+			return nil, fmt.Errorf("failed to read the blob %s of %s", entry.Object, entry.Path)
+		}
+	}
+
+	written, err := writeBlobs(results)
+	if err != nil {
+		return nil, err
+	}
+	updated := make([]indexEntry, len(entries))
+	for i, entry := range entries {
+		entry.Object = written[i]
+		updated[i] = entry
+	}
+	return updated, nil
+}
+
+// writeBlobs stores each content in the object database with a single hash-object process and
+// returns the object ids in order
+func writeBlobs(contents [][]byte) ([]string, error) {
+	dir, err := os.MkdirTemp("", "ezenv-blobs-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var paths strings.Builder
+	for i, content := range contents {
+		path := filepath.Join(dir, strconv.Itoa(i))
+		if err := os.WriteFile(path, content, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write temporary blob: %w", err)
+		}
+		paths.WriteString(path + "\n")
+	}
+
+	output, err := gitOutputRaw([]byte(paths.String()), "hash-object", "-w", "--no-filters", "--stdin-paths")
+	if err != nil {
+		return nil, fmt.Errorf("failed to write blobs: %w", err)
+	}
+	objects := strings.Fields(string(output))
+	if len(objects) != len(contents) {
+		return nil, fmt.Errorf("failed to write blobs: expected %d objects, got %d", len(contents), len(objects))
+	}
+	return objects, nil
+}
+
+// stageEntries points the index entries at their objects with a single update-index process
+func stageEntries(entries []indexEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	var info bytes.Buffer
+	for _, entry := range entries {
+		// Format: <mode> SP <object> TAB <path> NUL
+		fmt.Fprintf(&info, "%s %s\t%s\x00", entry.Mode, entry.Object, entry.Path)
+	}
+	cmd := exec.Command("git", "update-index", "-z", "--index-info")
+	cmd.Stdin = &info
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to update the index: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// stageRekeyed stages re-keyed entries and refreshes the sidecar files among them, which hold
+// ciphertext in the working tree as well
+func stageRekeyed(entries []indexEntry) error {
+	if err := stageEntries(entries); err != nil {
+		return err
+	}
+	var sidecarPaths []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Path, sidecar.Dir+"/") {
+			sidecarPaths = append(sidecarPaths, entry.Path)
+		}
+	}
+	if len(sidecarPaths) == 0 {
+		return nil
+	}
+	if _, err := gitOutput(append([]string{"checkout-index", "-f", "--"}, sidecarPaths...)...); err != nil {
+		return fmt.Errorf("failed to update sidecar files: %w", err)
+	}
+	return nil
+}
+
+// reencryptTransform decrypts a blob with oldKey and encrypts it with newKey using the current
+// encryption settings; blobs that were committed in plaintext are encrypted as well
+// The settings are read once, since reading them runs git
+func reencryptTransform(oldKey, newKey []byte) (rekeyTransform, error) {
+	opts, err := encryptOptions("")
+	if err != nil {
+		return nil, err
+	}
+	bind, err := bindPathsEnabled()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(entry indexEntry, content []byte) ([]byte, error) {
+		plaintext := content
+		if crypto.IsEncryptedFile(content) {
+			var err error
+			if plaintext, err = crypto.DecryptFile(content, oldKey); err != nil {
+				return nil, fmt.Errorf("failed to decrypt %s: %w", entry.Path, err)
+			}
+		}
+
+		entryOpts := opts
+		if bind {
+			entryOpts.Path = entry.Path
+		}
+		encrypted, err := crypto.EncryptFileWithOptions(plaintext, newKey, entryOpts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt %s: %w", entry.Path, err)
+		}
+		return encrypted, nil
+	}, nil
+}
+
+// rewrapTransform re-encrypts the per-file key of a blob from oldKey to newKey and leaves the
+// content as is; blobs without a per-file key, including plaintext ones, are fully re-encrypted
+func rewrapTransform(oldKey, newKey []byte) (rekeyTransform, error) {
+	reencrypt, err := reencryptTransform(oldKey, newKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(entry indexEntry, content []byte) ([]byte, error) {
+		rewrapped, err := crypto.RewrapFile(content, oldKey, newKey)
+		if errors.Is(err, crypto.ErrNoFileKey) {
+			return reencrypt(entry, content)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to rewrap %s: %w", entry.Path, err)
+		}
+		return rewrapped, nil
+	}, nil
+}
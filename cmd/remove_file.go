@@ -2,85 +2,39 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"os/exec"
-	"strings"
 )
 
-// RemoveFile removes a file from the list of files that should be encrypted
+// RemoveFile removes files or patterns from the list of files that should be encrypted
+// All arguments are checked first and .ezenv.yml and .gitattributes are written once
 func RemoveFile(args []string) error {
 	if len(args) < 1 {
 		return fmt.Errorf("no file specified")
 	}
 
-	filePath := args[0]
-
-	// Remove the file pattern from .gitattributes
-	if err := removeFromGitAttributes(filePath); err != nil {
-		return fmt.Errorf("failed to remove file from .gitattributes: %w", err)
-	}
-
-	fmt.Printf("✓ File removed from encryption: %s\n", filePath)
-	fmt.Printf("Note: The file will no longer be encrypted on git add/commit\n")
-
-	return nil
-}
-
-// removeFromGitAttributes removes a file pattern from .gitattributes
-func removeFromGitAttributes(filePath string) error {
-	// Read existing .gitattributes
-	content, err := os.ReadFile(".gitattributes")
+	config, err := loadRepoConfig()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return fmt.Errorf(".gitattributes file does not exist")
-		}
-		return fmt.Errorf("failed to read .gitattributes: %w", err)
+		return err
 	}
-
-	// Check if the pattern exists
-	if !containsPattern(string(content), filePath) {
-		return fmt.Errorf("file pattern not found in .gitattributes: %s", filePath)
+	existing, err := config.files()
+	if err != nil {
+		return err
 	}
-
-	// Remove the pattern
-	lines := strings.Split(string(content), "\n")
-	var newLines []string
-	pattern := filePath + " filter=ezenv"
-
-	for _, line := range lines {
-		if strings.TrimSpace(line) != pattern {
-			newLines = append(newLines, line)
+	// A directory argument refers to the dir/** pattern that add created for it
+	for i, arg := range args {
+		if pattern, ok := directoryOrGlobPattern(arg); ok && !containsManagedPattern(existing, arg) {
+			args[i] = pattern
 		}
 	}
 
-	// If no lines left (except empty ones), remove the file
-	if len(newLines) == 0 || (len(newLines) == 1 && strings.TrimSpace(newLines[0]) == "") {
-		if err := os.Remove(".gitattributes"); err != nil {
-			return fmt.Errorf("failed to remove .gitattributes: %w", err)
-		}
-	} else {
-		// Write updated content
-		newContent := strings.Join(newLines, "\n")
-		if !strings.HasSuffix(newContent, "\n") {
-			newContent += "\n"
-		}
-		if err := os.WriteFile(".gitattributes", []byte(newContent), 0644); err != nil {
-			return fmt.Errorf("failed to write .gitattributes: %w", err)
-		}
+	// Remove the file patterns from .ezenv.yml and .gitattributes
+	if err := removeManagedPatterns(args...); err != nil {
+		return fmt.Errorf("failed to remove files from %s: %w", RepoConfigFile, err)
 	}
 
-	// Add .gitattributes to git (or remove if deleted)
-	if _, err := os.Stat(".gitattributes"); err == nil {
-		addCmd := exec.Command("git", "add", ".gitattributes")
-		if err := addCmd.Run(); err != nil {
-			return fmt.Errorf("failed to add .gitattributes to git: %w", err)
-		}
-	} else {
-		rmCmd := exec.Command("git", "rm", ".gitattributes")
-		if err := rmCmd.Run(); err != nil {
-			return fmt.Errorf("failed to remove .gitattributes from git: %w", err)
-		}
+	for _, filePath := range args {
+		fmt.Printf("✓ File removed from encryption: %s\n", filePath)
 	}
+	fmt.Printf("Note: %d pattern(s) will no longer be encrypted on git add/commit\n", len(args))
 
 	return nil
 }
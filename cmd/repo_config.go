@@ -0,0 +1,679 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/patternlog"
+)
+
+// The committed .ezenv.yml holds everything about ez-env that everyone working on the repository
+// shares: the settings under their config names, the key mode and keyring backend, the GitHub
+// Environments with keys of their own, and the managed files with the environment whose key
+// encrypts them:
+//
+//	version: 1
+//	mode: shared-key
+//	failMode: soft
+//	environments:
+//	  - production
+//	files:
+//	  - .env
+//	  - pattern: deploy/prod.env
+//	    environment: production
+//
+// .gitattributes is generated from the files, since git reads the filters from there; the file is
+// edited as a YAML tree, so comments and key order written by hand survive ez-env's changes.
+
+// RepoConfigFile is the committed configuration shared by everyone working on the repository
+const RepoConfigFile = ".ezenv.yml"
+
+// legacyRepoConfigFile held the committed settings in the git config format before .ezenv.yml
+// It is still read; settings written since move to RepoConfigFile
+const legacyRepoConfigFile = ".ezenv/config"
+
+// repoConfigVersion is the version of the .ezenv.yml layout
+const repoConfigVersion = 1
+
+// gitAttributesHeader starts the ez-env section of a generated .gitattributes
+const gitAttributesHeader = "# ezenv encrypted files"
+
+// Keys of .ezenv.yml besides the settings
+const (
+	configKeyVersion      = "version"
+	configKeyMode         = "mode"
+	configKeyBackend      = "backend"
+	configKeyEnvironments = "environments"
+	configKeyFiles        = "files"
+)
+
+// managedPattern is a pattern of files encrypted by ez-env and the environment whose key
+// encrypts them, empty for the repository key
+type managedPattern struct {
+	Pattern     string `yaml:"pattern"`
+	Environment string `yaml:"environment,omitempty"`
+}
+
+// repoConfig is a .ezenv.yml file, kept as a YAML node tree
+type repoConfig struct {
+	path string
+	doc  *yaml.Node
+}
+
+// repoConfigs caches the configurations read by this process by path
+var repoConfigs = map[string]*repoConfig{}
+
+// loadRepoConfig returns the .ezenv.yml of the current working tree, empty when it does not exist
+func loadRepoConfig() (*repoConfig, error) {
+	root, err := repoRoot()
+	if err != nil {
+		return nil, err
+	}
+	return loadRepoConfigAt(filepath.Join(root, RepoConfigFile))
+}
+
+// loadRepoConfigAt returns the .ezenv.yml at path, empty when it does not exist
+func loadRepoConfigAt(path string) (*repoConfig, error) {
+	if config, ok := repoConfigs[path]; ok {
+		return config, nil
+	}
+	config := &repoConfig{path: path, doc: &yaml.Node{Kind: yaml.DocumentNode}}
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", RepoConfigFile, err)
+	}
+	if len(bytes.TrimSpace(content)) > 0 {
+		if err := yaml.Unmarshal(content, config.doc); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", RepoConfigFile, err)
+		}
+	}
+	if len(config.doc.Content) == 0 {
+		config.doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+	if config.root().Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s must be a mapping of settings", RepoConfigFile)
+	}
+	repoConfigs[path] = config
+	return config, nil
+}
+
+// root returns the top-level mapping
+func (c *repoConfig) root() *yaml.Node {
+	return c.doc.Content[0]
+}
+
+// exists reports whether the file exists
+func (c *repoConfig) exists() bool {
+	_, err := os.Stat(c.path)
+	return err == nil
+}
+
+// node returns the value of a top-level key, or nil when it is not set
+func (c *repoConfig) node(key string) *yaml.Node {
+	root := c.root()
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == key {
+			return root.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// value returns the scalar value of a top-level key
+func (c *repoConfig) value(key string) (string, bool) {
+	node := c.node(key)
+	if node == nil || node.Kind != yaml.ScalarNode || node.Tag == "!!null" {
+		return "", false
+	}
+	return node.Value, true
+}
+
+// set sets a top-level key to a scalar value or, for an empty value, removes it
+// New keys go before the files, which read best at the end
+func (c *repoConfig) set(key, value string) {
+	root := c.root()
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != key {
+			continue
+		}
+		if value == "" {
+			root.Content = append(root.Content[:i], root.Content[i+2:]...)
+		} else {
+			comment := root.Content[i+1].LineComment
+			root.Content[i+1] = scalarNode(value)
+			root.Content[i+1].LineComment = comment
+		}
+		return
+	}
+	if value == "" {
+		return
+	}
+	at := len(root.Content)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == configKeyFiles {
+			at = i
+		}
+	}
+	pair := []*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, scalarNode(value)}
+	root.Content = append(root.Content[:at], append(pair, root.Content[at:]...)...)
+}
+
+// scalarNode returns a scalar holding value, plain when YAML reads it back as the same text,
+// so numbers and booleans are not quoted
+func scalarNode(value string) *yaml.Node {
+	var parsed yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &parsed); err == nil && len(parsed.Content) == 1 {
+		node := parsed.Content[0]
+		if node.Kind == yaml.ScalarNode && node.Style == 0 && node.Value == value && node.Tag != "!!null" {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: node.Tag, Value: value}
+		}
+	}
+	node := &yaml.Node{}
+	node.SetString(value)
+	return node
+}
+
+// files returns the managed patterns; before the files are listed in .ezenv.yml they are read
+// from .gitattributes, where older versions of ez-env kept them
+func (c *repoConfig) files() ([]managedPattern, error) {
+	node := c.node(configKeyFiles)
+	if node == nil {
+		return gitAttributesPatterns(filepath.Join(filepath.Dir(c.path), ".gitattributes"))
+	}
+	if node.Tag == "!!null" {
+		return nil, nil
+	}
+	if node.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("%s: files must be a list", RepoConfigFile)
+	}
+	patterns := make([]managedPattern, 0, len(node.Content))
+	for _, item := range node.Content {
+		var pattern managedPattern
+		switch item.Kind {
+		case yaml.ScalarNode:
+			pattern.Pattern = item.Value
+		case yaml.MappingNode:
+			if err := item.Decode(&pattern); err != nil {
+				return nil, fmt.Errorf("%s line %d: %w", RepoConfigFile, item.Line, err)
+			}
+		default:
+			return nil, fmt.Errorf("%s line %d: a file is a pattern or a mapping with pattern and environment", RepoConfigFile, item.Line)
+		}
+		if pattern.Pattern == "" {
+			return nil, fmt.Errorf("%s line %d: file without a pattern", RepoConfigFile, item.Line)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// environments returns the GitHub Environments whose keys encrypt files
+func (c *repoConfig) environments() ([]string, error) {
+	node := c.node(configKeyEnvironments)
+	if node == nil || node.Tag == "!!null" {
+		return nil, nil
+	}
+	var environments []string
+	if err := node.Decode(&environments); err != nil {
+		return nil, fmt.Errorf("%s line %d: environments must be a list of names", RepoConfigFile, node.Line)
+	}
+	return environments, nil
+}
+
+// addEnvironment lists environment unless it is already
+func (c *repoConfig) addEnvironment(environment string) error {
+	environments, err := c.environments()
+	if err != nil {
+		return err
+	}
+	if slices.Contains(environments, environment) {
+		return nil
+	}
+	item := &yaml.Node{}
+	item.SetString(environment)
+	if node := c.node(configKeyEnvironments); node != nil && node.Kind == yaml.SequenceNode {
+		node.Content = append(node.Content, item)
+		return nil
+	}
+	sequence := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: []*yaml.Node{item}}
+	root := c.root()
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == configKeyEnvironments {
+			root.Content[i+1] = sequence
+			return nil
+		}
+	}
+	// Before the files, which name the environments
+	at := len(root.Content)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == configKeyFiles {
+			at = i
+		}
+	}
+	pair := []*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: configKeyEnvironments}, sequence}
+	root.Content = append(root.Content[:at], append(pair, root.Content[at:]...)...)
+	return nil
+}
+
+// setFiles replaces the managed patterns, keeping the comments of the entries that remain
+func (c *repoConfig) setFiles(patterns []managedPattern) {
+	existing := map[string]*yaml.Node{}
+	if node := c.node(configKeyFiles); node != nil && node.Kind == yaml.SequenceNode {
+		for _, item := range node.Content {
+			var p managedPattern
+			if item.Kind == yaml.ScalarNode {
+				p.Pattern = item.Value
+			} else if item.Decode(&p) != nil {
+				continue
+			}
+			existing[p.Pattern+"\x00"+p.Environment] = item
+		}
+	}
+
+	sequence := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, p := range patterns {
+		item, ok := existing[p.Pattern+"\x00"+p.Environment]
+		if !ok {
+			item = &yaml.Node{}
+			item.SetString(p.Pattern)
+			if p.Environment != "" {
+				item = &yaml.Node{}
+				item.Encode(p)
+			}
+		}
+		sequence.Content = append(sequence.Content, item)
+	}
+
+	root := c.root()
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == configKeyFiles {
+			sequence.HeadComment = root.Content[i+1].HeadComment
+			root.Content[i+1] = sequence
+			return
+		}
+	}
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: configKeyFiles}, sequence)
+}
+
+// save writes the file and stages it
+func (c *repoConfig) save() error {
+	if !c.exists() {
+		c.doc.HeadComment = "ez-env configuration shared by everyone working on this repository\n" +
+			"Run 'git ez-env config list' for the settings and 'git ez-env config sync' after editing files"
+		if _, ok := c.value(configKeyVersion); !ok {
+			root := c.root()
+			version := []*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: configKeyVersion}, scalarNode(strconv.Itoa(repoConfigVersion))}
+			root.Content = append(version, root.Content...)
+		}
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.doc); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", RepoConfigFile, err)
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", RepoConfigFile, err)
+	}
+	if err := os.WriteFile(c.path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", RepoConfigFile, err)
+	}
+	if _, err := gitOutput("-C", filepath.Dir(c.path), "add", "--", RepoConfigFile); err != nil {
+		return fmt.Errorf("failed to add %s to git: %w", RepoConfigFile, err)
+	}
+	return nil
+}
+
+// validate checks every key and value, for the commands that report a hand-edited file
+func (c *repoConfig) validate() error {
+	root := c.root()
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, node := root.Content[i].Value, root.Content[i+1]
+		if key == configKeyFiles || key == configKeyEnvironments {
+			continue
+		}
+		if node.Kind != yaml.ScalarNode {
+			return fmt.Errorf("%s line %d: %s must be a single value", RepoConfigFile, node.Line, key)
+		}
+		var err error
+		switch key {
+		case configKeyVersion:
+			if node.Value != strconv.Itoa(repoConfigVersion) {
+				err = fmt.Errorf("unsupported version %s (this ez-env reads version %d)", node.Value, repoConfigVersion)
+			}
+		case configKeyMode:
+			err = validateMode(node.Value)
+		case configKeyBackend:
+			err = crypto.ValidateBackend(node.Value)
+		default:
+			s, lookupErr := lookupSetting(key)
+			if lookupErr != nil || s.key != key {
+				return fmt.Errorf("%s line %d: unknown setting %q (run 'git ez-env config list')", RepoConfigFile, root.Content[i].Line, key)
+			}
+			err = s.validate(node.Value)
+		}
+		if err != nil {
+			return fmt.Errorf("%s line %d: invalid value for %s: %w", RepoConfigFile, node.Line, key, err)
+		}
+	}
+
+	environments, err := c.environments()
+	if err != nil {
+		return err
+	}
+	for _, environment := range environments {
+		if err := crypto.ValidateEnvironment(environment); err != nil {
+			return fmt.Errorf("%s: %w", RepoConfigFile, err)
+		}
+	}
+	patterns, err := c.files()
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, p := range patterns {
+		if strings.ContainsAny(p.Pattern, " \t") || strings.HasPrefix(p.Pattern, "#") {
+			return fmt.Errorf("%s: %q cannot be written to .gitattributes", RepoConfigFile, p.Pattern)
+		}
+		if seen[p.Pattern] {
+			return fmt.Errorf("%s: %s is listed twice", RepoConfigFile, p.Pattern)
+		}
+		seen[p.Pattern] = true
+		if p.Environment != "" {
+			if err := crypto.ValidateEnvironment(p.Environment); err != nil {
+				return fmt.Errorf("%s: %s: %w", RepoConfigFile, p.Pattern, err)
+			}
+			if !slices.Contains(environments, p.Environment) {
+				return fmt.Errorf("%s: the environment %s of %s is not listed under environments", RepoConfigFile, p.Environment, p.Pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// validateMode checks a key mode
+func validateMode(value string) error {
+	switch value {
+	case crypto.ModeSharedKey, crypto.ModeKeyring, crypto.ModePassphrase:
+		return nil
+	}
+	return fmt.Errorf("must be %s, %s or %s", crypto.ModeSharedKey, crypto.ModeKeyring, crypto.ModePassphrase)
+}
+
+// recordKeyMode records the key mode and, in keyring mode, how the keyring wraps the key
+func recordKeyMode(mode, backend string) error {
+	config, err := loadRepoConfig()
+	if err != nil {
+		return err
+	}
+	if mode != crypto.ModeKeyring {
+		backend = ""
+	}
+	config.set(configKeyMode, mode)
+	config.set(configKeyBackend, backend)
+	if err := config.save(); err != nil {
+		return err
+	}
+	crypto.ConfiguredMode = mode
+	return nil
+}
+
+// recordEnvironment lists environment in .ezenv.yml
+func recordEnvironment(environment string) error {
+	config, err := loadRepoConfig()
+	if err != nil {
+		return err
+	}
+	if err := config.addEnvironment(environment); err != nil {
+		return err
+	}
+	return config.save()
+}
+
+// updateManagedFiles changes the managed patterns with change, then writes .ezenv.yml and the
+// .gitattributes generated from it
+func updateManagedFiles(change func(patterns []managedPattern) ([]managedPattern, error)) error {
+	config, err := loadRepoConfig()
+	if err != nil {
+		return err
+	}
+	patterns, err := config.files()
+	if err != nil {
+		return err
+	}
+	if patterns, err = change(patterns); err != nil {
+		return err
+	}
+	for _, p := range patterns {
+		if p.Environment != "" {
+			if err := config.addEnvironment(p.Environment); err != nil {
+				return err
+			}
+		}
+	}
+	config.setFiles(patterns)
+	if err := config.save(); err != nil {
+		return err
+	}
+	return syncGitAttributes(config)
+}
+
+// addManagedPatterns adds patterns, encrypted with the key of environment unless it is empty, and
+// records the ones that were not managed yet in the pattern log
+func addManagedPatterns(environment string, patterns ...string) error {
+	var added []string
+	err := updateManagedFiles(func(existing []managedPattern) ([]managedPattern, error) {
+		for _, pattern := range patterns {
+			if containsManagedPattern(existing, pattern) {
+				continue
+			}
+			existing = append(existing, managedPattern{Pattern: pattern, Environment: environment})
+			added = append(added, pattern)
+		}
+		return existing, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, pattern := range added {
+		if err := recordPatternChange(patternlog.ActionAdd, pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeManagedPatterns removes patterns, failing before any change when one is not managed
+func removeManagedPatterns(patterns ...string) error {
+	err := updateManagedFiles(func(existing []managedPattern) ([]managedPattern, error) {
+		for _, pattern := range patterns {
+			if !containsManagedPattern(existing, pattern) {
+				return nil, fmt.Errorf("file pattern not found in %s: %s", RepoConfigFile, pattern)
+			}
+		}
+		var kept []managedPattern
+		for _, p := range existing {
+			if !slices.Contains(patterns, p.Pattern) {
+				kept = append(kept, p)
+			}
+		}
+		return kept, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, pattern := range patterns {
+		if err := recordPatternChange(patternlog.ActionRemove, pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// containsManagedPattern reports whether pattern is among patterns
+func containsManagedPattern(patterns []managedPattern, pattern string) bool {
+	for _, p := range patterns {
+		if p.Pattern == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// gitAttributesLine returns the .gitattributes line of a managed pattern
+func gitAttributesLine(p managedPattern) string {
+	line := p.Pattern + " " + attributes
+	if p.Environment != "" {
+		line += " " + crypto.EnvironmentAttribute + "=" + p.Environment
+	}
+	return line
+}
+
+// generateGitAttributes returns content with its ez-env lines replaced by the lines of patterns,
+// keeping every other line in place
+func generateGitAttributes(content string, patterns []managedPattern) string {
+	var kept []string
+	for _, line := range strings.Split(strings.TrimRight(content, "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		fields := strings.Fields(line)
+		if trimmed == gitAttributesHeader || strings.HasPrefix(trimmed, "# Files will be added here") {
+			continue
+		}
+		if len(fields) > 1 && !strings.HasPrefix(fields[0], "#") && usesEzenvDriver(fields[1:]) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	for len(kept) > 0 && strings.TrimSpace(kept[len(kept)-1]) == "" {
+		kept = kept[:len(kept)-1]
+	}
+
+	lines := kept
+	if len(patterns) > 0 {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, gitAttributesHeader)
+		for _, p := range patterns {
+			lines = append(lines, gitAttributesLine(p))
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// syncGitAttributes writes the ez-env lines of .gitattributes from the managed files of config and
+// stages it, removing .gitattributes when nothing else is left in it
+func syncGitAttributes(config *repoConfig) error {
+	patterns, err := config.files()
+	if err != nil {
+		return err
+	}
+	root := filepath.Dir(config.path)
+	path := filepath.Join(root, ".gitattributes")
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read .gitattributes: %w", err)
+	}
+
+	generated := generateGitAttributes(string(content), patterns)
+	if generated == "" {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove .gitattributes: %w", err)
+		}
+		// Fails when .gitattributes was never tracked, which is fine
+		gitOutput("-C", root, "rm", "--quiet", "--cached", "--ignore-unmatch", ".gitattributes")
+		return nil
+	}
+	if generated != string(content) {
+		if err := os.WriteFile(path, []byte(generated), 0644); err != nil {
+			return fmt.Errorf("failed to write .gitattributes: %w", err)
+		}
+	}
+	if _, err := gitOutput("-C", root, "add", "--", ".gitattributes"); err != nil {
+		return fmt.Errorf("failed to add .gitattributes to git: %w", err)
+	}
+	return nil
+}
+
+// checkGitAttributesInSync fails when .gitattributes does not assign the filters to exactly the
+// files listed in .ezenv.yml, as after editing either by hand
+func checkGitAttributesInSync() error {
+	config, err := loadRepoConfig()
+	if err != nil {
+		return err
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if config.node(configKeyFiles) == nil {
+		return nil
+	}
+	patterns, err := config.files()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(filepath.Dir(config.path), ".gitattributes")
+	listed, err := gitAttributesPatterns(path)
+	if err != nil {
+		return err
+	}
+	want := map[string]string{}
+	for _, p := range patterns {
+		want[p.Pattern] = p.Environment
+	}
+	got := map[string]string{}
+	for _, p := range listed {
+		got[p.Pattern] = p.Environment
+		if environment, ok := want[p.Pattern]; !ok {
+			return fmt.Errorf("%s is encrypted by .gitattributes but not listed in %s", p.Pattern, RepoConfigFile)
+		} else if environment != p.Environment {
+			return fmt.Errorf("%s has environment %q in .gitattributes but %q in %s", p.Pattern, p.Environment, environment, RepoConfigFile)
+		}
+	}
+	for _, p := range patterns {
+		if _, ok := got[p.Pattern]; !ok {
+			return fmt.Errorf("%s is listed in %s but missing from .gitattributes", p.Pattern, RepoConfigFile)
+		}
+	}
+	return nil
+}
+
+// gitAttributesPatterns returns the patterns .gitattributes at path assigns the ezenv filter to
+func gitAttributesPatterns(path string) ([]managedPattern, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .gitattributes: %w", err)
+	}
+	var patterns []managedPattern
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || !isPatternLine(line, fields[0]) {
+			continue
+		}
+		p := managedPattern{Pattern: fields[0]}
+		for _, attr := range fields[1:] {
+			if value, ok := strings.CutPrefix(attr, crypto.EnvironmentAttribute+"="); ok {
+				p.Environment = value
+			}
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/dotenv"
+)
+
+// Resolve interactively resolves a merge conflict in an encrypted dotenv file
+// The base/ours/theirs versions are decrypted and merged variable by variable,
+// prompting only for variables changed differently on both sides
+func Resolve(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("no file specified")
+	}
+
+	filePath := args[0]
+
+	stages, err := conflictStages(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read conflict state: %w", err)
+	}
+	if len(stages) == 0 {
+		return fmt.Errorf("file is not in a conflicted state: %s", filePath)
+	}
+
+	// Get encryption key
+	ctx := context.Background()
+	keyManager, err := fileKeyManager(filePath)
+	if err != nil {
+		return err
+	}
+	key, err := keyManager.GetEncryptionKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	defer key.Destroy()
+
+	// Decrypt each side of the conflict
+	versions := make(map[int]*dotenv.File)
+	for _, stage := range []int{1, 2, 3} {
+		versions[stage] = &dotenv.File{}
+		if !stages[stage] {
+			continue
+		}
+		plaintext, err := readStage(stage, filePath, key.Bytes())
+		if err != nil {
+			return err
+		}
+		versions[stage] = dotenv.Parse(plaintext)
+	}
+
+	base, ours, theirs := versions[1], versions[2], versions[3]
+	if len(ours.Keys()) == 0 && len(theirs.Keys()) == 0 {
+		return fmt.Errorf("%s does not contain KEY=VALUE variables; resolve it with 'git checkout --ours' or '--theirs' instead", filePath)
+	}
+
+	merged, err := mergeVariables(base, ours, theirs, bufio.NewReader(os.Stdin))
+	if err != nil {
+		return err
+	}
+
+	// Write the plaintext result and stage it (the clean filter re-encrypts it)
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(filePath); err == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := os.WriteFile(filePath, merged.Bytes(), perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filePath, err)
+	}
+
+	addCmd := exec.Command("git", "add", "--", filePath)
+	if err := addCmd.Run(); err != nil {
+		return fmt.Errorf("failed to mark %s as resolved: %w", filePath, err)
+	}
+
+	fmt.Printf("✓ Conflict resolved: %s\n", filePath)
+	return nil
+}
+
+// readStage reads and decrypts one stage of a conflicted path from the index
+func readStage(stage int, filePath string, key []byte) ([]byte, error) {
+	content, err := catFileBlob(fmt.Sprintf(":%d:%s", stage, filePath))
+	if err != nil {
+		return nil, err
+	}
+
+	if !crypto.IsEncryptedFile(content) {
+		return content, nil
+	}
+
+	plaintext, err := crypto.DecryptFile(content, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s (%s): %w", filePath, stageName(stage), err)
+	}
+	return plaintext, nil
+}
+
+// mergeVariables performs a three-way merge of dotenv variables, starting from ours
+func mergeVariables(base, ours, theirs *dotenv.File, input *bufio.Reader) (*dotenv.File, error) {
+	merged := &dotenv.File{Lines: append([]dotenv.Line(nil), ours.Lines...)}
+
+	keys := ours.Keys()
+	for _, key := range theirs.Keys() {
+		if _, ok := ours.Get(key); !ok {
+			keys = append(keys, key)
+		}
+	}
+
+	conflicts := 0
+	for _, key := range keys {
+		b, hasBase := base.Get(key)
+		o, hasOurs := ours.Get(key)
+		t, hasTheirs := theirs.Get(key)
+
+		var choice int
+		switch {
+		case hasOurs == hasTheirs && o == t:
+			choice = 2
+		case hasOurs == hasBase && o == b:
+			choice = 3
+		case hasTheirs == hasBase && t == b:
+			choice = 2
+		default:
+			conflicts++
+			var err error
+			choice, err = promptResolution(key, versionValue(b, hasBase), versionValue(o, hasOurs), versionValue(t, hasTheirs), input)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		value, ok := o, hasOurs
+		switch choice {
+		case 1:
+			value, ok = b, hasBase
+		case 3:
+			value, ok = t, hasTheirs
+		}
+		if ok {
+			merged.Set(key, value)
+		} else {
+			merged.Delete(key)
+		}
+	}
+
+	fmt.Printf("✓ Merged %d variables (%d resolved manually)\n", len(keys), conflicts)
+	return merged, nil
+}
+
+// promptResolution asks the user which version of a conflicting variable to keep
+func promptResolution(key, base, ours, theirs string, input *bufio.Reader) (int, error) {
+	fmt.Printf("\nConflict in %s:\n", key)
+	fmt.Printf("  base:   %s\n", base)
+	fmt.Printf("  ours:   %s\n", ours)
+	fmt.Printf("  theirs: %s\n", theirs)
+
+	for {
+		fmt.Printf("Keep (o)urs, (t)heirs or (b)ase? ")
+		answer, err := input.ReadString('\n')
+		if err != nil && (err != io.EOF || answer == "") {
+			return 0, fmt.Errorf("resolution aborted")
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "o", "ours":
+			return 2, nil
+		case "t", "theirs":
+			return 3, nil
+		case "b", "base":
+			return 1, nil
+		}
+	}
+}
+
+// versionValue formats a variable value for display, marking missing variables
+func versionValue(value string, ok bool) string {
+	if !ok {
+		return "(deleted)"
+	}
+	return value
+}
+
+// stageName returns the human readable name of an index stage
+func stageName(stage int) string {
+	switch stage {
+	case 1:
+		return "base"
+	case 2:
+		return "ours"
+	default:
+		return "theirs"
+	}
+}
@@ -0,0 +1,417 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+// rewriteBackupPrefix is where the original refs are kept after a history rewrite
+const rewriteBackupPrefix = "refs/ezenv-original/"
+
+// Rewrite rewrites the history of branches and tags so that every revision of the given paths
+// is encrypted (or, with --purge, removed), then prints the steps needed to publish the result
+// The original refs are kept under refs/ezenv-original/ until the rewrite has been verified
+func Rewrite(args []string) error {
+	flags := flag.NewFlagSet("rewrite", flag.ContinueOnError)
+	purge := flags.Bool("purge", false, "remove the paths from history instead of encrypting them")
+	yes := flags.Bool("yes", false, "do not ask for confirmation")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		return fmt.Errorf("no path specified")
+	}
+
+	if _, err := gitOutput("diff-index", "--cached", "--quiet", "HEAD", "--"); err != nil {
+		return fmt.Errorf("the index has staged changes; commit or unstage them before rewriting history")
+	}
+	if output, _ := gitOutput("for-each-ref", "--count=1", rewriteBackupPrefix); output != "" {
+		return fmt.Errorf("a previous rewrite left backups under %s; delete them with 'git for-each-ref --format=\"delete %%(refname)\" %s | git update-ref --stdin' first", rewriteBackupPrefix, rewriteBackupPrefix)
+	}
+
+	rewriter := &historyRewriter{paths: paths, purge: *purge, blobs: make(map[string]string), commits: make(map[string]string), environments: make(map[string]string)}
+	if !*purge {
+		managed, err := pathsWithFilter(paths)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			if !managed[path] {
+				return fmt.Errorf("%s is not managed by ez-env; run 'git ez-env add %s' first so new commits stay encrypted", path, path)
+			}
+		}
+
+		// Files with an ezenv-environment attribute are encrypted with their environment's key
+		keys := filterKeys{}
+		defer keys.destroy()
+		// Blobs are rewritten once however many paths share them, so they are not bound to a path
+		opts, err := encryptOptions("")
+		if err != nil {
+			return err
+		}
+		rewriter.encrypt = func(environment string, plaintext []byte) ([]byte, error) {
+			key, err := keys.forEnvironment(context.Background(), environment)
+			if err != nil {
+				return nil, err
+			}
+			return crypto.EncryptFileWithOptions(plaintext, key.Bytes(), opts)
+		}
+	}
+
+	refs, err := rewritableRefs()
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return fmt.Errorf("no branches or tags to rewrite")
+	}
+
+	action := "encrypt"
+	if *purge {
+		action = "remove"
+	}
+	if !*yes {
+		fmt.Printf("This rewrites every commit of %d branch(es) and tag(s) to %s %s.\n", len(refs), action, strings.Join(paths, ", "))
+		ok, err := confirm("Rewrite history?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("aborted")
+		}
+	}
+
+	tmpDir, err := privateTempDir("rewrite-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	rewriter.index = filepath.Join(tmpDir, "index")
+
+	if err := rewriter.rewriteCommits(refs); err != nil {
+		return err
+	}
+
+	updated := 0
+	for _, ref := range refs {
+		changed, err := rewriter.updateRef(ref)
+		if err != nil {
+			return err
+		}
+		if changed {
+			fmt.Printf("✓ Rewrote %s\n", ref.name)
+			updated++
+		}
+	}
+	if updated == 0 {
+		fmt.Println("✓ History already clean; nothing was rewritten")
+		return nil
+	}
+
+	// Bring the index in line with the rewritten HEAD; the working tree is left alone
+	if _, err := gitOutput("reset", "-q"); err != nil {
+		return fmt.Errorf("failed to reset the index: %w", err)
+	}
+
+	fmt.Printf("✓ Rewrote %d commit(s) on %d ref(s)\n", rewriter.rewritten, updated)
+	fmt.Printf("Original refs are kept under %s\n", rewriteBackupPrefix)
+	fmt.Println("\nTo publish the rewritten history:")
+	fmt.Println("  git push --force-with-lease --all")
+	fmt.Println("  git push --force --tags")
+	fmt.Println("Then ask every collaborator to re-clone, and once everything is verified remove the old objects:")
+	fmt.Printf("  git for-each-ref --format=\"delete %%(refname)\" %s | git update-ref --stdin\n", rewriteBackupPrefix)
+	fmt.Println("  git reflog expire --expire=now --all && git gc --prune=now")
+	fmt.Println("Note: the old content stays readable in existing clones and forks; rotate any leaked secrets")
+	return nil
+}
+
+// rewriteRef is a branch or tag whose history is rewritten
+type rewriteRef struct {
+	name       string
+	object     string
+	objectType string
+}
+
+// rewritableRefs lists local branches and tags
+// Remote-tracking refs are left untouched so they keep describing what the remote has
+func rewritableRefs() ([]rewriteRef, error) {
+	output, err := gitOutput("for-each-ref", "--format=%(refname) %(objectname) %(objecttype)", "refs/heads", "refs/tags")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+
+	var refs []rewriteRef
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 {
+			refs = append(refs, rewriteRef{name: fields[0], object: fields[1], objectType: fields[2]})
+		}
+	}
+	return refs, nil
+}
+
+// historyRewriter rewrites commits so that the blobs of paths are replaced or removed
+type historyRewriter struct {
+	paths   []string
+	purge   bool
+	encrypt func(environment string, plaintext []byte) ([]byte, error)
+	index   string
+
+	// blobs maps original blobs, prefixed with the environment they are encrypted for, to their
+	// replacement, commits original commits to rewritten ones
+	blobs     map[string]string
+	commits   map[string]string
+	rewritten int
+	// environments caches the environment of each path, "" for the repository key
+	environments map[string]string
+}
+
+// rewriteCommits rewrites every commit reachable from refs, parents before children
+func (r *historyRewriter) rewriteCommits(refs []rewriteRef) error {
+	args := []string{"rev-list", "--reverse", "--topo-order", "--parents"}
+	for _, ref := range refs {
+		args = append(args, ref.name)
+	}
+	output, err := gitOutput(args...)
+	if err != nil {
+		return fmt.Errorf("failed to list commits: %w", err)
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if err := r.rewriteCommit(fields[0], fields[1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteCommit writes a copy of commit with rewritten tree and parents, if anything changed
+func (r *historyRewriter) rewriteCommit(commit string, parents []string) error {
+	newParents := make([]string, len(parents))
+	parentsChanged := false
+	for i, parent := range parents {
+		newParents[i] = r.commits[parent]
+		if newParents[i] == "" {
+			newParents[i] = parent
+		}
+		parentsChanged = parentsChanged || newParents[i] != parent
+	}
+
+	tree, treeChanged, err := r.rewriteTree(commit)
+	if err != nil {
+		return err
+	}
+	if !treeChanged && !parentsChanged {
+		r.commits[commit] = commit
+		return nil
+	}
+
+	raw, err := gitOutputRaw(nil, "cat-file", "commit", commit)
+	if err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", commit, err)
+	}
+	header, message, _ := strings.Cut(string(raw), "\n\n")
+
+	var lines []string
+	parentIndex := 0
+	skipContinuation := false
+	for _, line := range strings.Split(header, "\n") {
+		if strings.HasPrefix(line, " ") && skipContinuation {
+			continue
+		}
+		skipContinuation = false
+		switch {
+		case strings.HasPrefix(line, "tree "):
+			line = "tree " + tree
+		case strings.HasPrefix(line, "parent ") && parentIndex < len(newParents):
+			line = "parent " + newParents[parentIndex]
+			parentIndex++
+		case strings.HasPrefix(line, "gpgsig ") || strings.HasPrefix(line, "gpgsig-sha256 "):
+			// Signatures cannot survive a rewrite
+			skipContinuation = true
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	object, err := gitOutputRaw([]byte(strings.Join(lines, "\n")+"\n\n"+message), "hash-object", "-t", "commit", "-w", "--stdin")
+	if err != nil {
+		return fmt.Errorf("failed to write commit: %w", err)
+	}
+	r.commits[commit] = strings.TrimSpace(string(object))
+	r.rewritten++
+	return nil
+}
+
+// rewriteTree returns the tree of commit with the paths replaced or removed and whether it changed
+func (r *historyRewriter) rewriteTree(commit string) (string, bool, error) {
+	output, err := gitOutputRaw(nil, append([]string{"ls-tree", "-r", "-z", commit, "--"}, r.paths...)...)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read tree of %s: %w", commit, err)
+	}
+
+	var updates [][]string
+	for _, record := range strings.Split(string(output), "\x00") {
+		// Format: <mode> <type> <object>\t<path>
+		meta, path, ok := strings.Cut(record, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 3 || fields[1] != "blob" || fields[0] == "120000" {
+			continue
+		}
+
+		if r.purge {
+			updates = append(updates, []string{"update-index", "--force-remove", "--", path})
+			continue
+		}
+		replacement, err := r.replacementBlob(fields[2], path)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to encrypt %s in %s: %w", path, commit, err)
+		}
+		if replacement != fields[2] {
+			updates = append(updates, []string{"update-index", "--cacheinfo", fields[0] + "," + replacement + "," + path})
+		}
+	}
+
+	if len(updates) == 0 {
+		tree, err := gitOutput("rev-parse", commit+"^{tree}")
+		return tree, false, err
+	}
+
+	if err := r.gitWithIndex("read-tree", commit); err != nil {
+		return "", false, err
+	}
+	for _, update := range updates {
+		if err := r.gitWithIndex(update...); err != nil {
+			return "", false, err
+		}
+	}
+	cmd := exec.Command("git", "write-tree")
+	cmd.Env = append(os.Environ(), "GIT_INDEX_FILE="+r.index)
+	tree, err := cmd.Output()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to write tree: %w", err)
+	}
+	return strings.TrimSpace(string(tree)), true, nil
+}
+
+// replacementBlob returns the encrypted version of a blob at path, encrypting each plaintext blob
+// once for each environment it is stored under
+func (r *historyRewriter) replacementBlob(blob, path string) (string, error) {
+	environment, ok := r.environments[path]
+	if !ok {
+		var err error
+		if environment, err = crypto.FileEnvironment(path); err != nil {
+			return "", err
+		}
+		r.environments[path] = environment
+	}
+	cached := environment + ":" + blob
+	if replacement, ok := r.blobs[cached]; ok {
+		return replacement, nil
+	}
+
+	content, err := catFileBlob(blob)
+	if err != nil {
+		return "", err
+	}
+	replacement := blob
+	if len(content) > 0 && !crypto.IsEncryptedFile(content) {
+		encrypted, err := r.encrypt(environment, content)
+		if err != nil {
+			return "", err
+		}
+		if replacement, err = writeBlob(encrypted); err != nil {
+			return "", err
+		}
+	}
+	r.blobs[cached] = replacement
+	return replacement, nil
+}
+
+// updateRef points ref at its rewritten object and keeps the original under rewriteBackupPrefix
+func (r *historyRewriter) updateRef(ref rewriteRef) (bool, error) {
+	object := ref.object
+	switch ref.objectType {
+	case "commit":
+		if rewritten, ok := r.commits[ref.object]; ok {
+			object = rewritten
+		}
+	case "tag":
+		var err error
+		if object, err = r.rewriteTag(ref.object); err != nil {
+			return false, err
+		}
+	}
+	if object == ref.object {
+		return false, nil
+	}
+
+	backup := rewriteBackupPrefix + strings.TrimPrefix(ref.name, "refs/")
+	if _, err := gitOutput("update-ref", backup, ref.object); err != nil {
+		return false, fmt.Errorf("failed to back up %s: %w", ref.name, err)
+	}
+	if _, err := gitOutput("update-ref", ref.name, object, ref.object); err != nil {
+		return false, fmt.Errorf("failed to update %s: %w", ref.name, err)
+	}
+	return true, nil
+}
+
+// rewriteTag writes a copy of an annotated tag that points at the rewritten commit
+func (r *historyRewriter) rewriteTag(tag string) (string, error) {
+	raw, err := gitOutputRaw(nil, "cat-file", "tag", tag)
+	if err != nil {
+		return "", fmt.Errorf("failed to read tag %s: %w", tag, err)
+	}
+	header, message, _ := strings.Cut(string(raw), "\n\n")
+
+	var lines []string
+	changed := false
+	for _, line := range strings.Split(header, "\n") {
+		if target, ok := strings.CutPrefix(line, "object "); ok {
+			if rewritten, ok := r.commits[target]; ok && rewritten != target {
+				line = "object " + rewritten
+				changed = true
+			}
+		}
+		lines = append(lines, line)
+	}
+	if !changed {
+		return tag, nil
+	}
+
+	// Signatures cannot survive a rewrite
+	if i := strings.Index(message, "-----BEGIN PGP SIGNATURE-----"); i >= 0 {
+		message = message[:i]
+	}
+
+	object, err := gitOutputRaw([]byte(strings.Join(lines, "\n")+"\n\n"+message), "hash-object", "-t", "tag", "-w", "--stdin")
+	if err != nil {
+		return "", fmt.Errorf("failed to write tag: %w", err)
+	}
+	return strings.TrimSpace(string(object)), nil
+}
+
+// gitWithIndex runs git against the rewriter's temporary index
+func (r *historyRewriter) gitWithIndex(args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), "GIT_INDEX_FILE="+r.index)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s failed: %w: %s", args[0], err, output)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/hosting"
+	"github.com/oliviaBahr/ez-env/sidecar"
+	"github.com/oliviaBahr/ez-env/workpool"
+)
+
+// RotateKey generates a new encryption key, publishes it and re-encrypts every tracked file
+// With --rewrap only the per-file keys are re-encrypted with the new key and the content is left as is
+// The re-encrypted blobs are staged so the rotation can be committed in one step
+// Progress is checkpointed in the git directory so an interrupted rotation can continue with --resume
+func RotateKey(args []string) error {
+	flags := flag.NewFlagSet("rotate-key", flag.ContinueOnError)
+	resume := flags.Bool("resume", false, "resume an interrupted rotation from its checkpoint")
+	batchSize := flags.Int("batch", 100, "number of files re-encrypted between checkpoints")
+	rewrap := flags.Bool("rewrap", false, "only rewrap the per-file keys instead of re-encrypting the content")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *batchSize < 1 {
+		return fmt.Errorf("--batch must be at least 1")
+	}
+	if crypto.CurrentMode() == crypto.ModePassphrase {
+		return fmt.Errorf("the key is derived from the passphrase in %s mode and cannot be replaced with a random key", crypto.ModePassphrase)
+	}
+
+	ctx := context.Background()
+	if crypto.CurrentMode() == crypto.ModeSharedKey {
+		// The new key is published as the secret
+		if err := checkGitHubAccess(ctx, "repo"); err != nil {
+			return err
+		}
+	}
+	return rotateKey(ctx, *resume, *batchSize, *rewrap)
+}
+
+// rotateKey performs a checkpointed key rotation and stages the re-encrypted files
+// rewrap is recorded in the checkpoint, so a resumed rotation continues the way it started
+func rotateKey(ctx context.Context, resume bool, batchSize int, rewrap bool) error {
+	keyManager := crypto.NewKeyManager()
+	currentKey, err := keyManager.GetEncryptionKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get current encryption key: %w", err)
+	}
+	defer currentKey.Destroy()
+
+	var checkpoint *reencryptCheckpoint
+	if resume {
+		if checkpoint, err = loadCheckpoint("rotate-key"); err != nil {
+			return err
+		}
+	} else {
+		newKey, err := crypto.GenerateEncryptionKey()
+		if err != nil {
+			return err
+		}
+		if checkpoint, err = newCheckpoint("rotate-key", currentKey.Bytes(), newKey); err != nil {
+			return err
+		}
+		checkpoint.Rewrap = rewrap
+	}
+
+	oldKey, newKey, err := checkpoint.keys(currentKey.Bytes())
+	if err != nil {
+		return err
+	}
+
+	entries, err := encryptedIndexEntries()
+	if err != nil {
+		return fmt.Errorf("failed to list encrypted files: %w", err)
+	}
+	sidecarEntries, err := indexEntries(sidecar.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to list sidecar files: %w", err)
+	}
+	entries = append(entries, sidecarEntries...)
+
+	// Only the repository key is rotated; files of environments keep their own keys
+	groups, err := entriesByEnvironment(entries)
+	if err != nil {
+		return err
+	}
+	entries = groups[""]
+	for _, environment := range groupNames(groups) {
+		if environment != "" {
+			fmt.Printf("Note: %d file(s) of the %s environment keep their environment key\n", len(groups[environment]), environment)
+		}
+	}
+
+	if resume {
+		kept := checkpoint.verify(entries, newKey)
+		fmt.Printf("✓ Resuming rotation to key %s (%d files already re-encrypted and verified)\n", checkpoint.NewKeyID, kept)
+	} else if err := checkpoint.save(); err != nil {
+		return err
+	}
+
+	// Re-encrypt everything before publishing the new key so a failure leaves the repository untouched
+	updated, err := reencryptCheckpointed(checkpoint, entries, oldKey, newKey, batchSize)
+	if err != nil {
+		return err
+	}
+
+	if !checkpoint.Published {
+		if err := publishKey(ctx, newKey); err != nil {
+			return err
+		}
+		checkpoint.Published = true
+		if err := checkpoint.save(); err != nil {
+			return err
+		}
+	}
+	if err := crypto.UpdateLocalKey(newKey); err != nil {
+		return fmt.Errorf("failed to update local key: %w", err)
+	}
+
+	if err := stageRekeyed(updated); err != nil {
+		return err
+	}
+	for _, entry := range updated {
+		fmt.Printf("✓ Re-encrypted %s\n", entry.Path)
+	}
+
+	if err := checkpoint.remove(); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Encryption key rotated (%d files re-encrypted)\n", len(updated))
+	fmt.Println("Note: commit and push the staged changes so collaborators pick up the new ciphertext")
+	return nil
+}
+
+// reencryptEntries decrypts each index blob with oldKey, encrypts it with newKey and writes the new blob
+// Blobs that were committed in plaintext are encrypted as well
+// With ezenv.bindPaths each blob is bound to the path it is staged at
+func reencryptEntries(entries []indexEntry, oldKey, newKey []byte) ([]indexEntry, error) {
+	transform, err := reencryptTransform(oldKey, newKey)
+	if err != nil {
+		return nil, err
+	}
+	return rekeyEntries(entries, workpool.DefaultJobs(), transform)
+}
+
+// rewrapEntries re-encrypts the per-file key of each index blob from oldKey to newKey and writes the new blob
+// Blobs without a per-file key, including plaintext ones, are fully re-encrypted instead
+func rewrapEntries(entries []indexEntry, oldKey, newKey []byte) ([]indexEntry, error) {
+	transform, err := rewrapTransform(oldKey, newKey)
+	if err != nil {
+		return nil, err
+	}
+	return rekeyEntries(entries, workpool.DefaultJobs(), transform)
+}
+
+// publishKey makes a new repository key available to collaborators using the current key mode
+func publishKey(ctx context.Context, key []byte) error {
+	if crypto.CurrentMode() == crypto.ModeKeyring {
+		keyring, err := loadTrustedKeyring()
+		if err != nil {
+			return err
+		}
+		if err := keyring.GenerateEncryptedDEKs(key); err != nil {
+			return err
+		}
+		if err := saveKeyring(keyring); err != nil {
+			return err
+		}
+		fmt.Printf("✓ New key wrapped for %d keyring entries\n", len(keyring.Entries))
+		return nil
+	}
+
+	if err := hosting.StoreEncryptionKey(ctx, key); err != nil {
+		return err
+	}
+	fmt.Println("✓ New encryption key stored in " + hosting.SecretStore())
+
+	// Codespaces read their own copy of the key, set by 'init --codespaces'
+	if hosting.Current() == hosting.HostGitHub {
+		exists, err := github.CodespacesSecretExists(ctx)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if err := github.StoreCodespacesKey(ctx, key); err != nil {
+				return err
+			}
+			fmt.Println("✓ New encryption key stored in the Codespaces secret")
+		}
+	}
+	return nil
+}
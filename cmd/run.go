@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/dotenv"
+)
+
+// ExitError carries the exit status of a child process so main can exit with the same status
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("command exited with status %d", e.Code)
+}
+
+// stringList is a flag that can be repeated
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// parseInterspersed parses flags that may appear before, between or after positional arguments
+// and returns the positional arguments; everything after -- is positional
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := flags.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		// flag stops at the first non-flag argument or consumes a -- terminator
+		if stop := len(args) - len(rest) - 1; stop >= 0 && args[stop] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// Run decrypts tracked dotenv files in memory and runs a command with their variables set
+// The staged content is used, so plaintext never has to exist in the working tree
+// Variables can be restricted per file with git config, for example to give a CI job only a subset:
+//
+//	git config --add ezenv-vars.config/.env.allow 'API_*'
+//	git config --add ezenv-vars.config/.env.deny API_ADMIN_TOKEN
+//
+//	git ez-env run -- npm start
+//	git ez-env run -f config/.env.staging -- ./deploy.sh
+func Run(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	var files stringList
+	flags.Var(&files, "f", "dotenv file to load (repeatable, later files win; default all managed dotenv files)")
+	override := flags.Bool("override", false, "let file variables override variables already set in the environment")
+	strict := flags.Bool("strict", false, "fail instead of skipping variables that violate the allow/deny lists")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return fmt.Errorf("no command specified (git ez-env run -- <command> [args...])")
+	}
+
+	variables, err := loadDotenvVariables(files, *strict)
+	if err != nil {
+		return err
+	}
+
+	child := exec.Command(flags.Arg(0), flags.Args()[1:]...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+	child.Env = mergeEnv(os.Environ(), variables, *override)
+
+	if err := child.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", flags.Arg(0), err)
+	}
+
+	// Forward termination signals so the child can shut down cleanly
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			child.Process.Signal(sig)
+		}
+	}()
+
+	if err := child.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+			return &ExitError{Code: exitErr.ExitCode()}
+		}
+		return fmt.Errorf("%s failed: %w", flags.Arg(0), err)
+	}
+	return nil
+}
+
+// loadDotenvVariables decrypts the staged content of dotenv files and merges their variables
+// Without files every managed dotenv file is loaded; later files win. Variables that violate the
+// ezenv-vars allow/deny lists are reported and skipped, or fail the load when strict is set
+func loadDotenvVariables(files []string, strict bool) (map[string]string, error) {
+	if len(files) == 0 {
+		managed, err := managedDotenvFiles()
+		if err != nil {
+			return nil, err
+		}
+		if len(managed) == 0 {
+			return nil, fmt.Errorf("no managed dotenv files found (use -f to choose files)")
+		}
+		files = managed
+	}
+
+	ctx := context.Background()
+	keys := filterKeys{}
+	defer keys.destroy()
+
+	variables := make(map[string]string)
+	violations := 0
+	for _, file := range files {
+		content, err := stagedPlaintextFor(ctx, keys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		policy, err := variablePolicy(file)
+		if err != nil {
+			return nil, err
+		}
+		permitted, denied := policy.Apply(dotenv.Parse(content).Map())
+		for _, name := range denied {
+			fmt.Fprintf(os.Stderr, "✗ %s: %s is not allowed by the ezenv-vars lists\n", file, name)
+		}
+		violations += len(denied)
+		for name, value := range permitted {
+			variables[name] = value
+		}
+	}
+	if violations > 0 && strict {
+		return nil, fmt.Errorf("%d variable(s) violate the allow/deny lists", violations)
+	}
+
+	return variables, nil
+}
+
+// stagedPlaintextFor returns the staged content of a file, decrypted with the key of its environment
+// if it is encrypted
+func stagedPlaintextFor(ctx context.Context, keys filterKeys, file string) ([]byte, error) {
+	content, err := catFileBlob(":" + file)
+	if err != nil {
+		return nil, fmt.Errorf("%s is %w", file, errNotStaged)
+	}
+	if !crypto.IsEncryptedFile(content) {
+		return content, nil
+	}
+	key, err := keys.get(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	if content, err = crypto.DecryptFile(content, key.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", file, err)
+	}
+	return content, nil
+}
+
+// errNotStaged is returned by stagedPlaintext and stagedPlaintextFor for a file without a staged or committed blob
+var errNotStaged = errors.New("not staged or committed")
+
+// stagedPlaintext returns the staged content of a file, decrypted if it is encrypted
+func stagedPlaintext(file string, key []byte) ([]byte, error) {
+	content, err := catFileBlob(":" + file)
+	if err != nil {
+		return nil, fmt.Errorf("%s is %w", file, errNotStaged)
+	}
+	if crypto.IsEncryptedFile(content) {
+		if content, err = crypto.DecryptFile(content, key); err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", file, err)
+		}
+	}
+	return content, nil
+}
+
+// variablePolicy reads the allow and deny lists of a file from git config (ezenv-vars.<file>.allow/deny)
+func variablePolicy(file string) (dotenv.Policy, error) {
+	var policy dotenv.Policy
+	for _, list := range []struct {
+		name     string
+		patterns *[]string
+	}{{"allow", &policy.Allow}, {"deny", &policy.Deny}} {
+		output, _ := gitOutput("config", "--get-all", "ezenv-vars."+file+"."+list.name)
+		for _, pattern := range strings.Fields(output) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return policy, fmt.Errorf("invalid pattern %q in ezenv-vars.%s.%s", pattern, file, list.name)
+			}
+			*list.patterns = append(*list.patterns, pattern)
+		}
+	}
+	return policy, nil
+}
+
+// managedDotenvFiles returns the staged managed files that look like dotenv files, sorted by path
+func managedDotenvFiles() ([]string, error) {
+	entries, err := encryptedIndexEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		base := path.Base(entry.Path)
+		if base == ".env" || strings.HasPrefix(base, ".env.") || strings.HasSuffix(base, ".env") {
+			files = append(files, entry.Path)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// mergeEnv adds variables to an environment list
+// Existing variables are only replaced when override is set
+func mergeEnv(environ []string, variables map[string]string, override bool) []string {
+	existing := make(map[string]bool, len(environ))
+	var result []string
+	for _, entry := range environ {
+		name, _, _ := strings.Cut(entry, "=")
+		if _, ok := variables[name]; ok && override {
+			continue
+		}
+		existing[name] = true
+		result = append(result, entry)
+	}
+
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !existing[name] {
+			result = append(result, name+"="+variables[name])
+		}
+	}
+	return result
+}
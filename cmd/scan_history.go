@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/canonical"
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+// historyLeak is a plaintext revision of a managed file found in the history
+type historyLeak struct {
+	Path   string `json:"path"`
+	Blob   string `json:"blob"`
+	Commit string `json:"commit"`
+	Date   string `json:"date"`
+	Author string `json:"author"`
+}
+
+// historyRevision is a blob that a commit introduced for a path
+type historyRevision struct {
+	path   string
+	blob   string
+	commit string
+	date   string
+	author string
+}
+
+// ScanHistory walks every commit reachable from any ref and reports revisions of files that are
+// managed today but were committed in plaintext, e.g. before init or by a clone without the filter
+func ScanHistory(args []string) error {
+	flags := flag.NewFlagSet("scan-history", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the leaks as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	revisions, err := historyRevisions("--exclude="+rewriteBackupPrefix+"*", "--all")
+	if err != nil {
+		return err
+	}
+
+	// The current .gitattributes decides which historical paths are managed
+	var paths []string
+	seenPaths := make(map[string]bool)
+	for _, revision := range revisions {
+		if !seenPaths[revision.path] {
+			seenPaths[revision.path] = true
+			paths = append(paths, revision.path)
+		}
+	}
+	if len(paths) == 0 {
+		fmt.Println("No history to scan")
+		return nil
+	}
+	managed, err := pathsWithFilter(paths)
+	if err != nil {
+		return err
+	}
+
+	// Revisions are listed newest first; keep the oldest commit that introduced each blob
+	introduced := make(map[string]historyRevision)
+	var objects []string
+	for _, revision := range revisions {
+		if !managed[revision.path] {
+			continue
+		}
+		key := revision.path + "\x00" + revision.blob
+		if _, ok := introduced[key]; !ok {
+			objects = append(objects, revision.blob)
+		}
+		introduced[key] = revision
+	}
+
+	plaintext := make(map[string]bool)
+	err = forEachBlob(uniqueStrings(objects), func(object string, content []byte) error {
+		// Empty files hold nothing to leak
+		if len(content) > 0 && !crypto.IsEncryptedFile(content) {
+			plaintext[object] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	leaks := []historyLeak{}
+	for _, revision := range introduced {
+		if plaintext[revision.blob] {
+			leaks = append(leaks, historyLeak{
+				Path:   revision.path,
+				Blob:   revision.blob,
+				Commit: revision.commit,
+				Date:   revision.date,
+				Author: revision.author,
+			})
+		}
+	}
+	sort.Slice(leaks, func(i, j int) bool {
+		if leaks[i].Path != leaks[j].Path {
+			return leaks[i].Path < leaks[j].Path
+		}
+		return leaks[i].Date < leaks[j].Date
+	})
+
+	if *asJSON {
+		data, err := canonical.Marshal(leaks)
+		if err != nil {
+			return fmt.Errorf("failed to encode leaks: %w", err)
+		}
+		if _, err := os.Stdout.Write(data); err != nil {
+			return err
+		}
+	} else {
+		for _, leak := range leaks {
+			fmt.Printf("✗ %s committed in plaintext in %s (%s, %s)\n", leak.Path, leak.Commit[:12], leak.Date, leak.Author)
+		}
+		fmt.Printf("✓ Scanned %d revision(s) of %d managed path(s)\n", len(introduced), countTrue(managed))
+	}
+
+	if len(leaks) > 0 {
+		if !*asJSON {
+			fmt.Println("Note: rotate the leaked secrets, then consider 'git ez-env rewrite <path>' to remove them from history")
+		}
+		return fmt.Errorf("%d plaintext revision(s) found in history", len(leaks))
+	}
+	return nil
+}
+
+// historyRevisions lists the blobs the commits selected by the git log revision arguments revs
+// introduced, newest first
+// Scanning skips backups of a previous rewrite because they are expected to hold the old content
+// Merge commits are compared with each parent so content introduced while resolving a merge is included
+func historyRevisions(revs ...string) ([]historyRevision, error) {
+	args := append([]string{"-c", "core.quotePath=false", "log", "-m", "--raw", "--no-abbrev", "--no-renames",
+		"--format=commit %H %as %an"}, revs...)
+	cmd := exec.Command("git", append(args, "--")...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	var revisions []historyRevision
+	var current historyRevision
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if header, ok := strings.CutPrefix(line, "commit "); ok {
+			// Format: commit <hash> <date> <author name>
+			fields := strings.SplitN(header, " ", 3)
+			if len(fields) == 3 {
+				current = historyRevision{commit: fields[0], date: fields[1], author: fields[2]}
+			}
+			continue
+		}
+
+		// Format: :<old mode> <new mode> <old blob> <new blob> <status>\t<path>
+		meta, path, ok := strings.Cut(line, "\t")
+		if !ok || !strings.HasPrefix(meta, ":") {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 5 || strings.HasPrefix(fields[4], "D") || fields[1] == "120000" || fields[1] == "160000" {
+			continue
+		}
+		if strings.HasPrefix(path, `"`) {
+			if unquoted, err := strconv.Unquote(path); err == nil {
+				path = unquoted
+			}
+		}
+
+		revision := current
+		revision.path = path
+		revision.blob = fields[3]
+		revisions = append(revisions, revision)
+	}
+	if err := scanner.Err(); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return revisions, nil
+}
+
+// uniqueStrings returns values without duplicates, keeping the first occurrence
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var result []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// countTrue returns how many values of m are true
+func countTrue(m map[string]bool) int {
+	count := 0
+	for _, value := range m {
+		if value {
+			count++
+		}
+	}
+	return count
+}
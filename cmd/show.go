@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+// Show decrypts a file at an arbitrary revision (e.g. HEAD~3:config/.env) and prints the plaintext
+func Show(args []string) error {
+	flags := flag.NewFlagSet("show", flag.ContinueOnError)
+	output := flags.String("o", "", "write the plaintext to this file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() < 1 {
+		return fmt.Errorf("no object specified (expected <rev>:<path>)")
+	}
+	object := flags.Arg(0)
+	if !strings.Contains(object, ":") {
+		return fmt.Errorf("invalid object %q (expected <rev>:<path>)", object)
+	}
+
+	content, err := catFileBlob(object)
+	if err != nil {
+		return err
+	}
+
+	plaintext := content
+	if crypto.IsEncryptedFile(content) {
+		ctx := context.Background()
+		_, path, _ := strings.Cut(object, ":")
+		keyManager, err := fileKeyManager(path)
+		if err != nil {
+			return err
+		}
+		key, err := keyManager.GetEncryptionKey(ctx)
+		defer key.Destroy()
+		if _, ok := crypto.EnvelopeKDF(content); err != nil && ok {
+			// Files encrypted in passphrase mode can be decrypted from their own header
+			passphrase, perr := readPassphrase("Passphrase: ", false)
+			if perr != nil {
+				return fmt.Errorf("failed to get encryption key: %w", perr)
+			}
+			plaintext, err = crypto.DecryptFileWithPassphrase(content, passphrase)
+		} else if err != nil {
+			return fmt.Errorf("failed to get encryption key: %w", err)
+		} else {
+			plaintext, err = crypto.DecryptFile(content, key.Bytes())
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", object, err)
+		}
+	}
+
+	if *output == "" {
+		_, err := os.Stdout.Write(plaintext)
+		return err
+	}
+
+	if err := os.WriteFile(*output, plaintext, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", *output, err)
+	}
+	fmt.Fprintf(os.Stderr, "✓ Decrypted %s to %s\n", object, *output)
+	return nil
+}
@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/sidecar"
+	"github.com/oliviaBahr/ez-env/workpool"
+)
+
+// sidecarAttributes lets git diff show the plaintext of sidecar ciphertext without filtering it
+var sidecarAttributes = sidecar.Dir + "/*" + sidecar.Extension + " diff=ezenv"
+
+// addExternal encrypts a file that lives outside the repository into the sidecar store
+// and records where its plaintext is installed
+func addExternal(filePath, name string) error {
+	abs, err := filepath.Abs(filePath)
+	if err != nil {
+		return fmt.Errorf("invalid path: %s", filePath)
+	}
+	plaintext, err := os.ReadFile(abs)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get home directory: %w", err)
+	}
+	installPath := sidecar.Portable(abs, home)
+	root, err := repoRoot()
+	if err != nil {
+		return err
+	}
+	// Unlock only installs inside the home directory or the repository
+	if _, err := sidecar.Resolve(installPath, home, root); err != nil {
+		return fmt.Errorf("%s cannot be stored: %w", filePath, err)
+	}
+	if name == "" {
+		name = sidecar.DefaultName(installPath)
+	}
+
+	m, err := sidecar.Load(sidecar.MapFile)
+	if err != nil {
+		return err
+	}
+	entry, err := m.Set(name, installPath)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	keyManager := crypto.NewKeyManager()
+	key, err := keyManager.GetEncryptionKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	defer key.Destroy()
+	opts, err := encryptOptions(filepath.ToSlash(entry.StoredPath()))
+	if err != nil {
+		return err
+	}
+	encrypted, err := crypto.EncryptFileWithOptions(plaintext, key.Bytes(), opts)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", filePath, err)
+	}
+
+	if err := os.MkdirAll(sidecar.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", sidecar.Dir, err)
+	}
+	if err := os.WriteFile(entry.StoredPath(), encrypted, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", entry.StoredPath(), err)
+	}
+	if err := m.Save(sidecar.MapFile); err != nil {
+		return err
+	}
+	if err := addSidecarAttributes(); err != nil {
+		return err
+	}
+
+	addCmd := exec.Command("git", "add", "--", entry.StoredPath(), sidecar.MapFile, ".gitattributes")
+	if err := addCmd.Run(); err != nil {
+		return fmt.Errorf("failed to stage sidecar entry: %w", err)
+	}
+
+	fmt.Printf("✓ External file %s stored encrypted as %s\n", installPath, entry.StoredPath())
+	fmt.Printf("Note: collaborators run 'git ez-env unlock %s' to install it\n", entry.Name)
+	return nil
+}
+
+// addSidecarAttributes ensures .gitattributes enables the diff driver for sidecar ciphertext
+func addSidecarAttributes() error {
+	content, err := os.ReadFile(".gitattributes")
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read .gitattributes: %w", err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == sidecarAttributes {
+			return nil
+		}
+	}
+
+	if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+		content = append(content, '\n')
+	}
+	content = append(content, []byte(sidecarAttributes+"\n")...)
+	if err := os.WriteFile(".gitattributes", content, 0644); err != nil {
+		return fmt.Errorf("failed to write .gitattributes: %w", err)
+	}
+	return nil
+}
+
+// Unlock checks out files that were locked for lack of a key (see locked.go); naming sidecar
+// entries, or --external for all of them, decrypts files from the sidecar store to their install
+// paths outside the repository
+// Anyone who can commit can add sidecar entries, so they are never installed without being asked
+// for, and creating files outside the repository needs confirmation
+func Unlock(args []string) error {
+	flags := flag.NewFlagSet("unlock", flag.ContinueOnError)
+	external := flags.Bool("external", false, "also install every file of the sidecar store")
+	force := flags.Bool("force", false, "overwrite installed files that differ from the stored version")
+	yes := flags.Bool("yes", false, "do not ask before writing files outside the repository")
+	jobs := flags.Int("jobs", workpool.DefaultJobs(), "number of files decrypted in parallel")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *jobs < 1 {
+		return fmt.Errorf("--jobs must be at least 1")
+	}
+
+	ctx := context.Background()
+	m, err := sidecar.Load(sidecar.MapFile)
+	if err != nil {
+		return err
+	}
+
+	var entries []sidecar.Entry
+	switch {
+	case flags.NArg() > 0:
+		for _, name := range flags.Args() {
+			entry, ok := m.Get(name)
+			if !ok {
+				return fmt.Errorf("no sidecar entry named %s", name)
+			}
+			entries = append(entries, entry)
+		}
+	case *external:
+		entries = m.Entries
+	default:
+		unlocked, err := unlockLockedFiles(ctx)
+		if err != nil {
+			return err
+		}
+		if unlocked > 0 {
+			fmt.Printf("✓ Unlocked %d locked file(s)\n", unlocked)
+		} else {
+			fmt.Println("Nothing to unlock: no file is locked")
+		}
+		if len(m.Entries) > 0 {
+			fmt.Printf("Note: %d external file(s) are managed by ez-env; install them with 'git ez-env unlock --external'\n", len(m.Entries))
+		}
+		return nil
+	}
+	if len(entries) == 0 {
+		fmt.Println("Nothing to unlock: no external files are managed by ez-env")
+		return nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get home directory: %w", err)
+	}
+	root, err := repoRoot()
+	if err != nil {
+		return err
+	}
+	if !*yes {
+		ok, err := confirmExternalWrites(entries, home, root, *force)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("aborted")
+		}
+	}
+
+	keyManager := crypto.NewKeyManager()
+	key, err := keyManager.GetEncryptionKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	defer key.Destroy()
+
+	// Results are printed in the order of the entries once every file is done
+	results := make([]unlockResult, len(entries))
+	poolErr := workpool.Run(len(entries), *jobs, func(i int) error {
+		var err error
+		results[i], err = unlockEntry(entries[i], key.Bytes(), home, root, *force)
+		return err
+	})
+
+	var skipped int
+	for _, result := range results {
+		if result.message == "" {
+			continue
+		}
+		fmt.Println(result.message)
+		if result.skipped {
+			skipped++
+		}
+	}
+	if poolErr != nil {
+		return poolErr
+	}
+	if skipped > 0 {
+		return fmt.Errorf("%d file(s) were not installed", skipped)
+	}
+	return nil
+}
+
+// confirmExternalWrites lists the install paths outside the repository that unlocking entries
+// would create, or with force overwrite, and asks whether to go ahead; it is true when there are none
+func confirmExternalWrites(entries []sidecar.Entry, home, root string, force bool) (bool, error) {
+	var writes []string
+	for _, entry := range entries {
+		target, err := sidecar.Resolve(entry.InstallPath, home, root)
+		if err != nil {
+			continue // unlockEntry reports it
+		}
+		if rel, err := filepath.Rel(root, target); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if _, err := os.Lstat(target); err == nil && !force {
+			continue
+		}
+		writes = append(writes, entry.InstallPath)
+	}
+	if len(writes) == 0 {
+		return true, nil
+	}
+
+	fmt.Println("Files outside the repository that will be written:")
+	for _, path := range writes {
+		fmt.Printf("  %s\n", path)
+	}
+	return confirm("Install them?")
+}
+
+// unlockResult is the outcome of installing one sidecar entry
+type unlockResult struct {
+	message string
+	skipped bool
+}
+
+// unlockEntry decrypts one sidecar entry to its install path, leaving a differing installed file
+// alone unless force is set
+func unlockEntry(entry sidecar.Entry, key []byte, home, root string, force bool) (unlockResult, error) {
+	target, err := sidecar.Resolve(entry.InstallPath, home, root)
+	if err != nil {
+		return unlockResult{message: fmt.Sprintf("✗ %s: %v", entry.Name, err), skipped: true}, nil
+	}
+	plaintext, err := readDecrypted(entry.StoredPath(), key)
+	if err != nil {
+		return unlockResult{}, err
+	}
+
+	if existing, err := os.ReadFile(target); err == nil {
+		if bytes.Equal(existing, plaintext) {
+			return unlockResult{message: fmt.Sprintf("✓ %s is up to date", entry.InstallPath)}, nil
+		}
+		if !force {
+			return unlockResult{message: fmt.Sprintf("✗ %s differs from the stored version (use --force to overwrite)", entry.InstallPath), skipped: true}, nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return unlockResult{}, fmt.Errorf("failed to create directory for %s: %w", entry.InstallPath, err)
+	}
+	if err := os.WriteFile(target, plaintext, 0600); err != nil {
+		return unlockResult{}, fmt.Errorf("failed to write %s: %w", entry.InstallPath, err)
+	}
+	if err := os.Chmod(target, 0600); err != nil {
+		return unlockResult{}, fmt.Errorf("failed to set permissions on %s: %w", entry.InstallPath, err)
+	}
+	return unlockResult{message: fmt.Sprintf("✓ Installed %s", entry.InstallPath)}, nil
+}
@@ -1,10 +1,13 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/oliviaBahr/ez-env/crypto"
 )
@@ -12,40 +15,91 @@ import (
 // Smudge decrypts the file content using the shared encryption key
 // This is called by Git when files are checked out (git checkout, git pull)
 // Only called for files that match patterns in .gitattributes
-func Smudge() error {
+// Git passes the path (%f) as the first argument so files moved from another path are detected
+// Files larger than ezenv.streamThreshold are decrypted as they are read (see smudgeStream)
+func Smudge(args []string) error {
+	path := ""
+	if len(args) > 0 {
+		path = args[0]
+	}
+	keys := filterKeys{}
+	defer keys.destroy()
+	start := time.Now()
+	input := &countingReader{r: os.Stdin}
+	err := smudgeFile(keys, path, input)
+	debugFilter("smudge", path, input.n, keys, start, err)
+	return err
+}
+
+// smudgeFile decrypts the content of the file at path read from r to stdout
+func smudgeFile(keys filterKeys, path string, r io.Reader) error {
+	threshold, err := streamThreshold()
+	if err != nil {
+		return err
+	}
 	// Read the encrypted file content from stdin
-	input, err := io.ReadAll(os.Stdin)
+	input, more, err := readHead(r, threshold)
 	if err != nil {
 		return fmt.Errorf("failed to read input: %w", err)
 	}
 
-	// Check if the content is encrypted
-	if !crypto.IsEncryptedFile(input) {
-		// If not encrypted, just pass it through
-		if _, err := os.Stdout.Write(input); err != nil {
-			return fmt.Errorf("failed to write output: %w", err)
-		}
-		return nil
+	if more {
+		return smudgeStream(context.Background(), keys, path, input, r, os.Stdout)
+	}
+	output, err := smudgeContent(context.Background(), keys, path, input)
+	if err != nil {
+		return err
+	}
+
+	// Write the plaintext content to stdout (Git will write this to the working tree)
+	if _, err := os.Stdout.Write(output); err != nil {
+		return fmt.Errorf("failed to write plaintext content: %w", err)
+	}
+	return nil
+}
+
+// smudgeContent decrypts the content of the file at path, or returns it unchanged when it is not
+// encrypted; path may be empty when git did not pass it
+func smudgeContent(ctx context.Context, keys filterKeys, path string, input []byte) ([]byte, error) {
+	if passThrough, err := smudgePassesThrough(input); passThrough || err != nil {
+		return input, err
 	}
 
 	// Get encryption key
-	ctx := context.Background()
-	keyManager := crypto.NewKeyManager()
-	key, err := keyManager.GetOrCreateEncryptionKey(ctx)
+	key, err := keys.get(ctx, path)
 	if err != nil {
-		return fmt.Errorf("failed to get encryption key: %w", err)
+		return smudgeWithoutKey(path, bytes.NewReader(input), err)
 	}
 
 	// Decrypt the file content
-	plaintext, err := crypto.DecryptFile(input, key)
+	plaintext, err := crypto.DecryptFileAt(input, key.Bytes(), path)
 	if err != nil {
-		return fmt.Errorf("failed to decrypt content: %w", err)
+		return nil, decryptError(path, err)
 	}
+	return plaintext, nil
+}
 
-	// Write the plaintext content to stdout (Git will write this to the working tree)
-	if _, err := os.Stdout.Write(plaintext); err != nil {
-		return fmt.Errorf("failed to write plaintext content: %w", err)
+// smudgePassesThrough reports whether smudge leaves content starting with head as it is because it
+// is not encrypted
+func smudgePassesThrough(head []byte) (bool, error) {
+	// A collaborator with a newer ez-env may have written a format this build cannot read;
+	// passing it through would check out ciphertext as if it were the file content
+	if format, ok := crypto.FormatVersion(head); ok && !crypto.IsSupportedFormat(format) {
+		return false, unsupportedFormatError(format)
 	}
 
-	return nil
+	// If not encrypted, just pass it through
+	return !crypto.IsEncryptedFile(head), nil
+}
+
+// decryptError explains why the content of path failed to decrypt
+func decryptError(path string, err error) error {
+	if errors.Is(err, crypto.ErrPathMismatch) {
+		return fmt.Errorf("%w; if the file was renamed, run 'git add --renormalize %s' and commit", err, path)
+	}
+	var mismatch *crypto.KeyMismatchError
+	if errors.As(err, &mismatch) {
+		return fmt.Errorf("failed to decrypt content: %w; the key on this machine does not match the file, run 'git ez-env whoami' to see where it came from", err)
+	}
+	return fmt.Errorf("failed to decrypt content: %w", err)
 }
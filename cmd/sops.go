@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+// ImportSops decrypts a SOPS document with the user's SOPS keys and tracks the plaintext under ez-env
+// SOPS resolves the key itself (age, KMS, PGP, ...) and strips its metadata when decrypting
+func ImportSops(args []string) error {
+	flags := flag.NewFlagSet("import-sops", flag.ContinueOnError)
+	output := flags.String("o", "", "path of the imported file (default strips .enc/.sops from the name)")
+	inputType := flags.String("input-type", "", "SOPS input type (yaml, json, dotenv, ini or binary) when it cannot be detected from the extension")
+	keepOriginal := flags.Bool("keep-original", false, "keep the SOPS-encrypted file")
+	force := flags.Bool("force", false, "overwrite an existing file at the default import path")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return fmt.Errorf("no file specified")
+	}
+	sopsFile := flags.Arg(0)
+
+	if _, err := exec.LookPath("sops"); err != nil {
+		return fmt.Errorf("sops is required to decrypt %s", sopsFile)
+	}
+	// The plaintext is staged with git add, which only encrypts it through the clean filter
+	if err := requireCleanFilter(); err != nil {
+		return err
+	}
+
+	target := *output
+	if target == "" {
+		target = sopsPlainPath(sopsFile)
+		// A derived name may be an unrelated file, which is only replaced when asked to
+		if _, err := os.Lstat(target); err == nil && target != sopsFile && !*force {
+			return fmt.Errorf("%s already exists; choose another path with -o or overwrite it with --force", target)
+		}
+	}
+
+	sopsArgs := []string{"--decrypt"}
+	if *inputType != "" {
+		sopsArgs = append(sopsArgs, "--input-type", *inputType, "--output-type", *inputType)
+	}
+	plaintext, err := runSops(nil, append(sopsArgs, sopsFile)...)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", sopsFile, err)
+	}
+
+	if err := os.WriteFile(target, plaintext, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", target, err)
+	}
+	if err := addManagedPatterns("", target); err != nil {
+		return fmt.Errorf("failed to add %s to %s: %w", target, RepoConfigFile, err)
+	}
+
+	if target != sopsFile && !*keepOriginal {
+		if _, err := gitOutput("rm", "-q", "--cached", "--ignore-unmatch", "--", sopsFile); err != nil {
+			return fmt.Errorf("failed to remove %s from git: %w", sopsFile, err)
+		}
+		if err := os.Remove(sopsFile); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", sopsFile, err)
+		}
+	}
+
+	addCmd := exec.Command("git", "add", "--", target)
+	if err := addCmd.Run(); err != nil {
+		return fmt.Errorf("failed to add %s to git: %w", target, err)
+	}
+
+	fmt.Printf("✓ Imported %s as %s\n", sopsFile, target)
+	fmt.Println("Note: remove the file from .sops.yaml creation rules once everyone has switched")
+	return nil
+}
+
+// sopsPlainPath derives the plaintext file name of a SOPS document,
+// e.g. secrets.enc.yaml becomes secrets.yaml and .env.sops becomes .env
+func sopsPlainPath(path string) string {
+	dir, base := filepath.Split(path)
+	for _, marker := range []string{".enc", ".sops"} {
+		if strings.HasSuffix(base, marker) && base != marker {
+			return filepath.Join(dir, strings.TrimSuffix(base, marker))
+		}
+		if i := strings.Index(base, marker+"."); i >= 0 && len(base) > len(marker)+1 {
+			return filepath.Join(dir, base[:i]+base[i+len(marker):])
+		}
+	}
+	return path
+}
+
+// ExportSops decrypts a tracked file and re-encrypts it as a SOPS document for the given age recipients
+// The plaintext is only written to a private temporary directory inside the git directory
+func ExportSops(args []string) error {
+	flags := flag.NewFlagSet("export-sops", flag.ContinueOnError)
+	age := flags.String("age", "", "comma-separated age recipients to encrypt the SOPS document to")
+	output := flags.String("o", "", "path of the SOPS document (default adds .enc before the extension)")
+	inputType := flags.String("input-type", "", "SOPS input type (yaml, json, dotenv, ini or binary) when it cannot be detected from the extension")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return fmt.Errorf("no file specified")
+	}
+	if *age == "" {
+		return fmt.Errorf("no recipients specified (use --age)")
+	}
+	filePath := flags.Arg(0)
+
+	if _, err := exec.LookPath("sops"); err != nil {
+		return fmt.Errorf("sops is required to create %s", filePath)
+	}
+
+	content, err := catFileBlob(":" + filepath.ToSlash(filePath))
+	if err != nil {
+		return fmt.Errorf("%s is not tracked", filePath)
+	}
+	plaintext := content
+	if crypto.IsEncryptedFile(content) {
+		ctx := context.Background()
+		keyManager, err := fileKeyManager(filePath)
+		if err != nil {
+			return err
+		}
+		key, err := keyManager.GetEncryptionKey(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get encryption key: %w", err)
+		}
+		defer key.Destroy()
+		if plaintext, err = crypto.DecryptFile(content, key.Bytes()); err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", filePath, err)
+		}
+	}
+
+	// SOPS detects the format from the file name, so the temporary copy keeps it
+	tmpDir, err := privateTempDir("sops-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	plainFile := filepath.Join(tmpDir, filepath.Base(filePath))
+	if err := os.WriteFile(plainFile, plaintext, 0600); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	sopsArgs := []string{"--encrypt", "--age", *age}
+	if *inputType != "" {
+		sopsArgs = append(sopsArgs, "--input-type", *inputType, "--output-type", *inputType)
+	}
+	document, err := runSops(nil, append(sopsArgs, plainFile)...)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", filePath, err)
+	}
+
+	target := *output
+	if target == "" {
+		target = sopsEncryptedPath(filePath)
+	}
+	if err := os.WriteFile(target, document, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", target, err)
+	}
+
+	fmt.Printf("✓ Exported %s as SOPS document %s\n", filePath, target)
+	return nil
+}
+
+// sopsEncryptedPath derives the SOPS document name for a file, e.g. secrets.yaml becomes secrets.enc.yaml
+func sopsEncryptedPath(path string) string {
+	dir, base := filepath.Split(path)
+	ext := filepath.Ext(base)
+	return filepath.Join(dir, strings.TrimSuffix(base, ext)+".enc"+ext)
+}
+
+// runSops runs the sops CLI and returns its stdout
+func runSops(stdin []byte, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("sops", args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("sops failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/patternlog"
+)
+
+// Status lists the encrypted patterns and files, or the history of pattern changes with --history
+func Status(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	history := flags.Bool("history", false, "show who added or removed encrypted patterns and when")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *history {
+		return printPatternHistory()
+	}
+
+	config, err := loadRepoConfig()
+	if err != nil {
+		return err
+	}
+	patterns, err := config.files()
+	if err != nil {
+		return err
+	}
+	fmt.Println("Encrypted patterns:")
+	for _, p := range patterns {
+		if p.Environment != "" {
+			fmt.Printf("  %s (%s environment)\n", p.Pattern, p.Environment)
+		} else {
+			fmt.Printf("  %s\n", p.Pattern)
+		}
+	}
+	if len(patterns) == 0 {
+		fmt.Println("  (none)")
+	}
+
+	entries, err := encryptedIndexEntries()
+	if err != nil {
+		return err
+	}
+	paths := make(map[string]string, len(entries))
+	var objects []string
+	for _, entry := range entries {
+		paths[entry.Object] = entry.Path
+		objects = append(objects, entry.Object)
+	}
+
+	// Only a key already on this machine is used; status never fetches one
+	currentKeyID := ""
+	if key, err := crypto.LoadLocalKey(); err == nil {
+		currentKeyID = crypto.KeyID(key)
+	}
+
+	fmt.Println("\nManaged files:")
+	if len(entries) == 0 {
+		fmt.Println("  (none)")
+	}
+	plaintext, mismatched := 0, 0
+	fileKeyIDs := make(map[string]bool)
+	err = forEachBlob(objects, func(object string, content []byte) error {
+		if !crypto.IsEncryptedFile(content) {
+			fmt.Printf("  ✗ %s (staged in plaintext)\n", paths[object])
+			plaintext++
+			return nil
+		}
+		keyID, ok := crypto.EnvelopeKeyID(content)
+		if ok {
+			fileKeyIDs[keyID] = true
+		}
+		if ok && currentKeyID != "" && keyID != currentKeyID {
+			fmt.Printf("  ✗ %s (encrypted with key %s)\n", paths[object], keyID)
+			mismatched++
+			return nil
+		}
+		fmt.Printf("  ✓ %s\n", paths[object])
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	printKeyFingerprint(currentKeyID, fileKeyIDs)
+	if plaintext > 0 {
+		fmt.Println("\nNote: run 'git add --renormalize .' to encrypt the files staged in plaintext")
+	}
+	if mismatched > 0 {
+		fmt.Printf("\nNote: %d file(s) were encrypted with another key and will not decrypt with the key on this machine\n", mismatched)
+	}
+	return nil
+}
+
+// printKeyFingerprint prints the fingerprint of the local key, or the one recorded in the managed
+// files when no key is stored on this machine
+func printKeyFingerprint(currentKeyID string, fileKeyIDs map[string]bool) {
+	switch {
+	case currentKeyID != "":
+		fmt.Printf("\nKey fingerprint: %s\n", currentKeyID)
+	case len(fileKeyIDs) == 1:
+		for keyID := range fileKeyIDs {
+			fmt.Printf("\nKey fingerprint: %s (recorded in the encrypted files)\n", keyID)
+		}
+	case len(fileKeyIDs) > 1:
+		fmt.Printf("\nKey fingerprint: files were encrypted with %d different keys\n", len(fileKeyIDs))
+	}
+}
+
+// printPatternHistory prints the committed log of pattern changes
+func printPatternHistory() error {
+	events, err := patternlog.Load(patternlog.File)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		fmt.Println("No pattern changes recorded")
+		return nil
+	}
+
+	for _, event := range events {
+		sign := "+"
+		if event.Action == patternlog.ActionRemove {
+			sign = "-"
+		}
+		fmt.Printf("%s  %s %-30s %s", event.Time.Local().Format("2006-01-02 15:04"), sign, event.Pattern, event.Actor)
+		if event.KeyGroup != "" {
+			fmt.Printf(" [%s]", event.KeyGroup)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// recordPatternChange appends a pattern change to the committed log and stages it
+func recordPatternChange(action, pattern string) error {
+	event := patternlog.Event{
+		Time:     time.Now(),
+		Action:   action,
+		Pattern:  pattern,
+		Actor:    gitIdentity(),
+		KeyGroup: crypto.CurrentMode(),
+	}
+	if err := patternlog.Append(patternlog.File, event); err != nil {
+		return err
+	}
+
+	addCmd := exec.Command("git", "add", patternlog.File)
+	if err := addCmd.Run(); err != nil {
+		return fmt.Errorf("failed to add %s to git: %w", patternlog.File, err)
+	}
+	return nil
+}
+
+// gitIdentity returns the configured git user as "Name <email>"
+func gitIdentity() string {
+	name, _ := gitOutput("config", "--get", "user.name")
+	email, _ := gitOutput("config", "--get", "user.email")
+	switch {
+	case name != "" && email != "":
+		return fmt.Sprintf("%s <%s>", name, email)
+	case email != "":
+		return "<" + email + ">"
+	}
+	return name
+}
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/pktline"
+)
+
+// Files larger than ezenv.streamThreshold never sit in memory whole: clean encrypts them into a
+// chunked envelope as they are read and smudge decrypts them a chunk at a time. Only the content up
+// to the threshold is read up front, to tell which files are large.
+// Large files take more time and disk space than small ones: clean spools the ciphertext and also
+// decrypts the staged file to compare it with the new content.
+
+// readHead reads content from r up to threshold bytes and reports whether more follows; a zero
+// threshold reads it whole
+func readHead(r io.Reader, threshold int64) ([]byte, bool, error) {
+	if threshold == 0 {
+		content, err := io.ReadAll(r)
+		return content, false, err
+	}
+	head, err := io.ReadAll(io.LimitReader(r, threshold+1))
+	return head, int64(len(head)) > threshold, err
+}
+
+// cleanStream encrypts the content of the file at path, head followed by the rest read from r,
+// into a chunked envelope and returns a reader of it, or of the content itself when it must not be
+// encrypted. When the file staged for path holds the same content, its ciphertext is returned
+// instead, so comparing an unchanged file with the index does not show it as modified
+// The result is spooled to a temporary file, since that is only known at the end and git sends a
+// filter process the whole file before it reads the response; it is ciphertext, so plaintext
+// never touches the disk
+func cleanStream(ctx context.Context, keys filterKeys, path string, head []byte, r io.Reader) (io.ReadCloser, error) {
+	content := io.MultiReader(bytes.NewReader(head), r)
+	if passThrough, err := cleanPassesThrough(path, head); err != nil {
+		return nil, err
+	} else if passThrough {
+		return spoolContent(content)
+	}
+
+	key, err := keys.get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := encryptOptions(path)
+	if err != nil {
+		return nil, err
+	}
+	spool, err := newSpoolFile()
+	if err != nil {
+		return nil, err
+	}
+	encrypter, err := crypto.NewEncryptWriter(spool, key.Bytes(), opts)
+	if err != nil {
+		spool.Close()
+		return nil, fmt.Errorf("failed to encrypt content: %w", err)
+	}
+	staged := stagedBlob(path)
+	matcher := crypto.NewPlaintextMatcher(staged, key.Bytes(), opts)
+	_, err = io.Copy(io.MultiWriter(encrypter, matcher), content)
+	staged.Close()
+	if err == nil {
+		err = encrypter.Close()
+	}
+	if err != nil {
+		spool.Close()
+		return nil, fmt.Errorf("failed to encrypt content: %w", err)
+	}
+
+	if matcher.Matches() {
+		spool.Close()
+		return stagedBlob(path), nil
+	}
+	return spool.rewind()
+}
+
+// smudgeStream decrypts the content of the file at path, head followed by the rest read from r,
+// into w, or copies it unchanged when it is not encrypted
+// Chunks are written as they authenticate, so on error w holds a prefix of the file git discards
+func smudgeStream(ctx context.Context, keys filterKeys, path string, head []byte, r io.Reader, w io.Writer) error {
+	content := io.MultiReader(bytes.NewReader(head), r)
+	if passThrough, err := smudgePassesThrough(head); err != nil {
+		return err
+	} else if !passThrough {
+		key, err := keys.get(ctx, path)
+		if err == nil {
+			return decryptStream(key, path, content, w)
+		}
+		placeholder, err := smudgeWithoutKey(path, content, err)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(placeholder); err != nil {
+			return fmt.Errorf("failed to write locked placeholder: %w", err)
+		}
+		return nil
+	}
+
+	if _, err := io.Copy(w, content); err != nil {
+		return fmt.Errorf("failed to copy content: %w", err)
+	}
+	return nil
+}
+
+// decryptStream decrypts the encrypted content of the file at path read from r into w
+func decryptStream(key *crypto.SecureBytes, path string, r io.Reader, w io.Writer) error {
+	plaintext, err := crypto.NewDecryptReader(r, key.Bytes(), path)
+	if err != nil {
+		return decryptError(path, err)
+	}
+	if _, err := io.Copy(w, plaintext); err != nil {
+		return decryptError(path, err)
+	}
+	return nil
+}
+
+// streamFilter answers a filter process request whose content exceeds the stream threshold, head
+// being its start and the rest still unread in content, and returns why filtering the file failed,
+// which git is told, and an error when answering git failed
+// git sends the whole file before it reads the response, so the ciphertext side is spooled to a
+// temporary file: clean encrypts into it before responding and smudge decrypts from it while
+// responding
+func streamFilter(ctx context.Context, w *pktline.Writer, keys filterKeys, command, path string, head []byte, content io.Reader) (filterErr, err error) {
+	var spooled io.ReadCloser
+	switch command {
+	case "clean":
+		spooled, filterErr = cleanStream(ctx, keys, path, head, content)
+	case "smudge":
+		spooled, filterErr = spoolContent(content)
+	default:
+		filterErr = fmt.Errorf("unsupported filter command %q", command)
+	}
+	// Whatever failed, git must be done sending before it reads the response
+	if _, err := io.Copy(io.Discard, content); err != nil {
+		if spooled != nil {
+			spooled.Close()
+		}
+		return nil, fmt.Errorf("failed to read content of %s: %w", path, err)
+	}
+	if filterErr != nil {
+		return filterErr, writeFilterResponse(w, path, nil, filterErr)
+	}
+
+	if err := w.WriteList("status=success"); err != nil {
+		spooled.Close()
+		return nil, fmt.Errorf("failed to write filter response: %w", err)
+	}
+	output := w.ContentWriter()
+	if command == "clean" {
+		if _, err := io.Copy(output, spooled); err != nil {
+			filterErr = fmt.Errorf("failed to read encrypted content: %w", err)
+		}
+	} else {
+		filterErr = smudgeStream(ctx, keys, path, head, spooled, output)
+	}
+	if err := spooled.Close(); err != nil && filterErr == nil {
+		filterErr = fmt.Errorf("failed to read encrypted content: %w", err)
+	}
+	if err := output.Close(); err != nil {
+		return filterErr, fmt.Errorf("failed to write filter response: %w", err)
+	}
+	// A status after the content overrides the success sent before it
+	if filterErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", path, filterErr)
+		if err := w.WriteList("status=error"); err != nil {
+			return filterErr, fmt.Errorf("failed to write filter response: %w", err)
+		}
+		return filterErr, nil
+	}
+	if err := w.WriteList(); err != nil {
+		return nil, fmt.Errorf("failed to write filter response: %w", err)
+	}
+	return nil, nil
+}
+
+// spoolFile is a temporary file in the git directory, removed when it is closed
+type spoolFile struct {
+	*os.File
+	dir string
+}
+
+// newSpoolFile creates an empty spool file
+func newSpoolFile() (*spoolFile, error) {
+	dir, err := privateTempDir("spool-")
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(dir, "")
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	return &spoolFile{File: file, dir: dir}, nil
+}
+
+// Close closes and removes the file
+func (s *spoolFile) Close() error {
+	err := s.File.Close()
+	os.RemoveAll(s.dir)
+	return err
+}
+
+// rewind returns the spool file for reading what was written to it
+func (s *spoolFile) rewind() (io.ReadCloser, error) {
+	if _, err := s.Seek(0, io.SeekStart); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to read temporary file: %w", err)
+	}
+	return s, nil
+}
+
+// spoolContent copies the content read from r to a spool file and returns it for reading
+func spoolContent(r io.Reader) (io.ReadCloser, error) {
+	spool, err := newSpoolFile()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(spool, r); err != nil {
+		spool.Close()
+		return nil, fmt.Errorf("failed to spool content: %w", err)
+	}
+	return spool.rewind()
+}
@@ -0,0 +1,409 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/hosting"
+	"github.com/oliviaBahr/ez-env/ssh"
+	"github.com/oliviaBahr/ez-env/version"
+	"github.com/oliviaBahr/ez-env/workflows"
+)
+
+// SyncKeys brings the keyring in line with the repository's collaborators on GitHub, or the
+// workspace members on Bitbucket
+// New collaborators and new SSH keys are added, and collaborators who lost access are removed
+// Only added entries get the key wrapped to them, so running it again without changes is a no-op
+func SyncKeys(args []string) error {
+	flags := flag.NewFlagSet("sync-keys", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report the changes without updating the keyring")
+	noCommit := flags.Bool("no-commit", false, "stage the updated keyring without committing it")
+	rotate := flags.String("rotate", "", "rotate the key when collaborators are removed: always, ask or never (default from ezenv.rotateOnRemoval, or always)")
+	keep := flags.String("keep", "", "comma-separated keyring logins that are not collaborators but keep access, such as bots")
+	installWorkflow := flags.Bool("install-workflow", false, "install a workflow that opens pull requests keeping the keyring in sync")
+	bot := flags.String("bot", workflows.DefaultSyncBot, "keyring login of the workflow's key (with --install-workflow)")
+	refresh := flags.Bool("refresh", false, "download collaborators and keys again instead of revalidating the cached ones")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	github.RefreshCache = *refresh
+
+	if err := requireKeyringMode(); err != nil {
+		return err
+	}
+	if *installWorkflow {
+		return installSyncKeysWorkflow(context.Background(), *bot)
+	}
+	policy, err := rotationPolicy(*rotate)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	collaborators, readOnly, err := keyCollaborators(ctx)
+	if err != nil {
+		return err
+	}
+	if len(collaborators) == 0 {
+		return fmt.Errorf("%s returned no collaborators who can push; refusing to empty the keyring", hosting.Name())
+	}
+
+	keyring, err := loadTrustedKeyring()
+	if err != nil {
+		return err
+	}
+	before := make(map[string]bool)
+	for _, login := range keyring.Logins() {
+		before[login] = true
+	}
+	current := make(map[string]bool)
+	for _, login := range collaborators {
+		current[login] = true
+	}
+
+	var added, newKeys, skipped []string
+	for _, login := range readOnly {
+		if !before[login] {
+			skipped = append(skipped, login+" (cannot push)")
+		}
+	}
+	for _, login := range collaborators {
+		// A revoked collaborator who is still on GitHub stays revoked until someone grants them again
+		if _, revoked := keyring.FindTombstone(login); revoked && !before[login] {
+			skipped = append(skipped, login+" (revoked)")
+			continue
+		}
+		count, err := addCollaboratorKeys(ctx, keyring, login)
+		if err != nil {
+			fmt.Printf("Warning: %v; keeping the keys of %s unchanged\n", err, login)
+			continue
+		}
+		switch {
+		case count > 0 && !before[login]:
+			added = append(added, login)
+		case count > 0:
+			newKeys = append(newKeys, fmt.Sprintf("%s (+%d key(s))", login, count))
+		case !before[login]:
+			skipped = append(skipped, login+" (no supported keys)")
+		}
+	}
+
+	for _, login := range strings.Split(*keep, ",") {
+		if login = strings.TrimSpace(login); login != "" {
+			current[login] = true
+		}
+	}
+
+	var removed []string
+	for login := range before {
+		if !current[login] {
+			removed = append(removed, login)
+		}
+	}
+	sort.Strings(removed)
+
+	// Only current collaborators are wrapped to; a pending entry for anyone else stays pending
+	var approved []string
+	pending := 0
+	for _, login := range collaborators {
+		if _, revoked := keyring.FindTombstone(login); revoked && !before[login] {
+			continue
+		}
+		approved = append(approved, login)
+		pending += keyring.PendingEntries(login)
+	}
+
+	for _, login := range added {
+		fmt.Printf("+ %s\n", login)
+	}
+	for _, change := range newKeys {
+		fmt.Printf("~ %s\n", change)
+	}
+	for _, login := range removed {
+		fmt.Printf("- %s\n", login)
+	}
+	for _, login := range skipped {
+		fmt.Printf("  skipped %s\n", login)
+	}
+
+	// The rotation commits everything staged, so it is left to the user when other changes are
+	staged, err := stagedChanges()
+	if err != nil {
+		return err
+	}
+
+	if pending == 0 && len(removed) == 0 {
+		fmt.Printf("✓ Keyring is in sync with the %d collaborator(s) on %s\n", len(collaborators), hosting.Name())
+		return nil
+	}
+	if *dryRun {
+		fmt.Println("Note: dry run, the keyring was not changed")
+		return nil
+	}
+
+	if pending > 0 {
+		key, err := crypto.NewKeyManager().GetEncryptionKey(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get encryption key: %w", err)
+		}
+		defer key.Destroy()
+		if _, err := keyring.WrapPendingDEKs(key.Bytes(), approved...); err != nil {
+			return err
+		}
+	}
+	actor := currentActor(ctx)
+	for _, login := range removed {
+		keyring.SoftDelete(login, actor, time.Now())
+	}
+	if err := saveKeyring(keyring); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Keyring updated: %d added, %d removed, %d key(s) wrapped\n", len(added), len(removed), pending)
+
+	doRotate := len(removed) > 0 && policy == rotateAlways
+	if len(removed) > 0 && policy == rotateAsk {
+		if doRotate, err = confirm(fmt.Sprintf("Rotate the key so %s cannot decrypt future changes?", strings.Join(removed, ", "))); err != nil {
+			return err
+		}
+	}
+	if doRotate {
+		if err := rotateKey(ctx, false, 100, false); err != nil {
+			return fmt.Errorf("the keyring was updated but the key rotation failed; run 'git ez-env rotate-key --resume' to finish revoking access: %w", err)
+		}
+		if keyring, err = loadTrustedKeyring(); err != nil {
+			return err
+		}
+		for _, login := range removed {
+			if tombstone, ok := keyring.FindTombstone(login); ok {
+				tombstone.Rotated = true
+			}
+		}
+		if err := rotateSyncBotKey(ctx, keyring); err != nil {
+			return err
+		}
+		if err := saveKeyring(keyring); err != nil {
+			return err
+		}
+		// The keyring and the re-encrypted files have to be committed together
+		if *noCommit || staged {
+			fmt.Println("Note: the keyring and the re-encrypted files are staged but not committed; commit them together and push so the removal takes effect")
+			return nil
+		}
+		commitCmd := exec.Command("git", "commit", "-q", "-m", syncCommitMessage(added, removed))
+		if output, err := commitCmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to commit the keyring and the re-encrypted files: %w: %s", err, output)
+		}
+		fmt.Println("✓ Keyring and re-encrypted files committed; push them so the changes take effect")
+		return nil
+	}
+	if len(removed) > 0 {
+		fmt.Printf("Warning: %s can still decrypt everything encrypted with the current key; run 'git ez-env rotate-key' to revoke that\n", strings.Join(removed, ", "))
+	}
+
+	if *noCommit {
+		return nil
+	}
+	commitCmd := exec.Command("git", "commit", "-q", "-m", syncCommitMessage(added, removed), "--", crypto.KeyringFile)
+	if output, err := commitCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to commit %s: %w: %s", crypto.KeyringFile, err, output)
+	}
+	fmt.Println("✓ Keyring committed; push it so the changes take effect")
+	return nil
+}
+
+// syncKeySecret is the repository secret holding the private key the sync workflow decrypts with
+const syncKeySecret = "EZENV_SYNC_SSH_KEY"
+
+// botLogin matches keyring logins that are safe to write into the workflow
+var botLogin = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// installSyncKeysWorkflow gives the sync workflow its own keyring entry and installs it
+// A new SSH key is generated for bot and wrapped like a collaborator's; the private key only
+// leaves this machine sealed as the EZENV_SYNC_SSH_KEY secret of an environment that only protected
+// branches can deploy to
+// Running it again for the configured bot replaces the bot's key
+func installSyncKeysWorkflow(ctx context.Context, bot string) error {
+	if !botLogin.MatchString(bot) {
+		return fmt.Errorf("invalid bot login %q", bot)
+	}
+	if hosting.Current() != hosting.HostGitHub {
+		return fmt.Errorf("the sync workflow runs on GitHub Actions; on %s run 'git ez-env sync-keys' from a scheduled pipeline instead", hosting.Name())
+	}
+	ref, err := pinnedRef()
+	if err != nil {
+		return err
+	}
+	if err := checkGitHubAccess(ctx, "repo", "workflow"); err != nil {
+		return err
+	}
+
+	keyring, err := loadTrustedKeyring()
+	if err != nil {
+		return err
+	}
+	if slices.Contains(keyring.Logins(), bot) && bot != settingValue("syncBot") {
+		return fmt.Errorf("%s already has a keyring entry; revoke it first or choose another --bot", bot)
+	}
+	if err := replaceBotKey(ctx, keyring, bot); err != nil {
+		return err
+	}
+	if err := saveKeyring(keyring); err != nil {
+		return err
+	}
+	if err := writeSetting(false, "ezenv.syncBot", bot); err != nil {
+		return err
+	}
+
+	repoPath, err := repoRoot()
+	if err != nil {
+		return err
+	}
+	if err := workflows.WriteSyncKeysWorkflowFile(repoPath, bot, ref); err != nil {
+		return err
+	}
+	if _, err := gitOutput("add", "--", filepath.Join(".github", "workflows", workflows.SyncKeysWorkflow)); err != nil {
+		return fmt.Errorf("failed to add workflow to git: %w", err)
+	}
+
+	fmt.Printf("✓ Granted %s access with a new SSH key stored as the %s secret of the %s environment\n", bot, syncKeySecret, workflows.SyncEnvironment)
+	fmt.Printf("✓ Sync workflow created, installing ez-env %s\n", ref)
+	fmt.Println("Note: commit and push the keyring, workflow and " + RepoConfigFile + "; collaborator changes then arrive as pull requests")
+	fmt.Printf("Note: the %s environment only accepts protected branches, so protect the default branch\n", workflows.SyncEnvironment)
+	fmt.Println("Note: allow GitHub Actions to create pull requests in the repository's Actions settings")
+	fmt.Println("Note: if the workflow cannot list collaborators, store a token with the repo scope as the EZENV_SYNC_TOKEN secret")
+	return nil
+}
+
+// replaceBotKey generates a new SSH key for bot, stores its private key as the sync workflow's
+// secret and replaces the bot's keyring entries with it
+func replaceBotKey(ctx context.Context, keyring *crypto.Keyring, bot string) error {
+	privatePEM, publicKey, err := ssh.GenerateEd25519Key()
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(privatePEM)
+	if !keyring.CanWrapTo(publicKey) {
+		return fmt.Errorf("the %s keyring cannot wrap the key to an SSH key, so the workflow could not decrypt", keyring.WrapBackend())
+	}
+
+	key, err := crypto.NewKeyManager().GetEncryptionKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	defer key.Destroy()
+
+	// Stored first, so a failure leaves no keyring entry that nothing can use
+	if err := github.StoreProtectedEnvironmentSecret(ctx, workflows.SyncEnvironment, syncKeySecret, privatePEM); err != nil {
+		return err
+	}
+	keyring.RemoveLogin(bot)
+	if _, err := keyring.AddEntry(bot, publicKey); err != nil {
+		return err
+	}
+	keyring.MarkGranted(bot, time.Now())
+	if _, err := keyring.WrapPendingDEKs(key.Bytes(), bot); err != nil {
+		return err
+	}
+	return nil
+}
+
+// rotateSyncBotKey replaces the sync workflow's key after collaborators were removed, since one of
+// them may have copied it out of the secret while they could still run workflows
+// It runs before the rotated keyring is saved, so the committed keyring never wraps the new key to the old one
+func rotateSyncBotKey(ctx context.Context, keyring *crypto.Keyring) error {
+	bot := settingValue("syncBot")
+	if bot == "" || !slices.Contains(keyring.Logins(), bot) {
+		return nil
+	}
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		// The workflow's token cannot write secrets
+		fmt.Printf("Warning: the SSH key of %s was not replaced; run 'git ez-env sync-keys --install-workflow --bot %s' after merging\n", bot, bot)
+		return nil
+	}
+	if err := checkGitHubAccess(ctx, "repo"); err != nil {
+		return fmt.Errorf("failed to replace the SSH key of %s: %w", bot, err)
+	}
+	if err := replaceBotKey(ctx, keyring, bot); err != nil {
+		return fmt.Errorf("failed to replace the SSH key of %s: %w", bot, err)
+	}
+	fmt.Printf("✓ Replaced the SSH key of %s\n", bot)
+	return nil
+}
+
+// pinnedRef returns the module version or commit of this build, which the generated workflows install
+// instead of whatever is latest when they run
+func pinnedRef() (string, error) {
+	info := version.Get(crypto.SupportedFormats)
+	if moduleVersion.MatchString(info.Version) {
+		return info.Version, nil
+	}
+	if commitHash.MatchString(info.Commit) {
+		return info.Commit, nil
+	}
+	return "", fmt.Errorf("this build of ez-env has no release version or commit for the workflow to install; install a release first")
+}
+
+// moduleVersion matches release and pseudo-versions that go install accepts
+var moduleVersion = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.-]+)?$`)
+
+// commitHash matches a full git commit hash
+var commitHash = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// keyCollaborators returns the collaborators who can push, and so get the key, and those who
+// cannot, such as read and triage roles on GitHub
+func keyCollaborators(ctx context.Context) ([]string, []string, error) {
+	collaborators, err := hosting.ListCollaboratorPermissions(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var writers, readOnly []string
+	for _, collaborator := range collaborators {
+		if collaborator.Push {
+			writers = append(writers, collaborator.Login)
+		} else {
+			readOnly = append(readOnly, collaborator.Login)
+		}
+	}
+	return writers, readOnly, nil
+}
+
+// stagedChanges reports whether the index differs from HEAD
+func stagedChanges() (bool, error) {
+	err := exec.Command("git", "diff", "--cached", "--quiet").Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check for staged changes: %w", err)
+	}
+	return false, nil
+}
+
+// syncCommitMessage describes a keyring sync for the commit log
+func syncCommitMessage(added, removed []string) string {
+	message := "Sync ez-env keyring with " + hosting.Name() + " collaborators"
+	var details []string
+	if len(added) > 0 {
+		details = append(details, "Added: "+strings.Join(added, ", "))
+	}
+	if len(removed) > 0 {
+		details = append(details, "Removed: "+strings.Join(removed, ", "))
+	}
+	if len(details) > 0 {
+		message += "\n\n" + strings.Join(details, "\n")
+	}
+	return message
+}
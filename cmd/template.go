@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/oliviaBahr/ez-env/dotenv"
+)
+
+// Template renders a Go template with the decrypted dotenv variables as its data, so config
+// files in other formats can use the secrets without committing them in plaintext
+//
+//	password = {{ .DB_PASSWORD }}
+//	listen = {{ get "PORT" | default "8080" }}
+//	{{ file "certs/server.pem" }}
+//
+//	git ez-env template nginx.conf.tmpl -o nginx.conf
+func Template(args []string) error {
+	flags := flag.NewFlagSet("template", flag.ContinueOnError)
+	var files stringList
+	flags.Var(&files, "f", "dotenv file to load (repeatable, later files win; default all managed dotenv files)")
+	output := flags.String("o", "", "write the rendered file to this path (mode 0600) instead of stdout")
+	strict := flags.Bool("strict", false, "fail instead of skipping variables that violate the allow/deny lists")
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: git ez-env template [-f <dotenv file>] <template> [-o <output>]")
+	}
+	input := positional[0]
+
+	source, err := os.ReadFile(input)
+	if err != nil {
+		return fmt.Errorf("failed to read template: %w", err)
+	}
+
+	// A template may only use managed files, so no dotenv files is not an error here
+	variables := map[string]string{}
+	if managed, err := managedDotenvFiles(); err != nil {
+		return err
+	} else if len(files) > 0 || len(managed) > 0 {
+		if variables, err = loadDotenvVariables(files, *strict); err != nil {
+			return err
+		}
+	}
+
+	keys := filterKeys{}
+	defer keys.destroy()
+	funcs := template.FuncMap{
+		// file inserts the decrypted staged content of a managed file
+		"file": func(path string) (string, error) {
+			content, err := stagedPlaintextFor(context.Background(), keys, path)
+			return string(content), err
+		},
+		// get returns a variable or an empty string, where .NAME fails for unknown variables
+		"get": func(name string) string {
+			return variables[name]
+		},
+		"required": func(name string) (string, error) {
+			value, ok := variables[name]
+			if !ok || value == "" {
+				return "", fmt.Errorf("variable %s is not set", name)
+			}
+			return value, nil
+		},
+		"default": func(fallback, value string) string {
+			if value == "" {
+				return fallback
+			}
+			return value
+		},
+		"json": func(value string) (string, error) {
+			data, err := json.Marshal(value)
+			return string(data), err
+		},
+		"shell":  dotenv.ShellQuote,
+		"dotenv": dotenv.Quote,
+		"indent": func(spaces int, value string) string {
+			padding := strings.Repeat(" ", spaces)
+			return padding + strings.ReplaceAll(value, "\n", "\n"+padding)
+		},
+	}
+
+	// Unknown variables render as an error instead of silently producing an empty value
+	tmpl, err := template.New(input).Funcs(funcs).Option("missingkey=error").Parse(string(source))
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, variables); err != nil {
+		return fmt.Errorf("failed to render template: %w", err)
+	}
+
+	if *output == "" {
+		_, err := os.Stdout.Write(rendered.Bytes())
+		return err
+	}
+
+	if err := os.WriteFile(*output, rendered.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", *output, err)
+	}
+	if err := os.Chmod(*output, 0600); err != nil {
+		return fmt.Errorf("failed to set permissions on %s: %w", *output, err)
+	}
+	fmt.Fprintf(os.Stderr, "✓ Rendered %s to %s\n", input, *output)
+
+	// The rendered file holds plaintext secrets; warn when it could be committed by accident
+	if _, err := gitOutput("check-ignore", "-q", "--", *output); err != nil {
+		if managed, err := pathsWithFilter([]string{*output}); err == nil && !managed[*output] {
+			fmt.Fprintf(os.Stderr, "Note: %s is neither ignored nor encrypted; add it to .gitignore so the plaintext is not committed\n", *output)
+		}
+	}
+	return nil
+}
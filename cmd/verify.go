@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/sidecar"
+	"github.com/oliviaBahr/ez-env/workpool"
+)
+
+// Verify decrypts every index blob covered by the ezenv attribute with the current key and
+// reports plaintext, corrupted and wrong-key blobs, failing if any are found
+func Verify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	jobs := flags.Int("jobs", workpool.DefaultJobs(), "number of files verified in parallel")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *jobs < 1 {
+		return fmt.Errorf("--jobs must be at least 1")
+	}
+
+	entries, err := encryptedIndexEntries()
+	if err != nil {
+		return fmt.Errorf("failed to list encrypted files: %w", err)
+	}
+	sidecarEntries, err := indexEntries(sidecar.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to list sidecar files: %w", err)
+	}
+	entries = append(entries, sidecarEntries...)
+
+	if len(entries) == 0 {
+		fmt.Println("No encrypted files to verify")
+		return nil
+	}
+
+	// Every file is verified with the key of its environment, retrieved before the workers start
+	ctx := context.Background()
+	keys := filterKeys{}
+	defer keys.destroy()
+	groups, err := entriesByEnvironment(entries)
+	if err != nil {
+		return err
+	}
+	environments := make(map[string]string)
+	for _, environment := range groupNames(groups) {
+		if _, err := keys.forEnvironment(ctx, environment); err != nil {
+			return err
+		}
+		for _, entry := range groups[environment] {
+			environments[entry.Path] = environment
+		}
+	}
+
+	paths := make(map[string][]string)
+	var objects []string
+	for _, entry := range entries {
+		// Symlinks are stored as link targets and never encrypted
+		if entry.Mode == "120000" {
+			continue
+		}
+		if _, ok := paths[entry.Object]; !ok {
+			objects = append(objects, entry.Object)
+		}
+		paths[entry.Object] = append(paths[entry.Object], entry.Path)
+	}
+
+	var mu sync.Mutex
+	problems := make(map[string]string)
+	verified := 0
+	pool := workpool.New(*jobs)
+	err = forEachBlob(objects, func(object string, content []byte) error {
+		pool.Go(func() error {
+			bound, isBound := crypto.EnvelopePath(content)
+			problem := make(map[string]string)
+			for _, path := range paths[object] {
+				key := keys[environments[path]].key
+				problem[path] = verifyBlob(content, key.Bytes())
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, path := range paths[object] {
+				switch {
+				case problem[path] != "":
+					problems[path] = problem[path]
+				case isBound && bound != path:
+					problems[path] = fmt.Sprintf("encrypted for %s (moved or copied from another path)", bound)
+				default:
+					verified++
+				}
+			}
+			return nil
+		})
+		return nil
+	})
+	pool.Wait()
+	if err != nil {
+		return err
+	}
+
+	failed := make([]string, 0, len(problems))
+	for path := range problems {
+		failed = append(failed, path)
+	}
+	sort.Strings(failed)
+	for _, path := range failed {
+		fmt.Printf("✗ %s: %s\n", path, problems[path])
+	}
+
+	if key, ok := keys[""]; ok && len(groups) == 1 {
+		fmt.Printf("✓ %d file(s) decrypt with key %s\n", verified, crypto.KeyID(key.key.Bytes()))
+	} else {
+		fmt.Printf("✓ %d file(s) decrypt with the keys of their environments\n", verified)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d file(s) failed verification", len(failed))
+	}
+	return nil
+}
+
+// verifyBlob returns a description of what is wrong with a managed blob, or an empty string
+func verifyBlob(content, key []byte) string {
+	if !crypto.IsEncryptedFile(content) {
+		return "stored in plaintext"
+	}
+
+	_, err := crypto.DecryptFile(content, key)
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, crypto.ErrAuthentication):
+		return "encrypted with a different key (or modified after encryption)"
+	default:
+		return fmt.Sprintf("corrupted (%v)", err)
+	}
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/canonical"
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/version"
+)
+
+// Version prints the build metadata and the encrypted formats this binary supports
+func Version(args []string) error {
+	flags := flag.NewFlagSet("version", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the build metadata as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	info := version.Get(crypto.SupportedFormats)
+	if *asJSON {
+		data, err := canonical.Marshal(info)
+		if err != nil {
+			return fmt.Errorf("failed to encode version: %w", err)
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	fmt.Printf("ez-env %s\n", info.Version)
+	if info.Commit != "" {
+		fmt.Printf("Commit: %s\n", info.Commit)
+	}
+	if info.Date != "" {
+		fmt.Printf("Built: %s\n", info.Date)
+	}
+	fmt.Printf("Go: %s\n", info.GoVersion)
+	formats := make([]string, len(info.Formats))
+	for i, format := range info.Formats {
+		formats[i] = fmt.Sprintf("v%d", format)
+		if format == crypto.AgeFormatVersion {
+			formats[i] = crypto.FormatAge
+		}
+	}
+	fmt.Printf("Encrypted formats: %s\n", strings.Join(formats, ", "))
+	return nil
+}
+
+// unsupportedFormatError explains that ciphertext uses a format newer than this build understands
+func unsupportedFormatError(format int) error {
+	return fmt.Errorf("content is encrypted with format v%d, which ez-env %s does not support; upgrade ez-env to read it",
+		format, version.Get(crypto.SupportedFormats).Version)
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/bitbucket"
+	"github.com/oliviaBahr/ez-env/crypto"
+	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/hosting"
+	"github.com/oliviaBahr/ez-env/ssh"
+)
+
+// Whoami reports the identity ez-env acts as and every input that decides whether it can decrypt:
+// the GitHub or Bitbucket login and credentials, the repository permission, the local key and the keyring entries
+func Whoami(args []string) error {
+	if err := checkGitRepo(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	host := hosting.Name()
+	login, err := hosting.GetCurrentUser(ctx)
+	if err == nil && login != "" {
+		fmt.Printf("%s login: %s\n", host, login)
+	} else {
+		if err == nil {
+			err = fmt.Errorf("%s returned no login", host)
+		}
+		login = ""
+		fmt.Printf("%s login: unknown (%v)\n", host, err)
+	}
+
+	if hosting.Current() == hosting.HostBitbucket {
+		if source, err := bitbucket.CredentialSource(); err == nil {
+			fmt.Printf("Credentials: %s\n", source)
+		} else {
+			fmt.Printf("Credentials: none (%v)\n", err)
+		}
+	} else if token, source, err := github.GetGitHubTokenSource(); err == nil {
+		fmt.Printf("Token: %s (from %s)\n", github.TokenType(token), source)
+		if scopes, err := github.GetTokenScopes(ctx); err != nil {
+			fmt.Printf("Token scopes: unknown (%v)\n", err)
+		} else if len(scopes) == 0 {
+			fmt.Println("Token scopes: none reported (fine-grained or app token)")
+		} else {
+			fmt.Printf("Token scopes: %s\n", strings.Join(scopes, ", "))
+		}
+	} else {
+		fmt.Printf("Token: none (%v)\n", err)
+	}
+
+	if owner, repo, err := hosting.GetRepositoryInfo(); err == nil {
+		fmt.Printf("Repository: %s/%s (remote %s)\n", owner, repo, github.Remote())
+		if login != "" {
+			if permission, err := hosting.GetRepositoryPermission(ctx, login); err == nil {
+				fmt.Printf("Repository permission: %s\n", permission)
+			} else {
+				fmt.Printf("Repository permission: unknown (%v)\n", err)
+			}
+		}
+	} else {
+		fmt.Printf("Repository: unknown (%v)\n", err)
+	}
+
+	mode := crypto.CurrentMode()
+	fmt.Printf("Key mode: %s\n", mode)
+
+	localKey, localErr := crypto.LoadLocalKey()
+	if localErr == nil {
+		fmt.Printf("Local key: present (key %s), used before any other source\n", crypto.KeyID(localKey))
+	} else {
+		fmt.Println("Local key: none")
+	}
+
+	if mode == crypto.ModeKeyring {
+		whoamiKeyring(login)
+	}
+
+	// The final verdict uses the same lookup as the filters
+	key, err := crypto.NewKeyManager().GetEncryptionKey(ctx)
+	if err != nil {
+		fmt.Printf("✗ Cannot decrypt: %v\n", err)
+		return nil
+	}
+	defer key.Destroy()
+	fmt.Printf("✓ Can decrypt with key %s\n", crypto.KeyID(key.Bytes()))
+	return nil
+}
+
+// whoamiKeyring reports which SSH key is used and how it and login appear in the keyring
+func whoamiKeyring(login string) {
+	keyring, err := crypto.LoadKeyring(crypto.KeyringFile)
+	if err != nil {
+		fmt.Printf("Keyring: unreadable (%v)\n", err)
+		return
+	}
+	fmt.Printf("Keyring backend: %s\n", keyring.WrapBackend())
+
+	// Report the key that unlocks the keyring, falling back to the preferred one when none does
+	fingerprint := ""
+	path, err := ssh.PrivateKeyPathFor(keyring.GrantedPublicKeys())
+	if err != nil {
+		path, err = ssh.LocalPrivateKeyPath()
+	}
+	if err == nil {
+		if privateKey, err := ssh.LoadPrivateKeyAt(path); err == nil {
+			if publicKey, err := ssh.PublicKeyOf(privateKey); err == nil {
+				if authorizedKey, err := ssh.AuthorizedKey(publicKey); err == nil {
+					fingerprint, _ = ssh.Fingerprint(authorizedKey)
+				}
+			}
+			fmt.Printf("SSH key: %s (%s)\n", path, fingerprint)
+		} else {
+			fmt.Printf("SSH key: %s is not usable (%v)\n", path, err)
+		}
+	}
+
+	var own []crypto.KeyringEntry
+	var match *crypto.KeyringEntry
+	for i, entry := range keyring.Entries {
+		if entry.Login == login {
+			own = append(own, entry)
+		}
+		if fingerprint != "" && entry.Fingerprint == fingerprint {
+			match = &keyring.Entries[i]
+		}
+	}
+
+	switch {
+	case match != nil && match.EncryptedDEK == "":
+		fmt.Printf("Keyring: this SSH key is registered for %s but pending; a collaborator with access has to run 'git ez-env grant %s'\n", match.Login, match.Login)
+	case match != nil:
+		fmt.Printf("Keyring: this SSH key is registered for %s\n", match.Login)
+	case len(own) > 0:
+		fmt.Printf("Keyring: %s has %d key(s), but not this SSH key; set %s to one of them\n", login, len(own), ssh.PrivateKeyEnv)
+	default:
+		fmt.Println("Keyring: no entry for this SSH key or login; ask a collaborator to run 'git ez-env grant <login>'")
+	}
+	if _, removed := keyring.FindTombstone(login); removed && login != "" {
+		fmt.Printf("Keyring: %s was revoked; 'git ez-env restore-access %s' can undo it within the grace period\n", login, login)
+	}
+}
@@ -0,0 +1,51 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/github"
+)
+
+// ErrNoActionsKey is returned when ez-env is not running in a GitHub Actions job that passes the key
+var ErrNoActionsKey = errors.New("no key passed by GitHub Actions")
+
+// InGitHubActions reports whether ez-env runs in a GitHub Actions job
+func InGitHubActions() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+// LoadActionsKey returns the key a GitHub Actions job passes in the environment variable named
+// after the secret, base64 encoded like the secret itself
+func LoadActionsKey() ([]byte, error) {
+	if !InGitHubActions() {
+		return nil, ErrNoActionsKey
+	}
+	encoded := strings.TrimSpace(os.Getenv(github.SecretName))
+	if encoded == "" {
+		return nil, ErrNoActionsKey
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a base64 encoded key: %w", github.SecretName, err)
+	}
+	if len(key) != keySize {
+		Wipe(key)
+		return nil, fmt.Errorf("%s holds a %d-byte key, expected %d bytes", github.SecretName, len(key), keySize)
+	}
+	return key, nil
+}
+
+// actionsKeyMissing explains how to pass the key to a GitHub Actions job, where the key workflow
+// cannot be dispatched: a job's token cannot start workflow runs that read secrets
+func actionsKeyMissing(environment string) error {
+	where := ""
+	if environment != "" {
+		where = fmt.Sprintf(" and run the job with 'environment: %s'", environment)
+	}
+	return fmt.Errorf("running in GitHub Actions, where the key cannot be requested through the workflow; "+
+		"pass it to the step with 'env: %[1]s: ${{ secrets.%[1]s }}'%[2]s", github.SecretName, where)
+}
@@ -0,0 +1,51 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oliviaBahr/ez-env/github"
+)
+
+func TestLoadActionsKey(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	tests := []struct {
+		name      string
+		actions   string
+		value     string
+		want      []byte
+		noKey     bool
+		expectErr string
+	}{
+		{name: "passed by the job", actions: "true", value: base64.StdEncoding.EncodeToString(key) + "\n", want: key},
+		{name: "outside Actions", actions: "", value: base64.StdEncoding.EncodeToString(key), noKey: true},
+		{name: "not passed", actions: "true", noKey: true},
+		{name: "not base64", actions: "true", value: "not a key!", expectErr: "not a base64 encoded key"},
+		{name: "wrong size", actions: "true", value: base64.StdEncoding.EncodeToString(key[:16]), expectErr: "16-byte key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GITHUB_ACTIONS", tt.actions)
+			t.Setenv(github.SecretName, tt.value)
+			got, err := LoadActionsKey()
+			switch {
+			case tt.noKey:
+				assert.ErrorIs(t, err, ErrNoActionsKey)
+			case tt.expectErr != "":
+				assert.ErrorContains(t, err, tt.expectErr)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestActionsKeyMissing(t *testing.T) {
+	assert.ErrorContains(t, actionsKeyMissing(""), "${{ secrets."+github.SecretName+" }}")
+	assert.ErrorContains(t, actionsKeyMissing("production"), "environment: production")
+}
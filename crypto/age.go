@@ -0,0 +1,172 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"filippo.io/age/plugin"
+	"github.com/oliviaBahr/ez-env/ssh"
+)
+
+const (
+	// BackendSSH wraps the data encryption key to SSH keys directly: RSA-OAEP for RSA keys and the
+	// X25519 envelope for ed25519 keys
+	BackendSSH = "ssh"
+	// BackendAge wraps the data encryption key in the age format to age X25519 recipients and SSH keys
+	BackendAge = "age"
+	// BackendGPG wraps the data encryption key with gpg to OpenPGP public keys
+	BackendGPG = "gpg"
+
+	// AgeIdentityEnv is the environment variable naming an age identity file used to unwrap the key
+	AgeIdentityEnv = "EZENV_AGE_IDENTITY"
+	// AgeIdentityName is the file name of the default age identity inside ssh.KeyDir
+	AgeIdentityName = "age.txt"
+)
+
+// Backends lists the supported keyring backends
+var Backends = []string{BackendSSH, BackendAge, BackendGPG}
+
+// IsAgeRecipient reports whether recipient is an age X25519 or plugin recipient ("age1...")
+func IsAgeRecipient(recipient string) bool {
+	return strings.HasPrefix(recipient, "age1")
+}
+
+// parseAgeRecipient parses an age X25519 or plugin recipient, or an ssh-ed25519 or ssh-rsa public key
+func parseAgeRecipient(recipient string) (age.Recipient, error) {
+	if isPluginRecipient(recipient) {
+		r, err := plugin.NewRecipient(recipient, pluginUI())
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse age plugin recipient: %w", err)
+		}
+		return r, nil
+	}
+	if IsAgeRecipient(recipient) {
+		r, err := age.ParseX25519Recipient(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse age recipient: %w", err)
+		}
+		return r, nil
+	}
+	r, err := agessh.ParseRecipient(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH recipient: %w", err)
+	}
+	return r, nil
+}
+
+// WrapDEKAge encrypts the data encryption key to a single recipient in the age format
+func WrapDEKAge(dek []byte, recipient string) ([]byte, error) {
+	r, err := parseAgeRecipient(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data encryption key: %w", err)
+	}
+	if _, err := w.Write(dek); err != nil {
+		return nil, fmt.Errorf("failed to wrap data encryption key: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to wrap data encryption key: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnwrapDEKAge decrypts an age-wrapped data encryption key with any of the identities
+func UnwrapDEKAge(wrapped []byte, identities []age.Identity) ([]byte, error) {
+	r, err := age.Decrypt(bytes.NewReader(wrapped), identities...)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := io.ReadAll(io.LimitReader(r, keySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data encryption key: %w", err)
+	}
+	if len(dek) != keySize {
+		return nil, fmt.Errorf("invalid data encryption key size: expected %d, got %d", keySize, len(dek))
+	}
+	return dek, nil
+}
+
+// LoadAgeIdentities returns the local identities that can unwrap an age keyring: the age identity
+// file named by EZENV_AGE_IDENTITY or ~/.config/ezenv/keys/age.txt, and the SSH private key used
+// for the keyring
+func LoadAgeIdentities() ([]age.Identity, error) {
+	var identities []age.Identity
+
+	path := os.Getenv(AgeIdentityEnv)
+	explicit := path != ""
+	if !explicit {
+		if dir, err := ssh.KeyDir(); err == nil {
+			path = filepath.Join(dir, AgeIdentityName)
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			parsed, err := parseAgeIdentityFile(data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load %s: %w", path, err)
+			}
+			identities = append(identities, parsed...)
+		case explicit || !os.IsNotExist(err):
+			return nil, fmt.Errorf("failed to read age identity: %w", err)
+		}
+	}
+
+	// Every local SSH key is offered, since the one wrapped to may not be the preferred one
+	// Keys that cannot be parsed, e.g. passphrase protected ones, are skipped when another identity loads
+	var parseErr error
+	for _, keyPath := range ssh.LocalPrivateKeyPaths() {
+		if data, err := os.ReadFile(keyPath); err == nil {
+			identity, err := agessh.ParseIdentity(data)
+			if err == nil {
+				identities = append(identities, identity)
+			} else if parseErr == nil {
+				parseErr = fmt.Errorf("failed to load %s: %w", keyPath, err)
+			}
+		}
+	}
+	if len(identities) == 0 && parseErr != nil {
+		return nil, parseErr
+	}
+
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no age identity found: set %s or %s", AgeIdentityEnv, ssh.PrivateKeyEnv)
+	}
+	return identities, nil
+}
+
+// DecryptDEKAge unwraps the data encryption key from the first entry one of the identities can open
+func (k *Keyring) DecryptDEKAge(identities []age.Identity) ([]byte, error) {
+	for _, entry := range k.Entries {
+		if entry.EncryptedDEK == "" {
+			continue
+		}
+		wrapped, err := decodeWrappedDEK(entry)
+		if err != nil {
+			return nil, err
+		}
+		dek, err := UnwrapDEKAge(wrapped, identities)
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap key for %s (%s): %w", entry.Login, entry.Fingerprint, err)
+		}
+		return dek, nil
+	}
+	return nil, fmt.Errorf("%w matches the local age identities", ErrNoKeyringEntry)
+}
@@ -0,0 +1,187 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Files in the age format are plain age ciphertexts encrypted to an X25519 identity derived from
+// the repository key. They can be decrypted with the age CLI and the identity printed by
+// 'export-key --age', without ez-env. The age format has no header fields, so files are neither
+// padded, deterministic nor bound to their path.
+
+const (
+	// FormatEnvelope is the ez-env envelope format
+	FormatEnvelope = "envelope"
+	// FormatAge writes age ciphertext decryptable with the age CLI
+	FormatAge = "age"
+
+	// AgeFormatVersion is the format version reported for age ciphertext, which has no version of
+	// its own in ez-env's numbering
+	AgeFormatVersion = 0
+)
+
+// Formats lists the formats files can be encrypted with
+var Formats = []string{FormatEnvelope, FormatAge}
+
+// ageHeader starts every binary age file
+var ageHeader = []byte("age-encryption.org/v1\n")
+
+// ageIdentityInfo separates the age identity from other keys derived from the repository key
+const ageIdentityInfo = "ez-env age identity"
+
+// isAgeFile reports whether data is age ciphertext
+func isAgeFile(data []byte) bool {
+	return bytes.HasPrefix(data, ageHeader)
+}
+
+// AgeIdentity returns the age identity derived from the repository key, in the AGE-SECRET-KEY-1
+// form the age CLI reads from an identity file
+func AgeIdentity(key []byte) (string, error) {
+	if len(key) != keySize {
+		return "", fmt.Errorf("invalid key size: expected %d, got %d", keySize, len(key))
+	}
+	scalar := make([]byte, 32)
+	defer Wipe(scalar)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(ageIdentityInfo)), scalar); err != nil {
+		return "", fmt.Errorf("failed to derive age identity: %w", err)
+	}
+	return strings.ToUpper(bech32Encode("age-secret-key-", scalar)), nil
+}
+
+// ageIdentity parses the age identity derived from the repository key
+func ageIdentity(key []byte) (*age.X25519Identity, error) {
+	encoded, err := AgeIdentity(key)
+	if err != nil {
+		return nil, err
+	}
+	return age.ParseX25519Identity(encoded)
+}
+
+// encryptAge encrypts plaintext to the age identity of key
+func encryptAge(plaintext, key []byte) ([]byte, error) {
+	identity, err := ageIdentity(key)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	w, err := age.Encrypt(&out, identity.Recipient())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// decryptAge decrypts age ciphertext with the age identity of key
+func decryptAge(encrypted, key []byte) ([]byte, error) {
+	r, err := newAgeReader(bytes.NewReader(encrypted), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// newAgeReader returns a reader of the plaintext of the age ciphertext read from r, decrypted with
+// the age identity of key
+func newAgeReader(r io.Reader, key []byte) (io.Reader, error) {
+	identity, err := ageIdentity(key)
+	if err != nil {
+		return nil, err
+	}
+	decrypter, err := age.Decrypt(r, identity)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, fmt.Errorf("%w: the age file is not encrypted to this repository key", ErrAuthentication)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt age file: %w", err)
+	}
+	return &ageReader{r: decrypter}, nil
+}
+
+// ageReader reports the errors of an age decrypter, which fail to authenticate a chunk, as
+// ErrAuthentication
+type ageReader struct {
+	r io.Reader
+}
+
+func (a *ageReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%w: %v", ErrAuthentication, err)
+	}
+	return n, err
+}
+
+// bech32Charset is the alphabet of bech32 data characters
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32Encode encodes data as a BIP 173 bech32 string with the human-readable part hrp
+func bech32Encode(hrp string, data []byte) string {
+	// Regroup the 8-bit bytes into 5-bit values, zero-padding the last one
+	var values []byte
+	acc, bits := 0, 0
+	for _, b := range data {
+		acc = acc<<8 | int(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			values = append(values, byte(acc>>bits&31))
+		}
+	}
+	if bits > 0 {
+		values = append(values, byte(acc<<(5-bits)&31))
+	}
+
+	checksumInput := make([]byte, 0, 2*len(hrp)+1+len(values)+6)
+	for i := range len(hrp) {
+		checksumInput = append(checksumInput, hrp[i]>>5)
+	}
+	checksumInput = append(checksumInput, 0)
+	for i := range len(hrp) {
+		checksumInput = append(checksumInput, hrp[i]&31)
+	}
+	checksumInput = append(checksumInput, values...)
+	checksumInput = append(checksumInput, 0, 0, 0, 0, 0, 0)
+	polymod := bech32Polymod(checksumInput) ^ 1
+
+	var out strings.Builder
+	out.WriteString(hrp)
+	out.WriteByte('1')
+	for _, v := range values {
+		out.WriteByte(bech32Charset[v])
+	}
+	for i := range 6 {
+		out.WriteByte(bech32Charset[polymod>>(5*(5-i))&31])
+	}
+	return out.String()
+}
+
+// bech32Polymod computes the bech32 checksum polynomial
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := range 5 {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
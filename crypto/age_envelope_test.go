@@ -0,0 +1,59 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBech32Encode(t *testing.T) {
+	// BIP 173 test vector with an empty data part
+	assert.Equal(t, "a12uel5l", bech32Encode("a", nil))
+}
+
+func TestAgeFormat(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	plaintext := []byte("API_KEY=secret\n")
+
+	encrypted, err := EncryptFileWithOptions(plaintext, key, EncryptOptions{Format: FormatAge})
+	require.NoError(t, err)
+	assert.True(t, IsEncryptedFile(encrypted))
+	version, ok := FormatVersion(encrypted)
+	assert.True(t, ok)
+	assert.Equal(t, AgeFormatVersion, version)
+
+	decrypted, err := DecryptFileAt(encrypted, key, "moved/.env")
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	// The exported identity decrypts the file with age alone
+	encoded, err := AgeIdentity(key)
+	require.NoError(t, err)
+	identity, err := age.ParseX25519Identity(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, encoded, identity.String())
+	r, err := age.Decrypt(bytes.NewReader(encrypted), identity)
+	require.NoError(t, err)
+	decrypted, err = io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	otherKey, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	_, err = DecryptFile(encrypted, otherKey)
+	assert.ErrorIs(t, err, ErrAuthentication)
+
+	_, err = EncryptFileWithOptions(plaintext, key, EncryptOptions{Format: FormatAge, PaddingBucket: 64})
+	assert.ErrorContains(t, err, "not supported by the age format")
+	_, err = EncryptFileWithOptions(plaintext, key, EncryptOptions{Format: FormatAge, Path: ".env"})
+	assert.EqualError(t, err, "path binding is not supported by the age format")
+	_, err = NewEncryptWriter(io.Discard, key, EncryptOptions{Format: FormatAge, Path: ".env"})
+	assert.EqualError(t, err, "path binding is not supported by the age format")
+	_, err = EncryptFileWithOptions(plaintext, key, EncryptOptions{Format: "zip"})
+	assert.EqualError(t, err, `unknown format "zip" (expected envelope or age)`)
+}
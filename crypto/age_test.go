@@ -0,0 +1,132 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/oliviaBahr/ez-env/ssh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// generateTestEd25519Key creates an ed25519 keypair as an OpenSSH PEM private key and an authorized_keys public key
+func generateTestEd25519Key(t *testing.T) ([]byte, string) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := gossh.MarshalPrivateKey(private, "")
+	require.NoError(t, err)
+	sshPublic, err := gossh.NewPublicKey(public)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(block), string(gossh.MarshalAuthorizedKey(sshPublic))
+}
+
+func TestWrapUnwrapDEKAge(t *testing.T) {
+	dek, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	other, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	wrapped, err := WrapDEKAge(dek, identity.Recipient().String())
+	require.NoError(t, err)
+
+	unwrapped, err := UnwrapDEKAge(wrapped, []age.Identity{other, identity})
+	require.NoError(t, err)
+	assert.Equal(t, dek, unwrapped)
+
+	_, err = UnwrapDEKAge(wrapped, []age.Identity{other})
+	assert.Error(t, err, "unwrapping with a different identity should fail")
+
+	_, err = WrapDEKAge(dek, "age1invalid")
+	assert.Error(t, err)
+}
+
+func TestAgeKeyringRoundTrip(t *testing.T) {
+	alice, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	_, bobPublic := generateTestEd25519Key(t)
+	_, carolPublic := generateTestSSHKey(t)
+	outsider, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	dek, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	keyring := NewKeyring()
+	keyring.Backend = BackendAge
+	for _, publicKey := range []string{alice.Recipient().String(), bobPublic, carolPublic} {
+		assert.True(t, keyring.CanWrapTo(publicKey))
+	}
+	assert.False(t, NewKeyring().CanWrapTo(alice.Recipient().String()), "the SSH backend cannot wrap to age recipients")
+
+	_, err = keyring.AddEntry("alice", alice.Recipient().String())
+	require.NoError(t, err)
+	_, err = keyring.AddEntry("bob", bobPublic)
+	require.NoError(t, err)
+	_, err = keyring.AddEntry("carol", carolPublic)
+	require.NoError(t, err)
+	assert.Equal(t, alice.Recipient().String(), keyring.Entries[0].Fingerprint)
+	require.NoError(t, keyring.GenerateEncryptedDEKs(dek, keyring.Logins()...))
+
+	path := filepath.Join(t.TempDir(), KeyringFile)
+	require.NoError(t, keyring.Save(path))
+	loaded, err := LoadKeyring(path)
+	require.NoError(t, err)
+	assert.Equal(t, BackendAge, loaded.WrapBackend())
+
+	unwrapped, err := loaded.DecryptDEKAge([]age.Identity{alice})
+	require.NoError(t, err)
+	assert.Equal(t, dek, unwrapped)
+
+	_, err = loaded.DecryptDEKAge([]age.Identity{outsider})
+	assert.Error(t, err)
+}
+
+func TestLoadAgeIdentities(t *testing.T) {
+	dir := t.TempDir()
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	identityPath := filepath.Join(dir, "identity.txt")
+	require.NoError(t, os.WriteFile(identityPath, []byte(identity.String()+"\n"), 0600))
+
+	privatePEM, publicKey := generateTestEd25519Key(t)
+	keyPath := filepath.Join(dir, "id_ed25519")
+	require.NoError(t, os.WriteFile(keyPath, privatePEM, 0600))
+
+	t.Setenv(AgeIdentityEnv, identityPath)
+	t.Setenv(ssh.PrivateKeyEnv, keyPath)
+
+	identities, err := LoadAgeIdentities()
+	require.NoError(t, err)
+	assert.Len(t, identities, 2)
+
+	dek, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	wrapped, err := WrapDEKAge(dek, publicKey)
+	require.NoError(t, err)
+	unwrapped, err := UnwrapDEKAge(wrapped, identities)
+	require.NoError(t, err)
+	assert.Equal(t, dek, unwrapped)
+
+	t.Setenv(AgeIdentityEnv, filepath.Join(dir, "missing.txt"))
+	_, err = LoadAgeIdentities()
+	assert.Error(t, err)
+}
+
+func TestLoadKeyringUnknownBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), KeyringFile)
+	require.NoError(t, os.WriteFile(path, []byte(`{"version":1,"backend":"pgp","entries":[]}`), 0644))
+
+	_, err := LoadKeyring(path)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported keyring backend")
+}
@@ -0,0 +1,207 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/oliviaBahr/ez-env/canonical"
+	"github.com/oliviaBahr/ez-env/ssh"
+)
+
+// capabilityVersion is the current capability file format
+const capabilityVersion = 1
+
+// CapabilityRecipient is a public key the capability key is wrapped to
+type CapabilityRecipient struct {
+	Fingerprint string `json:"fingerprint"`
+	WrappedKey  string `json:"wrapped_key"`
+}
+
+// CapabilityFile is a single delegated file encrypted with its path-bound subkey
+type CapabilityFile struct {
+	Path       string `json:"path"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// Capability is a time-limited grant to decrypt a fixed set of files
+// Every file is encrypted with a subkey derived from a fresh capability key, the path and
+// the expiry, so editing the paths or the expiry breaks decryption
+// Expiry is advisory once the capability is issued: Open enforces it, but a holder who unwraps the
+// capability key can derive the subkeys and decrypt the files at any later time
+type Capability struct {
+	Version    int                   `json:"version"`
+	Subject    string                `json:"subject"`
+	IssuedAt   time.Time             `json:"issued_at"`
+	ExpiresAt  time.Time             `json:"expires_at"`
+	Patterns   []string              `json:"patterns"`
+	Recipients []CapabilityRecipient `json:"recipients"`
+	Files      []CapabilityFile      `json:"files"`
+}
+
+// DerivePathKey derives a subkey of key that is bound to a path and context string
+func DerivePathKey(key []byte, path, context string) ([]byte, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid key size: expected %d, got %d", keySize, len(key))
+	}
+
+	info := []byte("ez-env path key\x00" + context + "\x00" + path)
+	subkey := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, info), subkey); err != nil {
+		return nil, fmt.Errorf("failed to derive path key: %w", err)
+	}
+	return subkey, nil
+}
+
+// NewCapability seals files for the given SSH public keys until expiresAt
+func NewCapability(subject string, patterns []string, files map[string][]byte, authorizedKeys []string, issuedAt, expiresAt time.Time) (*Capability, error) {
+	if len(authorizedKeys) == 0 {
+		return nil, fmt.Errorf("no recipient keys specified")
+	}
+	if !expiresAt.After(issuedAt) {
+		return nil, fmt.Errorf("capability must expire after it is issued")
+	}
+
+	capKey, err := GenerateEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+
+	capability := &Capability{
+		Version:   capabilityVersion,
+		Subject:   subject,
+		IssuedAt:  issuedAt.UTC().Truncate(time.Second),
+		ExpiresAt: expiresAt.UTC().Truncate(time.Second),
+		Patterns:  patterns,
+	}
+
+	for _, authorizedKey := range authorizedKeys {
+		fingerprint, err := ssh.Fingerprint(authorizedKey)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := WrapDEK(capKey, authorizedKey)
+		if err != nil {
+			return nil, err
+		}
+		capability.Recipients = append(capability.Recipients, CapabilityRecipient{
+			Fingerprint: fingerprint,
+			WrappedKey:  base64.StdEncoding.EncodeToString(wrapped),
+		})
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		subkey, err := DerivePathKey(capKey, path, capability.context())
+		if err != nil {
+			return nil, err
+		}
+		encrypted, err := EncryptFile(files[path], subkey)
+		if err != nil {
+			return nil, err
+		}
+		capability.Files = append(capability.Files, CapabilityFile{
+			Path:       path,
+			Ciphertext: base64.StdEncoding.EncodeToString(encrypted),
+		})
+	}
+
+	return capability, nil
+}
+
+// ParseCapability decodes a capability file
+func ParseCapability(data []byte) (*Capability, error) {
+	var capability Capability
+	if err := json.Unmarshal(data, &capability); err != nil {
+		return nil, fmt.Errorf("failed to parse capability: %w", err)
+	}
+	if capability.Version != capabilityVersion {
+		return nil, fmt.Errorf("unsupported capability version: %d", capability.Version)
+	}
+	return &capability, nil
+}
+
+// Marshal encodes the capability as canonical JSON
+func (c *Capability) Marshal() ([]byte, error) {
+	data, err := canonical.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode capability: %w", err)
+	}
+	return data, nil
+}
+
+// Open decrypts the delegated files with an SSH private key if the capability has not expired
+// The check only binds clients that run it; see Capability
+func (c *Capability) Open(privateKey crypto.PrivateKey, now time.Time) (map[string][]byte, error) {
+	if !now.Before(c.ExpiresAt) {
+		return nil, fmt.Errorf("capability for %s expired at %s", c.Subject, c.ExpiresAt.Format(time.RFC3339))
+	}
+
+	capKey, err := c.unwrapKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte, len(c.Files))
+	for _, file := range c.Files {
+		subkey, err := DerivePathKey(capKey, file.Path, c.context())
+		if err != nil {
+			return nil, err
+		}
+		encrypted, err := base64.StdEncoding.DecodeString(file.Ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", file.Path, err)
+		}
+		plaintext, err := DecryptFile(encrypted, subkey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", file.Path, err)
+		}
+		files[file.Path] = plaintext
+	}
+	return files, nil
+}
+
+// unwrapKey finds the recipient entry for privateKey and unwraps the capability key
+func (c *Capability) unwrapKey(privateKey crypto.PrivateKey) ([]byte, error) {
+	publicKey, err := ssh.PublicKeyOf(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	authorizedKey, err := ssh.AuthorizedKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := ssh.Fingerprint(authorizedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, recipient := range c.Recipients {
+		if recipient.Fingerprint != fingerprint {
+			continue
+		}
+		wrapped, err := base64.StdEncoding.DecodeString(recipient.WrappedKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode wrapped key: %w", err)
+		}
+		return UnwrapDEK(wrapped, privateKey)
+	}
+	return nil, fmt.Errorf("capability was not issued to SSH key %s", fingerprint)
+}
+
+// context binds derived subkeys to the capability subject and expiry
+func (c *Capability) context() string {
+	return "capability\x00" + c.Subject + "\x00" + c.ExpiresAt.UTC().Format(time.RFC3339)
+}
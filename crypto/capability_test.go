@@ -0,0 +1,93 @@
+package crypto
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilityRoundTrip(t *testing.T) {
+	holderPrivate, holderPublic := generateTestSSHKey(t)
+	outsiderPrivate, _ := generateTestSSHKey(t)
+
+	issued := time.Now()
+	files := map[string][]byte{
+		"staging/.env": []byte("API_KEY=staging\n"),
+		"staging/db":   []byte("DB_PASSWORD=hunter2\n"),
+	}
+
+	capability, err := NewCapability("support-bot", []string{"staging/*"}, files, []string{holderPublic}, issued, issued.Add(time.Hour))
+	require.NoError(t, err)
+
+	data, err := capability.Marshal()
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+
+	parsed, err := ParseCapability(data)
+	require.NoError(t, err)
+
+	opened, err := parsed.Open(holderPrivate, issued.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, files, opened)
+
+	_, err = parsed.Open(outsiderPrivate, issued.Add(time.Minute))
+	assert.Error(t, err, "a key the capability was not issued to should not open it")
+
+	_, err = parsed.Open(holderPrivate, issued.Add(2*time.Hour))
+	assert.Error(t, err, "an expired capability should not open")
+}
+
+func TestCapabilityTampering(t *testing.T) {
+	holderPrivate, holderPublic := generateTestSSHKey(t)
+
+	issued := time.Now()
+	files := map[string][]byte{
+		"staging/.env": []byte("API_KEY=staging\n"),
+		"staging/db":   []byte("DB_PASSWORD=hunter2\n"),
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(c *Capability)
+	}{
+		{"extended expiry", func(c *Capability) { c.ExpiresAt = c.ExpiresAt.Add(24 * time.Hour) }},
+		{"changed subject", func(c *Capability) { c.Subject = "someone-else" }},
+		{"swapped paths", func(c *Capability) { c.Files[0].Path, c.Files[1].Path = c.Files[1].Path, c.Files[0].Path }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			capability, err := NewCapability("support-bot", []string{"staging/*"}, files, []string{holderPublic}, issued, issued.Add(time.Hour))
+			require.NoError(t, err)
+
+			tt.tamper(capability)
+			_, err = capability.Open(holderPrivate, issued.Add(time.Minute))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDerivePathKey(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	a, err := DerivePathKey(key, "a/.env", "ctx")
+	require.NoError(t, err)
+	again, err := DerivePathKey(key, "a/.env", "ctx")
+	require.NoError(t, err)
+	b, err := DerivePathKey(key, "b/.env", "ctx")
+	require.NoError(t, err)
+	other, err := DerivePathKey(key, "a/.env", "other")
+	require.NoError(t, err)
+
+	assert.Len(t, a, keySize)
+	assert.Equal(t, a, again)
+	assert.NotEqual(t, a, b)
+	assert.NotEqual(t, a, other)
+	assert.NotEqual(t, key, a)
+
+	_, err = DerivePathKey([]byte("short"), "a/.env", "ctx")
+	assert.Error(t, err)
+}
@@ -4,7 +4,10 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 )
@@ -16,6 +19,10 @@ const (
 	tagSize   = 16
 )
 
+// ErrAuthentication is returned when well-formed ciphertext does not authenticate,
+// which means it was encrypted with a different key or modified afterwards
+var ErrAuthentication = errors.New("message authentication failed")
+
 // GenerateEncryptionKey generates a new AES-256 encryption key
 func GenerateEncryptionKey() ([]byte, error) {
 	key := make([]byte, keySize)
@@ -25,50 +32,36 @@ func GenerateEncryptionKey() ([]byte, error) {
 	return key, nil
 }
 
+// KeyID returns the fingerprint of a key, the first 8 bytes of a domain-separated SHA-256 hash, which
+// can be shown and recorded without revealing the key
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("ez-env key id\x00"), key...))
+	return hex.EncodeToString(sum[:8])
+}
+
 // EncryptFile encrypts file contents using AES-256-GCM
-// Returns the encrypted data with metadata:
-// - Version (uint32)
-// - Nonce (12 bytes)
-// - Encrypted content
+// Returns a format v2 envelope recording the cipher and the key id (see envelope.go)
 func EncryptFile(plaintext []byte, key []byte) ([]byte, error) {
-	if len(key) != keySize {
-		return nil, fmt.Errorf("invalid key size: expected %d, got %d", keySize, len(key))
-	}
-
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
-	}
-
-	nonce := make([]byte, nonceSize)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, fmt.Errorf("failed to generate nonce: %w", err)
-	}
-
-	// Encrypt the content
-	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
-
-	// Create the final output with metadata
-	// Format: [version(4)][nonce(12)][ciphertext]
-	output := make([]byte, 4+nonceSize+len(ciphertext))
-	binary.BigEndian.PutUint32(output[0:4], 1) // Version 1
-	copy(output[4:4+nonceSize], nonce)
-	copy(output[4+nonceSize:], ciphertext)
-
-	return output, nil
+	return EncryptFileWithOptions(plaintext, key, EncryptOptions{})
 }
 
 // DecryptFile decrypts file contents using AES-256-GCM
+// The format v2 envelope, age files (see age_envelope.go) and the legacy version 1 format are accepted:
+// - Version (uint32, 1)
+// - Nonce (12 bytes)
+// - Encrypted content
 func DecryptFile(encrypted []byte, key []byte) ([]byte, error) {
 	if len(key) != keySize {
 		return nil, fmt.Errorf("invalid key size: expected %d, got %d", keySize, len(key))
 	}
 
+	if isEnvelope(encrypted) {
+		return decryptEnvelope(encrypted, key, "")
+	}
+	if isAgeFile(encrypted) {
+		return decryptAge(encrypted, key)
+	}
+
 	if len(encrypted) < 4+nonceSize {
 		return nil, fmt.Errorf("encrypted data too short")
 	}
@@ -78,6 +71,9 @@ func DecryptFile(encrypted []byte, key []byte) ([]byte, error) {
 	if version != 1 {
 		return nil, fmt.Errorf("unsupported version: %d", version)
 	}
+	if len(encrypted) < 4+nonceSize+tagSize {
+		return nil, fmt.Errorf("encrypted data too short")
+	}
 
 	// Extract nonce and ciphertext
 	nonce := encrypted[4 : 4+nonceSize]
@@ -95,7 +91,7 @@ func DecryptFile(encrypted []byte, key []byte) ([]byte, error) {
 
 	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt: %w", err)
+		return nil, fmt.Errorf("failed to decrypt: %w", ErrAuthentication)
 	}
 
 	return plaintext, nil
@@ -103,10 +99,51 @@ func DecryptFile(encrypted []byte, key []byte) ([]byte, error) {
 
 // IsEncryptedFile checks if a file appears to be encrypted by ez-env
 func IsEncryptedFile(data []byte) bool {
-	if len(data) < 4 {
+	if isEnvelope(data) {
+		// Plaintext may start with the magic, so the header has to parse as well
+		_, _, err := decodeHeader(data)
+		return err == nil
+	}
+	if isAgeFile(data) {
+		return true
+	}
+
+	// The bare version 1 prefix is easily matched by other binary data, so at least
+	// a nonce and a tag must follow it
+	if len(data) < 4+nonceSize+tagSize {
 		return false
 	}
 
 	version := binary.BigEndian.Uint32(data[0:4])
 	return version == 1
 }
+
+// SupportedFormats lists the encrypted format versions this build can decrypt
+var SupportedFormats = []int{AgeFormatVersion, 1, envelopeVersion}
+
+// FormatVersion reports the format version of ez-env ciphertext, including versions newer than this
+// build understands, so callers can tell an unsupported format apart from plaintext
+// Supported formats must pass IsEncryptedFile; newer ones must keep the envelope framing
+func FormatVersion(data []byte) (int, bool) {
+	switch {
+	case isAgeFile(data):
+		return AgeFormatVersion, true
+	case IsEncryptedFile(data) && isEnvelope(data):
+		return envelopeVersion, true
+	case IsEncryptedFile(data):
+		return 1, true
+	case isNewerEnvelope(data):
+		return int(data[len(envelopeMagic)]), true
+	}
+	return 0, false
+}
+
+// IsSupportedFormat reports whether version is one of SupportedFormats
+func IsSupportedFormat(version int) bool {
+	for _, supported := range SupportedFormats {
+		if supported == version {
+			return true
+		}
+	}
+	return false
+}
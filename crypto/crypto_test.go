@@ -3,12 +3,27 @@ package crypto
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// encryptVersion1 produces the legacy version 1 format: [version(4)][nonce(12)][ciphertext]
+func encryptVersion1(t *testing.T, plaintext, key []byte) []byte {
+	t.Helper()
+	gcm, err := newGCM(key)
+	require.NoError(t, err)
+	nonce := make([]byte, nonceSize)
+	_, err = rand.Read(nonce)
+	require.NoError(t, err)
+	output := binary.BigEndian.AppendUint32(nil, 1)
+	output = append(output, nonce...)
+	return gcm.Seal(output, nonce, plaintext, nil)
+}
+
 func TestGenerateEncryptionKey(t *testing.T) {
 	tests := []struct {
 		name string
@@ -317,6 +332,11 @@ func TestIsEncryptedFile(t *testing.T) {
 			data: []byte{0x00, 0x00, 0x00},
 			want: false,
 		},
+		{
+			name: "identifies plaintext starting with the envelope magic",
+			data: []byte("EZENV\x02\xff\xff plain text content"),
+			want: false,
+		},
 		{
 			name: "identifies wrong version",
 			data: append([]byte{0x00, 0x00, 0x00, 0x02}, make([]byte, 100)...), // Version 2
@@ -371,7 +391,9 @@ func TestLargeFileEncryption(t *testing.T) {
 	require.NoError(t, err)
 
 	// Verify size
-	expectedSize := 4 + nonceSize + len(largeContent) + tagSize
+	header, _, err := decodeHeader(encrypted)
+	require.NoError(t, err)
+	expectedSize := len(header) + nonceSize + len(largeContent) + tagSize
 	assert.Len(t, encrypted, expectedSize)
 
 	// Decrypt
@@ -407,3 +429,80 @@ func BenchmarkDecryptFile(b *testing.B) {
 		require.NoError(b, err)
 	}
 }
+
+func TestDecryptFileAuthenticationError(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	otherKey, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	encrypted, err := EncryptFile([]byte("SECRET=1\n"), key)
+	require.NoError(t, err)
+
+	_, err = DecryptFile(encrypted, otherKey)
+	assert.ErrorIs(t, err, ErrAuthentication)
+
+	_, err = DecryptFile(encrypted[:4+nonceSize+tagSize-1], key)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrAuthentication, "truncated data is malformed, not unauthenticated")
+}
+
+func TestDecryptFileVersion1(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	encrypted := encryptVersion1(t, []byte("SECRET=1\n"), key)
+	assert.True(t, IsEncryptedFile(encrypted))
+
+	decrypted, err := DecryptFile(encrypted, key)
+	require.NoError(t, err)
+	assert.Equal(t, "SECRET=1\n", string(decrypted))
+
+	// A bare version prefix without room for a nonce and tag is not ciphertext
+	assert.False(t, IsEncryptedFile([]byte{0x00, 0x00, 0x00, 0x01, 'P', 'K'}))
+}
+
+func TestKeyID(t *testing.T) {
+	key1, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	key2, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	id := KeyID(key1)
+	assert.Len(t, id, 16)
+	assert.Equal(t, id, KeyID(key1))
+	assert.NotEqual(t, id, KeyID(key2))
+	assert.NotContains(t, id, hex.EncodeToString(key1[:8]))
+}
+
+func TestFormatVersion(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	v1 := encryptVersion1(t, []byte("test"), key)
+	v2, err := EncryptFile([]byte("test"), key)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		data      []byte
+		version   int
+		encrypted bool
+		supported bool
+	}{
+		{name: "version 1", data: v1, version: 1, encrypted: true, supported: true},
+		{name: "version 2 envelope", data: v2, version: 2, encrypted: true, supported: true},
+		{name: "newer envelope", data: append([]byte("EZENV\x07"), make([]byte, 40)...), version: 7, encrypted: true},
+		{name: "plaintext", data: []byte("KEY=value\n")},
+		{name: "plaintext starting with the magic", data: []byte("EZENV_API_KEY=secret\n")},
+		{name: "current version with an invalid header", data: append([]byte("EZENV\x02\x00\x05\x01"), make([]byte, 40)...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, encrypted := FormatVersion(tt.data)
+			assert.Equal(t, tt.version, version)
+			assert.Equal(t, tt.encrypted, encrypted)
+			assert.Equal(t, tt.supported, encrypted && IsSupportedFormat(version))
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"io"
+
+	"filippo.io/edwards25519"
+	"golang.org/x/crypto/hkdf"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/oliviaBahr/ez-env/ssh"
+)
+
+// X25519 wrapped key format:
+// - Version (1 byte, x25519WrapVersion)
+// - Ephemeral X25519 public key (32 bytes)
+// - AES-256-GCM encrypted data encryption key (32 bytes + 16 byte tag)
+// The AEAD key and nonce are derived with HKDF-SHA256 from the shared secret, salted with the
+// ephemeral and recipient public keys. RSA-OAEP output is never this short, so both can coexist.
+const (
+	x25519WrapVersion = 0x01
+	x25519WrapInfo    = "ez-env x25519 dek wrap v1"
+	x25519WrappedSize = 1 + 32 + keySize + tagSize
+)
+
+// CanWrapTo reports whether the data encryption key can be wrapped to an SSH public key
+// RSA keys use RSA-OAEP; ed25519 keys use the X25519 envelope
+func CanWrapTo(authorizedKey string) bool {
+	pub, err := ssh.ParsePublicKey(authorizedKey)
+	if err != nil {
+		return false
+	}
+	switch pub.Type() {
+	case gossh.KeyAlgoRSA, gossh.KeyAlgoED25519:
+		return true
+	}
+	return false
+}
+
+// WrapDEK encrypts the data encryption key to an SSH public key, using the X25519 envelope for
+// ed25519 keys and RSA-OAEP for RSA keys
+func WrapDEK(dek []byte, authorizedKey string) ([]byte, error) {
+	pub, err := ssh.ParsePublicKey(authorizedKey)
+	if err != nil {
+		return nil, err
+	}
+	if pub.Type() == gossh.KeyAlgoED25519 {
+		recipient, err := ed25519ToX25519Public(pub.(gossh.CryptoPublicKey).CryptoPublicKey().(ed25519.PublicKey))
+		if err != nil {
+			return nil, err
+		}
+		return wrapX25519(dek, recipient)
+	}
+
+	rsaPub, err := ssh.RSAPublicKey(authorizedKey)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaPub, dek, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data encryption key: %w", err)
+	}
+	return wrapped, nil
+}
+
+// UnwrapDEK decrypts a wrapped data encryption key with an RSA or ed25519 private key
+func UnwrapDEK(wrapped []byte, privateKey crypto.PrivateKey) ([]byte, error) {
+	var dek []byte
+	var err error
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		dek, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, key, wrapped, nil)
+	case ed25519.PrivateKey:
+		dek, err = unwrapX25519(wrapped, ed25519ToX25519Private(key))
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %T", privateKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data encryption key: %w", err)
+	}
+	if len(dek) != keySize {
+		return nil, fmt.Errorf("invalid data encryption key size: expected %d, got %d", keySize, len(dek))
+	}
+	return dek, nil
+}
+
+// wrapX25519 encrypts dek to an X25519 public key with an ephemeral key agreement
+func wrapX25519(dek []byte, recipient *ecdh.PublicKey) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data encryption key: %w", err)
+	}
+	defer Wipe(shared)
+
+	ephemeralPublic := ephemeral.PublicKey().Bytes()
+	aead, nonce, err := x25519AEAD(shared, ephemeralPublic, recipient.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	output := append([]byte{x25519WrapVersion}, ephemeralPublic...)
+	return aead.Seal(output, nonce, dek, output), nil
+}
+
+// unwrapX25519 decrypts a key wrapped by wrapX25519
+func unwrapX25519(wrapped []byte, privateKey *ecdh.PrivateKey) ([]byte, error) {
+	if len(wrapped) != x25519WrappedSize || wrapped[0] != x25519WrapVersion {
+		return nil, fmt.Errorf("not an X25519 wrapped key")
+	}
+	header := wrapped[:1+32]
+	ephemeral, err := ecdh.X25519().NewPublicKey(wrapped[1 : 1+32])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := privateKey.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(shared)
+
+	aead, nonce, err := x25519AEAD(shared, ephemeral.Bytes(), privateKey.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	dek, err := aead.Open(nil, nonce, wrapped[len(header):], header)
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return dek, nil
+}
+
+// x25519AEAD derives the AES-256-GCM key and nonce for an X25519 wrap
+func x25519AEAD(shared, ephemeralPublic, recipientPublic []byte) (cipher.AEAD, []byte, error) {
+	salt := append(append([]byte{}, ephemeralPublic...), recipientPublic...)
+	material := make([]byte, keySize+nonceSize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(x25519WrapInfo)), material); err != nil {
+		return nil, nil, fmt.Errorf("failed to derive wrapping key: %w", err)
+	}
+	aead, err := newGCM(material[:keySize])
+	// The cipher keeps its own key schedule, so the derived key is not needed any more
+	Wipe(material[:keySize])
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, material[keySize:], nil
+}
+
+// ed25519ToX25519Public converts an ed25519 public key to the birationally equivalent X25519 key
+func ed25519ToX25519Public(pub ed25519.PublicKey) (*ecdh.PublicKey, error) {
+	point, err := new(edwards25519.Point).SetBytes(pub)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ed25519 public key: %w", err)
+	}
+	return ecdh.X25519().NewPublicKey(point.BytesMontgomery())
+}
+
+// ed25519ToX25519Private derives the X25519 private key matching ed25519ToX25519Public
+func ed25519ToX25519Private(key ed25519.PrivateKey) *ecdh.PrivateKey {
+	digest := sha512.Sum512(key.Seed())
+	// NewPrivateKey clamps the scalar as X25519 requires; 32 bytes never fail
+	private, _ := ecdh.X25519().NewPrivateKey(digest[:32])
+	return private
+}
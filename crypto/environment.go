@@ -0,0 +1,199 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/hosting"
+)
+
+// Files can be encrypted with the key of a GitHub Environment (dev, staging, prod) instead of the
+// repository key. The environment is assigned per path with the ezenv-environment attribute in
+// .gitattributes, its key is stored as the environment's secret, and the key management workflow
+// runs in that environment, so its protection rules decide who can fetch the key.
+
+// EnvironmentAttribute is the .gitattributes attribute naming the environment of a managed file
+const EnvironmentAttribute = "ezenv-environment"
+
+// environmentNamePattern matches the environment names ez-env accepts; they become directory names
+var environmentNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ValidateEnvironment checks that name can be used as an environment
+func ValidateEnvironment(name string) error {
+	if !environmentNamePattern.MatchString(name) || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid environment name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+	return nil
+}
+
+// FileEnvironment returns the environment assigned to path by .gitattributes, or "" for files
+// encrypted with the repository key
+func FileEnvironment(path string) (string, error) {
+	environments, err := FileEnvironments([]string{path})
+	if err != nil {
+		return "", err
+	}
+	return environments[path], nil
+}
+
+// FileEnvironments returns the environment of each path with a single check-attr process
+// Paths encrypted with the repository key are left out of the map
+func FileEnvironments(paths []string) (map[string]string, error) {
+	cmd := exec.Command("git", "check-attr", "-z", "--stdin", EnvironmentAttribute)
+	cmd.Stdin = strings.NewReader(strings.Join(paths, "\x00") + "\x00")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the %s attribute: %w", EnvironmentAttribute, err)
+	}
+
+	// Format: <path> NUL <attribute> NUL <value> NUL, repeated
+	environments := make(map[string]string)
+	fields := strings.Split(string(output), "\x00")
+	for i := 0; i+2 < len(fields); i += 3 {
+		switch path, value := fields[i], fields[i+2]; value {
+		case "unspecified", "unset", "set":
+		default:
+			if err := ValidateEnvironment(value); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			environments[path] = value
+		}
+	}
+	return environments, nil
+}
+
+// EnvironmentKeyPath returns where an imported key of an environment is stored
+func EnvironmentKeyPath(environment string) (string, error) {
+	if err := ValidateEnvironment(environment); err != nil {
+		return "", err
+	}
+	path, err := LocalKeyPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), "environments", environment, "key"), nil
+}
+
+// LoadEnvironmentKey reads the locally stored key of an environment
+func LoadEnvironmentKey(environment string) ([]byte, error) {
+	path, err := EnvironmentKeyPath(environment)
+	if err != nil {
+		return nil, err
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the %s environment key: %w", environment, err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid %s environment key size: expected %d, got %d", environment, keySize, len(key))
+	}
+	return key, nil
+}
+
+// SaveEnvironmentKey stores the key of an environment inside the git directory
+func SaveEnvironmentKey(environment string, key []byte) error {
+	if len(key) != keySize {
+		return fmt.Errorf("invalid key size: expected %d, got %d", keySize, len(key))
+	}
+	path, err := EnvironmentKeyPath(environment)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		return fmt.Errorf("failed to write the %s environment key: %w", environment, err)
+	}
+	return nil
+}
+
+// getEnvironmentKey returns the key of km's environment from this machine or the workflow and,
+// with create, creates it when the environment has none
+func (km *KeyManager) getEnvironmentKey(ctx context.Context, create bool) (*SecureBytes, error) {
+	if err := ValidateEnvironment(km.environment); err != nil {
+		return nil, err
+	}
+	if key, err := LoadEnvironmentKey(km.environment); err == nil {
+		km.source = "local key"
+		return SecureBytesFrom(key), nil
+	}
+	// A job running in the environment sees its secret under the repository secret's name
+	if key, err := LoadActionsKey(); err == nil {
+		km.source = "actions secret"
+		return SecureBytesFrom(key), nil
+	} else if !errors.Is(err, ErrNoActionsKey) {
+		return nil, err
+	}
+	if parent, ok := ParentRepo(); ok {
+		key, err := loadParentKey(parent, km.environment)
+		if err != nil {
+			return nil, err
+		}
+		km.source = "parent repository"
+		return SecureBytesFrom(key), nil
+	}
+	if CurrentMode() != ModeSharedKey {
+		return nil, fmt.Errorf("environment keys need shared-key mode; the repository uses %s mode", CurrentMode())
+	}
+	if hosting.Current() != hosting.HostGitHub {
+		return nil, fmt.Errorf("environment keys are stored in GitHub Environments and need a GitHub remote")
+	}
+	if InGitHubActions() {
+		return nil, actionsKeyMissing(km.environment)
+	}
+
+	session := "environment " + km.environment
+	if key, err := loadSessionKey(session); err == nil {
+		km.source = "session agent"
+		return SecureBytesFrom(key), nil
+	}
+	name := session
+	if create {
+		name += "+create"
+	}
+	load := func() ([]byte, error) {
+		if key, err := LoadEnvironmentKey(km.environment); err == nil {
+			return key, nil
+		}
+		return loadSessionKey(session)
+	}
+	km.source = "shared retrieval"
+	key, err := singleFlight(name, load, func() ([]byte, error) {
+		if key, err := loadCachedKey(session); err == nil {
+			km.source = "key cache"
+			cacheInSession(session, key)
+			return key, nil
+		}
+		fmt.Fprintf(os.Stderr, "Retrieving the %s environment key via GitHub workflow...\n", km.environment)
+		key, err := github.GetEnvironmentKey(ctx, km.environment)
+		if errors.Is(err, github.ErrSecretMissing) && create {
+			fmt.Fprintf(os.Stderr, "The %s environment has no key yet. Creating new key...\n", km.environment)
+			if key, err = GenerateEncryptionKey(); err != nil {
+				return nil, fmt.Errorf("failed to generate encryption key: %w", err)
+			}
+			if err := github.StoreEnvironmentKey(ctx, km.environment, key); err != nil {
+				Wipe(key)
+				return nil, fmt.Errorf("failed to store encryption key: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "✓ New encryption key created and stored in the %s environment\n", km.environment)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to retrieve the %s environment key: %w", km.environment, err)
+		}
+		km.source = "workflow"
+		cacheOnDisk(session, key)
+		cacheInSession(session, key)
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return SecureBytesFrom(key), nil
+}
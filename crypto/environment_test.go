@@ -0,0 +1,70 @@
+package crypto
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateEnvironment(t *testing.T) {
+	tests := []struct {
+		name      string
+		expectErr bool
+	}{
+		{name: "production"},
+		{name: "staging-eu"},
+		{name: "dev_1.2"},
+		{name: "", expectErr: true},
+		{name: "../key", expectErr: true},
+		{name: "a/b", expectErr: true},
+		{name: "-flag", expectErr: true},
+		{name: "with space", expectErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateEnvironment(tt.name)
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestFileEnvironments(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, exec.Command("git", "init", "-q", dir).Run())
+	original, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(original) })
+
+	attributes := ".env filter=ezenv\n" +
+		".env.production filter=ezenv " + EnvironmentAttribute + "=production\n" +
+		"deploy/** filter=ezenv " + EnvironmentAttribute + "=staging\n"
+	require.NoError(t, os.WriteFile(".gitattributes", []byte(attributes), 0644))
+
+	environments, err := FileEnvironments([]string{".env", ".env.production", "deploy/secrets.json"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{".env.production": "production", "deploy/secrets.json": "staging"}, environments)
+
+	environment, err := FileEnvironment(".env")
+	require.NoError(t, err)
+	assert.Empty(t, environment)
+
+	// Environment keys are kept apart from the repository key
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	require.NoError(t, SaveEnvironmentKey("production", key))
+	loaded, err := LoadEnvironmentKey("production")
+	require.NoError(t, err)
+	assert.Equal(t, key, loaded)
+	_, err = LoadLocalKey()
+	assert.Error(t, err)
+	_, err = EnvironmentKeyPath("../key")
+	assert.Error(t, err)
+}
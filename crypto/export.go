@@ -0,0 +1,101 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/oliviaBahr/ez-env/canonical"
+)
+
+const (
+	// Argon2id parameters used to derive key-export encryption keys
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // 64 MiB
+	argon2Threads = 4
+	saltSize      = 16
+)
+
+// exportedKey is the on-disk format of a passphrase-protected key export
+type exportedKey struct {
+	Version   int    `json:"version"`
+	KDF       string `json:"kdf"`
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory"`
+	Threads   uint8  `json:"threads"`
+	Salt      string `json:"salt"`
+	Encrypted string `json:"encrypted_key"`
+}
+
+// ExportKey encrypts the repository key with a key derived from passphrase using Argon2id
+func ExportKey(key []byte, passphrase []byte) ([]byte, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid key size: expected %d, got %d", keySize, len(key))
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase must not be empty")
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	wrappingKey := argon2.IDKey(passphrase, salt, argon2Time, argon2Memory, argon2Threads, keySize)
+	encrypted, err := EncryptFile(key, wrappingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := canonical.Marshal(exportedKey{
+		Version:   1,
+		KDF:       "argon2id",
+		Time:      argon2Time,
+		Memory:    argon2Memory,
+		Threads:   argon2Threads,
+		Salt:      base64.StdEncoding.EncodeToString(salt),
+		Encrypted: base64.StdEncoding.EncodeToString(encrypted),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode exported key: %w", err)
+	}
+	return data, nil
+}
+
+// ImportKey decrypts a key previously produced by ExportKey
+func ImportKey(data []byte, passphrase []byte) ([]byte, error) {
+	var exported exportedKey
+	if err := json.Unmarshal(data, &exported); err != nil {
+		return nil, fmt.Errorf("failed to parse exported key: %w", err)
+	}
+	if exported.Version != 1 || exported.KDF != "argon2id" {
+		return nil, fmt.Errorf("unsupported key export format: version %d, kdf %q", exported.Version, exported.KDF)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(exported.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode salt: %w", err)
+	}
+	encrypted, err := base64.StdEncoding.DecodeString(exported.Encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encrypted key: %w", err)
+	}
+
+	params := KDFParams{Time: exported.Time, Memory: exported.Memory, Threads: exported.Threads, Salt: salt}
+	if err := params.validate(); err != nil {
+		return nil, fmt.Errorf("invalid key export: %w", err)
+	}
+	wrappingKey := params.DeriveKey(passphrase)
+	key, err := DecryptFile(encrypted, wrappingKey)
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or corrupted key export")
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid key size: expected %d, got %d", keySize, len(key))
+	}
+	return key, nil
+}
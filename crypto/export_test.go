@@ -0,0 +1,71 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImportKey(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	exported, err := ExportKey(key, []byte("correct horse battery staple"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(exported), string(key))
+
+	tests := []struct {
+		name       string
+		data       []byte
+		passphrase string
+		expectErr  string
+	}{
+		{
+			name:       "imports with correct passphrase",
+			data:       exported,
+			passphrase: "correct horse battery staple",
+		},
+		{
+			name:       "fails with wrong passphrase",
+			data:       exported,
+			passphrase: "wrong",
+			expectErr:  "wrong passphrase",
+		},
+		{
+			name:       "fails with invalid data",
+			data:       []byte("not json"),
+			passphrase: "correct horse battery staple",
+			expectErr:  "failed to parse exported key",
+		},
+		{
+			name:       "fails with unsupported format",
+			data:       []byte(`{"version": 2, "kdf": "argon2id"}`),
+			passphrase: "correct horse battery staple",
+			expectErr:  "unsupported key export format",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imported, err := ImportKey(tt.data, []byte(tt.passphrase))
+			if tt.expectErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, key, imported)
+		})
+	}
+}
+
+func TestExportKeyValidation(t *testing.T) {
+	_, err := ExportKey([]byte("short"), []byte("passphrase"))
+	assert.ErrorContains(t, err, "invalid key size")
+
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	_, err = ExportKey(key, nil)
+	assert.ErrorContains(t, err, "passphrase must not be empty")
+}
@@ -0,0 +1,156 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Every envelope encrypts its content with its own random file key, and only that file key is
+// encrypted with the repository key (the key encryption key). A leaked file key exposes a single
+// version of a single file, and a new repository key can be adopted by rewrapping the file keys
+// (RewrapFile) without touching the encrypted content.
+//
+// Files encrypted with a path (EncryptOptions.Path) have their file key wrapped with a subkey derived
+// from the repository key and the path instead, so leaking that subkey exposes only files at that path.
+//
+// The content is authenticated with the header minus the file key and key id fields, so both can
+// be replaced by RewrapFile. The wrapped file key is authenticated with the rest of the header.
+
+const (
+	wrappedFileKeySize = nonceSize + keySize + tagSize
+
+	fileKeyInfo = "ez-env deterministic file key v1"
+	// pathKeyContext is the DerivePathKey context of the subkeys that wrap path-bound file keys
+	pathKeyContext = "file key"
+)
+
+// ErrNoFileKey is returned by RewrapFile for files encrypted directly with the repository key
+var ErrNoFileKey = errors.New("file has no per-file key")
+
+// contentFields returns the header fields the content is authenticated with
+func contentFields(fields map[byte][]byte) map[byte][]byte {
+	content := make(map[byte][]byte, len(fields))
+	for fieldType, value := range fields {
+		if fieldType != fieldFileKey && fieldType != fieldKeyID {
+			content[fieldType] = value
+		}
+	}
+	return content
+}
+
+// wrapFields returns the header fields the wrapped file key is authenticated with
+func wrapFields(fields map[byte][]byte) map[byte][]byte {
+	wrap := make(map[byte][]byte, len(fields))
+	for fieldType, value := range fields {
+		if fieldType != fieldFileKey {
+			wrap[fieldType] = value
+		}
+	}
+	return wrap
+}
+
+// newFileKey returns a random file key, or in deterministic mode one derived from the repository
+// key and the content
+func newFileKey(key, contentAAD, content []byte, deterministic bool) ([]byte, error) {
+	fileKey := make([]byte, keySize)
+	if !deterministic {
+		if _, err := io.ReadFull(rand.Reader, fileKey); err != nil {
+			return nil, fmt.Errorf("failed to generate file key: %w", err)
+		}
+		return fileKey, nil
+	}
+
+	derivationKey := make([]byte, keySize)
+	defer Wipe(derivationKey)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(fileKeyInfo)), derivationKey); err != nil {
+		return nil, fmt.Errorf("failed to derive file key: %w", err)
+	}
+	mac := hmac.New(sha256.New, derivationKey)
+	mac.Write(contentAAD)
+	mac.Write(content)
+	return mac.Sum(nil), nil
+}
+
+// wrappingKey returns the key that wraps the file key: the repository key, or for files bound to
+// a path the subkey derived for that path. The result is always a copy the caller wipes
+func wrappingKey(key []byte, fields map[byte][]byte) ([]byte, error) {
+	if path, ok := fields[fieldPath]; ok {
+		return DerivePathKey(key, string(path), pathKeyContext)
+	}
+	return bytes.Clone(key), nil
+}
+
+// wrapFileKey encrypts fileKey with the repository key, binding it to the other header fields
+// The nonce is synthetic: distinct file keys never share one, and equal inputs give equal output
+func wrapFileKey(fileKey, key []byte, fields map[byte][]byte) ([]byte, error) {
+	kek, err := wrappingKey(key, fields)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(kek)
+	aad := encodeHeader(wrapFields(fields))
+	nonce, err := syntheticNonce(kek, aad, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, fileKey, aad), nil
+}
+
+// unwrapFileKey decrypts the file key of an envelope with the repository key
+func unwrapFileKey(key []byte, fields map[byte][]byte) ([]byte, error) {
+	wrapped := fields[fieldFileKey]
+	kek, err := wrappingKey(key, fields)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(kek)
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := gcm.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], encodeHeader(wrapFields(fields)))
+	if err != nil {
+		return nil, keyMismatchError(key, fields)
+	}
+	return fileKey, nil
+}
+
+// RewrapFile re-encrypts the file key of an envelope from oldKey to newKey, leaving the encrypted
+// content as it is. Files without a per-file key return ErrNoFileKey and must be re-encrypted
+func RewrapFile(encrypted, oldKey, newKey []byte) ([]byte, error) {
+	if len(oldKey) != keySize || len(newKey) != keySize {
+		return nil, fmt.Errorf("invalid key size: expected %d", keySize)
+	}
+	if !isEnvelope(encrypted) {
+		return nil, ErrNoFileKey
+	}
+	header, fields, err := decodeHeader(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := fields[fieldFileKey]; !ok {
+		return nil, ErrNoFileKey
+	}
+
+	fileKey, err := unwrapFileKey(oldKey, fields)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(fileKey)
+	fields[fieldKeyID] = keyIDBytes(newKey)
+	if fields[fieldFileKey], err = wrapFileKey(fileKey, newKey, fields); err != nil {
+		return nil, err
+	}
+	return append(encodeHeader(fields), encrypted[len(header):]...), nil
+}
@@ -0,0 +1,153 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPerFileKeys(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	first, err := EncryptFile([]byte("SECRET=1\n"), key)
+	require.NoError(t, err)
+	second, err := EncryptFile([]byte("SECRET=1\n"), key)
+	require.NoError(t, err)
+
+	_, firstFields, err := decodeHeader(first)
+	require.NoError(t, err)
+	_, secondFields, err := decodeHeader(second)
+	require.NoError(t, err)
+	firstKey, err := unwrapFileKey(key, firstFields)
+	require.NoError(t, err)
+	secondKey, err := unwrapFileKey(key, secondFields)
+	require.NoError(t, err)
+	assert.NotEqual(t, firstKey, secondKey, "every encryption should use its own file key")
+	assert.NotEqual(t, key, firstKey)
+
+	// The file key alone decrypts the content it belongs to
+	header, _, err := decodeHeader(first)
+	require.NoError(t, err)
+	gcm, err := newGCM(firstKey)
+	require.NoError(t, err)
+	body := first[len(header):]
+	plaintext, err := gcm.Open(nil, body[:nonceSize], body[nonceSize:], encodeHeader(contentFields(firstFields)))
+	require.NoError(t, err)
+	assert.Equal(t, "SECRET=1\n", string(plaintext))
+
+	// The wrapped file key is authenticated
+	tampered := append([]byte{}, first...)
+	tampered[len(header)-1] ^= 0x01
+	_, err = DecryptFile(tampered, key)
+	assert.ErrorIs(t, err, ErrAuthentication)
+}
+
+func TestRewrapFile(t *testing.T) {
+	oldKey, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	newKey, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		opts EncryptOptions
+	}{
+		{name: "default", opts: EncryptOptions{}},
+		{name: "padded", opts: EncryptOptions{PaddingBucket: 64}},
+		{name: "deterministic", opts: EncryptOptions{Deterministic: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, err := EncryptFileWithOptions([]byte("SECRET=1\n"), oldKey, tt.opts)
+			require.NoError(t, err)
+
+			rewrapped, err := RewrapFile(encrypted, oldKey, newKey)
+			require.NoError(t, err)
+
+			oldHeader, _, err := decodeHeader(encrypted)
+			require.NoError(t, err)
+			newHeader, fields, err := decodeHeader(rewrapped)
+			require.NoError(t, err)
+			assert.Equal(t, encrypted[len(oldHeader):], rewrapped[len(newHeader):], "the encrypted content should not change")
+			assert.Equal(t, keyIDBytes(newKey), fields[fieldKeyID])
+
+			plaintext, err := DecryptFile(rewrapped, newKey)
+			require.NoError(t, err)
+			assert.Equal(t, "SECRET=1\n", string(plaintext))
+
+			_, err = DecryptFile(rewrapped, oldKey)
+			assert.ErrorIs(t, err, ErrAuthentication)
+			assert.ErrorContains(t, err, "encrypted with key "+KeyID(newKey))
+
+			_, err = RewrapFile(encrypted, newKey, oldKey)
+			assert.ErrorIs(t, err, ErrAuthentication, "rewrapping needs the key the file was encrypted with")
+		})
+	}
+}
+
+func TestRewrapFileWithoutFileKey(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	legacy := sealEnvelope(t, []byte("SECRET=1"), key, map[byte][]byte{fieldCipher: {cipherAES256GCM}})
+	_, err = RewrapFile(legacy, key, key)
+	assert.ErrorIs(t, err, ErrNoFileKey)
+
+	_, err = RewrapFile(encryptVersion1(t, []byte("SECRET=1"), key), key, key)
+	assert.ErrorIs(t, err, ErrNoFileKey)
+}
+
+func TestPathBoundFiles(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	otherKey, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	prod, err := EncryptFileWithOptions([]byte("DB=prod\n"), key, EncryptOptions{Path: "config/prod.env"})
+	require.NoError(t, err)
+
+	path, ok := EnvelopePath(prod)
+	require.True(t, ok)
+	assert.Equal(t, "config/prod.env", path)
+
+	for _, path := range []string{"", "config/prod.env"} {
+		plaintext, err := DecryptFileAt(prod, key, path)
+		require.NoError(t, err)
+		assert.Equal(t, "DB=prod\n", string(plaintext))
+	}
+
+	_, err = DecryptFileAt(prod, key, "config/staging.env")
+	assert.ErrorIs(t, err, ErrPathMismatch)
+
+	// The file key is wrapped with the path subkey, not the repository key
+	_, fields, err := decodeHeader(prod)
+	require.NoError(t, err)
+	subkey, err := DerivePathKey(key, "config/prod.env", pathKeyContext)
+	require.NoError(t, err)
+	gcm, err := newGCM(subkey)
+	require.NoError(t, err)
+	wrapped := fields[fieldFileKey]
+	_, err = gcm.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], encodeHeader(wrapFields(fields)))
+	assert.NoError(t, err)
+	gcm, err = newGCM(key)
+	require.NoError(t, err)
+	_, err = gcm.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], encodeHeader(wrapFields(fields)))
+	assert.Error(t, err, "the repository key alone should not unwrap a bound file key")
+
+	// Editing the recorded path breaks authentication
+	staging, err := EncryptFileWithOptions([]byte("DB=staging\n"), key, EncryptOptions{Path: "config/prod.eno"})
+	require.NoError(t, err)
+	forged := bytes.Replace(staging, []byte("config/prod.eno"), []byte("config/prod.env"), 1)
+	_, err = DecryptFileAt(forged, key, "config/prod.env")
+	assert.ErrorIs(t, err, ErrAuthentication)
+
+	rewrapped, err := RewrapFile(prod, key, otherKey)
+	require.NoError(t, err)
+	plaintext, err := DecryptFileAt(rewrapped, otherKey, "config/prod.env")
+	require.NoError(t, err)
+	assert.Equal(t, "DB=prod\n", string(plaintext))
+}
@@ -0,0 +1,116 @@
+package crypto
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// flight is a key retrieval in progress; goroutines asking for the same key wait for it
+type flight struct {
+	done chan struct{}
+	key  []byte
+	err  error
+	// waiters counts the callers that have not copied the key yet; the last one wipes it
+	waiters int
+}
+
+// flights are the retrievals in progress in this process, by key name
+var flights struct {
+	sync.Mutex
+	byName map[string]*flight
+}
+
+// singleFlight retrieves the key called name once for every concurrent caller
+// Within the process callers share one retrieval; across the processes of a repository, such as
+// the filters git runs during one 'git add', the retrieval holds a lock file, and a process that
+// waited for the lock first tries cached, since the key may have been stored locally meanwhile
+// Each caller gets its own copy of the key
+func singleFlight(name string, cached, retrieve func() ([]byte, error)) ([]byte, error) {
+	flights.Lock()
+	if f, ok := flights.byName[name]; ok {
+		f.waiters++
+		flights.Unlock()
+		<-f.done
+		key := slices.Clone(f.key)
+		f.release()
+		if f.err != nil {
+			return nil, f.err
+		}
+		return key, nil
+	}
+	f := &flight{done: make(chan struct{})}
+	if flights.byName == nil {
+		flights.byName = make(map[string]*flight)
+	}
+	flights.byName[name] = f
+	flights.Unlock()
+
+	key, err := retrieveLocked(cached, retrieve)
+	if err == nil {
+		f.key = slices.Clone(key)
+	}
+	f.err = err
+	flights.Lock()
+	delete(flights.byName, name)
+	f.waiters++
+	flights.Unlock()
+	close(f.done)
+	f.release()
+	return key, err
+}
+
+// release marks a caller done with the shared key and wipes it after the last one
+func (f *flight) release() {
+	flights.Lock()
+	defer flights.Unlock()
+	if f.waiters--; f.waiters == 0 {
+		Wipe(f.key)
+	}
+}
+
+// retrieveLocked runs retrieve while holding the repository's key lock
+func retrieveLocked(cached, retrieve func() ([]byte, error)) ([]byte, error) {
+	unlock, waited, err := lockKeys()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if waited {
+		if key, err := cached(); err == nil {
+			return key, nil
+		}
+	}
+	return retrieve()
+}
+
+// lockKeys takes the lock file of the repository's keys and reports whether it had to wait for it
+func lockKeys() (func(), bool, error) {
+	path, err := LocalKeyPath()
+	if err != nil {
+		return nil, false, err
+	}
+	path = filepath.Join(filepath.Dir(path), "key.lock")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, false, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open key lock: %w", err)
+	}
+	waited := false
+	err = lockFile(file, func() {
+		waited = true
+		fmt.Fprintln(os.Stderr, "Waiting for another ez-env process to retrieve the key...")
+	})
+	if err != nil {
+		file.Close()
+		return nil, false, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return func() {
+		unlockFile(file)
+		file.Close()
+	}, waited, nil
+}
@@ -0,0 +1,82 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSingleFlight(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, exec.Command("git", "init", "-q", dir).Run())
+	original, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(original) })
+
+	key := bytes.Repeat([]byte{9}, keySize)
+	noCache := func() ([]byte, error) { return nil, errors.New("not cached") }
+
+	t.Run("concurrent callers share one retrieval", func(t *testing.T) {
+		var calls atomic.Int32
+		release := make(chan struct{})
+		retrieve := func() ([]byte, error) {
+			calls.Add(1)
+			<-release
+			return bytes.Clone(key), nil
+		}
+
+		var wg sync.WaitGroup
+		results := make([][]byte, 8)
+		for i := range results {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				got, err := singleFlight("repository", noCache, retrieve)
+				assert.NoError(t, err)
+				results[i] = got
+			}()
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		assert.Equal(t, int32(1), calls.Load())
+		for _, got := range results {
+			assert.Equal(t, key, got)
+		}
+		results[0][0] = 0
+		assert.Equal(t, key, results[1], "every caller gets its own copy")
+	})
+
+	t.Run("waiting for the lock reads the cached key", func(t *testing.T) {
+		unlock, waited, err := lockKeys()
+		require.NoError(t, err)
+		assert.False(t, waited)
+
+		done := make(chan []byte)
+		go func() {
+			got, err := singleFlight("repository", func() ([]byte, error) { return bytes.Clone(key), nil }, func() ([]byte, error) {
+				return nil, errors.New("retrieved although another process stored the key")
+			})
+			assert.NoError(t, err)
+			done <- got
+		}()
+		time.Sleep(50 * time.Millisecond)
+		unlock()
+		assert.Equal(t, key, <-done)
+	})
+
+	t.Run("failures reach every caller", func(t *testing.T) {
+		_, err := singleFlight("repository", noCache, func() ([]byte, error) { return nil, errors.New("workflow failed") })
+		assert.EqualError(t, err, "workflow failed")
+	})
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package crypto
+
+import "os"
+
+// lockFile is not supported on this platform; retrievals are still shared within a process
+func lockFile(f *os.File, busy func()) error {
+	return nil
+}
+
+// unlockFile releases a lock taken by lockFile
+func unlockFile(f *os.File) {}
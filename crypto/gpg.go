@@ -0,0 +1,191 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// GPG keyrings wrap the data encryption key to collaborators' OpenPGP public keys with the gpg
+// binary. Wrapping runs gpg in a throwaway home directory so the user's own keyring is never
+// touched; unwrapping runs with the user's home directory so gpg-agent supplies the secret key.
+
+// gpgArmorHeader starts an ASCII-armored OpenPGP public key
+const gpgArmorHeader = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+
+// IsGPGPublicKey reports whether publicKey is an ASCII-armored OpenPGP public key
+func IsGPGPublicKey(publicKey string) bool {
+	return strings.HasPrefix(strings.TrimSpace(publicKey), gpgArmorHeader)
+}
+
+// gpgKeyInfo describes an OpenPGP public key as listed by gpg
+type gpgKeyInfo struct {
+	Fingerprint string
+	CanEncrypt  bool
+}
+
+// runGPG runs gpg with stdin and returns its stdout; homedir overrides the gpg home directory when set
+func runGPG(homedir string, stdin []byte, args ...string) ([]byte, error) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		return nil, fmt.Errorf("gpg is not installed")
+	}
+	if homedir != "" {
+		args = append([]string{"--homedir", homedir}, args...)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("gpg", append([]string{"--batch", "--quiet", "--no-tty"}, args...)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("gpg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// withGPGHome runs fn with a temporary gpg home directory that is removed afterwards
+func withGPGHome(fn func(homedir string) error) error {
+	homedir, err := os.MkdirTemp("", "ezenv-gpg-")
+	if err != nil {
+		return fmt.Errorf("failed to create gpg home directory: %w", err)
+	}
+	defer os.RemoveAll(homedir)
+	return fn(homedir)
+}
+
+// inspectGPGKey returns the fingerprint and encryption capability of an armored public key
+func inspectGPGKey(publicKey string) (gpgKeyInfo, error) {
+	if !IsGPGPublicKey(publicKey) {
+		return gpgKeyInfo{}, fmt.Errorf("not an armored OpenPGP public key")
+	}
+	var info gpgKeyInfo
+	err := withGPGHome(func(homedir string) error {
+		output, err := runGPG(homedir, []byte(publicKey), "--with-colons", "--import-options", "show-only", "--import")
+		if err != nil {
+			return err
+		}
+		info, err = parseGPGKeyListing(output)
+		return err
+	})
+	return info, err
+}
+
+// parseGPGKeyListing parses the colon listing of a single public key
+// The primary key's fingerprint identifies the key; field 12 of the pub record holds the usable
+// capabilities of the whole key, where an upper case E means some subkey can encrypt
+func parseGPGKeyListing(output []byte) (gpgKeyInfo, error) {
+	var info gpgKeyInfo
+	inPrimary := false
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(line, ":")
+		switch fields[0] {
+		case "pub":
+			if info.Fingerprint != "" {
+				return gpgKeyInfo{}, fmt.Errorf("expected a single OpenPGP public key")
+			}
+			inPrimary = true
+			info.CanEncrypt = len(fields) > 11 && strings.Contains(fields[11], "E")
+		case "fpr":
+			if inPrimary && len(fields) > 9 {
+				info.Fingerprint = fields[9]
+			}
+			inPrimary = false
+		}
+	}
+	if info.Fingerprint == "" {
+		return gpgKeyInfo{}, fmt.Errorf("no OpenPGP public key found")
+	}
+	return info, nil
+}
+
+// gpgFingerprint returns the fingerprint of an armored public key as recorded in the keyring
+func gpgFingerprint(publicKey string) (string, error) {
+	info, err := inspectGPGKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	return "OPENPGP:" + info.Fingerprint, nil
+}
+
+// CanWrapToGPG reports whether the data encryption key can be wrapped to an armored public key
+func CanWrapToGPG(publicKey string) bool {
+	info, err := inspectGPGKey(publicKey)
+	return err == nil && info.CanEncrypt
+}
+
+// WrapDEKGPG encrypts the data encryption key to an armored OpenPGP public key
+func WrapDEKGPG(dek []byte, publicKey string) ([]byte, error) {
+	var wrapped []byte
+	err := withGPGHome(func(homedir string) error {
+		keyFile := filepath.Join(homedir, "recipient.asc")
+		if err := os.WriteFile(keyFile, []byte(publicKey), 0600); err != nil {
+			return fmt.Errorf("failed to write recipient key: %w", err)
+		}
+		output, err := runGPG(homedir, dek, "--trust-model", "always", "--recipient-file", keyFile, "--encrypt")
+		wrapped = output
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data encryption key: %w", err)
+	}
+	return wrapped, nil
+}
+
+// UnwrapDEKGPG decrypts a wrapped data encryption key with the user's gpg, which asks gpg-agent
+// for the secret key
+func UnwrapDEKGPG(wrapped []byte) ([]byte, error) {
+	dek, err := runGPG("", wrapped, "--decrypt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data encryption key: %w", err)
+	}
+	if len(dek) != keySize {
+		return nil, fmt.Errorf("invalid data encryption key size: expected %d, got %d", keySize, len(dek))
+	}
+	return dek, nil
+}
+
+// localGPGSecretKeys returns the fingerprints of the primary keys in the user's gpg secret keyring
+func localGPGSecretKeys() (map[string]bool, error) {
+	output, err := runGPG("", nil, "--with-colons", "--list-secret-keys")
+	if err != nil {
+		return nil, err
+	}
+	fingerprints := make(map[string]bool)
+	inPrimary := false
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(line, ":")
+		switch fields[0] {
+		case "sec":
+			inPrimary = true
+		case "fpr":
+			if inPrimary && len(fields) > 9 {
+				fingerprints["OPENPGP:"+fields[9]] = true
+			}
+			inPrimary = false
+		}
+	}
+	return fingerprints, nil
+}
+
+// DecryptDEKGPG unwraps the data encryption key from the first entry whose key is in the local
+// gpg secret keyring
+func (k *Keyring) DecryptDEKGPG() ([]byte, error) {
+	secretKeys, err := localGPGSecretKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range k.Entries {
+		if entry.EncryptedDEK == "" || !secretKeys[entry.Fingerprint] {
+			continue
+		}
+		wrapped, err := decodeWrappedDEK(entry)
+		if err != nil {
+			return nil, err
+		}
+		return UnwrapDEKGPG(wrapped)
+	}
+	return nil, fmt.Errorf("%w matches a local GPG secret key", ErrNoKeyringEntry)
+}
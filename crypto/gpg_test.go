@@ -0,0 +1,101 @@
+package crypto
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gpgTestKey generates an OpenPGP key in a temporary GNUPGHOME and returns its armored public key
+func gpgTestKey(t *testing.T, name string) string {
+	t.Helper()
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	home := t.TempDir()
+	t.Setenv("GNUPGHOME", home)
+	t.Cleanup(func() {
+		exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+	})
+
+	_, err := runGPG("", nil, "--passphrase", "", "--quick-generate-key", name+" <"+name+"@example.com>", "future-default", "default", "never")
+	require.NoError(t, err)
+	armored, err := runGPG("", nil, "--armor", "--export", name+"@example.com")
+	require.NoError(t, err)
+	return string(armored)
+}
+
+func TestGPGKeyring(t *testing.T) {
+	publicKey := gpgTestKey(t, "alice")
+	require.True(t, IsGPGPublicKey(publicKey))
+	require.True(t, CanWrapToGPG(publicKey))
+
+	keyring := NewKeyring()
+	keyring.Backend = BackendGPG
+	added, err := keyring.AddEntry("alice", publicKey)
+	require.NoError(t, err)
+	require.True(t, added)
+	assert.Regexp(t, "^OPENPGP:[0-9A-F]{40}$", keyring.Entries[0].Fingerprint)
+
+	added, err = keyring.AddEntry("alice", publicKey)
+	require.NoError(t, err)
+	assert.False(t, added)
+
+	dek := make([]byte, keySize)
+	dek[0] = 0x42
+	require.NoError(t, keyring.GenerateEncryptedDEKs(dek, keyring.Logins()...))
+
+	unwrapped, err := keyring.DecryptDEKGPG()
+	require.NoError(t, err)
+	assert.Equal(t, dek, unwrapped)
+
+	keyring.Entries[0].Fingerprint = "OPENPGP:0000000000000000000000000000000000000000"
+	_, err = keyring.DecryptDEKGPG()
+	assert.EqualError(t, err, "no keyring entry matches a local GPG secret key")
+}
+
+func TestParseGPGKeyListing(t *testing.T) {
+	tests := []struct {
+		name    string
+		listing string
+		want    gpgKeyInfo
+		wantErr bool
+	}{
+		{
+			name: "encryption subkey",
+			listing: "pub:-:255:22:AAAA:1700000000:::-:::scESC:::::ed25519:::0:\n" +
+				"fpr:::::::::0123456789ABCDEF0123456789ABCDEF01234567:\n" +
+				"sub:-:255:18:BBBB:1700000000::::::e:::::cv25519::\n" +
+				"fpr:::::::::89ABCDEF0123456789ABCDEF0123456789ABCDEF:\n",
+			want: gpgKeyInfo{Fingerprint: "0123456789ABCDEF0123456789ABCDEF01234567", CanEncrypt: true},
+		},
+		{
+			name: "signing only",
+			listing: "pub:-:255:22:AAAA:1700000000:::-:::scSC:::::ed25519:::0:\n" +
+				"fpr:::::::::0123456789ABCDEF0123456789ABCDEF01234567:\n",
+			want: gpgKeyInfo{Fingerprint: "0123456789ABCDEF0123456789ABCDEF01234567"},
+		},
+		{
+			name: "two keys",
+			listing: "pub:-:255:22:AAAA:1700000000:::-:::scESC:::::ed25519:::0:\n" +
+				"fpr:::::::::0123456789ABCDEF0123456789ABCDEF01234567:\n" +
+				"pub:-:255:22:CCCC:1700000000:::-:::scESC:::::ed25519:::0:\n" +
+				"fpr:::::::::FEDCBA9876543210FEDCBA9876543210FEDCBA98:\n",
+			wantErr: true,
+		},
+		{name: "empty", listing: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := parseGPGKeyListing([]byte(tt.listing))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, info)
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/plugin"
+	"golang.org/x/term"
+)
+
+// Hardware tokens are supported through age plugins on age keyrings: the token's recipient
+// ("age1yubikey1...") is added to the keyring like any age recipient, and the identity stub
+// ("AGE-PLUGIN-YUBIKEY-1...") in the local age identity file tells the plugin which token and slot
+// unwraps the key. The private key never leaves the token; the plugin asks for the PIN and touch
+// when the key is unwrapped. Wrapping to a hardware recipient needs the same plugin installed.
+
+// DefaultHardwarePlugin is the age plugin used for hardware keys unless another one is named
+// age-plugin-yubikey covers PIV slots on YubiKeys; FIDO2 tokens work with e.g. age-plugin-fido2-hmac
+const DefaultHardwarePlugin = "yubikey"
+
+// HardwareKey is a key on a hardware token found by DiscoverHardwareKeys
+type HardwareKey struct {
+	// Description identifies the token and slot, as reported by the plugin
+	Description string
+	// Recipient is the age recipient the data encryption key is wrapped to
+	Recipient string
+	// Identity is the identity stub that lets the plugin find the key on the token
+	Identity string
+}
+
+// PluginBinary returns the executable name of an age plugin
+func PluginBinary(name string) string {
+	return "age-plugin-" + name
+}
+
+// isPluginRecipient reports whether recipient is an age plugin recipient ("age1<plugin>1...")
+func isPluginRecipient(recipient string) bool {
+	if !IsAgeRecipient(recipient) {
+		return false
+	}
+	_, err := age.ParseX25519Recipient(recipient)
+	return err != nil
+}
+
+// DiscoverHardwareKeys lists the keys on connected hardware tokens using the named age plugin
+// It runs "age-plugin-<name> --identity", which prints each key's identity stub preceded by comment
+// lines describing the token, slot and recipient
+func DiscoverHardwareKeys(pluginName string) ([]HardwareKey, error) {
+	binary := PluginBinary(pluginName)
+	if _, err := exec.LookPath(binary); err != nil {
+		return nil, fmt.Errorf("%s is not installed", binary)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(binary, "--identity")
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list hardware keys with %s: %w: %s", binary, err, strings.TrimSpace(stderr.String()))
+	}
+	return parsePluginIdentities(output), nil
+}
+
+// parsePluginIdentities parses the identity listing of an age plugin
+func parsePluginIdentities(output []byte) []HardwareKey {
+	var keys []HardwareKey
+	var current HardwareKey
+	var description []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#"):
+			comment := strings.TrimSpace(strings.TrimPrefix(line, "#"))
+			if name, value, ok := strings.Cut(comment, ":"); ok {
+				name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+				switch name {
+				case "Recipient":
+					current.Recipient = value
+				case "Serial", "Name":
+					description = append(description, name+": "+value)
+				}
+			}
+		case strings.HasPrefix(line, "AGE-PLUGIN-"):
+			current.Identity = line
+			current.Description = strings.Join(description, ", ")
+			if current.Recipient != "" {
+				keys = append(keys, current)
+			}
+			current, description = HardwareKey{}, nil
+		}
+	}
+	return keys
+}
+
+// parseAgeIdentityFile parses an age identity file that may contain plugin identity stubs next to
+// native X25519 identities
+func parseAgeIdentityFile(data []byte) ([]age.Identity, error) {
+	var native bytes.Buffer
+	var plugins []age.Identity
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "AGE-PLUGIN-") {
+			native.WriteString(line + "\n")
+			continue
+		}
+		identity, err := plugin.NewIdentity(line, pluginUI())
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse plugin identity: %w", err)
+		}
+		plugins = append(plugins, identity)
+	}
+
+	var identities []age.Identity
+	if strings.TrimSpace(native.String()) != "" || len(plugins) == 0 {
+		parsed, err := age.ParseIdentities(&native)
+		if err != nil {
+			return nil, err
+		}
+		identities = parsed
+	}
+	// Native identities are tried first so a software key avoids a touch prompt
+	return append(identities, plugins...), nil
+}
+
+// pluginUI lets plugins talk to the user on stderr and the terminal, since stdout carries file
+// content when ez-env runs as a git filter
+func pluginUI() *plugin.ClientUI {
+	return &plugin.ClientUI{
+		DisplayMessage: func(name, message string) error {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", PluginBinary(name), message)
+			return nil
+		},
+		RequestValue: func(name, prompt string, secret bool) (string, error) {
+			return terminalInput(fmt.Sprintf("%s: %s ", PluginBinary(name), prompt), secret)
+		},
+		Confirm: func(name, prompt, yes, no string) (bool, error) {
+			choices := yes
+			if no != "" {
+				choices += "/" + no
+			}
+			answer, err := terminalInput(fmt.Sprintf("%s: %s [%s] ", PluginBinary(name), prompt, choices), false)
+			if err != nil {
+				return false, err
+			}
+			return answer == yes, nil
+		},
+		WaitTimer: func(name string) {
+			fmt.Fprintln(os.Stderr, "Touch your security key to decrypt...")
+		},
+	}
+}
+
+// terminalInput reads a line or a secret from the controlling terminal
+func terminalInput(prompt string, secret bool) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("no terminal available to answer the plugin")
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, prompt)
+	if secret {
+		value, err := term.ReadPassword(int(tty.Fd()))
+		fmt.Fprintln(tty)
+		return string(value), err
+	}
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	return strings.TrimSpace(line), err
+}
@@ -0,0 +1,70 @@
+package crypto
+
+import (
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePluginIdentities(t *testing.T) {
+	recipient := plugin.EncodeRecipient("yubikey", []byte("slot 1 public key"))
+	output := "#       Serial: 12345678, Slot: 1\n" +
+		"#         Name: age identity 1a2b3c4d\n" +
+		"#    Recipient: " + recipient + "\n" +
+		"AGE-PLUGIN-YUBIKEY-1EXAMPLE\n" +
+		"\n" +
+		"# Name: orphaned stub without a recipient\n" +
+		"AGE-PLUGIN-YUBIKEY-1ORPHAN\n"
+
+	keys := parsePluginIdentities([]byte(output))
+	require.Len(t, keys, 1)
+	assert.Equal(t, HardwareKey{
+		Description: "Serial: 12345678, Slot: 1, Name: age identity 1a2b3c4d",
+		Recipient:   recipient,
+		Identity:    "AGE-PLUGIN-YUBIKEY-1EXAMPLE",
+	}, keys[0])
+}
+
+func TestIsPluginRecipient(t *testing.T) {
+	native, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		recipient string
+		want      bool
+	}{
+		{name: "plugin recipient", recipient: plugin.EncodeRecipient("yubikey", []byte("public key")), want: true},
+		{name: "native recipient", recipient: native.Recipient().String(), want: false},
+		{name: "ssh key", recipient: "ssh-ed25519 AAAA", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isPluginRecipient(tt.recipient))
+		})
+	}
+}
+
+func TestParseAgeIdentityFile(t *testing.T) {
+	native, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	stub := plugin.EncodeIdentity("yubikey", []byte("slot 1"))
+
+	data := "# created by age-keygen\n" + stub + "\n" + native.String() + "\n"
+	identities, err := parseAgeIdentityFile([]byte(data))
+	require.NoError(t, err)
+	require.Len(t, identities, 2)
+	assert.IsType(t, &age.X25519Identity{}, identities[0])
+	require.IsType(t, &plugin.Identity{}, identities[1])
+	assert.Equal(t, "yubikey", identities[1].(*plugin.Identity).Name())
+
+	identities, err = parseAgeIdentityFile([]byte(stub + "\n"))
+	require.NoError(t, err)
+	assert.Len(t, identities, 1)
+
+	_, err = parseAgeIdentityFile([]byte("AGE-PLUGIN-NOT-BECH32\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,157 @@
+package crypto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/ssh"
+)
+
+// CacheTTL is how long a key retrieved through the workflow is cached in the git directory,
+// encrypted to the user's SSH key, so git keeps working offline; once it expires the workflow
+// checks access again. Zero disables the cache; the cacheTTL setting sets it
+var CacheTTL time.Duration
+
+// keyCacheVersion is the version of the cache file format
+const keyCacheVersion = 1
+
+// ErrKeyCacheExpired is returned for a cached key older than CacheTTL
+var ErrKeyCacheExpired = errors.New("the cached key has expired")
+
+// keyCacheEntry is a cached key wrapped to an SSH public key
+type keyCacheEntry struct {
+	Version int `json:"version"`
+	// Recipient is the SSH public key the key is wrapped to
+	Recipient string    `json:"recipient"`
+	Wrapped   []byte    `json:"wrapped"`
+	CachedAt  time.Time `json:"cachedAt"`
+	// Secret names the secret the key was retrieved from; switching secrets ignores the cache
+	Secret string `json:"secret"`
+}
+
+// keyCacheDir returns the directory of the cached keys inside the git directory
+func keyCacheDir() (string, error) {
+	path, err := LocalKeyPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), "cache"), nil
+}
+
+// keyCachePath returns the cache file of the key called name, such as "environment production"
+func keyCachePath(name string) (string, error) {
+	dir, err := keyCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strings.ReplaceAll(name, " ", "-")+".json"), nil
+}
+
+// loadCachedKey returns the cached key called name, unwrapping it with the user's SSH key
+// An expired entry is removed
+func loadCachedKey(name string) ([]byte, error) {
+	if CacheTTL <= 0 {
+		return nil, fmt.Errorf("the key cache is disabled")
+	}
+	path, err := keyCachePath(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry keyCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Version != keyCacheVersion {
+		return nil, fmt.Errorf("invalid key cache file %s", path)
+	}
+	// The TTL in effect now applies, so shortening it also shortens existing entries
+	if age := time.Since(entry.CachedAt); age < 0 || age > CacheTTL {
+		os.Remove(path)
+		return nil, ErrKeyCacheExpired
+	}
+	if entry.Secret != github.SecretName {
+		return nil, fmt.Errorf("the cached key belongs to the secret %s", entry.Secret)
+	}
+	privateKey, err := ssh.LoadPrivateKeyFor([]string{entry.Recipient})
+	if err != nil {
+		return nil, err
+	}
+	key, err := UnwrapDEK(entry.Wrapped, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap the cached key: %w", err)
+	}
+	return key, nil
+}
+
+// cacheOnDisk caches the key called name wrapped to the user's SSH public key, when the cache is
+// enabled; the key is only cached, so failing to cache it is a warning
+func cacheOnDisk(name string, key []byte) {
+	if CacheTTL <= 0 {
+		return
+	}
+	if err := saveCachedKey(name, key); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: the key could not be cached: %v\n", err)
+	}
+}
+
+// saveCachedKey writes the cache file of the key called name
+func saveCachedKey(name string, key []byte) error {
+	recipient, err := ssh.LocalAuthorizedKey()
+	if err != nil {
+		return err
+	}
+	if !CanWrapTo(recipient) {
+		return fmt.Errorf("the SSH key %s is neither RSA nor ed25519", recipient)
+	}
+	wrapped, err := WrapDEK(key, recipient)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(keyCacheEntry{
+		Version:   keyCacheVersion,
+		Recipient: recipient,
+		Wrapped:   wrapped,
+		CachedAt:  time.Now().UTC(),
+		Secret:    github.SecretName,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	path, err := keyCachePath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create key cache directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write key cache: %w", err)
+	}
+	return nil
+}
+
+// ForgetCachedKeys removes every cached key of the repository and returns how many there were
+func ForgetCachedKeys() (int, error) {
+	dir, err := keyCacheDir()
+	if err != nil {
+		return 0, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read key cache: %w", err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return 0, fmt.Errorf("failed to remove key cache: %w", err)
+	}
+	return len(entries), nil
+}
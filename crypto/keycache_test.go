@@ -0,0 +1,101 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/ssh"
+)
+
+func TestKeyCache(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, exec.Command("git", "init", "-q", dir).Run())
+	chdir(t, dir)
+	privatePEM, _ := generateTestEd25519Key(t)
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(keyPath, privatePEM, 0600))
+	t.Setenv(ssh.PrivateKeyEnv, keyPath)
+
+	originalTTL, originalSecret := CacheTTL, github.SecretName
+	t.Cleanup(func() { CacheTTL, github.SecretName = originalTTL, originalSecret })
+	CacheTTL = time.Hour
+	key := bytes.Repeat([]byte{3}, keySize)
+
+	t.Run("cached key round trips", func(t *testing.T) {
+		require.NoError(t, saveCachedKey("environment production", key))
+		cached, err := loadCachedKey("environment production")
+		require.NoError(t, err)
+		assert.Equal(t, key, cached)
+
+		path, err := keyCachePath("environment production")
+		require.NoError(t, err)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), string(key), "the key is stored wrapped")
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		_, err := loadCachedKey("repository")
+		assert.Error(t, err)
+	})
+
+	t.Run("disabled cache is not read", func(t *testing.T) {
+		require.NoError(t, saveCachedKey("repository", key))
+		CacheTTL = 0
+		defer func() { CacheTTL = time.Hour }()
+		_, err := loadCachedKey("repository")
+		assert.Error(t, err)
+	})
+
+	t.Run("another secret's key is ignored", func(t *testing.T) {
+		require.NoError(t, saveCachedKey("repository", key))
+		github.SecretName = "OTHER_KEY"
+		defer func() { github.SecretName = originalSecret }()
+		_, err := loadCachedKey("repository")
+		assert.ErrorContains(t, err, originalSecret)
+	})
+
+	t.Run("expired key is removed", func(t *testing.T) {
+		require.NoError(t, saveCachedKey("repository", key))
+		path, err := keyCachePath("repository")
+		require.NoError(t, err)
+		var entry keyCacheEntry
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &entry))
+		entry.CachedAt = time.Now().Add(-2 * time.Hour)
+		data, err = json.Marshal(entry)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, data, 0600))
+
+		_, err = loadCachedKey("repository")
+		assert.ErrorIs(t, err, ErrKeyCacheExpired)
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("forget removes every cached key", func(t *testing.T) {
+		require.NoError(t, saveCachedKey("repository", key))
+		removed, err := ForgetCachedKeys()
+		require.NoError(t, err)
+		assert.Equal(t, 2, removed)
+		_, err = loadCachedKey("environment production")
+		assert.Error(t, err)
+
+		removed, err = ForgetCachedKeys()
+		require.NoError(t, err)
+		assert.Equal(t, 0, removed)
+	})
+}
@@ -0,0 +1,148 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/hosting"
+)
+
+// UseKeychain caches a key fetched through the GitHub workflow in the macOS Keychain, so the
+// filters read it locally instead of running the workflow again; the keychain setting turns it off
+// and a nonzero CacheTTL expires it like the key cache
+var UseKeychain = true
+
+// ErrKeychainUnavailable is returned when there is no Keychain to use or its use is disabled
+var ErrKeychainUnavailable = errors.New("keychain is not available")
+
+// keychainNotFound is the exit status of the security tool when no matching item exists
+const keychainNotFound = 44
+
+// keychainItem returns the service and account of the current repository's Keychain item
+// The service names the repository so every clone of it shares one item, and the account names
+// the secret since a repository can switch secrets
+func keychainItem() (string, string, error) {
+	if !UseKeychain || runtime.GOOS != "darwin" {
+		return "", "", ErrKeychainUnavailable
+	}
+	if _, err := exec.LookPath("security"); err != nil {
+		return "", "", ErrKeychainUnavailable
+	}
+	owner, repo, err := hosting.GetRepositoryInfo()
+	if err != nil {
+		return "", "", err
+	}
+	return "ez-env " + owner + "/" + repo, github.SecretName, nil
+}
+
+// keychainFormat prefixes the value of Keychain items that record the key id and caching time
+const keychainFormat = "ezenv1"
+
+// encodeKeychainValue returns the Keychain value of key: the key id and caching time, which
+// LoadKeychainKey checks, followed by the key in hex
+func encodeKeychainValue(key []byte, now time.Time) string {
+	return strings.Join([]string{keychainFormat, KeyID(key), strconv.FormatInt(now.Unix(), 10), hex.EncodeToString(key)}, ":")
+}
+
+// decodeKeychainValue returns the key of a Keychain value, failing for a value that does not
+// carry the id of its key or is older than CacheTTL
+func decodeKeychainValue(value string, now time.Time) ([]byte, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 4 || parts[0] != keychainFormat {
+		return nil, fmt.Errorf("the keychain item does not record its key id")
+	}
+	cachedAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid caching time in the keychain item")
+	}
+	// The keychain must not outlive the access check the cacheTTL setting asks for
+	if age := now.Sub(time.Unix(cachedAt, 0)); CacheTTL > 0 && (age < 0 || age > CacheTTL) {
+		return nil, ErrKeyCacheExpired
+	}
+	key, err := hex.DecodeString(parts[3])
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("invalid key in the keychain item")
+	}
+	if KeyID(key) != parts[1] {
+		Wipe(key)
+		return nil, fmt.Errorf("the key in the keychain item is not key %s", parts[1])
+	}
+	return key, nil
+}
+
+// LoadKeychainKey reads the current repository's key from the Keychain
+// An item that is expired, predates key ids or whose key does not match its id is removed, so
+// the key is retrieved again
+func LoadKeychainKey() ([]byte, error) {
+	service, account, err := keychainItem()
+	if err != nil {
+		return nil, err
+	}
+	output, err := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key from keychain: %w", err)
+	}
+	key, err := decodeKeychainValue(string(output), time.Now())
+	if err != nil {
+		DeleteKeychainKey()
+		return nil, fmt.Errorf("keychain item %q: %w", service, err)
+	}
+	return key, nil
+}
+
+// SaveKeychainKey stores the current repository's key in the Keychain, replacing any earlier one
+// The command is passed on stdin so the key never shows up in the process list
+func SaveKeychainKey(key []byte) error {
+	if len(key) != keySize {
+		return fmt.Errorf("invalid key size: expected %d, got %d", keySize, len(key))
+	}
+	service, account, err := keychainItem()
+	if err != nil {
+		return err
+	}
+	command := fmt.Sprintf("add-generic-password -U -s %q -a %q -l %q -w %s\n", service, account, service, encodeKeychainValue(key, time.Now()))
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(command)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to store key in keychain: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// DeleteKeychainKey removes the current repository's key from the Keychain
+// Returns false if there was no key to remove
+func DeleteKeychainKey() (bool, error) {
+	service, account, err := keychainItem()
+	if err != nil {
+		return false, err
+	}
+	err = exec.Command("security", "delete-generic-password", "-s", service, "-a", account).Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == keychainNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to delete key from keychain: %w", err)
+	}
+	return true, nil
+}
+
+// cacheInKeychain stores a key fetched from GitHub in the Keychain, warning if that fails
+func cacheInKeychain(key []byte) {
+	err := SaveKeychainKey(key)
+	if err != nil && !errors.Is(err, ErrKeychainUnavailable) {
+		fmt.Fprintf(os.Stderr, "Warning: the key could not be cached in the keychain: %v\n", err)
+	}
+}
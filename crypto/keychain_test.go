@@ -0,0 +1,38 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeychainValue(t *testing.T) {
+	original := CacheTTL
+	t.Cleanup(func() { CacheTTL = original })
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	otherKey, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	value := encodeKeychainValue(key, now)
+
+	CacheTTL = 0
+	decoded, err := decodeKeychainValue(value+"\n", now.Add(365*24*time.Hour))
+	require.NoError(t, err, "without a cache TTL the keychain does not expire")
+	assert.Equal(t, key, decoded)
+
+	CacheTTL = time.Hour
+	_, err = decodeKeychainValue(value, now.Add(30*time.Minute))
+	require.NoError(t, err)
+	_, err = decodeKeychainValue(value, now.Add(2*time.Hour))
+	assert.ErrorIs(t, err, ErrKeyCacheExpired)
+
+	_, err = decodeKeychainValue(hex.EncodeToString(key), now)
+	assert.ErrorContains(t, err, "does not record its key id")
+	swapped := keychainFormat + ":" + KeyID(key) + ":1700000000:" + hex.EncodeToString(otherKey)
+	_, err = decodeKeychainValue(swapped, now)
+	assert.ErrorContains(t, err, "is not key "+KeyID(key))
+}
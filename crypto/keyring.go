@@ -0,0 +1,429 @@
+package crypto
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/canonical"
+	"github.com/oliviaBahr/ez-env/ssh"
+)
+
+const (
+	// KeyringFile is the committed file holding the data encryption key wrapped to each collaborator
+	KeyringFile = ".gitenv_keyring"
+
+	// ModeSharedKey distributes the repository key through GitHub secrets and workflows
+	ModeSharedKey = "shared-key"
+	// ModeKeyring wraps the repository key (DEK) to each collaborator's SSH key in KeyringFile
+	ModeKeyring = "keyring"
+)
+
+// ConfiguredMode is the key management mode recorded in the repository configuration; when it is
+// empty the mode is detected from the committed key files
+var ConfiguredMode = ""
+
+// KeyringEntry is a single collaborator key that can unwrap the data encryption key
+type KeyringEntry struct {
+	Login        string `json:"login"`
+	PublicKey    string `json:"public_key"`
+	Fingerprint  string `json:"fingerprint"`
+	EncryptedDEK string `json:"encrypted_dek,omitempty"`
+	// GrantedAt is when the login was last granted access; keyrings written before it was recorded lack it
+	GrantedAt *time.Time `json:"granted_at,omitempty"`
+}
+
+// Tombstone records a removed collaborator so access can be restored without re-fetching keys
+type Tombstone struct {
+	Login     string         `json:"login"`
+	Entries   []KeyringEntry `json:"entries"`
+	RemovedAt time.Time      `json:"removed_at"`
+	RemovedBy string         `json:"removed_by,omitempty"`
+	Rotated   bool           `json:"rotated"`
+}
+
+// Keyring is the set of collaborators that can decrypt the repository
+type Keyring struct {
+	Version int `json:"version"`
+	// Backend is how the data encryption key is wrapped; keyrings written before it was recorded use BackendSSH
+	Backend    string         `json:"backend,omitempty"`
+	Entries    []KeyringEntry `json:"entries"`
+	Tombstones []Tombstone    `json:"tombstones,omitempty"`
+	// Signature is set when a granted entry signed the keyring (see keyring_sign.go)
+	Signature *KeyringSignature `json:"signature,omitempty"`
+}
+
+// CurrentMode returns the key management mode of the repository in the current directory, as
+// configured or detected from the committed key files
+func CurrentMode() string {
+	if ConfiguredMode != "" {
+		return ConfiguredMode
+	}
+	return DetectedMode()
+}
+
+// DetectedMode returns the key management mode the committed key files of the repository imply
+func DetectedMode() string {
+	if _, err := os.Stat(RepoFile(KeyringFile)); err == nil {
+		return ModeKeyring
+	}
+	if _, err := os.Stat(RepoFile(PassphraseFile)); err == nil {
+		return ModePassphrase
+	}
+	return ModeSharedKey
+}
+
+// NewKeyring creates an empty keyring
+func NewKeyring() *Keyring {
+	return &Keyring{Version: 1}
+}
+
+// ValidateBackend fails unless backend is a supported keyring backend
+func ValidateBackend(backend string) error {
+	for _, supported := range Backends {
+		if backend == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported keyring backend %q (expected %s, %s or %s)", backend, BackendSSH, BackendAge, BackendGPG)
+}
+
+// WrapBackend returns how the keyring wraps the data encryption key
+func (k *Keyring) WrapBackend() string {
+	if k.Backend == "" {
+		return BackendSSH
+	}
+	return k.Backend
+}
+
+// CanWrapTo reports whether the keyring's backend can wrap the data encryption key to publicKey
+func (k *Keyring) CanWrapTo(publicKey string) bool {
+	switch k.WrapBackend() {
+	case BackendAge:
+		_, err := parseAgeRecipient(publicKey)
+		return err == nil
+	case BackendGPG:
+		return CanWrapToGPG(publicKey)
+	}
+	return CanWrapTo(publicKey)
+}
+
+// wrapDEK wraps the data encryption key to publicKey with the keyring's backend
+func (k *Keyring) wrapDEK(dek []byte, publicKey string) ([]byte, error) {
+	switch k.WrapBackend() {
+	case BackendAge:
+		return WrapDEKAge(dek, publicKey)
+	case BackendGPG:
+		return WrapDEKGPG(dek, publicKey)
+	}
+	return WrapDEK(dek, publicKey)
+}
+
+// entryFingerprint identifies a keyring key: age recipients are short enough to identify themselves
+// and OpenPGP keys use their primary key fingerprint
+func entryFingerprint(publicKey string) (string, error) {
+	if IsGPGPublicKey(publicKey) {
+		return gpgFingerprint(publicKey)
+	}
+	if IsAgeRecipient(publicKey) {
+		if _, err := parseAgeRecipient(publicKey); err != nil {
+			return "", err
+		}
+		return publicKey, nil
+	}
+	return ssh.Fingerprint(publicKey)
+}
+
+// decodeWrappedDEK decodes the base64 wrapped key of an entry
+func decodeWrappedDEK(entry KeyringEntry) ([]byte, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(entry.EncryptedDEK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode wrapped key for %s: %w", entry.Login, err)
+	}
+	return wrapped, nil
+}
+
+// LoadKeyring reads a keyring from disk
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+
+	var keyring Keyring
+	if err := json.Unmarshal(data, &keyring); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring: %w", err)
+	}
+	if keyring.Version != 1 {
+		return nil, fmt.Errorf("unsupported keyring version: %d", keyring.Version)
+	}
+	if err := ValidateBackend(keyring.WrapBackend()); err != nil {
+		return nil, err
+	}
+
+	return &keyring, nil
+}
+
+// Save writes the keyring to disk as canonical JSON
+func (k *Keyring) Save(path string) error {
+	if err := canonical.WriteFile(path, k, 0644); err != nil {
+		return fmt.Errorf("failed to save keyring: %w", err)
+	}
+	return nil
+}
+
+// AddEntry adds a collaborator public key to the keyring
+// Returns false if the key is already present
+func (k *Keyring) AddEntry(login, publicKey string) (bool, error) {
+	fingerprint, err := entryFingerprint(publicKey)
+	if err != nil {
+		return false, err
+	}
+
+	for _, entry := range k.Entries {
+		if entry.Fingerprint == fingerprint {
+			return false, nil
+		}
+	}
+
+	k.Entries = append(k.Entries, KeyringEntry{
+		Login:       login,
+		PublicKey:   publicKey,
+		Fingerprint: fingerprint,
+	})
+	// Entries are kept sorted so the saved keyring does not depend on the order keys were added
+	sort.SliceStable(k.Entries, func(i, j int) bool {
+		if k.Entries[i].Login != k.Entries[j].Login {
+			return k.Entries[i].Login < k.Entries[j].Login
+		}
+		return k.Entries[i].Fingerprint < k.Entries[j].Fingerprint
+	})
+	return true, nil
+}
+
+// PendingEntries returns how many keys of login have no wrapped key yet, e.g. keys registered
+// by keygen that still need a collaborator with access to wrap the key to them
+func (k *Keyring) PendingEntries(login string) int {
+	pending := 0
+	for _, entry := range k.Entries {
+		if entry.Login == login && entry.EncryptedDEK == "" {
+			pending++
+		}
+	}
+	return pending
+}
+
+// MarkGranted records that every key of login was granted access at the given time
+func (k *Keyring) MarkGranted(login string, at time.Time) {
+	at = at.UTC().Truncate(time.Second)
+	for i := range k.Entries {
+		if k.Entries[i].Login == login {
+			k.Entries[i].GrantedAt = &at
+		}
+	}
+}
+
+// RemoveLogin removes every key belonging to login and returns how many were removed
+func (k *Keyring) RemoveLogin(login string) int {
+	entries := k.Entries[:0]
+	removed := 0
+	for _, entry := range k.Entries {
+		if entry.Login == login {
+			removed++
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	k.Entries = entries
+	return removed
+}
+
+// SoftDelete removes every key belonging to login and keeps them in a tombstone
+// An existing tombstone for login is replaced; the number of removed keys is returned
+func (k *Keyring) SoftDelete(login, removedBy string, removedAt time.Time) int {
+	var removed []KeyringEntry
+	for _, entry := range k.Entries {
+		if entry.Login == login {
+			entry.EncryptedDEK = ""
+			removed = append(removed, entry)
+		}
+	}
+	if len(removed) == 0 {
+		return 0
+	}
+	k.RemoveLogin(login)
+
+	k.RemoveTombstone(login)
+	k.Tombstones = append(k.Tombstones, Tombstone{
+		Login:     login,
+		Entries:   removed,
+		RemovedAt: removedAt.UTC().Truncate(time.Second),
+		RemovedBy: removedBy,
+	})
+	sort.SliceStable(k.Tombstones, func(i, j int) bool { return k.Tombstones[i].Login < k.Tombstones[j].Login })
+	return len(removed)
+}
+
+// FindTombstone returns the tombstone of a removed collaborator
+func (k *Keyring) FindTombstone(login string) (*Tombstone, bool) {
+	for i := range k.Tombstones {
+		if k.Tombstones[i].Login == login {
+			return &k.Tombstones[i], true
+		}
+	}
+	return nil, false
+}
+
+// Restore re-adds the keys of a removed collaborator if the removal is within the grace period
+// The restored entries still need the data encryption key wrapped to them
+func (k *Keyring) Restore(login string, now time.Time, gracePeriod time.Duration) (int, error) {
+	tombstone, ok := k.FindTombstone(login)
+	if !ok {
+		return 0, fmt.Errorf("%s was not removed from the keyring", login)
+	}
+	if now.Sub(tombstone.RemovedAt) > gracePeriod {
+		return 0, fmt.Errorf("%s was removed on %s, outside the %s grace period", login, tombstone.RemovedAt.Format(time.RFC3339), gracePeriod)
+	}
+
+	restored := 0
+	for _, entry := range tombstone.Entries {
+		added, err := k.AddEntry(entry.Login, entry.PublicKey)
+		if err != nil {
+			return restored, err
+		}
+		if added {
+			restored++
+		}
+	}
+	k.MarkGranted(login, now)
+	k.RemoveTombstone(login)
+	return restored, nil
+}
+
+// RemoveTombstone deletes the tombstone of login if there is one
+func (k *Keyring) RemoveTombstone(login string) {
+	tombstones := k.Tombstones[:0]
+	for _, tombstone := range k.Tombstones {
+		if tombstone.Login != login {
+			tombstones = append(tombstones, tombstone)
+		}
+	}
+	k.Tombstones = tombstones
+}
+
+// Logins returns the distinct collaborator logins in the keyring
+func (k *Keyring) Logins() []string {
+	var logins []string
+	seen := make(map[string]bool)
+	for _, entry := range k.Entries {
+		if !seen[entry.Login] {
+			seen[entry.Login] = true
+			logins = append(logins, entry.Login)
+		}
+	}
+	return logins
+}
+
+// GrantedPublicKeys returns the public keys of the entries that have a wrapped key
+func (k *Keyring) GrantedPublicKeys() []string {
+	var keys []string
+	for _, entry := range k.Entries {
+		if entry.EncryptedDEK != "" {
+			keys = append(keys, entry.PublicKey)
+		}
+	}
+	return keys
+}
+
+// LoadPrivateKey loads the local SSH private key that belongs to one of the granted entries,
+// so a dedicated key generated by keygen does not hide a personal key that has access
+func (k *Keyring) LoadPrivateKey() (crypto.PrivateKey, error) {
+	privateKey, err := ssh.LoadPrivateKeyFor(k.GrantedPublicKeys())
+	if errors.Is(err, ssh.ErrNoPrivateKey) {
+		return nil, fmt.Errorf("%w for the local SSH keys: %v", ErrNoKeyringEntry, err)
+	}
+	return privateKey, err
+}
+
+// GenerateEncryptedDEKs wraps the data encryption key with the keyring's backend to every entry
+// that already has access and to every key of the approved logins
+// Pending entries of other logins stay pending: anyone can add one to the committed file, so only
+// an explicit grant may turn it into access
+func (k *Keyring) GenerateEncryptedDEKs(dek []byte, approved ...string) error {
+	if len(dek) != keySize {
+		return fmt.Errorf("invalid key size: expected %d, got %d", keySize, len(dek))
+	}
+
+	for i := range k.Entries {
+		if k.Entries[i].EncryptedDEK == "" && !slices.Contains(approved, k.Entries[i].Login) {
+			continue
+		}
+		wrapped, err := k.wrapDEK(dek, k.Entries[i].PublicKey)
+		if err != nil {
+			return fmt.Errorf("failed to wrap key for %s (%s): %w", k.Entries[i].Login, k.Entries[i].Fingerprint, err)
+		}
+		k.Entries[i].EncryptedDEK = base64.StdEncoding.EncodeToString(wrapped)
+	}
+	return nil
+}
+
+// WrapPendingDEKs wraps the data encryption key only to the pending entries of the approved logins
+// Existing entries keep their wrapped key so the saved keyring only changes where access changed
+func (k *Keyring) WrapPendingDEKs(dek []byte, approved ...string) (int, error) {
+	if len(dek) != keySize {
+		return 0, fmt.Errorf("invalid key size: expected %d, got %d", keySize, len(dek))
+	}
+
+	wrappedCount := 0
+	for i := range k.Entries {
+		if k.Entries[i].EncryptedDEK != "" || !slices.Contains(approved, k.Entries[i].Login) {
+			continue
+		}
+		wrapped, err := k.wrapDEK(dek, k.Entries[i].PublicKey)
+		if err != nil {
+			return wrappedCount, fmt.Errorf("failed to wrap key for %s (%s): %w", k.Entries[i].Login, k.Entries[i].Fingerprint, err)
+		}
+		k.Entries[i].EncryptedDEK = base64.StdEncoding.EncodeToString(wrapped)
+		wrappedCount++
+	}
+	return wrappedCount, nil
+}
+
+// ErrNoKeyringEntry is returned when none of the local keys has been granted access in the keyring
+var ErrNoKeyringEntry = errors.New("no keyring entry")
+
+// DecryptDEK unwraps the data encryption key using the entry matching privateKey (RSA or ed25519)
+func (k *Keyring) DecryptDEK(privateKey crypto.PrivateKey) ([]byte, error) {
+	publicKey, err := ssh.PublicKeyOf(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	authorizedKey, err := ssh.AuthorizedKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := ssh.Fingerprint(authorizedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range k.Entries {
+		if entry.Fingerprint != fingerprint || entry.EncryptedDEK == "" {
+			continue
+		}
+
+		wrapped, err := decodeWrappedDEK(entry)
+		if err != nil {
+			return nil, err
+		}
+		return UnwrapDEK(wrapped, privateKey)
+	}
+
+	return nil, fmt.Errorf("%w for SSH key %s", ErrNoKeyringEntry, fingerprint)
+}
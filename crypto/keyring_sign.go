@@ -0,0 +1,201 @@
+package crypto
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/oliviaBahr/ez-env/canonical"
+	"github.com/oliviaBahr/ez-env/ssh"
+)
+
+// Keyring signing stops anyone with push access from slipping a rogue key into the keyring.
+// Whoever saves the keyring signs it with the SSH key of a granted entry, and each clone remembers
+// the granted keys of the last keyring it verified. A new keyring is only trusted when it is signed
+// by one of those keys, so every change has to come from someone who already had access. The
+// first signed keyring a clone sees is trusted on first use; once a clone has seen a signed
+// keyring it rejects unsigned ones.
+
+// keyringSignatureNamespace is the SSH signature namespace of keyring signatures
+const keyringSignatureNamespace = "ez-env-keyring@github.com/oliviaBahr/ez-env"
+
+// ErrNotKeyringSigner is returned when the signing key is not a granted entry of the keyring
+var ErrNotKeyringSigner = errors.New("the signing key is not a granted keyring entry")
+
+// KeyringSignature is an SSH signature over the keyring by one of its granted entries
+type KeyringSignature struct {
+	Signer      string `json:"signer"`
+	Fingerprint string `json:"fingerprint"`
+	Signature   string `json:"signature"`
+}
+
+// keyringTrust is the locally remembered set of keys allowed to sign the next keyring
+type keyringTrust struct {
+	Signers []string `json:"signers"`
+}
+
+// signedPayload returns the keyring content covered by the signature: everything except the
+// signature itself and pending entries, which cannot decrypt and are added by keygen without access
+func (k *Keyring) signedPayload() ([]byte, error) {
+	payload := *k
+	payload.Signature = nil
+	payload.Entries = nil
+	for _, entry := range k.Entries {
+		if entry.EncryptedDEK != "" {
+			payload.Entries = append(payload.Entries, entry)
+		}
+	}
+	return canonical.Marshal(&payload)
+}
+
+// grantedEntry returns the entry with a wrapped key matching fingerprint
+func (k *Keyring) grantedEntry(fingerprint string) (*KeyringEntry, bool) {
+	for i := range k.Entries {
+		if k.Entries[i].Fingerprint == fingerprint && k.Entries[i].EncryptedDEK != "" {
+			return &k.Entries[i], true
+		}
+	}
+	return nil, false
+}
+
+// Sign signs the keyring with an SSH private key that belongs to one of its granted entries
+func (k *Keyring) Sign(privateKey crypto.PrivateKey) error {
+	publicKey, err := ssh.PublicKeyOf(privateKey)
+	if err != nil {
+		return err
+	}
+	authorizedKey, err := ssh.AuthorizedKey(publicKey)
+	if err != nil {
+		return err
+	}
+	fingerprint, err := ssh.Fingerprint(authorizedKey)
+	if err != nil {
+		return err
+	}
+	entry, ok := k.grantedEntry(fingerprint)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotKeyringSigner, fingerprint)
+	}
+
+	k.Signature = nil
+	payload, err := k.signedPayload()
+	if err != nil {
+		return err
+	}
+	signature, err := ssh.Sign(privateKey, keyringSignatureNamespace, payload)
+	if err != nil {
+		return err
+	}
+	k.Signature = &KeyringSignature{Signer: entry.Login, Fingerprint: fingerprint, Signature: string(signature)}
+	return nil
+}
+
+// VerifySignature checks the keyring signature and returns the granted entry that made it
+func (k *Keyring) VerifySignature() (*KeyringEntry, error) {
+	if k.Signature == nil {
+		return nil, fmt.Errorf("keyring is not signed")
+	}
+	payload, err := k.signedPayload()
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := ssh.VerifySignature([]byte(k.Signature.Signature), keyringSignatureNamespace, payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyring signature: %w", err)
+	}
+	fingerprint := gossh.FingerprintSHA256(publicKey)
+	entry, ok := k.grantedEntry(fingerprint)
+	if !ok || fingerprint != k.Signature.Fingerprint {
+		return nil, fmt.Errorf("keyring was signed by %s, which is not a granted entry", fingerprint)
+	}
+	return entry, nil
+}
+
+// keyringTrustPath returns where the clone remembers the keys allowed to sign the keyring
+func keyringTrustPath() (string, error) {
+	keyPath, err := LocalKeyPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(keyPath), "keyring_trust.json"), nil
+}
+
+// loadKeyringTrust returns the remembered signers, or nil if no signed keyring was verified yet
+func loadKeyringTrust() (*keyringTrust, error) {
+	path, err := keyringTrustPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring trust: %w", err)
+	}
+	var trust keyringTrust
+	if err := json.Unmarshal(data, &trust); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring trust: %w", err)
+	}
+	return &trust, nil
+}
+
+// TrustKeyring remembers the granted entries of a verified keyring as the signers of the next one
+func TrustKeyring(k *Keyring) error {
+	path, err := keyringTrustPath()
+	if err != nil {
+		return err
+	}
+	trust := keyringTrust{Signers: []string{}}
+	for _, entry := range k.Entries {
+		if entry.EncryptedDEK != "" {
+			trust.Signers = append(trust.Signers, entry.Fingerprint)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := canonical.WriteFile(path, trust, 0600); err != nil {
+		return fmt.Errorf("failed to save keyring trust: %w", err)
+	}
+	return nil
+}
+
+// CheckKeyringTrust verifies the keyring against the signers remembered from the last verified
+// keyring, then remembers this keyring's granted entries
+func CheckKeyringTrust(k *Keyring) error {
+	trust, err := loadKeyringTrust()
+	if err != nil {
+		return err
+	}
+	if k.Signature == nil {
+		if trust != nil {
+			return fmt.Errorf("%s is not signed, but this clone verified a signed keyring before; it may have been tampered with", KeyringFile)
+		}
+		return nil
+	}
+
+	signer, err := k.VerifySignature()
+	if err != nil {
+		return fmt.Errorf("%s cannot be trusted: %w", KeyringFile, err)
+	}
+	if trust != nil && !contains(trust.Signers, signer.Fingerprint) {
+		return fmt.Errorf("%s was signed by %s (%s), who was not a granted entry of the last trusted keyring", KeyringFile, signer.Login, signer.Fingerprint)
+	}
+	return TrustKeyring(k)
+}
+
+// contains reports whether values contains value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
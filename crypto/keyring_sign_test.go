@@ -0,0 +1,75 @@
+package crypto
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signedTestKeyring returns a keyring with alice and bob granted, signed by alice
+func signedTestKeyring(t *testing.T) (*Keyring, []byte) {
+	t.Helper()
+	aliceKey, alicePublic := generateTestSSHKey(t)
+	_, bobPublic := generateTestSSHKey(t)
+
+	keyring := NewKeyring()
+	_, err := keyring.AddEntry("alice", alicePublic)
+	require.NoError(t, err)
+	_, err = keyring.AddEntry("bob", bobPublic)
+	require.NoError(t, err)
+	dek, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	require.NoError(t, keyring.GenerateEncryptedDEKs(dek, keyring.Logins()...))
+	require.NoError(t, keyring.Sign(aliceKey))
+	return keyring, dek
+}
+
+func TestKeyringSignature(t *testing.T) {
+	keyring, dek := signedTestKeyring(t)
+	assert.Equal(t, "alice", keyring.Signature.Signer)
+
+	signer, err := keyring.VerifySignature()
+	require.NoError(t, err)
+	assert.Equal(t, "alice", signer.Login)
+
+	// A pending registration is not covered by the signature
+	_, pendingPublic := generateTestSSHKey(t)
+	_, err = keyring.AddEntry("carol", pendingPublic)
+	require.NoError(t, err)
+	_, err = keyring.VerifySignature()
+	assert.NoError(t, err)
+
+	// Wrapping the key to it is
+	_, err = keyring.WrapPendingDEKs(dek, "carol")
+	require.NoError(t, err)
+	_, err = keyring.VerifySignature()
+	assert.ErrorContains(t, err, "invalid keyring signature")
+
+	outsiderKey, _ := generateTestSSHKey(t)
+	assert.ErrorIs(t, keyring.Sign(outsiderKey), ErrNotKeyringSigner)
+}
+
+func TestCheckKeyringTrust(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, exec.Command("git", "init", "-q", dir).Run())
+	original, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(original) })
+
+	// Unsigned keyrings are accepted until a signed one was verified
+	unsigned := NewKeyring()
+	require.NoError(t, CheckKeyringTrust(unsigned))
+
+	keyring, _ := signedTestKeyring(t)
+	require.NoError(t, CheckKeyringTrust(keyring))
+	assert.EqualError(t, CheckKeyringTrust(unsigned), KeyringFile+" is not signed, but this clone verified a signed keyring before; it may have been tampered with")
+
+	// A keyring signed by someone who was not trusted before is rejected, even if self-consistent
+	other, _ := signedTestKeyring(t)
+	assert.ErrorContains(t, CheckKeyringTrust(other), "who was not a granted entry of the last trusted keyring")
+	require.NoError(t, CheckKeyringTrust(keyring))
+}
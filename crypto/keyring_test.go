@@ -0,0 +1,392 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/ssh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// generateTestSSHKey creates an RSA keypair and its authorized_keys representation
+func generateTestSSHKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	publicKey, err := ssh.AuthorizedKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+	return privateKey, publicKey
+}
+
+func TestWrapUnwrapDEK(t *testing.T) {
+	privateKey, publicKey := generateTestSSHKey(t)
+	otherKey, _ := generateTestSSHKey(t)
+
+	dek, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	wrapped, err := WrapDEK(dek, publicKey)
+	require.NoError(t, err)
+	assert.NotEqual(t, dek, wrapped)
+
+	unwrapped, err := UnwrapDEK(wrapped, privateKey)
+	require.NoError(t, err)
+	assert.Equal(t, dek, unwrapped)
+
+	_, err = UnwrapDEK(wrapped, otherKey)
+	assert.Error(t, err, "unwrapping with a different key should fail")
+}
+
+func TestWrapUnwrapDEKEd25519(t *testing.T) {
+	privatePEM, publicKey := generateTestEd25519Key(t)
+	privateKey, err := ssh.ParsePrivateKey(privatePEM)
+	require.NoError(t, err)
+	otherPEM, _ := generateTestEd25519Key(t)
+	otherKey, err := ssh.ParsePrivateKey(otherPEM)
+	require.NoError(t, err)
+	rsaKey, _ := generateTestSSHKey(t)
+
+	dek, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	assert.True(t, CanWrapTo(publicKey))
+	wrapped, err := WrapDEK(dek, publicKey)
+	require.NoError(t, err)
+	assert.Len(t, wrapped, x25519WrappedSize)
+
+	again, err := WrapDEK(dek, publicKey)
+	require.NoError(t, err)
+	assert.NotEqual(t, wrapped, again, "every wrap should use a fresh ephemeral key")
+
+	unwrapped, err := UnwrapDEK(wrapped, privateKey)
+	require.NoError(t, err)
+	assert.Equal(t, dek, unwrapped)
+
+	_, err = UnwrapDEK(wrapped, otherKey)
+	assert.Error(t, err, "unwrapping with a different key should fail")
+	_, err = UnwrapDEK(wrapped, rsaKey)
+	assert.Error(t, err, "unwrapping with an RSA key should fail")
+
+	tampered := append([]byte{}, wrapped...)
+	tampered[len(tampered)-1] ^= 0x01
+	_, err = UnwrapDEK(tampered, privateKey)
+	assert.ErrorIs(t, err, ErrAuthentication)
+}
+
+func TestWrapDEKUnsupportedKey(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	publicKey, err := ssh.AuthorizedKey(&ecdsaKey.PublicKey)
+	require.NoError(t, err)
+
+	assert.False(t, CanWrapTo(publicKey))
+	_, err = WrapDEK(make([]byte, keySize), publicKey)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported SSH key type")
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	alicePrivate, alicePublic := generateTestSSHKey(t)
+	bobPEM, bobPublic := generateTestEd25519Key(t)
+	bobPrivate, err := ssh.ParsePrivateKey(bobPEM)
+	require.NoError(t, err)
+	outsiderPrivate, _ := generateTestSSHKey(t)
+
+	dek, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	keyring := NewKeyring()
+	assert.True(t, keyring.CanWrapTo(bobPublic))
+	added, err := keyring.AddEntry("alice", alicePublic)
+	require.NoError(t, err)
+	assert.True(t, added)
+	added, err = keyring.AddEntry("alice", alicePublic)
+	require.NoError(t, err)
+	assert.False(t, added, "duplicate keys should not be added twice")
+	_, err = keyring.AddEntry("bob", bobPublic)
+	require.NoError(t, err)
+
+	require.NoError(t, keyring.GenerateEncryptedDEKs(dek, keyring.Logins()...))
+
+	path := filepath.Join(t.TempDir(), KeyringFile)
+	require.NoError(t, keyring.Save(path))
+
+	loaded, err := LoadKeyring(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, loaded.Logins())
+
+	for _, privateKey := range []crypto.PrivateKey{alicePrivate, bobPrivate} {
+		key, err := loaded.DecryptDEK(privateKey)
+		require.NoError(t, err)
+		assert.Equal(t, dek, key)
+	}
+
+	_, err = loaded.DecryptDEK(outsiderPrivate)
+	assert.ErrorIs(t, err, ErrNoKeyringEntry)
+	assert.Contains(t, err.Error(), "no keyring entry")
+
+	assert.Equal(t, 1, loaded.RemoveLogin("bob"))
+	_, err = loaded.DecryptDEK(bobPrivate)
+	assert.Error(t, err, "removed collaborators should no longer match an entry")
+}
+
+func TestGenerateEncryptedDEKsInvalidKey(t *testing.T) {
+	keyring := NewKeyring()
+	err := keyring.GenerateEncryptedDEKs([]byte("short"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid key size")
+}
+
+func TestKeyringSaveIsCanonical(t *testing.T) {
+	_, alicePublic := generateTestSSHKey(t)
+	_, bobPublic := generateTestSSHKey(t)
+	dir := t.TempDir()
+
+	first := NewKeyring()
+	_, err := first.AddEntry("bob", bobPublic)
+	require.NoError(t, err)
+	_, err = first.AddEntry("alice", alicePublic)
+	require.NoError(t, err)
+
+	second := NewKeyring()
+	_, err = second.AddEntry("alice", alicePublic)
+	require.NoError(t, err)
+	_, err = second.AddEntry("bob", bobPublic)
+	require.NoError(t, err)
+
+	firstPath := filepath.Join(dir, "first")
+	secondPath := filepath.Join(dir, "second")
+	require.NoError(t, first.Save(firstPath))
+	require.NoError(t, second.Save(secondPath))
+
+	firstData, err := os.ReadFile(firstPath)
+	require.NoError(t, err)
+	secondData, err := os.ReadFile(secondPath)
+	require.NoError(t, err)
+	assert.Equal(t, string(firstData), string(secondData), "insertion order must not change the saved keyring")
+
+	loaded, err := LoadKeyring(firstPath)
+	require.NoError(t, err)
+	require.NoError(t, loaded.Save(firstPath))
+	resaved, err := os.ReadFile(firstPath)
+	require.NoError(t, err)
+	assert.Equal(t, string(firstData), string(resaved), "re-saving an unchanged keyring must not change it")
+}
+
+func TestKeyringMarkGranted(t *testing.T) {
+	_, alicePublic := generateTestSSHKey(t)
+	_, bobPublic := generateTestSSHKey(t)
+
+	keyring := NewKeyring()
+	_, err := keyring.AddEntry("alice", alicePublic)
+	require.NoError(t, err)
+	_, err = keyring.AddEntry("bob", bobPublic)
+	require.NoError(t, err)
+
+	grantedAt := time.Date(2024, 5, 1, 12, 0, 0, 500, time.FixedZone("CEST", 2*60*60))
+	keyring.MarkGranted("alice", grantedAt)
+
+	for _, entry := range keyring.Entries {
+		if entry.Login == "alice" {
+			require.NotNil(t, entry.GrantedAt)
+			assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), *entry.GrantedAt)
+		} else {
+			assert.Nil(t, entry.GrantedAt)
+		}
+	}
+}
+
+func TestKeyringSoftDeleteAndRestore(t *testing.T) {
+	alicePrivate, alicePublic := generateTestSSHKey(t)
+	bobPrivate, bobPublic := generateTestSSHKey(t)
+
+	dek, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	keyring := NewKeyring()
+	_, err = keyring.AddEntry("alice", alicePublic)
+	require.NoError(t, err)
+	_, err = keyring.AddEntry("bob", bobPublic)
+	require.NoError(t, err)
+	require.NoError(t, keyring.GenerateEncryptedDEKs(dek, keyring.Logins()...))
+
+	removedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 1, keyring.SoftDelete("bob", "alice", removedAt))
+	assert.Equal(t, 0, keyring.SoftDelete("carol", "alice", removedAt))
+	assert.Equal(t, []string{"alice"}, keyring.Logins())
+
+	tombstone, ok := keyring.FindTombstone("bob")
+	require.True(t, ok)
+	assert.Equal(t, "alice", tombstone.RemovedBy)
+	assert.Equal(t, removedAt, tombstone.RemovedAt)
+	require.Len(t, tombstone.Entries, 1)
+	assert.Empty(t, tombstone.Entries[0].EncryptedDEK, "tombstones must not keep a wrapped key")
+
+	_, err = keyring.DecryptDEK(bobPrivate)
+	assert.Error(t, err, "a removed collaborator should not unwrap the key")
+
+	tests := []struct {
+		name    string
+		login   string
+		now     time.Time
+		wantErr bool
+	}{
+		{"unknown login", "carol", removedAt.Add(time.Hour), true},
+		{"outside grace period", "bob", removedAt.Add(31 * 24 * time.Hour), true},
+		{"within grace period", "bob", removedAt.Add(24 * time.Hour), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restored, err := keyring.Restore(tt.login, tt.now, 30*24*time.Hour)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, 1, restored)
+		})
+	}
+
+	assert.Equal(t, []string{"alice", "bob"}, keyring.Logins())
+	assert.Empty(t, keyring.Tombstones)
+
+	require.NoError(t, keyring.GenerateEncryptedDEKs(dek, keyring.Logins()...))
+	for _, privateKey := range []crypto.PrivateKey{alicePrivate, bobPrivate} {
+		key, err := keyring.DecryptDEK(privateKey)
+		require.NoError(t, err)
+		assert.Equal(t, dek, key)
+	}
+}
+
+func TestKeyringPendingEntries(t *testing.T) {
+	_, alicePublic := generateTestSSHKey(t)
+	_, bobPublic := generateTestSSHKey(t)
+	dek, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	keyring := NewKeyring()
+	_, err = keyring.AddEntry("alice", alicePublic)
+	require.NoError(t, err)
+	require.NoError(t, keyring.GenerateEncryptedDEKs(dek, keyring.Logins()...))
+	_, err = keyring.AddEntry("bob", bobPublic)
+	require.NoError(t, err)
+
+	assert.Equal(t, 0, keyring.PendingEntries("alice"))
+	assert.Equal(t, 1, keyring.PendingEntries("bob"))
+	assert.Equal(t, 0, keyring.PendingEntries("carol"))
+}
+
+func TestKeyringWrapPendingDEKs(t *testing.T) {
+	_, alicePublic := generateTestSSHKey(t)
+	bobPrivate, bobPublic := generateTestSSHKey(t)
+	dek, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	keyring := NewKeyring()
+	_, err = keyring.AddEntry("alice", alicePublic)
+	require.NoError(t, err)
+	require.NoError(t, keyring.GenerateEncryptedDEKs(dek, keyring.Logins()...))
+	aliceWrapped := keyring.Entries[0].EncryptedDEK
+
+	_, err = keyring.AddEntry("bob", bobPublic)
+	require.NoError(t, err)
+
+	// Only approved logins are wrapped to
+	wrapped, err := keyring.WrapPendingDEKs(dek, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 0, wrapped)
+	assert.Equal(t, 1, keyring.PendingEntries("bob"))
+
+	wrapped, err = keyring.WrapPendingDEKs(dek, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, 1, wrapped)
+	assert.Equal(t, aliceWrapped, keyring.Entries[0].EncryptedDEK, "existing entries must not be re-wrapped")
+
+	unwrapped, err := keyring.DecryptDEK(bobPrivate)
+	require.NoError(t, err)
+	assert.Equal(t, dek, unwrapped)
+
+	wrapped, err = keyring.WrapPendingDEKs(dek, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, 0, wrapped)
+
+	_, err = keyring.WrapPendingDEKs([]byte("short"), "bob")
+	assert.Error(t, err)
+}
+
+func TestGenerateEncryptedDEKsKeepsPendingEntries(t *testing.T) {
+	alicePrivate, alicePublic := generateTestSSHKey(t)
+	malloryPrivate, malloryPublic := generateTestSSHKey(t)
+	oldKey, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	newKey, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	keyring := NewKeyring()
+	_, err = keyring.AddEntry("alice", alicePublic)
+	require.NoError(t, err)
+	require.NoError(t, keyring.GenerateEncryptedDEKs(oldKey, "alice"))
+	// An entry added to the committed file without a grant
+	_, err = keyring.AddEntry("mallory", malloryPublic)
+	require.NoError(t, err)
+
+	// Rotating re-wraps existing access only
+	require.NoError(t, keyring.GenerateEncryptedDEKs(newKey))
+	key, err := keyring.DecryptDEK(alicePrivate)
+	require.NoError(t, err)
+	assert.Equal(t, newKey, key)
+	assert.Equal(t, 1, keyring.PendingEntries("mallory"))
+	_, err = keyring.DecryptDEK(malloryPrivate)
+	assert.Error(t, err)
+
+	require.NoError(t, keyring.GenerateEncryptedDEKs(newKey, "mallory"))
+	key, err = keyring.DecryptDEK(malloryPrivate)
+	require.NoError(t, err)
+	assert.Equal(t, newKey, key)
+}
+
+func TestKeyringLoadPrivateKey(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv(ssh.PrivateKeyEnv, "")
+
+	// A personal key with access and a newer dedicated key that is still pending
+	personalPEM, personalPublic, err := ssh.GenerateEd25519Key()
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(home, ".ssh"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".ssh", "id_ed25519"), personalPEM, 0600))
+	dedicatedPEM, dedicatedPublic, err := ssh.GenerateEd25519Key()
+	require.NoError(t, err)
+	keyDir, err := ssh.KeyDir()
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(keyDir, 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(keyDir, ssh.DedicatedEd25519KeyName), dedicatedPEM, 0600))
+
+	dek, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	keyring := NewKeyring()
+	_, err = keyring.AddEntry("alice", personalPublic)
+	require.NoError(t, err)
+	require.NoError(t, keyring.GenerateEncryptedDEKs(dek, "alice"))
+	_, err = keyring.AddEntry("alice", dedicatedPublic)
+	require.NoError(t, err)
+
+	privateKey, err := keyring.LoadPrivateKey()
+	require.NoError(t, err, "the personal key is used although the dedicated key is preferred")
+	key, err := keyring.DecryptDEK(privateKey)
+	require.NoError(t, err)
+	assert.Equal(t, dek, key)
+
+	_, err = NewKeyring().LoadPrivateKey()
+	assert.ErrorIs(t, err, ErrNoKeyringEntry)
+}
@@ -2,42 +2,321 @@ package crypto
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 
 	"github.com/oliviaBahr/ez-env/github"
+	"github.com/oliviaBahr/ez-env/hosting"
 )
 
 // KeyManager handles encryption key storage and retrieval
-type KeyManager struct{}
+type KeyManager struct {
+	// environment is the GitHub Environment whose key is managed, or empty for the repository key
+	environment string
+	// source is where the last key GetEncryptionKey returned came from
+	source string
+}
 
 // NewKeyManager creates a new key manager
 func NewKeyManager() *KeyManager {
 	return &KeyManager{}
 }
 
-// GetOrCreateEncryptionKey retrieves the existing encryption key or creates a new one
-func (km *KeyManager) GetOrCreateEncryptionKey(ctx context.Context) ([]byte, error) {
-	fmt.Println("Retrieving encryption key via GitHub workflow...")
+// NewEnvironmentKeyManager creates a key manager for the key of a GitHub Environment
+// An empty environment manages the repository key like NewKeyManager
+func NewEnvironmentKeyManager(environment string) *KeyManager {
+	return &KeyManager{environment: environment}
+}
+
+// Source returns where the last key GetEncryptionKey returned came from, such as "keychain" or
+// "workflow", for diagnostics
+func (km *KeyManager) Source() string {
+	return km.source
+}
+
+// GetEncryptionKey retrieves the existing encryption key without ever creating a new one
+// The caller owns the returned key and should Destroy it when done
+func (km *KeyManager) GetEncryptionKey(ctx context.Context) (*SecureBytes, error) {
+	if km.environment != "" {
+		return km.getEnvironmentKey(ctx, false)
+	}
+	// A key imported with import-key takes precedence over remote retrieval
+	if key, err := LoadLocalKey(); err == nil {
+		km.source = "local key"
+		return SecureBytesFrom(key), nil
+	}
+	// A GitHub Actions job passes the key in from the secret
+	if key, err := LoadActionsKey(); err == nil {
+		km.source = "actions secret"
+		return SecureBytesFrom(key), nil
+	} else if !errors.Is(err, ErrNoActionsKey) {
+		return nil, err
+	}
+	// A submodule that inherits the key gets it from its parent repository
+	if parent, ok := ParentRepo(); ok {
+		key, err := loadParentKey(parent, "")
+		if err != nil {
+			return nil, err
+		}
+		km.source = "parent repository"
+		return SecureBytesFrom(key), nil
+	}
+
+	switch CurrentMode() {
+	case ModeKeyring:
+		km.source = "keyring"
+		return km.GetKeyringKey()
+	case ModePassphrase:
+		km.source = "passphrase"
+		return km.GetPassphraseKey()
+	}
+	if InGitHubActions() {
+		return nil, actionsKeyMissing("")
+	}
 
-	// First try to get the existing key via workflow
-	key, err := github.GetEncryptionKey(ctx)
+	// A key fetched earlier is read from the keychain instead of running the workflow again
+	if key, err := LoadKeychainKey(); err == nil {
+		km.source = "keychain"
+		return SecureBytesFrom(key), nil
+	}
+	// The filters of one git operation share the key through the session agent
+	if key, err := loadSessionKey("repository"); err == nil {
+		km.source = "session agent"
+		return SecureBytesFrom(key), nil
+	}
+	// Concurrent filters share one workflow run instead of each dispatching their own
+	km.source = "shared retrieval"
+	key, err := singleFlight("repository", loadStoredKey, func() ([]byte, error) {
+		if key, err := loadCachedKey("repository"); err == nil {
+			km.source = "key cache"
+			cacheInSession("repository", key)
+			return key, nil
+		}
+		if hosting.Current() == hosting.HostGitHub {
+			fmt.Fprintln(os.Stderr, "Retrieving encryption key via GitHub workflow...")
+		}
+		key, err := hosting.GetEncryptionKey(ctx)
+		if errors.Is(err, github.ErrSecretMissing) {
+			return nil, fmt.Errorf("%w: the key was deleted from the repository secrets. "+
+				"Anyone who still holds the key (an imported key or an export-key file) can run 'git ez-env recover' to upload it again; "+
+				"without a copy the encrypted files cannot be decrypted", err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve encryption key: %w", err)
+		}
+		km.source = "workflow"
+		cacheInKeychain(key)
+		cacheOnDisk("repository", key)
+		cacheInSession("repository", key)
+		return key, nil
+	})
 	if err != nil {
-		// If getting the key fails, create a new one
-		fmt.Println("No existing encryption key found. Creating new key...")
-		key, err = GenerateEncryptionKey()
+		return nil, err
+	}
+	return SecureBytesFrom(key), nil
+}
+
+// loadStoredKey reads a key another process stored on this machine while this one waited for it
+func loadStoredKey() ([]byte, error) {
+	if key, err := LoadLocalKey(); err == nil {
+		return key, nil
+	}
+	if key, err := loadSessionKey("repository"); err == nil {
+		return key, nil
+	}
+	return LoadKeychainKey()
+}
+
+// GetOrCreateEncryptionKey retrieves the existing encryption key or creates a new one
+// The caller owns the returned key and should Destroy it when done
+func (km *KeyManager) GetOrCreateEncryptionKey(ctx context.Context) (*SecureBytes, error) {
+	if km.environment != "" {
+		return km.getEnvironmentKey(ctx, true)
+	}
+	// A key imported with import-key takes precedence over remote retrieval
+	if key, err := LoadLocalKey(); err == nil {
+		return SecureBytesFrom(key), nil
+	}
+	// A GitHub Actions job passes the key in from the secret
+	if key, err := LoadActionsKey(); err == nil {
+		return SecureBytesFrom(key), nil
+	} else if !errors.Is(err, ErrNoActionsKey) {
+		return nil, err
+	}
+	// A submodule that inherits the key gets it from its parent repository
+	if parent, ok := ParentRepo(); ok {
+		key, err := loadParentKey(parent, "")
 		if err != nil {
-			return nil, fmt.Errorf("failed to generate encryption key: %w", err)
+			return nil, err
 		}
+		return SecureBytesFrom(key), nil
+	}
+
+	switch CurrentMode() {
+	case ModeKeyring:
+		return km.GetKeyringKey()
+	case ModePassphrase:
+		return km.GetPassphraseKey()
+	}
+	if InGitHubActions() {
+		return nil, actionsKeyMissing("")
+	}
 
-		// Store the new key in GitHub secrets
-		if err := github.StoreEncryptionKey(ctx, key); err != nil {
-			return nil, fmt.Errorf("failed to store encryption key: %w", err)
+	// A key fetched earlier is read from the keychain instead of running the workflow again
+	if key, err := LoadKeychainKey(); err == nil {
+		return SecureBytesFrom(key), nil
+	}
+	if key, err := loadSessionKey("repository"); err == nil {
+		return SecureBytesFrom(key), nil
+	}
+	// Holding the lock, a concurrent process creates the key first and this one retrieves it,
+	// instead of both creating different keys
+	key, err := singleFlight("repository+create", loadStoredKey, func() ([]byte, error) {
+		if key, err := loadCachedKey("repository"); err == nil {
+			cacheInSession("repository", key)
+			return key, nil
 		}
+		if hosting.Current() == hosting.HostGitHub {
+			fmt.Fprintln(os.Stderr, "Retrieving encryption key via GitHub workflow...")
+		}
+
+		// First try to get the existing key via workflow
+		key, err := hosting.GetEncryptionKey(ctx)
+		if err != nil && !errors.Is(err, github.ErrSecretMissing) {
+			return nil, fmt.Errorf("failed to retrieve encryption key: %w", err)
+		}
+		if err != nil {
+			// Only create a new key when the repository has none; any other failure must not replace the existing key
+			fmt.Fprintln(os.Stderr, "No existing encryption key found. Creating new key...")
+			key, err = GenerateEncryptionKey()
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate encryption key: %w", err)
+			}
+
+			// Store the new key in GitHub secrets
+			if err := hosting.StoreEncryptionKey(ctx, key); err != nil {
+				Wipe(key)
+				return nil, fmt.Errorf("failed to store encryption key: %w", err)
+			}
 
-		fmt.Println("✓ New encryption key created and stored in GitHub repository secrets")
-	} else {
-		fmt.Println("✓ Existing encryption key retrieved from GitHub repository secrets")
+			fmt.Fprintln(os.Stderr, "✓ New encryption key created and stored in "+hosting.SecretStore())
+		} else {
+			fmt.Fprintln(os.Stderr, "✓ Existing encryption key retrieved from "+hosting.SecretStore())
+		}
+		cacheInKeychain(key)
+		cacheOnDisk("repository", key)
+		cacheInSession("repository", key)
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return SecureBytesFrom(key), nil
+}
+
+// GetKeyringKey unwraps the data encryption key from the keyring with the local SSH private key,
+// for age keyrings with the local age identities and for gpg keyrings through gpg-agent
+func (km *KeyManager) GetKeyringKey() (*SecureBytes, error) {
+	keyring, err := LoadKeyring(RepoFile(KeyringFile))
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckKeyringTrust(keyring); err != nil {
+		return nil, err
+	}
+
+	var key []byte
+	switch keyring.WrapBackend() {
+	case BackendAge:
+		identities, loadErr := LoadAgeIdentities()
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		key, err = keyring.DecryptDEKAge(identities)
+	case BackendGPG:
+		key, err = keyring.DecryptDEKGPG()
+	default:
+		privateKey, loadErr := keyring.LoadPrivateKey()
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		key, err = keyring.DecryptDEK(privateKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key from keyring: %w", err)
+	}
+
+	return SecureBytesFrom(key), nil
+}
+
+// LocalKeyPath returns the path where an imported key is stored for the current repository
+func LocalKeyPath() (string, error) {
+	dir, err := GitDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ezenv", "key"), nil
+}
+
+// LoadLocalKey reads the locally stored key for the current repository
+func LoadLocalKey() ([]byte, error) {
+	path, err := LocalKeyPath()
+	if err != nil {
+		return nil, err
 	}
 
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local key: %w", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid local key size: expected %d, got %d", keySize, len(key))
+	}
 	return key, nil
 }
+
+// SaveLocalKey stores the key for the current repository inside the git directory
+func SaveLocalKey(key []byte) error {
+	if len(key) != keySize {
+		return fmt.Errorf("invalid key size: expected %d, got %d", keySize, len(key))
+	}
+
+	path, err := LocalKeyPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		return fmt.Errorf("failed to write local key: %w", err)
+	}
+	return nil
+}
+
+// UpdateLocalKey replaces the locally stored key and the keychain copy if they exist, so neither
+// goes stale after a rotation, and forgets the cached and session copies of the old key
+func UpdateLocalKey(key []byte) error {
+	if _, err := ForgetSession(); err != nil {
+		return err
+	}
+	if _, err := ForgetCachedKeys(); err != nil {
+		return err
+	}
+	if _, err := LoadKeychainKey(); err == nil {
+		if err := SaveKeychainKey(key); err != nil {
+			return err
+		}
+	}
+
+	path, err := LocalKeyPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	return SaveLocalKey(key)
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package crypto
+
+import "errors"
+
+// lockMemory is not supported on this platform; key material is still wiped by Destroy
+func lockMemory(b []byte) error {
+	return errors.New("memory locking is not supported on this platform")
+}
+
+// unlockMemory is a no-op where lockMemory is not supported
+func unlockMemory(b []byte) {}
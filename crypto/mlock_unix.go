@@ -0,0 +1,20 @@
+//go:build linux || darwin
+
+package crypto
+
+import "syscall"
+
+// lockMemory keeps b out of swap; it fails without the privilege or when RLIMIT_MEMLOCK is exhausted
+func lockMemory(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return syscall.Mlock(b)
+}
+
+// unlockMemory releases a lock taken by lockMemory
+func unlockMemory(b []byte) {
+	if len(b) > 0 {
+		syscall.Munlock(b)
+	}
+}
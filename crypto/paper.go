@@ -0,0 +1,139 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"strings"
+)
+
+// A paper backup writes the repository key down for offline escrow, as words in the style of a
+// BIP39 mnemonic and as a short code for a QR code. Every word stands for one byte of the key and
+// two more carry a checksum, so a mistyped or missing word is detected instead of silently
+// restoring a wrong key. The words are matched on their first four letters, which are unique.
+
+// paperCodePrefix starts the text of a paper backup QR code
+const paperCodePrefix = "EZENV1:"
+
+// paperChecksumSize is the number of checksum bytes appended to the key
+const paperChecksumSize = 2
+
+// paperPrefixLength is the number of letters that identify a word
+const paperPrefixLength = 4
+
+// paperEncoding encodes QR codes with characters that survive being typed in again
+var paperEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// paperWordList has one word for each byte value
+var paperWordList = strings.Fields(`
+	able acid acorn actor adapt admit adult agent album alert alien alpha amber angle ankle
+	apple april arena argue armor arrow atlas atom audio autumn avoid awake axis bacon badge
+	bagel baker bamboo banjo barrel basil basket beach beard beetle bench berry bicycle birch
+	blade blanket bloom board bonus border bottle bounce bracket bread brick bridge broom
+	brush bubble bucket buffalo bundle burger butter cabin cactus camel candle canoe canyon
+	carbon carpet castle cattle cedar cello cement chalk chapter cherry chess chief chimney
+	cider cinema circle citrus clever cliff clock cloud cobalt coconut coffee comet copper
+	coral cotton couch coyote crab crane crayon credit cricket crown crystal cube cupboard
+	curtain cycle daisy dancer debut decade delta denim desert diamond diesel dinner dolphin
+	domino donkey dragon drum duck dune eagle echo eclipse elbow elephant ember engine
+	envelope equal errand escape estate evening exhibit fabric falcon fancy farmer feather
+	fence ferry fiber fiddle filter finger fiscal flame flannel flute focus forest fossil
+	fountain frame fringe frost fruit funnel galaxy garden garlic gazelle gecko gentle giant
+	ginger giraffe glacier glove goblet golden gorilla grape gravel guitar habit hammer harbor
+	harvest hazel helmet hermit hockey honey horizon hotel hunter husky iceberg icon igloo
+	impact index indigo insect island ivory jacket jaguar jasmine jelly jigsaw jockey journey
+	juggle jungle juniper kayak kernel kettle kingdom kitten koala ladder lagoon lantern
+	laptop lemon leopard lizard lobster locket lumber lunar magnet mango marble meadow melody
+	mercury method mirror mitten monkey mosaic muffin museum napkin nectar needle nephew
+	nickel noodle nugget oasis ocean olive onion
+`)
+
+// paperPayload returns the key followed by its checksum
+func paperPayload(key []byte) ([]byte, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid key size: expected %d, got %d", keySize, len(key))
+	}
+	sum := sha256.Sum256(key)
+	return append(bytes.Clone(key), sum[:paperChecksumSize]...), nil
+}
+
+// PaperWords returns the words of a paper backup of key
+func PaperWords(key []byte) ([]string, error) {
+	payload, err := paperPayload(key)
+	if err != nil {
+		return nil, err
+	}
+	words := make([]string, len(payload))
+	for i, b := range payload {
+		words[i] = paperWordList[b]
+	}
+	return words, nil
+}
+
+// PaperCode returns the text of the QR code of a paper backup of key
+func PaperCode(key []byte) (string, error) {
+	payload, err := paperPayload(key)
+	if err != nil {
+		return "", err
+	}
+	return paperCodePrefix + paperEncoding.EncodeToString(payload), nil
+}
+
+// ParsePaperBackup restores the key from the words of a paper backup or the text of its QR code
+// Words may be separated by any whitespace, numbered and typed in any case
+func ParsePaperBackup(text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+	var payload []byte
+	if code, ok := strings.CutPrefix(strings.ToUpper(text), paperCodePrefix); ok {
+		decoded, err := paperEncoding.DecodeString(code)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup code: %w", err)
+		}
+		payload = decoded
+	} else {
+		decoded, err := decodePaperWords(text)
+		if err != nil {
+			return nil, err
+		}
+		payload = decoded
+	}
+
+	if len(payload) != keySize+paperChecksumSize {
+		return nil, fmt.Errorf("a paper backup has %d words, got %d", keySize+paperChecksumSize, len(payload))
+	}
+	key := payload[:keySize]
+	sum := sha256.Sum256(key)
+	if !bytes.Equal(sum[:paperChecksumSize], payload[keySize:]) {
+		return nil, fmt.Errorf("checksum mismatch: a word was mistyped, swapped or left out")
+	}
+	return key, nil
+}
+
+// decodePaperWords maps backup words to bytes, ignoring the numbers of a numbered list
+func decodePaperWords(text string) ([]byte, error) {
+	var payload []byte
+	for _, field := range strings.Fields(strings.ToLower(text)) {
+		word := strings.TrimRight(field, ".,:;)")
+		if word == "" || strings.Trim(word, "0123456789") == "" {
+			continue
+		}
+		b, ok := paperWordValue(word)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a backup word", word)
+		}
+		payload = append(payload, b)
+	}
+	return payload, nil
+}
+
+// paperWordValue returns the byte a word stands for, matching its first four letters
+func paperWordValue(word string) (byte, bool) {
+	prefix := word[:min(len(word), paperPrefixLength)]
+	for i, candidate := range paperWordList {
+		if candidate == word || (len(word) >= paperPrefixLength && strings.HasPrefix(candidate, prefix)) {
+			return byte(i), true
+		}
+	}
+	return 0, false
+}
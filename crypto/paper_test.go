@@ -0,0 +1,75 @@
+package crypto
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaperWordList(t *testing.T) {
+	require.Len(t, paperWordList, 256)
+	prefixes := make(map[string]string)
+	for _, word := range paperWordList {
+		prefix := word[:paperPrefixLength]
+		assert.Empty(t, prefixes[prefix], "%s and %s share a prefix", prefixes[prefix], word)
+		prefixes[prefix] = word
+	}
+}
+
+func TestPaperBackup(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	words, err := PaperWords(key)
+	require.NoError(t, err)
+	require.Len(t, words, keySize+paperChecksumSize)
+	code, err := PaperCode(key)
+	require.NoError(t, err)
+
+	var numbered strings.Builder
+	for i, word := range words {
+		fmt.Fprintf(&numbered, "%2d. %s\n", i+1, word)
+	}
+	var prefixes []string
+	for _, word := range words {
+		prefixes = append(prefixes, strings.ToUpper(word[:paperPrefixLength]))
+	}
+	swapped := append([]string{words[1], words[0]}, words[2:]...)
+	replacement := "A"
+	if code[10] == 'A' {
+		replacement = "B"
+	}
+	corrupted := code[:10] + replacement + code[11:]
+
+	tests := []struct {
+		name      string
+		text      string
+		expectErr string
+	}{
+		{name: "words", text: strings.Join(words, " ")},
+		{name: "numbered list", text: numbered.String()},
+		{name: "first four letters", text: strings.Join(prefixes, "\n")},
+		{name: "QR code text", text: code + "\n"},
+		{name: "lower case QR code text", text: strings.ToLower(code)},
+		{name: "missing word", text: strings.Join(words[1:], " "), expectErr: "a paper backup has 34 words, got 33"},
+		{name: "unknown word", text: strings.Join(append([]string{"zebra"}, words[1:]...), " "), expectErr: `"zebra" is not a backup word`},
+		{name: "swapped words", text: strings.Join(swapped, " "), expectErr: "checksum mismatch"},
+		{name: "corrupted code", text: corrupted, expectErr: "checksum mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "swapped words" && words[0] == words[1] {
+				t.Skip("swapping equal words changes nothing")
+			}
+			restored, err := ParsePaperBackup(tt.text)
+			if tt.expectErr != "" {
+				assert.ErrorContains(t, err, tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, key, restored)
+		})
+	}
+}
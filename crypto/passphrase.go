@@ -0,0 +1,242 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/term"
+
+	"github.com/oliviaBahr/ez-env/canonical"
+)
+
+const (
+	// PassphraseFile is the committed file holding the KDF parameters of a passphrase-derived key
+	PassphraseFile = ".gitenv_passphrase"
+	// ModePassphrase derives the repository key from a passphrase, without GitHub or collaborators
+	ModePassphrase = "passphrase"
+
+	// PassphraseEnv can be set to provide a passphrase non-interactively
+	PassphraseEnv = "EZENV_PASSPHRASE"
+
+	// kdfArgon2id identifies Argon2id in the envelope KDF field
+	kdfArgon2id byte = 0x01
+
+	// maxArgon2Memory bounds the memory in KiB that parameters read from a file may ask for
+	maxArgon2Memory = 4 * 1024 * 1024 // 4 GiB
+)
+
+// KDFParams are the Argon2id parameters and salt that derive a key from a passphrase
+type KDFParams struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	Salt    []byte
+}
+
+// NewKDFParams returns the default Argon2id parameters with a random salt
+func NewKDFParams() (KDFParams, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return KDFParams{}, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return KDFParams{Time: argon2Time, Memory: argon2Memory, Threads: argon2Threads, Salt: salt}, nil
+}
+
+// validate rejects parameters Argon2id cannot run with, which would panic, and memory costs no
+// legitimate file asks for
+func (p KDFParams) validate() error {
+	if p.Time < 1 {
+		return fmt.Errorf("invalid KDF time cost: %d", p.Time)
+	}
+	if p.Threads < 1 {
+		return fmt.Errorf("invalid KDF parallelism: %d", p.Threads)
+	}
+	if p.Memory < 8*uint32(p.Threads) || p.Memory > maxArgon2Memory {
+		return fmt.Errorf("invalid KDF memory cost: %d KiB", p.Memory)
+	}
+	return nil
+}
+
+// DeriveKey derives the repository key from passphrase
+func (p KDFParams) DeriveKey(passphrase []byte) []byte {
+	return argon2.IDKey(passphrase, p.Salt, p.Time, p.Memory, p.Threads, keySize)
+}
+
+// encodeKDF serializes the parameters for the envelope header:
+// [kdf id(1)][time(4)][memory(4)][threads(1)][salt]
+func (p KDFParams) encodeKDF() []byte {
+	value := []byte{kdfArgon2id}
+	value = binary.BigEndian.AppendUint32(value, p.Time)
+	value = binary.BigEndian.AppendUint32(value, p.Memory)
+	value = append(value, p.Threads)
+	return append(value, p.Salt...)
+}
+
+// decodeKDF parses the envelope KDF field
+func decodeKDF(value []byte) (KDFParams, error) {
+	if len(value) < 10+saltSize || value[0] != kdfArgon2id {
+		return KDFParams{}, fmt.Errorf("invalid KDF field")
+	}
+	params := KDFParams{
+		Time:    binary.BigEndian.Uint32(value[1:5]),
+		Memory:  binary.BigEndian.Uint32(value[5:9]),
+		Threads: value[9],
+		Salt:    append([]byte{}, value[10:]...),
+	}
+	if err := params.validate(); err != nil {
+		return KDFParams{}, err
+	}
+	return params, nil
+}
+
+// EnvelopeKDF returns the passphrase parameters stored in an encrypted file's header
+func EnvelopeKDF(encrypted []byte) (KDFParams, bool) {
+	_, fields, err := decodeHeader(encrypted)
+	if err != nil {
+		return KDFParams{}, false
+	}
+	value, ok := fields[fieldKDF]
+	if !ok {
+		return KDFParams{}, false
+	}
+	params, err := decodeKDF(value)
+	return params, err == nil
+}
+
+// DecryptFileWithPassphrase decrypts a file encrypted in passphrase mode using only the passphrase,
+// with the KDF parameters read from the file's own header
+func DecryptFileWithPassphrase(encrypted, passphrase []byte) ([]byte, error) {
+	params, ok := EnvelopeKDF(encrypted)
+	if !ok {
+		return nil, fmt.Errorf("file was not encrypted with a passphrase-derived key")
+	}
+	return DecryptFile(encrypted, params.DeriveKey(passphrase))
+}
+
+// PassphraseConfig is the committed description of a passphrase-derived repository key
+type PassphraseConfig struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	Salt    string `json:"salt"`
+	// KeyID lets a wrong passphrase be detected before anything is decrypted
+	KeyID string `json:"key_id"`
+}
+
+// NewPassphraseConfig records params and the id of the key derived with them
+func NewPassphraseConfig(params KDFParams, key []byte) *PassphraseConfig {
+	return &PassphraseConfig{
+		Version: 1,
+		KDF:     "argon2id",
+		Time:    params.Time,
+		Memory:  params.Memory,
+		Threads: params.Threads,
+		Salt:    base64.StdEncoding.EncodeToString(params.Salt),
+		KeyID:   KeyID(key),
+	}
+}
+
+// LoadPassphraseConfig reads the passphrase configuration from disk
+func LoadPassphraseConfig(path string) (*PassphraseConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase configuration: %w", err)
+	}
+	var config PassphraseConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse passphrase configuration: %w", err)
+	}
+	if config.Version != 1 || config.KDF != "argon2id" {
+		return nil, fmt.Errorf("unsupported passphrase configuration: version %d, kdf %q", config.Version, config.KDF)
+	}
+	return &config, nil
+}
+
+// Save writes the passphrase configuration to disk as canonical JSON
+func (c *PassphraseConfig) Save(path string) error {
+	if err := canonical.WriteFile(path, c, 0644); err != nil {
+		return fmt.Errorf("failed to save passphrase configuration: %w", err)
+	}
+	return nil
+}
+
+// Params returns the KDF parameters of the configuration
+func (c *PassphraseConfig) Params() (KDFParams, error) {
+	salt, err := base64.StdEncoding.DecodeString(c.Salt)
+	if err != nil || len(salt) < saltSize {
+		return KDFParams{}, fmt.Errorf("invalid salt in passphrase configuration")
+	}
+	params := KDFParams{Time: c.Time, Memory: c.Memory, Threads: c.Threads, Salt: salt}
+	if err := params.validate(); err != nil {
+		return KDFParams{}, fmt.Errorf("invalid passphrase configuration: %w", err)
+	}
+	return params, nil
+}
+
+// DeriveKey derives the repository key and checks it against the recorded key id
+func (c *PassphraseConfig) DeriveKey(passphrase []byte) ([]byte, error) {
+	params, err := c.Params()
+	if err != nil {
+		return nil, err
+	}
+	key := params.DeriveKey(passphrase)
+	if KeyID(key) != c.KeyID {
+		return nil, fmt.Errorf("wrong passphrase")
+	}
+	return key, nil
+}
+
+// GetPassphraseKey derives the repository key from the passphrase in EZENV_PASSPHRASE or typed on the terminal
+// The key is stored locally afterwards so the filters do not ask again
+func (km *KeyManager) GetPassphraseKey() (*SecureBytes, error) {
+	config, err := LoadPassphraseConfig(RepoFile(PassphraseFile))
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := terminalPassphrase("ez-env passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(passphrase)
+	key, err := config.DeriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if err := SaveLocalKey(key); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: the key could not be stored locally: %v\n", err)
+	}
+	return SecureBytesFrom(key), nil
+}
+
+// terminalPassphrase reads a passphrase from EZENV_PASSPHRASE or the controlling terminal
+// The terminal is opened directly because git filters receive file content on stdin
+func terminalPassphrase(prompt string) ([]byte, error) {
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("no terminal available to read the passphrase (set %s instead)", PassphraseEnv)
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, prompt)
+	passphrase, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase must not be empty")
+	}
+	return passphrase, nil
+}
@@ -0,0 +1,117 @@
+package crypto
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKDFParams returns cheap Argon2id parameters so tests stay fast
+func testKDFParams(t *testing.T) KDFParams {
+	t.Helper()
+	params, err := NewKDFParams()
+	require.NoError(t, err)
+	params.Time = 1
+	params.Memory = 8 * 1024
+	params.Threads = 1
+	return params
+}
+
+func TestDecryptFileWithPassphrase(t *testing.T) {
+	params := testKDFParams(t)
+	passphrase := []byte("correct horse battery staple")
+	key := params.DeriveKey(passphrase)
+
+	tests := []struct {
+		name string
+		opts EncryptOptions
+	}{
+		{name: "kdf only", opts: EncryptOptions{KDF: &params}},
+		{name: "kdf and padding", opts: EncryptOptions{KDF: &params, PaddingBucket: 64}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, err := EncryptFileWithOptions([]byte("SECRET=1\n"), key, tt.opts)
+			require.NoError(t, err)
+
+			stored, ok := EnvelopeKDF(encrypted)
+			require.True(t, ok)
+			assert.Equal(t, params, stored)
+
+			plaintext, err := DecryptFileWithPassphrase(encrypted, passphrase)
+			require.NoError(t, err)
+			assert.Equal(t, "SECRET=1\n", string(plaintext))
+
+			plaintext, err = DecryptFile(encrypted, key)
+			require.NoError(t, err)
+			assert.Equal(t, "SECRET=1\n", string(plaintext))
+
+			_, err = DecryptFileWithPassphrase(encrypted, []byte("wrong"))
+			assert.ErrorIs(t, err, ErrAuthentication)
+		})
+	}
+
+	encrypted, err := EncryptFile([]byte("SECRET=1\n"), key)
+	require.NoError(t, err)
+	_, ok := EnvelopeKDF(encrypted)
+	assert.False(t, ok)
+	_, err = DecryptFileWithPassphrase(encrypted, passphrase)
+	assert.Error(t, err)
+}
+
+func TestPassphraseConfig(t *testing.T) {
+	params := testKDFParams(t)
+	passphrase := []byte("correct horse battery staple")
+	key := params.DeriveKey(passphrase)
+
+	path := filepath.Join(t.TempDir(), PassphraseFile)
+	require.NoError(t, NewPassphraseConfig(params, key).Save(path))
+
+	config, err := LoadPassphraseConfig(path)
+	require.NoError(t, err)
+	loaded, err := config.Params()
+	require.NoError(t, err)
+	assert.Equal(t, params, loaded)
+
+	derived, err := config.DeriveKey(passphrase)
+	require.NoError(t, err)
+	assert.Equal(t, key, derived)
+
+	_, err = config.DeriveKey([]byte("wrong"))
+	assert.EqualError(t, err, "wrong passphrase")
+}
+
+func TestKDFParamsValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(p *KDFParams)
+		want   string
+	}{
+		{name: "valid", modify: func(p *KDFParams) {}},
+		{name: "zero time", modify: func(p *KDFParams) { p.Time = 0 }, want: "invalid KDF time cost: 0"},
+		{name: "zero threads", modify: func(p *KDFParams) { p.Threads = 0 }, want: "invalid KDF parallelism: 0"},
+		{name: "zero memory", modify: func(p *KDFParams) { p.Memory = 0 }, want: "invalid KDF memory cost: 0 KiB"},
+		{name: "excessive memory", modify: func(p *KDFParams) { p.Memory = 1 << 31 }, want: "invalid KDF memory cost: 2147483648 KiB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := testKDFParams(t)
+			tt.modify(&params)
+
+			decoded, err := decodeKDF(params.encodeKDF())
+			config := NewPassphraseConfig(params, make([]byte, keySize))
+			_, configErr := config.Params()
+			if tt.want == "" {
+				require.NoError(t, err)
+				require.NoError(t, configErr)
+				assert.Equal(t, params, decoded)
+				return
+			}
+			assert.EqualError(t, err, tt.want)
+			assert.ErrorContains(t, configErr, tt.want)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// InheritParent makes a submodule use the key of the repository it is a submodule of; the
+// inheritParent setting turns it on
+var InheritParent = false
+
+// GitDir returns the git directory shared by all worktrees of the repository, where ez-env keeps
+// its local state, so a key imported in one worktree is used by the others too
+func GitDir() (string, error) {
+	output, err := exec.Command("git", "rev-parse", "--path-format=absolute", "--git-common-dir").Output()
+	if err != nil {
+		// git before 2.31 has no --path-format; its --git-dir is still right outside linked worktrees
+		if output, err = exec.Command("git", "rev-parse", "--git-dir").Output(); err != nil {
+			return "", fmt.Errorf("failed to locate git directory: %w", err)
+		}
+	}
+	return filepath.Abs(strings.TrimSpace(string(output)))
+}
+
+// RepoFile returns the path of name at the top of the working tree, so committed ez-env files
+// such as KeyringFile are found from subdirectories too; outside a working tree it is name
+func RepoFile(name string) string {
+	output, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return name
+	}
+	return filepath.Join(strings.TrimSpace(string(output)), name)
+}
+
+// ParentRepo returns the working tree of the superproject when the current repository is a
+// submodule that inherits its key
+func ParentRepo() (string, bool) {
+	if !InheritParent {
+		return "", false
+	}
+	output, err := exec.Command("git", "rev-parse", "--show-superproject-working-tree").Output()
+	if parent := strings.TrimSpace(string(output)); err == nil && parent != "" {
+		return parent, true
+	}
+	return "", false
+}
+
+// loadParentKey gets the key of the superproject by running ez-env in it, so the superproject's
+// mode, settings and remote decide how the key is obtained
+func loadParentKey(parent, environment string) ([]byte, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get executable path: %w", err)
+	}
+	args := []string{"ci", "key", "--quiet"}
+	if environment != "" {
+		args = append(args, "--environment", environment)
+	}
+	var stdout bytes.Buffer
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = parent
+	cmd.Env = parentEnv()
+	cmd.Stdout = &stdout
+	// Progress and prompts of the superproject's retrieval reach the user
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to get the key of the parent repository %s: %w", parent, err)
+	}
+	defer Wipe(stdout.Bytes())
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stdout.String()))
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("the parent repository %s returned an invalid key", parent)
+	}
+	return key, nil
+}
+
+// parentEnv returns the environment without the variables that tie git to the current repository,
+// such as the GIT_DIR git sets for filters, so commands run in the superproject act on it
+func parentEnv() []string {
+	output, err := exec.Command("git", "rev-parse", "--local-env-vars").Output()
+	if err != nil {
+		return os.Environ()
+	}
+	local := strings.Fields(string(output))
+	var env []string
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		if !slices.Contains(local, name) {
+			env = append(env, variable)
+		}
+	}
+	return env
+}
@@ -0,0 +1,90 @@
+package crypto
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runGit runs git in dir and fails the test when it fails
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	args = append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "protocol.file.allow=always"}, args...)
+	output, err := exec.Command("git", args...).CombinedOutput()
+	require.NoError(t, err, string(output))
+}
+
+// chdir changes the working directory for the rest of the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	original, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(original) })
+}
+
+func TestGitDirAndRepoFile(t *testing.T) {
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	mainTree := filepath.Join(base, "main")
+	worktree := filepath.Join(base, "worktree")
+	runGit(t, base, "init", "-q", mainTree)
+	runGit(t, mainTree, "commit", "-q", "--allow-empty", "-m", "initial")
+	runGit(t, mainTree, "worktree", "add", "-q", worktree)
+	require.NoError(t, os.Mkdir(filepath.Join(worktree, "sub"), 0755))
+
+	tests := []struct {
+		name     string
+		dir      string
+		wantFile string
+	}{
+		{name: "main working tree", dir: mainTree, wantFile: filepath.Join(mainTree, KeyringFile)},
+		{name: "linked worktree", dir: worktree, wantFile: filepath.Join(worktree, KeyringFile)},
+		{name: "subdirectory of a linked worktree", dir: filepath.Join(worktree, "sub"), wantFile: filepath.Join(worktree, KeyringFile)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdir(t, tt.dir)
+			dir, err := GitDir()
+			require.NoError(t, err)
+			assert.Equal(t, filepath.Join(mainTree, ".git"), dir)
+			assert.Equal(t, tt.wantFile, RepoFile(KeyringFile))
+		})
+	}
+}
+
+func TestParentRepo(t *testing.T) {
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	library := filepath.Join(base, "library")
+	parent := filepath.Join(base, "parent")
+	runGit(t, base, "init", "-q", library)
+	runGit(t, library, "commit", "-q", "--allow-empty", "-m", "initial")
+	runGit(t, base, "init", "-q", parent)
+	runGit(t, parent, "submodule", "add", "-q", library, "library")
+	t.Cleanup(func() { InheritParent = false })
+
+	tests := []struct {
+		name    string
+		dir     string
+		inherit bool
+		want    string
+	}{
+		{name: "submodule inheriting", dir: filepath.Join(parent, "library"), inherit: true, want: parent},
+		{name: "submodule not inheriting", dir: filepath.Join(parent, "library"), inherit: false},
+		{name: "not a submodule", dir: parent, inherit: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdir(t, tt.dir)
+			InheritParent = tt.inherit
+			got, ok := ParentRepo()
+			assert.Equal(t, tt.want != "", ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/oliviaBahr/ez-env/canonical"
+)
+
+// Protections of a sealed output file
+const (
+	// SealedWithKey means the output is encrypted with the repository key
+	SealedWithKey = "repository-key"
+	// SealedWithPassphrase means the output is encrypted with a key derived from a passphrase
+	SealedWithPassphrase = "passphrase"
+)
+
+// sealedFormat identifies sealed output files
+const sealedFormat = "ez-env sealed output"
+
+// SealedOutput is the on-disk format of reports, logs and backups written by ez-env
+type SealedOutput struct {
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	Protection string `json:"protection"`
+	KeyID      string `json:"key_id,omitempty"`
+	KDF        string `json:"kdf,omitempty"`
+	Time       uint32 `json:"time,omitempty"`
+	Memory     uint32 `json:"memory,omitempty"`
+	Threads    uint8  `json:"threads,omitempty"`
+	Salt       string `json:"salt,omitempty"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// SealOutput encrypts output with the repository key or the key of an environment
+func SealOutput(data, key []byte) ([]byte, error) {
+	encrypted, err := EncryptFile(data, key)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSealed(SealedOutput{
+		Protection: SealedWithKey,
+		KeyID:      KeyID(key),
+		Ciphertext: base64.StdEncoding.EncodeToString(encrypted),
+	})
+}
+
+// SealOutputWithPassphrase encrypts output with a key derived from passphrase using Argon2id
+func SealOutputWithPassphrase(data, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase must not be empty")
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	encrypted, err := EncryptFile(data, argon2.IDKey(passphrase, salt, argon2Time, argon2Memory, argon2Threads, keySize))
+	if err != nil {
+		return nil, err
+	}
+	return marshalSealed(SealedOutput{
+		Protection: SealedWithPassphrase,
+		KDF:        "argon2id",
+		Time:       argon2Time,
+		Memory:     argon2Memory,
+		Threads:    argon2Threads,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Ciphertext: base64.StdEncoding.EncodeToString(encrypted),
+	})
+}
+
+// ParseSealedOutput decodes a sealed output file
+func ParseSealedOutput(data []byte) (*SealedOutput, error) {
+	var sealed SealedOutput
+	if err := json.Unmarshal(data, &sealed); err != nil || sealed.Format != sealedFormat {
+		return nil, fmt.Errorf("not an ez-env sealed output file")
+	}
+	if sealed.Version != 1 {
+		return nil, fmt.Errorf("unsupported sealed output version: %d", sealed.Version)
+	}
+	return &sealed, nil
+}
+
+// Open decrypts output sealed with the repository key or the key of an environment
+func (s *SealedOutput) Open(key []byte) ([]byte, error) {
+	if s.Protection != SealedWithKey {
+		return nil, fmt.Errorf("output is protected with a %s", s.Protection)
+	}
+	if KeyID(key) != s.KeyID {
+		return nil, fmt.Errorf("output was sealed with key %s, but the current key is %s", s.KeyID, KeyID(key))
+	}
+	return s.decrypt(key)
+}
+
+// OpenWithPassphrase decrypts output sealed with a passphrase
+func (s *SealedOutput) OpenWithPassphrase(passphrase []byte) ([]byte, error) {
+	if s.Protection != SealedWithPassphrase || s.KDF != "argon2id" {
+		return nil, fmt.Errorf("output is not protected with a passphrase")
+	}
+	salt, err := base64.StdEncoding.DecodeString(s.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode salt: %w", err)
+	}
+	data, err := s.decrypt(argon2.IDKey(passphrase, salt, s.Time, s.Memory, s.Threads, keySize))
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or corrupted output")
+	}
+	return data, nil
+}
+
+// decrypt decodes and decrypts the ciphertext
+func (s *SealedOutput) decrypt(key []byte) ([]byte, error) {
+	encrypted, err := base64.StdEncoding.DecodeString(s.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode sealed output: %w", err)
+	}
+	return DecryptFile(encrypted, key)
+}
+
+// marshalSealed fills in the format header and encodes sealed as canonical JSON
+func marshalSealed(sealed SealedOutput) ([]byte, error) {
+	sealed.Format = sealedFormat
+	sealed.Version = 1
+	data, err := canonical.Marshal(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sealed output: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,61 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealOutputWithKey(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	otherKey, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	report := []byte(`{"mode":"keyring"}`)
+	data, err := SealOutput(report, key)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "keyring")
+
+	sealed, err := ParseSealedOutput(data)
+	require.NoError(t, err)
+	assert.Equal(t, SealedWithKey, sealed.Protection)
+
+	opened, err := sealed.Open(key)
+	require.NoError(t, err)
+	assert.Equal(t, report, opened)
+
+	_, err = sealed.Open(otherKey)
+	assert.ErrorContains(t, err, "sealed with key")
+
+	_, err = sealed.OpenWithPassphrase([]byte("passphrase"))
+	assert.Error(t, err)
+}
+
+func TestSealOutputWithPassphrase(t *testing.T) {
+	report := []byte("audit log")
+	data, err := SealOutputWithPassphrase(report, []byte("correct horse battery staple"))
+	require.NoError(t, err)
+
+	sealed, err := ParseSealedOutput(data)
+	require.NoError(t, err)
+	assert.Equal(t, SealedWithPassphrase, sealed.Protection)
+
+	opened, err := sealed.OpenWithPassphrase([]byte("correct horse battery staple"))
+	require.NoError(t, err)
+	assert.Equal(t, report, opened)
+
+	_, err = sealed.OpenWithPassphrase([]byte("wrong"))
+	assert.ErrorContains(t, err, "wrong passphrase")
+
+	_, err = SealOutputWithPassphrase(report, nil)
+	assert.Error(t, err)
+}
+
+func TestParseSealedOutputRejectsOtherFiles(t *testing.T) {
+	for _, data := range []string{"not json", `{"mode":"keyring"}`} {
+		_, err := ParseSealedOutput([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
@@ -0,0 +1,92 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// redacted replaces key material wherever a SecureBytes is formatted
+const redacted = "[REDACTED]"
+
+// SecureBytes holds key material such as the repository key and data encryption keys
+// The buffer is locked into memory where the platform allows it, so it is never written to swap,
+// and Destroy wipes it. Formatting a SecureBytes with fmt or encoding it as JSON never reveals the
+// contents; use Bytes to hand the key to a cipher
+type SecureBytes struct {
+	buf    []byte
+	locked bool
+}
+
+// NewSecureBytes allocates a zeroed buffer of size bytes for key material
+func NewSecureBytes(size int) *SecureBytes {
+	s := &SecureBytes{buf: make([]byte, size)}
+	s.locked = lockMemory(s.buf) == nil
+	// A forgotten Destroy still wipes the key once the buffer is unreachable
+	runtime.SetFinalizer(s, (*SecureBytes).Destroy)
+	return s
+}
+
+// SecureBytesFrom moves b into a new SecureBytes and wipes b
+func SecureBytesFrom(b []byte) *SecureBytes {
+	s := NewSecureBytes(len(b))
+	copy(s.buf, b)
+	Wipe(b)
+	return s
+}
+
+// Bytes returns the key material; the slice is only valid until Destroy
+func (s *SecureBytes) Bytes() []byte {
+	if s == nil {
+		return nil
+	}
+	return s.buf
+}
+
+// Len returns the size of the key material
+func (s *SecureBytes) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.buf)
+}
+
+// Destroy wipes and unlocks the key material; it is safe to call more than once
+func (s *SecureBytes) Destroy() {
+	if s == nil || s.buf == nil {
+		return
+	}
+	Wipe(s.buf)
+	if s.locked {
+		unlockMemory(s.buf)
+	}
+	s.buf, s.locked = nil, false
+	runtime.SetFinalizer(s, nil)
+}
+
+// String keeps key material out of logs and error messages
+func (s *SecureBytes) String() string {
+	return redacted
+}
+
+// GoString keeps key material out of %#v output
+func (s *SecureBytes) GoString() string {
+	return redacted
+}
+
+// Format keeps key material out of every fmt verb, including %x and %s
+func (s *SecureBytes) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, redacted)
+}
+
+// MarshalJSON refuses to encode key material
+func (s *SecureBytes) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("refusing to encode key material")
+}
+
+// Wipe overwrites b with zeros
+func Wipe(b []byte) {
+	clear(b)
+	// Keep the writes from being optimized away as dead stores
+	runtime.KeepAlive(b)
+}
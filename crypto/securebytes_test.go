@@ -0,0 +1,70 @@
+package crypto
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecureBytesRedaction(t *testing.T) {
+	key := SecureBytesFrom([]byte("0123456789abcdef0123456789abcdef"))
+	defer key.Destroy()
+
+	tests := []struct {
+		name   string
+		format string
+	}{
+		{name: "value", format: "%v"},
+		{name: "string", format: "%s"},
+		{name: "hex", format: "%x"},
+		{name: "go syntax", format: "%#v"},
+		{name: "quoted", format: "%q"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, "[REDACTED]", fmt.Sprintf(tt.format, key))
+		})
+	}
+
+	wrapped := fmt.Errorf("failed with key %v", key)
+	assert.NotContains(t, wrapped.Error(), "0123456789")
+
+	_, err := json.Marshal(struct{ Key *SecureBytes }{key})
+	assert.Error(t, err)
+}
+
+func TestSecureBytesLifecycle(t *testing.T) {
+	source := []byte{1, 2, 3, 4}
+	key := SecureBytesFrom(source)
+	assert.Equal(t, []byte{0, 0, 0, 0}, source, "the source is wiped")
+	assert.Equal(t, []byte{1, 2, 3, 4}, key.Bytes())
+	assert.Equal(t, 4, key.Len())
+
+	buf := key.Bytes()
+	key.Destroy()
+	assert.Equal(t, []byte{0, 0, 0, 0}, buf, "Destroy wipes the buffer")
+	assert.Nil(t, key.Bytes())
+	assert.Equal(t, 0, key.Len())
+	key.Destroy()
+
+	var missing *SecureBytes
+	assert.Nil(t, missing.Bytes())
+	missing.Destroy()
+}
+
+func TestEncryptionWithSecureKey(t *testing.T) {
+	raw, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	key := SecureBytesFrom(raw)
+	defer key.Destroy()
+
+	encrypted, err := EncryptFileWithOptions([]byte("SECRET=1\n"), key.Bytes(), EncryptOptions{Path: ".env"})
+	require.NoError(t, err)
+	// Encrypting must not wipe the caller's key along with its own intermediate keys
+	plaintext, err := DecryptFileAt(encrypted, key.Bytes(), ".env")
+	require.NoError(t, err)
+	assert.Equal(t, "SECRET=1\n", string(plaintext))
+}
@@ -0,0 +1,236 @@
+package crypto
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SessionTimeout is how long the session agent of a repository keeps retrieved keys after their
+// last use, so the filters git runs for one operation retrieve the key once; zero disables it
+var SessionTimeout = time.Minute
+
+// sessionMaxLifetime bounds how long an agent serves keys however often they are used, so a
+// revoked key is retrieved again eventually
+const sessionMaxLifetime = 15 * time.Minute
+
+// errNoSession is returned when no session agent holds the requested key
+var errNoSession = errors.New("no session key")
+
+// loadSessionKey returns the key called name from the repository's session agent
+func loadSessionKey(name string) ([]byte, error) {
+	if SessionTimeout <= 0 || !sessionSupported {
+		return nil, errNoSession
+	}
+	socket, err := sessionSocket()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := sessionRequest(socket, "get "+name)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := strings.CutPrefix(reply, "key ")
+	if !ok {
+		return nil, errNoSession
+	}
+	key, err := hex.DecodeString(value)
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("the session agent returned an invalid key")
+	}
+	return key, nil
+}
+
+// cacheInSession hands the key called name to the repository's session agent, starting one when
+// none is running; the key is only cached, so failing to cache it is not an error
+func cacheInSession(name string, key []byte) {
+	if SessionTimeout <= 0 || !sessionSupported {
+		return
+	}
+	socket, err := sessionSocket()
+	if err != nil {
+		return
+	}
+	request := "put " + hex.EncodeToString(key) + " " + name
+	if _, err := sessionRequest(socket, request); err == nil {
+		return
+	}
+	if err := startSessionAgent(socket); err != nil {
+		return
+	}
+	sessionRequest(socket, request)
+}
+
+// ForgetSession stops the repository's session agent, wiping the keys it holds, and reports
+// whether one was running
+func ForgetSession() (bool, error) {
+	if !sessionSupported {
+		return false, nil
+	}
+	socket, err := sessionSocket()
+	if err != nil {
+		return false, err
+	}
+	if _, err := sessionRequest(socket, "forget"); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// sessionSocket returns the socket of the current repository's session agent, in a directory
+// only the user can enter; the name is derived from the git directory so worktrees share it
+func sessionSocket() (string, error) {
+	gitDir, err := GitDir()
+	if err != nil {
+		return "", err
+	}
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	if base == "" {
+		base = os.TempDir()
+	}
+	// Socket paths are limited to about 100 bytes, so the directory is short and the name a hash
+	dir := filepath.Join(base, fmt.Sprintf("ezenv-%d", os.Getuid()))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create session directory: %w", err)
+	}
+	if err := checkPrivateDir(dir); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(gitDir))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".sock"), nil
+}
+
+// sessionRequest sends one request line to the agent listening on socket and returns its reply
+func sessionRequest(socket, request string) (string, error) {
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := fmt.Fprintln(conn, request); err != nil {
+		return "", err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(reply, "\n"), nil
+}
+
+// startSessionAgent starts an agent on socket in the background and waits until it listens
+func startSessionAgent(socket string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, "session-agent", socket, SessionTimeout.String())
+	// The agent must not hold on to the output git reads from the filter, or git waits for it
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer cmd.Process.Release()
+	ready, err := bufio.NewReader(stdout).ReadString('\n')
+	stdout.Close()
+	if err != nil || ready != "ready\n" {
+		return fmt.Errorf("the session agent did not start")
+	}
+	return nil
+}
+
+// RunSessionAgent serves keys on socket until none was requested for timeout, or for at most
+// sessionMaxLifetime; ready is called once it listens
+// The keys only ever live in the agent's memory
+func RunSessionAgent(socket string, timeout time.Duration, ready func()) error {
+	if err := checkPrivateDir(filepath.Dir(socket)); err != nil {
+		return err
+	}
+	// A socket left behind by an agent that died is replaced; a live agent keeps its socket
+	if _, err := sessionRequest(socket, "ping"); err == nil {
+		return fmt.Errorf("a session agent is already running on %s", socket)
+	}
+	os.Remove(socket)
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", socket, err)
+	}
+	defer listener.Close()
+	ready()
+
+	keys := make(map[string]*SecureBytes)
+	defer func() {
+		for _, key := range keys {
+			key.Destroy()
+		}
+	}()
+	end := time.Now().Add(sessionMaxLifetime)
+	for {
+		deadline := time.Now().Add(timeout)
+		if deadline.After(end) {
+			deadline = end
+		}
+		listener.SetDeadline(deadline)
+		conn, err := listener.Accept()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to accept session request: %w", err)
+		}
+		if forget := serveSessionRequest(conn, keys); forget {
+			return nil
+		}
+	}
+}
+
+// serveSessionRequest answers one request and reports whether the agent was told to forget
+func serveSessionRequest(conn net.Conn, keys map[string]*SecureBytes) bool {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	command, argument, _ := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+	reply := "ok"
+	switch command {
+	case "get":
+		if key, ok := keys[argument]; ok {
+			reply = "key " + hex.EncodeToString(key.Bytes())
+		} else {
+			reply = "none"
+		}
+	case "put":
+		value, name, _ := strings.Cut(argument, " ")
+		key, err := hex.DecodeString(value)
+		if err != nil || len(key) != keySize || name == "" {
+			reply = "error invalid key"
+			break
+		}
+		if old, ok := keys[name]; ok {
+			old.Destroy()
+		}
+		keys[name] = SecureBytesFrom(key)
+	case "ping":
+	case "forget":
+		fmt.Fprintln(conn, reply)
+		return true
+	default:
+		reply = "error unknown command"
+	}
+	fmt.Fprintln(conn, reply)
+	return false
+}
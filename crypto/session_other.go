@@ -0,0 +1,20 @@
+//go:build !linux && !darwin
+
+package crypto
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// sessionSupported reports whether session agents can run on this platform; without private
+// directories for their sockets they are not, and filters retrieve the key themselves
+const sessionSupported = false
+
+// checkPrivateDir is not supported on this platform
+func checkPrivateDir(dir string) error {
+	return errors.New("session agents are not supported on this platform")
+}
+
+// detach is a no-op where session agents are not supported
+func detach(cmd *exec.Cmd) {}
@@ -0,0 +1,111 @@
+//go:build linux || darwin
+
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestAgent runs a session agent on a socket in a private temporary directory
+func startTestAgent(t *testing.T, timeout time.Duration) (string, chan error) {
+	t.Helper()
+	// Temporary directories of tests can exceed the socket path limit on macOS
+	dir, err := os.MkdirTemp("", "ezenv")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "agent.sock")
+
+	ready := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- RunSessionAgent(socket, timeout, func() { close(ready) }) }()
+	select {
+	case <-ready:
+	case err := <-done:
+		t.Fatalf("agent did not start: %v", err)
+	}
+	return socket, done
+}
+
+func TestSessionAgent(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	socket, done := startTestAgent(t, time.Minute)
+
+	tests := []struct {
+		name    string
+		request string
+		reply   string
+	}{
+		{name: "missing key", request: "get repository", reply: "none"},
+		{name: "store key", request: "put " + hex.EncodeToString(key) + " repository", reply: "ok"},
+		{name: "stored key", request: "get repository", reply: "key " + hex.EncodeToString(key)},
+		{name: "keys are stored by name", request: "get environment production", reply: "none"},
+		{name: "store key with spaces in its name", request: "put " + hex.EncodeToString(key) + " environment production", reply: "ok"},
+		{name: "stored key with spaces in its name", request: "get environment production", reply: "key " + hex.EncodeToString(key)},
+		{name: "invalid key size", request: "put abcd repository", reply: "error invalid key"},
+		{name: "invalid key kept the stored one", request: "get repository", reply: "key " + hex.EncodeToString(key)},
+		{name: "unknown command", request: "list", reply: "error unknown command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := sessionRequest(socket, tt.request)
+			require.NoError(t, err)
+			assert.Equal(t, tt.reply, reply)
+		})
+	}
+
+	t.Run("a second agent does not take over the socket", func(t *testing.T) {
+		err := RunSessionAgent(socket, time.Minute, func() {})
+		assert.ErrorContains(t, err, "already running")
+	})
+
+	t.Run("forget stops the agent", func(t *testing.T) {
+		reply, err := sessionRequest(socket, "forget")
+		require.NoError(t, err)
+		assert.Equal(t, "ok", reply)
+		require.NoError(t, <-done)
+		_, err = sessionRequest(socket, "get repository")
+		assert.Error(t, err)
+	})
+}
+
+func TestSessionAgentTimeout(t *testing.T) {
+	socket, done := startTestAgent(t, 200*time.Millisecond)
+
+	// Requests keep the agent alive past the timeout
+	for range 3 {
+		time.Sleep(100 * time.Millisecond)
+		_, err := sessionRequest(socket, "ping")
+		require.NoError(t, err)
+	}
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not stop after the timeout")
+	}
+	_, err := os.Stat(socket)
+	assert.True(t, os.IsNotExist(err), "the socket is removed")
+}
+
+func TestCheckPrivateDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Chmod(dir, 0700))
+	assert.NoError(t, checkPrivateDir(dir))
+
+	require.NoError(t, os.Chmod(dir, 0755))
+	assert.Error(t, checkPrivateDir(dir))
+
+	link := filepath.Join(t.TempDir(), "link")
+	require.NoError(t, os.Chmod(dir, 0700))
+	require.NoError(t, os.Symlink(dir, link))
+	assert.Error(t, checkPrivateDir(link))
+}
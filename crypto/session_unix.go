@@ -0,0 +1,32 @@
+//go:build linux || darwin
+
+package crypto
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// sessionSupported reports whether session agents can run on this platform
+const sessionSupported = true
+
+// checkPrivateDir verifies that dir is a directory of the user that nobody else can enter, so
+// nobody else can connect to the sockets in it
+func checkPrivateDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || int(stat.Uid) != os.Getuid() || info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s is not a private directory of this user", dir)
+	}
+	return nil
+}
+
+// detach starts cmd in its own session, so it outlives the git command that started the filter
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
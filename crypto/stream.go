@@ -0,0 +1,375 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// Chunked envelopes encrypt the content in independently sealed chunks, so files of any size are
+// encrypted and decrypted in constant memory. The header records the chunk size (fieldChunking) and
+// is followed directly by the chunks, each sealed with the file key under the nonce
+// [counter(11)][last(1)] and the content fields of the header as associated data. Only the last
+// chunk has last=1, so reordered, dropped or truncated chunks fail to authenticate. Every chunk but
+// the last holds exactly the chunk size of plaintext; the last may be shorter or empty.
+//
+// Counter nonces are safe because every file has its own random file key, which is why chunked
+// files are never deterministic. They are not padded and carry no plaintext MAC either, since both
+// need the whole content before the header is written.
+
+const (
+	// DefaultChunkSize is the chunk size of files encrypted without an explicit one
+	DefaultChunkSize = 64 * 1024
+	maxChunkSize     = 16 << 20
+
+	// headerPrefixSize is the size of the magic, version and header length before the fields
+	headerPrefixSize = 5 + 1 + 2
+)
+
+// chunkSize returns the chunk size content of size bytes is encrypted with, or zero when it is
+// sealed whole
+func (opts EncryptOptions) chunkSize(size int64) int {
+	switch {
+	case opts.ChunkSize > 0:
+		return opts.ChunkSize
+	case opts.ChunkAbove > 0 && size > opts.ChunkAbove:
+		return DefaultChunkSize
+	}
+	return 0
+}
+
+// NewEncryptWriter returns a writer that encrypts what is written to it into w, holding at most one
+// chunk in memory; Close writes the last chunk and must be called. The envelope format is always
+// chunked, with DefaultChunkSize unless opts.ChunkSize is set; FormatAge streams age ciphertext
+func NewEncryptWriter(w io.Writer, key []byte, opts EncryptOptions) (io.WriteCloser, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid key size: expected %d, got %d", keySize, len(key))
+	}
+	switch opts.Format {
+	case "", FormatEnvelope:
+	case FormatAge:
+		if err := opts.checkAge(); err != nil {
+			return nil, err
+		}
+		identity, err := ageIdentity(key)
+		if err != nil {
+			return nil, err
+		}
+		encrypter, err := age.Encrypt(w, identity.Recipient())
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt: %w", err)
+		}
+		return encrypter, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q (expected %s)", opts.Format, strings.Join(Formats, " or "))
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if chunkSize < 0 || chunkSize > maxChunkSize {
+		return nil, fmt.Errorf("invalid chunk size: %d", chunkSize)
+	}
+
+	fields := optionFields(key, opts)
+	// Padding is meant for small files and needs their length up front
+	delete(fields, fieldPadding)
+	fields[fieldChunking] = binary.BigEndian.AppendUint32(nil, uint32(chunkSize))
+	fileKey, err := newFileKey(key, nil, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(fileKey)
+	if fields[fieldFileKey], err = wrapFileKey(fileKey, key, fields); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(encodeHeader(fields)); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+	return &chunkWriter{
+		w:      w,
+		gcm:    gcm,
+		aad:    encodeHeader(contentFields(fields)),
+		buf:    make([]byte, 0, chunkSize),
+		sealed: make([]byte, 0, chunkSize+tagSize),
+	}, nil
+}
+
+// chunkWriter seals the content written to it a chunk at a time
+type chunkWriter struct {
+	w       io.Writer
+	gcm     cipher.AEAD
+	aad     []byte
+	buf     []byte
+	sealed  []byte
+	counter uint64
+	closed  bool
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	if c.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is sealed only once more content follows, so Close always seals the last one
+		if len(c.buf) == cap(c.buf) {
+			if err := c.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(c.buf[len(c.buf):cap(c.buf)], p)
+		c.buf = c.buf[:len(c.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the last chunk
+func (c *chunkWriter) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.seal(true)
+}
+
+// seal encrypts the buffered chunk and writes it out
+func (c *chunkWriter) seal(last bool) error {
+	c.sealed = c.gcm.Seal(c.sealed[:0], chunkNonce(c.counter, last), c.buf, c.aad)
+	Wipe(c.buf)
+	c.buf = c.buf[:0]
+	c.counter++
+	if _, err := c.w.Write(c.sealed); err != nil {
+		return fmt.Errorf("failed to write encrypted chunk: %w", err)
+	}
+	return nil
+}
+
+// chunkNonce returns the nonce of chunk number counter
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.BigEndian.PutUint64(nonce[nonceSize-9:nonceSize-1], counter)
+	if last {
+		nonce[nonceSize-1] = 1
+	}
+	return nonce
+}
+
+// IsChunked reports whether data starts with the complete header of a chunked envelope
+func IsChunked(data []byte) bool {
+	_, fields, err := decodeHeader(data)
+	if err != nil {
+		return false
+	}
+	_, ok := fields[fieldChunking]
+	return ok
+}
+
+// NewDecryptReader returns a reader of the plaintext of the encrypted file read from r, checking a
+// bound path like DecryptFileAt. Chunked envelopes and age files are decrypted a chunk at a time,
+// so content read before an error must be discarded; other formats are decrypted whole up front
+func NewDecryptReader(r io.Reader, key []byte, path string) (io.Reader, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid key size: expected %d, got %d", keySize, len(key))
+	}
+	br := bufio.NewReaderSize(r, headerPrefixSize+0xffff)
+	if fields, ok := readChunkedHeader(br); ok {
+		return newChunkReader(br, key, fields, path)
+	}
+	if prefix, _ := br.Peek(len(ageHeader)); isAgeFile(prefix) {
+		return newAgeReader(br, key)
+	}
+
+	encrypted, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := DecryptFileAt(encrypted, key, path)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(plaintext), nil
+}
+
+// readChunkedHeader consumes the header of a chunked envelope from br, whose buffer must fit the
+// largest header, and returns its fields; anything else is left unread
+func readChunkedHeader(br *bufio.Reader) (map[byte][]byte, bool) {
+	prefix, _ := br.Peek(headerPrefixSize)
+	if !isEnvelope(prefix) {
+		return nil, false
+	}
+	headerLen := headerPrefixSize + int(binary.BigEndian.Uint16(prefix[headerPrefixSize-2:]))
+	data, err := br.Peek(headerLen)
+	if err != nil || !IsChunked(data) {
+		return nil, false
+	}
+	// The fields point into the header, which must outlive the buffer it was peeked from
+	_, fields, _ := decodeHeader(bytes.Clone(data))
+	br.Discard(headerLen)
+	return fields, true
+}
+
+// PlaintextMatcher compares the plaintext written to it with the content of a chunked file, so
+// clean can keep a large staged file that did not change, as MatchesPlaintext does for small ones
+type PlaintextMatcher struct {
+	plaintext io.Reader
+	buf       []byte
+	matches   bool
+}
+
+// NewPlaintextMatcher returns a matcher of the chunked envelope read from encrypted, which only
+// matches when it was encrypted with key and the header options of opts
+func NewPlaintextMatcher(encrypted io.Reader, key []byte, opts EncryptOptions) *PlaintextMatcher {
+	m := &PlaintextMatcher{}
+	if opts.Format == FormatAge || len(key) != keySize {
+		return m
+	}
+	br := bufio.NewReaderSize(encrypted, headerPrefixSize+0xffff)
+	fields, ok := readChunkedHeader(br)
+	if !ok {
+		return m
+	}
+	options := maps.Clone(fields)
+	for _, fieldType := range []byte{fieldFileKey, fieldChunking, fieldPlaintextMAC} {
+		delete(options, fieldType)
+	}
+	expected := optionFields(key, opts)
+	delete(expected, fieldPadding)
+	if !bytes.Equal(encodeHeader(options), encodeHeader(expected)) {
+		return m
+	}
+	plaintext, err := newChunkReader(br, key, fields, "")
+	if err != nil {
+		return m
+	}
+	m.plaintext, m.matches = plaintext, true
+	return m
+}
+
+// Write compares p with the next plaintext of the file; it never fails
+func (m *PlaintextMatcher) Write(p []byte) (int, error) {
+	if !m.matches {
+		return len(p), nil
+	}
+	if cap(m.buf) < len(p) {
+		m.buf = make([]byte, len(p))
+	}
+	buf := m.buf[:len(p)]
+	if _, err := io.ReadFull(m.plaintext, buf); err != nil || !bytes.Equal(buf, p) {
+		m.matches = false
+	}
+	Wipe(buf)
+	return len(p), nil
+}
+
+// Matches reports whether everything written matched the whole plaintext of the file
+func (m *PlaintextMatcher) Matches() bool {
+	if !m.matches {
+		return false
+	}
+	var rest [1]byte
+	n, err := m.plaintext.Read(rest[:])
+	for n == 0 && err == nil {
+		n, err = m.plaintext.Read(rest[:])
+	}
+	return n == 0 && err == io.EOF
+}
+
+// newChunkReader returns a reader of the plaintext of the chunks read from r, which follow an
+// envelope header with fields; a non-empty path must match a bound path
+func newChunkReader(r io.Reader, key []byte, fields map[byte][]byte, path string) (io.Reader, error) {
+	if bound, ok := fields[fieldPath]; ok && path != "" && string(bound) != path {
+		return nil, fmt.Errorf("%s was %w (%s)", path, ErrPathMismatch, bound)
+	}
+	if _, ok := fields[fieldFileKey]; !ok {
+		return nil, fmt.Errorf("invalid chunked envelope: no file key")
+	}
+	fileKey, err := unwrapFileKey(key, fields)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(fileKey)
+	gcm, err := newGCM(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	chunkSize := int(binary.BigEndian.Uint32(fields[fieldChunking]))
+	return &chunkReader{
+		r:      bufio.NewReader(r),
+		gcm:    gcm,
+		aad:    encodeHeader(contentFields(fields)),
+		sealed: make([]byte, chunkSize+tagSize),
+	}, nil
+}
+
+// chunkReader opens the chunks read from r one at a time
+type chunkReader struct {
+	r       *bufio.Reader
+	gcm     cipher.AEAD
+	aad     []byte
+	sealed  []byte
+	opened  []byte
+	plain   []byte
+	counter uint64
+	done    bool
+	err     error
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.plain) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.err = c.next()
+	}
+	n := copy(p, c.plain)
+	c.plain = c.plain[n:]
+	return n, nil
+}
+
+// next opens the next chunk, returning io.EOF after the last one
+func (c *chunkReader) next() error {
+	if c.done {
+		return io.EOF
+	}
+	n, err := io.ReadFull(c.r, c.sealed)
+	last := false
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		// Only the last chunk is shorter than the others
+		last = true
+	case err != nil:
+		return err
+	default:
+		if _, err := c.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if n < tagSize {
+		return fmt.Errorf("failed to decrypt: %w: truncated chunk", ErrAuthentication)
+	}
+	opened, err := c.gcm.Open(c.opened[:0], chunkNonce(c.counter, last), c.sealed[:n], c.aad)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt chunk %d: %w", c.counter, ErrAuthentication)
+	}
+	c.opened, c.plain = opened, opened
+	c.counter++
+	c.done = last
+	return nil
+}
@@ -0,0 +1,214 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encryptStream encrypts plaintext through NewEncryptWriter, writing it in pieces of step bytes
+func encryptStream(t *testing.T, plaintext, key []byte, opts EncryptOptions, step int) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := NewEncryptWriter(&out, key, opts)
+	require.NoError(t, err)
+	for rest := plaintext; len(rest) > 0; {
+		n := min(step, len(rest))
+		_, err := w.Write(rest[:n])
+		require.NoError(t, err)
+		rest = rest[n:]
+	}
+	require.NoError(t, w.Close())
+	return out.Bytes()
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	const chunk = 16
+
+	tests := []struct {
+		name string
+		size int
+		opts EncryptOptions
+	}{
+		{name: "empty content", size: 0, opts: EncryptOptions{ChunkSize: chunk}},
+		{name: "partial chunk", size: chunk - 1, opts: EncryptOptions{ChunkSize: chunk}},
+		{name: "exactly one chunk", size: chunk, opts: EncryptOptions{ChunkSize: chunk}},
+		{name: "one byte past a chunk", size: chunk + 1, opts: EncryptOptions{ChunkSize: chunk}},
+		{name: "many chunks", size: 10*chunk + 3, opts: EncryptOptions{ChunkSize: chunk, Path: "data/dump.sqlite"}},
+		{name: "default chunk size", size: 3*DefaultChunkSize + 5},
+		{name: "age format", size: 100000, opts: EncryptOptions{Format: FormatAge}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext := bytes.Repeat([]byte("0123456789abcdef-"), tt.size/17+1)[:tt.size]
+			encrypted := encryptStream(t, plaintext, key, tt.opts, 7)
+			assert.True(t, IsEncryptedFile(encrypted))
+			assert.Equal(t, tt.opts.Format != FormatAge, IsChunked(encrypted))
+
+			r, err := NewDecryptReader(bytes.NewReader(encrypted), key, tt.opts.Path)
+			require.NoError(t, err)
+			decrypted, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(plaintext, decrypted))
+
+			// Whole-file decryption reads the chunked format as well
+			decrypted, err = DecryptFile(encrypted, key)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(plaintext, decrypted))
+		})
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	const chunk = 16
+	plaintext := bytes.Repeat([]byte("A"), 3*chunk)
+	encrypted := encryptStream(t, plaintext, key, EncryptOptions{ChunkSize: chunk, Path: "big.db"}, chunk)
+	header, _, err := decodeHeader(encrypted)
+	require.NoError(t, err)
+	sealed := chunk + tagSize
+	body := encrypted[len(header):]
+	require.Len(t, body, 3*sealed)
+
+	swapped := append(append(append(append([]byte{}, header...), body[sealed:2*sealed]...), body[:sealed]...), body[2*sealed:]...)
+	flipped := append([]byte{}, encrypted...)
+	flipped[len(flipped)-1] ^= 0x01
+
+	tests := []struct {
+		name      string
+		encrypted []byte
+		path      string
+		expectErr string
+	}{
+		{name: "dropped last chunk", encrypted: encrypted[:len(encrypted)-sealed], expectErr: "failed to decrypt"},
+		{name: "truncated chunk", encrypted: encrypted[:len(encrypted)-5], expectErr: "failed to decrypt"},
+		{name: "swapped chunks", encrypted: swapped, expectErr: "failed to decrypt"},
+		{name: "flipped bit", encrypted: flipped, expectErr: "failed to decrypt"},
+		{name: "appended chunk", encrypted: append(append([]byte{}, encrypted...), body[:sealed]...), expectErr: "failed to decrypt"},
+		{name: "header only", encrypted: header, expectErr: "truncated chunk"},
+		{name: "other path", encrypted: encrypted, path: "other.db", expectErr: "encrypted for a different path"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewDecryptReader(bytes.NewReader(tt.encrypted), key, tt.path)
+			if err == nil {
+				_, err = io.ReadAll(r)
+			}
+			assert.ErrorContains(t, err, tt.expectErr)
+
+			_, err = DecryptFileAt(tt.encrypted, key, tt.path)
+			assert.ErrorContains(t, err, tt.expectErr)
+		})
+	}
+}
+
+func TestChunkAbove(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	opts := EncryptOptions{ChunkAbove: 100, PaddingBucket: 64, Deterministic: true}
+
+	small, err := EncryptFileWithOptions(bytes.Repeat([]byte("A"), 100), key, opts)
+	require.NoError(t, err)
+	assert.False(t, IsChunked(small))
+
+	// Large content is chunked, which leaves out padding and deterministic mode
+	large, err := EncryptFileWithOptions(bytes.Repeat([]byte("A"), 101), key, opts)
+	require.NoError(t, err)
+	assert.True(t, IsChunked(large))
+	_, fields, err := decodeHeader(large)
+	require.NoError(t, err)
+	assert.NotContains(t, fields, fieldPadding)
+	again, err := EncryptFileWithOptions(bytes.Repeat([]byte("A"), 101), key, opts)
+	require.NoError(t, err)
+	assert.NotEqual(t, large, again)
+
+	decrypted, err := DecryptFile(large, key)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("A"), 101), decrypted)
+}
+
+func TestRewrapChunkedFile(t *testing.T) {
+	oldKey, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	newKey, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	plaintext := bytes.Repeat([]byte("row\n"), 100)
+
+	encrypted, err := EncryptFileWithOptions(plaintext, oldKey, EncryptOptions{ChunkSize: 64})
+	require.NoError(t, err)
+	rewrapped, err := RewrapFile(encrypted, oldKey, newKey)
+	require.NoError(t, err)
+	decrypted, err := DecryptFile(rewrapped, newKey)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+}
+
+func TestNewDecryptReaderWholeFormats(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+
+	envelope, err := EncryptFileWithOptions([]byte("SECRET=1\n"), key, EncryptOptions{PaddingBucket: 64})
+	require.NoError(t, err)
+	r, err := NewDecryptReader(bytes.NewReader(envelope), key, "")
+	require.NoError(t, err)
+	decrypted, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "SECRET=1\n", string(decrypted))
+
+	otherKey, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	_, err = NewDecryptReader(bytes.NewReader(envelope), otherKey, "")
+	assert.ErrorIs(t, err, ErrAuthentication)
+}
+
+func TestPlaintextMatcher(t *testing.T) {
+	key, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	otherKey, err := GenerateEncryptionKey()
+	require.NoError(t, err)
+	opts := EncryptOptions{ChunkSize: 16, Path: "big.db"}
+	plaintext := bytes.Repeat([]byte("0123456789"), 10)
+	encrypted := encryptStream(t, plaintext, key, opts, 16)
+	whole, err := EncryptFileWithOptions(plaintext, key, EncryptOptions{Path: "big.db"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		encrypted []byte
+		plaintext []byte
+		key       []byte
+		opts      EncryptOptions
+		matches   bool
+	}{
+		{name: "same plaintext", encrypted: encrypted, plaintext: plaintext, key: key, opts: opts, matches: true},
+		{name: "changed byte", encrypted: encrypted, plaintext: append(bytes.Clone(plaintext[:99]), 'x'), key: key, opts: opts},
+		{name: "shorter plaintext", encrypted: encrypted, plaintext: plaintext[:99], key: key, opts: opts},
+		{name: "longer plaintext", encrypted: encrypted, plaintext: append(bytes.Clone(plaintext), 'x'), key: key, opts: opts},
+		{name: "other key", encrypted: encrypted, plaintext: plaintext, key: otherKey, opts: opts},
+		{name: "other path", encrypted: encrypted, plaintext: plaintext, key: key, opts: EncryptOptions{ChunkSize: 16, Path: "other.db"}},
+		{name: "file that is not chunked", encrypted: whole, plaintext: plaintext, key: key, opts: opts},
+		{name: "no file", encrypted: nil, plaintext: plaintext, key: key, opts: opts},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher := NewPlaintextMatcher(bytes.NewReader(tt.encrypted), tt.key, tt.opts)
+			for rest := tt.plaintext; len(rest) > 0; {
+				n := min(7, len(rest))
+				written, err := matcher.Write(rest[:n])
+				require.NoError(t, err)
+				assert.Equal(t, n, written)
+				rest = rest[n:]
+			}
+			assert.Equal(t, tt.matches, matcher.Matches())
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package dotenv
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Line is a single line of a dotenv file
+// Comments and blank lines have an empty Key and are preserved verbatim
+type Line struct {
+	Key   string
+	Value string
+	Raw   string
+}
+
+// File is a parsed dotenv file that preserves ordering, comments and formatting
+type File struct {
+	Lines []Line
+}
+
+// Parse parses dotenv content into a File
+// Lines that are not KEY=VALUE assignments are kept as raw lines
+func Parse(data []byte) *File {
+	content := strings.ReplaceAll(string(data), "\r\n", "\n")
+	content = strings.TrimSuffix(content, "\n")
+
+	f := &File{}
+	if content == "" {
+		return f
+	}
+
+	for _, raw := range strings.Split(content, "\n") {
+		key, value, ok := parseLine(raw)
+		if !ok {
+			f.Lines = append(f.Lines, Line{Raw: raw})
+			continue
+		}
+		f.Lines = append(f.Lines, Line{Key: key, Value: value, Raw: raw})
+	}
+
+	return f
+}
+
+// parseLine parses a single KEY=VALUE line, with an optional "export " prefix
+func parseLine(raw string) (string, string, bool) {
+	line := strings.TrimSpace(raw)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false
+	}
+	line = strings.TrimPrefix(line, "export ")
+
+	eq := strings.Index(line, "=")
+	if eq <= 0 {
+		return "", "", false
+	}
+
+	key := strings.TrimSpace(line[:eq])
+	if !isValidKey(key) {
+		return "", "", false
+	}
+
+	return key, parseValue(strings.TrimSpace(line[eq+1:])), true
+}
+
+// parseValue unquotes a value and strips trailing comments from unquoted values
+func parseValue(value string) string {
+	if len(value) >= 2 {
+		switch value[0] {
+		case '\'':
+			if end := strings.LastIndex(value, "'"); end > 0 {
+				return value[1:end]
+			}
+		case '"':
+			if end := strings.LastIndex(value, "\""); end > 0 {
+				return unescape(value[1:end])
+			}
+		}
+	}
+
+	if idx := strings.Index(value, " #"); idx >= 0 {
+		value = value[:idx]
+	}
+	return strings.TrimSpace(value)
+}
+
+// unescape expands the escape sequences allowed in double-quoted values
+func unescape(value string) string {
+	replacer := strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\t`, "\t", `\"`, `"`, `\\`, `\`)
+	return replacer.Replace(value)
+}
+
+// isValidKey reports whether key is a valid environment variable name
+func isValidKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i, r := range key {
+		switch {
+		case r == '_' || r == '.' || r == '-':
+		case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Keys returns the variable names in the order they appear in the file
+func (f *File) Keys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, line := range f.Lines {
+		if line.Key != "" && !seen[line.Key] {
+			seen[line.Key] = true
+			keys = append(keys, line.Key)
+		}
+	}
+	return keys
+}
+
+// Get returns the value of key; when a key is assigned more than once the last assignment wins
+func (f *File) Get(key string) (string, bool) {
+	for i := len(f.Lines) - 1; i >= 0; i-- {
+		if f.Lines[i].Key == key {
+			return f.Lines[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// Map returns all variables as a map
+func (f *File) Map() map[string]string {
+	vars := make(map[string]string)
+	for _, line := range f.Lines {
+		if line.Key != "" {
+			vars[line.Key] = line.Value
+		}
+	}
+	return vars
+}
+
+// Set updates the value of key in place, or appends a new assignment
+func (f *File) Set(key, value string) {
+	raw := fmt.Sprintf("%s=%s", key, Quote(value))
+	for i := range f.Lines {
+		if f.Lines[i].Key == key {
+			if f.Lines[i].Value != value {
+				f.Lines[i] = Line{Key: key, Value: value, Raw: raw}
+			}
+			return
+		}
+	}
+	f.Lines = append(f.Lines, Line{Key: key, Value: value, Raw: raw})
+}
+
+// Delete removes every assignment of key
+func (f *File) Delete(key string) {
+	lines := f.Lines[:0]
+	for _, line := range f.Lines {
+		if line.Key != key {
+			lines = append(lines, line)
+		}
+	}
+	f.Lines = lines
+}
+
+// Bytes renders the file back to dotenv content
+func (f *File) Bytes() []byte {
+	if len(f.Lines) == 0 {
+		return nil
+	}
+
+	var b strings.Builder
+	for _, line := range f.Lines {
+		b.WriteString(line.Raw)
+		b.WriteString("\n")
+	}
+	return []byte(b.String())
+}
+
+// Quote returns value quoted as needed so that it parses back unchanged
+func Quote(value string) string {
+	if value == "" {
+		return ""
+	}
+	if !strings.ContainsAny(value, " \t\r\n\"'#\\$`") {
+		return value
+	}
+	if !strings.ContainsAny(value, "'\r\n") {
+		return "'" + value + "'"
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + replacer.Replace(value) + `"`
+}
@@ -0,0 +1,110 @@
+package dotenv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected map[string]string
+	}{
+		{
+			name:     "parses simple assignments",
+			content:  "FOO=bar\nBAZ=qux\n",
+			expected: map[string]string{"FOO": "bar", "BAZ": "qux"},
+		},
+		{
+			name:     "skips comments and blank lines",
+			content:  "# comment\n\nFOO=bar\n",
+			expected: map[string]string{"FOO": "bar"},
+		},
+		{
+			name:     "handles export prefix",
+			content:  "export FOO=bar\n",
+			expected: map[string]string{"FOO": "bar"},
+		},
+		{
+			name:     "handles quoted values",
+			content:  "A='single # quoted'\nB=\"double\\nquoted\"\n",
+			expected: map[string]string{"A": "single # quoted", "B": "double\nquoted"},
+		},
+		{
+			name:     "strips inline comments from unquoted values",
+			content:  "FOO=bar # comment\n",
+			expected: map[string]string{"FOO": "bar"},
+		},
+		{
+			name:     "handles CRLF line endings",
+			content:  "FOO=bar\r\nBAZ=qux\r\n",
+			expected: map[string]string{"FOO": "bar", "BAZ": "qux"},
+		},
+		{
+			name:     "handles empty values",
+			content:  "FOO=\n",
+			expected: map[string]string{"FOO": ""},
+		},
+		{
+			name:     "ignores invalid lines",
+			content:  "not an assignment\n=value\n1FOO=bar\n",
+			expected: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Parse([]byte(tt.content))
+			assert.Equal(t, tt.expected, f.Map())
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	content := "# database\nDB_HOST=localhost\nexport DB_PASS='s3cr3t'\n\nAPI_KEY=abc # inline\n"
+
+	f := Parse([]byte(content))
+	assert.Equal(t, content, string(f.Bytes()), "unchanged files should render byte-for-byte")
+	assert.Equal(t, []string{"DB_HOST", "DB_PASS", "API_KEY"}, f.Keys())
+}
+
+func TestSetAndDelete(t *testing.T) {
+	f := Parse([]byte("# header\nFOO=bar\nBAZ=qux\n"))
+
+	f.Set("FOO", "new value")
+	f.Set("NEW", "value")
+	f.Delete("BAZ")
+
+	assert.Equal(t, "# header\nFOO='new value'\nNEW=value\n", string(f.Bytes()))
+
+	value, ok := f.Get("FOO")
+	assert.True(t, ok)
+	assert.Equal(t, "new value", value)
+
+	_, ok = f.Get("BAZ")
+	assert.False(t, ok)
+}
+
+func TestQuote(t *testing.T) {
+	values := []string{
+		"",
+		"simple",
+		"with space",
+		"it's",
+		"line1\nline2",
+		`back\slash`,
+		`"double"`,
+		"hash # value",
+	}
+
+	for _, value := range values {
+		t.Run(value, func(t *testing.T) {
+			f := Parse([]byte("KEY=" + Quote(value) + "\n"))
+			parsed, ok := f.Get("KEY")
+			assert.True(t, ok)
+			assert.Equal(t, value, parsed)
+		})
+	}
+}
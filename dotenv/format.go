@@ -0,0 +1,66 @@
+package dotenv
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Output formats supported by Format
+const (
+	FormatDotenv = "dotenv"
+	FormatJSON   = "json"
+	FormatYAML   = "yaml"
+	FormatShell  = "shell"
+)
+
+// Format renders variables sorted by name in one of the output formats
+// The shell format quotes values for POSIX shells; export prefixes each line with export
+func Format(variables map[string]string, format string, export bool) ([]byte, error) {
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	switch format {
+	case FormatDotenv:
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s=%s\n", name, Quote(variables[name]))
+		}
+	case FormatShell:
+		prefix := ""
+		if export {
+			prefix = "export "
+		}
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s%s=%s\n", prefix, name, ShellQuote(variables[name]))
+		}
+	case FormatJSON:
+		data, err := json.MarshalIndent(variables, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode variables: %w", err)
+		}
+		b.Write(data)
+		b.WriteString("\n")
+	case FormatYAML:
+		// JSON strings are valid YAML double-quoted scalars, so every value keeps its exact content
+		for _, name := range names {
+			value, err := json.Marshal(variables[name])
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode %s: %w", name, err)
+			}
+			fmt.Fprintf(&b, "%s: %s\n", name, value)
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q (expected dotenv, json, yaml or shell)", format)
+	}
+	return []byte(b.String()), nil
+}
+
+// ShellQuote quotes value for a POSIX shell so eval assigns it unchanged
+func ShellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
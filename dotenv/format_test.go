@@ -0,0 +1,72 @@
+package dotenv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	variables := map[string]string{
+		"NAME":  "it's here",
+		"PLAIN": "value",
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		export bool
+		want   string
+	}{
+		{
+			name:   "dotenv",
+			format: FormatDotenv,
+			want:   "NAME=\"it's here\"\nPLAIN=value\n",
+		},
+		{
+			name:   "shell",
+			format: FormatShell,
+			want:   "NAME='it'\\''s here'\nPLAIN='value'\n",
+		},
+		{
+			name:   "shell with export",
+			format: FormatShell,
+			export: true,
+			want:   "export NAME='it'\\''s here'\nexport PLAIN='value'\n",
+		},
+		{
+			name:   "json",
+			format: FormatJSON,
+			want:   "{\n  \"NAME\": \"it's here\",\n  \"PLAIN\": \"value\"\n}\n",
+		},
+		{
+			name:   "yaml",
+			format: FormatYAML,
+			want:   "NAME: \"it's here\"\nPLAIN: \"value\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Format(variables, tt.format, tt.export)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+
+	_, err := Format(variables, "toml", false)
+	assert.Error(t, err)
+}
+
+func TestFormatDotenvRoundTrip(t *testing.T) {
+	variables := map[string]string{
+		"MULTILINE": "line one\nline two",
+		"QUOTES":    `say "hi" and 'bye'`,
+		"EMPTY":     "",
+	}
+
+	data, err := Format(variables, FormatDotenv, false)
+	require.NoError(t, err)
+	assert.Equal(t, variables, Parse(data).Map())
+}
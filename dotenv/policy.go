@@ -0,0 +1,47 @@
+package dotenv
+
+import (
+	"path"
+	"sort"
+)
+
+// Policy restricts which variables of a file may be exported
+// Patterns use path.Match syntax, so API_* matches every name starting with API_
+// An empty Allow list permits every name that is not denied; Deny always wins
+type Policy struct {
+	Allow []string
+	Deny  []string
+}
+
+// Permits reports whether the policy lets name be exported
+func (p Policy) Permits(name string) bool {
+	if matchAny(p.Deny, name) {
+		return false
+	}
+	return len(p.Allow) == 0 || matchAny(p.Allow, name)
+}
+
+// Apply returns the permitted variables and the sorted names that violate the policy
+func (p Policy) Apply(variables map[string]string) (map[string]string, []string) {
+	permitted := make(map[string]string, len(variables))
+	var violations []string
+	for name, value := range variables {
+		if p.Permits(name) {
+			permitted[name] = value
+		} else {
+			violations = append(violations, name)
+		}
+	}
+	sort.Strings(violations)
+	return permitted, violations
+}
+
+// matchAny reports whether name matches one of the patterns
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package dotenv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyApply(t *testing.T) {
+	variables := map[string]string{
+		"API_URL":    "https://example.com",
+		"API_TOKEN":  "token",
+		"DB_URL":     "postgres://",
+		"AWS_SECRET": "secret",
+	}
+
+	tests := []struct {
+		name       string
+		policy     Policy
+		permitted  []string
+		violations []string
+	}{
+		{
+			name:      "empty policy permits everything",
+			policy:    Policy{},
+			permitted: []string{"API_TOKEN", "API_URL", "AWS_SECRET", "DB_URL"},
+		},
+		{
+			name:       "allow list restricts names",
+			policy:     Policy{Allow: []string{"API_*"}},
+			permitted:  []string{"API_TOKEN", "API_URL"},
+			violations: []string{"AWS_SECRET", "DB_URL"},
+		},
+		{
+			name:       "deny list removes names",
+			policy:     Policy{Deny: []string{"AWS_*"}},
+			permitted:  []string{"API_TOKEN", "API_URL", "DB_URL"},
+			violations: []string{"AWS_SECRET"},
+		},
+		{
+			name:       "deny wins over allow",
+			policy:     Policy{Allow: []string{"API_*"}, Deny: []string{"API_TOKEN"}},
+			permitted:  []string{"API_URL"},
+			violations: []string{"API_TOKEN", "AWS_SECRET", "DB_URL"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			permitted, violations := tt.policy.Apply(variables)
+
+			var names []string
+			for name := range permitted {
+				names = append(names, name)
+			}
+			assert.ElementsMatch(t, tt.permitted, names)
+			assert.Equal(t, tt.violations, violations)
+		})
+	}
+}
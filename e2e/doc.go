@@ -0,0 +1,3 @@
+// Package e2e runs ez-env end to end through git, in temporary repositories that push to local
+// bare remotes and against a fake GitHub API, so the tests need neither network nor credentials
+package e2e
@@ -0,0 +1,529 @@
+package e2e
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oliviaBahr/ez-env/githubtest"
+)
+
+// TestSharedKey tests the default mode: init stores a new key in the repository secret, and a
+// collaborator's clone retrieves it through the workflow to decrypt and re-encrypt files
+func TestSharedKey(t *testing.T) {
+	api := githubtest.NewServer(t, "acme", "app", "alice")
+	hub := newHub(t)
+	alice := newMachine(t, api, "alice")
+	repo := alice.newRepo(hub)
+
+	alice.ezenv(repo, "init")
+	_, ok := api.Secret("EZENV_ENCRYPTION_KEY")
+	require.True(t, ok, "init stores the key in the secret")
+
+	writeFile(t, repo, ".env", "API_KEY=secret\n")
+	alice.ezenv(repo, "add", ".env")
+	alice.git(repo, "add", "-A")
+	alice.git(repo, "commit", "-qm", "Add secrets")
+	alice.git(repo, "push", "-q", "hub", "main")
+	assert.NotContains(t, alice.git(repo, "show", "hub/main:.env"), "API_KEY", "the remote only holds ciphertext")
+	assert.Empty(t, alice.git(repo, "status", "--porcelain"))
+
+	bob := newMachine(t, api, "bob")
+	clone := bob.clone(hub)
+	assert.NotContains(t, readFile(t, clone, ".env"), "API_KEY", "without the filters a clone holds ciphertext")
+	dispatches := api.Dispatches()
+	bob.configureFilters(clone)
+	bob.refresh(clone, ".env")
+	assert.Greater(t, api.Dispatches(), dispatches, "the key is retrieved through the workflow")
+	assert.Equal(t, "API_KEY=secret\n", readFile(t, clone, ".env"))
+	assert.Empty(t, bob.git(clone, "status", "--porcelain"), "checked out files are unmodified")
+
+	writeFile(t, clone, ".env", "API_KEY=rotated\n")
+	bob.git(clone, "commit", "-qam", "Rotate the API key")
+	bob.git(clone, "push", "-q", "hub", "main")
+	assert.NotContains(t, bob.git(clone, "show", "hub/main:.env"), "API_KEY")
+
+	alice.git(repo, "pull", "-q", "hub", "main")
+	assert.Equal(t, "API_KEY=rotated\n", readFile(t, repo, ".env"))
+}
+
+// TestPassphrase tests passphrase mode, which needs no GitHub at all, and soft fail mode: a clone
+// without the passphrase checks files out locked and unlocks them once it has it
+func TestPassphrase(t *testing.T) {
+	api := githubtest.NewServer(t, "acme", "app", "alice")
+	hub := newHub(t)
+	alice := newMachine(t, api, "alice")
+	alice.setenv("EZENV_PASSPHRASE", "correct horse battery staple")
+	repo := alice.newRepo(hub)
+
+	alice.ezenv(repo, "init", "--mode", "passphrase")
+	writeFile(t, repo, "config/.env", "DB_PASSWORD=hunter2\n")
+	alice.ezenv(repo, "add", "config/.env")
+	alice.git(repo, "add", "-A")
+	alice.git(repo, "commit", "-qm", "Add secrets")
+	alice.git(repo, "push", "-q", "hub", "main")
+	assert.NotContains(t, alice.git(repo, "show", "hub/main:config/.env"), "hunter2")
+	assert.Zero(t, api.Dispatches(), "passphrase mode never asks GitHub")
+
+	ci := newMachine(t, api, "ci")
+	clone := ci.clone(hub)
+	ci.ezenv(clone, "config", "set", "--local", "failMode", "soft")
+	ci.configureFilters(clone)
+	ci.refresh(clone, "config/.env")
+	locked := readFile(t, clone, "config/.env")
+	assert.True(t, strings.HasPrefix(locked, "EZENV LOCKED FILE"), "checked out locked: %q", locked)
+	assert.Empty(t, ci.git(clone, "status", "--porcelain"), "locked files are unmodified")
+
+	ci.setenv("EZENV_PASSPHRASE", "correct horse battery staple")
+	assert.Contains(t, ci.ezenv(clone, "unlock"), "Unlocked 1 locked file(s)")
+	assert.Equal(t, "DB_PASSWORD=hunter2\n", readFile(t, clone, "config/.env"))
+	assert.Empty(t, ci.git(clone, "status", "--porcelain"))
+}
+
+// TestLockedPlaceholderPath tests that clean only restores the ciphertext a locked placeholder
+// names for the path it was checked out at, so it cannot be copied over another managed file
+func TestLockedPlaceholderPath(t *testing.T) {
+	api := githubtest.NewServer(t, "acme", "app", "alice")
+	hub := newHub(t)
+	alice := newMachine(t, api, "alice")
+	alice.setenv("EZENV_PASSPHRASE", "correct horse battery staple")
+	repo := alice.newRepo(hub)
+	alice.ezenv(repo, "init", "--mode", "passphrase")
+	writeFile(t, repo, "a.env", "A=1\n")
+	writeFile(t, repo, "b.env", "B=2\n")
+	alice.ezenv(repo, "add", "*.env")
+	alice.git(repo, "add", "-A")
+	alice.git(repo, "commit", "-qm", "Add secrets")
+	alice.git(repo, "push", "-q", "hub", "main")
+
+	ci := newMachine(t, api, "ci")
+	clone := ci.clone(hub)
+	ci.ezenv(clone, "config", "set", "--local", "failMode", "soft")
+	ci.configureFilters(clone)
+	ci.refresh(clone, "a.env", "b.env")
+	staged := ci.git(clone, "ls-files", "-s", "b.env")
+
+	writeFile(t, clone, "b.env", readFile(t, clone, "a.env"))
+	_, stderr, err := ci.run(clone, "", "git", "add", "b.env")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "not the staged or committed content of b.env")
+	assert.Equal(t, staged, ci.git(clone, "ls-files", "-s", "b.env"))
+}
+
+// TestFilters tests the one-shot filters against the filter process, and that files above the
+// stream threshold round trip as chunked envelopes
+func TestFilters(t *testing.T) {
+	api := githubtest.NewServer(t, "acme", "app", "alice")
+	alice := newMachine(t, api, "alice")
+	alice.setenv("EZENV_PASSPHRASE", "correct horse battery staple")
+	repo := alice.newRepo(newHub(t))
+	alice.ezenv(repo, "init", "--mode", "passphrase")
+	alice.ezenv(repo, "config", "set", "streamThreshold", "4096")
+
+	small := "TOKEN=abc\n"
+	large := strings.Repeat("LINE=0123456789abcdef\n", 10000)
+	writeFile(t, repo, "small.env", small)
+	writeFile(t, repo, "large.env", large)
+	alice.ezenv(repo, "add", "*.env")
+	alice.git(repo, "add", "-A")
+	alice.git(repo, "commit", "-qm", "Add secrets")
+
+	roundTrip := func(filters string) {
+		for path, content := range map[string]string{"small.env": small, "large.env": large} {
+			require.NoError(t, os.Remove(filepath.Join(repo, path)))
+			alice.git(repo, "checkout", "--", path)
+			assert.Equal(t, content, readFile(t, repo, path), "%s through the %s", path, filters)
+		}
+		assert.Empty(t, alice.git(repo, "status", "--porcelain"), filters)
+		cleaned := alice.git(repo, "show", ":large.env")
+		assert.NotContains(t, cleaned, "LINE=", filters)
+	}
+	roundTrip("filter process")
+	// Without the process command git runs clean and smudge once per file
+	alice.git(repo, "config", "--unset", "filter.ezenv.process")
+	writeFile(t, repo, "large.env", large+"LINE=appended\n")
+	alice.git(repo, "commit", "-qam", "Append a line")
+	large += "LINE=appended\n"
+	roundTrip("one-shot filters")
+}
+
+// TestRename tests that a managed file moved with git mv still checks out with path binding on:
+// the pre-commit hook blocks the commit of ciphertext bound to the old path and names the fix
+func TestRename(t *testing.T) {
+	api := githubtest.NewServer(t, "acme", "app", "alice")
+	alice := newMachine(t, api, "alice")
+	alice.setenv("EZENV_PASSPHRASE", "correct horse battery staple")
+	repo := alice.newRepo(newHub(t))
+	alice.ezenv(repo, "init", "--mode", "passphrase")
+	alice.ezenv(repo, "install-hooks")
+	writeFile(t, repo, "config/.env", "DB_PASSWORD=hunter2\n")
+	alice.ezenv(repo, "add", "config/.env*")
+	alice.git(repo, "add", "-A")
+	alice.git(repo, "commit", "-qm", "Add secrets")
+
+	alice.git(repo, "mv", "config/.env", "config/.env.local")
+	_, stderr, err := alice.run(repo, "", "git", "commit", "-qm", "Rename secrets")
+	require.Error(t, err, "ciphertext bound to the old path is not committed")
+	assert.Contains(t, stderr, "git add --renormalize config/.env.local")
+
+	alice.git(repo, "add", "--renormalize", "config/.env.local")
+	alice.git(repo, "commit", "-qm", "Rename secrets")
+	require.NoError(t, os.Remove(filepath.Join(repo, "config", ".env.local")))
+	alice.git(repo, "checkout", "--", "config/.env.local")
+	assert.Equal(t, "DB_PASSWORD=hunter2\n", readFile(t, repo, "config/.env.local"))
+}
+
+// TestEdit tests that edit runs the configured editor without a shell, so a quoted path with
+// spaces works and nothing in the editor value is expanded
+func TestEdit(t *testing.T) {
+	api := githubtest.NewServer(t, "acme", "app", "alice")
+	alice := newMachine(t, api, "alice")
+	alice.setenv("EZENV_PASSPHRASE", "correct horse battery staple")
+	repo := alice.newRepo(newHub(t))
+	alice.ezenv(repo, "init", "--mode", "passphrase")
+	writeFile(t, repo, ".env", "A=1\n")
+	alice.ezenv(repo, "add", ".env")
+	alice.git(repo, "add", "-A")
+	alice.git(repo, "commit", "-qm", "Add secrets")
+
+	bin := filepath.Join(t.TempDir(), "my editors")
+	writeFile(t, bin, "append", "#!/bin/sh\nfor last; do :; done\nprintf 'B=2\\n' >> \"$last\"\n")
+	require.NoError(t, os.Chmod(filepath.Join(bin, "append"), 0755))
+	pwned := filepath.Join(t.TempDir(), "pwned")
+	alice.setenv("GIT_EDITOR", "'"+filepath.Join(bin, "append")+"' \"$(touch "+pwned+")\"")
+
+	alice.ezenv(repo, "edit", ".env")
+	assert.Equal(t, "A=1\nB=2\n", readFile(t, repo, ".env"))
+	assert.NoFileExists(t, pwned, "the editor value was not run through a shell")
+}
+
+// TestUnlockExternal tests that unlock only installs sidecar entries it is asked for, and asks
+// before creating files outside the repository
+func TestUnlockExternal(t *testing.T) {
+	api := githubtest.NewServer(t, "acme", "app", "alice")
+	alice := newMachine(t, api, "alice")
+	alice.setenv("EZENV_PASSPHRASE", "correct horse battery staple")
+	repo := alice.newRepo(newHub(t))
+	alice.ezenv(repo, "init", "--mode", "passphrase")
+	credentials := filepath.Join(alice.home, ".aws", "credentials")
+	writeFile(t, alice.home, ".aws/credentials", "secret=1\n")
+	alice.ezenv(repo, "add", "--external", credentials)
+	alice.git(repo, "add", "-A")
+	alice.git(repo, "commit", "-qm", "Add external secrets")
+	require.NoError(t, os.Remove(credentials))
+
+	assert.Contains(t, alice.ezenv(repo, "unlock"), "install them with 'git ez-env unlock --external'")
+	assert.NoFileExists(t, credentials)
+
+	stdout, _, err := alice.run(repo, "n\n", "git", "ez-env", "unlock", "--external")
+	require.Error(t, err)
+	assert.Contains(t, stdout, "~/.aws/credentials")
+	assert.NoFileExists(t, credentials)
+
+	_, stderr, err := alice.run(repo, "y\n", "git", "ez-env", "unlock", "--external")
+	require.NoError(t, err, stderr)
+	assert.Equal(t, "secret=1\n", readFile(t, alice.home, ".aws/credentials"))
+}
+
+// TestDeinitSidecar tests that deinit installs the files of the sidecar store before removing it,
+// and refuses to remove it while an installed file differs from the stored version
+func TestDeinitSidecar(t *testing.T) {
+	api := githubtest.NewServer(t, "acme", "app", "alice")
+	alice := newMachine(t, api, "alice")
+	alice.setenv("EZENV_PASSPHRASE", "correct horse battery staple")
+	repo := alice.newRepo(newHub(t))
+	alice.ezenv(repo, "init", "--mode", "passphrase")
+	writeFile(t, alice.home, ".aws/credentials", "secret=1\n")
+	alice.ezenv(repo, "add", "--external", filepath.Join(alice.home, ".aws", "credentials"))
+	alice.git(repo, "add", "-A")
+	alice.git(repo, "commit", "-qm", "Add external secrets")
+
+	writeFile(t, alice.home, ".aws/credentials", "secret=2\n")
+	_, stderr, err := alice.run(repo, "", "git", "ez-env", "deinit", "--yes")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "were not installed")
+	assert.DirExists(t, filepath.Join(repo, ".ezenv", "sidecar"), "the store was kept")
+
+	require.NoError(t, os.Remove(filepath.Join(alice.home, ".aws", "credentials")))
+	alice.ezenv(repo, "deinit", "--yes")
+	assert.Equal(t, "secret=1\n", readFile(t, alice.home, ".aws/credentials"))
+	assert.NoDirExists(t, filepath.Join(repo, ".ezenv"))
+}
+
+// TestReencryptMissingBlob tests that re-encrypt matches blobs to files by object id, so a staged
+// object that cannot be read fails instead of shifting content onto other files
+func TestReencryptMissingBlob(t *testing.T) {
+	api := githubtest.NewServer(t, "acme", "app", "alice")
+	alice := newMachine(t, api, "alice")
+	alice.setenv("EZENV_PASSPHRASE", "correct horse battery staple")
+	repo := alice.newRepo(newHub(t))
+	alice.ezenv(repo, "init", "--mode", "passphrase")
+	writeFile(t, repo, "a.env", "A=1\n")
+	writeFile(t, repo, "b.env", "B=2\n")
+	alice.ezenv(repo, "add", "*.env")
+	alice.git(repo, "add", "-A")
+	alice.git(repo, "commit", "-qm", "Add secrets")
+	staged := alice.git(repo, "ls-files", "-s", "b.env")
+
+	alice.git(repo, "update-index", "--cacheinfo", "100644,"+strings.Repeat("1", 40)+",a.env")
+	_, stderr, err := alice.run(repo, "", "git", "ez-env", "re-encrypt")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "failed to read the blob")
+	assert.Equal(t, staged, alice.git(repo, "ls-files", "-s", "b.env"), "no other file was re-encrypted")
+}
+
+// TestRewrite tests that rewrite encrypts, or with --purge removes, a file in every commit of a
+// history with a merge and an annotated tag, keeping the original refs as backups
+func TestRewrite(t *testing.T) {
+	// setup commits secret.env in plaintext on both sides of a merge that v1 tags, then manages it
+	setup := func(t *testing.T) (*machine, string) {
+		api := githubtest.NewServer(t, "acme", "app", "alice")
+		alice := newMachine(t, api, "alice")
+		alice.setenv("EZENV_PASSPHRASE", "correct horse battery staple")
+		repo := alice.newRepo(newHub(t))
+		writeFile(t, repo, "secret.env", "TOKEN=one\n")
+		alice.git(repo, "add", "-A")
+		alice.git(repo, "commit", "-qm", "Add secret")
+		alice.git(repo, "checkout", "-qb", "feature")
+		writeFile(t, repo, "secret.env", "TOKEN=two\n")
+		alice.git(repo, "commit", "-qam", "Change secret")
+		alice.git(repo, "checkout", "-q", "main")
+		writeFile(t, repo, "README", "app\n")
+		alice.git(repo, "add", "README")
+		alice.git(repo, "commit", "-qm", "Add readme")
+		alice.git(repo, "merge", "-q", "--no-ff", "-m", "Merge feature", "feature")
+		alice.git(repo, "tag", "-a", "-m", "Release", "v1")
+
+		alice.ezenv(repo, "init", "--mode", "passphrase")
+		alice.ezenv(repo, "add", "secret.env")
+		alice.git(repo, "add", "-A")
+		alice.git(repo, "commit", "-qm", "Manage secret")
+		return alice, repo
+	}
+
+	t.Run("encrypt", func(t *testing.T) {
+		alice, repo := setup(t)
+		original := alice.git(repo, "rev-parse", "main")
+		tag := alice.git(repo, "rev-parse", "v1")
+
+		alice.ezenv(repo, "rewrite", "--yes", "secret.env")
+		assert.Equal(t, original, alice.git(repo, "rev-parse", "refs/ezenv-original/heads/main"))
+		assert.Equal(t, tag, alice.git(repo, "rev-parse", "refs/ezenv-original/tags/v1"))
+		assert.Equal(t, "tag\n", alice.git(repo, "cat-file", "-t", "v1"), "v1 is still an annotated tag")
+		assert.NotEmpty(t, alice.git(repo, "rev-list", "--merges", "main"), "the merge is kept")
+
+		for _, commit := range strings.Fields(alice.git(repo, "rev-list", "main", "--", "secret.env")) {
+			assert.NotContains(t, alice.git(repo, "cat-file", "blob", commit+":secret.env"), "TOKEN", "in %s", commit)
+		}
+		assert.Equal(t, "TOKEN=one\n", alice.ezenv(repo, "show", "main~2:secret.env"))
+		assert.Equal(t, "TOKEN=two\n", alice.ezenv(repo, "show", "v1:secret.env"))
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		alice, repo := setup(t)
+		original := alice.git(repo, "rev-parse", "main")
+
+		alice.ezenv(repo, "rewrite", "--purge", "--yes", "secret.env")
+		assert.Equal(t, original, alice.git(repo, "rev-parse", "refs/ezenv-original/heads/main"))
+		assert.NotEmpty(t, alice.git(repo, "rev-parse", "refs/ezenv-original/tags/v1"))
+		assert.Empty(t, alice.git(repo, "rev-list", "main", "v1", "--", "secret.env"), "no commit holds secret.env")
+		assert.NotEmpty(t, alice.git(repo, "rev-list", "--merges", "v1"), "the merge is kept")
+	})
+}
+
+// TestCheckStaged tests that a clone without the filters cannot commit or push plaintext
+func TestCheckStaged(t *testing.T) {
+	api := githubtest.NewServer(t, "acme", "app", "alice")
+	hub := newHub(t)
+	alice := newMachine(t, api, "alice")
+	alice.setenv("EZENV_PASSPHRASE", "correct horse battery staple")
+	repo := alice.newRepo(hub)
+	alice.ezenv(repo, "init", "--mode", "passphrase")
+	writeFile(t, repo, ".env", "API_KEY=secret\n")
+	alice.ezenv(repo, "add", ".env")
+	alice.git(repo, "add", "-A")
+	alice.git(repo, "commit", "-qm", "Add secrets")
+	alice.git(repo, "push", "-q", "hub", "main")
+
+	bob := newMachine(t, api, "bob")
+	clone := bob.clone(hub)
+	assert.Contains(t, bob.ezenv(clone, "check-staged"), "1 staged managed file(s) are encrypted")
+	bob.ezenv(clone, "install-hooks")
+
+	writeFile(t, clone, ".env", "API_KEY=leaked\n")
+	bob.git(clone, "add", ".env")
+	_, stderr, err := bob.run(clone, "", "git", "ez-env", "check-staged")
+	assert.Error(t, err)
+	assert.Contains(t, stderr, ".env")
+	_, stderr, err = bob.run(clone, "", "git", "commit", "-qm", "Leak")
+	assert.Error(t, err, "the pre-commit hook blocks the commit")
+	assert.Contains(t, stderr, ".env")
+
+	bob.git(clone, "commit", "-qm", "Leak", "--no-verify")
+	_, stderr, err = bob.run(clone, "", "git", "push", "-q", "hub", "main")
+	assert.Error(t, err, "the pre-push hook blocks the push")
+	assert.Contains(t, stderr, ".env")
+	assert.NotContains(t, bob.git(clone, "show", "hub/main:.env"), "leaked")
+
+	// The remote tip is a commit bob has not fetched
+	writeFile(t, repo, "README", "app\n")
+	alice.git(repo, "add", "README")
+	alice.git(repo, "commit", "-qm", "Add README")
+	alice.git(repo, "push", "-q", "hub", "main")
+	_, stderr, err = bob.run(clone, "", "git", "push", "-q", "--force", "hub", "main")
+	assert.Error(t, err, "the pre-push hook blocks the push")
+	assert.Contains(t, stderr, ".env is committed in plaintext")
+}
+
+// TestMigrateWithoutFilters tests that migrate refuses to run in a clone without the clean filter,
+// where git add would commit the decrypted files, before it deletes anything
+func TestMigrateWithoutFilters(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	api := githubtest.NewServer(t, "acme", "app", "alice")
+	alice := newMachine(t, api, "alice")
+	repo := alice.newRepo(newHub(t))
+	writeFile(t, repo, ".gitsecret/paths/mapping.cfg", "secret.env:0123\n")
+	writeFile(t, repo, "secret.env.secret", "ciphertext")
+
+	_, stderr, err := alice.run(repo, "", "git", "ez-env", "migrate", "--yes", "git-secret")
+	require.Error(t, err)
+	assert.Contains(t, stderr, "filter.ezenv.clean is not set")
+	assert.FileExists(t, filepath.Join(repo, "secret.env.secret"))
+	assert.NoFileExists(t, filepath.Join(repo, "secret.env"))
+}
+
+// TestDebugLog tests that EZENV_DEBUG logs the filters to the git directory, not to git's stdout
+func TestDebugLog(t *testing.T) {
+	api := githubtest.NewServer(t, "acme", "app", "alice")
+	alice := newMachine(t, api, "alice")
+	alice.setenv("EZENV_PASSPHRASE", "correct horse battery staple")
+	repo := alice.newRepo(newHub(t))
+	alice.ezenv(repo, "init", "--mode", "passphrase")
+	writeFile(t, repo, ".env", "API_KEY=secret\n")
+	alice.ezenv(repo, "add", ".env")
+
+	alice.setenv("EZENV_DEBUG", "1")
+	alice.git(repo, "add", ".env")
+	log := readFile(t, repo, ".git/ezenv/debug.log")
+	assert.Contains(t, log, "msg=clean")
+	assert.Contains(t, log, "file=.env")
+	assert.Contains(t, log, `key_source="local key"`)
+	assert.NotContains(t, log, "secret")
+}
+
+// TestRepoConfig tests that .ezenv.yml holds the settings, key mode and managed files, that
+// .gitattributes follows it when it is edited by hand, and that legacy settings move to it
+func TestRepoConfig(t *testing.T) {
+	api := githubtest.NewServer(t, "acme", "app", "alice")
+	alice := newMachine(t, api, "alice")
+	alice.setenv("EZENV_PASSPHRASE", "correct horse battery staple")
+	repo := alice.newRepo(newHub(t))
+
+	// Settings committed before .ezenv.yml are still read
+	writeFile(t, repo, ".ezenv/config", "[ezenv]\n\tpadding = 256\n\tfailMode = soft\n")
+	assert.Equal(t, "256\n", alice.ezenv(repo, "config", "get", "padding"))
+
+	alice.ezenv(repo, "init", "--mode", "passphrase")
+	writeFile(t, repo, ".env", "API_KEY=secret\n")
+	alice.ezenv(repo, "add", ".env")
+	alice.ezenv(repo, "config", "set", "padding", "512")
+	alice.ezenv(repo, "config", "set", "failMode", "soft")
+	assert.NoFileExists(t, filepath.Join(repo, ".ezenv", "config"), "the legacy file is removed once its settings moved")
+
+	config := readFile(t, repo, ".ezenv.yml")
+	for _, line := range []string{"version: 1\n", "mode: passphrase\n", "padding: 512\n", "failMode: soft\n", "files:\n  - .env\n"} {
+		assert.Contains(t, config, line)
+	}
+	assert.Contains(t, alice.ezenv(repo, "config", "list"), "(.ezenv.yml)")
+	assert.Contains(t, readFile(t, repo, ".gitattributes"), ".env filter=ezenv")
+
+	// A pattern added by hand takes effect once .gitattributes is synced, and the comment survives
+	writeFile(t, repo, ".ezenv.yml", config+"  # Service account credentials\n  - 'secrets/*.json'\n")
+	writeFile(t, repo, "secrets/gcp.json", `{"private_key": "hunter2"}`)
+	assert.Contains(t, alice.ezenv(repo, "config", "sync"), "2 pattern(s)")
+	assert.Contains(t, readFile(t, repo, ".gitattributes"), "secrets/*.json filter=ezenv")
+	alice.git(repo, "add", "-A")
+	assert.NotContains(t, alice.git(repo, "show", ":secrets/gcp.json"), "hunter2")
+	alice.ezenv(repo, "config", "set", "padding", "1024")
+	assert.Contains(t, readFile(t, repo, ".ezenv.yml"), "# Service account credentials")
+
+	alice.ezenv(repo, "remove", ".env")
+	config = readFile(t, repo, ".ezenv.yml")
+	assert.NotContains(t, config, "- .env")
+	assert.NotContains(t, readFile(t, repo, ".gitattributes"), ".env filter=ezenv")
+	assert.Contains(t, alice.ezenv(repo, "status"), "secrets/*.json")
+
+	// A hand edit that names an unknown setting is reported instead of ignored
+	writeFile(t, repo, ".ezenv.yml", config+"padddding: 12\n")
+	_, stderr, err := alice.run(repo, "", "git", "ez-env", "config", "sync")
+	assert.Error(t, err)
+	assert.Contains(t, stderr, `unknown setting "padddding"`)
+}
+
+// TestEnvironmentKeys tests that commands reading managed files decrypt each with the key of its environment
+func TestEnvironmentKeys(t *testing.T) {
+	api := githubtest.NewServer(t, "acme", "app", "alice")
+	alice := newMachine(t, api, "alice")
+	alice.setenv("EZENV_PASSPHRASE", "correct horse battery staple")
+	repo := alice.newRepo(newHub(t))
+	alice.ezenv(repo, "init", "--mode", "passphrase")
+
+	prodKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	_, stderr, err := alice.run(repo, "", "env", "EZENV_ENCRYPTION_KEY="+prodKey, "git", "ez-env", "ci", "unlock", "--environment", "prod")
+	require.NoError(t, err, stderr)
+
+	writeFile(t, repo, ".env", "API_KEY=dev\n")
+	writeFile(t, repo, "prod.env", "API_KEY=prod\n")
+	alice.ezenv(repo, "add", ".env")
+	alice.ezenv(repo, "add", "--environment", "prod", "prod.env")
+	alice.git(repo, "add", "-A")
+	alice.git(repo, "commit", "-qm", "Add secrets")
+
+	assert.Contains(t, alice.ezenv(repo, "verify"), "2 file(s) decrypt with the keys of their environments")
+	assert.Contains(t, alice.ezenv(repo, "peek", "prod.env"), "API_KEY=prod")
+	assert.Equal(t, "prod\n", alice.ezenv(repo, "run", "-f", "prod.env", "--", "printenv", "API_KEY"))
+
+	blob := filepath.Join(t.TempDir(), "blob")
+	require.NoError(t, os.WriteFile(blob, []byte(alice.git(repo, "cat-file", "blob", "HEAD:prod.env")), 0600))
+	assert.Equal(t, "API_KEY=prod\n", alice.ezenv(repo, "diff", blob))
+
+	alice.ezenv(repo, "re-encrypt")
+	assert.Contains(t, alice.ezenv(repo, "verify"), "2 file(s) decrypt")
+
+	capability := filepath.Join(t.TempDir(), "alice.ezcap")
+	alice.ezenv(repo, "delegate", "--paths", "prod.env", "-o", capability, "alice")
+	assert.Equal(t, "API_KEY=prod\n", alice.ezenv(repo, "delegate", "open", capability, "prod.env"))
+
+	alice.git(repo, "checkout", "-qb", "feature")
+	writeFile(t, repo, "prod.env", "API_KEY=feature\n")
+	alice.git(repo, "commit", "-qam", "Change on feature")
+	alice.git(repo, "checkout", "-q", "main")
+	writeFile(t, repo, "prod.env", "API_KEY=main\n")
+	alice.git(repo, "commit", "-qam", "Change on main")
+	_, _, err = alice.run(repo, "", "git", "merge", "-q", "feature")
+	require.Error(t, err, "both sides changed API_KEY")
+	_, stderr, err = alice.run(repo, "t\n", "git", "ez-env", "resolve", "prod.env")
+	require.NoError(t, err, stderr)
+	assert.Equal(t, "API_KEY=feature\n", readFile(t, repo, "prod.env"))
+	alice.git(repo, "commit", "-qm", "Merge feature")
+
+	// A file committed before it was managed is encrypted in history with its environment's key
+	writeFile(t, repo, "legacy.env", "API_KEY=legacy\n")
+	alice.git(repo, "add", "legacy.env")
+	alice.git(repo, "commit", "-qm", "Add legacy secrets")
+	alice.ezenv(repo, "add", "--environment", "prod", "legacy.env")
+	alice.git(repo, "add", "-A")
+	alice.git(repo, "commit", "-qm", "Manage legacy secrets")
+	alice.ezenv(repo, "rewrite", "--yes", "legacy.env")
+	assert.NotContains(t, alice.git(repo, "cat-file", "blob", "HEAD~1:legacy.env"), "legacy")
+	assert.Equal(t, "API_KEY=legacy\n", alice.ezenv(repo, "show", "HEAD~1:legacy.env"))
+}
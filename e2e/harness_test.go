@@ -0,0 +1,203 @@
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	// The binary is built by TestMain, so go test would not see changes to the commands and
+	// reuse cached results; importing them makes it
+	_ "github.com/oliviaBahr/ez-env/cmd"
+	"github.com/oliviaBahr/ez-env/githubtest"
+)
+
+// remoteURL is the origin of every test repository; ez-env reads the GitHub repository from it,
+// while git pushes and fetches through the hub remote, a local bare repository
+const remoteURL = "https://github.com/acme/app.git"
+
+// binDir holds the ez-env binary, named so git runs it as git ez-env, and a stub of gh
+var binDir string
+
+func TestMain(m *testing.M) {
+	if runtime.GOOS == "windows" {
+		// The gh stub and the filters configured by init are shell commands
+		fmt.Println("skipping end-to-end tests on windows")
+		os.Exit(0)
+	}
+	dir, err := os.MkdirTemp("", "ez-env-e2e-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := 1
+	if err := setupBinDir(dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	} else {
+		binDir = dir
+		code = m.Run()
+	}
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// setupBinDir builds ez-env into dir and writes a gh that is logged in with a fake token
+func setupBinDir(dir string) error {
+	build := exec.Command("go", "build", "-o", filepath.Join(dir, "git-ez-env"), "github.com/oliviaBahr/ez-env")
+	if output, err := build.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to build ez-env: %v\n%s", err, output)
+	}
+	gh := `#!/bin/sh
+if [ "$1 $2" = "auth token" ]; then
+	echo gho_e2e_token
+	exit 0
+fi
+echo "gh stub: unsupported command: $*" >&2
+exit 1
+`
+	return os.WriteFile(filepath.Join(dir, "gh"), []byte(gh), 0755)
+}
+
+// machine is a developer's machine: its own home directory, git identity and SSH key on GitHub,
+// with ez-env and the gh stub on the PATH and the GitHub API pointed at the fake
+type machine struct {
+	t    *testing.T
+	home string
+	env  []string
+}
+
+// newMachine returns a machine of login, whose SSH key is added to the fake API's user
+// The environment is built from scratch, so neither the tokens nor the CI variables of whoever runs
+// the tests leak in
+func newMachine(t *testing.T, api *githubtest.Server, login string) *machine {
+	t.Helper()
+	home := t.TempDir()
+	keyPath, authorizedKey := githubtest.WriteSSHKey(t, home)
+	api.AddSSHKey(authorizedKey)
+	m := &machine{
+		t:    t,
+		home: home,
+		env: []string{
+			"HOME=" + home,
+			"PATH=" + binDir + string(os.PathListSeparator) + os.Getenv("PATH"),
+			"TMPDIR=" + os.TempDir(),
+			"XDG_CONFIG_HOME=" + filepath.Join(home, ".config"),
+			"XDG_CACHE_HOME=" + filepath.Join(home, ".cache"),
+			"GIT_CONFIG_NOSYSTEM=1",
+			"GIT_TERMINAL_PROMPT=0",
+			"GITHUB_API_URL=" + api.URL,
+			"EZENV_SSH_KEY=" + keyPath,
+		},
+	}
+	m.git(home, "config", "--global", "user.name", login)
+	m.git(home, "config", "--global", "user.email", login+"@example.com")
+	m.git(home, "config", "--global", "init.defaultBranch", "main")
+	// Filters of one git command would share keys through a background agent outliving the test
+	m.git(home, "config", "--global", "ezenv.sessionTimeout", "0")
+	m.git(home, "config", "--global", "ezenv.keychain", "false")
+	return m
+}
+
+// setenv sets an environment variable for the commands the machine runs from now on
+func (m *machine) setenv(name, value string) {
+	m.env = append(m.env, name+"="+value)
+}
+
+// run runs a command in dir with stdin and returns its stdout and stderr
+func (m *machine) run(dir, stdin, name string, args ...string) (string, string, error) {
+	m.t.Helper()
+	var stdout, stderr strings.Builder
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Env = m.env
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+// git runs git in dir, fails the test when it fails and returns its stdout
+func (m *machine) git(dir string, args ...string) string {
+	m.t.Helper()
+	stdout, stderr, err := m.run(dir, "", "git", args...)
+	require.NoError(m.t, err, "git %s\n%s%s", strings.Join(args, " "), stdout, stderr)
+	return stdout
+}
+
+// ezenv runs git ez-env in dir, fails the test when it fails and returns its stdout
+func (m *machine) ezenv(dir string, args ...string) string {
+	m.t.Helper()
+	return m.git(dir, append([]string{"ez-env"}, args...)...)
+}
+
+// newRepo creates an empty repository whose origin is remoteURL and that pushes to hub
+func (m *machine) newRepo(hub string) string {
+	m.t.Helper()
+	dir := filepath.Join(m.t.TempDir(), "app")
+	m.git(m.home, "init", "-q", dir)
+	m.git(dir, "remote", "add", "origin", remoteURL)
+	m.git(dir, "remote", "add", "hub", hub)
+	return dir
+}
+
+// clone clones hub with origin set to remoteURL
+func (m *machine) clone(hub string) string {
+	m.t.Helper()
+	dir := filepath.Join(m.t.TempDir(), "app")
+	m.git(m.home, "clone", "-q", "-o", "hub", hub, dir)
+	m.git(dir, "remote", "add", "origin", remoteURL)
+	return dir
+}
+
+// newHub creates the bare repository the machines push to and fetch from
+func newHub(t *testing.T) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "hub.git")
+	output, err := exec.Command("git", "init", "-q", "--bare", "--initial-branch=main", dir).CombinedOutput()
+	require.NoError(t, err, string(output))
+	return dir
+}
+
+// writeFile writes content to the file at the slash-separated path in dir
+func writeFile(t *testing.T, dir, path, content string) {
+	t.Helper()
+	full := filepath.Join(dir, filepath.FromSlash(path))
+	require.NoError(t, os.MkdirAll(filepath.Dir(full), 0755))
+	require.NoError(t, os.WriteFile(full, []byte(content), 0644))
+}
+
+// readFile returns the content of the file at the slash-separated path in dir
+func readFile(t *testing.T, dir, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+	require.NoError(t, err)
+	return string(content)
+}
+
+// configureFilters configures the filters in a clone of a repository that uses ez-env, as doctor
+// says to; init would set the repository up from scratch
+func (m *machine) configureFilters(dir string) {
+	m.t.Helper()
+	exe := filepath.Join(binDir, "git-ez-env")
+	m.git(dir, "config", "filter.ezenv.clean", exe+" clean %f")
+	m.git(dir, "config", "filter.ezenv.smudge", exe+" smudge %f")
+	m.git(dir, "config", "filter.ezenv.process", exe+" filter-process")
+	m.git(dir, "config", "filter.ezenv.required", "true")
+}
+
+// refresh checks files out again so they pass through the smudge filter, as a clone made before
+// its filters were configured holds ciphertext; git skips files whose stat data is unchanged, so
+// they are removed first
+func (m *machine) refresh(dir string, paths ...string) {
+	m.t.Helper()
+	for _, path := range paths {
+		require.NoError(m.t, os.Remove(filepath.Join(dir, filepath.FromSlash(path))))
+	}
+	m.git(dir, append([]string{"checkout", "--"}, paths...)...)
+}
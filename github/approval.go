@@ -0,0 +1,77 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	gogithub "github.com/google/go-github/v66/github"
+)
+
+// ApprovalEnvironment is the protected environment the workflow runs key requests of collaborators
+// below the maintain role in, so a reviewer has to approve them
+const ApprovalEnvironment = "ez-env-approval"
+
+// maxReviewers is the most required reviewers GitHub allows on an environment
+const maxReviewers = 6
+
+// RequireApproval protects the approval environment with required reviewers and returns their
+// logins; without approvers the repository's admins and maintainers review, up to six of them
+func RequireApproval(ctx context.Context, approvers []string) ([]string, error) {
+	gh, owner, repo, err := repoClient()
+	if err != nil {
+		return nil, err
+	}
+	if len(approvers) == 0 {
+		collaborators, err := ListCollaboratorPermissions(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, collaborator := range collaborators {
+			if roleAtLeast(collaborator.Permission, "", "maintain") {
+				approvers = append(approvers, collaborator.Login)
+			}
+		}
+	}
+	if len(approvers) == 0 {
+		return nil, fmt.Errorf("the repository has no admins or maintainers to approve key requests; name them with --approvers")
+	}
+	if len(approvers) > maxReviewers {
+		approvers = approvers[:maxReviewers]
+	}
+
+	reviewers := make([]*gogithub.EnvReviewers, len(approvers))
+	for i, login := range approvers {
+		user, _, err := gh.Users.Get(ctx, login)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up approver %s: %w", login, err)
+		}
+		reviewers[i] = &gogithub.EnvReviewers{Type: gogithub.String("User"), ID: user.ID}
+	}
+	environment := &gogithub.CreateUpdateEnvironment{Reviewers: reviewers}
+	if _, _, err := gh.Repositories.CreateUpdateEnvironment(ctx, owner, repo, ApprovalEnvironment, environment); err != nil {
+		return nil, fmt.Errorf("failed to protect the %s environment: %w", ApprovalEnvironment, err)
+	}
+	return approvers, nil
+}
+
+// pendingApproval describes what a waiting run needs: the environments it waits for and who can
+// approve them
+func pendingApproval(deployments []*gogithub.PendingDeployment) (string, string) {
+	var environments, reviewers []string
+	for _, deployment := range deployments {
+		environments = append(environments, deployment.GetEnvironment().GetName())
+		for _, required := range deployment.Reviewers {
+			switch reviewer := required.Reviewer.(type) {
+			case *gogithub.User:
+				reviewers = append(reviewers, reviewer.GetLogin())
+			case *gogithub.Team:
+				reviewers = append(reviewers, "team "+reviewer.GetSlug())
+			}
+		}
+	}
+	if len(reviewers) == 0 {
+		reviewers = []string{"a reviewer"}
+	}
+	return strings.Join(environments, ", "), strings.Join(reviewers, ", ")
+}
@@ -0,0 +1,47 @@
+package github
+
+import (
+	"testing"
+
+	gogithub "github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPendingApproval tests describing the environments and reviewers a waiting run needs
+func TestPendingApproval(t *testing.T) {
+	tests := []struct {
+		name         string
+		deployments  []*gogithub.PendingDeployment
+		environments string
+		reviewers    string
+	}{
+		{
+			name: "user and team reviewers",
+			deployments: []*gogithub.PendingDeployment{{
+				Environment: &gogithub.PendingDeploymentEnvironment{Name: gogithub.String(ApprovalEnvironment)},
+				Reviewers: []*gogithub.RequiredReviewer{
+					{Type: gogithub.String("User"), Reviewer: &gogithub.User{Login: gogithub.String("alice")}},
+					{Type: gogithub.String("Team"), Reviewer: &gogithub.Team{Slug: gogithub.String("admins")}},
+				},
+			}},
+			environments: ApprovalEnvironment,
+			reviewers:    "alice, team admins",
+		},
+		{
+			name: "no reviewers listed",
+			deployments: []*gogithub.PendingDeployment{{
+				Environment: &gogithub.PendingDeploymentEnvironment{Name: gogithub.String("production")},
+			}},
+			environments: "production",
+			reviewers:    "a reviewer",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			environments, reviewers := pendingApproval(tt.deployments)
+			assert.Equal(t, tt.environments, environments)
+			assert.Equal(t, tt.reviewers, reviewers)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package github
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+
+	"github.com/oliviaBahr/ez-env/ssh"
+)
+
+// Files in the key artifact uploaded by the workflow
+const (
+	// encryptedKeyArtifactFile holds the base64 key encrypted with age to the requester's SSH keys
+	encryptedKeyArtifactFile = "encryption-key.age"
+	// recipientsArtifactFile lists the public SSH keys the key was encrypted to, one per line
+	recipientsArtifactFile = "recipients.txt"
+)
+
+// artifactKey returns the base64 key from a key artifact
+// The key is decrypted with the local SSH private key matching one of the recipients; artifacts of
+// workflows written before the key was encrypted hold it in plaintext and are still accepted
+func artifactKey(archive []byte) ([]byte, error) {
+	encrypted, err := readArtifactFile(archive, encryptedKeyArtifactFile)
+	if err != nil {
+		plaintext, legacyErr := readArtifactFile(archive, keyArtifactFile)
+		if legacyErr != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "Warning: the workflow uploaded the key unencrypted; update it with 'git ez-env init --update-workflow' and push\n")
+		return plaintext, nil
+	}
+	recipients, err := readArtifactFile(archive, recipientsArtifactFile)
+	if err != nil {
+		return nil, err
+	}
+	return decryptForSSHKey(encrypted, strings.Split(strings.TrimSpace(string(recipients)), "\n"))
+}
+
+// decryptForSSHKey decrypts age ciphertext encrypted to recipients with the local private key of one of them
+func decryptForSSHKey(ciphertext []byte, recipients []string) ([]byte, error) {
+	privateKey, err := ssh.LoadPrivateKeyFor(recipients)
+	if err != nil {
+		return nil, fmt.Errorf("the key was encrypted to your SSH keys on GitHub, but %w", err)
+	}
+
+	var identity age.Identity
+	switch key := privateKey.(type) {
+	case ed25519.PrivateKey:
+		identity, err = agessh.NewEd25519Identity(key)
+	case *rsa.PrivateKey:
+		identity, err = agessh.NewRSAIdentity(key)
+	default:
+		err = fmt.Errorf("unsupported private key type: %T", privateKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := age.Decrypt(bytes.NewReader(ciphertext), identity)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, fmt.Errorf("the key artifact is not encrypted to your SSH key")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the key artifact: %w", err)
+	}
+	plaintext, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the key artifact: %w", err)
+	}
+	return plaintext, nil
+}
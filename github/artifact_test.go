@@ -0,0 +1,75 @@
+package github
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oliviaBahr/ez-env/ssh"
+)
+
+// testArtifact builds an artifact zip archive holding files
+func testArtifact(t *testing.T, files map[string][]byte) []byte {
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	for name, content := range files {
+		file, err := writer.Create(name)
+		require.NoError(t, err)
+		_, err = file.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	return archive.Bytes()
+}
+
+// TestArtifactKey tests decrypting the key artifact with the matching local SSH key
+func TestArtifactKey(t *testing.T) {
+	privatePEM, publicKey, err := ssh.GenerateEd25519Key()
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(keyPath, privatePEM, 0600))
+	t.Setenv(ssh.PrivateKeyEnv, keyPath)
+
+	encryptTo := func(authorizedKey string) []byte {
+		parsed, err := ssh.ParsePublicKey(authorizedKey)
+		require.NoError(t, err)
+		recipient, err := agessh.NewEd25519Recipient(parsed)
+		require.NoError(t, err)
+		var out bytes.Buffer
+		w, err := age.Encrypt(&out, recipient)
+		require.NoError(t, err)
+		_, err = w.Write([]byte("a2V5\n"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return out.Bytes()
+	}
+
+	key, err := artifactKey(testArtifact(t, map[string][]byte{
+		encryptedKeyArtifactFile: encryptTo(publicKey),
+		recipientsArtifactFile:   []byte(publicKey + "\n"),
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "a2V5\n", string(key))
+
+	_, otherKey, err := ssh.GenerateEd25519Key()
+	require.NoError(t, err)
+	_, err = artifactKey(testArtifact(t, map[string][]byte{
+		encryptedKeyArtifactFile: encryptTo(otherKey),
+		recipientsArtifactFile:   []byte(otherKey + "\n"),
+	}))
+	assert.ErrorContains(t, err, "match the requested public keys")
+
+	key, err = artifactKey(testArtifact(t, map[string][]byte{keyArtifactFile: []byte("bGVnYWN5\n")}))
+	require.NoError(t, err, "artifacts of older workflows are still read")
+	assert.Equal(t, "bGVnYWN5\n", string(key))
+
+	_, err = artifactKey(testArtifact(t, map[string][]byte{"other.txt": nil}))
+	assert.ErrorContains(t, err, encryptedKeyArtifactFile)
+}
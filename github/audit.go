@@ -0,0 +1,154 @@
+package github
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	gogithub "github.com/google/go-github/v66/github"
+)
+
+const (
+	// AuditBranch is the branch the key management workflow records key retrievals on
+	AuditBranch = "ezenv-audit"
+	// AuditFile is the file on AuditBranch holding one JSON record per retrieval
+	AuditFile = "audit.jsonl"
+	// auditAuthor is who commits the records; its commits through the API are signed by GitHub
+	auditAuthor = "github-actions[bot]"
+)
+
+// ErrNoAuditLog is returned when no key retrieval has been recorded yet
+var ErrNoAuditLog = errors.New("no key retrievals recorded")
+
+// AuditRecord is a key retrieval recorded by the workflow
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// Actor dispatched the run and User is who it was requested for
+	Actor       string `json:"actor"`
+	User        string `json:"user"`
+	Action      string `json:"action"`
+	Environment string `json:"environment,omitempty"`
+	RunID       int64  `json:"run_id"`
+	RunAttempt  int    `json:"run_attempt"`
+	// Commit is the commit of the workflow that ran
+	Commit string `json:"commit"`
+	// KeyID fingerprints the key that was handed out
+	KeyID string `json:"key_id"`
+}
+
+// AuditCommit is a commit to the audit log that was not made and signed by the workflow
+type AuditCommit struct {
+	SHA    string
+	Author string
+	// Reason says why the commit is not trusted
+	Reason string
+}
+
+// GetAuditLog returns the recorded key retrievals, oldest first, and the commits to the log that
+// the workflow did not make; such commits may have added, changed or removed records
+func GetAuditLog(ctx context.Context) ([]AuditRecord, []AuditCommit, error) {
+	gh, owner, repo, err := repoClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, _, err := gh.Repositories.DownloadContents(ctx, owner, repo, AuditFile, &gogithub.RepositoryContentGetOptions{Ref: AuditBranch})
+	if isNotFound(err) || (err != nil && strings.Contains(err.Error(), "no file named")) {
+		return nil, nil, ErrNoAuditLog
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the audit log: %w", err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the audit log: %w", err)
+	}
+	records, err := parseAuditLog(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var untrusted []AuditCommit
+	opts := &gogithub.CommitsListOptions{SHA: AuditBranch, Path: AuditFile, ListOptions: gogithub.ListOptions{PerPage: 100}}
+	for {
+		commits, response, err := gh.Repositories.ListCommits(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list audit log commits: %w", err)
+		}
+		for _, commit := range commits {
+			if untrustedCommit := checkAuditCommit(commit); untrustedCommit != nil {
+				untrusted = append(untrusted, *untrustedCommit)
+			}
+		}
+		if response.NextPage == 0 {
+			return records, untrusted, nil
+		}
+		opts.Page = response.NextPage
+	}
+}
+
+// AuditBranchProtected reports whether branch protection guards AuditBranch
+// Every workflow of the repository commits as the same signed bot, so the records only prove
+// anything when the branch cannot be pushed to by other workflows or collaborators
+func AuditBranchProtected(ctx context.Context) (bool, error) {
+	gh, owner, repo, err := repoClient()
+	if err != nil {
+		return false, err
+	}
+	branch, response, err := gh.Repositories.GetBranch(ctx, owner, repo, AuditBranch, 1)
+	if response != nil && response.StatusCode == http.StatusNotFound {
+		return false, ErrNoAuditLog
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read the %s branch: %w", AuditBranch, err)
+	}
+	return branch.GetProtected(), nil
+}
+
+// parseAuditLog parses the JSON records of the audit log, one per line
+func parseAuditLog(data []byte) ([]AuditRecord, error) {
+	var records []AuditRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var record AuditRecord
+		if err := json.Unmarshal([]byte(text), &record); err != nil {
+			return nil, fmt.Errorf("invalid audit record on line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the audit log: %w", err)
+	}
+	return records, nil
+}
+
+// checkAuditCommit describes why commit is not trusted, or returns nil when the workflow made it
+// and GitHub verified its signature
+func checkAuditCommit(commit *gogithub.RepositoryCommit) *AuditCommit {
+	author := commit.GetAuthor().GetLogin()
+	if author == "" {
+		author = commit.GetCommit().GetAuthor().GetName()
+	}
+	untrusted := &AuditCommit{SHA: commit.GetSHA(), Author: author}
+	switch verification := commit.GetCommit().GetVerification(); {
+	case author != auditAuthor:
+		untrusted.Reason = "it was not made by the workflow"
+	case !verification.GetVerified():
+		untrusted.Reason = "its signature is not verified (" + verification.GetReason() + ")"
+	default:
+		return nil
+	}
+	return untrusted
+}
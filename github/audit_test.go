@@ -0,0 +1,67 @@
+package github
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gogithub "github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseAuditLog tests reading the records the workflow appends
+func TestParseAuditLog(t *testing.T) {
+	data := []byte(`{"time":"2026-01-02T03:04:05Z","actor":"alice","user":"alice","action":"get-key","environment":"","run_id":42,"run_attempt":1,"commit":"abc123","key_id":"05c4a9e95b518d36"}
+
+{"time":"2026-01-03T03:04:05Z","actor":"bob","user":"carol","action":"rotate-key","environment":"production","run_id":43,"run_attempt":2,"commit":"def456","key_id":"1111111111111111"}
+`)
+	records, err := parseAuditLog(data)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, AuditRecord{
+		Time: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), Actor: "alice", User: "alice", Action: "get-key",
+		RunID: 42, RunAttempt: 1, Commit: "abc123", KeyID: "05c4a9e95b518d36",
+	}, records[0])
+	assert.Equal(t, "production", records[1].Environment)
+
+	_, err = parseAuditLog([]byte("{\"actor\":\"alice\"}\nnot json\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
+
+// TestCheckAuditCommit tests trusting only verified commits of the workflow
+func TestCheckAuditCommit(t *testing.T) {
+	commit := func(login string, verified bool) *gogithub.RepositoryCommit {
+		return &gogithub.RepositoryCommit{
+			SHA:    gogithub.String("abc"),
+			Author: &gogithub.User{Login: gogithub.String(login)},
+			Commit: &gogithub.Commit{Verification: &gogithub.SignatureVerification{
+				Verified: gogithub.Bool(verified),
+				Reason:   gogithub.String(map[bool]string{true: "valid", false: "unsigned"}[verified]),
+			}},
+		}
+	}
+
+	assert.Nil(t, checkAuditCommit(commit(auditAuthor, true)))
+	assert.Equal(t, "it was not made by the workflow", checkAuditCommit(commit("mallory", true)).Reason)
+	assert.Contains(t, checkAuditCommit(commit(auditAuthor, false)).Reason, "unsigned")
+}
+
+// TestAuditBranchProtected tests that the audit log is only trusted on a protected branch
+func TestAuditBranchProtected(t *testing.T) {
+	server := useFakeAPI(t)
+	ctx := context.Background()
+
+	_, err := AuditBranchProtected(ctx)
+	assert.ErrorIs(t, err, ErrNoAuditLog)
+
+	server.SetBranch(AuditBranch, false)
+	protected, err := AuditBranchProtected(ctx)
+	require.NoError(t, err)
+	assert.False(t, protected)
+
+	server.SetBranch(AuditBranch, true)
+	protected, err = AuditBranchProtected(ctx)
+	require.NoError(t, err)
+	assert.True(t, protected)
+}
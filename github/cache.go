@@ -0,0 +1,153 @@
+package github
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// RefreshCache makes cached API responses be downloaded again instead of revalidated, for when
+// the cache is suspected to be stale; the fresh responses replace the cached ones
+var RefreshCache = false
+
+// cacheablePath matches the API paths whose responses are cached: collaborator lists and the
+// public keys of users, which sync-keys and grant download for every collaborator
+var cacheablePath = regexp.MustCompile(`^/repos/[^/]+/[^/]+/collaborators$|^/users/[^/]+/(keys|gpg_keys)$`)
+
+// cachedResponse is a response body stored with the validators GitHub sent for it
+type cachedResponse struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	// Link is kept so the pages of a cached list are still followed
+	Link string `json:"link,omitempty"`
+	Body []byte `json:"body"`
+}
+
+// cacheTransport revalidates cached responses with conditional requests, so unchanged lists and
+// keys are not downloaded again; GitHub does not count 304 responses against the rate limit
+// The cache is keyed by the URL and the token, since what a list holds depends on who asks
+type cacheTransport struct {
+	base http.RoundTripper
+	// dir holds one file per cached response, or is empty when there is no cache directory
+	dir string
+}
+
+// newCacheTransport wraps base with a cache in the user's cache directory
+func newCacheTransport(base http.RoundTripper) *cacheTransport {
+	dir := ""
+	if cache, err := os.UserCacheDir(); err == nil {
+		dir = filepath.Join(cache, "ez-env", "github")
+	}
+	return &cacheTransport{base: base, dir: dir}
+}
+
+// RoundTrip serves unchanged cacheable responses from the cache and stores changed ones
+func (t *cacheTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if t.dir == "" || request.Method != http.MethodGet || !cacheablePath.MatchString(request.URL.Path) {
+		return t.base.RoundTrip(request)
+	}
+	path := t.entryPath(request)
+	cached, _ := readCachedResponse(path)
+	if cached != nil && !RefreshCache {
+		request = request.Clone(request.Context())
+		if cached.ETag != "" {
+			request.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			request.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	response, err := t.base.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusNotModified && cached != nil {
+		drain(response)
+		// go-github treats 304 as an error, so the cached response is returned as the 200 it was
+		response.StatusCode = http.StatusOK
+		response.Status = "200 OK"
+		response.Header.Del("Link")
+		if cached.Link != "" {
+			response.Header.Set("Link", cached.Link)
+		}
+		response.Header.Set("Content-Type", "application/json; charset=utf-8")
+		response.Header.Set("Content-Length", strconv.Itoa(len(cached.Body)))
+		response.ContentLength = int64(len(cached.Body))
+		response.Body = io.NopCloser(bytes.NewReader(cached.Body))
+		return response, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return response, nil
+	}
+
+	entry := &cachedResponse{
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+		Link:         response.Header.Get("Link"),
+	}
+	if entry.ETag == "" && entry.LastModified == "" {
+		return response, nil
+	}
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(body))
+	entry.Body = body
+	// A response that cannot be cached is still returned
+	_ = writeCachedResponse(path, entry)
+	return response, nil
+}
+
+// entryPath returns the cache file of a request, named by a hash of its URL and token
+func (t *cacheTransport) entryPath(request *http.Request) string {
+	sum := sha256.Sum256([]byte(request.Header.Get("Authorization") + "\x00" + request.URL.String()))
+	return filepath.Join(t.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// readCachedResponse reads a cache file
+func readCachedResponse(path string) (*cachedResponse, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cached cachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, err
+	}
+	return &cached, nil
+}
+
+// writeCachedResponse writes a cache file, replacing it atomically so concurrent runs never read
+// a partial one
+func writeCachedResponse(path string, cached *cachedResponse) error {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,76 @@
+package github
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCacheTransport tests revalidating cached collaborator lists and keys with their ETag
+func TestCacheTransport(t *testing.T) {
+	body := `[{"login":"alice"}]`
+	var full, notModified atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := `"` + body + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Link", `<`+r.URL.Path+`?page=2>; rel="next"`)
+		io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &cacheTransport{base: http.DefaultTransport, dir: t.TempDir()}}
+	get := func(path, token string) (*http.Response, string) {
+		request, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+		request.Header.Set("Authorization", "Bearer "+token)
+		response, err := client.Do(request)
+		require.NoError(t, err)
+		defer response.Body.Close()
+		content, err := io.ReadAll(response.Body)
+		require.NoError(t, err)
+		return response, string(content)
+	}
+	t.Cleanup(func() { RefreshCache = false })
+
+	response, content := get("/repos/o/r/collaborators", "one")
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, body, content)
+
+	response, content = get("/repos/o/r/collaborators", "one")
+	assert.Equal(t, http.StatusOK, response.StatusCode, "a 304 is returned as the cached 200")
+	assert.Equal(t, body, content)
+	assert.Contains(t, response.Header.Get("Link"), "page=2", "pagination of a cached list is kept")
+	assert.Equal(t, int32(1), full.Load())
+	assert.Equal(t, int32(1), notModified.Load())
+
+	get("/repos/o/r/collaborators", "two")
+	assert.Equal(t, int32(2), full.Load(), "another token does not share the cache")
+
+	RefreshCache = true
+	get("/repos/o/r/collaborators", "one")
+	assert.Equal(t, int32(3), full.Load(), "a refresh downloads the list again")
+	RefreshCache = false
+
+	body = `[{"login":"alice"},{"login":"bob"}]`
+	_, content = get("/repos/o/r/collaborators", "one")
+	assert.Equal(t, body, content, "a changed list replaces the cached one")
+
+	get("/users/alice/keys", "one")
+	get("/users/alice/keys", "one")
+	assert.Equal(t, int32(2), notModified.Load(), "user keys are cached too")
+
+	get("/repos/o/r/actions/secrets/public-key", "one")
+	get("/repos/o/r/actions/secrets/public-key", "one")
+	assert.Equal(t, int32(2), notModified.Load(), "other paths are not cached")
+}
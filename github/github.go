@@ -1,260 +1,1123 @@
 package github
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"slices"
 	"strings"
+	"sync"
 	"time"
+
+	gogithub "github.com/google/go-github/v66/github"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/oauth2"
 )
 
 const (
+	// DefaultSecretName is the secret name used unless the secretName setting overrides it
+	DefaultSecretName = "EZENV_ENCRYPTION_KEY"
+	// DefaultWorkflowName is the workflow file name used unless the workflowName setting overrides it
+	DefaultWorkflowName = "ez-env-key-management.yml"
+	// DefaultRemoteName is the git remote used unless the remote setting overrides it
+	DefaultRemoteName = "origin"
+
+	// ScopeRepository stores the key as a repository secret
+	ScopeRepository = "repository"
+	// ScopeOrganization stores the key as an organization secret shared with selected repositories
+	ScopeOrganization = "organization"
+)
+
+// The names below are the defaults until the repository settings are applied at startup
+var (
 	// SecretName is the name of the GitHub repository secret that stores the encryption key
-	SecretName = "EZENV_ENCRYPTION_KEY"
+	SecretName = DefaultSecretName
 	// WorkflowName is the name of the workflow for key management
-	WorkflowName = "ez-env-key-management.yml"
+	WorkflowName = DefaultWorkflowName
+	// RemoteName is the git remote that points at the GitHub repository
+	RemoteName = DefaultRemoteName
+	// AutoRemote picks the remote among the GitHub remotes when none is configured, see Remote
+	AutoRemote = false
+	// SecretScope is whether SecretName is a repository or an organization secret
+	SecretScope = ScopeRepository
 )
 
-// GetGitHubToken retrieves the GitHub token from environment or gh auth status
+// ErrSecretMissing is returned when the encryption key secret does not exist on the repository
+var ErrSecretMissing = errors.New("repository secret does not exist")
+
+// GetGitHubToken returns the token for the GitHub API
+// GITHUB_TOKEN and GH_TOKEN take precedence; otherwise the token gh is logged in with is used, so
+// gh is only needed to authenticate
 func GetGitHubToken() (string, error) {
-	// First try environment variable
-	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
-		return token, nil
+	token, _, err := GetGitHubTokenSource()
+	return token, err
+}
+
+// GetGitHubTokenSource returns the token for the GitHub API and where it came from
+func GetGitHubTokenSource() (string, string, error) {
+	for _, name := range []string{"GITHUB_TOKEN", "GH_TOKEN"} {
+		if token := os.Getenv(name); token != "" {
+			return token, name, nil
+		}
+	}
+
+	if _, err := exec.LookPath("gh"); err != nil {
+		return "", "", fmt.Errorf("no GitHub token found: set GITHUB_TOKEN or log in with 'gh auth login'")
+	}
+	output, err := exec.Command("gh", "auth", "token").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get GitHub token from gh: %w", err)
+	}
+	token := strings.TrimSpace(string(output))
+	if token == "" {
+		return "", "", fmt.Errorf("no GitHub token found: set GITHUB_TOKEN or log in with 'gh auth login'")
+	}
+	return token, "gh", nil
+}
+
+// APIURLEnv names the variable that points the client at another API than api.github.com, as
+// GitHub Enterprise Server runners set it and as tests do to use a fake API
+const APIURLEnv = "GITHUB_API_URL"
+
+// client returns the GitHub API client, authenticated once per process
+var client = sync.OnceValues(newClient)
+
+// newClient returns an API client authenticated with the user's token
+// Transient failures and rate limits are retried by the transport, so callers see one error
+func newClient() (*gogithub.Client, error) {
+	token, err := GetGitHubToken()
+	if err != nil {
+		return nil, err
+	}
+	httpClient := &http.Client{Transport: &oauth2.Transport{
+		Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}),
+		Base:   newCacheTransport(newRetryTransport(http.DefaultTransport)),
+	}}
+	gh := gogithub.NewClient(httpClient)
+	if apiURL := os.Getenv(APIURLEnv); apiURL != "" {
+		baseURL, err := url.Parse(strings.TrimSuffix(apiURL, "/") + "/")
+		if err != nil || baseURL.Host == "" {
+			return nil, fmt.Errorf("invalid %s: %q", APIURLEnv, apiURL)
+		}
+		gh.BaseURL = baseURL
+	}
+	return gh, nil
+}
+
+// downloadClient fetches artifact archives from their signed URLs, without the token
+var downloadClient = &http.Client{Transport: newRetryTransport(http.DefaultTransport)}
+
+// repoClient returns the API client along with the owner and name of the current repository
+func repoClient() (*gogithub.Client, string, string, error) {
+	gh, err := client()
+	if err != nil {
+		return nil, "", "", err
+	}
+	owner, repo, err := GetRepositoryInfo()
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to get repository info: %w", err)
+	}
+	return gh, owner, repo, nil
+}
+
+// isNotFound reports whether err is a 404 response from the API
+func isNotFound(err error) bool {
+	var response *gogithub.ErrorResponse
+	return errors.As(err, &response) && response.Response != nil && response.Response.StatusCode == http.StatusNotFound
+}
+
+// TokenType describes the kind of GitHub token from its prefix
+func TokenType(token string) string {
+	switch {
+	case strings.HasPrefix(token, "github_pat_"):
+		return "fine-grained personal access token"
+	case strings.HasPrefix(token, "ghp_"):
+		return "classic personal access token"
+	case strings.HasPrefix(token, "gho_"):
+		return "OAuth token"
+	case strings.HasPrefix(token, "ghu_"):
+		return "GitHub App user token"
+	case strings.HasPrefix(token, "ghs_"):
+		return "GitHub App installation token"
+	}
+	return "unknown token type"
+}
+
+// GetTokenScopes returns the OAuth scopes of the GitHub token
+// Fine-grained and GitHub App tokens have no scopes, so the list is empty for them
+func GetTokenScopes(ctx context.Context) ([]string, error) {
+	gh, err := client()
+	if err != nil {
+		return nil, err
+	}
+	_, response, err := gh.Users.Get(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token scopes: %w", err)
+	}
+
+	var scopes []string
+	for _, scope := range strings.Split(response.Header.Get("X-OAuth-Scopes"), ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// GetRepositoryPermission returns the role of login on the current repository
+// (admin, maintain, write, triage or read)
+func GetRepositoryPermission(ctx context.Context, login string) (string, error) {
+	gh, owner, repo, err := repoClient()
+	if err != nil {
+		return "", err
 	}
 
-	// Then try gh auth status
-	cmd := exec.Command("gh", "auth", "status", "--show-token")
-	output, err := cmd.Output()
+	level, _, err := gh.Repositories.GetPermissionLevel(ctx, owner, repo, login)
 	if err != nil {
-		return "", fmt.Errorf("failed to get GitHub token: %w", err)
+		return "", fmt.Errorf("failed to get repository permission: %w", err)
+	}
+	return level.GetRoleName(), nil
+}
+
+// roles orders the repository roles from least to most privileged
+var roles = []string{"read", "triage", "write", "maintain", "admin"}
+
+// scopeImpliedBy lists the broader OAuth scopes that include a scope
+var scopeImpliedBy = map[string][]string{
+	"public_repo":      {"repo"},
+	"repo:status":      {"repo"},
+	"read:public_key":  {"write:public_key", "admin:public_key"},
+	"write:public_key": {"admin:public_key"},
+	"read:org":         {"write:org", "admin:org"},
+}
+
+// missingScopes returns the scopes in need that the scopes in have do not grant
+func missingScopes(have, need []string) []string {
+	var missing []string
+	for _, scope := range need {
+		granted := slices.Contains(have, scope)
+		for _, broader := range scopeImpliedBy[scope] {
+			granted = granted || slices.Contains(have, broader)
+		}
+		if !granted {
+			missing = append(missing, scope)
+		}
 	}
+	return missing
+}
 
-	// Parse the output to extract the token
-	// Format: "  - Token: gho_..."
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "Token: ") {
-			// Extract token from line like "  - Token: gho_..."
-			parts := strings.Split(line, "Token: ")
-			if len(parts) == 2 {
-				return strings.TrimSpace(parts[1]), nil
+// roleAtLeast reports whether role is min or more privileged; custom roles are compared by the
+// base permission they extend
+func roleAtLeast(role, permission, min string) bool {
+	rank := slices.Index(roles, role)
+	if rank < 0 {
+		rank = slices.Index(roles, permission)
+	}
+	return rank >= 0 && rank >= slices.Index(roles, min)
+}
+
+// CheckAccess verifies that the token has scopes and that the authenticated user has at least
+// role on the current repository, so an operation fails before it changes anything
+// Fine-grained and GitHub App tokens report no scopes; their permissions surface as API errors
+func CheckAccess(ctx context.Context, scopes []string, role string) error {
+	token, source, err := GetGitHubTokenSource()
+	if err != nil {
+		return err
+	}
+	if len(scopes) > 0 {
+		have, err := GetTokenScopes(ctx)
+		if err != nil {
+			return err
+		}
+		classic := strings.HasPrefix(token, "ghp_") || strings.HasPrefix(token, "gho_")
+		if missing := missingScopes(have, scopes); len(missing) > 0 && (classic || len(have) > 0) {
+			fix := fmt.Sprintf("create a token with the %s scopes", strings.Join(scopes, ", "))
+			if source == "gh" {
+				fix = fmt.Sprintf("run 'gh auth refresh -s %s'", strings.Join(missing, ","))
 			}
+			return fmt.Errorf("the GitHub token from %s is missing the %s scope(s); %s", source, strings.Join(missing, ", "), fix)
 		}
 	}
 
-	return "", fmt.Errorf("no GitHub token found")
+	if role == "" {
+		return nil
+	}
+	gh, owner, repo, err := repoClient()
+	if err != nil {
+		return err
+	}
+	repository, _, err := gh.Repositories.Get(ctx, owner, repo)
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to get repository: %w", err)
+	}
+	if err := checkFork(repository); err != nil {
+		return err
+	}
+	user, _, err := gh.Users.Get(ctx, "")
+	if err != nil {
+		return fmt.Errorf("failed to get current user: %w", err)
+	}
+	level, _, err := gh.Repositories.GetPermissionLevel(ctx, owner, repo, user.GetLogin())
+	if isNotFound(err) {
+		return fmt.Errorf("%s cannot access %s/%s; check the remote and that the token can see the repository", user.GetLogin(), owner, repo)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get repository permission: %w", err)
+	}
+	if !roleAtLeast(level.GetRoleName(), level.GetPermission(), role) {
+		return fmt.Errorf("%s has the %s role on %s/%s, but this needs %s or higher; ask a repository admin", user.GetLogin(), level.GetRoleName(), owner, repo, role)
+	}
+	return nil
 }
 
 // GetCurrentUser gets the current authenticated user
 func GetCurrentUser(ctx context.Context) (string, error) {
-	cmd := exec.CommandContext(ctx, "gh", "api", "user", "--jq", ".login")
-	output, err := cmd.Output()
+	gh, err := client()
+	if err != nil {
+		return "", err
+	}
+	user, _, err := gh.Users.Get(ctx, "")
 	if err != nil {
 		return "", fmt.Errorf("failed to get current user: %w", err)
 	}
-
-	// Remove newline from output
-	username := strings.TrimSpace(string(output))
-	return username, nil
+	return user.GetLogin(), nil
 }
 
 // GetRepositoryInfo gets the owner and repository name from the current git remote
 func GetRepositoryInfo() (string, string, error) {
-	// Get the current repository
-	cmd := exec.Command("git", "remote", "get-url", "origin")
-	output, err := cmd.Output()
+	remoteURL, err := RemoteURL()
 	if err != nil {
-		return "", "", fmt.Errorf("failed to get remote URL: %w", err)
+		return "", "", err
 	}
+	return ParseRemoteURL(remoteURL)
+}
 
-	// Parse the remote URL to get owner/repo
-	// Format: git@github.com:owner/repo.git or https://github.com/owner/repo.git
-	remoteURL := strings.TrimSpace(string(output))
-	var owner, repo string
-	if strings.HasPrefix(remoteURL, "git@github.com:") {
-		parts := strings.Split(strings.TrimPrefix(remoteURL, "git@github.com:"), "/")
-		if len(parts) != 2 {
-			return "", "", fmt.Errorf("invalid remote URL format: %s", remoteURL)
-		}
-		owner = parts[0]
-		repo = strings.TrimSuffix(parts[1], ".git")
-	} else if strings.HasPrefix(remoteURL, "https://github.com/") {
-		parts := strings.Split(strings.TrimPrefix(remoteURL, "https://github.com/"), "/")
-		if len(parts) != 2 {
-			return "", "", fmt.Errorf("invalid remote URL format: %s", remoteURL)
-		}
-		owner = parts[0]
-		repo = strings.TrimSuffix(parts[1], ".git")
-	} else {
-		return "", "", fmt.Errorf("unsupported remote URL format: %s", remoteURL)
+// StoreEncryptionKey stores the encryption key as a GitHub repository secret, or an organization
+// secret when SecretScope is ScopeOrganization
+// The value is sealed to the repository's Actions public key before it leaves this machine
+func StoreEncryptionKey(ctx context.Context, key []byte) error {
+	gh, owner, repo, err := repoClient()
+	if err != nil {
+		return err
+	}
+	if SecretScope == ScopeOrganization {
+		return storeOrgSecret(ctx, gh, owner, repo, key)
 	}
 
-	return owner, repo, nil
+	value := []byte(base64.StdEncoding.EncodeToString(key))
+	defer clear(value)
+	if err := storeRepoSecret(ctx, gh, owner, repo, SecretName, value); err != nil {
+		return fmt.Errorf("failed to store encryption key: %w", err)
+	}
+	return nil
 }
 
-// StoreEncryptionKey stores the encryption key as a GitHub repository secret using GitHub CLI
-func StoreEncryptionKey(ctx context.Context, key []byte) error {
-	// Convert key to base64
-	keyB64 := base64.StdEncoding.EncodeToString(key)
+// StoreRepoSecret stores value as the Actions repository secret name
+func StoreRepoSecret(ctx context.Context, name string, value []byte) error {
+	gh, owner, repo, err := repoClient()
+	if err != nil {
+		return err
+	}
+	if err := storeRepoSecret(ctx, gh, owner, repo, name, value); err != nil {
+		return fmt.Errorf("failed to store the %s secret: %w", name, err)
+	}
+	return nil
+}
+
+// storeRepoSecret seals value to the repository's Actions public key and stores it as a secret
+func storeRepoSecret(ctx context.Context, gh *gogithub.Client, owner, repo, name string, value []byte) error {
+	publicKey, _, err := gh.Actions.GetRepoPublicKey(ctx, owner, repo)
+	if err != nil {
+		return fmt.Errorf("failed to get repository public key: %w", err)
+	}
+	sealed, err := sealSecret(publicKey.GetKey(), value)
+	if err != nil {
+		return err
+	}
+
+	secret := &gogithub.EncryptedSecret{
+		Name:           name,
+		KeyID:          publicKey.GetKeyID(),
+		EncryptedValue: sealed,
+	}
+	_, err = gh.Actions.CreateOrUpdateRepoSecret(ctx, owner, repo, secret)
+	return err
+}
+
+// StoreEnvironmentKey stores a key as the secret of a GitHub Environment, creating the
+// environment if it does not exist yet
+// Protection rules of an existing environment are left as they are
+func StoreEnvironmentKey(ctx context.Context, environment string, key []byte) error {
+	gh, owner, repo, err := repoClient()
+	if err != nil {
+		return err
+	}
+	repository, _, err := gh.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return fmt.Errorf("failed to get repository: %w", err)
+	}
+
+	_, _, err = gh.Repositories.GetEnvironment(ctx, owner, repo, environment)
+	if isNotFound(err) {
+		_, _, err = gh.Repositories.CreateUpdateEnvironment(ctx, owner, repo, environment, &gogithub.CreateUpdateEnvironment{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set up the %s environment: %w", environment, err)
+	}
+
+	value := []byte(base64.StdEncoding.EncodeToString(key))
+	defer clear(value)
+	if err := storeEnvSecret(ctx, gh, int(repository.GetID()), environment, SecretName, value); err != nil {
+		return fmt.Errorf("failed to store the %s environment key: %w", environment, err)
+	}
+	return nil
+}
+
+// StoreProtectedEnvironmentSecret stores value as a secret of a GitHub Environment that only
+// protected branches can deploy to, so a workflow pushed to any other branch cannot read it
+// The environment is created, or its branch policy tightened, as needed
+func StoreProtectedEnvironmentSecret(ctx context.Context, environment, name string, value []byte) error {
+	gh, owner, repo, err := repoClient()
+	if err != nil {
+		return err
+	}
+	repository, _, err := gh.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return fmt.Errorf("failed to get repository: %w", err)
+	}
+
+	policy := &gogithub.CreateUpdateEnvironment{
+		DeploymentBranchPolicy: &gogithub.BranchPolicy{
+			ProtectedBranches:    gogithub.Bool(true),
+			CustomBranchPolicies: gogithub.Bool(false),
+		},
+	}
+	if _, _, err := gh.Repositories.CreateUpdateEnvironment(ctx, owner, repo, environment, policy); err != nil {
+		return fmt.Errorf("failed to set up the %s environment: %w", environment, err)
+	}
+	if err := storeEnvSecret(ctx, gh, int(repository.GetID()), environment, name, value); err != nil {
+		return fmt.Errorf("failed to store the %s secret: %w", name, err)
+	}
+	return nil
+}
 
-	// Store the key using GitHub CLI
-	cmd := exec.CommandContext(ctx, "gh", "secret", "set", SecretName, "--body", keyB64)
-	if err := cmd.Run(); err != nil {
+// storeEnvSecret seals value to an environment's Actions public key and stores it as a secret
+func storeEnvSecret(ctx context.Context, gh *gogithub.Client, repoID int, environment, name string, value []byte) error {
+	publicKey, _, err := gh.Actions.GetEnvPublicKey(ctx, repoID, environment)
+	if err != nil {
+		return fmt.Errorf("failed to get the %s environment public key: %w", environment, err)
+	}
+	sealed, err := sealSecret(publicKey.GetKey(), value)
+	if err != nil {
+		return err
+	}
+
+	secret := &gogithub.EncryptedSecret{
+		Name:           name,
+		KeyID:          publicKey.GetKeyID(),
+		EncryptedValue: sealed,
+	}
+	_, err = gh.Actions.CreateOrUpdateEnvSecret(ctx, repoID, environment, secret)
+	return err
+}
+
+// EnvironmentSecretExists reports whether the key secret is set in a GitHub Environment
+// An empty environment checks the repository (or organization) secret
+func EnvironmentSecretExists(ctx context.Context, environment string) (bool, error) {
+	if environment == "" {
+		return SecretExists(ctx)
+	}
+	gh, owner, repo, err := repoClient()
+	if err != nil {
+		return false, err
+	}
+	repository, _, err := gh.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return false, fmt.Errorf("failed to get repository: %w", err)
+	}
+
+	_, _, err = gh.Actions.GetEnvSecret(ctx, int(repository.GetID()), environment, SecretName)
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get the %s environment secret: %w", environment, err)
+	}
+	return true, nil
+}
+
+// StoreCodespacesKey stores the encryption key as a Codespaces repository secret, which every
+// codespace of the repository sees as an environment variable named SecretName
+func StoreCodespacesKey(ctx context.Context, key []byte) error {
+	gh, owner, repo, err := repoClient()
+	if err != nil {
+		return err
+	}
+	publicKey, _, err := gh.Codespaces.GetRepoPublicKey(ctx, owner, repo)
+	if err != nil {
+		return fmt.Errorf("failed to get the Codespaces public key: %w", err)
+	}
+
+	value := []byte(base64.StdEncoding.EncodeToString(key))
+	defer clear(value)
+	sealed, err := sealSecret(publicKey.GetKey(), value)
+	if err != nil {
+		return err
+	}
+
+	secret := &gogithub.EncryptedSecret{
+		Name:           SecretName,
+		KeyID:          publicKey.GetKeyID(),
+		EncryptedValue: sealed,
+	}
+	if _, err := gh.Codespaces.CreateOrUpdateRepoSecret(ctx, owner, repo, secret); err != nil {
+		return fmt.Errorf("failed to store the Codespaces secret: %w", err)
+	}
+	return nil
+}
+
+// CodespacesSecretExists reports whether the key is set as a Codespaces repository secret
+func CodespacesSecretExists(ctx context.Context) (bool, error) {
+	gh, owner, repo, err := repoClient()
+	if err != nil {
+		return false, err
+	}
+	_, _, err = gh.Codespaces.GetRepoSecret(ctx, owner, repo, SecretName)
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get the Codespaces secret: %w", err)
+	}
+	return true, nil
+}
+
+// DeleteCodespacesKey deletes the Codespaces repository secret
+func DeleteCodespacesKey(ctx context.Context) error {
+	gh, owner, repo, err := repoClient()
+	if err != nil {
+		return err
+	}
+	if _, err := gh.Codespaces.DeleteRepoSecret(ctx, owner, repo, SecretName); err != nil {
+		return fmt.Errorf("failed to delete the Codespaces secret: %w", err)
+	}
+	return nil
+}
+
+// secretLabel names the key secret of an environment in messages
+func secretLabel(environment string) string {
+	if environment == "" {
+		return SecretName
+	}
+	return fmt.Sprintf("%s (environment %s)", SecretName, environment)
+}
+
+// storeOrgSecret stores the encryption key as an organization secret and makes sure the current
+// repository can read it
+// A new secret is only shared with the current repository; an existing one keeps its visibility
+// and the repositories it is shared with, and the current repository is added to them
+func storeOrgSecret(ctx context.Context, gh *gogithub.Client, org, repo string, key []byte) error {
+	repository, _, err := gh.Repositories.Get(ctx, org, repo)
+	if err != nil {
+		return fmt.Errorf("failed to get repository: %w", err)
+	}
+	if repository.GetOwner().GetType() != "Organization" {
+		return fmt.Errorf("%s is not owned by an organization; use a repository secret instead", repository.GetFullName())
+	}
+
+	publicKey, _, err := gh.Actions.GetOrgPublicKey(ctx, org)
+	if err != nil {
+		return fmt.Errorf("failed to get organization public key: %w", err)
+	}
+	value := []byte(base64.StdEncoding.EncodeToString(key))
+	defer clear(value)
+	sealed, err := sealSecret(publicKey.GetKey(), value)
+	if err != nil {
+		return err
+	}
+
+	secret := &gogithub.EncryptedSecret{
+		Name:           SecretName,
+		KeyID:          publicKey.GetKeyID(),
+		EncryptedValue: sealed,
+	}
+	existing, _, err := gh.Actions.GetOrgSecret(ctx, org, SecretName)
+	switch {
+	case isNotFound(err):
+		secret.Visibility = "selected"
+		secret.SelectedRepositoryIDs = gogithub.SelectedRepoIDs{repository.GetID()}
+	case err != nil:
+		return fmt.Errorf("failed to get organization secret: %w", err)
+	default:
+		secret.Visibility = existing.Visibility
+	}
+	if _, err := gh.Actions.CreateOrUpdateOrgSecret(ctx, org, secret); err != nil {
 		return fmt.Errorf("failed to store encryption key: %w", err)
 	}
 
+	if existing != nil && existing.Visibility == "selected" {
+		if _, err := gh.Actions.AddSelectedRepoToOrgSecret(ctx, org, SecretName, repository); err != nil {
+			return fmt.Errorf("failed to share the organization secret with %s: %w", repository.GetFullName(), err)
+		}
+	}
 	return nil
 }
 
+// repoOrgSecret returns the organization secret named SecretName if the current repository can
+// read it, or nil
+func repoOrgSecret(ctx context.Context, gh *gogithub.Client, owner, repo string) (*gogithub.Secret, error) {
+	opts := &gogithub.ListOptions{PerPage: 100}
+	for {
+		secrets, response, err := gh.Actions.ListRepoOrgSecrets(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list organization secrets: %w", err)
+		}
+		for _, secret := range secrets.Secrets {
+			if secret.Name == SecretName {
+				return secret, nil
+			}
+		}
+		if response.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = response.NextPage
+	}
+}
+
+// sealSecret encrypts value as a libsodium sealed box for the base64 Curve25519 public key, the
+// form the API expects secret values in
+func sealSecret(publicKey string, value []byte) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(decoded) != 32 {
+		return "", fmt.Errorf("invalid repository public key")
+	}
+	var recipient [32]byte
+	copy(recipient[:], decoded)
+
+	sealed, err := box.SealAnonymous(nil, value, &recipient, rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// keyArtifactFile is the file in the artifacts of older workflows that holds the base64 key in plaintext
+const keyArtifactFile = "encryption-key.txt"
+
 // GetEncryptionKey retrieves the encryption key via GitHub workflow
 func GetEncryptionKey(ctx context.Context) ([]byte, error) {
+	return GetEnvironmentKey(ctx, "")
+}
+
+// GetEnvironmentKey retrieves the key of a GitHub Environment via the workflow, which runs its job
+// in that environment so the environment's secret is read and its protection rules apply
+// An empty environment is the repository key
+func GetEnvironmentKey(ctx context.Context, environment string) ([]byte, error) {
+	// Without the secret the workflow can only fail, so report that directly
+	if exists, err := EnvironmentSecretExists(ctx, environment); err == nil && !exists {
+		return nil, fmt.Errorf("%w: %s", ErrSecretMissing, secretLabel(environment))
+	}
+
+	gh, owner, repo, err := repoClient()
+	if err != nil {
+		return nil, err
+	}
 	currentUser, err := GetCurrentUser(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get current user: %w", err)
 	}
 
-	fmt.Printf("Triggering GitHub workflow to retrieve encryption key...\n")
+	repository, _, err := gh.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repository: %w", err)
+	}
+	// Runs dispatched where they cannot work would only fail minutes later
+	if err := checkKeyRequest(repository, currentUser); err != nil {
+		return nil, err
+	}
 
-	// Trigger the workflow to get the key
-	cmd := exec.CommandContext(ctx, "gh", "workflow", "run", WorkflowName,
-		"--field", "action=get-key",
-		"--field", fmt.Sprintf("user=%s", currentUser))
+	// Runs newer than the latest one before the dispatch belong to it when the workflow predates
+	// dispatch IDs
+	previousRunID, err := latestDispatchRunID(ctx, gh, owner, repo, currentUser)
+	if err != nil {
+		return nil, err
+	}
+	dispatchID, err := newDispatchID()
+	if err != nil {
+		return nil, err
+	}
 
-	if err := cmd.Run(); err != nil {
+	fmt.Fprintf(os.Stderr, "Triggering GitHub workflow to retrieve encryption key...\n")
+
+	// Trigger the workflow to get the key
+	inputs := map[string]interface{}{"action": "get-key", "user": currentUser, "dispatch_id": dispatchID}
+	if environment != "" {
+		// Only sent when set, so workflows written before environments existed keep working
+		inputs["environment"] = environment
+	}
+	event := gogithub.CreateWorkflowDispatchEventRequest{
+		Ref:    repository.GetDefaultBranch(),
+		Inputs: inputs,
+	}
+	_, err = gh.Actions.CreateWorkflowDispatchEventByFileName(ctx, owner, repo, WorkflowName, event)
+	if isUnexpectedInput(err, "dispatch_id") {
+		fmt.Fprintf(os.Stderr, "Warning: the workflow predates dispatch IDs, so concurrent key requests may pick up each other's run; update it with 'git ez-env init --update-workflow' and push\n")
+		delete(inputs, "dispatch_id")
+		dispatchID = ""
+		_, err = gh.Actions.CreateWorkflowDispatchEventByFileName(ctx, owner, repo, WorkflowName, event)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("failed to trigger workflow: %w", err)
 	}
 
-	// Wait a moment for the workflow to start
-	time.Sleep(2 * time.Second)
+	runID, err := waitForRun(ctx, gh, owner, repo, currentUser, dispatchID, previousRunID)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Waiting for workflow run %d to complete...\n", runID)
+
+	if err := waitForCompletion(ctx, gh, owner, repo, runID, environment); err != nil {
+		return nil, err
+	}
+
+	// Wait for artifacts to be available
+	artifactName := "encryption-key-" + currentUser
+	if dispatchID != "" {
+		artifactName = "encryption-key-" + dispatchID
+	}
+	fmt.Fprintf(os.Stderr, "Waiting for encryption key artifact to be available...\n")
+
+	artifactID, err := waitForArtifact(ctx, gh, owner, repo, runID, artifactName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for artifact: %w", err)
+	}
 
-	// Get the latest workflow run for this workflow
-	cmd = exec.CommandContext(ctx, "gh", "run", "list", "--workflow", WorkflowName, "--limit", "1", "--json", "databaseId,status")
-	output, err := cmd.Output()
+	// Download the artifact; the key is read from the archive in memory and never written to disk
+	fmt.Fprintf(os.Stderr, "Downloading encryption key artifact...\n")
+
+	archive, err := downloadArtifact(ctx, gh, owner, repo, artifactID)
+	if err != nil {
+		return nil, err
+	}
+	keyData, err := artifactKey(archive)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get workflow run: %w", err)
+		return nil, err
 	}
+	defer clear(keyData)
 
-	var runs []struct {
-		DatabaseID int    `json:"databaseId"`
-		Status     string `json:"status"`
+	// Decode the base64 key
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(keyData)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key: %w", err)
 	}
-	if err := json.Unmarshal(output, &runs); err != nil {
-		return nil, fmt.Errorf("failed to parse workflow runs: %w", err)
+
+	fmt.Fprintf(os.Stderr, "✓ Encryption key retrieved successfully\n")
+	return key, nil
+}
+
+// latestDispatchRunID returns the id of the newest run of the workflow that login dispatched, or 0
+func latestDispatchRunID(ctx context.Context, gh *gogithub.Client, owner, repo, login string) (int64, error) {
+	opts := &gogithub.ListWorkflowRunsOptions{
+		Actor:       login,
+		Event:       "workflow_dispatch",
+		ListOptions: gogithub.ListOptions{PerPage: 1},
+	}
+	runs, _, err := gh.Actions.ListWorkflowRunsByFileName(ctx, owner, repo, WorkflowName, opts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get workflow run: %w", err)
+	}
+	if len(runs.WorkflowRuns) == 0 {
+		return 0, nil
+	}
+	return runs.WorkflowRuns[0].GetID(), nil
+}
+
+// newDispatchID returns a random UUID that the workflow puts in the name of the run it dispatches
+func newDispatchID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", fmt.Errorf("failed to generate dispatch ID: %w", err)
+	}
+	id[6] = id[6]&0x0f | 0x40 // version 4
+	id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]), nil
+}
+
+// isUnexpectedInput reports whether a dispatch was rejected because the workflow does not declare input
+func isUnexpectedInput(err error, input string) bool {
+	var errResp *gogithub.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Response != nil &&
+		errResp.Response.StatusCode == http.StatusUnprocessableEntity &&
+		strings.Contains(errResp.Message, "Unexpected inputs") && strings.Contains(errResp.Message, input)
+}
+
+// dispatchRunID returns the id of the run whose name carries dispatchID, or 0
+func dispatchRunID(runs []*gogithub.WorkflowRun, dispatchID string) int64 {
+	for _, run := range runs {
+		if strings.Contains(run.GetDisplayTitle(), dispatchID) {
+			return run.GetID()
+		}
 	}
+	return 0
+}
 
-	if len(runs) == 0 {
-		return nil, fmt.Errorf("no workflow runs found")
+// waitForRun waits for the run created by a dispatch to appear and returns its id
+// The run is found by its dispatch ID, or without one as the first run newer than previousRunID
+func waitForRun(ctx context.Context, gh *gogithub.Client, owner, repo, login, dispatchID string, previousRunID int64) (int64, error) {
+	for i := 0; i < 30; i++ { // Wait up to 30 seconds for the run to be created
+		if dispatchID != "" {
+			opts := &gogithub.ListWorkflowRunsOptions{
+				Actor:       login,
+				Event:       "workflow_dispatch",
+				ListOptions: gogithub.ListOptions{PerPage: 20},
+			}
+			runs, _, err := gh.Actions.ListWorkflowRunsByFileName(ctx, owner, repo, WorkflowName, opts)
+			if err != nil {
+				return 0, fmt.Errorf("failed to get workflow run: %w", err)
+			}
+			if runID := dispatchRunID(runs.WorkflowRuns, dispatchID); runID != 0 {
+				return runID, nil
+			}
+		} else {
+			runID, err := latestDispatchRunID(ctx, gh, owner, repo, login)
+			if err != nil {
+				return 0, err
+			}
+			if runID > previousRunID {
+				return runID, nil
+			}
+		}
+		if err := sleep(ctx, time.Second); err != nil {
+			return 0, err
+		}
 	}
+	return 0, fmt.Errorf("no workflow runs found")
+}
 
-	runID := runs[0].DatabaseID
-	fmt.Printf("Waiting for workflow run %d to complete...\n", runID)
+// approvalTimeout bounds how long a run may wait for a reviewer to approve access to an environment
+const approvalTimeout = 15 * time.Minute
 
-	// Wait for the workflow to complete
+// waitForCompletion polls a workflow run until it completes and fails unless it succeeded
+// Time spent waiting for a protected environment to be approved does not count against the limit
+func waitForCompletion(ctx context.Context, gh *gogithub.Client, owner, repo string, runID int64, environment string) error {
+	approvalDeadline := time.Now().Add(approvalTimeout)
+	announced := false
 	for i := 0; i < 60; i++ { // Wait up to 60 seconds
-		cmd = exec.CommandContext(ctx, "gh", "run", "view", fmt.Sprintf("%d", runID), "--json", "status,conclusion")
-		output, err := cmd.Output()
+		run, _, err := gh.Actions.GetWorkflowRunByID(ctx, owner, repo, runID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to check workflow status: %w", err)
+			return fmt.Errorf("failed to check workflow status: %w", err)
 		}
 
-		var run struct {
-			Status     string `json:"status"`
-			Conclusion string `json:"conclusion"`
-		}
-		if err := json.Unmarshal(output, &run); err != nil {
-			return nil, fmt.Errorf("failed to parse workflow status: %w", err)
+		if run.GetStatus() == "waiting" {
+			if !announced {
+				waitingFor, approvers := environment, "a reviewer"
+				if deployments, _, err := gh.Actions.GetPendingDeployments(ctx, owner, repo, runID); err == nil && len(deployments) > 0 {
+					waitingFor, approvers = pendingApproval(deployments)
+				}
+				fmt.Fprintf(os.Stderr, "Workflow run %d is awaiting approval from %s to access the %s environment...\n", runID, approvers, waitingFor)
+				fmt.Fprintf(os.Stderr, "Approve it at %s\n", run.GetHTMLURL())
+				announced = true
+			}
+			if time.Now().After(approvalDeadline) {
+				return fmt.Errorf("workflow run %d was not approved within %s", runID, approvalTimeout)
+			}
+			i--
+			if err := sleep(ctx, 5*time.Second); err != nil {
+				return err
+			}
+			continue
 		}
 
-		if run.Status == "completed" {
-			if run.Conclusion == "success" {
-				fmt.Printf("✓ Workflow completed successfully\n")
-				break
-			} else if run.Conclusion == "failure" {
-				return nil, fmt.Errorf("workflow failed with conclusion: %s", run.Conclusion)
-			} else if run.Conclusion == "cancelled" {
-				return nil, fmt.Errorf("workflow was cancelled")
-			} else {
-				return nil, fmt.Errorf("workflow completed with unexpected conclusion: %s", run.Conclusion)
+		if run.GetStatus() == "completed" {
+			switch conclusion := run.GetConclusion(); conclusion {
+			case "success":
+				fmt.Fprintf(os.Stderr, "✓ Workflow completed successfully\n")
+				return nil
+			case "failure":
+				return fmt.Errorf("workflow failed with conclusion: %s", conclusion)
+			case "cancelled":
+				return fmt.Errorf("workflow was cancelled")
+			default:
+				return fmt.Errorf("workflow completed with unexpected conclusion: %s", conclusion)
 			}
-		} else if run.Status == "failed" {
-			return nil, fmt.Errorf("workflow failed")
-		} else if run.Status == "cancelled" {
-			return nil, fmt.Errorf("workflow was cancelled")
 		}
 
 		// Show progress for longer waits
 		if i > 0 && i%10 == 0 {
-			fmt.Printf("Still waiting for workflow completion... (attempt %d/60)\n", i+1)
+			fmt.Fprintf(os.Stderr, "Still waiting for workflow completion... (attempt %d/60)\n", i+1)
 		}
 
-		time.Sleep(1 * time.Second)
+		if err := sleep(ctx, time.Second); err != nil {
+			return err
+		}
 	}
+	return fmt.Errorf("workflow run %d did not complete after 60 seconds", runID)
+}
 
-	// Wait for artifacts to be available
-	artifactName := fmt.Sprintf("encryption-key-%s", currentUser)
-	fmt.Printf("Waiting for encryption key artifact to be available...\n")
+// waitForArtifact polls the GitHub API until the specified artifact is available and returns its id
+func waitForArtifact(ctx context.Context, gh *gogithub.Client, owner, repo string, runID int64, artifactName string) (int64, error) {
+	for i := 0; i < 30; i++ { // Wait up to 30 seconds for artifacts
+		artifacts, _, err := gh.Actions.ListWorkflowRunArtifacts(ctx, owner, repo, runID, &gogithub.ListOptions{PerPage: 100})
+		if err == nil {
+			for _, artifact := range artifacts.Artifacts {
+				if artifact.GetName() == artifactName && !artifact.GetExpired() {
+					return artifact.GetID(), nil
+				}
+			}
+		}
 
-	if err := waitForArtifact(ctx, runID, artifactName); err != nil {
-		return nil, fmt.Errorf("failed to wait for artifact: %w", err)
+		if err := sleep(ctx, time.Second); err != nil {
+			return 0, err
+		}
 	}
+	return 0, fmt.Errorf("artifact %s not available after 30 seconds", artifactName)
+}
 
-	// Download the artifact
-	fmt.Printf("Downloading encryption key artifact...\n")
+// downloadArtifact downloads the zip archive of an artifact
+func downloadArtifact(ctx context.Context, gh *gogithub.Client, owner, repo string, artifactID int64) ([]byte, error) {
+	location, _, err := gh.Actions.DownloadArtifact(ctx, owner, repo, artifactID, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download artifact: %w", err)
+	}
 
-	// Remove existing file if it exists to avoid download conflicts
-	os.Remove("encryption-key.txt")
+	// The archive is served from a signed URL that must not receive the token
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download artifact: %w", err)
+	}
+	response, err := downloadClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download artifact: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download artifact: %s", response.Status)
+	}
 
-	cmd = exec.CommandContext(ctx, "gh", "run", "download", fmt.Sprintf("%d", runID), "--name", artifactName)
-	if err := cmd.Run(); err != nil {
+	archive, err := io.ReadAll(response.Body)
+	if err != nil {
 		return nil, fmt.Errorf("failed to download artifact: %w", err)
 	}
+	return archive, nil
+}
 
-	// Read the key from the downloaded file
-	keyData, err := os.ReadFile("encryption-key.txt")
+// readArtifactFile returns the content of the named file in an artifact zip archive
+func readArtifactFile(archive []byte, name string) ([]byte, error) {
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open artifact: %w", err)
+	}
+	file, err := reader.Open(name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read key file: %w", err)
+		return nil, fmt.Errorf("failed to read %s from artifact: %w", name, err)
 	}
+	defer file.Close()
 
-	// Clean up the downloaded file
-	os.Remove("encryption-key.txt")
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from artifact: %w", name, err)
+	}
+	return content, nil
+}
 
-	// Decode the base64 key
-	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(keyData)))
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// DeleteEncryptionKey deletes the encryption key repository secret
+// An organization secret is shared with other repositories, so it is only unshared from this one
+func DeleteEncryptionKey(ctx context.Context) error {
+	gh, owner, repo, err := repoClient()
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode key: %w", err)
+		return err
+	}
+	if SecretScope == ScopeOrganization {
+		repository, _, err := gh.Repositories.Get(ctx, owner, repo)
+		if err != nil {
+			return fmt.Errorf("failed to get repository: %w", err)
+		}
+		_, err = gh.Actions.RemoveSelectedRepoFromOrgSecret(ctx, owner, SecretName, repository)
+		var response *gogithub.ErrorResponse
+		if errors.As(err, &response) && response.Response != nil && response.Response.StatusCode == http.StatusConflict {
+			return fmt.Errorf("the organization secret %s is shared with every repository; an organization admin has to delete it", SecretName)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to remove %s from the organization secret: %w", repository.GetFullName(), err)
+		}
+		return nil
+	}
+	if _, err := gh.Actions.DeleteRepoSecret(ctx, owner, repo, SecretName); err != nil {
+		return fmt.Errorf("failed to delete encryption key secret: %w", err)
 	}
+	return nil
+}
 
-	fmt.Printf("✓ Encryption key retrieved successfully\n")
-	return key, nil
+// Collaborator is a repository collaborator and their role (admin, maintain, write, triage or read)
+type Collaborator struct {
+	Login      string
+	Permission string
+	// Push reports whether the collaborator can push, which custom role names do not tell
+	Push bool
 }
 
-// waitForArtifact polls the GitHub API to check if the specified artifact is available
-func waitForArtifact(ctx context.Context, runID int, artifactName string) error {
-	// Get repository info for API calls
-	owner, repo, err := GetRepositoryInfo()
+// ListCollaboratorPermissions returns every collaborator on the current repository with their role
+func ListCollaboratorPermissions(ctx context.Context) ([]Collaborator, error) {
+	gh, owner, repo, err := repoClient()
 	if err != nil {
-		return fmt.Errorf("failed to get repository info: %w", err)
+		return nil, err
 	}
 
-	// Poll for artifact availability
-	for i := 0; i < 30; i++ { // Wait up to 30 seconds for artifacts
-		// Check if artifact exists using GitHub API
-		cmd := exec.CommandContext(ctx, "gh", "api",
-			fmt.Sprintf("repos/%s/%s/actions/runs/%d/artifacts", owner, repo, runID),
-			"--jq", fmt.Sprintf(`.[] | select(.name == "%s") | .id`, artifactName))
+	var collaborators []Collaborator
+	opts := &gogithub.ListCollaboratorsOptions{ListOptions: gogithub.ListOptions{PerPage: 100}}
+	for {
+		users, response, err := gh.Repositories.ListCollaborators(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list collaborators: %w", err)
+		}
+		for _, user := range users {
+			collaborators = append(collaborators, Collaborator{Login: user.GetLogin(), Permission: user.GetRoleName(), Push: user.GetPermissions()["push"]})
+		}
+		if response.NextPage == 0 {
+			return collaborators, nil
+		}
+		opts.Page = response.NextPage
+	}
+}
+
+// GetUserSSHKeys returns the public SSH keys a user has registered on GitHub in authorized_keys format
+func GetUserSSHKeys(ctx context.Context, login string) ([]string, error) {
+	gh, err := client()
+	if err != nil {
+		return nil, err
+	}
 
-		output, err := cmd.Output()
-		if err == nil && len(strings.TrimSpace(string(output))) > 0 {
-			// Artifact found
-			return nil
+	var keys []string
+	opts := &gogithub.ListOptions{PerPage: 100}
+	for {
+		page, response, err := gh.Users.ListKeys(ctx, login, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get SSH keys for %s: %w", login, err)
+		}
+		for _, key := range page {
+			if key.GetKey() != "" {
+				keys = append(keys, key.GetKey())
+			}
 		}
+		if response.NextPage == 0 {
+			return keys, nil
+		}
+		opts.Page = response.NextPage
+	}
+}
 
-		// Wait before next check
-		time.Sleep(1 * time.Second)
+// GetUserGPGKeys returns the ASCII-armored OpenPGP public keys a user has registered on GitHub
+func GetUserGPGKeys(ctx context.Context, login string) ([]string, error) {
+	gh, err := client()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	opts := &gogithub.ListOptions{PerPage: 100}
+	for {
+		page, response, err := gh.Users.ListGPGKeys(ctx, login, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get GPG keys for %s: %w", login, err)
+		}
+		for _, key := range page {
+			if key.GetRawKey() != "" {
+				keys = append(keys, key.GetRawKey())
+			}
+		}
+		if response.NextPage == 0 {
+			return keys, nil
+		}
+		opts.Page = response.NextPage
+	}
+}
+
+// GetSecretUpdatedAt returns when the encryption key secret was last set
+func GetSecretUpdatedAt(ctx context.Context) (time.Time, error) {
+	gh, owner, repo, err := repoClient()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if SecretScope == ScopeOrganization {
+		secret, err := repoOrgSecret(ctx, gh, owner, repo)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if secret == nil {
+			return time.Time{}, fmt.Errorf("%w: %s", ErrSecretMissing, SecretName)
+		}
+		return secret.UpdatedAt.Time, nil
 	}
 
-	return fmt.Errorf("artifact %s not available after 30 seconds", artifactName)
+	secret, _, err := gh.Actions.GetRepoSecret(ctx, owner, repo, SecretName)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get secret metadata: %w", err)
+	}
+	return secret.UpdatedAt.Time, nil
+}
+
+// CheckAuthentication verifies that a GitHub token is available and accepted by the API
+func CheckAuthentication(ctx context.Context) error {
+	if _, err := GetCurrentUser(ctx); err != nil {
+		return fmt.Errorf("not authenticated with GitHub: %w", err)
+	}
+	return nil
+}
+
+// SecretExists reports whether the encryption key secret is set on the repository
+// An organization secret only counts when it is shared with the repository
+func SecretExists(ctx context.Context) (bool, error) {
+	gh, owner, repo, err := repoClient()
+	if err != nil {
+		return false, err
+	}
+	if SecretScope == ScopeOrganization {
+		secret, err := repoOrgSecret(ctx, gh, owner, repo)
+		return secret != nil, err
+	}
+
+	_, _, err = gh.Actions.GetRepoSecret(ctx, owner, repo, SecretName)
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get repository secret: %w", err)
+	}
+	return true, nil
+}
+
+// ActionsEnabled reports whether GitHub Actions is enabled for the repository
+func ActionsEnabled(ctx context.Context) (bool, error) {
+	gh, owner, repo, err := repoClient()
+	if err != nil {
+		return false, err
+	}
+
+	permissions, _, err := gh.Repositories.GetActionsPermissions(ctx, owner, repo)
+	if err != nil {
+		return false, fmt.Errorf("failed to get Actions permissions: %w", err)
+	}
+	return permissions.GetEnabled(), nil
 }
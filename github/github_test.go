@@ -1,113 +1,115 @@
 package github
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"net/http"
 	"os"
 	"os/exec"
-	"strings"
+	"path/filepath"
+	"slices"
+	"sync"
 	"testing"
 
+	gogithub "github.com/google/go-github/v66/github"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/nacl/box"
+
+	"github.com/oliviaBahr/ez-env/githubtest"
+	"github.com/oliviaBahr/ez-env/ssh"
 )
 
+// useFakeAPI points the client at a fake API for acme/app accessed by octocat, from a temporary
+// clone whose origin is the repository, with an SSH key of octocat the workflow encrypts the key to
+func useFakeAPI(t *testing.T) *githubtest.Server {
+	server := githubtest.NewServer(t, "acme", "app", "octocat")
+	keyPath, authorizedKey := githubtest.WriteSSHKey(t, t.TempDir())
+	server.AddSSHKey(authorizedKey)
+	t.Setenv(ssh.PrivateKeyEnv, keyPath)
+	t.Setenv("GITHUB_TOKEN", "gho_fake_token")
+	t.Setenv(APIURLEnv, server.URL)
+
+	original := client
+	client = sync.OnceValues(newClient)
+	t.Cleanup(func() { client = original })
+
+	dir := t.TempDir()
+	require.NoError(t, exec.Command("git", "init", "-q", dir).Run())
+	require.NoError(t, exec.Command("git", "-C", dir, "remote", "add", "origin", "https://github.com/acme/app.git").Run())
+	originalDir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(originalDir) })
+	return server
+}
+
 // TestGetGitHubToken tests the GitHub token retrieval functionality
 func TestGetGitHubToken(t *testing.T) {
+	// gh is stubbed, so the fallback does not depend on who runs the tests
+	bin := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "gh"), []byte("#!/bin/sh\necho gho_stub_token\n"), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("GH_TOKEN", "")
+
 	tests := []struct {
-		name      string
-		setEnv    bool
-		envToken  string
-		expectErr bool
+		name     string
+		envToken string
+		want     string
+		source   string
 	}{
 		{
-			name:      "gets token from environment variable",
-			setEnv:    true,
-			envToken:  "gho_test_token_123",
-			expectErr: false,
+			name:     "gets token from environment variable",
+			envToken: "gho_test_token_123",
+			want:     "gho_test_token_123",
+			source:   "GITHUB_TOKEN",
 		},
 		{
-			name:      "falls back to gh auth when env not set",
-			setEnv:    false,
-			expectErr: false,
+			name:   "falls back to gh auth when env not set",
+			want:   "gho_stub_token",
+			source: "gh",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Save original environment
-			originalToken := os.Getenv("GITHUB_TOKEN")
-
-			if tt.setEnv {
-				os.Setenv("GITHUB_TOKEN", tt.envToken)
-				defer os.Setenv("GITHUB_TOKEN", originalToken)
-			} else {
-				os.Unsetenv("GITHUB_TOKEN")
-				defer os.Setenv("GITHUB_TOKEN", originalToken)
-			}
+			t.Setenv("GITHUB_TOKEN", tt.envToken)
 
-			token, err := GetGitHubToken()
-
-			if tt.expectErr {
-				assert.Error(t, err)
-				return
-			}
-
-			if tt.setEnv {
-				assert.NoError(t, err)
-				assert.Equal(t, tt.envToken, token)
-			} else {
-				// When falling back to gh auth, we should get a valid token
-				assert.NoError(t, err)
-				assert.NotEmpty(t, token)
-				assert.True(t, strings.HasPrefix(token, "gho_") || strings.HasPrefix(token, "ghp_"))
-			}
+			token, source, err := GetGitHubTokenSource()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, token)
+			assert.Equal(t, tt.source, source)
 		})
 	}
 }
 
 // TestGetCurrentUser tests getting the current authenticated user
 func TestGetCurrentUser(t *testing.T) {
-	ctx := context.Background()
+	useFakeAPI(t)
 
-	username, err := GetCurrentUser(ctx)
+	username, err := GetCurrentUser(context.Background())
 	assert.NoError(t, err)
-	assert.NotEmpty(t, username)
-	assert.True(t, len(username) > 0)
+	assert.Equal(t, "octocat", username)
 }
 
 // TestGetRepositoryInfo tests repository information retrieval
 func TestGetRepositoryInfo(t *testing.T) {
-	// Save current directory
-	originalDir, err := os.Getwd()
-	require.NoError(t, err)
-	defer os.Chdir(originalDir)
-
-	// Change to the test repository
-	testRepoPath := "../../ez-test-env"
-	err = os.Chdir(testRepoPath)
-	require.NoError(t, err)
+	useFakeAPI(t)
 
 	owner, repo, err := GetRepositoryInfo()
 	assert.NoError(t, err)
-	assert.NotEmpty(t, owner)
-	assert.NotEmpty(t, repo)
-	assert.Equal(t, "ez-test-env", repo)
+	assert.Equal(t, "acme", owner)
+	assert.Equal(t, "app", repo)
 }
 
 // TestStoreAndRetrieveEncryptionKey tests the full round-trip of storing and retrieving an encryption key
 func TestStoreAndRetrieveEncryptionKey(t *testing.T) {
 	ctx := context.Background()
-
-	// Change to the test repository for this test
-	originalDir, err := os.Getwd()
-	require.NoError(t, err)
-	defer os.Chdir(originalDir)
-
-	testRepoPath := "../../ez-test-env"
-	err = os.Chdir(testRepoPath)
-	require.NoError(t, err)
+	server := useFakeAPI(t)
 
 	// Generate a test key (32 bytes)
 	testKey := make([]byte, 32)
@@ -116,67 +118,50 @@ func TestStoreAndRetrieveEncryptionKey(t *testing.T) {
 	}
 
 	t.Run("store encryption key", func(t *testing.T) {
-		// Store the key
-		err := StoreEncryptionKey(ctx, testKey)
-		assert.NoError(t, err)
+		require.NoError(t, StoreEncryptionKey(ctx, testKey))
+
+		// The secret holds the key in base64, as the workflow reads it
+		stored, ok := server.Secret(SecretName)
+		require.True(t, ok)
+		assert.Equal(t, base64.StdEncoding.EncodeToString(testKey), stored)
 	})
 
 	t.Run("retrieve encryption key via workflow", func(t *testing.T) {
-		// Retrieve the key via workflow
 		// The workflow should return the actual stored key
 		retrievedKey, err := GetEncryptionKey(ctx)
-		assert.NoError(t, err)
-		assert.NotNil(t, retrievedKey)
-		assert.Len(t, retrievedKey, 32, "Encryption key should be 32 bytes")
-
-		// The retrieved key should match the stored key
+		require.NoError(t, err)
 		assert.Equal(t, testKey, retrievedKey, "Retrieved key should match stored key")
+		assert.Equal(t, 1, server.Dispatches())
 	})
 }
 
 // TestStoreEncryptionKey tests storing an encryption key
 func TestStoreEncryptionKey(t *testing.T) {
 	ctx := context.Background()
-
-	// Change to the test repository for this test
-	originalDir, err := os.Getwd()
-	require.NoError(t, err)
-	defer os.Chdir(originalDir)
-
-	testRepoPath := "../../ez-test-env"
-	err = os.Chdir(testRepoPath)
-	require.NoError(t, err)
+	server := useFakeAPI(t)
 
 	tests := []struct {
-		name      string
-		expectErr bool
+		name string
+		key  byte
 	}{
 		{
-			name:      "stores valid encryption key",
-			expectErr: false,
+			name: "stores valid encryption key",
+			key:  1,
 		},
 		{
-			name:      "stores another valid key",
-			expectErr: false,
+			name: "replaces the stored key",
+			key:  2,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Generate a test key (32 bytes)
-			testKey := make([]byte, 32)
-			for i := range testKey {
-				testKey[i] = byte(i + 1)
-			}
-
-			err = StoreEncryptionKey(ctx, testKey)
+			testKey := bytes.Repeat([]byte{tt.key}, 32)
 
-			if tt.expectErr {
-				assert.Error(t, err)
-				return
-			}
-
-			assert.NoError(t, err)
+			require.NoError(t, StoreEncryptionKey(ctx, testKey))
+			stored, ok := server.Secret(SecretName)
+			require.True(t, ok)
+			assert.Equal(t, base64.StdEncoding.EncodeToString(testKey), stored)
 		})
 	}
 }
@@ -184,21 +169,21 @@ func TestStoreEncryptionKey(t *testing.T) {
 // TestGetEncryptionKey tests retrieving an encryption key
 func TestGetEncryptionKey(t *testing.T) {
 	ctx := context.Background()
+	server := useFakeAPI(t)
 
-	// Change to the test repository for this test
-	originalDir, err := os.Getwd()
-	require.NoError(t, err)
-	defer os.Chdir(originalDir)
-
-	testRepoPath := "../../ez-test-env"
-	err = os.Chdir(testRepoPath)
-	require.NoError(t, err)
+	t.Run("missing secret", func(t *testing.T) {
+		_, err := GetEncryptionKey(ctx)
+		assert.ErrorIs(t, err, ErrSecretMissing)
+		assert.Zero(t, server.Dispatches(), "no workflow runs without the secret")
+	})
 
 	t.Run("retrieve encryption key", func(t *testing.T) {
+		testKey := bytes.Repeat([]byte{7}, 32)
+		server.SetSecret(SecretName, base64.StdEncoding.EncodeToString(testKey))
+
 		key, err := GetEncryptionKey(ctx)
-		assert.NoError(t, err)
-		assert.NotNil(t, key)
-		assert.Len(t, key, 32, "Encryption key should be 32 bytes")
+		require.NoError(t, err)
+		assert.Equal(t, testKey, key)
 	})
 }
 
@@ -235,13 +220,6 @@ func TestBase64Encoding(t *testing.T) {
 	}
 }
 
-// TestGitHubCLIAvailability tests that GitHub CLI is available
-func TestGitHubCLIAvailability(t *testing.T) {
-	cmd := exec.Command("gh", "--version")
-	err := cmd.Run()
-	assert.NoError(t, err)
-}
-
 // TestGitRemoteURLParsing tests the parsing of different git remote URL formats
 func TestGitRemoteURLParsing(t *testing.T) {
 	tests := []struct {
@@ -282,6 +260,40 @@ func TestGitRemoteURLParsing(t *testing.T) {
 			remoteURL: "https://github.com/invalid",
 			expectErr: true,
 		},
+		{
+			name:        "parses ssh:// URL",
+			remoteURL:   "ssh://git@github.com/testuser/testrepo.git",
+			expectOwner: "testuser",
+			expectRepo:  "testrepo",
+		},
+		{
+			name:        "parses ssh:// URL with port",
+			remoteURL:   "ssh://git@github.com:22/testuser/testrepo",
+			expectOwner: "testuser",
+			expectRepo:  "testrepo",
+		},
+		{
+			name:        "parses HTTPS URL with user and trailing slash",
+			remoteURL:   "https://someone@github.com/testuser/testrepo/",
+			expectOwner: "testuser",
+			expectRepo:  "testrepo",
+		},
+		{
+			name:        "parses SSH URL with another user",
+			remoteURL:   "org-123@github.com:testuser/testrepo.git",
+			expectOwner: "testuser",
+			expectRepo:  "testrepo",
+		},
+		{
+			name:      "fails with too deep path",
+			remoteURL: "https://github.com/testuser/testrepo/tree/main",
+			expectErr: true,
+		},
+		{
+			name:      "fails with unsupported scheme",
+			remoteURL: "ftp://github.com/testuser/testrepo.git",
+			expectErr: true,
+		},
 		{
 			name:      "fails with unsupported URL",
 			remoteURL: "https://gitlab.com/testuser/testrepo.git",
@@ -291,31 +303,7 @@ func TestGitRemoteURLParsing(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// This is a helper function to test the parsing logic
-			// We'll extract the parsing logic from GetRepositoryInfo for testing
-			var owner, repo string
-			var err error
-
-			if strings.HasPrefix(tt.remoteURL, "git@github.com:") {
-				parts := strings.Split(strings.TrimPrefix(tt.remoteURL, "git@github.com:"), "/")
-				if len(parts) != 2 {
-					err = fmt.Errorf("invalid remote URL format: %s", tt.remoteURL)
-				} else {
-					owner = parts[0]
-					repo = strings.TrimSuffix(parts[1], ".git")
-				}
-			} else if strings.HasPrefix(tt.remoteURL, "https://github.com/") {
-				parts := strings.Split(strings.TrimPrefix(tt.remoteURL, "https://github.com/"), "/")
-				if len(parts) != 2 {
-					err = fmt.Errorf("invalid remote URL format: %s", tt.remoteURL)
-				} else {
-					owner = parts[0]
-					repo = strings.TrimSuffix(parts[1], ".git")
-				}
-			} else {
-				err = fmt.Errorf("unsupported remote URL format: %s", tt.remoteURL)
-			}
-
+			owner, repo, err := ParseRemoteURL(tt.remoteURL)
 			if tt.expectErr {
 				assert.Error(t, err)
 				return
@@ -328,48 +316,212 @@ func TestGitRemoteURLParsing(t *testing.T) {
 	}
 }
 
-// BenchmarkStoreEncryptionKey benchmarks storing encryption keys
-func BenchmarkStoreEncryptionKey(b *testing.B) {
-	ctx := context.Background()
+// TestChooseRemote tests picking the remote among several GitHub remotes
+func TestChooseRemote(t *testing.T) {
+	fork := gitRemote{name: "origin", owner: "me", repo: "app"}
+	upstream := gitRemote{name: "upstream", owner: "org", repo: "app"}
+	mirror := gitRemote{name: "mirror", owner: "org", repo: "app"}
+	adminOf := func(names ...string) func(gitRemote) bool {
+		return func(remote gitRemote) bool { return slices.Contains(names, remote.name) }
+	}
 
-	// Change to the test repository for this benchmark
-	originalDir, err := os.Getwd()
-	require.NoError(b, err)
-	defer os.Chdir(originalDir)
-
-	testRepoPath := "../../ez-test-env"
-	err = os.Chdir(testRepoPath)
-	require.NoError(b, err)
-
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		// Generate a test key (32 bytes)
-		testKey := make([]byte, 32)
-		for j := range testKey {
-			testKey[j] = byte(j + 1)
-		}
-
-		err = StoreEncryptionKey(ctx, testKey)
-		require.NoError(b, err)
+	assert.Equal(t, "upstream", chooseRemote([]gitRemote{upstream}, "origin", adminOf()), "the only GitHub remote")
+	assert.Equal(t, "upstream", chooseRemote([]gitRemote{fork, upstream}, "origin", adminOf("upstream")), "the administered repository")
+	assert.Equal(t, "origin", chooseRemote([]gitRemote{upstream, fork}, "origin", adminOf("origin", "upstream")), "the preferred remote wins a tie")
+	assert.Equal(t, "origin", chooseRemote([]gitRemote{upstream, fork}, "origin", adminOf()), "the preferred remote without admin access")
+	assert.Equal(t, "upstream", chooseRemote([]gitRemote{upstream, mirror}, "origin", func(gitRemote) bool {
+		t.Fatal("remotes of one repository need no API calls")
+		return false
+	}))
+}
+
+// TestTokenType tests that tokens are classified by their prefix
+func TestTokenType(t *testing.T) {
+	tests := []struct {
+		token string
+		want  string
+	}{
+		{token: "github_pat_11ABC", want: "fine-grained personal access token"},
+		{token: "ghp_abc", want: "classic personal access token"},
+		{token: "gho_abc", want: "OAuth token"},
+		{token: "ghu_abc", want: "GitHub App user token"},
+		{token: "ghs_abc", want: "GitHub App installation token"},
+		{token: "abc", want: "unknown token type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, tt.want, TokenType(tt.token))
+		})
 	}
 }
 
-// BenchmarkGetEncryptionKey benchmarks retrieving encryption keys
-func BenchmarkGetEncryptionKey(b *testing.B) {
-	ctx := context.Background()
+// TestSealSecret tests that secret values are sealed so only the repository key can open them
+func TestSealSecret(t *testing.T) {
+	publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+	require.NoError(t, err)
 
-	// Change to the test repository for this benchmark
-	originalDir, err := os.Getwd()
-	require.NoError(b, err)
-	defer os.Chdir(originalDir)
+	sealed, err := sealSecret(base64.StdEncoding.EncodeToString(publicKey[:]), []byte("c2VjcmV0"))
+	require.NoError(t, err)
+	decoded, err := base64.StdEncoding.DecodeString(sealed)
+	require.NoError(t, err)
 
-	testRepoPath := "../../ez-test-env"
-	err = os.Chdir(testRepoPath)
-	require.NoError(b, err)
+	opened, ok := box.OpenAnonymous(nil, decoded, publicKey, privateKey)
+	require.True(t, ok)
+	assert.Equal(t, "c2VjcmV0", string(opened))
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		_, err := GetEncryptionKey(ctx)
-		require.NoError(b, err)
+	tests := []struct {
+		name      string
+		publicKey string
+	}{
+		{name: "not base64", publicKey: "not base64!"},
+		{name: "wrong size", publicKey: base64.StdEncoding.EncodeToString([]byte("short"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sealSecret(tt.publicKey, []byte("value"))
+			assert.ErrorContains(t, err, "invalid repository public key")
+		})
+	}
+}
+
+// TestReadArtifactFile tests reading the key file from an artifact archive
+func TestReadArtifactFile(t *testing.T) {
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	file, err := writer.Create(keyArtifactFile)
+	require.NoError(t, err)
+	_, err = file.Write([]byte("a2V5\n"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	content, err := readArtifactFile(archive.Bytes(), keyArtifactFile)
+	require.NoError(t, err)
+	assert.Equal(t, "a2V5\n", string(content))
+
+	_, err = readArtifactFile(archive.Bytes(), "missing.txt")
+	assert.ErrorContains(t, err, "failed to read missing.txt from artifact")
+
+	_, err = readArtifactFile([]byte("not a zip"), keyArtifactFile)
+	assert.ErrorContains(t, err, "failed to open artifact")
+}
+
+// TestIsNotFound tests recognizing 404 API responses
+func TestIsNotFound(t *testing.T) {
+	notFound := &gogithub.ErrorResponse{Response: &http.Response{StatusCode: http.StatusNotFound}}
+	forbidden := &gogithub.ErrorResponse{Response: &http.Response{StatusCode: http.StatusForbidden}}
+
+	assert.True(t, isNotFound(notFound))
+	assert.True(t, isNotFound(fmt.Errorf("wrapped: %w", notFound)))
+	assert.False(t, isNotFound(forbidden))
+	assert.False(t, isNotFound(fmt.Errorf("network down")))
+	assert.False(t, isNotFound(nil))
+}
+
+// TestMissingScopes tests that broader scopes satisfy the narrower ones they include
+func TestMissingScopes(t *testing.T) {
+	tests := []struct {
+		name string
+		have []string
+		need []string
+		want []string
+	}{
+		{name: "all granted", have: []string{"repo", "workflow"}, need: []string{"repo", "workflow"}},
+		{name: "workflow missing", have: []string{"repo", "read:org"}, need: []string{"repo", "workflow"}, want: []string{"workflow"}},
+		{name: "implied by broader scope", have: []string{"admin:public_key", "repo"}, need: []string{"read:public_key", "public_repo"}},
+		{name: "no scopes", need: []string{"repo"}, want: []string{"repo"}},
+		{name: "nothing needed", have: []string{"gist"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, missingScopes(tt.have, tt.need))
+		})
+	}
+}
+
+// TestRoleAtLeast tests comparing repository roles, including custom roles
+func TestRoleAtLeast(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       string
+		permission string
+		min        string
+		want       bool
+	}{
+		{name: "admin", role: "admin", permission: "admin", min: "maintain", want: true},
+		{name: "maintain", role: "maintain", permission: "write", min: "maintain", want: true},
+		{name: "write", role: "write", permission: "write", min: "maintain", want: false},
+		{name: "custom role extending admin", role: "security-lead", permission: "admin", min: "maintain", want: true},
+		{name: "custom role extending write", role: "deployer", permission: "write", min: "maintain", want: false},
+		{name: "no access", role: "", permission: "none", min: "read", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, roleAtLeast(tt.role, tt.permission, tt.min))
+		})
+	}
+}
+
+// TestDispatchRunID tests finding the run of a dispatch among concurrent runs
+func TestDispatchRunID(t *testing.T) {
+	first, err := newDispatchID()
+	require.NoError(t, err)
+	second, err := newDispatchID()
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, first)
+
+	runs := []*gogithub.WorkflowRun{
+		{ID: gogithub.Int64(3), DisplayTitle: gogithub.String("ez-env get-key for alice " + second)},
+		{ID: gogithub.Int64(2), DisplayTitle: gogithub.String("ez-env get-key for alice " + first)},
+		{ID: gogithub.Int64(1), DisplayTitle: gogithub.String("ez-env Key Management")},
+	}
+	assert.Equal(t, int64(2), dispatchRunID(runs, first))
+	assert.Equal(t, int64(3), dispatchRunID(runs, second))
+	assert.Zero(t, dispatchRunID(runs, "00000000-0000-4000-8000-000000000000"))
+}
+
+// TestIsUnexpectedInput tests recognizing dispatches rejected by workflows without an input
+func TestIsUnexpectedInput(t *testing.T) {
+	rejected := &gogithub.ErrorResponse{
+		Response: &http.Response{StatusCode: http.StatusUnprocessableEntity},
+		Message:  `Unexpected inputs provided: ["dispatch_id"]`,
+	}
+	invalid := &gogithub.ErrorResponse{
+		Response: &http.Response{StatusCode: http.StatusUnprocessableEntity},
+		Message:  "No ref found for: main",
+	}
+
+	assert.True(t, isUnexpectedInput(rejected, "dispatch_id"))
+	assert.True(t, isUnexpectedInput(fmt.Errorf("wrapped: %w", rejected), "dispatch_id"))
+	assert.False(t, isUnexpectedInput(rejected, "environment"))
+	assert.False(t, isUnexpectedInput(invalid, "dispatch_id"))
+	assert.False(t, isUnexpectedInput(nil, "dispatch_id"))
+}
+
+// TestCheckKeyRequest tests failing early on forks and without write access
+func TestCheckKeyRequest(t *testing.T) {
+	t.Cleanup(func() { AutoRemote = false })
+	fork := &gogithub.Repository{
+		FullName:    gogithub.String("me/app"),
+		Fork:        gogithub.Bool(true),
+		Parent:      &gogithub.Repository{FullName: gogithub.String("org/app")},
+		Permissions: map[string]bool{"admin": true, "push": true},
 	}
+	readOnly := &gogithub.Repository{FullName: gogithub.String("org/app"), Permissions: map[string]bool{"pull": true}}
+	writable := &gogithub.Repository{FullName: gogithub.String("org/app"), Permissions: map[string]bool{"pull": true, "push": true}}
+
+	AutoRemote = true
+	var forkErr *ForkError
+	require.ErrorAs(t, checkKeyRequest(fork, "me"), &forkErr)
+	assert.Equal(t, "org/app", forkErr.Parent)
+	assert.Contains(t, forkErr.Error(), "git remote add upstream https://github.com/org/app.git")
+
+	AutoRemote = false
+	assert.NoError(t, checkKeyRequest(fork, "me"), "a configured remote uses the fork deliberately")
+	assert.ErrorContains(t, checkKeyRequest(readOnly, "me"), "no write access to org/app")
+	assert.NoError(t, checkKeyRequest(writable, "me"))
+	assert.NoError(t, checkKeyRequest(&gogithub.Repository{FullName: gogithub.String("org/app")}, "me"), "unknown permissions are not checked")
 }
@@ -0,0 +1,222 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	gogithub "github.com/google/go-github/v66/github"
+)
+
+// gitRemote is a git remote that points at a GitHub repository
+type gitRemote struct {
+	name  string
+	owner string
+	repo  string
+}
+
+// autoRemote holds the remote picked once per process by Remote
+var autoRemote struct {
+	once sync.Once
+	name string
+}
+
+// Remote returns the name of the git remote that points at the repository
+// A configured remote is used as is. Otherwise the only GitHub remote is used; with several, such
+// as a fork and its upstream, the one whose repository the user administers wins, then RemoteName
+func Remote() string {
+	if !AutoRemote {
+		return RemoteName
+	}
+	autoRemote.once.Do(func() {
+		autoRemote.name = RemoteName
+		remotes, err := githubRemotes()
+		if err != nil || len(remotes) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		autoRemote.name = chooseRemote(remotes, RemoteName, func(remote gitRemote) bool {
+			return isRepositoryAdmin(ctx, remote.owner, remote.repo)
+		})
+	})
+	return autoRemote.name
+}
+
+// RemoteURL returns the URL of the git remote that points at the repository
+func RemoteURL() (string, error) {
+	output, err := exec.Command("git", "remote", "get-url", Remote()).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get remote URL: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ParseRemoteURL returns the owner and name of the GitHub repository a remote URL points at
+// Accepted are scp-like SSH URLs (git@github.com:owner/repo.git) and ssh://, https://, http://
+// and git:// URLs, with or without a user, port, .git suffix or trailing slash
+func ParseRemoteURL(remoteURL string) (string, string, error) {
+	var host, path string
+	if !strings.Contains(remoteURL, "://") {
+		// scp-like syntax: [user@]host:path
+		hostPart, pathPart, ok := strings.Cut(remoteURL, ":")
+		if !ok {
+			return "", "", fmt.Errorf("unsupported remote URL format: %s", remoteURL)
+		}
+		_, host, _ = strings.Cut(hostPart, "@")
+		if host == "" {
+			host = hostPart
+		}
+		path = pathPart
+	} else {
+		parsed, err := url.Parse(remoteURL)
+		if err != nil {
+			return "", "", fmt.Errorf("unsupported remote URL format: %s", remoteURL)
+		}
+		switch parsed.Scheme {
+		case "ssh", "git+ssh", "https", "http", "git":
+		default:
+			return "", "", fmt.Errorf("unsupported remote URL format: %s", remoteURL)
+		}
+		host, path = parsed.Hostname(), parsed.Path
+	}
+	if !strings.EqualFold(host, "github.com") {
+		return "", "", fmt.Errorf("unsupported remote URL format: %s", remoteURL)
+	}
+
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid remote URL format: %s", remoteURL)
+	}
+	return parts[0], parts[1], nil
+}
+
+// githubRemotes lists the remotes of the repository that point at GitHub
+func githubRemotes() ([]gitRemote, error) {
+	output, err := exec.Command("git", "config", "--get-regexp", `^remote\..*\.url$`).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remotes: %w", err)
+	}
+	var remotes []gitRemote
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		key, remoteURL, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		owner, repo, err := ParseRemoteURL(remoteURL)
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(key, "remote."), ".url")
+		remotes = append(remotes, gitRemote{name: name, owner: owner, repo: repo})
+	}
+	return remotes, nil
+}
+
+// chooseRemote picks the remote of the repository among GitHub remotes, preferring preferred
+// When they point at different repositories the first one isAdmin accepts wins
+func chooseRemote(remotes []gitRemote, preferred string, isAdmin func(gitRemote) bool) string {
+	// The preferred remote is tried first so it wins when the user administers several
+	remotes = slices.Clone(remotes)
+	slices.SortStableFunc(remotes, func(a, b gitRemote) int {
+		switch {
+		case a.name == preferred && b.name != preferred:
+			return -1
+		case b.name == preferred && a.name != preferred:
+			return 1
+		}
+		return 0
+	})
+
+	sameRepository := true
+	for _, remote := range remotes[1:] {
+		if !strings.EqualFold(remote.owner+"/"+remote.repo, remotes[0].owner+"/"+remotes[0].repo) {
+			sameRepository = false
+		}
+	}
+	if !sameRepository {
+		for _, remote := range remotes {
+			if isAdmin(remote) {
+				return remote.name
+			}
+		}
+	}
+	return remotes[0].name
+}
+
+// isRepositoryAdmin reports whether the authenticated user administers owner/repo
+func isRepositoryAdmin(ctx context.Context, owner, repo string) bool {
+	gh, err := client()
+	if err != nil {
+		return false
+	}
+	repository, _, err := gh.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return false
+	}
+	return repository.GetPermissions()["admin"]
+}
+
+// ForkError is returned when the remote points at a fork, whose secrets and workflow runs are
+// separate from those of its upstream
+type ForkError struct {
+	// Repository and Parent are the full names of the fork and the repository it was forked from
+	Repository string
+	Parent     string
+}
+
+func (e *ForkError) Error() string {
+	return fmt.Sprintf("%s is a fork of %s; the key is kept in the secrets of %s and its workflow cannot be run from the fork. "+
+		"Add the upstream with 'git remote add upstream https://github.com/%s.git', or run "+
+		"'git ez-env config set --local remote %s' to manage a key in the fork itself",
+		e.Repository, e.Parent, e.Parent, e.Parent, Remote())
+}
+
+// checkFork returns a ForkError when repository is a fork and no remote was configured
+// explicitly, which would mean ez-env is deliberately used in the fork
+func checkFork(repository *gogithub.Repository) error {
+	if !AutoRemote || !repository.GetFork() {
+		return nil
+	}
+	return &ForkError{Repository: repository.GetFullName(), Parent: repository.GetParent().GetFullName()}
+}
+
+// checkKeyRequest fails when the key workflow of repository cannot be dispatched by login: from a
+// fork, or without write access, which workflow_dispatch needs
+func checkKeyRequest(repository *gogithub.Repository, login string) error {
+	if err := checkFork(repository); err != nil {
+		return err
+	}
+	// Permissions are only reported to authenticated users
+	if permissions := repository.GetPermissions(); len(permissions) > 0 && !permissions["push"] {
+		return fmt.Errorf("%s has no write access to %s, which running the key workflow needs; ask a repository admin to add you as a collaborator, or for a copy of the key to import with 'git ez-env import-key'",
+			login, repository.GetFullName())
+	}
+	return nil
+}
+
+// RemoteFor returns the name of a git remote that points at the repository fullName (owner/repo)
+func RemoteFor(fullName string) (string, bool) {
+	remotes, err := githubRemotes()
+	if err != nil {
+		return "", false
+	}
+	for _, remote := range remotes {
+		if strings.EqualFold(remote.owner+"/"+remote.repo, fullName) {
+			return remote.name, true
+		}
+	}
+	return "", false
+}
+
+// UseRemote points the GitHub integration at name for the rest of the process
+func UseRemote(name string) {
+	RemoteName = name
+	AutoRemote = false
+}
@@ -0,0 +1,148 @@
+package github
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// retryTransport retries GitHub API requests that fail transiently: server errors and network
+// errors on requests that are safe to repeat, and primary or secondary rate limits on any request
+// Rate limits are waited out when they reset soon enough; otherwise the request fails at once
+type retryTransport struct {
+	base http.RoundTripper
+	// attempts is how often a request is sent before giving up
+	attempts int
+	// baseDelay is the first backoff delay, doubled on each retry up to maxDelay
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	// maxRateLimitWait is the longest wait for a rate limit to reset
+	maxRateLimitWait time.Duration
+}
+
+// newRetryTransport wraps base with the default retry policy
+func newRetryTransport(base http.RoundTripper) *retryTransport {
+	return &retryTransport{
+		base:             base,
+		attempts:         5,
+		baseDelay:        time.Second,
+		maxDelay:         30 * time.Second,
+		maxRateLimitWait: time.Minute,
+	}
+}
+
+// RoundTrip sends the request, retrying transient failures with exponential backoff and jitter
+func (t *retryTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	var lastFailure string
+	for attempt := 0; attempt < t.attempts; attempt++ {
+		if attempt > 0 && request.Body != nil {
+			// The body was consumed by the previous attempt
+			if request.GetBody == nil {
+				return nil, fmt.Errorf("GitHub API %s %s failed: %s; the request body could not be replayed to retry it", request.Method, request.URL.Path, lastFailure)
+			}
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			request = request.Clone(request.Context())
+			request.Body = body
+		}
+
+		response, err := t.base.RoundTrip(request)
+		delay := t.backoff(attempt)
+		switch {
+		case err != nil:
+			if request.Context().Err() != nil || !idempotent(request.Method) {
+				return nil, err
+			}
+			lastFailure = err.Error()
+		case isRateLimited(response):
+			wait, known := rateLimitWait(response, time.Now())
+			if known && wait > t.maxRateLimitWait {
+				response.Body.Close()
+				return nil, fmt.Errorf("GitHub API rate limit exceeded until %s", time.Now().Add(wait).Format("15:04:05"))
+			}
+			if known {
+				delay = wait
+			}
+			lastFailure = "rate limited (" + response.Status + ")"
+			if delay >= 5*time.Second {
+				fmt.Fprintf(os.Stderr, "GitHub API rate limit reached; retrying in %s...\n", delay.Round(time.Second))
+			}
+			drain(response)
+		case response.StatusCode >= 500 && response.StatusCode != http.StatusNotImplemented:
+			// The server may have acted on the request before failing, so only repeat safe ones
+			if !idempotent(request.Method) {
+				return response, nil
+			}
+			lastFailure = response.Status
+			drain(response)
+		default:
+			return response, nil
+		}
+
+		if attempt == t.attempts-1 {
+			break
+		}
+		if err := sleep(request.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("GitHub API %s %s failed after %d attempts: %s", request.Method, request.URL.Path, t.attempts, lastFailure)
+}
+
+// backoff returns the delay before retrying after attempt, doubling each time up to maxDelay,
+// with jitter so concurrent clients do not retry in step
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	delay := t.baseDelay << attempt
+	if delay > t.maxDelay || delay <= 0 {
+		delay = t.maxDelay
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// idempotent reports whether a request with method can be sent again after a network or server
+// error without risking a repeated side effect
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// isRateLimited reports whether a response rejects the request for exceeding a primary or
+// secondary rate limit; other 403 responses are permission errors
+func isRateLimited(response *http.Response) bool {
+	switch response.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		return response.Header.Get("Retry-After") != "" || response.Header.Get("X-RateLimit-Remaining") == "0"
+	}
+	return false
+}
+
+// rateLimitWait returns how long to wait before the rate limit allows requests again, from the
+// Retry-After header or the X-RateLimit-Reset time, and whether either was present
+func rateLimitWait(response *http.Response, now time.Time) (time.Duration, bool) {
+	if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	if response.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(response.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return max(time.Unix(reset, 0).Sub(now), 0), true
+		}
+	}
+	return 0, false
+}
+
+// drain discards and closes a response body so the connection can be reused
+func drain(response *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	response.Body.Close()
+}
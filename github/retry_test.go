@@ -0,0 +1,192 @@
+package github
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRetryClient returns a client that retries quickly
+func testRetryClient(attempts int) *http.Client {
+	transport := newRetryTransport(http.DefaultTransport)
+	transport.attempts = attempts
+	transport.baseDelay = time.Millisecond
+	transport.maxDelay = 5 * time.Millisecond
+	transport.maxRateLimitWait = time.Second
+	return &http.Client{Transport: transport}
+}
+
+// TestRetryTransport tests which responses are retried and how often
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		failures  int
+		fail      func(w http.ResponseWriter)
+		wantCalls int32
+		wantErr   string
+		wantCode  int
+	}{
+		{
+			name:      "server errors are retried",
+			method:    http.MethodPut,
+			failures:  2,
+			fail:      func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+			wantCalls: 3,
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "server errors of requests that are not idempotent are not retried",
+			method:    http.MethodPost,
+			failures:  2,
+			fail:      func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+			wantCalls: 1,
+			wantCode:  http.StatusBadGateway,
+		},
+		{
+			name:     "secondary rate limit is retried",
+			method:   http.MethodPost,
+			failures: 1,
+			fail: func(w http.ResponseWriter) {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusForbidden)
+			},
+			wantCalls: 2,
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "permission errors are not retried",
+			method:    http.MethodPost,
+			failures:  1,
+			fail:      func(w http.ResponseWriter) { w.WriteHeader(http.StatusForbidden) },
+			wantCalls: 1,
+			wantCode:  http.StatusForbidden,
+		},
+		{
+			name:      "not found is not retried",
+			method:    http.MethodGet,
+			failures:  1,
+			fail:      func(w http.ResponseWriter) { w.WriteHeader(http.StatusNotFound) },
+			wantCalls: 1,
+			wantCode:  http.StatusNotFound,
+		},
+		{
+			name:      "retries are exhausted",
+			method:    http.MethodPut,
+			failures:  10,
+			fail:      func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) },
+			wantCalls: 3,
+			wantErr:   "failed after 3 attempts: 503 Service Unavailable",
+		},
+		{
+			name:     "distant rate limit reset fails at once",
+			method:   http.MethodPost,
+			failures: 10,
+			fail: func(w http.ResponseWriter) {
+				w.Header().Set("X-RateLimit-Remaining", "0")
+				w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+				w.WriteHeader(http.StatusForbidden)
+			},
+			wantCalls: 1,
+			wantErr:   "rate limit exceeded until",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				assert.Equal(t, "payload", string(body), "the body is sent again on every attempt")
+				if int(calls.Add(1)) <= tt.failures {
+					tt.fail(w)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			request, err := http.NewRequest(tt.method, server.URL+"/repos/o/r/dispatches", strings.NewReader("payload"))
+			require.NoError(t, err)
+			response, err := testRetryClient(3).Do(request)
+			assert.Equal(t, tt.wantCalls, calls.Load())
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			response.Body.Close()
+			assert.Equal(t, tt.wantCode, response.StatusCode)
+		})
+	}
+}
+
+// TestRetryTransportBodyNotReplayable tests that a failed request whose body cannot be sent again
+// says so instead of reporting exhausted retries
+func TestRetryTransportBodyNotReplayable(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	request, err := http.NewRequest(http.MethodPut, server.URL+"/repos/o/r/secrets/KEY", io.NopCloser(strings.NewReader("payload")))
+	require.NoError(t, err)
+	_, err = testRetryClient(3).Do(request)
+	assert.ErrorContains(t, err, "503 Service Unavailable; the request body could not be replayed")
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+// TestRetryTransportNetworkErrors tests that only requests safe to repeat are retried after a
+// network error
+func TestRetryTransportNetworkErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		request, err := http.NewRequest(method, url, nil)
+		require.NoError(t, err)
+		_, err = testRetryClient(2).Do(request)
+		require.Error(t, err)
+		assert.Equal(t, method == http.MethodGet, strings.Contains(err.Error(), "failed after 2 attempts"), method)
+	}
+}
+
+// TestRateLimitWait tests reading the wait from Retry-After and X-RateLimit-Reset
+func TestRateLimitWait(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name      string
+		headers   map[string]string
+		want      time.Duration
+		wantKnown bool
+	}{
+		{name: "retry after", headers: map[string]string{"Retry-After": "30"}, want: 30 * time.Second, wantKnown: true},
+		{name: "reset time", headers: map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "1700000045"}, want: 45 * time.Second, wantKnown: true},
+		{name: "reset in the past", headers: map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "1699999990"}, wantKnown: true},
+		{name: "requests remaining", headers: map[string]string{"X-RateLimit-Remaining": "12", "X-RateLimit-Reset": "1700000045"}},
+		{name: "no headers"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := &http.Response{Header: http.Header{}}
+			for name, value := range tt.headers {
+				response.Header.Set(name, value)
+			}
+			wait, known := rateLimitWait(response, now)
+			assert.Equal(t, tt.wantKnown, known)
+			assert.Equal(t, tt.want, wait)
+		})
+	}
+}
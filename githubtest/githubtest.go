@@ -0,0 +1,495 @@
+// Package githubtest runs a fake GitHub API for tests that must not reach the network
+// It serves what ez-env uses for one repository: the authenticated user and their SSH keys, the
+// repository and its Actions secrets, and the key management workflow, whose dispatched get-key
+// runs complete at once with the artifact the real workflow uploads
+package githubtest
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"golang.org/x/crypto/nacl/box"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Server is a fake GitHub API for one repository and one authenticated user
+type Server struct {
+	// URL is the base URL of the API, for GITHUB_API_URL
+	URL   string
+	Owner string
+	Repo  string
+	Login string
+	// SecretName is the secret the workflow reads the key from
+	SecretName string
+
+	server     *httptest.Server
+	t          testing.TB
+	publicKey  *[32]byte
+	privateKey *[32]byte
+
+	mu      sync.Mutex
+	secrets map[string]secret
+	sshKeys []string
+	runs    []*workflowRun
+	// branches maps the branches that exist to whether they are protected
+	branches map[string]bool
+}
+
+// secret is a stored Actions secret
+type secret struct {
+	value     string
+	updatedAt time.Time
+}
+
+// workflowRun is a completed run of the key management workflow
+type workflowRun struct {
+	id         int64
+	title      string
+	conclusion string
+	// artifactName and artifact are the key artifact of a successful run, a zip archive
+	artifactName string
+	artifact     []byte
+}
+
+// NewServer starts a fake API for the repository owner/repo accessed by login; it is closed when
+// the test ends
+func NewServer(t testing.TB, owner, repo, login string) *Server {
+	t.Helper()
+	publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("githubtest: failed to generate the repository public key: %v", err)
+	}
+	s := &Server{
+		Owner:      owner,
+		Repo:       repo,
+		Login:      login,
+		SecretName: "EZENV_ENCRYPTION_KEY",
+		t:          t,
+		publicKey:  publicKey,
+		privateKey: privateKey,
+		secrets:    make(map[string]secret),
+		branches:   map[string]bool{"main": false},
+	}
+	s.server = httptest.NewServer(s.routes())
+	s.URL = s.server.URL
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// AddSSHKey adds an authorized_keys line to the SSH keys of the user, which the workflow encrypts
+// the key to
+func (s *Server) AddSSHKey(authorizedKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sshKeys = append(s.sshKeys, strings.TrimSpace(authorizedKey))
+}
+
+// Secret returns the value of the repository secret name as the workflow sees it
+func (s *Server) Secret(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.secrets[name]
+	return stored.value, ok
+}
+
+// SetSecret stores value as the repository secret name
+func (s *Server) SetSecret(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[name] = secret{value: value, updatedAt: time.Now().UTC()}
+}
+
+// SetBranch creates or updates a branch of the repository
+func (s *Server) SetBranch(name string, protected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.branches[name] = protected
+}
+
+// Dispatches returns how many workflow runs were dispatched
+func (s *Server) Dispatches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.runs)
+}
+
+// WriteSSHKey writes a new unencrypted ed25519 private key to dir, for EZENV_SSH_KEY, and returns
+// its path and its authorized_keys line
+func WriteSSHKey(t testing.TB, dir string) (string, string) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("githubtest: failed to generate SSH key: %v", err)
+	}
+	block, err := gossh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatalf("githubtest: failed to encode SSH key: %v", err)
+	}
+	sshPublic, err := gossh.NewPublicKey(public)
+	if err != nil {
+		t.Fatalf("githubtest: failed to encode SSH key: %v", err)
+	}
+	path := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("githubtest: failed to write SSH key: %v", err)
+	}
+	return path, strings.TrimSpace(string(gossh.MarshalAuthorizedKey(sshPublic)))
+}
+
+// routes returns the handler of the API
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	repo := "/repos/{owner}/{repo}"
+	mux.HandleFunc("GET /user", s.getUser)
+	mux.HandleFunc("GET /users/{login}/keys", s.getUserKeys)
+	mux.HandleFunc("GET "+repo, s.repository(s.getRepository))
+	mux.HandleFunc("GET "+repo+"/collaborators/{login}/permission", s.repository(s.getPermission))
+	mux.HandleFunc("GET "+repo+"/branches/{branch}", s.repository(s.getBranch))
+	mux.HandleFunc("GET "+repo+"/actions/secrets/public-key", s.repository(s.getPublicKey))
+	mux.HandleFunc("GET "+repo+"/actions/secrets/{name}", s.repository(s.getSecret))
+	mux.HandleFunc("PUT "+repo+"/actions/secrets/{name}", s.repository(s.putSecret))
+	mux.HandleFunc("DELETE "+repo+"/actions/secrets/{name}", s.repository(s.deleteSecret))
+	mux.HandleFunc("POST "+repo+"/actions/workflows/{workflow}/dispatches", s.repository(s.dispatch))
+	mux.HandleFunc("GET "+repo+"/actions/workflows/{workflow}/runs", s.repository(s.listRuns))
+	mux.HandleFunc("GET "+repo+"/actions/runs/{id}", s.repository(s.getRun))
+	mux.HandleFunc("GET "+repo+"/actions/runs/{id}/artifacts", s.repository(s.listArtifacts))
+	mux.HandleFunc("GET "+repo+"/actions/artifacts/{id}/zip", s.repository(s.downloadArtifact))
+	mux.HandleFunc("GET /artifacts/{id}", s.artifactArchive)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		s.t.Logf("githubtest: unhandled request %s %s", r.Method, r.URL.Path)
+		writeError(w, http.StatusNotFound, "Not Found")
+	})
+
+	// The API needs a token; the archive URL is signed instead and must not receive it
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorized := r.Header.Get("Authorization") != ""
+		if strings.HasPrefix(r.URL.Path, "/artifacts/") == authorized {
+			writeError(w, http.StatusUnauthorized, "Bad credentials")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// repository wraps a handler of the repository's endpoints, which other repositories do not have
+func (s *Server) repository(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("owner") != s.Owner || r.PathValue("repo") != s.Repo {
+			writeError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// getUser serves the authenticated user, with the scopes of a classic token
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-OAuth-Scopes", "repo, workflow, read:org")
+	writeJSON(w, http.StatusOK, map[string]any{"login": s.Login, "id": 1})
+}
+
+// getUserKeys serves the public SSH keys of a user
+func (s *Server) getUserKeys(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []map[string]any{}
+	if r.PathValue("login") == s.Login {
+		for i, key := range s.sshKeys {
+			keys = append(keys, map[string]any{"id": i + 1, "key": key})
+		}
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// getRepository serves the repository, which the user administers
+func (s *Server) getRepository(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":             1,
+		"name":           s.Repo,
+		"full_name":      s.Owner + "/" + s.Repo,
+		"default_branch": "main",
+		"fork":           false,
+		"permissions":    map[string]bool{"admin": true, "maintain": true, "push": true, "triage": true, "pull": true},
+	})
+}
+
+// getBranch serves a branch and whether it is protected
+func (s *Server) getBranch(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	protected, ok := s.branches[r.PathValue("branch")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Branch not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"name": r.PathValue("branch"), "protected": protected})
+}
+
+// getPermission serves the role of a collaborator; only the user is one
+func (s *Server) getPermission(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("login") != s.Login {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"permission": "admin",
+		"role_name":  "admin",
+		"user":       map[string]any{"login": s.Login},
+	})
+}
+
+// getPublicKey serves the public key secrets are sealed to
+func (s *Server) getPublicKey(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"key_id": "1",
+		"key":    base64.StdEncoding.EncodeToString(s.publicKey[:]),
+	})
+}
+
+// getSecret serves the metadata of a secret, never its value
+func (s *Server) getSecret(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.secrets[r.PathValue("name")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"name":       r.PathValue("name"),
+		"created_at": stored.updatedAt.Format(time.RFC3339),
+		"updated_at": stored.updatedAt.Format(time.RFC3339),
+	})
+}
+
+// putSecret opens the value sealed to the repository public key, as GitHub does
+func (s *Server) putSecret(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		KeyID          string `json:"key_id"`
+		EncryptedValue string `json:"encrypted_value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.KeyID != "1" {
+		writeError(w, http.StatusUnprocessableEntity, "Invalid request")
+		return
+	}
+	sealed, err := base64.StdEncoding.DecodeString(body.EncryptedValue)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "Invalid encrypted_value")
+		return
+	}
+	value, ok := box.OpenAnonymous(nil, sealed, s.publicKey, s.privateKey)
+	if !ok {
+		writeError(w, http.StatusUnprocessableEntity, "Bad encrypted_value")
+		return
+	}
+	s.SetSecret(r.PathValue("name"), string(value))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// deleteSecret removes a secret
+func (s *Server) deleteSecret(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.secrets, r.PathValue("name"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// dispatch runs the workflow at once: a get-key run succeeds with the key encrypted to the user's
+// SSH keys, and any other run fails
+func (s *Server) dispatch(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Ref    string            `json:"ref"`
+		Inputs map[string]string `json:"inputs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "Invalid request")
+		return
+	}
+	inputs := body.Inputs
+	run := &workflowRun{
+		title:      strings.TrimSpace(fmt.Sprintf("ez-env %s for %s %s", inputs["action"], inputs["user"], inputs["dispatch_id"])),
+		conclusion: "failure",
+	}
+	if inputs["action"] == "get-key" && inputs["environment"] == "" {
+		if artifact, err := s.keyArtifact(); err != nil {
+			s.t.Logf("githubtest: get-key run failed: %v", err)
+		} else {
+			run.conclusion = "success"
+			run.artifact = artifact
+			run.artifactName = "encryption-key-" + inputs["user"]
+			if inputs["dispatch_id"] != "" {
+				run.artifactName = "encryption-key-" + inputs["dispatch_id"]
+			}
+		}
+	}
+
+	s.mu.Lock()
+	run.id = int64(len(s.runs) + 1)
+	s.runs = append(s.runs, run)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// keyArtifact returns the archive the workflow uploads: the secret encrypted with age to the
+// user's SSH keys, and the keys it was encrypted to
+func (s *Server) keyArtifact() ([]byte, error) {
+	value, ok := s.Secret(s.SecretName)
+	if !ok {
+		return nil, fmt.Errorf("the secret %s is not set", s.SecretName)
+	}
+	s.mu.Lock()
+	keys := append([]string(nil), s.sshKeys...)
+	s.mu.Unlock()
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s has no SSH keys", s.Login)
+	}
+
+	var recipients []age.Recipient
+	for _, key := range keys {
+		recipient, err := agessh.ParseRecipient(key)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+	var encrypted bytes.Buffer
+	writer, err := age.Encrypt(&encrypted, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write([]byte(value + "\n")); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	var archive bytes.Buffer
+	zipWriter := zip.NewWriter(&archive)
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{"encryption-key.age", encrypted.Bytes()},
+		{"recipients.txt", []byte(strings.Join(keys, "\n") + "\n")},
+	}
+	for _, file := range files {
+		fileWriter, err := zipWriter.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fileWriter.Write(file.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return archive.Bytes(), nil
+}
+
+// listRuns lists the runs newest first, like the API
+func (s *Server) listRuns(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := []map[string]any{}
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		runs = append(runs, runJSON(s.runs[i]))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"total_count": len(runs), "workflow_runs": runs})
+}
+
+// getRun serves a workflow run
+func (s *Server) getRun(w http.ResponseWriter, r *http.Request) {
+	run := s.run(r)
+	if run == nil {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	writeJSON(w, http.StatusOK, runJSON(run))
+}
+
+// listArtifacts lists the artifacts of a run
+func (s *Server) listArtifacts(w http.ResponseWriter, r *http.Request) {
+	run := s.run(r)
+	if run == nil {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	artifacts := []map[string]any{}
+	if run.artifact != nil {
+		artifacts = append(artifacts, map[string]any{"id": run.id, "name": run.artifactName, "expired": false})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"total_count": len(artifacts), "artifacts": artifacts})
+}
+
+// downloadArtifact redirects to the archive, as the API redirects to a signed URL
+func (s *Server) downloadArtifact(w http.ResponseWriter, r *http.Request) {
+	if run := s.run(r); run == nil || run.artifact == nil {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	w.Header().Set("Location", s.URL+"/artifacts/"+r.PathValue("id"))
+	w.WriteHeader(http.StatusFound)
+}
+
+// artifactArchive serves the archive of an artifact at its signed URL
+func (s *Server) artifactArchive(w http.ResponseWriter, r *http.Request) {
+	run := s.run(r)
+	if run == nil || run.artifact == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Write(run.artifact)
+}
+
+// run returns the run the id path value names, or nil
+func (s *Server) run(r *http.Request) *workflowRun {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil || id < 1 || id > int64(len(s.runs)) {
+		return nil
+	}
+	return s.runs[id-1]
+}
+
+// runJSON returns the API representation of a run
+func runJSON(run *workflowRun) map[string]any {
+	return map[string]any{
+		"id":            run.id,
+		"display_title": run.title,
+		"status":        "completed",
+		"conclusion":    run.conclusion,
+	}
+}
+
+// writeJSON writes value as the JSON body of a response with status
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError writes an API error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
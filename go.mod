@@ -2,10 +2,21 @@ module github.com/oliviaBahr/ez-env
 
 go 1.23.4
 
-require github.com/stretchr/testify v1.10.0
+require (
+	filippo.io/age v1.2.1
+	filippo.io/edwards25519 v1.1.0
+	github.com/google/go-github/v66 v66.0.0
+	github.com/stretchr/testify v1.10.0
+	golang.org/x/crypto v0.40.0
+	golang.org/x/oauth2 v0.30.0
+	golang.org/x/term v0.33.0
+	gopkg.in/yaml.v3 v3.0.1
+	rsc.io/qr v0.2.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/google/go-querystring v1.1.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	golang.org/x/sys v0.34.0 // indirect
 )
@@ -0,0 +1,259 @@
+package hosting
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/bitbucket"
+	"github.com/oliviaBahr/ez-env/github"
+)
+
+// Hosts a repository can live on
+const (
+	HostGitHub    = "github"
+	HostBitbucket = "bitbucket"
+)
+
+// VariableScope is where the key is stored on Bitbucket, repository or workspace variables
+// It is the repository scope until the repository settings are applied at startup
+var VariableScope = bitbucket.ScopeRepository
+
+// bitbucketAccounts maps the nicknames of workspace members to their account UUIDs
+var bitbucketAccounts sync.Map
+
+// ErrKeyNotReadable is returned when the host cannot hand the shared key back to developers
+var ErrKeyNotReadable = errors.New("secured Bitbucket variables cannot be read back")
+
+// Current returns the host of the configured git remote; anything but Bitbucket is GitHub
+func Current() string {
+	if remoteURL, err := github.RemoteURL(); err == nil && bitbucket.IsRemoteURL(remoteURL) {
+		return HostBitbucket
+	}
+	return HostGitHub
+}
+
+// Name returns the display name of the current host
+func Name() string {
+	if Current() == HostBitbucket {
+		return "Bitbucket"
+	}
+	return "GitHub"
+}
+
+// SecretStore describes where the shared key is stored, for messages
+func SecretStore() string {
+	if Current() == HostBitbucket {
+		return "Bitbucket " + VariableScope + " variables"
+	}
+	return "GitHub " + github.SecretScope + " secrets"
+}
+
+// bitbucketRepo returns a Bitbucket client with the workspace and repository of the remote
+func bitbucketRepo() (*bitbucket.Client, string, string, error) {
+	remoteURL, err := github.RemoteURL()
+	if err != nil {
+		return nil, "", "", err
+	}
+	workspace, repo, err := bitbucket.ParseRemoteURL(remoteURL)
+	if err != nil {
+		return nil, "", "", err
+	}
+	client, err := bitbucket.NewClient()
+	if err != nil {
+		return nil, "", "", err
+	}
+	return client, workspace, repo, nil
+}
+
+// GetRepositoryInfo returns the owner (or workspace) and name of the repository
+func GetRepositoryInfo() (string, string, error) {
+	if Current() == HostBitbucket {
+		remoteURL, err := github.RemoteURL()
+		if err != nil {
+			return "", "", err
+		}
+		return bitbucket.ParseRemoteURL(remoteURL)
+	}
+	return github.GetRepositoryInfo()
+}
+
+// GetCurrentUser returns the login of the authenticated user
+func GetCurrentUser(ctx context.Context) (string, error) {
+	if Current() == HostBitbucket {
+		client, err := bitbucket.NewClient()
+		if err != nil {
+			return "", err
+		}
+		user, err := client.CurrentUser(ctx)
+		if err != nil {
+			return "", err
+		}
+		return user.Nickname, nil
+	}
+	return github.GetCurrentUser(ctx)
+}
+
+// GetRepositoryPermission returns the role of login: their repository role on GitHub, or their
+// workspace permission on Bitbucket
+func GetRepositoryPermission(ctx context.Context, login string) (string, error) {
+	if Current() != HostBitbucket {
+		return github.GetRepositoryPermission(ctx, login)
+	}
+	collaborators, err := ListCollaboratorPermissions(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, collaborator := range collaborators {
+		if collaborator.Login == login {
+			return "workspace " + collaborator.Permission, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not a member of the workspace", login)
+}
+
+// CheckAuthentication verifies that credentials for the host are available and accepted
+func CheckAuthentication(ctx context.Context) error {
+	if Current() == HostBitbucket {
+		if _, err := GetCurrentUser(ctx); err != nil {
+			return fmt.Errorf("not authenticated with Bitbucket: %w", err)
+		}
+		return nil
+	}
+	return github.CheckAuthentication(ctx)
+}
+
+// GetEncryptionKey retrieves the shared key
+// Bitbucket never returns the value of a secured variable, so there the key can only be created
+func GetEncryptionKey(ctx context.Context) ([]byte, error) {
+	if Current() == HostBitbucket {
+		exists, err := SecretExists(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("%w: %s", github.ErrSecretMissing, github.SecretName)
+		}
+		return nil, fmt.Errorf("%w: import the key with 'git ez-env import-key', or switch to keyring mode", ErrKeyNotReadable)
+	}
+	return github.GetEncryptionKey(ctx)
+}
+
+// StoreEncryptionKey stores the key as a GitHub repository secret or a secured Bitbucket variable
+func StoreEncryptionKey(ctx context.Context, key []byte) error {
+	if Current() == HostBitbucket {
+		client, workspace, repo, err := bitbucketRepo()
+		if err != nil {
+			return err
+		}
+		if err := client.SetVariable(ctx, VariableScope, workspace, repo, github.SecretName, base64.StdEncoding.EncodeToString(key)); err != nil {
+			return fmt.Errorf("failed to store encryption key: %w", err)
+		}
+		return nil
+	}
+	return github.StoreEncryptionKey(ctx, key)
+}
+
+// SecretExists reports whether the key secret or variable is set
+func SecretExists(ctx context.Context) (bool, error) {
+	if Current() == HostBitbucket {
+		client, workspace, repo, err := bitbucketRepo()
+		if err != nil {
+			return false, err
+		}
+		_, err = client.FindVariable(ctx, VariableScope, workspace, repo, github.SecretName)
+		if errors.Is(err, bitbucket.ErrNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return github.SecretExists(ctx)
+}
+
+// DeleteEncryptionKey deletes the key secret or variable
+func DeleteEncryptionKey(ctx context.Context) error {
+	if Current() == HostBitbucket {
+		client, workspace, repo, err := bitbucketRepo()
+		if err != nil {
+			return err
+		}
+		return client.DeleteVariable(ctx, VariableScope, workspace, repo, github.SecretName)
+	}
+	return github.DeleteEncryptionKey(ctx)
+}
+
+// GetSecretUpdatedAt returns when the key secret was last set
+// Bitbucket does not report when a variable changed
+func GetSecretUpdatedAt(ctx context.Context) (time.Time, error) {
+	if Current() == HostBitbucket {
+		return time.Time{}, fmt.Errorf("Bitbucket does not report when a variable was set")
+	}
+	return github.GetSecretUpdatedAt(ctx)
+}
+
+// ListCollaboratorPermissions returns everyone with access and their role
+// Bitbucket roles are the workspace permissions owner, collaborator and member, which do not say
+// who can write to the repository, so every member counts as able to push
+func ListCollaboratorPermissions(ctx context.Context) ([]github.Collaborator, error) {
+	if Current() != HostBitbucket {
+		return github.ListCollaboratorPermissions(ctx)
+	}
+	client, workspace, _, err := bitbucketRepo()
+	if err != nil {
+		return nil, err
+	}
+	members, err := client.WorkspaceMembers(ctx, workspace)
+	if err != nil {
+		return nil, err
+	}
+	collaborators := make([]github.Collaborator, len(members))
+	for i, member := range members {
+		collaborators[i] = github.Collaborator{Login: member.User.Nickname, Permission: member.Permission, Push: true}
+		bitbucketAccounts.Store(member.User.Nickname, member.User.UUID)
+	}
+	return collaborators, nil
+}
+
+// GetUserSSHKeys returns the public SSH keys of login in authorized_keys format
+// Bitbucket looks keys up by account, so the login is resolved among the workspace members
+func GetUserSSHKeys(ctx context.Context, login string) ([]string, error) {
+	if Current() != HostBitbucket {
+		return github.GetUserSSHKeys(ctx, login)
+	}
+	if _, known := bitbucketAccounts.Load(login); !known {
+		if _, err := ListCollaboratorPermissions(ctx); err != nil {
+			return nil, err
+		}
+	}
+	account := login
+	if uuid, ok := bitbucketAccounts.Load(login); ok {
+		account = uuid.(string)
+	}
+
+	client, err := bitbucket.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := client.UserSSHKeys(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+	var publicKeys []string
+	for _, key := range keys {
+		if key.Key != "" {
+			publicKeys = append(publicKeys, key.Key)
+		}
+	}
+	return publicKeys, nil
+}
+
+// GetUserGPGKeys returns the OpenPGP public keys of login; only GitHub publishes them
+func GetUserGPGKeys(ctx context.Context, login string) ([]string, error) {
+	if Current() == HostBitbucket {
+		return nil, fmt.Errorf("Bitbucket does not publish GPG keys; use an SSH or age keyring")
+	}
+	return github.GetUserGPGKeys(ctx, login)
+}
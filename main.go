@@ -1,33 +1,90 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/oliviaBahr/ez-env/cmd"
 )
 
+// commandList is printed in the usage text and when an unknown command is given
+const commandList = `  init         Initialize ezenv in the current repository (--mode shared-key|keyring|passphrase, --backend ssh|age|gpg, --no-keychain, --org-secret, --environment <name>, --codespaces, --update-workflow, --require-approval, --inherit-parent)
+  add         Add a file to be encrypted and encrypt tracked matches (--stdin to read content from stdin, -i to pick files, --environment <name>, --jobs N)
+  remove      Remove a file from encryption
+  resolve     Resolve a merge conflict in an encrypted dotenv file
+  convert     Convert between shared-key and keyring modes (--to keyring|shared-key, --backend ssh|age|gpg)
+  rotate-key  Generate a new encryption key and re-encrypt all files
+  export-key  Export the encryption key to a passphrase-protected file (--age for an age identity)
+  import-key  Import an exported encryption key on this machine
+  backup      Print a paper backup of the key as words and a QR code (--paper, --png <file>)
+  restore     Restore the key from a paper backup read from stdin (--paper)
+  health      Report encryption coverage and last key rotation (--json, --badge)
+  doctor      Diagnose setup problems and suggest fixes
+  peek        Print decrypted lines of a file for editor plugins (--line-range, --stdio)
+  show        Decrypt a file at any revision, e.g. HEAD~3:config/.env (-o file)
+  canary      Plant and check decoy secrets (add, check, install-workflow)
+  delegate    Issue or open a time-limited capability to decrypt selected files (the expiry is advisory once issued)
+  unlock      Check out files locked for lack of a key; name sidecar entries or pass --external to install files from the sidecar store (--force, --yes, --jobs N)
+  migrate     Migrate files from git-secret or blackbox (migrate git-secret|blackbox)
+  import-sops Decrypt a SOPS document and track it under ez-env
+  export-sops Re-encrypt a tracked file as a SOPS document (--age <recipient>)
+  grant       Add a collaborator's GitHub SSH keys, or an age recipient (--recipient), to the keyring (--refresh)
+  revoke      Remove a collaborator and rotate the key (--rotate always|ask|never)
+  sync-keys   Add and remove keyring entries to match the GitHub collaborators (--keep <logins>, --install-workflow, --refresh)
+  keygen      Generate an ez-env keypair in ~/.config/ezenv/keys (or use a hardware token) and add it to the keyring
+  forget      Remove the keys cached for this repository (cacheTTL cache, macOS Keychain, session agent)
+  whoami      Show the GitHub identity, permission and keyring entry used to decrypt
+  restore-access
+              Restore a removed collaborator within the grace period
+  run         Run a command with decrypted dotenv variables set (run -- <command>)
+  env         Print decrypted dotenv variables (--format dotenv|json|yaml|shell, --export)
+  template    Render a Go template with decrypted variables (template <file> -o <output>)
+  edit        Edit a managed file in $EDITOR through a private decrypted copy
+  copy        Copy one decrypted variable to the clipboard (copy <file> <name>)
+  install-hooks
+              Install pre-commit and pre-push hooks that block committing or pushing managed files in plaintext
+              (--refresh adds post-merge/post-checkout hooks that refresh decrypted files)
+  ci          Set up decryption in CI (setup --provider github-actions|gitlab|circleci|bitbucket-pipelines, unlock, key)
+  check-staged
+              Check that every staged managed file is encrypted, e.g. in a clone without the filter
+  status      List encrypted patterns and files (--history for pattern changes)
+  verify      Check that every encrypted file decrypts with the current key (--jobs N)
+  scan-history
+              Report revisions of managed files committed in plaintext (--json)
+  re-encrypt  Re-encrypt every managed file with the current key and settings (--jobs N)
+  deinit      Decrypt all files and remove ez-env from the repository (--delete-secret)
+  rewrite     Encrypt (or --purge) every historical revision of a path
+  audit       List who can obtain the key and how (--json for compliance reports)
+  audit-log   Show who retrieved the key through the workflow and when (--user <login>, --since <duration>, --json)
+  read-output Decrypt a report written by ez-env (reports are encrypted unless --plaintext)
+  config      Read and write the settings in .ezenv.yml (get, set, unset, list, sync)
+  version     Print the version and supported encrypted formats (--json)
+  recover     Re-upload a deleted GitHub secret from a local key (--from <exported-key>)`
+
 func main() {
 	if len(os.Args) < 2 {
 		fmt.Println("Usage: git ez-env <command>")
 		fmt.Println("\nCommands:")
-		fmt.Println("  init         Initialize ezenv in the current repository")
-		fmt.Println("  add         Add a file to be encrypted")
-		fmt.Println("  remove      Remove a file from encryption")
+		fmt.Println(commandList)
 		fmt.Println("\nKey Management:")
 		fmt.Println("  - Uses GitHub Actions workflows for secure key distribution")
 		fmt.Println("  - Keys stored in GitHub repository secrets")
 		fmt.Println("  - Automatic access control via GitHub permissions")
 		fmt.Println("\nPrerequisites:")
-		fmt.Println("  - GitHub CLI (gh) installed and authenticated")
+		fmt.Println("  - GITHUB_TOKEN set, or the GitHub CLI (gh) logged in")
+		fmt.Println("  - On Bitbucket: BITBUCKET_TOKEN, or BITBUCKET_USERNAME and BITBUCKET_APP_PASSWORD")
 		fmt.Println("  - Repository with GitHub Actions enabled")
 		fmt.Println("  - Collaborator access to the repository")
+		fmt.Println("  - An ed25519 or RSA SSH key on your GitHub account; the workflow encrypts the key to it")
 		os.Exit(1)
 	}
 
 	command := os.Args[1]
 	args := os.Args[2:]
 
+	cmd.ApplySettings()
+
 	var err error
 	switch command {
 	case "init":
@@ -36,15 +93,140 @@ func main() {
 		err = cmd.AddFile(args)
 	case "remove":
 		err = cmd.RemoveFile(args)
+	case "clean":
+		// Git filter: encrypts stdin to stdout, so nothing else may be written to stdout
+		err = cmd.Clean(args)
+	case "smudge":
+		// Git filter: decrypts stdin to stdout, so nothing else may be written to stdout
+		err = cmd.Smudge(args)
+	case "filter-process":
+		// Git long-running filter: speaks the pkt-line protocol on stdin and stdout
+		err = cmd.FilterProcess(args)
+	case "merge":
+		// Git merge driver: merges decrypted versions and re-encrypts the result
+		err = cmd.Merge(args)
+	case "diff":
+		// Git textconv driver: decrypts the file given as argument to stdout
+		err = cmd.Diff(args)
+	case "resolve":
+		err = cmd.Resolve(args)
+	case "convert":
+		err = cmd.Convert(args)
+	case "rotate-key":
+		err = cmd.RotateKey(args)
+	case "export-key":
+		err = cmd.ExportKey(args)
+	case "import-key":
+		err = cmd.ImportKey(args)
+	case "backup":
+		err = cmd.Backup(args)
+	case "restore":
+		err = cmd.Restore(args)
+	case "health":
+		err = cmd.Health(args)
+	case "doctor":
+		err = cmd.Doctor(args)
+	case "peek":
+		err = cmd.Peek(args)
+	case "show":
+		err = cmd.Show(args)
+	case "canary":
+		err = cmd.Canary(args)
+	case "delegate":
+		err = cmd.Delegate(args)
+	case "unlock":
+		err = cmd.Unlock(args)
+	case "migrate":
+		err = cmd.Migrate(args)
+	case "import-sops":
+		err = cmd.ImportSops(args)
+	case "export-sops":
+		err = cmd.ExportSops(args)
+	case "keygen":
+		err = cmd.Keygen(args)
+	case "forget":
+		err = cmd.Forget(args)
+	case "session-agent":
+		// Started in the background by the filters to share the key between them
+		err = cmd.SessionAgent(args)
+	case "whoami":
+		err = cmd.Whoami(args)
+	case "grant":
+		err = cmd.Grant(args)
+	case "revoke":
+		err = cmd.Revoke(args)
+	case "sync-keys":
+		err = cmd.SyncKeys(args)
+	case "restore-access":
+		err = cmd.RestoreAccess(args)
+	case "env":
+		err = cmd.Env(args)
+	case "template":
+		err = cmd.Template(args)
+	case "edit":
+		err = cmd.Edit(args)
+	case "copy":
+		err = cmd.Copy(args)
+	case "clear-clipboard":
+		// Started in the background by copy to clear the clipboard after the timeout
+		err = cmd.ClearClipboard(args)
+	case "install-hooks":
+		err = cmd.InstallHooks(args)
+	case "pre-commit-check":
+		// Run by the pre-commit hook
+		err = cmd.PreCommitCheck(args)
+	case "pre-push-check":
+		// Run by the pre-push hook
+		err = cmd.PrePushCheck(args)
+	case "check-staged":
+		err = cmd.CheckStaged(args)
+	case "post-merge-refresh":
+		// Run by the post-merge hook
+		err = cmd.RefreshHook("post-merge", args)
+	case "post-checkout-refresh":
+		// Run by the post-checkout hook
+		err = cmd.RefreshHook("post-checkout", args)
+	case "ci":
+		err = cmd.CI(args)
+	case "run":
+		err = cmd.Run(args)
+	case "status":
+		err = cmd.Status(args)
+	case "verify":
+		err = cmd.Verify(args)
+	case "scan-history":
+		err = cmd.ScanHistory(args)
+	case "re-encrypt":
+		err = cmd.ReEncrypt(args)
+	case "deinit":
+		err = cmd.Deinit(args)
+	case "rewrite":
+		err = cmd.Rewrite(args)
+	case "audit":
+		err = cmd.Audit(args)
+	case "audit-log":
+		err = cmd.AuditLog(args)
+	case "read-output":
+		err = cmd.ReadOutput(args)
+	case "config":
+		err = cmd.Config(args)
+	case "version", "--version":
+		err = cmd.Version(args)
+	case "recover":
+		err = cmd.Recover(args)
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
 		fmt.Println("\nAvailable commands:")
-		fmt.Println("  init         Initialize ezenv in the current repository")
-		fmt.Println("  add         Add a file to be encrypted")
-		fmt.Println("  remove      Remove a file from encryption")
+		fmt.Println(commandList)
 		os.Exit(1)
 	}
 
+	// Commands that run a child process exit with its status
+	var exitErr *cmd.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.Code)
+	}
+
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -0,0 +1,81 @@
+package patternlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/oliviaBahr/ez-env/canonical"
+)
+
+const (
+	// File is the committed, append-only log of changes to the encrypted patterns
+	File = ".ezenv/patterns.jsonl"
+
+	// ActionAdd and ActionRemove are the recorded pattern changes
+	ActionAdd    = "add"
+	ActionRemove = "remove"
+)
+
+// Event is a single change to the set of patterns that are encrypted
+type Event struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Pattern  string    `json:"pattern"`
+	Actor    string    `json:"actor,omitempty"`
+	KeyGroup string    `json:"key_group,omitempty"`
+}
+
+// Append adds an event to the log at path, creating it if needed
+// Events are stored one per line so concurrent additions on different branches merge cleanly
+func Append(path string, event Event) error {
+	event.Time = event.Time.UTC().Truncate(time.Second)
+	line, err := canonical.MarshalLine(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode pattern event: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open pattern log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(line); err != nil {
+		return fmt.Errorf("failed to write pattern log: %w", err)
+	}
+	return nil
+}
+
+// Load reads all events from the log at path, returning nothing if it does not exist
+func Load(path string) ([]Event, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pattern log: %w", err)
+	}
+
+	var events []Event
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			return nil, fmt.Errorf("failed to parse pattern log line %d: %w", lineNumber, err)
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
@@ -0,0 +1,57 @@
+package patternlog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".ezenv", "patterns.jsonl")
+
+	events, err := Load(path)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	added := Event{
+		Time:     time.Date(2024, 3, 1, 9, 30, 0, 500, time.FixedZone("CET", 3600)),
+		Action:   ActionAdd,
+		Pattern:  "config/.env",
+		Actor:    "Alice <alice@example.com>",
+		KeyGroup: "shared-key",
+	}
+	removed := Event{
+		Time:    time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC),
+		Action:  ActionRemove,
+		Pattern: "config/.env",
+	}
+	require.NoError(t, Append(path, added))
+	require.NoError(t, Append(path, removed))
+
+	events, err = Load(path)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC), events[0].Time)
+	assert.Equal(t, "Alice <alice@example.com>", events[0].Actor)
+	assert.Equal(t, ActionRemove, events[1].Action)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t,
+		`{"action":"add","actor":"Alice <alice@example.com>","key_group":"shared-key","pattern":"config/.env","time":"2024-03-01T08:30:00Z"}`+"\n"+
+			`{"action":"remove","pattern":"config/.env","time":"2024-04-01T10:00:00Z"}`+"\n",
+		string(data))
+}
+
+func TestLoadRejectsCorruptLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "patterns.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"action\":\"add\"}\nnot json\n"), 0644))
+
+	_, err := Load(path)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+}
@@ -0,0 +1,192 @@
+// Package pktline reads and writes git's pkt-line framing, which long-running filter processes
+// use to talk to git
+package pktline
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MaxPayload is the largest payload of one packet; longer content is split across packets
+const MaxPayload = 65516
+
+// ErrFlush is returned by ReadPacket for a flush packet, which ends a list or a content stream
+var ErrFlush = errors.New("flush packet")
+
+// Reader reads packets
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader returns a reader of the packets in r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// ReadPacket returns the payload of the next packet, or ErrFlush for a flush packet
+// io.EOF is returned only when the stream ends between packets
+func (r *Reader) ReadPacket() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated packet header")
+		}
+		return nil, err
+	}
+	length, err := strconv.ParseUint(string(header[:]), 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid packet length %q", header[:])
+	}
+	switch {
+	case length == 0:
+		return nil, ErrFlush
+	case length < 4 || length > MaxPayload+4:
+		return nil, fmt.Errorf("invalid packet length %d", length)
+	}
+	payload := make([]byte, length-4)
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		return nil, fmt.Errorf("truncated packet: %w", err)
+	}
+	return payload, nil
+}
+
+// ReadList returns the text packets up to the next flush packet, without their trailing newlines
+func (r *Reader) ReadList() ([]string, error) {
+	var list []string
+	for {
+		payload, err := r.ReadPacket()
+		if errors.Is(err, ErrFlush) {
+			return list, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, strings.TrimSuffix(string(payload), "\n"))
+	}
+}
+
+// ReadContent returns the payloads up to the next flush packet joined together
+func (r *Reader) ReadContent() ([]byte, error) {
+	content, err := io.ReadAll(r.ContentReader())
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// ContentReader returns a reader of the payloads up to the next flush packet, so content can be
+// processed as it arrives; it returns io.EOF once it read the flush packet
+func (r *Reader) ContentReader() io.Reader {
+	return &contentReader{r: r}
+}
+
+// contentReader reads content one packet at a time
+type contentReader struct {
+	r       *Reader
+	payload []byte
+	done    bool
+}
+
+func (c *contentReader) Read(p []byte) (int, error) {
+	for len(c.payload) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		payload, err := c.r.ReadPacket()
+		if errors.Is(err, ErrFlush) {
+			c.done = true
+			return 0, io.EOF
+		}
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		c.payload = payload
+	}
+	n := copy(p, c.payload)
+	c.payload = c.payload[n:]
+	return n, nil
+}
+
+// Writer writes packets; Flush must be called for them to reach the underlying writer
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter returns a writer of packets to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// WritePacket writes one packet holding payload, which must not be empty or exceed MaxPayload
+func (w *Writer) WritePacket(payload []byte) error {
+	if len(payload) == 0 || len(payload) > MaxPayload {
+		return fmt.Errorf("invalid packet payload size %d", len(payload))
+	}
+	if _, err := fmt.Fprintf(w.w, "%04x", len(payload)+4); err != nil {
+		return err
+	}
+	_, err := w.w.Write(payload)
+	return err
+}
+
+// WriteList writes each line as a text packet and ends the list with a flush packet
+func (w *Writer) WriteList(lines ...string) error {
+	for _, line := range lines {
+		if err := w.WritePacket([]byte(line + "\n")); err != nil {
+			return err
+		}
+	}
+	return w.WriteFlush()
+}
+
+// WriteContent writes content split into packets and ends it with a flush packet
+func (w *Writer) WriteContent(content []byte) error {
+	cw := w.ContentWriter()
+	if _, err := cw.Write(content); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+// ContentWriter returns a writer that splits what is written to it into packets, so content can
+// be sent as it is produced; Close ends the content with a flush packet
+func (w *Writer) ContentWriter() io.WriteCloser {
+	return &contentWriter{w: w}
+}
+
+// contentWriter writes content as packets of at most MaxPayload bytes
+type contentWriter struct {
+	w *Writer
+}
+
+func (c *contentWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), MaxPayload)
+		if err := c.w.WritePacket(p[:n]); err != nil {
+			return written, err
+		}
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (c *contentWriter) Close() error {
+	return c.w.WriteFlush()
+}
+
+// WriteFlush writes a flush packet and sends everything written so far
+func (w *Writer) WriteFlush() error {
+	if _, err := w.w.WriteString("0000"); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
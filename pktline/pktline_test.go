@@ -0,0 +1,104 @@
+package pktline
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteList(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteList("git-filter-server", "version=2"))
+	assert.Equal(t, "0016git-filter-server\n000eversion=2\n0000", buf.String())
+
+	list, err := NewReader(&buf).ReadList()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"git-filter-server", "version=2"}, list)
+}
+
+func TestContentRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		packets int
+	}{
+		{name: "empty", content: nil, packets: 0},
+		{name: "one packet", content: []byte("A=1\n"), packets: 1},
+		{name: "exactly one full packet", content: bytes.Repeat([]byte{'x'}, MaxPayload), packets: 1},
+		{name: "split across packets", content: bytes.Repeat([]byte{'y'}, 2*MaxPayload+1), packets: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, NewWriter(&buf).WriteContent(tt.content))
+			assert.Equal(t, len(tt.content)+4*tt.packets+4, buf.Len())
+
+			r := NewReader(&buf)
+			content, err := r.ReadContent()
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.content), len(content))
+			assert.True(t, bytes.Equal(tt.content, content))
+			_, err = r.ReadPacket()
+			assert.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestReadPacketErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{name: "invalid length", input: "zzzzdata", err: "invalid packet length"},
+		{name: "length below header size", input: "0002", err: "invalid packet length 2"},
+		{name: "truncated header", input: "00", err: "truncated packet header"},
+		{name: "truncated payload", input: "0010abc", err: "truncated packet"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReader(strings.NewReader(tt.input)).ReadPacket()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestReadContentUnterminated(t *testing.T) {
+	_, err := NewReader(strings.NewReader("0008abcd")).ReadContent()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestWritePacketRejectsInvalidSizes(t *testing.T) {
+	w := NewWriter(io.Discard)
+	assert.Error(t, w.WritePacket(nil))
+	assert.Error(t, w.WritePacket(make([]byte, MaxPayload+1)))
+}
+
+func TestContentStreaming(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	cw := w.ContentWriter()
+	for range 3 {
+		_, err := cw.Write(bytes.Repeat([]byte{'z'}, MaxPayload/2+1))
+		require.NoError(t, err)
+	}
+	require.NoError(t, cw.Close())
+	require.NoError(t, w.WriteList("status=success"))
+
+	r := NewReader(&buf)
+	content, err := io.ReadAll(r.ContentReader())
+	require.NoError(t, err)
+	assert.Len(t, content, 3*(MaxPayload/2+1))
+	// The reader stops at the flush packet and leaves what follows it
+	list, err := r.ReadList()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"status=success"}, list)
+}
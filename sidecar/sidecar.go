@@ -0,0 +1,139 @@
+package sidecar
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/oliviaBahr/ez-env/canonical"
+)
+
+const (
+	// Dir is the repository directory holding the ciphertext of files that live outside the repository
+	Dir = ".ezenv/sidecar"
+	// MapFile is the committed file mapping sidecar entries to their install paths
+	MapFile = ".ezenv/sidecar.json"
+
+	// Extension is appended to the name of every stored ciphertext file
+	Extension = ".enc"
+)
+
+// validName restricts entry names so they are safe to use as file names
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Entry maps a stored ciphertext to the path where its plaintext is installed
+// InstallPath uses a ~/ prefix for paths inside the home directory so it works for every collaborator
+type Entry struct {
+	Name        string `json:"name"`
+	InstallPath string `json:"install_path"`
+}
+
+// Map is the list of files managed outside the repository
+type Map struct {
+	Version int     `json:"version"`
+	Entries []Entry `json:"entries"`
+}
+
+// Load reads the sidecar map from path, returning an empty map if it does not exist
+func Load(path string) (*Map, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Map{Version: 1}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sidecar map: %w", err)
+	}
+
+	var m Map
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse sidecar map: %w", err)
+	}
+	return &m, nil
+}
+
+// Save writes the sidecar map to path as canonical JSON
+func (m *Map) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := canonical.WriteFile(path, m, 0644); err != nil {
+		return fmt.Errorf("failed to save sidecar map: %w", err)
+	}
+	return nil
+}
+
+// Set adds or updates the entry with the given name
+func (m *Map) Set(name, installPath string) (Entry, error) {
+	if !validName.MatchString(name) {
+		return Entry{}, fmt.Errorf("invalid sidecar name: %q", name)
+	}
+
+	entry := Entry{Name: name, InstallPath: installPath}
+	for i := range m.Entries {
+		if m.Entries[i].Name == name {
+			m.Entries[i] = entry
+			return entry, nil
+		}
+	}
+	m.Entries = append(m.Entries, entry)
+	sort.Slice(m.Entries, func(i, j int) bool { return m.Entries[i].Name < m.Entries[j].Name })
+	return entry, nil
+}
+
+// Get returns the entry with the given name
+func (m *Map) Get(name string) (Entry, bool) {
+	for _, entry := range m.Entries {
+		if entry.Name == name {
+			return entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+// StoredPath returns the repository path of the entry's ciphertext
+func (e Entry) StoredPath() string {
+	return filepath.Join(Dir, e.Name+Extension)
+}
+
+// DefaultName derives an entry name from an install path, e.g. ~/.config/app/credentials.json
+// becomes app-credentials.json
+func DefaultName(installPath string) string {
+	dir := filepath.Base(filepath.Dir(installPath))
+	base := filepath.Base(installPath)
+	name := strings.TrimPrefix(dir, ".") + "-" + strings.TrimPrefix(base, ".")
+	if dir == "." || dir == string(filepath.Separator) || dir == "~" || !validName.MatchString(strings.TrimPrefix(dir, ".")) {
+		name = strings.TrimPrefix(base, ".")
+	}
+	return name
+}
+
+// Portable converts an absolute path inside home into the ~/ form stored in the map
+func Portable(path, home string) string {
+	if rel, err := filepath.Rel(home, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "~/" + filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// Resolve returns where an install path is written on this machine: ~/ paths inside home and other
+// paths inside the repository root
+// The map is committed, so absolute paths and paths that leave their root after cleaning are
+// rejected rather than letting a collaborator write anywhere on another machine
+func Resolve(installPath, home, root string) (string, error) {
+	rel, base := installPath, root
+	if strings.HasPrefix(installPath, "~/") {
+		rel, base = installPath[2:], home
+	}
+	rel = filepath.Clean(filepath.FromSlash(rel))
+	if filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" || strings.HasPrefix(installPath, "/") {
+		return "", fmt.Errorf("invalid install path %q: absolute paths are not allowed", installPath)
+	}
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid install path %q: it escapes %s", installPath, base)
+	}
+	return filepath.Join(base, rel), nil
+}
@@ -0,0 +1,127 @@
+package sidecar
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".ezenv", "sidecar.json")
+
+	m, err := Load(path)
+	require.NoError(t, err)
+	assert.Empty(t, m.Entries)
+
+	_, err = m.Set("netrc", "~/.netrc")
+	require.NoError(t, err)
+	_, err = m.Set("app-credentials.json", "~/.config/app/credentials.json")
+	require.NoError(t, err)
+	_, err = m.Set("netrc", "~/.netrc-work")
+	require.NoError(t, err)
+	require.NoError(t, m.Save(path))
+
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, loaded.Entries, 2)
+	assert.Equal(t, "app-credentials.json", loaded.Entries[0].Name)
+
+	entry, ok := loaded.Get("netrc")
+	require.True(t, ok)
+	assert.Equal(t, "~/.netrc-work", entry.InstallPath)
+	assert.Equal(t, filepath.Join(Dir, "netrc.enc"), entry.StoredPath())
+
+	_, ok = loaded.Get("missing")
+	assert.False(t, ok)
+}
+
+func TestSetRejectsInvalidNames(t *testing.T) {
+	m := &Map{Version: 1}
+	for _, name := range []string{"", "../escape", "a/b", ".hidden"} {
+		_, err := m.Set(name, "~/.netrc")
+		assert.Error(t, err, name)
+	}
+}
+
+func TestDefaultName(t *testing.T) {
+	tests := []struct {
+		installPath string
+		expected    string
+	}{
+		{"~/.config/app/credentials.json", "app-credentials.json"},
+		{"~/.netrc", "netrc"},
+		{"/etc/app/token", "app-token"},
+		{"~/.docker/config.json", "docker-config.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.installPath, func(t *testing.T) {
+			assert.Equal(t, tt.expected, DefaultName(tt.installPath))
+		})
+	}
+}
+
+func TestPortableAndResolve(t *testing.T) {
+	home := filepath.FromSlash("/home/alice")
+	root := filepath.FromSlash("/home/alice/src/app")
+
+	tests := []struct {
+		path     string
+		portable string
+	}{
+		{"/home/alice/.netrc", "~/.netrc"},
+		{"/home/alice/.config/app/credentials.json", "~/.config/app/credentials.json"},
+		{"/etc/app/token", "/etc/app/token"},
+		{"/home/alicia/.netrc", "/home/alicia/.netrc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			portable := Portable(filepath.FromSlash(tt.path), home)
+			assert.Equal(t, tt.portable, portable)
+			resolved, err := Resolve(portable, home, root)
+			if strings.HasPrefix(portable, "~/") {
+				require.NoError(t, err)
+				assert.Equal(t, filepath.FromSlash(tt.path), resolved)
+			} else {
+				assert.ErrorContains(t, err, "absolute paths are not allowed")
+			}
+		})
+	}
+}
+
+func TestResolveRejectsEscapes(t *testing.T) {
+	home := filepath.FromSlash("/home/alice")
+	root := filepath.FromSlash("/home/alice/src/app")
+
+	tests := []struct {
+		installPath string
+		expected    string
+		wantErr     string
+	}{
+		{"~/.config/../.netrc", "/home/alice/.netrc", ""},
+		{"config/local.env", "/home/alice/src/app/config/local.env", ""},
+		{"~/../bob/.netrc", "", "escapes"},
+		{"~/..", "", "escapes"},
+		{"~/", "", "escapes"},
+		{"../../.bashrc", "", "escapes"},
+		{"config/../../other/.env", "", "escapes"},
+		{"/etc/passwd", "", "absolute"},
+		{"~//etc/passwd", "", "absolute"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.installPath, func(t *testing.T) {
+			resolved, err := Resolve(tt.installPath, home, root)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, filepath.FromSlash(tt.expected), resolved)
+		})
+	}
+}
@@ -0,0 +1,392 @@
+package ssh
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// PrivateKeyEnv is the environment variable that overrides the private key used for the keyring
+const PrivateKeyEnv = "EZENV_SSH_KEY"
+
+// PassphraseEnv is the environment variable holding the passphrase of an encrypted private key
+const PassphraseEnv = "EZENV_SSH_KEY_PASSPHRASE"
+
+// ParsePublicKey parses a public key in authorized_keys format (e.g. "ssh-rsa AAAA... comment")
+func ParsePublicKey(authorizedKey string) (gossh.PublicKey, error) {
+	pub, _, _, _, err := gossh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH public key: %w", err)
+	}
+	return pub, nil
+}
+
+// RSAPublicKey extracts the RSA public key from an authorized_keys formatted key
+func RSAPublicKey(authorizedKey string) (*rsa.PublicKey, error) {
+	pub, err := ParsePublicKey(authorizedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cryptoPub, ok := pub.(gossh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported SSH key type: %s", pub.Type())
+	}
+	rsaPub, ok := cryptoPub.CryptoPublicKey().(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported SSH key type: %s", pub.Type())
+	}
+	return rsaPub, nil
+}
+
+// Fingerprint returns the SHA256 fingerprint of an authorized_keys formatted key
+func Fingerprint(authorizedKey string) (string, error) {
+	pub, err := ParsePublicKey(authorizedKey)
+	if err != nil {
+		return "", err
+	}
+	return gossh.FingerprintSHA256(pub), nil
+}
+
+// ParseSSHPrivateKey parses a PEM encoded RSA private key in PKCS#1, PKCS#8 or OpenSSH format
+func ParseSSHPrivateKey(pemBytes []byte) (*rsa.PrivateKey, error) {
+	key, err := ParsePrivateKey(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type: %T (expected RSA)", key)
+	}
+	return rsaKey, nil
+}
+
+// ParsePrivateKey parses a PEM encoded RSA or ed25519 private key in PKCS#1, PKCS#8 or OpenSSH
+// format, the default of ssh-keygen. Encrypted keys return a *gossh.PassphraseMissingError; use
+// ParsePrivateKeyWithPassphrase for them
+// It returns *rsa.PrivateKey or ed25519.PrivateKey
+func ParsePrivateKey(pemBytes []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in private key")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY", "PRIVATE KEY", "OPENSSH PRIVATE KEY":
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %s", block.Type)
+	}
+	raw, err := gossh.ParseRawPrivateKey(pemBytes)
+	var missing *gossh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key (%s): %w", block.Type, err)
+	}
+	return supportedPrivateKey(raw)
+}
+
+// ParsePrivateKeyWithPassphrase parses an encrypted private key like ParsePrivateKey
+func ParsePrivateKeyWithPassphrase(pemBytes, passphrase []byte) (crypto.PrivateKey, error) {
+	raw, err := gossh.ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase)
+	if errors.Is(err, x509.IncorrectPasswordError) {
+		return nil, fmt.Errorf("wrong passphrase for the SSH private key")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted private key: %w", err)
+	}
+	return supportedPrivateKey(raw)
+}
+
+// supportedPrivateKey narrows a parsed private key to the RSA and ed25519 keys the keyring can use
+func supportedPrivateKey(raw any) (crypto.PrivateKey, error) {
+	switch key := raw.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ed25519.PrivateKey:
+		return *key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type: %T (use an RSA or ed25519 key)", raw)
+}
+
+// LoadLocalPrivateKey loads the user's RSA or ed25519 private key from LocalPrivateKeyPath
+// Encrypted keys are unlocked with EZENV_SSH_KEY_PASSPHRASE or a passphrase typed on the terminal
+func LoadLocalPrivateKey() (crypto.PrivateKey, error) {
+	path, err := LocalPrivateKeyPath()
+	if err != nil {
+		return nil, err
+	}
+	return LoadPrivateKeyAt(path)
+}
+
+// ErrNoPrivateKey is returned when no local private key matches the requested public keys
+var ErrNoPrivateKey = errors.New("no SSH private key found")
+
+// LoadPrivateKeyFor loads the first local private key whose public key is one of authorizedKeys
+// Public keys are read from the .pub file or the key file itself, so only the matching key is
+// unlocked and the passphrases of other keys are never asked for
+func LoadPrivateKeyFor(authorizedKeys []string) (crypto.PrivateKey, error) {
+	path, err := PrivateKeyPathFor(authorizedKeys)
+	if err != nil {
+		return nil, err
+	}
+	return LoadPrivateKeyAt(path)
+}
+
+// PrivateKeyPathFor returns the first of LocalPrivateKeyPaths whose public key is one of authorizedKeys
+func PrivateKeyPathFor(authorizedKeys []string) (string, error) {
+	wanted := make(map[string]bool)
+	for _, authorizedKey := range authorizedKeys {
+		if pub, err := ParsePublicKey(authorizedKey); err == nil {
+			wanted[string(pub.Marshal())] = true
+		}
+	}
+
+	paths := LocalPrivateKeyPaths()
+	for _, path := range paths {
+		if pub, err := localPublicKey(path); err == nil && wanted[string(pub.Marshal())] {
+			return path, nil
+		}
+	}
+	if len(paths) == 0 {
+		return "", fmt.Errorf("%w (set %s)", ErrNoPrivateKey, PrivateKeyEnv)
+	}
+	return "", fmt.Errorf("%w: none of %s match the requested public keys", ErrNoPrivateKey, strings.Join(paths, ", "))
+}
+
+// LocalPrivateKeyPaths returns the existing private keys ez-env may use, in the order
+// LocalPrivateKeyPath prefers them; EZENV_SSH_KEY alone is returned when it is set
+func LocalPrivateKeyPaths() []string {
+	if path := os.Getenv(PrivateKeyEnv); path != "" {
+		return []string{path}
+	}
+	var candidates []string
+	if dir, err := KeyDir(); err == nil {
+		candidates = append(candidates, filepath.Join(dir, DedicatedEd25519KeyName), filepath.Join(dir, DedicatedKeyName))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".ssh", "id_rsa"), filepath.Join(home, ".ssh", "id_ed25519"))
+	}
+
+	var paths []string
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// LocalAuthorizedKey returns the public key of LocalPrivateKeyPath in authorized_keys format
+// without unlocking the private key
+func LocalAuthorizedKey() (string, error) {
+	path, err := LocalPrivateKeyPath()
+	if err != nil {
+		return "", err
+	}
+	pub, err := localPublicKey(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the public key of %s: %w", path, err)
+	}
+	return strings.TrimSpace(string(gossh.MarshalAuthorizedKey(pub))), nil
+}
+
+// localPublicKey returns the public key of the private key at path without unlocking it
+func localPublicKey(path string) (gossh.PublicKey, error) {
+	if data, err := os.ReadFile(path + ".pub"); err == nil {
+		return ParsePublicKey(string(data))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKey(data)
+	var missing *gossh.PassphraseMissingError
+	if errors.As(err, &missing) && missing.PublicKey != nil {
+		return missing.PublicKey, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	public, err := PublicKeyOf(key)
+	if err != nil {
+		return nil, err
+	}
+	return gossh.NewPublicKey(public)
+}
+
+// LoadPrivateKeyAt loads the private key at path, asking for its passphrase if it is encrypted
+func LoadPrivateKeyAt(path string) (crypto.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH private key: %w", err)
+	}
+
+	key, err := ParsePrivateKey(data)
+	var missing *gossh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		passphrase, passErr := keyPassphrase(path)
+		if passErr != nil {
+			return nil, passErr
+		}
+		key, err = ParsePrivateKeyWithPassphrase(data, passphrase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// keyPassphrase reads the passphrase of an encrypted private key from EZENV_SSH_KEY_PASSPHRASE or
+// the controlling terminal, which is opened directly because git filters receive content on stdin
+func keyPassphrase(path string) ([]byte, error) {
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s is encrypted and no terminal is available to ask for its passphrase (set %s instead)", path, PassphraseEnv)
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "Passphrase for %s: ", path)
+	passphrase, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return passphrase, nil
+}
+
+// PublicKeyOf returns the public half of an RSA or ed25519 private key
+func PublicKeyOf(privateKey crypto.PrivateKey) (crypto.PublicKey, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return &key.PublicKey, nil
+	case ed25519.PrivateKey:
+		return key.Public(), nil
+	}
+	return nil, fmt.Errorf("unsupported private key type: %T", privateKey)
+}
+
+// LoadLocalSSHPrivateKey loads the user's RSA private key
+// The path can be overridden with EZENV_SSH_KEY, otherwise ~/.ssh/id_rsa is used
+func LoadLocalSSHPrivateKey() (*rsa.PrivateKey, error) {
+	key, err := LoadLocalPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type: %T (expected RSA)", key)
+	}
+	return rsaKey, nil
+}
+
+// LocalPrivateKeyPath returns the path of the SSH private key used for the keyring
+// EZENV_SSH_KEY wins, then a key generated with keygen, then ~/.ssh/id_rsa and ~/.ssh/id_ed25519
+func LocalPrivateKeyPath() (string, error) {
+	if path := os.Getenv(PrivateKeyEnv); path != "" {
+		return path, nil
+	}
+
+	if dir, err := KeyDir(); err == nil {
+		for _, name := range []string{DedicatedEd25519KeyName, DedicatedKeyName} {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			}
+		}
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	// id_rsa stays first so existing keyrings keep unlocking with the key they were written for
+	defaultPath := filepath.Join(home, ".ssh", "id_rsa")
+	if _, err := os.Stat(defaultPath); err != nil {
+		path := filepath.Join(home, ".ssh", "id_ed25519")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return defaultPath, nil
+}
+
+// DedicatedKeyName is the file name of the RSA private key generated by keygen inside KeyDir
+const DedicatedKeyName = "id_rsa"
+
+// DedicatedEd25519KeyName is the file name of the ed25519 private key generated by keygen inside KeyDir
+const DedicatedEd25519KeyName = "id_ed25519"
+
+// KeyDir returns the directory holding keypairs dedicated to ez-env ($XDG_CONFIG_HOME/ezenv/keys,
+// by default ~/.config/ezenv/keys), kept apart from the user's personal ~/.ssh keys
+func KeyDir() (string, error) {
+	if config := os.Getenv("XDG_CONFIG_HOME"); config != "" {
+		return filepath.Join(config, "ezenv", "keys"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(home, ".config", "ezenv", "keys"), nil
+}
+
+// GenerateRSAKey generates an RSA keypair and returns the PKCS#1 PEM private key and the
+// authorized_keys formatted public key
+func GenerateRSAKey(bits int) ([]byte, string, error) {
+	if bits < 2048 {
+		return nil, "", fmt.Errorf("RSA keys must have at least 2048 bits, got %d", bits)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate RSA key: %w", err)
+	}
+	publicKey, err := AuthorizedKey(&key.PublicKey)
+	if err != nil {
+		return nil, "", err
+	}
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return privatePEM, publicKey, nil
+}
+
+// GenerateEd25519Key generates an ed25519 keypair and returns the OpenSSH PEM private key and the
+// authorized_keys formatted public key
+func GenerateEd25519Key() ([]byte, string, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate ed25519 key: %w", err)
+	}
+	publicKey, err := AuthorizedKey(public)
+	if err != nil {
+		return nil, "", err
+	}
+	block, err := gossh.MarshalPrivateKey(private, "")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode ed25519 key: %w", err)
+	}
+	return pem.EncodeToMemory(block), publicKey, nil
+}
+
+// AuthorizedKey formats an RSA or ed25519 public key in authorized_keys format
+func AuthorizedKey(key crypto.PublicKey) (string, error) {
+	pub, err := gossh.NewPublicKey(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert public key: %w", err)
+	}
+	return strings.TrimSpace(string(gossh.MarshalAuthorizedKey(pub))), nil
+}
@@ -0,0 +1,274 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestParseSSHPrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	tests := []struct {
+		name      string
+		data      []byte
+		expectErr string
+	}{
+		{
+			name: "parses PKCS#1 PEM key",
+			data: pkcs1,
+		},
+		{
+			name:      "fails without PEM data",
+			data:      []byte("not a key"),
+			expectErr: "no PEM data",
+		},
+		{
+			name:      "fails with unsupported block type",
+			data:      pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{0x01}}),
+			expectErr: "unsupported private key type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParseSSHPrivateKey(tt.data)
+			if tt.expectErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, key.Equal(parsed))
+		})
+	}
+}
+
+func TestParsePrivateKeyFormats(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	pkcs8 := func(key any) []byte {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err)
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+	openssh := func(key any, passphrase string) []byte {
+		var block *pem.Block
+		var err error
+		if passphrase == "" {
+			block, err = gossh.MarshalPrivateKey(key, "")
+		} else {
+			block, err = gossh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+		}
+		require.NoError(t, err)
+		return pem.EncodeToMemory(block)
+	}
+
+	tests := []struct {
+		name       string
+		data       []byte
+		passphrase string
+		want       any
+	}{
+		{name: "OpenSSH RSA", data: openssh(rsaKey, ""), want: rsaKey},
+		{name: "OpenSSH ed25519", data: openssh(edKey, ""), want: edKey},
+		{name: "PKCS#8 RSA", data: pkcs8(rsaKey), want: rsaKey},
+		{name: "PKCS#8 ed25519", data: pkcs8(edKey), want: edKey},
+		{name: "encrypted OpenSSH ed25519", data: openssh(edKey, "hunter2"), passphrase: "hunter2", want: edKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParsePrivateKey(tt.data)
+			if tt.passphrase != "" {
+				var missing *gossh.PassphraseMissingError
+				require.ErrorAs(t, err, &missing)
+				_, err = ParsePrivateKeyWithPassphrase(tt.data, []byte("wrong"))
+				assert.ErrorContains(t, err, "wrong passphrase")
+				parsed, err = ParsePrivateKeyWithPassphrase(tt.data, []byte(tt.passphrase))
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, parsed)
+		})
+	}
+}
+
+func TestLoadEncryptedPrivateKey(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := gossh.MarshalPrivateKeyWithPassphrase(key, "", []byte("hunter2"))
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0600))
+
+	t.Setenv(PrivateKeyEnv, path)
+	t.Setenv(PassphraseEnv, "hunter2")
+	loaded, err := LoadLocalPrivateKey()
+	require.NoError(t, err)
+	assert.Equal(t, key, loaded)
+}
+
+func TestLocalAuthorizedKey(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := gossh.MarshalPrivateKeyWithPassphrase(private, "", []byte("hunter2"))
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0600))
+	want, err := AuthorizedKey(public)
+	require.NoError(t, err)
+
+	t.Setenv(PrivateKeyEnv, path)
+	t.Setenv(PassphraseEnv, "")
+	got, err := LocalAuthorizedKey()
+	require.NoError(t, err, "the public key of an encrypted key is read without its passphrase")
+	assert.Equal(t, want, got)
+
+	t.Setenv(PrivateKeyEnv, filepath.Join(t.TempDir(), "missing"))
+	_, err = LocalAuthorizedKey()
+	assert.Error(t, err)
+}
+
+func TestAuthorizedKeyRoundTrip(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	authorized, err := AuthorizedKey(&key.PublicKey)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(authorized, "ssh-rsa "))
+
+	parsed, err := RSAPublicKey(authorized)
+	require.NoError(t, err)
+	assert.True(t, key.PublicKey.Equal(parsed))
+
+	fingerprint, err := Fingerprint(authorized)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(fingerprint, "SHA256:"))
+}
+
+func TestLoadLocalSSHPrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "id_rsa")
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, os.WriteFile(path, data, 0600))
+
+	t.Setenv(PrivateKeyEnv, path)
+
+	loaded, err := LoadLocalSSHPrivateKey()
+	require.NoError(t, err)
+	assert.True(t, key.Equal(loaded))
+}
+
+func TestGenerateRSAKey(t *testing.T) {
+	privatePEM, publicKey, err := GenerateRSAKey(2048)
+	require.NoError(t, err)
+
+	key, err := ParseSSHPrivateKey(privatePEM)
+	require.NoError(t, err)
+	parsed, err := RSAPublicKey(publicKey)
+	require.NoError(t, err)
+	assert.True(t, key.PublicKey.Equal(parsed))
+
+	_, _, err = GenerateRSAKey(1024)
+	assert.Error(t, err)
+}
+
+func TestGenerateEd25519Key(t *testing.T) {
+	privatePEM, publicKey, err := GenerateEd25519Key()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(publicKey, "ssh-ed25519 "))
+
+	key, err := ParsePrivateKey(privatePEM)
+	require.NoError(t, err)
+	require.IsType(t, ed25519.PrivateKey{}, key)
+
+	public, err := PublicKeyOf(key)
+	require.NoError(t, err)
+	authorized, err := AuthorizedKey(public)
+	require.NoError(t, err)
+	assert.Equal(t, publicKey, authorized)
+
+	path := filepath.Join(t.TempDir(), DedicatedEd25519KeyName)
+	require.NoError(t, os.WriteFile(path, privatePEM, 0600))
+	t.Setenv(PrivateKeyEnv, path)
+	loaded, err := LoadLocalPrivateKey()
+	require.NoError(t, err)
+	assert.Equal(t, key, loaded)
+}
+
+func TestLocalPrivateKeyPathPrefersDedicatedKey(t *testing.T) {
+	config := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", config)
+	t.Setenv(PrivateKeyEnv, "")
+
+	path, err := LocalPrivateKeyPath()
+	require.NoError(t, err)
+	assert.Equal(t, ".ssh", filepath.Base(filepath.Dir(path)))
+
+	dir, err := KeyDir()
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(dir, 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, DedicatedKeyName), []byte("key"), 0600))
+
+	path, err = LocalPrivateKeyPath()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(config, "ezenv", "keys", DedicatedKeyName), path)
+}
+
+func TestLoadPrivateKeyFor(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv(PrivateKeyEnv, "")
+	t.Setenv(PassphraseEnv, "hunter2")
+	require.NoError(t, os.MkdirAll(filepath.Join(home, ".ssh"), 0700))
+
+	// An encrypted RSA key without a .pub file that is not requested
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	block, err := gossh.MarshalPrivateKeyWithPassphrase(rsaKey, "", []byte("other"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".ssh", "id_rsa"), pem.EncodeToMemory(block), 0600))
+
+	// An encrypted ed25519 key with a .pub file that is requested
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err = gossh.MarshalPrivateKeyWithPassphrase(private, "", []byte("hunter2"))
+	require.NoError(t, err)
+	path := filepath.Join(home, ".ssh", "id_ed25519")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0600))
+	authorized, err := AuthorizedKey(public)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path+".pub", []byte(authorized+" me@laptop\n"), 0644))
+
+	assert.Equal(t, []string{filepath.Join(home, ".ssh", "id_rsa"), path}, LocalPrivateKeyPaths())
+
+	loaded, err := LoadPrivateKeyFor([]string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGarbage", authorized})
+	require.NoError(t, err, "the RSA key is skipped without asking for its passphrase")
+	assert.Equal(t, private, loaded)
+
+	otherAuthorized, err := AuthorizedKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	_, err = LoadPrivateKeyFor([]string{otherAuthorized})
+	assert.ErrorContains(t, err, "wrong passphrase", "the encrypted key's public half is found without its passphrase")
+
+	_, err = LoadPrivateKeyFor(nil)
+	assert.ErrorContains(t, err, "match the requested public keys")
+}
@@ -0,0 +1,133 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"fmt"
+	"hash"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// SSH signatures use the SSHSIG format of OpenSSH (PROTOCOL.sshsig), so they can also be checked
+// with 'ssh-keygen -Y verify'. The namespace keeps a signature made for one purpose from being
+// accepted for another.
+const (
+	sshsigMagic   = "SSHSIG"
+	sshsigVersion = 1
+	sshsigPEMType = "SSH SIGNATURE"
+)
+
+// sshsigBlob is the signature blob following the magic preamble
+type sshsigBlob struct {
+	Version   uint32
+	PublicKey []byte
+	Namespace string
+	Reserved  string
+	HashAlg   string
+	Signature []byte
+}
+
+// sshsigSignedData is the data actually signed, following the magic preamble
+type sshsigSignedData struct {
+	Namespace string
+	Reserved  string
+	HashAlg   string
+	Hash      []byte
+}
+
+// sshsigHash returns the hash function of an SSHSIG hash algorithm name
+func sshsigHash(name string) (hash.Hash, error) {
+	switch name {
+	case "sha512":
+		return sha512.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported signature hash algorithm %q", name)
+}
+
+// signedData returns the bytes covered by an SSHSIG signature of message
+func signedData(namespace, hashAlg string, message []byte) ([]byte, error) {
+	h, err := sshsigHash(hashAlg)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(message)
+	data := sshsigSignedData{Namespace: namespace, HashAlg: hashAlg, Hash: h.Sum(nil)}
+	return append([]byte(sshsigMagic), gossh.Marshal(data)...), nil
+}
+
+// Sign signs message with an RSA or ed25519 private key and returns an armored SSH signature
+func Sign(privateKey crypto.PrivateKey, namespace string, message []byte) ([]byte, error) {
+	signer, err := gossh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	data, err := signedData(namespace, "sha512", message)
+	if err != nil {
+		return nil, err
+	}
+
+	var signature *gossh.Signature
+	if algorithmSigner, ok := signer.(gossh.AlgorithmSigner); ok && signer.PublicKey().Type() == gossh.KeyAlgoRSA {
+		// OpenSSH rejects SHA-1 RSA signatures
+		signature, err = algorithmSigner.SignWithAlgorithm(nil, data, gossh.KeyAlgoRSASHA512)
+	} else {
+		signature, err = signer.Sign(nil, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	blob := sshsigBlob{
+		Version:   sshsigVersion,
+		PublicKey: signer.PublicKey().Marshal(),
+		Namespace: namespace,
+		HashAlg:   "sha512",
+		Signature: gossh.Marshal(signature),
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: sshsigPEMType, Bytes: append([]byte(sshsigMagic), gossh.Marshal(blob)...)}), nil
+}
+
+// VerifySignature checks an armored SSH signature of message made for namespace and returns the
+// public key that made it; callers decide whether that key is trusted
+func VerifySignature(armored []byte, namespace string, message []byte) (gossh.PublicKey, error) {
+	block, _ := pem.Decode(armored)
+	if block == nil || block.Type != sshsigPEMType {
+		return nil, fmt.Errorf("not an armored SSH signature")
+	}
+	if !bytes.HasPrefix(block.Bytes, []byte(sshsigMagic)) {
+		return nil, fmt.Errorf("not an SSH signature")
+	}
+	var blob sshsigBlob
+	if err := gossh.Unmarshal(block.Bytes[len(sshsigMagic):], &blob); err != nil {
+		return nil, fmt.Errorf("failed to parse SSH signature: %w", err)
+	}
+	if blob.Version != sshsigVersion {
+		return nil, fmt.Errorf("unsupported SSH signature version %d", blob.Version)
+	}
+	if blob.Namespace != namespace {
+		return nil, fmt.Errorf("signature was made for %q, not %q", blob.Namespace, namespace)
+	}
+
+	publicKey, err := gossh.ParsePublicKey(blob.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	var signature gossh.Signature
+	if err := gossh.Unmarshal(blob.Signature, &signature); err != nil {
+		return nil, fmt.Errorf("failed to parse SSH signature: %w", err)
+	}
+	data, err := signedData(namespace, blob.HashAlg, message)
+	if err != nil {
+		return nil, err
+	}
+	if err := publicKey.Verify(data, &signature); err != nil {
+		return nil, fmt.Errorf("bad signature by %s: %w", gossh.FingerprintSHA256(publicKey), err)
+	}
+	return publicKey, nil
+}
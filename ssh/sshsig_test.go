@@ -0,0 +1,68 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestSignVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		key  any
+	}{
+		{name: "rsa", key: rsaKey},
+		{name: "ed25519", key: edKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := []byte("keyring contents")
+			signature, err := Sign(tt.key, "ez-env-test", message)
+			require.NoError(t, err)
+
+			publicKey, err := VerifySignature(signature, "ez-env-test", message)
+			require.NoError(t, err)
+			signer, err := gossh.NewSignerFromKey(tt.key)
+			require.NoError(t, err)
+			assert.Equal(t, signer.PublicKey().Marshal(), publicKey.Marshal())
+
+			_, err = VerifySignature(signature, "ez-env-test", []byte("tampered"))
+			assert.ErrorContains(t, err, "bad signature")
+
+			_, err = VerifySignature(signature, "other-namespace", message)
+			assert.ErrorContains(t, err, "signature was made for")
+		})
+	}
+}
+
+func TestSignatureVerifiesWithSSHKeygen(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	message := []byte("keyring contents")
+	signature, err := Sign(key, "ez-env-test", message)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "message.sig")
+	require.NoError(t, os.WriteFile(path, signature, 0600))
+	cmd := exec.Command("ssh-keygen", "-Y", "check-novalidate", "-n", "ez-env-test", "-s", path)
+	cmd.Stdin = bytes.NewReader(message)
+	output, err := cmd.CombinedOutput()
+	assert.NoError(t, err, string(output))
+}
@@ -1,31 +1,94 @@
 package workflows
 
 import (
+	"bytes"
 	"embed"
 	"fmt"
 	"os"
 	"path/filepath"
+
+	"github.com/oliviaBahr/ez-env/github"
+)
+
+const (
+	// KeyManagementWorkflow is the embedded key distribution workflow; it is written as github.WorkflowName
+	KeyManagementWorkflow = "ez-env-key-management.yml"
+	// HealthWorkflow is the file name of the scheduled health report workflow
+	HealthWorkflow = "ez-env-health.yml"
+	// CanaryWorkflow is the file name of the canary tripwire workflow
+	CanaryWorkflow = "ez-env-canary.yml"
+	// SyncKeysWorkflow is the file name of the workflow that opens pull requests syncing the keyring
+	SyncKeysWorkflow = "ez-env-sync-keys.yml"
+
+	// DefaultSyncBot is the keyring login of the sync workflow's key
+	DefaultSyncBot = "ez-env-bot"
+	// SyncEnvironment is the GitHub Environment holding the sync workflow's key
+	SyncEnvironment = "ez-env-sync"
 )
 
-//go:embed ez-env-key-management.yml
+//go:embed ez-env-key-management.yml ez-env-health.yml ez-env-canary.yml ez-env-sync-keys.yml
 var workflowFS embed.FS
 
 // WriteWorkflowFile writes the embedded workflow file to the repository
+// The file is named after the configured workflow and reads the configured secret
 func WriteWorkflowFile(repoPath string) error {
-	// Create the .github/workflows directory
-	workflowsDir := filepath.Join(repoPath, ".github", "workflows")
-	if err := os.MkdirAll(workflowsDir, 0755); err != nil {
-		return fmt.Errorf("failed to create workflows directory: %w", err)
+	content, err := workflowFS.ReadFile(KeyManagementWorkflow)
+	if err != nil {
+		return fmt.Errorf("failed to read embedded workflow file: %w", err)
+	}
+	content = bytes.ReplaceAll(content, []byte(github.DefaultSecretName), []byte(github.SecretName))
+	return writeWorkflow(repoPath, github.WorkflowName, content)
+}
+
+// KeyManagementWorkflowPath returns the repository-relative path of the key management workflow
+func KeyManagementWorkflowPath() string {
+	return filepath.Join(".github", "workflows", github.WorkflowName)
+}
+
+// WriteHealthWorkflowFile writes the embedded health report workflow to the repository
+// The workflow installs ez-env at ref, since it hands the binary a token that can read secrets
+func WriteHealthWorkflowFile(repoPath, ref string) error {
+	content, err := workflowFS.ReadFile(HealthWorkflow)
+	if err != nil {
+		return fmt.Errorf("failed to read embedded workflow file: %w", err)
+	}
+	content = bytes.ReplaceAll(content, []byte("ez-env@latest"), []byte("ez-env@"+ref))
+	return writeWorkflow(repoPath, HealthWorkflow, content)
+}
+
+// WriteCanaryWorkflowFile writes the embedded canary check workflow to the repository
+// The workflow runs on every push and pull request, so it installs ez-env at ref rather than latest
+func WriteCanaryWorkflowFile(repoPath, ref string) error {
+	content, err := workflowFS.ReadFile(CanaryWorkflow)
+	if err != nil {
+		return fmt.Errorf("failed to read embedded workflow file: %w", err)
 	}
+	content = bytes.ReplaceAll(content, []byte("ez-env@latest"), []byte("ez-env@"+ref))
+	return writeWorkflow(repoPath, CanaryWorkflow, content)
+}
 
-	// Read the embedded workflow file
-	workflowContent, err := workflowFS.ReadFile("ez-env-key-management.yml")
+// WriteSyncKeysWorkflowFile writes the embedded keyring sync workflow to the repository
+// The workflow keeps the keyring entry of bot, whose key it decrypts with, and installs ez-env at ref
+func WriteSyncKeysWorkflowFile(repoPath, bot, ref string) error {
+	content, err := workflowFS.ReadFile(SyncKeysWorkflow)
 	if err != nil {
 		return fmt.Errorf("failed to read embedded workflow file: %w", err)
 	}
+	content = bytes.ReplaceAll(content, []byte("--keep "+DefaultSyncBot), []byte("--keep "+bot))
+	content = bytes.ReplaceAll(content, []byte("ez-env@latest"), []byte("ez-env@"+ref))
+	return writeWorkflow(repoPath, SyncKeysWorkflow, content)
+}
+
+// writeWorkflow writes a workflow file into .github/workflows
+func writeWorkflow(repoPath, name string, workflowContent []byte) error {
+	// Create the .github/workflows directory
+	workflowsDir := filepath.Join(repoPath, ".github", "workflows")
+	if err := os.MkdirAll(workflowsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create workflows directory: %w", err)
+	}
 
 	// Write the workflow file to the repository
-	workflowPath := filepath.Join(workflowsDir, "ez-env-key-management.yml")
+	workflowPath := filepath.Join(workflowsDir, name)
 	if err := os.WriteFile(workflowPath, workflowContent, 0644); err != nil {
 		return fmt.Errorf("failed to write workflow file: %w", err)
 	}
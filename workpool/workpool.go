@@ -0,0 +1,90 @@
+package workpool
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+)
+
+// DefaultJobs is the number of workers used unless a command is told otherwise
+func DefaultJobs() int {
+	return runtime.GOMAXPROCS(0)
+}
+
+// Pool runs tasks on a bounded number of goroutines and collects the error of every failed task
+// instead of stopping at the first one, so a command can report all files that need attention
+type Pool struct {
+	tasks chan task
+	wg    sync.WaitGroup
+
+	mu     sync.Mutex
+	errs   map[int]error
+	queued int
+}
+
+type task struct {
+	index int
+	run   func() error
+}
+
+// New starts a pool with jobs workers; values below 1 use a single worker
+func New(jobs int) *Pool {
+	p := &Pool{tasks: make(chan task), errs: make(map[int]error)}
+	for range max(1, jobs) {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for t := range p.tasks {
+		if err := t.run(); err != nil {
+			p.mu.Lock()
+			p.errs[t.index] = err
+			p.mu.Unlock()
+		}
+	}
+}
+
+// Go queues a task, blocking while every worker is busy so callers streaming file contents into
+// the pool hold at most one file per worker in memory
+func (p *Pool) Go(run func() error) {
+	p.mu.Lock()
+	index := p.queued
+	p.queued++
+	p.mu.Unlock()
+	p.tasks <- task{index: index, run: run}
+}
+
+// Failed reports whether any task has failed so far
+func (p *Pool) Failed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.errs) > 0
+}
+
+// Wait waits for every queued task and returns their errors joined in the order the tasks were
+// queued, or nil if all succeeded. The pool cannot be used afterwards
+func (p *Pool) Wait() error {
+	close(p.tasks)
+	p.wg.Wait()
+
+	errs := make([]error, 0, len(p.errs))
+	for index := range p.queued {
+		if err, ok := p.errs[index]; ok {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run calls fn for every index below n on up to jobs workers and returns the joined errors
+func Run(n, jobs int, fn func(i int) error) error {
+	p := New(min(jobs, n))
+	for i := range n {
+		p.Go(func() error { return fn(i) })
+	}
+	return p.Wait()
+}
@@ -0,0 +1,91 @@
+package workpool
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oliviaBahr/ez-env/crypto"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs int
+	}{
+		{name: "sequential", jobs: 1},
+		{name: "parallel", jobs: 8},
+		{name: "more workers than tasks", jobs: 100},
+		{name: "invalid job count", jobs: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var done atomic.Int32
+			results := make([]int, 20)
+			err := Run(len(results), tt.jobs, func(i int) error {
+				done.Add(1)
+				results[i] = i * i
+				return nil
+			})
+			require.NoError(t, err)
+			assert.EqualValues(t, 20, done.Load())
+			assert.Equal(t, 19*19, results[19])
+		})
+	}
+}
+
+func TestRunCollectsEveryError(t *testing.T) {
+	var done atomic.Int32
+	err := Run(10, 4, func(i int) error {
+		done.Add(1)
+		if i%3 == 0 {
+			return fmt.Errorf("file %d failed", i)
+		}
+		return nil
+	})
+	// Failures do not stop the remaining tasks, and errors keep the task order
+	assert.EqualValues(t, 10, done.Load())
+	assert.EqualError(t, err, "file 0 failed\nfile 3 failed\nfile 6 failed\nfile 9 failed")
+}
+
+func TestPoolFailed(t *testing.T) {
+	failure := errors.New("failed")
+	pool := New(2)
+	assert.False(t, pool.Failed())
+	pool.Go(func() error { return failure })
+	assert.ErrorIs(t, pool.Wait(), failure)
+	assert.True(t, pool.Failed())
+}
+
+// BenchmarkDecrypt decrypts the files of a repository with 1024 managed files, sequentially and
+// on growing worker pools
+func BenchmarkDecrypt(b *testing.B) {
+	key, err := crypto.GenerateEncryptionKey()
+	require.NoError(b, err)
+	plaintext := make([]byte, 4096)
+	files := make([][]byte, 1024)
+	for i := range files {
+		path := fmt.Sprintf("services/%d/.env", i)
+		if files[i], err = crypto.EncryptFileWithOptions(plaintext, key, crypto.EncryptOptions{Path: path}); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	for _, jobs := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			for range b.N {
+				err := Run(len(files), jobs, func(i int) error {
+					_, err := crypto.DecryptFileAt(files[i], key, fmt.Sprintf("services/%d/.env", i))
+					return err
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}